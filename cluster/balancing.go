@@ -100,6 +100,12 @@ import (
  *     - 优先迁移键数量多的槽
  *     - 避免迁移正在使用的热点槽
  *     - 考虑槽的访问模式
+ *
+ * 【迁移槽的选择】
+ * 计算计划时遍历当前数据库的键，用 HashSlot 统计每个槽的键数量，
+ * 再按 SlotSelection 对源节点的槽排序（键数量相同时按槽号）：
+ * - SELECT_FEWEST_KEYS（默认）：优先迁移键少的槽，迁移的数据量最小
+ * - SELECT_MOST_KEYS：优先迁移键多的槽，尽快降低热点节点的负载
  */
 
 // BalancingStrategy 平衡策略
@@ -111,6 +117,14 @@ const (
 	BALANCE_ADAPTIVE                          // 自适应分配
 )
 
+// SlotSelection 选择迁移槽的策略
+type SlotSelection int
+
+const (
+	SELECT_FEWEST_KEYS SlotSelection = iota // 优先迁移键少的槽
+	SELECT_MOST_KEYS                        // 优先迁移键多的槽
+)
+
 // NodeWeight 节点权重
 type NodeWeight struct {
 	NodeID string
@@ -137,6 +151,7 @@ type MigrationPlan struct {
 type SlotBalancer struct {
 	cluster     *Cluster
 	strategy    BalancingStrategy
+	selection   SlotSelection      // 选择迁移槽的策略
 	nodeWeights map[string]float64 // nodeID -> weight
 	mu          sync.RWMutex
 	threshold   float64 // 不平衡阈值（百分比）
@@ -159,6 +174,13 @@ func (sb *SlotBalancer) SetStrategy(strategy BalancingStrategy) {
 	sb.strategy = strategy
 }
 
+// SetSlotSelection 设置选择迁移槽的策略
+func (sb *SlotBalancer) SetSlotSelection(selection SlotSelection) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	sb.selection = selection
+}
+
 // SetNodeWeight 设置节点权重
 func (sb *SlotBalancer) SetNodeWeight(nodeID string, weight float64) {
 	sb.mu.Lock()
@@ -189,8 +211,9 @@ func (sb *SlotBalancer) CalculateBalancePlan() (*BalancingPlan, error) {
 	// 计算当前分配
 	currentSlots := sb.calculateCurrentSlots(masterNodes)
 
-	// 计算需要迁移的槽
-	migrations := sb.calculateMigrations(currentSlots, targetSlots, masterNodes)
+	// 计算需要迁移的槽（按每个槽的键数量选择）
+	keyCounts := sb.countKeysPerSlot()
+	migrations := sb.calculateMigrations(currentSlots, targetSlots, masterNodes, keyCounts)
 
 	plan.Migrations = migrations
 
@@ -287,7 +310,7 @@ func (sb *SlotBalancer) calculateCurrentSlots(nodes []*ClusterNode) map[string]i
 }
 
 // calculateMigrations 计算需要迁移的槽
-func (sb *SlotBalancer) calculateMigrations(currentSlots, targetSlots map[string]int, nodes []*ClusterNode) []*MigrationPlan {
+func (sb *SlotBalancer) calculateMigrations(currentSlots, targetSlots map[string]int, nodes []*ClusterNode, keyCounts []int) []*MigrationPlan {
 	migrations := make([]*MigrationPlan, 0)
 
 	// 找出需要减少槽的节点（源节点）
@@ -324,6 +347,7 @@ func (sb *SlotBalancer) calculateMigrations(currentSlots, targetSlots map[string
 	})
 
 	// 分配迁移任务
+	selected := make(map[int]bool)
 	sourceIdx := 0
 	targetIdx := 0
 
@@ -342,7 +366,7 @@ func (sb *SlotBalancer) calculateMigrations(currentSlots, targetSlots map[string
 		migrateCount := min(sourceNeed, targetNeed)
 
 		// 选择要迁移的槽
-		slotsToMigrate := sb.selectSlotsToMigrate(source, migrateCount)
+		slotsToMigrate := sb.selectSlotsToMigrate(source, migrateCount, keyCounts, selected)
 
 		// 创建迁移计划
 		for _, slot := range slotsToMigrate {
@@ -371,16 +395,46 @@ func (sb *SlotBalancer) calculateMigrations(currentSlots, targetSlots map[string
 	return migrations
 }
 
-// selectSlotsToMigrate 选择要迁移的槽
-func (sb *SlotBalancer) selectSlotsToMigrate(node *ClusterNode, count int) []int {
-	// 简化实现：随机选择槽
-	// 实际应该考虑槽的负载（键数量）
-	slots := make([]int, 0, count)
+// countKeysPerSlot 统计当前数据库中每个槽的键数量（没有存储层时全部为 0）
+func (sb *SlotBalancer) countKeysPerSlot() []int {
+	counts := make([]int, CLUSTER_SLOTS)
+	if sb.cluster.server == nil {
+		return counts
+	}
+	for _, key := range sb.cluster.server.GetCurrentDb().Keys("*") {
+		counts[HashSlot(key)]++
+	}
+	return counts
+}
 
-	for i := 0; i < count && i < len(node.Slots); i++ {
-		slots = append(slots, node.Slots[i])
+// selectSlotsToMigrate 按 SlotSelection 从节点的槽中选择 count 个要迁移的槽，
+// selected 记录本次计划中已经选过的槽（同一个源节点可能迁往多个目标节点），不会重复选择
+func (sb *SlotBalancer) selectSlotsToMigrate(node *ClusterNode, count int, keyCounts []int, selected map[int]bool) []int {
+	candidates := make([]int, 0, len(node.Slots))
+	for _, slot := range node.Slots {
+		if !selected[slot] {
+			candidates = append(candidates, slot)
+		}
 	}
 
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := keyCounts[candidates[i]], keyCounts[candidates[j]]
+		if a == b {
+			return candidates[i] < candidates[j]
+		}
+		if sb.selection == SELECT_MOST_KEYS {
+			return a > b
+		}
+		return a < b
+	})
+
+	if count > len(candidates) {
+		count = len(candidates)
+	}
+	slots := candidates[:count]
+	for _, slot := range slots {
+		selected[slot] = true
+	}
 	return slots
 }
 
@@ -1,7 +1,13 @@
 package cluster
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"net"
+	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/code-100-precent/LingCache/storage"
@@ -143,18 +149,36 @@ import (
  */
 
 const (
-	CLUSTER_SLOTS = 16384 // Redis 集群槽数
+	CLUSTER_SLOTS     = 16384 // Redis 集群槽数
+	CLUSTER_PORT_INCR = 10000 // 集群总线端口 = 客户端端口 + 10000
 )
 
 // ClusterNode 集群节点
 type ClusterNode struct {
 	NodeID   string
 	Addr     string
-	Slots    []int // 负责的槽
+	BusAddr  string // 集群总线地址，为空时使用 Addr 的端口 + CLUSTER_PORT_INCR
+	Slots    []int  // 负责的槽
 	Master   *ClusterNode
 	Replicas []*ClusterNode
 }
 
+// busAddress 节点的集群总线地址
+func (n *ClusterNode) busAddress() string {
+	if n.BusAddr != "" {
+		return n.BusAddr
+	}
+	host, port, err := net.SplitHostPort(n.Addr)
+	if err != nil {
+		return n.Addr
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return n.Addr
+	}
+	return net.JoinHostPort(host, strconv.Itoa(p+CLUSTER_PORT_INCR))
+}
+
 // Cluster 集群
 type Cluster struct {
 	nodes             map[string]*ClusterNode     // nodeID -> node
@@ -185,6 +209,7 @@ func NewCluster(server *storage.RedisServer, nodeID string, addr string) *Cluste
 		myself: myself,
 		server: server,
 	}
+	cluster.nodes[nodeID] = myself
 
 	// 初始化故障转移管理器和通信器
 	cluster.failoverMgr = NewFailoverManager(cluster)
@@ -212,7 +237,7 @@ func (c *Cluster) AddNode(nodeID string, addr string) {
 	}
 }
 
-// AssignSlots 分配槽给节点
+// AssignSlots 分配槽给节点（槽原来属于其他节点时从该节点移除）
 func (c *Cluster) AssignSlots(nodeID string, slots []int) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -223,9 +248,140 @@ func (c *Cluster) AssignSlots(nodeID string, slots []int) {
 	}
 
 	for _, slot := range slots {
-		if slot >= 0 && slot < CLUSTER_SLOTS {
-			c.slots[slot] = node
-			node.Slots = append(node.Slots, slot)
+		if slot < 0 || slot >= CLUSTER_SLOTS {
+			continue
+		}
+		old := c.slots[slot]
+		if old == node {
+			continue
+		}
+		if old != nil {
+			old.removeSlot(slot)
+		}
+		c.slots[slot] = node
+		node.Slots = append(node.Slots, slot)
+	}
+}
+
+// removeSlot 从节点负责的槽中移除 slot
+func (n *ClusterNode) removeSlot(slot int) {
+	for i, s := range n.Slots {
+		if s == slot {
+			n.Slots = append(n.Slots[:i], n.Slots[i+1:]...)
+			return
+		}
+	}
+}
+
+// GetNode 按节点 ID 获取节点
+func (c *Cluster) GetNode(nodeID string) (*ClusterNode, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	node, exists := c.nodes[nodeID]
+	return node, exists
+}
+
+// GenerateNodeID 生成随机的节点 ID（40 个十六进制字符，与 Redis 相同）
+func GenerateNodeID() string {
+	buf := make([]byte, 20)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// ForgetNode 从本节点的集群视图中移除节点（CLUSTER FORGET），该节点负责的槽变为未分配
+func (c *Cluster) ForgetNode(nodeID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if nodeID == c.myself.NodeID {
+		return errors.New("I tried hard but I can't forget myself...")
+	}
+	node, exists := c.nodes[nodeID]
+	if !exists {
+		return fmt.Errorf("Unknown node %s", nodeID)
+	}
+	if c.myself.Master == node {
+		return errors.New("Can't forget my master!")
+	}
+
+	for _, slot := range node.Slots {
+		if c.slots[slot] == node {
+			c.slots[slot] = nil
+		}
+	}
+	if node.Master != nil {
+		node.Master.removeReplica(node)
+	}
+	for _, replica := range node.Replicas {
+		replica.Master = nil
+	}
+	delete(c.nodes, nodeID)
+	return nil
+}
+
+// ReplicateNode 将本节点设置为指定主节点的从节点（CLUSTER REPLICATE）。
+// 本节点不能负责任何槽，目标必须是主节点
+func (c *Cluster) ReplicateNode(masterID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	master, exists := c.nodes[masterID]
+	if !exists {
+		return fmt.Errorf("Unknown node %s", masterID)
+	}
+	if master == c.myself {
+		return errors.New("Can't replicate myself")
+	}
+	if master.Master != nil {
+		return errors.New("I can only replicate a master, not a replica.")
+	}
+	if len(c.myself.Slots) > 0 {
+		return errors.New("To set a master the node must be empty and without assigned slots.")
+	}
+
+	if c.myself.Master != nil {
+		c.myself.Master.removeReplica(c.myself)
+	}
+	c.myself.Master = master
+	master.Replicas = append(master.Replicas, c.myself)
+	return nil
+}
+
+// Reset 重置本节点的集群状态（CLUSTER RESET）：成为主节点，忘记其他所有节点，
+// 所有槽变为未分配；hard 为 true 时生成新的节点 ID。
+// 包含数据的主节点不能重置
+func (c *Cluster) Reset(hard bool) error {
+	c.mu.Lock()
+	if c.myself.Master == nil && c.server != nil {
+		for i := 0; i < c.server.GetDbNum(); i++ {
+			if db, err := c.server.GetDb(i); err == nil && db.DBSize() > 0 {
+				c.mu.Unlock()
+				return errors.New("CLUSTER RESET can't be called with master nodes containing keys")
+			}
+		}
+	}
+
+	c.slots = [CLUSTER_SLOTS]*ClusterNode{}
+	c.myself.Slots = make([]int, 0)
+	c.myself.Master = nil
+	c.myself.Replicas = nil
+	if hard {
+		c.myself.NodeID = GenerateNodeID()
+	}
+	c.nodes = map[string]*ClusterNode{c.myself.NodeID: c.myself}
+	c.mu.Unlock()
+
+	// 迁移状态在锁外清除（ReshardingManager 会获取集群的锁）
+	c.reshardingMgr.Reset()
+	return nil
+}
+
+// removeReplica 从主节点的从节点列表中移除 replica
+func (n *ClusterNode) removeReplica(replica *ClusterNode) {
+	for i, r := range n.Replicas {
+		if r == replica {
+			n.Replicas = append(n.Replicas[:i], n.Replicas[i+1:]...)
+			return
 		}
 	}
 }
@@ -265,7 +421,7 @@ var crc16Table = [256]uint16{
 	0x02b1, 0x1290, 0x22f3, 0x32d2, 0x4235, 0x5214, 0x6277, 0x7256,
 	0xb5ea, 0xa5cb, 0x95a8, 0x8589, 0xf56e, 0xe54f, 0xd52c, 0xc50d,
 	0x34e2, 0x24c3, 0x14a0, 0x0481, 0x7466, 0x6447, 0x5424, 0x4405,
-	0xa7db, 0xb7fa, 0x8799, 0x97b8, 0xe75f, 0xf77e, 0xc71d, 0xd33c,
+	0xa7db, 0xb7fa, 0x8799, 0x97b8, 0xe75f, 0xf77e, 0xc71d, 0xd73c,
 	0x26d3, 0x36f2, 0x0691, 0x16b0, 0x6657, 0x7676, 0x4615, 0x5634,
 	0xd94c, 0xc96d, 0xf90e, 0xe92f, 0x99c8, 0x89e9, 0xb98a, 0xa9ab,
 	0x5844, 0x4865, 0x7806, 0x6827, 0x18c0, 0x08e1, 0x3882, 0x28a3,
@@ -287,31 +443,35 @@ func crc16(data []byte) uint16 {
 }
 
 // HashSlot 计算键的槽号
+// 与 Redis 一致：键中第一个 '{' 与其后第一个 '}' 之间的内容非空时只对这部分计算（hash tag），
+// 使相关的键落在同一个槽
 func HashSlot(key string) int {
-	// 提取键中的 {} 部分（如果有）
-	start := -1
-	end := -1
-	for i, c := range key {
-		if c == '{' {
-			start = i
-		} else if c == '}' && start != -1 {
-			end = i
-			break
+	hashKey := key
+	if start := strings.IndexByte(key, '{'); start != -1 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			hashKey = key[start+1 : start+1+end]
 		}
 	}
 
-	var hashKey string
-	if start != -1 && end != -1 && end > start+1 {
-		hashKey = key[start+1 : end]
-	} else {
-		hashKey = key
-	}
-
 	// 使用 CRC16 算法
 	hash := crc16([]byte(hashKey))
 	return int(hash) % CLUSTER_SLOTS
 }
 
+// KeysInSlot 返回数据库中属于指定槽的键，最多 count 个（count < 0 表示不限制）
+func KeysInSlot(db *storage.RedisDb, slot int, count int) []string {
+	keys := make([]string, 0)
+	for _, key := range db.Keys("*") {
+		if count >= 0 && len(keys) >= count {
+			break
+		}
+		if HashSlot(key) == slot {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
 // GetNodeForKey 获取键对应的节点
 func (c *Cluster) GetNodeForKey(key string) *ClusterNode {
 	slot := HashSlot(key)
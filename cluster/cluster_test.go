@@ -1,8 +1,12 @@
 package cluster
 
 import (
-	"github.com/code-100-precent/LingCache/storage"
+	"net"
+	"strconv"
 	"testing"
+	"time"
+
+	"github.com/code-100-precent/LingCache/storage"
 )
 
 // TestClusterCreation 测试集群创建
@@ -102,3 +106,199 @@ func TestFailoverManager(t *testing.T) {
 
 	t.Log("Failover manager test passed")
 }
+
+// TestHashSlotValues 测试槽号与 Redis 的 CRC16 结果一致，以及 hash tag 的处理
+func TestHashSlotValues(t *testing.T) {
+	cases := map[string]int{
+		"123456789":            12739, // CRC16/XMODEM 校验值 0x31c3
+		"foo":                  12182,
+		"bar":                  5061,
+		"hello":                866,
+		"{user1000}.following": 3443,
+		"{user1000}.followers": 3443,
+		"foo{{bar}}zap":        HashSlot("{bar"),
+		"foo{bar}{zap}":        HashSlot("bar"),
+	}
+	for key, want := range cases {
+		if got := HashSlot(key); got != want {
+			t.Fatalf("HashSlot(%q): expected %d, got %d", key, want, got)
+		}
+	}
+	if HashSlot("foo{}{bar}") == HashSlot("bar") {
+		t.Fatal("Empty hash tag should hash the whole key")
+	}
+}
+
+// keysForSlot 生成 n 个属于指定槽的键（使用相同的 hash tag）
+func keysForSlot(slot, n int) []string {
+	tag := ""
+	for i := 0; ; i++ {
+		tag = "tag" + strconv.Itoa(i)
+		if HashSlot(tag) == slot {
+			break
+		}
+	}
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = "{" + tag + "}:" + strconv.Itoa(i)
+	}
+	return keys
+}
+
+// TestSelectSlotsByKeyCount 测试平衡计划按每个槽的键数量选择迁移的槽
+func TestSelectSlotsByKeyCount(t *testing.T) {
+	server := storage.NewRedisServer(16)
+	db := server.GetCurrentDb()
+	skew := map[int]int{100: 50, 200: 10, 300: 1}
+	for slot, n := range skew {
+		for _, key := range keysForSlot(slot, n) {
+			db.Set(key, storage.NewStringObject([]byte("v")))
+		}
+	}
+
+	cluster := NewCluster(server, "node1", "127.0.0.1:7000")
+	cluster.AddNode("node2", "127.0.0.1:7001")
+	all := make([]int, CLUSTER_SLOTS)
+	for slot := range all {
+		all[slot] = slot
+	}
+	cluster.AssignSlots("node1", all)
+
+	plan := func(selection SlotSelection) []int {
+		balancer := cluster.GetBalancer()
+		balancer.SetSlotSelection(selection)
+		p, err := balancer.CalculateBalancePlan()
+		if err != nil {
+			t.Fatalf("CalculateBalancePlan failed: %v", err)
+		}
+		if len(p.Migrations) != CLUSTER_SLOTS/2 {
+			t.Fatalf("Expected %d migrations, got %d", CLUSTER_SLOTS/2, len(p.Migrations))
+		}
+		slots := make([]int, len(p.Migrations))
+		for i, m := range p.Migrations {
+			if m.SourceNodeID != "node1" || m.TargetNodeID != "node2" {
+				t.Fatalf("Unexpected migration %+v", m)
+			}
+			slots[i] = m.Slot
+		}
+		return slots
+	}
+
+	// 默认优先迁移键少的槽：有键的槽都留在原节点
+	for _, slot := range plan(SELECT_FEWEST_KEYS) {
+		if skew[slot] > 0 {
+			t.Fatalf("Expected slots with keys to stay, but slot %d (%d keys) was selected", slot, skew[slot])
+		}
+	}
+
+	// 优先迁移键多的槽：按键数量从多到少
+	slots := plan(SELECT_MOST_KEYS)
+	if slots[0] != 100 || slots[1] != 200 || slots[2] != 300 || slots[3] != 0 {
+		t.Fatalf("Expected slots 100, 200, 300 first, got %v", slots[:4])
+	}
+}
+
+// TestMigrateSlotData 测试两个进程内节点之间迁移槽：所有键（含过期时间）移到目标节点，
+// 源节点不再保存也不再负责这些键；目标节点不可达时中断的迁移可以继续
+func TestMigrateSlotData(t *testing.T) {
+	const slot = 1234
+	sourceServer := storage.NewRedisServer(16)
+	targetServer := storage.NewRedisServer(16)
+	source := NewCluster(sourceServer, "nodeA", "127.0.0.1:7000")
+	target := NewCluster(targetServer, "nodeB", "127.0.0.1:7001")
+	source.AddNode("nodeB", "127.0.0.1:7001")
+	target.AddNode("nodeA", "127.0.0.1:7000")
+	source.AssignSlots("nodeA", []int{slot})
+	target.AssignSlots("nodeA", []int{slot})
+
+	sourceDb, _ := sourceServer.GetDb(0)
+	keys := keysForSlot(slot, 20)
+	for _, key := range keys {
+		sourceDb.Set(key, storage.NewStringObject([]byte("value:"+key)))
+	}
+	listObj := storage.NewListObject()
+	list, _ := listObj.GetList()
+	list.Push([]byte("a"), 1)
+	list.Push([]byte("b"), 1)
+	sourceDb.Set(keys[0], listObj)
+	expireAt := time.Now().Unix() + 100
+	sourceDb.ExpireAt(keys[1], expireAt)
+	sourceDb.Set("other", storage.NewStringObject([]byte("stays")))
+
+	// 目标节点的集群总线不可达：迁移失败，键仍在源节点，槽保持迁移状态
+	unused, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodeB, _ := source.GetNode("nodeB")
+	nodeB.BusAddr = unused.Addr().String()
+	unused.Close()
+
+	rm := source.GetReshardingManager()
+	if err := rm.MigrateSlotData(slot, "nodeA", "nodeB", sourceServer); err == nil {
+		t.Fatal("Expected migration to an unreachable node to fail")
+	}
+	if sourceDb.DBSize() != len(keys)+1 || !rm.IsSlotMigrating(slot) {
+		t.Fatalf("Expected keys to stay on the source, dbsize=%d migrating=%v", sourceDb.DBSize(), rm.IsSlotMigrating(slot))
+	}
+
+	// 启动目标节点后继续迁移
+	if err := target.communicator.Start(0); err != nil {
+		t.Fatal(err)
+	}
+	defer target.communicator.Stop()
+	defer source.communicator.Stop()
+	nodeB.BusAddr = target.communicator.ListenAddr().String()
+
+	if err := rm.MigrateSlotData(slot, "nodeA", "nodeB", sourceServer); err != nil {
+		t.Fatalf("MigrateSlotData failed: %v", err)
+	}
+
+	targetDb, _ := targetServer.GetDb(0)
+	for _, key := range keys {
+		if sourceDb.Exists(key) {
+			t.Fatalf("Key %s is still on the source", key)
+		}
+		obj, err := targetDb.Peek(key)
+		if err != nil {
+			t.Fatalf("Key %s missing on the target", key)
+		}
+		if key == keys[0] {
+			l, _ := obj.GetList()
+			items, _ := l.Range(0, -1)
+			if len(items) != 2 || string(items[0]) != "a" || string(items[1]) != "b" {
+				t.Fatalf("Unexpected list on the target: %q", items)
+			}
+			continue
+		}
+		val, _ := obj.GetStringValue()
+		if string(val) != "value:"+key {
+			t.Fatalf("Key %s: unexpected value %q", key, val)
+		}
+	}
+	if got, ok := targetDb.GetExpireAt(keys[1]); !ok || got != expireAt {
+		t.Fatalf("Expected expire time %d on the target, got %d", expireAt, got)
+	}
+	if !sourceDb.Exists("other") || targetDb.Exists("other") {
+		t.Fatal("Keys of other slots must not be migrated")
+	}
+
+	// 源节点不再负责该槽（之后的请求得到 MOVED），目标节点收到 SLOTS 通知后负责该槽
+	if owner := source.GetSlotNode(slot); owner == nil || owner.NodeID != "nodeB" || rm.IsSlotMigrating(slot) {
+		t.Fatalf("Expected the source to map slot %d to nodeB", slot)
+	}
+	migration, _ := rm.GetMigrationStatus(slot)
+	if migration.KeysMigrated != len(keys) || migration.KeysTotal != len(keys) {
+		t.Fatalf("Unexpected progress: %d/%d", migration.KeysMigrated, migration.KeysTotal)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if owner := target.GetSlotNode(slot); owner != nil && owner.NodeID == "nodeB" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Target did not learn it owns the slot")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -2,11 +2,15 @@ package cluster
 
 import (
 	"bufio"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"sync"
 	"time"
+
+	"github.com/code-100-precent/LingCache/persistence"
 )
 
 /*
@@ -31,6 +35,7 @@ import (
  *    - PUBLISH: 发布订阅消息
  *    - FAILOVER_AUTH_REQUEST: 故障转移投票请求
  *    - FAILOVER_AUTH_ACK: 故障转移投票确认
+ *    - MIGRATE / MIGRATE_ACK: 槽迁移时传输单个键及其确认
  *
  * 3. 通信流程
  *    - 节点启动：监听集群端口（默认 6379 + 10000 = 16379）
@@ -115,11 +120,40 @@ func (nc *NodeCommunicator) Start(port int) error {
 	return nil
 }
 
+// ListenAddr 集群总线实际监听的地址（Start 传入端口 0 时由系统分配）
+func (nc *NodeCommunicator) ListenAddr() net.Addr {
+	if nc.listener == nil {
+		return nil
+	}
+	return nc.listener.Addr()
+}
+
+// Stop 停止通信服务，关闭监听和已建立的连接
+func (nc *NodeCommunicator) Stop() {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	nc.running = false
+	if nc.listener != nil {
+		nc.listener.Close()
+	}
+	for nodeID, conn := range nc.connections {
+		conn.Close()
+		delete(nc.connections, nodeID)
+	}
+}
+
 // acceptConnections 接受连接
 func (nc *NodeCommunicator) acceptConnections() {
-	for nc.running {
+	for {
 		conn, err := nc.listener.Accept()
 		if err != nil {
+			nc.mu.RLock()
+			running := nc.running
+			nc.mu.RUnlock()
+			if !running {
+				return
+			}
 			continue
 		}
 
@@ -163,6 +197,8 @@ func (nc *NodeCommunicator) handleMessage(msg *ClusterMessage, conn net.Conn) {
 		nc.handleFailover(msg)
 	case "SLOTS":
 		nc.handleSlots(msg)
+	case "MIGRATE":
+		nc.handleMigrate(msg, conn)
 	default:
 		// 未知消息类型
 	}
@@ -251,6 +287,61 @@ func (nc *NodeCommunicator) handleSlots(msg *ClusterMessage) {
 	nc.cluster.AssignSlots(nodeID, slotList)
 }
 
+// handleMigrate 处理 MIGRATE 消息：还原槽迁移传来的键，在同一连接上回复 MIGRATE_ACK 或 MIGRATE_ERR
+func (nc *NodeCommunicator) handleMigrate(msg *ClusterMessage, conn net.Conn) {
+	key, err := nc.restoreMigratedKey(msg)
+
+	response := ClusterMessage{
+		Type:      "MIGRATE_ACK",
+		From:      nc.cluster.GetMyself().NodeID,
+		To:        msg.From,
+		Data:      map[string]interface{}{"key": key},
+		Timestamp: time.Now().Unix(),
+	}
+	if err != nil {
+		response.Type = "MIGRATE_ERR"
+		response.Data = map[string]interface{}{"key": key, "error": err.Error()}
+	}
+	nc.sendMessageToConn(conn, &response)
+}
+
+// restoreMigratedKey 把 MIGRATE 消息中的键写入本地存储（已存在时覆盖）
+func (nc *NodeCommunicator) restoreMigratedKey(msg *ClusterMessage) (string, error) {
+	data, ok := msg.Data.(map[string]interface{})
+	if !ok {
+		return "", errors.New("invalid MIGRATE message")
+	}
+
+	key, _ := data["key"].(string)
+	dbIndex, _ := data["db"].(float64)
+	expireAt, _ := data["expireAt"].(float64)
+	encoded, _ := data["value"].(string)
+
+	if nc.cluster.server == nil {
+		return key, errors.New("no storage attached")
+	}
+	db, err := nc.cluster.server.GetDb(int(dbIndex))
+	if err != nil {
+		return key, err
+	}
+
+	payload, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return key, err
+	}
+	obj, err := persistence.RestoreObject(payload)
+	if err != nil {
+		return key, err
+	}
+
+	db.Del(key)
+	db.Set(key, obj)
+	if expireAt > 0 {
+		db.ExpireAt(key, int64(expireAt))
+	}
+	return key, nil
+}
+
 // SendMeet 发送 MEET 消息
 func (nc *NodeCommunicator) SendMeet(targetAddr string, targetNodeID string) error {
 	conn, err := net.Dial("tcp", targetAddr)
@@ -281,13 +372,13 @@ func (nc *NodeCommunicator) sendMessage(nodeID string, msg *ClusterMessage) erro
 
 	if !exists {
 		// 需要先建立连接
-		node := nc.cluster.nodes[nodeID]
-		if node == nil {
+		node, ok := nc.cluster.GetNode(nodeID)
+		if !ok {
 			return fmt.Errorf("node not found: %s", nodeID)
 		}
 
 		var err error
-		conn, err = net.Dial("tcp", node.Addr)
+		conn, err = net.DialTimeout("tcp", node.busAddress(), time.Second)
 		if err != nil {
 			return err
 		}
@@ -297,7 +388,17 @@ func (nc *NodeCommunicator) sendMessage(nodeID string, msg *ClusterMessage) erro
 		nc.mu.Unlock()
 	}
 
-	return nc.sendMessageToConn(conn, msg)
+	if err := nc.sendMessageToConn(conn, msg); err != nil {
+		// 连接已断开，下次发送时重新建立
+		nc.mu.Lock()
+		if nc.connections[nodeID] == conn {
+			delete(nc.connections, nodeID)
+		}
+		nc.mu.Unlock()
+		conn.Close()
+		return err
+	}
+	return nil
 }
 
 // sendMessageToConn 发送消息到连接
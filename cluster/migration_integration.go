@@ -1,12 +1,15 @@
 package cluster
 
 import (
+	"bufio"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
 	"time"
 
+	"github.com/code-100-precent/LingCache/persistence"
 	"github.com/code-100-precent/LingCache/storage"
 )
 
@@ -36,6 +39,13 @@ import (
  *    - 减少网络往返次数
  *    - 提高迁移效率
  *
+ * 4. 本实现
+ *    - 值使用 RDB 的值编码序列化（persistence.DumpObject），连同过期时间和数据库编号
+ *      放在 MIGRATE 消息中，通过集群总线发送给目标节点
+ *    - 目标节点还原键后在同一连接上回复 MIGRATE_ACK，源节点收到确认才删除本地的键
+ *    - 中途失败时迁移记录保持 MIGRATING，再次调用 MigrateSlotData 从剩余的键继续
+ *      （已确认的键已从源节点删除，未确认的键重发时目标节点直接覆盖）
+ *
  * 【面试题】
  * Q1: MIGRATE 命令如何保证原子性？
  * A1: 原子性保证：
@@ -80,59 +90,89 @@ import (
  *     - 需要手动处理或自动回滚
  */
 
-// MigrationClient 迁移客户端（用于与目标节点通信）
+// MIGRATION_TIMEOUT 等待目标节点确认单个键的超时时间
+const MIGRATION_TIMEOUT = 10 * time.Second
+
+// MigrationClient 迁移客户端，通过集群总线向目标节点发送键并等待确认
 type MigrationClient struct {
 	conn    net.Conn
-	host    string
-	port    int
+	reader  *bufio.Reader
+	from    string
+	to      string
 	timeout time.Duration
 }
 
-// NewMigrationClient 创建迁移客户端
-func NewMigrationClient(host string, port int, timeout time.Duration) (*MigrationClient, error) {
-	addr := fmt.Sprintf("%s:%d", host, port)
-	conn, err := net.DialTimeout("tcp", addr, timeout)
+// NewMigrationClient 连接目标节点的集群总线
+func NewMigrationClient(from string, target *ClusterNode, timeout time.Duration) (*MigrationClient, error) {
+	conn, err := net.DialTimeout("tcp", target.busAddress(), timeout)
 	if err != nil {
 		return nil, err
 	}
 
 	return &MigrationClient{
 		conn:    conn,
-		host:    host,
-		port:    port,
+		reader:  bufio.NewReader(conn),
+		from:    from,
+		to:      target.NodeID,
 		timeout: timeout,
 	}, nil
 }
 
-// MigrateKey 迁移单个键
-func (mc *MigrationClient) MigrateKey(key string, obj *storage.RedisObject, destDb int, copy bool) error {
-	// 序列化对象
-	data, err := serializeObject(obj)
+// MigrateKey 迁移单个键：发送序列化后的值，等待目标节点的 MIGRATE_ACK
+// expireAt 为过期时间（Unix 秒），0 表示不过期
+func (mc *MigrationClient) MigrateKey(dbIndex int, key string, obj *storage.RedisObject, expireAt int64) error {
+	payload, err := persistence.DumpObject(obj)
+	if err != nil {
+		return err
+	}
+
+	msg := ClusterMessage{
+		Type: "MIGRATE",
+		From: mc.from,
+		To:   mc.to,
+		Data: map[string]interface{}{
+			"db":       dbIndex,
+			"key":      key,
+			"value":    base64.StdEncoding.EncodeToString(payload),
+			"expireAt": expireAt,
+		},
+		Timestamp: time.Now().Unix(),
+	}
+	data, err := json.Marshal(&msg)
 	if err != nil {
 		return err
 	}
 
-	// 构建 MIGRATE 命令
-	cmd := buildMigrateCommand(key, destDb, data, copy)
+	mc.conn.SetDeadline(time.Now().Add(mc.timeout))
+	defer mc.conn.SetDeadline(time.Time{})
 
-	// 发送命令
-	if _, err := mc.conn.Write([]byte(cmd)); err != nil {
+	if _, err := fmt.Fprintf(mc.conn, "%s\n", data); err != nil {
 		return err
 	}
 
-	// 读取响应
-	response := make([]byte, 1024)
-	n, err := mc.conn.Read(response)
+	// 读取确认
+	line, err := mc.reader.ReadString('\n')
 	if err != nil {
 		return err
 	}
-
-	// 解析响应
-	if string(response[:n]) != "+OK\r\n" {
-		return errors.New("migration failed")
+	var reply ClusterMessage
+	if err := json.Unmarshal([]byte(line), &reply); err != nil {
+		return err
 	}
 
-	return nil
+	fields, _ := reply.Data.(map[string]interface{})
+	switch reply.Type {
+	case "MIGRATE_ACK":
+		if acked, _ := fields["key"].(string); acked != key {
+			return fmt.Errorf("unexpected ack for key %q", acked)
+		}
+		return nil
+	case "MIGRATE_ERR":
+		reason, _ := fields["error"].(string)
+		return errors.New(reason)
+	default:
+		return fmt.Errorf("unexpected reply %s", reply.Type)
+	}
 }
 
 // Close 关闭连接
@@ -143,30 +183,6 @@ func (mc *MigrationClient) Close() error {
 	return nil
 }
 
-// serializeObject 序列化 RedisObject
-func serializeObject(obj *storage.RedisObject) ([]byte, error) {
-	// 简化实现：使用 JSON 序列化
-	// 实际应该使用 Redis 的序列化格式（RDB 格式）
-	data := map[string]interface{}{
-		"type":     obj.Type,
-		"encoding": obj.Encoding,
-		"data":     obj.Ptr,
-	}
-
-	return json.Marshal(data)
-}
-
-// buildMigrateCommand 构建 MIGRATE 命令
-func buildMigrateCommand(key string, destDb int, data []byte, copy bool) string {
-	// 简化实现：实际应该使用 Redis 协议格式
-	cmd := fmt.Sprintf("MIGRATE %s %d %d", key, destDb, 5000)
-	if copy {
-		cmd += " COPY"
-	}
-	cmd += "\r\n"
-	return cmd
-}
-
 // GetKeysInSlot 获取槽中的所有键（与存储层集成）
 func GetKeysInSlot(server *storage.RedisServer, slot int) []string {
 	keys := make([]string, 0)
@@ -177,110 +193,88 @@ func GetKeysInSlot(server *storage.RedisServer, slot int) []string {
 		if err != nil {
 			continue
 		}
-
-		// 获取数据库中的所有键
-		allKeys := db.Keys("*")
-
-		// 过滤出属于指定槽的键
-		for _, key := range allKeys {
-			if HashSlot(key) == slot {
-				keys = append(keys, key)
-			}
-		}
+		keys = append(keys, KeysInSlot(db, slot, -1)...)
 	}
 
 	return keys
 }
 
 // MigrateSlotData 迁移槽中的所有数据（与存储层集成）
+// 每个键在目标节点确认后才从 server 中删除；返回错误时迁移保持 MIGRATING 状态，
+// 以相同的目标节点再次调用会从剩余的键继续。全部迁移完成后槽分配给目标节点
 func (rm *ReshardingManager) MigrateSlotData(slot int, sourceNodeID, targetNodeID string, server *storage.RedisServer) error {
-	// 开始迁移
-	if err := rm.StartMigration(slot, sourceNodeID, targetNodeID); err != nil {
+	// 开始迁移，或继续之前中断的迁移
+	if err := rm.resumeMigration(slot, sourceNodeID, targetNodeID); err != nil {
 		return err
 	}
 
 	// 获取目标节点
-	rm.cluster.mu.RLock()
-	targetNode, exists := rm.cluster.nodes[targetNodeID]
-	rm.cluster.mu.RUnlock()
+	targetNode, exists := rm.cluster.GetNode(targetNodeID)
 	if !exists {
 		return errors.New("target node not found")
 	}
 
-	// 获取槽中的所有键
-	keys := GetKeysInSlot(server, slot)
+	// 获取槽中剩余的键（按数据库分组）
+	keysByDb := make([][]string, server.GetDbNum())
+	remaining := 0
+	for i := range keysByDb {
+		if db, err := server.GetDb(i); err == nil {
+			keysByDb[i] = KeysInSlot(db, slot, -1)
+			remaining += len(keysByDb[i])
+		}
+	}
 
 	// 更新迁移总数
 	rm.mu.Lock()
-	if migration, exists := rm.migrations[slot]; exists {
-		migration.KeysTotal = len(keys)
-	}
+	migration := rm.migrations[slot]
+	migration.KeysTotal = migration.KeysMigrated + remaining
 	rm.mu.Unlock()
 
-	// 创建迁移客户端
-	client, err := NewMigrationClient(targetNode.Addr, 6379, 10*time.Second)
-	if err != nil {
-		return err
-	}
-	defer client.Close()
-
-	// 迁移每个键
-	for _, key := range keys {
-		// 从存储层获取对象
-		obj, err := getObjectFromStorage(server, key)
+	if remaining > 0 {
+		client, err := NewMigrationClient(sourceNodeID, targetNode, MIGRATION_TIMEOUT)
 		if err != nil {
-			fmt.Printf("Failed to get key %s: %v\n", key, err)
-			continue
-		}
-
-		// 迁移键
-		if err := client.MigrateKey(key, obj, 0, false); err != nil {
-			fmt.Printf("Failed to migrate key %s: %v\n", key, err)
-			continue
+			return err
 		}
-
-		// 从源节点删除键（如果未指定 COPY）
-		deleteKeyFromStorage(server, key)
-
-		// 更新迁移进度
-		rm.mu.Lock()
-		if migration, exists := rm.migrations[slot]; exists {
-			migration.KeysMigrated++
+		defer client.Close()
+
+		for i, keys := range keysByDb {
+			db, _ := server.GetDb(i)
+			for _, key := range keys {
+				obj, err := db.Peek(key)
+				if err != nil {
+					// 期间已经过期或被删除
+					continue
+				}
+				expireAt, _ := db.GetExpireAt(key)
+
+				if err := client.MigrateKey(i, key, obj, expireAt); err != nil {
+					return fmt.Errorf("failed to migrate key %s: %w", key, err)
+				}
+
+				// 目标节点已确认，删除源节点的键
+				db.Del(key)
+
+				// 更新迁移进度
+				rm.mu.Lock()
+				migration.KeysMigrated++
+				rm.mu.Unlock()
+			}
 		}
-		rm.mu.Unlock()
 	}
 
 	// 完成迁移
 	return rm.CompleteMigration(slot)
 }
 
-// getObjectFromStorage 从存储层获取对象
-func getObjectFromStorage(server *storage.RedisServer, key string) (*storage.RedisObject, error) {
-	// 遍历所有数据库查找键
-	for i := 0; i < server.GetDbNum(); i++ {
-		db, err := server.GetDb(i)
-		if err != nil {
-			continue
-		}
-
-		obj, err := db.Get(key)
-		if err == nil {
-			return obj, nil
-		}
-	}
-
-	return nil, errors.New("key not found")
-}
-
-// deleteKeyFromStorage 从存储层删除键
-func deleteKeyFromStorage(server *storage.RedisServer, key string) {
-	// 遍历所有数据库删除键
-	for i := 0; i < server.GetDbNum(); i++ {
-		db, err := server.GetDb(i)
-		if err != nil {
-			continue
-		}
+// resumeMigration 槽已经在迁往同一目标节点时继续该迁移，否则开始新的迁移
+func (rm *ReshardingManager) resumeMigration(slot int, sourceNodeID, targetNodeID string) error {
+	rm.mu.RLock()
+	migration, exists := rm.migrations[slot]
+	resuming := exists && rm.migratingSlots[slot] && migration.TargetNodeID == targetNodeID
+	rm.mu.RUnlock()
 
-		db.Del(key)
+	if resuming {
+		return nil
 	}
+	return rm.StartMigration(slot, sourceNodeID, targetNodeID)
 }
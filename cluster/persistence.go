@@ -250,6 +250,11 @@ func (cp *ConfigPersistence) buildConfig() {
 		Slots:  myself.Slots,
 		Flags:  []string{"myself", "master"},
 	}
+	if myself.Master != nil {
+		cp.config.Myself.Role = "slave"
+		cp.config.Myself.MasterID = myself.Master.NodeID
+		cp.config.Myself.Flags = []string{"myself", "slave"}
+	}
 	cp.config.Nodes[myself.NodeID] = cp.config.Myself
 
 	// 保存其他节点
@@ -205,6 +205,92 @@ func (rm *ReshardingManager) StartMigration(slot int, sourceNodeID, targetNodeID
 	return nil
 }
 
+// SetSlotMigrating CLUSTER SETSLOT <slot> MIGRATING <node-id>：
+// 本节点负责的槽开始迁往目标节点，之后本节点不存在的键返回 ASK 重定向
+func (rm *ReshardingManager) SetSlotMigrating(slot int, targetNodeID string) error {
+	myself := rm.cluster.GetMyself()
+	if owner := rm.cluster.GetSlotNode(slot); owner == nil || owner.NodeID != myself.NodeID {
+		return fmt.Errorf("I'm not the owner of hash slot %d", slot)
+	}
+	if _, exists := rm.cluster.GetNode(targetNodeID); !exists {
+		return fmt.Errorf("I don't know about node %s", targetNodeID)
+	}
+
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	rm.migrations[slot] = &SlotMigration{
+		Slot:         slot,
+		SourceNodeID: myself.NodeID,
+		TargetNodeID: targetNodeID,
+		State:        SLOT_STATE_MIGRATING,
+		StartTime:    time.Now(),
+	}
+	rm.migratingSlots[slot] = true
+	delete(rm.importingSlots, slot)
+	return nil
+}
+
+// SetSlotImporting CLUSTER SETSLOT <slot> IMPORTING <node-id>：
+// 从源节点导入槽，之后带 ASKING 的请求可以在本节点执行
+func (rm *ReshardingManager) SetSlotImporting(slot int, sourceNodeID string) error {
+	myself := rm.cluster.GetMyself()
+	if owner := rm.cluster.GetSlotNode(slot); owner != nil && owner.NodeID == myself.NodeID {
+		return fmt.Errorf("I'm already the owner of hash slot %d", slot)
+	}
+	if _, exists := rm.cluster.GetNode(sourceNodeID); !exists {
+		return fmt.Errorf("I don't know about node %s", sourceNodeID)
+	}
+
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	rm.migrations[slot] = &SlotMigration{
+		Slot:         slot,
+		SourceNodeID: sourceNodeID,
+		TargetNodeID: myself.NodeID,
+		State:        SLOT_STATE_IMPORTING,
+		StartTime:    time.Now(),
+	}
+	rm.importingSlots[slot] = sourceNodeID
+	delete(rm.migratingSlots, slot)
+	return nil
+}
+
+// SetSlotStable CLUSTER SETSLOT <slot> STABLE：清除槽的迁移状态（不改变槽的归属）
+func (rm *ReshardingManager) SetSlotStable(slot int) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	delete(rm.migrations, slot)
+	delete(rm.migratingSlots, slot)
+	delete(rm.importingSlots, slot)
+}
+
+// SetSlotNode CLUSTER SETSLOT <slot> NODE <node-id>：将槽分配给节点，结束迁移
+func (rm *ReshardingManager) SetSlotNode(slot int, nodeID string) error {
+	if _, exists := rm.cluster.GetNode(nodeID); !exists {
+		return fmt.Errorf("Unknown node %s", nodeID)
+	}
+	rm.cluster.AssignSlots(nodeID, []int{slot})
+
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	if migration, exists := rm.migrations[slot]; exists {
+		migration.State = SLOT_STATE_STABLE
+		migration.EndTime = time.Now()
+	}
+	delete(rm.migratingSlots, slot)
+	delete(rm.importingSlots, slot)
+	return nil
+}
+
+// Reset 清除所有槽的迁移状态（CLUSTER RESET）
+func (rm *ReshardingManager) Reset() {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	rm.migrations = make(map[int]*SlotMigration)
+	rm.migratingSlots = make(map[int]bool)
+	rm.importingSlots = make(map[int]string)
+}
+
 // IsSlotMigrating 检查槽是否正在迁移
 func (rm *ReshardingManager) IsSlotMigrating(slot int) bool {
 	rm.mu.RLock()
@@ -220,6 +306,22 @@ func (rm *ReshardingManager) IsSlotImporting(slot int) bool {
 	return exists
 }
 
+// GetMigratingTargetNode 获取正在迁出的槽的目标节点（ASK 重定向的地址）
+func (rm *ReshardingManager) GetMigratingTargetNode(slot int) (*ClusterNode, bool) {
+	rm.mu.RLock()
+	migration, exists := rm.migrations[slot]
+	migrating := rm.migratingSlots[slot]
+	rm.mu.RUnlock()
+	if !exists || !migrating {
+		return nil, false
+	}
+
+	rm.cluster.mu.RLock()
+	defer rm.cluster.mu.RUnlock()
+	node, exists := rm.cluster.nodes[migration.TargetNodeID]
+	return node, exists
+}
+
 // GetImportingSourceNode 获取导入槽的源节点
 func (rm *ReshardingManager) GetImportingSourceNode(slot int) (string, bool) {
 	rm.mu.RLock()
@@ -258,10 +360,9 @@ func (rm *ReshardingManager) MigrateKey(key string, targetNode *ClusterNode, tim
 // CompleteMigration 完成槽迁移
 func (rm *ReshardingManager) CompleteMigration(slot int) error {
 	rm.mu.Lock()
-	defer rm.mu.Unlock()
-
 	migration, exists := rm.migrations[slot]
 	if !exists {
+		rm.mu.Unlock()
 		return errors.New("migration not found")
 	}
 
@@ -273,18 +374,40 @@ func (rm *ReshardingManager) CompleteMigration(slot int) error {
 	migration.EndTime = time.Now()
 	delete(rm.migratingSlots, slot)
 	delete(rm.importingSlots, slot)
+	targetNodeID := migration.TargetNodeID
+	rm.mu.Unlock()
 
-	// 通知所有节点更新槽分配
-	rm.notifySlotUpdate(slot, migration.TargetNodeID)
+	// 通知所有节点更新槽分配（网络操作，不持有锁）
+	rm.notifySlotUpdate(slot, targetNodeID)
 
 	return nil
 }
 
-// notifySlotUpdate 通知节点更新槽分配
+// notifySlotUpdate 通过集群总线向其他节点发送 SLOTS 消息，通知槽的新归属
+// 发送失败的节点之后通过心跳交换拓扑时再更新
 func (rm *ReshardingManager) notifySlotUpdate(slot int, nodeID string) {
-	// 通过 Gossip 协议通知所有节点
-	// 简化实现：更新本地集群状态
-	// 实际应该通过 communicator 发送 SLOTS 消息
+	communicator := rm.cluster.communicator
+	if communicator == nil {
+		return
+	}
+
+	myself := rm.cluster.GetMyself()
+	for _, node := range rm.cluster.GetNodes() {
+		if node.NodeID == myself.NodeID {
+			continue
+		}
+		msg := ClusterMessage{
+			Type: "SLOTS",
+			From: myself.NodeID,
+			To:   node.NodeID,
+			Data: map[string]interface{}{
+				"nodeID": nodeID,
+				"slots":  []int{slot},
+			},
+			Timestamp: time.Now().Unix(),
+		}
+		communicator.sendMessage(node.NodeID, &msg)
+	}
 }
 
 // GetMigrationStatus 获取迁移状态
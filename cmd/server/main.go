@@ -32,10 +32,18 @@ func main() {
 
 	// 创建服务器
 	srv := server.NewServer(*addr, *dbnum)
+	srv.SetConfigFile(utils.LoadedEnvFile())
 
 	// 初始化 AOF（如果启用）
 	if err := srv.InitAOF(config.AofEnabled, config.AofFilename); err != nil {
-		fmt.Printf("Warning: Failed to initialize AOF: %v\n", err)
+		fmt.Printf("Fatal error loading the AOF: %v\n", err)
+		os.Exit(1)
+	}
+
+	// 加载 RDB 快照（AOF 已恢复数据时跳过）
+	if err := srv.InitRDB(config.RdbEnabled, config.RdbFilename); err != nil {
+		fmt.Printf("Fatal error loading the DB: %v\n", err)
+		os.Exit(1)
 	}
 
 	// 初始化集群（如果启用）
@@ -69,9 +77,12 @@ func main() {
 		fmt.Printf("Cluster mode: enabled (port: %d)\n", config.ClusterPort)
 	}
 
-	// 等待信号
-	<-sigChan
-	fmt.Println("\nShutting down server...")
-	srv.Stop()
+	// 等待信号或 SHUTDOWN 命令
+	select {
+	case <-sigChan:
+		fmt.Println("\nShutting down server...")
+		srv.Stop()
+	case <-srv.Done():
+	}
 	fmt.Println("Server stopped")
 }
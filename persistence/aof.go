@@ -2,11 +2,18 @@ package persistence
 
 import (
 	"bufio"
+	"errors"
+	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/code-100-precent/LingCache/protocol"
+	"github.com/code-100-precent/LingCache/storage"
 )
 
 /*
@@ -21,14 +28,44 @@ import (
  * *3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n
  *
  * 【AOF 重写】
- * 将当前数据库状态转换为命令序列，生成新的 AOF 文件。
+ * 将当前数据库状态转换为命令序列，生成新的 AOF 文件：
+ * 每个键一条命令（SET/RPUSH/SADD/ZADD/HSET），有过期时间的键再加一条 PEXPIREAT，
+ * 文件中不再包含已被覆盖或删除的历史命令。
  */
 
+// fsync 策略（appendfsync）
+const (
+	FSYNC_ALWAYS   = "always"   // 每次写入后 fsync
+	FSYNC_EVERYSEC = "everysec" // 每秒 fsync 一次
+	FSYNC_NO       = "no"       // 交给操作系统决定
+)
+
+// FsyncPolicies 支持的 fsync 策略
+var FsyncPolicies = []string{FSYNC_ALWAYS, FSYNC_EVERYSEC, FSYNC_NO}
+
+// aofFile AOF 写入器使用的文件（测试中可以替换为统计 fsync 次数的实现）
+type aofFile interface {
+	io.Writer
+	Sync() error
+	Close() error
+}
+
+// AOF_FSYNC_INTERVAL everysec 策略下后台 fsync 的间隔
+const AOF_FSYNC_INTERVAL = time.Second
+
 // AOFWriter AOF 写入器
 type AOFWriter struct {
-	file   *os.File
-	writer *bufio.Writer
-	mu     sync.Mutex
+	filename    string
+	file        aofFile
+	writer      *bufio.Writer
+	fsyncPolicy string
+	selectedDb  int  // 最近一次写入的 SELECT 对应的数据库（-1 表示还没有写入）
+	unsynced    bool // 上次 fsync 之后是否有新的写入
+	closed      bool
+	writeErr    error            // 最近一次写入或 fsync 的错误（nil 表示成功）
+	rewrite     *aofRewriteState // 正在进行的重写（nil 表示没有）
+	done        chan struct{}    // 关闭时通知后台 fsync 退出
+	mu          sync.Mutex
 }
 
 // NewAOFWriter 创建 AOF 写入器
@@ -38,59 +75,435 @@ func NewAOFWriter(filename string) (*AOFWriter, error) {
 		return nil, err
 	}
 
-	return &AOFWriter{
-		file:   file,
-		writer: bufio.NewWriter(file),
-	}, nil
+	return newAOFWriter(filename, file, AOF_FSYNC_INTERVAL), nil
 }
 
-// Append 追加命令到 AOF
-func (aof *AOFWriter) Append(cmd *protocol.RESPValue) error {
+// newAOFWriter 创建写入 file 的 AOF 写入器，并启动 everysec 策略的后台 fsync
+func newAOFWriter(filename string, file aofFile, interval time.Duration) *AOFWriter {
+	aof := &AOFWriter{
+		filename:    filename,
+		file:        file,
+		writer:      bufio.NewWriter(file),
+		fsyncPolicy: FSYNC_EVERYSEC,
+		selectedDb:  -1,
+		done:        make(chan struct{}),
+	}
+	go aof.backgroundFsync(interval)
+	return aof
+}
+
+// SetFsyncPolicy 设置 fsync 策略（CONFIG SET appendfsync 立即生效）
+func (aof *AOFWriter) SetFsyncPolicy(policy string) {
 	aof.mu.Lock()
 	defer aof.mu.Unlock()
+	aof.fsyncPolicy = policy
+}
+
+// FsyncPolicy 当前的 fsync 策略
+func (aof *AOFWriter) FsyncPolicy() string {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+	return aof.fsyncPolicy
+}
+
+// Append 追加命令到 AOF（dbIndex 为命令执行时所在的数据库）
+// 与上一条命令不在同一个数据库时先写入 SELECT，重放时才能回到正确的数据库。
+// 命令总是写入操作系统（write），是否立即 fsync 取决于 appendfsync：
+// - always：返回前 fsync，宕机最多丢失正在执行的命令
+// - everysec：由后台每秒 fsync 一次，宕机最多丢失约 1 秒的数据
+// - no：不主动 fsync，由操作系统决定何时落盘
+func (aof *AOFWriter) Append(dbIndex int, cmd *protocol.RESPValue) error {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+
+	aof.writeErr = aof.append(dbIndex, cmd)
+	return aof.writeErr
+}
+
+// append 写入一条命令（调用方持有 aof.mu）
+func (aof *AOFWriter) append(dbIndex int, cmd *protocol.RESPValue) error {
+	if dbIndex != aof.selectedDb {
+		selectCmd := protocol.NewArray([]*protocol.RESPValue{
+			protocol.NewBulkString("SELECT"),
+			protocol.NewBulkString(strconv.Itoa(dbIndex)),
+		})
+		if _, err := aof.writer.Write(selectCmd.Encode()); err != nil {
+			return err
+		}
+		aof.selectedDb = dbIndex
+	}
 
 	data := cmd.Encode()
 	_, err := aof.writer.Write(data)
 	if err != nil {
 		return err
 	}
-	return aof.writer.Flush()
+	if err := aof.writer.Flush(); err != nil {
+		return err
+	}
+
+	if aof.rewrite != nil {
+		aof.rewrite.track(dbIndex, cmd)
+	}
+
+	if aof.fsyncPolicy == FSYNC_ALWAYS {
+		aof.unsynced = false
+		return aof.file.Sync()
+	}
+	aof.unsynced = true
+	return nil
 }
 
-// Close 关闭 AOF 文件
+// LastWriteOK 最近一次写入（包括 fsync）是否成功
+func (aof *AOFWriter) LastWriteOK() bool {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+	return aof.writeErr == nil
+}
+
+// backgroundFsync everysec 策略下定期 fsync（策略为 always/no 时跳过）
+func (aof *AOFWriter) backgroundFsync(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-aof.done:
+			return
+		case <-ticker.C:
+			aof.mu.Lock()
+			if aof.fsyncPolicy == FSYNC_EVERYSEC && aof.unsynced && !aof.closed {
+				aof.unsynced = false
+				if err := aof.file.Sync(); err != nil {
+					fmt.Printf("AOF fsync error: %v\n", err)
+					aof.writeErr = err
+				}
+			}
+			aof.mu.Unlock()
+		}
+	}
+}
+
+// Close 关闭 AOF 文件（关闭前 fsync，保证已写入的命令落盘）
 func (aof *AOFWriter) Close() error {
 	aof.mu.Lock()
 	defer aof.mu.Unlock()
 
+	if aof.closed {
+		return nil
+	}
+	aof.closed = true
+	close(aof.done)
+
 	if err := aof.writer.Flush(); err != nil {
 		return err
 	}
+	if aof.fsyncPolicy != FSYNC_NO && aof.unsynced {
+		aof.file.Sync()
+	}
 	return aof.file.Close()
 }
 
-// Rewrite 重写 AOF 文件
-func (aof *AOFWriter) Rewrite(server interface{}) error {
-	// 关闭当前文件
-	aof.Close()
+// AOFKeysFunc 返回写命令修改的键（重写期间用于记录被修改的键）。
+// ok 为 false 表示无法确定修改了哪些键（如 FLUSHALL、SWAPDB），重写结束时需要重新写入整个键空间
+type AOFKeysFunc func(cmd *protocol.RESPValue) (keys []string, ok bool)
+
+// aofRewriteState 重写期间记录的修改
+type aofRewriteState struct {
+	keysFunc AOFKeysFunc
+	modified map[int]map[string]struct{} // 数据库 -> 被修改的键
+	full     bool                        // 有无法确定键的命令，需要重新写入整个键空间
+}
+
+// track 记录一条写命令修改的键
+func (rs *aofRewriteState) track(dbIndex int, cmd *protocol.RESPValue) {
+	keys, ok := rs.keysFunc(cmd)
+	if !ok {
+		rs.full = true
+		return
+	}
+	if rs.modified[dbIndex] == nil {
+		rs.modified[dbIndex] = make(map[string]struct{})
+	}
+	for _, key := range keys {
+		rs.modified[dbIndex][key] = struct{}{}
+	}
+}
+
+// Rewrite 重写 AOF 文件：按当前键空间生成重建每个键所需的最少命令，写入临时文件后原子替换旧文件。
+//
+// 与 BGSAVE 一样按批遍历数据库，不会长时间阻塞写命令。遍历期间仍在追加到旧文件的写命令，
+// 会记录下它们修改的键；遍历结束后在 AOF 锁内把这些键按当前值重新写入
+// （键已不存在时写入 DEL），然后切换到新文件，之后的写命令直接追加到新文件
+func (aof *AOFWriter) Rewrite(server *storage.RedisServer, keysFunc AOFKeysFunc) error {
+	aof.mu.Lock()
+	if aof.closed {
+		aof.mu.Unlock()
+		return errors.New("AOF is closed")
+	}
+	if aof.rewrite != nil {
+		aof.mu.Unlock()
+		return errors.New("AOF rewrite already in progress")
+	}
+	state := &aofRewriteState{keysFunc: keysFunc, modified: make(map[int]map[string]struct{})}
+	aof.rewrite = state
+	aof.mu.Unlock()
 
-	// 创建新的 AOF 文件
-	newFile, err := os.Create(aof.file.Name() + ".tmp")
+	tmpFile := filepath.Join(filepath.Dir(aof.filename), fmt.Sprintf("temp-rewriteaof-%d.aof", os.Getpid()))
+	file, err := os.Create(tmpFile)
 	if err != nil {
+		aof.stopRewrite()
 		return err
 	}
-	defer newFile.Close()
 
-	// 将当前数据库状态转换为命令序列并写入
-	// 简化实现：这里应该遍历所有数据库和键，生成 SET 等命令
-	// 实际实现需要访问 server 对象来获取数据
+	rw := &aofRewriter{writer: bufio.NewWriterSize(file, 64*1024), selectedDb: -1}
+	if err := rw.writeKeyspace(server); err != nil {
+		aof.stopRewrite()
+		file.Close()
+		os.Remove(tmpFile)
+		return err
+	}
+
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+	aof.rewrite = nil
+
+	if err := aof.finishRewrite(server, state, rw, file, tmpFile); err != nil {
+		file.Close()
+		os.Remove(tmpFile)
+		return err
+	}
+	return nil
+}
 
-	// 原子替换文件
-	return os.Rename(newFile.Name(), aof.file.Name())
+// stopRewrite 放弃重写
+func (aof *AOFWriter) stopRewrite() {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+	aof.rewrite = nil
 }
 
-// AOFLoader AOF 加载器
+// finishRewrite 写入重写期间被修改的键并切换到新文件（调用方持有 aof.mu）
+func (aof *AOFWriter) finishRewrite(server *storage.RedisServer, state *aofRewriteState, rw *aofRewriter, file *os.File, tmpFile string) error {
+	if state.full {
+		// 无法确定修改了哪些键：清空临时文件，重新写入整个键空间（期间写命令的追加会等待）
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err := file.Truncate(0); err != nil {
+			return err
+		}
+		rw.writer.Reset(file)
+		rw.selectedDb = -1
+		if err := rw.writeKeyspace(server); err != nil {
+			return err
+		}
+	} else {
+		if err := rw.writeModifiedKeys(server, state.modified); err != nil {
+			return err
+		}
+	}
+
+	if err := rw.writer.Flush(); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	// 旧文件中尚未写出的内容已经包含在新文件中
+	aof.writer.Flush()
+	if err := os.Rename(tmpFile, aof.filename); err != nil {
+		return err
+	}
+
+	newFile, err := os.OpenFile(aof.filename, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	aof.file.Close()
+	aof.file = newFile
+	aof.writer = bufio.NewWriter(newFile)
+	aof.selectedDb = rw.selectedDb
+	aof.unsynced = false
+	return nil
+}
+
+// aofRewriter 生成重建键空间的命令
+type aofRewriter struct {
+	writer     *bufio.Writer
+	selectedDb int
+}
+
+// writeKeyspace 按批遍历所有数据库，写入重建每个键的命令
+func (rw *aofRewriter) writeKeyspace(server *storage.RedisServer) error {
+	for i := 0; i < server.GetDbNum(); i++ {
+		db, err := server.GetDb(i)
+		if err != nil || db.DBSize() == 0 {
+			continue
+		}
+
+		err = db.ForEachChunk(rdbSaveChunkSize, func(key string, obj *storage.RedisObject, expireAt int64) error {
+			if err := rw.selectDb(i); err != nil {
+				return err
+			}
+			return rw.writeObject(key, obj, expireAt)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeModifiedKeys 按当前值重新写入被修改的键（已不存在的键写入 DEL）
+func (rw *aofRewriter) writeModifiedKeys(server *storage.RedisServer, modified map[int]map[string]struct{}) error {
+	dbs := make([]int, 0, len(modified))
+	for dbIndex := range modified {
+		dbs = append(dbs, dbIndex)
+	}
+	sort.Ints(dbs)
+
+	for _, dbIndex := range dbs {
+		db, err := server.GetDb(dbIndex)
+		if err != nil {
+			continue
+		}
+		for key := range modified[dbIndex] {
+			if err := rw.selectDb(dbIndex); err != nil {
+				return err
+			}
+			// 先删除快照中的旧值，再按当前值重建
+			if err := rw.writeCommand("DEL", key); err != nil {
+				return err
+			}
+			obj, err := db.Peek(key)
+			if err != nil {
+				continue
+			}
+			expireAt, _ := db.GetExpireAt(key)
+			if err := rw.writeObject(key, obj, expireAt); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// selectDb 切换到 dbIndex（与上一条命令在同一个数据库时不写入）
+func (rw *aofRewriter) selectDb(dbIndex int) error {
+	if dbIndex == rw.selectedDb {
+		return nil
+	}
+	rw.selectedDb = dbIndex
+	return rw.writeCommand("SELECT", strconv.Itoa(dbIndex))
+}
+
+// writeObject 写入重建一个键的命令：每个键一条命令（包含所有元素），有过期时间时再加一条 PEXPIREAT
+func (rw *aofRewriter) writeObject(key string, obj *storage.RedisObject, expireAt int64) error {
+	var err error
+	switch obj.Type {
+	case storage.OBJ_STRING:
+		val, e := obj.GetStringValue()
+		if e != nil {
+			return e
+		}
+		err = rw.writeCommand("SET", key, string(val))
+
+	case storage.OBJ_LIST:
+		list, e := obj.GetList()
+		if e != nil {
+			return e
+		}
+		values, _ := list.Range(0, -1)
+		args := []string{key}
+		for _, val := range values {
+			args = append(args, string(val))
+		}
+		err = rw.writeCommand("RPUSH", args...)
+
+	case storage.OBJ_SET:
+		set, e := obj.GetSet()
+		if e != nil {
+			return e
+		}
+		args := []string{key}
+		for _, member := range set.Members() {
+			args = append(args, string(member))
+		}
+		err = rw.writeCommand("SADD", args...)
+
+	case storage.OBJ_ZSET:
+		zset, e := obj.GetZSet()
+		if e != nil {
+			return e
+		}
+		entries, _ := zset.Range(0, -1, false)
+		args := []string{key}
+		for _, entry := range entries {
+			args = append(args, strconv.FormatFloat(entry.Score(), 'g', -1, 64), string(entry.Member()))
+		}
+		err = rw.writeCommand("ZADD", args...)
+
+	case storage.OBJ_HASH:
+		hash, e := obj.GetHash()
+		if e != nil {
+			return e
+		}
+		entries := hash.GetAll()
+		args := []string{key}
+		for _, entry := range entries {
+			args = append(args, string(entry.Field()), string(entry.Value()))
+		}
+		if err = rw.writeCommand("HSET", args...); err != nil {
+			return err
+		}
+		// 字段的过期时间
+		for _, entry := range entries {
+			if fieldExpireAt, ok := hash.FieldExpireAt(entry.Field()); ok {
+				err = rw.writeCommand("HPEXPIREAT", key, strconv.FormatInt(fieldExpireAt, 10), "FIELDS", "1", string(entry.Field()))
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+	default:
+		return fmt.Errorf("unknown object type: %d", obj.Type)
+	}
+	if err != nil {
+		return err
+	}
+
+	if expireAt > 0 {
+		return rw.writeCommand("PEXPIREAT", key, strconv.FormatInt(expireAt*1000, 10))
+	}
+	return nil
+}
+
+// writeCommand 以 RESP 数组格式写入一条命令
+func (rw *aofRewriter) writeCommand(name string, args ...string) error {
+	values := make([]*protocol.RESPValue, 0, len(args)+1)
+	values = append(values, protocol.NewBulkString(name))
+	for _, arg := range args {
+		values = append(values, protocol.NewBulkString(arg))
+	}
+	_, err := rw.writer.Write(protocol.NewArray(values).Encode())
+	return err
+}
+
+// ErrAOFTruncated AOF 文件末尾的命令不完整（例如写入过程中宕机）
+var ErrAOFTruncated = errors.New("AOF file is truncated")
+
+// AOFLoader AOF 加载器（逐条解析命令，记录最后一条完整命令结束的位置）
 type AOFLoader struct {
+	file   *os.File
 	reader *bufio.Reader
+	read   int64 // 从文件读入缓冲区的字节数
+	offset int64 // 最后一条完整命令结束的位置
 }
 
 // NewAOFLoader 创建 AOF 加载器
@@ -100,26 +513,66 @@ func NewAOFLoader(filename string) (*AOFLoader, error) {
 		return nil, err
 	}
 
-	return &AOFLoader{
-		reader: bufio.NewReader(file),
-	}, nil
+	loader := &AOFLoader{file: file}
+	loader.reader = bufio.NewReader(readCounter{loader})
+	return loader, nil
+}
+
+// readCounter 统计从文件读取的字节数
+type readCounter struct {
+	loader *AOFLoader
+}
+
+func (rc readCounter) Read(p []byte) (int, error) {
+	n, err := rc.loader.file.Read(p)
+	rc.loader.read += int64(n)
+	return n, err
+}
+
+// Next 读取下一条命令。文件正常结束时返回 io.EOF，
+// 末尾的命令不完整时返回 ErrAOFTruncated（ValidSize 为可以保留的长度）
+func (loader *AOFLoader) Next() (*protocol.RESPValue, error) {
+	cmd, err := protocol.Decode(loader.reader)
+	pos := loader.read - int64(loader.reader.Buffered())
+	if err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			if pos == loader.offset {
+				return nil, io.EOF
+			}
+			return nil, ErrAOFTruncated
+		}
+		return nil, fmt.Errorf("bad AOF format at offset %d: %w", loader.offset, err)
+	}
+	if !cmd.IsArray() || len(cmd.GetArray()) == 0 {
+		return nil, fmt.Errorf("bad AOF format at offset %d: expected a command array", loader.offset)
+	}
+
+	loader.offset = pos
+	return cmd, nil
+}
+
+// ValidSize 最后一条完整命令结束的位置（截断文件时保留的长度）
+func (loader *AOFLoader) ValidSize() int64 {
+	return loader.offset
 }
 
-// Load 从 AOF 文件加载命令
+// Load 从 AOF 文件加载所有命令。末尾的命令不完整时返回已解析的命令和 ErrAOFTruncated
 func (loader *AOFLoader) Load() ([]*protocol.RESPValue, error) {
 	commands := make([]*protocol.RESPValue, 0)
 
 	for {
-		cmd, err := protocol.Decode(loader.reader)
+		cmd, err := loader.Next()
+		if err == io.EOF {
+			return commands, nil
+		}
 		if err != nil {
-			// 检查是否是 EOF 错误（文件读取完毕）
-			if err == io.EOF || err.Error() == "EOF" || err.Error() == "unexpected EOF" {
-				break
-			}
-			return nil, err
+			return commands, err
 		}
 		commands = append(commands, cmd)
 	}
+}
 
-	return commands, nil
+// Close 关闭 AOF 文件
+func (loader *AOFLoader) Close() error {
+	return loader.file.Close()
 }
@@ -0,0 +1,152 @@
+package persistence
+
+import (
+	"bytes"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/code-100-precent/LingCache/protocol"
+)
+
+// countingFile 记录写入内容和 fsync 次数的 AOF 文件
+type countingFile struct {
+	mu    sync.Mutex
+	buf   bytes.Buffer
+	syncs int
+}
+
+func (f *countingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.buf.Write(p)
+}
+
+func (f *countingFile) Sync() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.syncs++
+	return nil
+}
+
+func (f *countingFile) Close() error { return nil }
+
+func (f *countingFile) syncCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.syncs
+}
+
+func setCommand(key, value string) *protocol.RESPValue {
+	return protocol.NewArray([]*protocol.RESPValue{
+		protocol.NewBulkString("SET"), protocol.NewBulkString(key), protocol.NewBulkString(value),
+	})
+}
+
+// TestAOFFsyncAlways 测试 always 策略在 Append 返回前同步 fsync
+func TestAOFFsyncAlways(t *testing.T) {
+	file := &countingFile{}
+	aof := newAOFWriter("test.aof", file, time.Hour)
+	defer aof.Close()
+	aof.SetFsyncPolicy(FSYNC_ALWAYS)
+
+	for i := 1; i <= 3; i++ {
+		if err := aof.Append(0, setCommand("k", "v")); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+		if got := file.syncCount(); got != i {
+			t.Fatalf("Expected %d syncs after %d appends, got %d", i, i, got)
+		}
+	}
+
+	// 第一条命令之前写入 SELECT 0
+	if !bytes.HasPrefix(file.buf.Bytes(), []byte("*2\r\n$6\r\nSELECT\r\n$1\r\n0\r\n*3\r\n$3\r\nSET")) {
+		t.Fatalf("Unexpected AOF content: %q", file.buf.String())
+	}
+}
+
+// TestAOFFsyncEverysecAndNo 测试 everysec 由后台定期 fsync，no 从不主动 fsync
+func TestAOFFsyncEverysecAndNo(t *testing.T) {
+	file := &countingFile{}
+	aof := newAOFWriter("test.aof", file, 10*time.Millisecond)
+	defer aof.Close()
+
+	aof.Append(0, setCommand("k", "v"))
+	aof.Append(0, setCommand("k", "v"))
+	if got := file.syncCount(); got != 0 {
+		t.Fatalf("everysec should not fsync in Append, got %d syncs", got)
+	}
+	deadline := time.Now().Add(time.Second)
+	for file.syncCount() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("everysec did not fsync in the background")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// 没有新的写入时不会重复 fsync
+	time.Sleep(50 * time.Millisecond)
+	synced := file.syncCount()
+	if synced != 1 {
+		t.Fatalf("Expected a single background fsync, got %d", synced)
+	}
+
+	aof.SetFsyncPolicy(FSYNC_NO)
+	aof.Append(0, setCommand("k", "v"))
+	time.Sleep(50 * time.Millisecond)
+	if got := file.syncCount(); got != synced {
+		t.Fatalf("appendfsync no should not fsync, got %d syncs", got)
+	}
+}
+
+// TestAOFRewriteCommands 测试重写后的 AOF 每个键只有一条命令（加上过期时间），且之后的写入追加到新文件
+func TestAOFRewriteCommands(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "appendonly.aof")
+	aof, err := NewAOFWriter(filename)
+	if err != nil {
+		t.Fatalf("NewAOFWriter failed: %v", err)
+	}
+	defer aof.Close()
+	for i := 0; i < 100; i++ {
+		aof.Append(0, setCommand("str", "old"))
+	}
+
+	keysFunc := func(cmd *protocol.RESPValue) ([]string, bool) {
+		return []string{cmd.Array[1].ToString()}, true
+	}
+	if err := aof.Rewrite(populateRDBServer(t), keysFunc); err != nil {
+		t.Fatalf("Rewrite failed: %v", err)
+	}
+	aof.Append(5, setCommand("after", "rewrite"))
+	aof.Close()
+
+	loader, err := NewAOFLoader(filename)
+	if err != nil {
+		t.Fatalf("NewAOFLoader failed: %v", err)
+	}
+	defer loader.Close()
+	commands, err := loader.Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	counts := make(map[string]int)
+	for _, cmd := range commands {
+		counts[cmd.Array[0].ToString()]++
+	}
+	want := map[string]int{"SELECT": 3, "SET": 4, "RPUSH": 1, "SADD": 1, "ZADD": 1, "HSET": 2, "HPEXPIREAT": 1, "PEXPIREAT": 2}
+	for name, n := range want {
+		if counts[name] != n {
+			t.Fatalf("Expected %d %s commands, got %d (%v)", n, name, counts[name], counts)
+		}
+	}
+	if len(counts) != len(want) {
+		t.Fatalf("Unexpected commands in rewritten AOF: %v", counts)
+	}
+
+	last := commands[len(commands)-1]
+	if last.Array[1].ToString() != "after" || commands[len(commands)-2].Array[1].ToString() != "5" {
+		t.Fatalf("Expected SELECT 5 and the new write at the end of the rewritten AOF")
+	}
+}
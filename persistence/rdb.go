@@ -1,13 +1,18 @@
 package persistence
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/code-100-precent/LingCache/storage"
+	"github.com/code-100-precent/LingCache/utils"
 )
 
 /*
@@ -30,10 +35,20 @@ import (
  * +--------+--------+--------+--------+--------+
  *
  * 【键值对格式】
- * +--------+--------+--------+--------+--------+
- * |  Type  |  Key   |  Value |  Expire|  ...   |
- * | (1B)   |        |        | (opt)  |        |
- * +--------+--------+--------+--------+--------+
+ * +--------+--------+--------+--------+
+ * | Expire |  Type  |  Key   |  Value |
+ * | (opt)  | (1B)   |        |        |
+ * +--------+--------+--------+--------+
+ *
+ * Expire 为 EXPIRETIME_MS 操作码加 8 字节毫秒时间戳，作用于紧随其后的键。
+ * Type 为对象类型；有字段设置了过期时间的哈希使用 HASH_METADATA 类型，
+ * 每个字段在 field、value 之后多写 8 字节的毫秒过期时间（0 表示不过期）。
+ * 这是本项目自己的格式，与 Redis 同类型的编码不兼容，Redis 无法加载这类键。
+ *
+ * 【校验和】
+ * EOF 之后是 8 字节小端序的 CRC64（Jones 多项式，与 Redis 相同），
+ * 覆盖从魔数到 EOF 的全部内容。加载时校验不一致则拒绝加载。
+ * 关闭校验（rdbchecksum no）时写入 0，加载时校验和为 0 的文件跳过校验。
  */
 
 const (
@@ -43,31 +58,95 @@ const (
 	RDB_OPCODE_SELECTDB      = 0xFE
 	RDB_OPCODE_EXPIRETIME_MS = 0xFC
 	RDB_OPCODE_EXPIRETIME    = 0xFD
+
+	RDB_TYPE_HASH_METADATA = 22 // 带字段过期时间的哈希（本项目自己的编码，见上文）
 )
 
+var (
+	ErrRDBInvalid   = errors.New("invalid RDB file")
+	ErrRDBTruncated = errors.New("unexpected end of RDB file")
+	ErrRDBChecksum  = errors.New("wrong RDB checksum")
+)
+
+// crcWriter 写入的同时计算 CRC64
+type crcWriter struct {
+	w   io.Writer
+	crc uint64
+}
+
+func (cw *crcWriter) Write(p []byte) (int, error) {
+	cw.crc = utils.CRC64(cw.crc, p)
+	return cw.w.Write(p)
+}
+
+// crcReader 读取的同时计算 CRC64
+type crcReader struct {
+	r   io.Reader
+	crc uint64
+}
+
+func (cr *crcReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.crc = utils.CRC64(cr.crc, p[:n])
+	return n, err
+}
+
 // RDBEncoder RDB 编码器
 type RDBEncoder struct {
-	writer io.Writer
+	writer   io.Writer
+	checksum bool // 是否写入 CRC64 校验和（默认开启）
 }
 
 // NewRDBEncoder 创建 RDB 编码器
 func NewRDBEncoder(writer io.Writer) *RDBEncoder {
-	return &RDBEncoder{writer: writer}
+	return &RDBEncoder{writer: writer, checksum: true}
+}
+
+// SetChecksum 设置是否写入校验和（rdbchecksum），关闭时写入 0
+func (enc *RDBEncoder) SetChecksum(enabled bool) {
+	enc.checksum = enabled
 }
 
+// rdbSaveChunkSize 保存时每批处理的键数量（每批只短暂持有数据库读锁）
+const rdbSaveChunkSize = 1024
+
 // Save 保存数据库到 RDB 文件
+// 数据先写入临时文件，完成后再 rename 覆盖，避免保存失败留下不完整的文件；
+// 遍历数据库时按批加锁，不会在整个保存期间阻塞其它命令
 func (enc *RDBEncoder) Save(server *storage.RedisServer, filename string) error {
-	file, err := os.Create(filename)
+	tmpFile := fmt.Sprintf("%s.tmp-%d", filename, os.Getpid())
+	file, err := os.Create(tmpFile)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
-	enc.writer = file
+	if err := enc.SaveTo(server, file); err != nil {
+		file.Close()
+		os.Remove(tmpFile)
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmpFile)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+
+	return os.Rename(tmpFile, filename)
+}
+
+// SaveTo 将所有数据库按 RDB 格式写入 w（全量复制时写入内存缓冲区发送给从节点）
+func (enc *RDBEncoder) SaveTo(server *storage.RedisServer, w io.Writer) error {
+	buf := bufio.NewWriterSize(w, 64*1024)
+	cw := &crcWriter{w: buf}
+	enc.writer = cw
 
-	// 写入魔数和版本
-	enc.writeString(RDB_MAGIC)
-	enc.writeString(RDB_VERSION)
+	// 写入魔数和版本（不带长度前缀）
+	enc.writer.Write([]byte(RDB_MAGIC + RDB_VERSION))
 
 	// 保存每个数据库
 	for i := 0; i < server.GetDbNum(); i++ {
@@ -84,47 +163,65 @@ func (enc *RDBEncoder) Save(server *storage.RedisServer, filename string) error
 		enc.writeByte(RDB_OPCODE_SELECTDB)
 		enc.writeLength(uint32(i))
 
-		// 保存数据库中的所有键值对
-		keys := db.Keys("*")
-		for _, key := range keys {
-			obj, err := db.Get(key)
-			if err != nil {
-				continue
-			}
-
-			// 检查过期时间
-			ttl, _ := db.TTL(key)
-			if ttl > 0 {
+		// 分批保存数据库中的所有键值对
+		err = db.ForEachChunk(rdbSaveChunkSize, func(key string, obj *storage.RedisObject, expireAt int64) error {
+			if expireAt > 0 {
 				// 写入过期时间（毫秒）
 				enc.writeByte(RDB_OPCODE_EXPIRETIME_MS)
-				expireTime := time.Now().UnixMilli() + ttl*1000
-				enc.writeUint64(uint64(expireTime))
+				enc.writeUint64(uint64(expireAt * 1000))
 			}
 
 			// 写入键值对
-			if err := enc.writeKeyValue(key, obj); err != nil {
-				return err
-			}
+			return enc.writeKeyValue(key, obj)
+		})
+		if err != nil {
+			return err
 		}
 	}
 
 	// 写入 EOF
 	enc.writeByte(RDB_OPCODE_EOF)
 
-	// 写入校验和（简化实现：跳过）
+	// 写入校验和
+	var checksum uint64
+	if enc.checksum {
+		checksum = cw.crc
+	}
+	if err := binary.Write(buf, binary.LittleEndian, checksum); err != nil {
+		return err
+	}
 
-	return nil
+	return buf.Flush()
 }
 
 // writeKeyValue 写入键值对
 func (enc *RDBEncoder) writeKeyValue(key string, obj *storage.RedisObject) error {
 	// 写入类型
-	enc.writeByte(byte(obj.Type))
+	enc.writeByte(rdbValueType(obj))
 
 	// 写入键
 	enc.writeString(key)
 
 	// 写入值
+	return enc.writeValue(obj)
+}
+
+// rdbValueType 返回对象在 RDB 中的类型字节
+func rdbValueType(obj *storage.RedisObject) byte {
+	if obj.Type == storage.OBJ_HASH {
+		if hash, err := obj.GetHash(); err == nil && hash.HasFieldExpires() {
+			return RDB_TYPE_HASH_METADATA
+		}
+	}
+	return byte(obj.Type)
+}
+
+// writeValue 按对象类型写入值（不含类型和键）
+func (enc *RDBEncoder) writeValue(obj *storage.RedisObject) error {
+	if rdbValueType(obj) == RDB_TYPE_HASH_METADATA {
+		return enc.writeHashMetadataValue(obj)
+	}
+
 	switch obj.Type {
 	case storage.OBJ_STRING:
 		return enc.writeStringValue(obj)
@@ -141,6 +238,27 @@ func (enc *RDBEncoder) writeKeyValue(key string, obj *storage.RedisObject) error
 	}
 }
 
+// DumpObject 将单个值序列化为 RDB 格式（类型字节 + 值），用于集群槽迁移等按键传输的场景
+func DumpObject(obj *storage.RedisObject) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := NewRDBEncoder(&buf)
+	enc.writeByte(rdbValueType(obj))
+	if err := enc.writeValue(obj); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// RestoreObject 还原 DumpObject 序列化的值
+func RestoreObject(data []byte) (*storage.RedisObject, error) {
+	dec := NewRDBDecoder(bytes.NewReader(data))
+	objType, err := dec.readByte()
+	if err != nil {
+		return nil, err
+	}
+	return dec.readValue(storage.ObjectType(objType))
+}
+
 // writeStringValue 写入字符串值
 func (enc *RDBEncoder) writeStringValue(obj *storage.RedisObject) error {
 	val, err := obj.GetStringValue()
@@ -229,6 +347,25 @@ func (enc *RDBEncoder) writeHashValue(obj *storage.RedisObject) error {
 	return nil
 }
 
+// writeHashMetadataValue 写入带字段过期时间的哈希值
+func (enc *RDBEncoder) writeHashMetadataValue(obj *storage.RedisObject) error {
+	hash, err := obj.GetHash()
+	if err != nil {
+		return err
+	}
+
+	entries := hash.GetAll()
+	enc.writeLength(uint32(len(entries)))
+	for _, entry := range entries {
+		enc.writeString(string(entry.Field()))
+		enc.writeString(string(entry.Value()))
+		expireAt, _ := hash.FieldExpireAt(entry.Field())
+		enc.writeUint64(uint64(expireAt))
+	}
+
+	return nil
+}
+
 // 辅助函数
 
 func (enc *RDBEncoder) writeByte(b byte) error {
@@ -266,15 +403,24 @@ func (enc *RDBEncoder) writeFloat64(v float64) error {
 
 // RDBDecoder RDB 解码器
 type RDBDecoder struct {
-	reader io.Reader
+	reader   io.Reader
+	checksum bool // 是否校验 CRC64 校验和（默认开启）
 }
 
 // NewRDBDecoder 创建 RDB 解码器
 func NewRDBDecoder(reader io.Reader) *RDBDecoder {
-	return &RDBDecoder{reader: reader}
+	return &RDBDecoder{reader: reader, checksum: true}
+}
+
+// SetChecksum 设置加载时是否校验校验和（rdbchecksum）
+func (dec *RDBDecoder) SetChecksum(enabled bool) {
+	dec.checksum = enabled
 }
 
 // Load 从 RDB 文件加载数据
+// 按 SELECTDB/EXPIRETIME 操作码把每个键值对还原到对应的数据库，
+// 过期时间通过数据库的过期字典设置，加载时已经过期的键直接丢弃。
+// 文件在 EOF 操作码之前结束视为文件损坏
 func (dec *RDBDecoder) Load(server *storage.RedisServer, filename string) error {
 	file, err := os.Open(filename)
 	if err != nil {
@@ -282,79 +428,136 @@ func (dec *RDBDecoder) Load(server *storage.RedisServer, filename string) error
 	}
 	defer file.Close()
 
-	dec.reader = file
+	return dec.LoadFrom(server, file)
+}
+
+// LoadFrom 从 r 读取 RDB 数据并校验校验和（从节点加载全量复制收到的快照）
+func (dec *RDBDecoder) LoadFrom(server *storage.RedisServer, r io.Reader) error {
+	cr := &crcReader{r: bufio.NewReaderSize(r, 64*1024)}
+	dec.reader = cr
+	if err := dec.load(server); err != nil {
+		return err
+	}
 
+	// EOF 之后是校验和（不计入校验范围）
+	expected := cr.crc
+	var checksum uint64
+	if err := dec.readBinary(&checksum); err != nil {
+		return err
+	}
+	if dec.checksum && checksum != 0 && checksum != expected {
+		return ErrRDBChecksum
+	}
+	return nil
+}
+
+// load 从 dec.reader 读取 RDB 数据（到 EOF 操作码为止）
+func (dec *RDBDecoder) load(server *storage.RedisServer) error {
 	// 读取魔数和版本
-	magic := make([]byte, 5)
-	if _, err := dec.reader.Read(magic); err != nil {
+	header := make([]byte, len(RDB_MAGIC)+len(RDB_VERSION))
+	if err := dec.readFull(header); err != nil {
 		return err
 	}
-	if string(magic[:5]) != RDB_MAGIC {
-		return fmt.Errorf("invalid RDB file")
+	if string(header[:len(RDB_MAGIC)]) != RDB_MAGIC {
+		return ErrRDBInvalid
+	}
+	if _, err := strconv.Atoi(string(header[len(RDB_MAGIC):])); err != nil {
+		return ErrRDBInvalid
 	}
 
-	version := make([]byte, 4)
-	if _, err := dec.reader.Read(version); err != nil {
+	now := time.Now().Unix()
+	db, err := server.GetDb(0)
+	if err != nil {
 		return err
 	}
+	var expireAt int64 // 下一个键的过期时间（Unix 秒，0 表示不过期）
 
-	// 读取数据库
-	currentDB := 0
 	for {
 		b, err := dec.readByte()
 		if err != nil {
-			break
+			return err
 		}
 
-		if b == RDB_OPCODE_EOF {
-			break
-		}
+		switch b {
+		case RDB_OPCODE_EOF:
+			return nil
 
-		if b == RDB_OPCODE_SELECTDB {
+		case RDB_OPCODE_SELECTDB:
 			dbNum, err := dec.readLength()
 			if err != nil {
 				return err
 			}
-			currentDB = int(dbNum)
+			if db, err = server.GetDb(int(dbNum)); err != nil {
+				return fmt.Errorf("RDB selects database %d: %w", dbNum, err)
+			}
 			continue
-		}
 
-		if b == RDB_OPCODE_EXPIRETIME_MS {
-			expireTime, err := dec.readUint64()
+		case RDB_OPCODE_EXPIRETIME_MS:
+			ms, err := dec.readUint64()
 			if err != nil {
 				return err
 			}
-			// 处理过期时间（简化实现：跳过）
-			_ = expireTime
+			// 向上取整，避免加载后比保存时提前过期
+			expireAt = int64((ms + 999) / 1000)
+			continue
+
+		case RDB_OPCODE_EXPIRETIME:
+			var seconds uint32
+			if err := dec.readBinary(&seconds); err != nil {
+				return err
+			}
+			expireAt = int64(seconds)
 			continue
 		}
 
 		// 读取键值对
-		objType := storage.ObjectType(b)
 		key, err := dec.readString()
 		if err != nil {
 			return err
 		}
-
-		obj, err := dec.readValue(objType)
+		obj, err := dec.readValue(storage.ObjectType(b))
 		if err != nil {
-			return err
+			return fmt.Errorf("RDB key %q: %w", key, err)
 		}
 
-		// 保存到数据库
-		db, err := server.GetDb(currentDB)
-		if err != nil {
-			continue
+		if expireAt > 0 && expireAt <= now {
+			// 已经过期的键不加载
+			obj.DecrRefCount()
+		} else {
+			db.Set(key, obj)
+			if expireAt > 0 {
+				db.ExpireAt(key, expireAt)
+			}
+		}
+		expireAt = 0
+	}
+}
+
+// readFull 读满 buf，文件提前结束时返回 ErrRDBTruncated
+func (dec *RDBDecoder) readFull(buf []byte) error {
+	if _, err := io.ReadFull(dec.reader, buf); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrRDBTruncated
 		}
-		db.Set(key, obj)
+		return err
 	}
+	return nil
+}
 
+// readBinary 按小端序读取定长数值
+func (dec *RDBDecoder) readBinary(v any) error {
+	if err := binary.Read(dec.reader, binary.LittleEndian, v); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrRDBTruncated
+		}
+		return err
+	}
 	return nil
 }
 
 func (dec *RDBDecoder) readByte() (byte, error) {
 	b := make([]byte, 1)
-	_, err := dec.reader.Read(b)
+	err := dec.readFull(b)
 	return b[0], err
 }
 
@@ -368,11 +571,11 @@ func (dec *RDBDecoder) readLength() (uint32, error) {
 		return uint32(b), nil
 	} else if b == 254 {
 		var len uint16
-		err := binary.Read(dec.reader, binary.LittleEndian, &len)
+		err := dec.readBinary(&len)
 		return uint32(len), err
 	} else {
 		var len uint32
-		err := binary.Read(dec.reader, binary.LittleEndian, &len)
+		err := dec.readBinary(&len)
 		return len, err
 	}
 }
@@ -384,13 +587,19 @@ func (dec *RDBDecoder) readString() (string, error) {
 	}
 
 	data := make([]byte, len)
-	_, err = dec.reader.Read(data)
+	err = dec.readFull(data)
 	return string(data), err
 }
 
 func (dec *RDBDecoder) readUint64() (uint64, error) {
 	var v uint64
-	err := binary.Read(dec.reader, binary.LittleEndian, &v)
+	err := dec.readBinary(&v)
+	return v, err
+}
+
+func (dec *RDBDecoder) readFloat64() (float64, error) {
+	var v float64
+	err := dec.readBinary(&v)
 	return v, err
 }
 
@@ -447,8 +656,7 @@ func (dec *RDBDecoder) readValue(objType storage.ObjectType) (*storage.RedisObje
 			if err != nil {
 				return nil, err
 			}
-			var score float64
-			err = binary.Read(dec.reader, binary.LittleEndian, &score)
+			score, err := dec.readFloat64()
 			if err != nil {
 				return nil, err
 			}
@@ -476,6 +684,33 @@ func (dec *RDBDecoder) readValue(objType storage.ObjectType) (*storage.RedisObje
 		}
 		return hashObj, nil
 
+	case RDB_TYPE_HASH_METADATA:
+		len, err := dec.readLength()
+		if err != nil {
+			return nil, err
+		}
+		hashObj := storage.NewHashObject()
+		hash, _ := hashObj.GetHash()
+		for i := uint32(0); i < len; i++ {
+			field, err := dec.readString()
+			if err != nil {
+				return nil, err
+			}
+			value, err := dec.readString()
+			if err != nil {
+				return nil, err
+			}
+			expireAt, err := dec.readUint64()
+			if err != nil {
+				return nil, err
+			}
+			hash.Set([]byte(field), []byte(value))
+			if expireAt != 0 {
+				hash.SetFieldExpire([]byte(field), int64(expireAt))
+			}
+		}
+		return hashObj, nil
+
 	default:
 		return nil, fmt.Errorf("unknown object type: %d", objType)
 	}
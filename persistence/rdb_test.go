@@ -0,0 +1,272 @@
+package persistence
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/code-100-precent/LingCache/storage"
+	"github.com/code-100-precent/LingCache/utils"
+)
+
+// populateRDBServer 在 db 0 和 db 3 中写入所有类型的键（包括大编码和带过期时间的键）
+func populateRDBServer(t *testing.T) *storage.RedisServer {
+	t.Helper()
+	server := storage.NewRedisServer(16)
+	db0, _ := server.GetDb(0)
+	db3, _ := server.GetDb(3)
+
+	db0.Set("str", storage.NewStringObject([]byte("hello")))
+	db0.Set("num", storage.NewStringObject([]byte("12345")))
+
+	listObj := storage.NewListObject()
+	list, _ := listObj.GetList()
+	for i := 0; i < 600; i++ {
+		list.Push([]byte("item:"+strconv.Itoa(i)), 1)
+	}
+	db0.Set("list", listObj)
+
+	setObj := storage.NewSetObject()
+	set, _ := setObj.GetSet()
+	set.Add([]byte("1"))
+	set.Add([]byte("2"))
+	set.Add([]byte("three"))
+	db0.Set("set", setObj)
+
+	zsetObj := storage.NewZSetObject()
+	zset, _ := zsetObj.GetZSet()
+	for i := 0; i < 200; i++ {
+		zset.Add([]byte("m"+strconv.Itoa(i)), float64(i)/3)
+	}
+	db3.Set("zset", zsetObj)
+
+	hashObj := storage.NewHashObject()
+	hash, _ := hashObj.GetHash()
+	hash.Set([]byte("f1"), []byte("v1"))
+	hash.Set([]byte("f2"), []byte("v2"))
+	db3.Set("hash", hashObj)
+
+	// 有字段过期时间的哈希使用 HASH_METADATA 类型保存
+	volatileHashObj := storage.NewHashObject()
+	volatileHash, _ := volatileHashObj.GetHash()
+	volatileHash.Set([]byte("f1"), []byte("v1"))
+	volatileHash.Set([]byte("f2"), []byte("v2"))
+	volatileHash.SetFieldExpire([]byte("f1"), time.Now().UnixMilli()+100000)
+	db3.Set("volatile-hash", volatileHashObj)
+
+	db0.Set("volatile", storage.NewStringObject([]byte("v")))
+	db0.ExpireAt("volatile", time.Now().Unix()+100)
+	db3.ExpireAt("hash", time.Now().Unix()+200)
+
+	return server
+}
+
+// dumpDb 将数据库内容展开为可比较的字符串（类型、值、过期时间）
+func dumpDb(t *testing.T, db *storage.RedisDb) map[string]string {
+	t.Helper()
+	result := make(map[string]string)
+	for _, key := range db.Keys("*") {
+		obj, err := db.Peek(key)
+		if err != nil {
+			t.Fatalf("Peek %s: %v", key, err)
+		}
+
+		var value string
+		switch obj.Type {
+		case storage.OBJ_STRING:
+			val, _ := obj.GetStringValue()
+			value = string(val)
+		case storage.OBJ_LIST:
+			list, _ := obj.GetList()
+			items, _ := list.Range(0, -1)
+			value = fmt.Sprintf("%q", items)
+		case storage.OBJ_SET:
+			set, _ := obj.GetSet()
+			members := make(map[string]bool)
+			for _, m := range set.Members() {
+				members[string(m)] = true
+			}
+			value = fmt.Sprint(members)
+		case storage.OBJ_ZSET:
+			zset, _ := obj.GetZSet()
+			entries, _ := zset.Range(0, -1, false)
+			for _, e := range entries {
+				value += fmt.Sprintf("%s=%v,", e.Member(), e.Score())
+			}
+		case storage.OBJ_HASH:
+			hash, _ := obj.GetHash()
+			fields := make(map[string]string)
+			for _, e := range hash.GetAll() {
+				expireAt, _ := hash.FieldExpireAt(e.Field())
+				fields[string(e.Field())] = fmt.Sprintf("%s@%d", e.Value(), expireAt)
+			}
+			value = fmt.Sprint(fields)
+		}
+
+		expireAt, _ := db.GetExpireAt(key)
+		result[key] = fmt.Sprintf("%s|%s|%d", obj.TypeString(), value, expireAt)
+	}
+	return result
+}
+
+// TestRDBRoundTrip 测试保存所有类型（含过期时间）后加载得到相同的数据
+func TestRDBRoundTrip(t *testing.T) {
+	original := populateRDBServer(t)
+	filename := filepath.Join(t.TempDir(), "dump.rdb")
+	if err := NewRDBEncoder(nil).Save(original, filename); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	loaded := storage.NewRedisServer(16)
+	if err := NewRDBDecoder(nil).Load(loaded, filename); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	for i := 0; i < 16; i++ {
+		want, _ := original.GetDb(i)
+		got, _ := loaded.GetDb(i)
+		wantDump, gotDump := dumpDb(t, want), dumpDb(t, got)
+		if len(wantDump) != len(gotDump) {
+			t.Fatalf("db %d: expected %d keys, got %d", i, len(wantDump), len(gotDump))
+		}
+		for key, value := range wantDump {
+			if gotDump[key] != value {
+				t.Fatalf("db %d key %s: expected %s, got %s", i, key, value, gotDump[key])
+			}
+		}
+	}
+}
+
+// TestDumpRestoreHashFieldTTL 测试 DumpObject/RestoreObject 保留哈希字段的过期时间
+func TestDumpRestoreHashFieldTTL(t *testing.T) {
+	hashObj := storage.NewHashObject()
+	hash, _ := hashObj.GetHash()
+	hash.Set([]byte("volatile"), []byte("1"))
+	hash.Set([]byte("persistent"), []byte("2"))
+	expireAt := time.Now().UnixMilli() + 60000
+	hash.SetFieldExpire([]byte("volatile"), expireAt)
+
+	data, err := DumpObject(hashObj)
+	if err != nil {
+		t.Fatalf("DumpObject failed: %v", err)
+	}
+	if data[0] != RDB_TYPE_HASH_METADATA {
+		t.Fatalf("Expected type %d, got %d", RDB_TYPE_HASH_METADATA, data[0])
+	}
+
+	restored, err := RestoreObject(data)
+	if err != nil {
+		t.Fatalf("RestoreObject failed: %v", err)
+	}
+	if restored.Type != storage.OBJ_HASH {
+		t.Fatalf("Expected a hash, got type %d", restored.Type)
+	}
+	got, _ := restored.GetHash()
+	if v, _ := got.Get([]byte("volatile")); string(v) != "1" {
+		t.Fatalf("Expected volatile=1, got %q", v)
+	}
+	if at, ok := got.FieldExpireAt([]byte("volatile")); !ok || at != expireAt {
+		t.Fatalf("Expected field TTL %d, got %d (%v)", expireAt, at, ok)
+	}
+	if _, ok := got.FieldExpireAt([]byte("persistent")); ok {
+		t.Fatal("Expected the persistent field to stay persistent")
+	}
+}
+
+// TestRDBLoadErrors 测试无效文件、截断文件和加载时已过期的键
+func TestRDBLoadErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	invalid := filepath.Join(dir, "invalid.rdb")
+	os.WriteFile(invalid, []byte("NOTREDIS0009"), 0644)
+	if err := NewRDBDecoder(nil).Load(storage.NewRedisServer(16), invalid); err != ErrRDBInvalid {
+		t.Fatalf("Expected ErrRDBInvalid, got %v", err)
+	}
+
+	filename := filepath.Join(dir, "dump.rdb")
+	if err := NewRDBEncoder(nil).Save(populateRDBServer(t), filename); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	data, _ := os.ReadFile(filename)
+
+	// 文件在 EOF 操作码之前结束
+	truncated := filepath.Join(dir, "truncated.rdb")
+	os.WriteFile(truncated, data[:len(data)/2], 0644)
+	if err := NewRDBDecoder(nil).Load(storage.NewRedisServer(16), truncated); !errors.Is(err, ErrRDBTruncated) {
+		t.Fatalf("Expected ErrRDBTruncated, got %v", err)
+	}
+
+	// 保存后才过期的键不会被加载
+	server := storage.NewRedisServer(16)
+	db, _ := server.GetDb(0)
+	db.Set("stale", storage.NewStringObject([]byte("v")))
+	db.ExpireAt("stale", time.Now().Unix()+1)
+	if err := NewRDBEncoder(nil).Save(server, filename); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	time.Sleep(1100 * time.Millisecond)
+
+	loaded := storage.NewRedisServer(16)
+	if err := NewRDBDecoder(nil).Load(loaded, filename); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	loadedDb, _ := loaded.GetDb(0)
+	if loadedDb.DBSize() != 0 {
+		t.Fatalf("Expected expired key to be skipped, dbsize=%d", loadedDb.DBSize())
+	}
+}
+
+// TestRDBChecksum 测试校验和：正确的文件通过校验，损坏一个字节后加载失败，关闭校验时写入 0 并跳过校验
+func TestRDBChecksum(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "dump.rdb")
+	if err := NewRDBEncoder(nil).Save(populateRDBServer(t), filename); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	data, _ := os.ReadFile(filename)
+
+	checksum := binary.LittleEndian.Uint64(data[len(data)-8:])
+	if checksum == 0 || checksum != utils.CRC64(0, data[:len(data)-8]) {
+		t.Fatalf("Expected CRC64 of the payload, got %#x", checksum)
+	}
+	if err := NewRDBDecoder(nil).Load(storage.NewRedisServer(16), filename); err != nil {
+		t.Fatalf("Load of a valid file failed: %v", err)
+	}
+
+	// 修改一个值的字节（"hello" -> "jello"），结构仍然合法，只有校验和能发现
+	corrupted := filepath.Join(dir, "corrupted.rdb")
+	index := bytes.Index(data, []byte("hello"))
+	bad := bytes.Clone(data)
+	bad[index] = 'j'
+	os.WriteFile(corrupted, bad, 0644)
+	if err := NewRDBDecoder(nil).Load(storage.NewRedisServer(16), corrupted); err != ErrRDBChecksum {
+		t.Fatalf("Expected ErrRDBChecksum, got %v", err)
+	}
+
+	// 加载方关闭校验时不检查
+	decoder := NewRDBDecoder(nil)
+	decoder.SetChecksum(false)
+	if err := decoder.Load(storage.NewRedisServer(16), corrupted); err != nil {
+		t.Fatalf("Expected load without verification to succeed, got %v", err)
+	}
+
+	// 保存方关闭校验时写入 0，加载时跳过校验
+	encoder := NewRDBEncoder(nil)
+	encoder.SetChecksum(false)
+	if err := encoder.Save(populateRDBServer(t), filename); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	data, _ = os.ReadFile(filename)
+	if checksum := binary.LittleEndian.Uint64(data[len(data)-8:]); checksum != 0 {
+		t.Fatalf("Expected zero checksum with rdbchecksum no, got %#x", checksum)
+	}
+	if err := NewRDBDecoder(nil).Load(storage.NewRedisServer(16), filename); err != nil {
+		t.Fatalf("Load of a file without checksum failed: %v", err)
+	}
+}
@@ -0,0 +1,191 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"strconv"
+)
+
+/*
+ * ============================================================================
+ * 内联命令（Inline Command）
+ * ============================================================================
+ *
+ * 除了 RESP 数组形式，Redis 还接受以空白分隔、以换行结束的文本命令，
+ * 方便用 telnet 直接调试：
+ *
+ *   PING\r\n
+ *   SET foo "hello world"\r\n
+ *
+ * 【解析规则】（与 Redis 的 sdssplitargs 一致）
+ * - 参数之间以空白分隔，\r 可以省略
+ * - "..." 内支持 \n \r \t \b \a \" \\ 和 \xHH 转义
+ * - '...' 内只支持 \' 转义
+ * - 引号结束后必须紧跟空白或行尾，否则视为引号不匹配
+ * - 空行被忽略
+ * - 单行超过 PROTO_INLINE_MAX_SIZE 时报错，避免无限读取
+ */
+
+// PROTO_INLINE_MAX_SIZE 内联命令的最大长度（字节）
+const PROTO_INLINE_MAX_SIZE = 64 * 1024
+
+var (
+	ErrInlineTooBig     = errors.New("Protocol error: too big inline request")
+	ErrUnbalancedQuotes = errors.New("Protocol error: unbalanced quotes in request")
+)
+
+// DecodeRequest 解码客户端请求：首字节为 * 时按 RESP 数组解析，否则按内联命令解析
+// 返回的请求总是 RESP 数组
+func DecodeRequest(reader *bufio.Reader) (*RESPValue, error) {
+	for {
+		b, err := reader.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		if b[0] == byte(RESP_ARRAY) {
+			return Decode(reader)
+		}
+
+		line, err := readInlineLine(reader)
+		if err != nil {
+			return nil, err
+		}
+		args, err := SplitInlineArgs(line)
+		if err != nil {
+			return nil, err
+		}
+		if len(args) == 0 {
+			// 空行，继续读取下一条请求
+			continue
+		}
+
+		array := make([]*RESPValue, len(args))
+		for i, arg := range args {
+			array[i] = NewBulkString(arg)
+		}
+		return NewArray(array), nil
+	}
+}
+
+// readInlineLine 读取一行（不含行尾的 \r\n），超过最大长度时返回错误
+func readInlineLine(reader *bufio.Reader) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if len(line)+len(chunk) > PROTO_INLINE_MAX_SIZE {
+			return nil, ErrInlineTooBig
+		}
+		line = append(line, chunk...)
+		if err == nil {
+			break
+		}
+		if err != bufio.ErrBufferFull {
+			return nil, err
+		}
+	}
+
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	line = bytes.TrimSuffix(line, []byte("\r"))
+	return line, nil
+}
+
+// SplitInlineArgs 将内联命令拆分为参数列表
+func SplitInlineArgs(line []byte) ([]string, error) {
+	args := make([]string, 0)
+	i := 0
+	for {
+		// 跳过空白
+		for i < len(line) && isInlineSpace(line[i]) {
+			i++
+		}
+		if i >= len(line) {
+			return args, nil
+		}
+
+		var current []byte
+		inDouble, inSingle := false, false
+		done := false
+		for !done {
+			if inDouble {
+				if i >= len(line) {
+					return nil, ErrUnbalancedQuotes
+				}
+				switch {
+				case line[i] == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHexDigit(line[i+2]) && isHexDigit(line[i+3]):
+					n, _ := strconv.ParseUint(string(line[i+2:i+4]), 16, 8)
+					current = append(current, byte(n))
+					i += 3
+				case line[i] == '\\' && i+1 < len(line):
+					i++
+					switch line[i] {
+					case 'n':
+						current = append(current, '\n')
+					case 'r':
+						current = append(current, '\r')
+					case 't':
+						current = append(current, '\t')
+					case 'b':
+						current = append(current, '\b')
+					case 'a':
+						current = append(current, '\a')
+					default:
+						current = append(current, line[i])
+					}
+				case line[i] == '"':
+					// 结束引号后必须是空白或行尾
+					if i+1 < len(line) && !isInlineSpace(line[i+1]) {
+						return nil, ErrUnbalancedQuotes
+					}
+					done = true
+				default:
+					current = append(current, line[i])
+				}
+			} else if inSingle {
+				if i >= len(line) {
+					return nil, ErrUnbalancedQuotes
+				}
+				switch {
+				case line[i] == '\\' && i+1 < len(line) && line[i+1] == '\'':
+					i++
+					current = append(current, '\'')
+				case line[i] == '\'':
+					if i+1 < len(line) && !isInlineSpace(line[i+1]) {
+						return nil, ErrUnbalancedQuotes
+					}
+					done = true
+				default:
+					current = append(current, line[i])
+				}
+			} else {
+				if i >= len(line) {
+					break
+				}
+				switch line[i] {
+				case ' ', '\n', '\r', '\t', 0:
+					done = true
+				case '"':
+					inDouble = true
+				case '\'':
+					inSingle = true
+				default:
+					current = append(current, line[i])
+				}
+			}
+			if i < len(line) {
+				i++
+			}
+		}
+		args = append(args, string(current))
+	}
+}
+
+// isInlineSpace 是否为参数分隔符
+func isInlineSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\v' || c == '\f'
+}
+
+// isHexDigit 是否为十六进制数字
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
@@ -0,0 +1,96 @@
+package protocol
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+// decodeRequests 从输入中依次解码所有请求，返回每条请求的参数
+func decodeRequests(t *testing.T, input string) [][]string {
+	t.Helper()
+	reader := bufio.NewReader(strings.NewReader(input))
+	var requests [][]string
+	for {
+		req, err := DecodeRequest(reader)
+		if err != nil {
+			break
+		}
+		if req.Type != RESP_ARRAY {
+			t.Fatalf("Expected array request, got %v", req)
+		}
+		args := make([]string, len(req.Array))
+		for i, arg := range req.Array {
+			if arg.Type != RESP_BULK_STRING {
+				t.Fatalf("Expected bulk string argument, got %v", arg)
+			}
+			args[i] = arg.Str
+		}
+		requests = append(requests, args)
+	}
+	return requests
+}
+
+func assertArgs(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("Expected %q, got %q", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Expected %q, got %q", want, got)
+		}
+	}
+}
+
+func TestDecodeInlineRequest(t *testing.T) {
+	requests := decodeRequests(t, "SET foo bar\r\nGET foo\r\n")
+	if len(requests) != 2 {
+		t.Fatalf("Expected 2 requests, got %d", len(requests))
+	}
+	assertArgs(t, requests[0], "SET", "foo", "bar")
+	assertArgs(t, requests[1], "GET", "foo")
+
+	// 行尾可以只有 \n，空行被忽略，可以与 RESP 数组混用
+	requests = decodeRequests(t, "PING\n\r\n  \r\n*2\r\n$3\r\nGET\r\n$1\r\nk\r\nECHO   x\r\n")
+	if len(requests) != 3 {
+		t.Fatalf("Expected 3 requests, got %d", len(requests))
+	}
+	assertArgs(t, requests[0], "PING")
+	assertArgs(t, requests[1], "GET", "k")
+	assertArgs(t, requests[2], "ECHO", "x")
+}
+
+func TestSplitInlineArgs(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{`SET key "hello world"`, []string{"SET", "key", "hello world"}},
+		{`SET key 'it\'s'`, []string{"SET", "key", "it's"}},
+		{`SET key "a\nb\x41\"" ""`, []string{"SET", "key", "a\nbA\"", ""}},
+		{`SET key 'no\nescape'`, []string{"SET", "key", `no\nescape`}},
+		{"\tSET  key\tvalue  ", []string{"SET", "key", "value"}},
+	}
+	for _, tt := range tests {
+		got, err := SplitInlineArgs([]byte(tt.line))
+		if err != nil {
+			t.Fatalf("SplitInlineArgs(%q) failed: %v", tt.line, err)
+		}
+		assertArgs(t, got, tt.want...)
+	}
+
+	for _, line := range []string{`SET key "unterminated`, `SET key 'a'b`, `SET key "a"b`} {
+		if _, err := SplitInlineArgs([]byte(line)); err != ErrUnbalancedQuotes {
+			t.Fatalf("Expected unbalanced quotes for %q, got %v", line, err)
+		}
+	}
+}
+
+func TestDecodeInlineTooBig(t *testing.T) {
+	input := "SET key " + strings.Repeat("x", PROTO_INLINE_MAX_SIZE) + "\r\n"
+	reader := bufio.NewReader(strings.NewReader(input))
+	if _, err := DecodeRequest(reader); err != ErrInlineTooBig {
+		t.Fatalf("Expected too big inline request error, got %v", err)
+	}
+}
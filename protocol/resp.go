@@ -26,6 +26,17 @@ import (
  * - 整数: :<number>\r\n
  * - 批量字符串: $<length>\r\n<data>\r\n
  * - 数组: *<count>\r\n<elements>...
+ *
+ * 【RESP3】
+ * 客户端通过 HELLO 3 切换到 RESP3 后，还会用到以下类型（见 resp3.go）：
+ * - 空值: _\r\n
+ * - 浮点数: ,<double>\r\n
+ * - 布尔: #t\r\n / #f\r\n
+ * - 大整数: (<number>\r\n
+ * - 原样字符串: =<length>\r\n<fmt>:<data>\r\n
+ * - 映射: %<pairs>\r\n<key><value>...
+ * - 集合: ~<count>\r\n<elements>...
+ * 对 RESP2 客户端编码时，这些类型会降级为对应的 RESP2 类型
  */
 
 var (
@@ -42,15 +53,27 @@ const (
 	RESP_INTEGER       RESPType = ':'
 	RESP_BULK_STRING   RESPType = '$'
 	RESP_ARRAY         RESPType = '*'
+
+	// RESP3 类型
+	RESP_NULL       RESPType = '_'
+	RESP_DOUBLE     RESPType = ','
+	RESP_BOOLEAN    RESPType = '#'
+	RESP_BIG_NUMBER RESPType = '('
+	RESP_VERBATIM   RESPType = '='
+	RESP_MAP        RESPType = '%'
+	RESP_SET        RESPType = '~'
 )
 
 // RESPValue RESP 值
 type RESPValue struct {
-	Type  RESPType
-	Str   string
-	Int   int64
-	Array []*RESPValue
-	Null  bool // 用于 nil 批量字符串
+	Type   RESPType
+	Str    string
+	Int    int64
+	Array  []*RESPValue // 数组/集合元素；映射按 key、value 交替存放
+	Null   bool         // 用于 nil 批量字符串 / nil 数组
+	Double float64      // RESP3 浮点数
+	Bool   bool         // RESP3 布尔
+	Format string       // RESP3 原样字符串的格式（如 txt、mkd）
 }
 
 // NewSimpleString 创建简单字符串
@@ -102,10 +125,28 @@ func NewArray(elements []*RESPValue) *RESPValue {
 	}
 }
 
-// Encode 编码为 RESP 格式
+// NewNullArray 创建空数组（RESP2 编码为 *-1，如事务被 WATCH 中止时 EXEC 的回复）
+func NewNullArray() *RESPValue {
+	return &RESPValue{
+		Type: RESP_ARRAY,
+		Null: true,
+	}
+}
+
+// Encode 编码为 RESP2 格式（RESP3 类型降级为对应的 RESP2 类型）
 func (v *RESPValue) Encode() []byte {
+	return v.EncodeProto(2)
+}
+
+// EncodeProto 按协议版本（2 或 3）编码
+func (v *RESPValue) EncodeProto(proto int) []byte {
 	var buf bytes.Buffer
+	v.encodeTo(&buf, proto)
+	return buf.Bytes()
+}
 
+// encodeTo 将值编码写入 buf
+func (v *RESPValue) encodeTo(buf *bytes.Buffer, proto int) {
 	switch v.Type {
 	case RESP_SIMPLE_STRING:
 		buf.WriteByte('+')
@@ -124,25 +165,25 @@ func (v *RESPValue) Encode() []byte {
 
 	case RESP_BULK_STRING:
 		if v.Null {
-			buf.WriteString("$-1\r\n")
+			writeNull(buf, proto)
 		} else {
-			buf.WriteByte('$')
-			buf.WriteString(strconv.Itoa(len(v.Str)))
-			buf.WriteString("\r\n")
-			buf.WriteString(v.Str)
-			buf.WriteString("\r\n")
+			writeBulkString(buf, v.Str)
 		}
 
 	case RESP_ARRAY:
-		buf.WriteByte('*')
-		buf.WriteString(strconv.Itoa(len(v.Array)))
-		buf.WriteString("\r\n")
-		for _, elem := range v.Array {
-			buf.Write(elem.Encode())
+		if v.Null {
+			if proto >= 3 {
+				buf.WriteString("_\r\n")
+			} else {
+				buf.WriteString("*-1\r\n")
+			}
+		} else {
+			writeAggregate(buf, '*', len(v.Array), v.Array, proto)
 		}
-	}
 
-	return buf.Bytes()
+	default:
+		v.encodeRESP3To(buf, proto)
+	}
 }
 
 // Decode 从 Reader 解码 RESP 值
@@ -235,6 +276,7 @@ func Decode(reader *bufio.Reader) (*RESPValue, error) {
 			return &RESPValue{
 				Type:  RESP_ARRAY,
 				Array: nil,
+				Null:  true,
 			}, nil
 		}
 
@@ -253,7 +295,7 @@ func Decode(reader *bufio.Reader) (*RESPValue, error) {
 		}, nil
 
 	default:
-		return nil, ErrInvalidFormat
+		return decodeRESP3(reader, line)
 	}
 }
 
@@ -271,6 +313,9 @@ func (v *RESPValue) ToString() string {
 	if v.Type == RESP_SIMPLE_STRING {
 		return v.Str
 	}
+	if v.Type == RESP_DOUBLE || v.Type == RESP_BIG_NUMBER || v.Type == RESP_VERBATIM {
+		return v.Str
+	}
 	return ""
 }
 
@@ -0,0 +1,267 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+/*
+ * ============================================================================
+ * RESP3 类型
+ * ============================================================================
+ *
+ * 【降级规则】（对 RESP2 客户端编码时）
+ * - 空值        -> $-1（nil 批量字符串）
+ * - 浮点数      -> 批量字符串（inf/-inf 输出为 inf/-inf）
+ * - 布尔        -> 整数 1/0
+ * - 大整数      -> 批量字符串
+ * - 原样字符串  -> 批量字符串（去掉格式前缀）
+ * - 映射        -> key、value 交替的数组
+ * - 集合        -> 数组
+ */
+
+// NewNull 创建空值（RESP3 的 _，RESP2 下为 nil 批量字符串）
+func NewNull() *RESPValue {
+	return &RESPValue{
+		Type: RESP_NULL,
+		Null: true,
+	}
+}
+
+// NewDouble 创建浮点数（Str 同时保存其文本形式，便于按字符串读取）
+func NewDouble(f float64) *RESPValue {
+	return &RESPValue{
+		Type:   RESP_DOUBLE,
+		Str:    FormatDouble(f),
+		Double: f,
+	}
+}
+
+// NewBoolean 创建布尔值
+func NewBoolean(b bool) *RESPValue {
+	return &RESPValue{
+		Type: RESP_BOOLEAN,
+		Bool: b,
+	}
+}
+
+// NewBigNumber 创建大整数（num 为十进制整数字符串）
+func NewBigNumber(num string) *RESPValue {
+	return &RESPValue{
+		Type: RESP_BIG_NUMBER,
+		Str:  num,
+	}
+}
+
+// NewVerbatimString 创建原样字符串（format 为三个字符的格式，如 txt、mkd）
+func NewVerbatimString(format, s string) *RESPValue {
+	return &RESPValue{
+		Type:   RESP_VERBATIM,
+		Str:    s,
+		Format: format,
+	}
+}
+
+// NewMap 创建映射，pairs 按 key、value 交替存放
+func NewMap(pairs []*RESPValue) *RESPValue {
+	return &RESPValue{
+		Type:  RESP_MAP,
+		Array: pairs,
+	}
+}
+
+// NewSet 创建集合
+func NewSet(elements []*RESPValue) *RESPValue {
+	return &RESPValue{
+		Type:  RESP_SET,
+		Array: elements,
+	}
+}
+
+// FormatDouble 格式化浮点数（无穷大为 inf/-inf，与 Redis 一致）
+func FormatDouble(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// encodeRESP3To 编码 RESP3 类型，proto 为 2 时降级为 RESP2 类型
+func (v *RESPValue) encodeRESP3To(buf *bytes.Buffer, proto int) {
+	switch v.Type {
+	case RESP_NULL:
+		writeNull(buf, proto)
+
+	case RESP_DOUBLE:
+		if proto < 3 {
+			writeBulkString(buf, v.Str)
+			return
+		}
+		buf.WriteByte(',')
+		buf.WriteString(v.Str)
+		buf.WriteString("\r\n")
+
+	case RESP_BOOLEAN:
+		if proto < 3 {
+			if v.Bool {
+				buf.WriteString(":1\r\n")
+			} else {
+				buf.WriteString(":0\r\n")
+			}
+			return
+		}
+		if v.Bool {
+			buf.WriteString("#t\r\n")
+		} else {
+			buf.WriteString("#f\r\n")
+		}
+
+	case RESP_BIG_NUMBER:
+		if proto < 3 {
+			writeBulkString(buf, v.Str)
+			return
+		}
+		buf.WriteByte('(')
+		buf.WriteString(v.Str)
+		buf.WriteString("\r\n")
+
+	case RESP_VERBATIM:
+		if proto < 3 {
+			writeBulkString(buf, v.Str)
+			return
+		}
+		buf.WriteByte('=')
+		buf.WriteString(strconv.Itoa(len(v.Str) + 4))
+		buf.WriteString("\r\n")
+		buf.WriteString(v.Format)
+		buf.WriteByte(':')
+		buf.WriteString(v.Str)
+		buf.WriteString("\r\n")
+
+	case RESP_MAP:
+		if proto < 3 {
+			writeAggregate(buf, '*', len(v.Array), v.Array, proto)
+			return
+		}
+		writeAggregate(buf, '%', len(v.Array)/2, v.Array, proto)
+
+	case RESP_SET:
+		if proto < 3 {
+			writeAggregate(buf, '*', len(v.Array), v.Array, proto)
+			return
+		}
+		writeAggregate(buf, '~', len(v.Array), v.Array, proto)
+	}
+}
+
+// writeNull 写入空值：RESP3 为 _，RESP2 为 nil 批量字符串
+func writeNull(buf *bytes.Buffer, proto int) {
+	if proto >= 3 {
+		buf.WriteString("_\r\n")
+	} else {
+		buf.WriteString("$-1\r\n")
+	}
+}
+
+// writeBulkString 写入批量字符串
+func writeBulkString(buf *bytes.Buffer, s string) {
+	buf.WriteByte('$')
+	buf.WriteString(strconv.Itoa(len(s)))
+	buf.WriteString("\r\n")
+	buf.WriteString(s)
+	buf.WriteString("\r\n")
+}
+
+// writeAggregate 写入聚合类型（数组、映射、集合）的头部和所有元素
+func writeAggregate(buf *bytes.Buffer, prefix byte, count int, elements []*RESPValue, proto int) {
+	buf.WriteByte(prefix)
+	buf.WriteString(strconv.Itoa(count))
+	buf.WriteString("\r\n")
+	for _, elem := range elements {
+		elem.encodeTo(buf, proto)
+	}
+}
+
+// decodeRESP3 解码 RESP3 类型（line 为去掉 \r\n 的首行）
+func decodeRESP3(reader *bufio.Reader, line []byte) (*RESPValue, error) {
+	payload := string(line[1:])
+
+	switch RESPType(line[0]) {
+	case RESP_NULL:
+		return NewNull(), nil
+
+	case RESP_DOUBLE:
+		var f float64
+		switch strings.ToLower(payload) {
+		case "inf":
+			f = math.Inf(1)
+		case "-inf":
+			f = math.Inf(-1)
+		case "nan":
+			f = math.NaN()
+		default:
+			parsed, err := strconv.ParseFloat(payload, 64)
+			if err != nil {
+				return nil, ErrInvalidFormat
+			}
+			f = parsed
+		}
+		return NewDouble(f), nil
+
+	case RESP_BOOLEAN:
+		switch payload {
+		case "t":
+			return NewBoolean(true), nil
+		case "f":
+			return NewBoolean(false), nil
+		}
+		return nil, ErrInvalidFormat
+
+	case RESP_BIG_NUMBER:
+		return NewBigNumber(payload), nil
+
+	case RESP_VERBATIM:
+		length, err := strconv.Atoi(payload)
+		if err != nil || length < 4 {
+			return nil, ErrInvalidFormat
+		}
+		data := make([]byte, length+2)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		if data[3] != ':' || data[length] != '\r' || data[length+1] != '\n' {
+			return nil, ErrInvalidFormat
+		}
+		return NewVerbatimString(string(data[:3]), string(data[4:length])), nil
+
+	case RESP_MAP, RESP_SET:
+		count, err := strconv.Atoi(payload)
+		if err != nil || count < 0 {
+			return nil, ErrInvalidFormat
+		}
+		if line[0] == byte(RESP_MAP) {
+			count *= 2
+		}
+		elements := make([]*RESPValue, count)
+		for i := range elements {
+			if elements[i], err = Decode(reader); err != nil {
+				return nil, err
+			}
+		}
+		if line[0] == byte(RESP_MAP) {
+			return NewMap(elements), nil
+		}
+		return NewSet(elements), nil
+	}
+
+	return nil, ErrInvalidFormat
+}
@@ -0,0 +1,86 @@
+package protocol
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEncodeRESP3(t *testing.T) {
+	tests := []struct {
+		name  string
+		value *RESPValue
+		resp3 string
+		resp2 string
+	}{
+		{"null", NewNull(), "_\r\n", "$-1\r\n"},
+		{"null bulk", NewNullBulkString(), "_\r\n", "$-1\r\n"},
+		{"null array", NewNullArray(), "_\r\n", "*-1\r\n"},
+		{"double", NewDouble(1.5), ",1.5\r\n", "$3\r\n1.5\r\n"},
+		{"double inf", NewDouble(math.Inf(-1)), ",-inf\r\n", "$4\r\n-inf\r\n"},
+		{"true", NewBoolean(true), "#t\r\n", ":1\r\n"},
+		{"false", NewBoolean(false), "#f\r\n", ":0\r\n"},
+		{"big number", NewBigNumber("3492890328409238509324850943850943825024385"),
+			"(3492890328409238509324850943850943825024385\r\n",
+			"$43\r\n3492890328409238509324850943850943825024385\r\n"},
+		{"verbatim", NewVerbatimString("txt", "Some string"), "=15\r\ntxt:Some string\r\n", "$11\r\nSome string\r\n"},
+		{"map", NewMap([]*RESPValue{NewBulkString("a"), NewInteger(1), NewBulkString("b"), NewDouble(2)}),
+			"%2\r\n$1\r\na\r\n:1\r\n$1\r\nb\r\n,2\r\n",
+			"*4\r\n$1\r\na\r\n:1\r\n$1\r\nb\r\n$1\r\n2\r\n"},
+		{"set", NewSet([]*RESPValue{NewBulkString("x"), NewBoolean(true)}),
+			"~2\r\n$1\r\nx\r\n#t\r\n",
+			"*2\r\n$1\r\nx\r\n:1\r\n"},
+		{"nested", NewArray([]*RESPValue{NewMap([]*RESPValue{NewBulkString("k"), NewNull()})}),
+			"*1\r\n%1\r\n$1\r\nk\r\n_\r\n",
+			"*1\r\n*2\r\n$1\r\nk\r\n$-1\r\n"},
+	}
+
+	for _, tt := range tests {
+		if got := string(tt.value.EncodeProto(3)); got != tt.resp3 {
+			t.Errorf("%s: RESP3 expected %q, got %q", tt.name, tt.resp3, got)
+		}
+		if got := string(tt.value.EncodeProto(2)); got != tt.resp2 {
+			t.Errorf("%s: RESP2 expected %q, got %q", tt.name, tt.resp2, got)
+		}
+		if got := string(tt.value.Encode()); got != tt.resp2 {
+			t.Errorf("%s: Encode should default to RESP2, got %q", tt.name, got)
+		}
+	}
+}
+
+func TestDecodeRESP3(t *testing.T) {
+	value := NewMap([]*RESPValue{
+		NewBulkString("double"), NewDouble(-2.25),
+		NewBulkString("bool"), NewBoolean(true),
+		NewBulkString("big"), NewBigNumber("12345678901234567890"),
+		NewBulkString("verbatim"), NewVerbatimString("mkd", "# title"),
+		NewBulkString("set"), NewSet([]*RESPValue{NewInteger(1)}),
+		NewBulkString("null"), NewNull(),
+	})
+
+	decoded, err := DecodeFromBytes(value.EncodeProto(3))
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if decoded.Type != RESP_MAP || len(decoded.Array) != 12 {
+		t.Fatalf("Expected map with 6 pairs, got %+v", decoded)
+	}
+	fields := decoded.Array
+	if fields[1].Type != RESP_DOUBLE || fields[1].Double != -2.25 {
+		t.Fatalf("Unexpected double: %+v", fields[1])
+	}
+	if fields[3].Type != RESP_BOOLEAN || !fields[3].Bool {
+		t.Fatalf("Unexpected boolean: %+v", fields[3])
+	}
+	if fields[5].Type != RESP_BIG_NUMBER || fields[5].Str != "12345678901234567890" {
+		t.Fatalf("Unexpected big number: %+v", fields[5])
+	}
+	if fields[7].Type != RESP_VERBATIM || fields[7].Format != "mkd" || fields[7].Str != "# title" {
+		t.Fatalf("Unexpected verbatim string: %+v", fields[7])
+	}
+	if fields[9].Type != RESP_SET || len(fields[9].Array) != 1 || fields[9].Array[0].Int != 1 {
+		t.Fatalf("Unexpected set: %+v", fields[9])
+	}
+	if fields[11].Type != RESP_NULL || !fields[11].Null {
+		t.Fatalf("Unexpected null: %+v", fields[11])
+	}
+}
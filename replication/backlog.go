@@ -0,0 +1,65 @@
+package replication
+
+/*
+ * ============================================================================
+ * 复制积压缓冲区（Replication Backlog）
+ * ============================================================================
+ *
+ * 固定大小的环形缓冲区，保存最近传播给从节点的命令流。
+ * 从节点断线重连时带上复制 ID 和偏移量，只要缺失的部分仍在缓冲区中，
+ * 主节点就回复 +CONTINUE 并只发送缺失的命令（部分同步），不需要重新发送 RDB 快照。
+ *
+ * 缓冲区保存的是复制偏移量 (end-histlen, end] 范围内的字节，
+ * end 为主节点当前的复制偏移量。
+ */
+
+// DEFAULT_REPL_BACKLOG_SIZE 默认积压缓冲区大小（repl-backlog-size）
+const DEFAULT_REPL_BACKLOG_SIZE = 1024 * 1024
+
+// replBacklog 复制积压缓冲区
+type replBacklog struct {
+	buf     []byte
+	idx     int   // 下一个写入位置
+	histlen int64 // 已保存的字节数（不超过 len(buf)）
+}
+
+// newReplBacklog 创建积压缓冲区
+func newReplBacklog(size int64) *replBacklog {
+	if size <= 0 {
+		size = DEFAULT_REPL_BACKLOG_SIZE
+	}
+	return &replBacklog{buf: make([]byte, size)}
+}
+
+// feed 追加命令流数据，缓冲区满时覆盖最旧的数据
+func (b *replBacklog) feed(data []byte) {
+	for len(data) > 0 {
+		n := copy(b.buf[b.idx:], data)
+		b.idx = (b.idx + n) % len(b.buf)
+		data = data[n:]
+		b.histlen += int64(n)
+	}
+	if b.histlen > int64(len(b.buf)) {
+		b.histlen = int64(len(b.buf))
+	}
+}
+
+// since 返回 (offset, end] 范围内的数据，end 为当前复制偏移量；
+// offset 早于缓冲区保存的范围或晚于 end 时返回 false
+func (b *replBacklog) since(offset, end int64) ([]byte, bool) {
+	if offset < end-b.histlen || offset > end {
+		return nil, false
+	}
+
+	size := len(b.buf)
+	n := int(end - offset)
+	start := ((b.idx-n)%size + size) % size
+	data := make([]byte, 0, n)
+	if start+n <= size {
+		data = append(data, b.buf[start:start+n]...)
+	} else {
+		data = append(data, b.buf[start:]...)
+		data = append(data, b.buf[:n-(size-start)]...)
+	}
+	return data, true
+}
@@ -2,8 +2,13 @@ package replication
 
 import (
 	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"net"
+	"sort"
+	"strconv"
 	"sync"
 
 	"github.com/code-100-precent/LingCache/persistence"
@@ -25,6 +30,22 @@ import (
  * - REPLCONF: 配置复制
  * - PSYNC: 部分同步请求
  * - FULLRESYNC: 全量同步
+ *
+ * 【全量同步】
+ * 从节点发送 PSYNC 后，主节点回复 +FULLRESYNC <replid> <offset>，
+ * 接着以 $<len>\r\n<RDB 数据> 的形式发送当前键空间的快照（数据后没有 \r\n），
+ * 之后持续发送写命令。快照是时间点副本：在写屏障内（没有执行了一半、尚未传播的写命令）
+ * 深拷贝整个键空间并记下此时的复制偏移量 offset，再在屏障外把副本编码为 RDB。
+ * 之后传播的命令先缓存在从节点的 pending 中，快照发送完后再一并发出，
+ * 保证快照恰好包含 offset 之前的所有修改，命令流从 offset 开始连续且不重复。
+ *
+ * 复制偏移量是主节点发给从节点的命令流的字节数，主从按同样的方式累加，
+ * 从节点通过 REPLCONF ACK 上报自己的偏移量（WAIT 依赖）。
+ *
+ * 【部分同步】
+ * 从节点重连时发送 PSYNC <replid> <offset>（offset 为下一个需要的字节，即已处理偏移量 + 1）。
+ * replid 与主节点一致且缺失的数据仍在积压缓冲区中时，主节点回复 +CONTINUE <replid>，
+ * 然后只发送缺失的命令；否则退回全量同步。
  */
 
 // Master 主节点
@@ -32,17 +53,42 @@ type Master struct {
 	server      *storage.RedisServer
 	replicas    map[*Replica]bool // 从节点集合
 	mu          sync.RWMutex
-	replOffset  int64  // 复制偏移量
-	replBacklog []byte // 复制积压缓冲区
+	replID      string       // 复制 ID（40 个十六进制字符）
+	replOffset  int64        // 复制偏移量
+	replDb      int          // 命令流当前选择的数据库（-1 表示下一条命令前需要发送 SELECT）
+	backlog     *replBacklog // 复制积压缓冲区（第一个从节点连接时创建）
+	backlogSize int64        // repl-backlog-size
+	barrier     sync.Locker  // 写屏障：持有时没有已修改数据但尚未传播的写命令
+
+	syncFull       int64 // 全量同步次数
+	syncPartialOK  int64 // 接受的部分同步次数
+	syncPartialErr int64 // 拒绝的部分同步次数（转为全量同步）
 }
 
+// 从节点状态（INFO replication 中的 state）
+const (
+	REPLICA_STATE_WAIT_BGSAVE = "wait_bgsave" // 等待全量同步的 RDB 发送完成
+	REPLICA_STATE_ONLINE      = "online"      // 全量同步完成，接收增量命令
+)
+
 // Replica 从节点连接
 type Replica struct {
-	conn   net.Conn
-	writer *bufio.Writer
-	master *Master
-	offset int64
-	closed bool
+	conn    net.Conn
+	writer  *bufio.Writer
+	master  *Master
+	port    int    // 从节点的监听端口（REPLCONF listening-port）
+	state   string // 复制状态
+	pending []byte // 快照发送完成前传播的命令
+	offset  int64
+	closed  bool
+}
+
+// ReplicaInfo 从节点信息（INFO replication 的 slaveN 行）
+type ReplicaInfo struct {
+	IP     string
+	Port   int
+	State  string
+	Offset int64
 }
 
 // NewMaster 创建主节点
@@ -50,13 +96,86 @@ func NewMaster(server *storage.RedisServer) *Master {
 	return &Master{
 		server:      server,
 		replicas:    make(map[*Replica]bool),
+		replID:      newReplID(),
 		replOffset:  0,
-		replBacklog: make([]byte, 0),
+		replDb:      -1,
+		backlogSize: DEFAULT_REPL_BACKLOG_SIZE,
+	}
+}
+
+// SetWriteBarrier 设置写屏障，全量同步复制键空间时持有它，使快照与复制偏移量一致
+func (m *Master) SetWriteBarrier(barrier sync.Locker) {
+	m.barrier = barrier
+}
+
+// SetBacklogSize 设置积压缓冲区大小（repl-backlog-size），已有的缓冲区按新大小重建，之前的内容丢弃
+func (m *Master) SetBacklogSize(size int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if size <= 0 {
+		size = DEFAULT_REPL_BACKLOG_SIZE
+	}
+	if size == m.backlogSize {
+		return
+	}
+	m.backlogSize = size
+	if m.backlog != nil {
+		m.backlog = newReplBacklog(size)
+	}
+}
+
+// BacklogInfo 积压缓冲区的状态：是否已创建、大小、保存的字节数
+func (m *Master) BacklogInfo() (active bool, size int64, histlen int64) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.backlog == nil {
+		return false, m.backlogSize, 0
+	}
+	return true, m.backlogSize, m.backlog.histlen
+}
+
+// SyncStats 全量同步、接受的部分同步、拒绝的部分同步次数
+func (m *Master) SyncStats() (full, partialOK, partialErr int64) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.syncFull, m.syncPartialOK, m.syncPartialErr
+}
+
+// newReplID 生成随机的复制 ID
+func newReplID() string {
+	buf := make([]byte, 20)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// ReplID 主节点的复制 ID
+func (m *Master) ReplID() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.replID
+}
+
+// ChangeReplID 生成新的复制 ID（从节点提升为主节点时调用），
+// 之前的命令流历史不再对应本节点的数据，之后用旧 ID 请求部分同步的从节点会进行全量同步
+func (m *Master) ChangeReplID() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.replID = newReplID()
+	m.replDb = -1
+}
+
+// DisconnectReplicas 断开所有从节点（本节点成为其它节点的从节点时调用，从节点重连后重新同步）
+func (m *Master) DisconnectReplicas() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for replica := range m.replicas {
+		m.removeReplicaLocked(replica)
 	}
 }
 
-// AddReplica 添加从节点
-func (m *Master) AddReplica(conn net.Conn) *Replica {
+// AddReplica 添加从节点并在后台开始全量同步，listeningPort 为从节点通过 REPLCONF listening-port 报告的端口
+func (m *Master) AddReplica(conn net.Conn, listeningPort int) *Replica {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -64,60 +183,159 @@ func (m *Master) AddReplica(conn net.Conn) *Replica {
 		conn:   conn,
 		writer: bufio.NewWriter(conn),
 		master: m,
+		port:   listeningPort,
+		state:  REPLICA_STATE_WAIT_BGSAVE,
 		offset: 0,
 		closed: false,
 	}
 
 	m.replicas[replica] = true
+	m.syncFull++
+	if m.backlog == nil {
+		m.backlog = newReplBacklog(m.backlogSize)
+	}
 
-	// 启动全量同步
+	// 启动全量同步（快照对应生成快照时的复制偏移量）
 	go m.fullResync(replica)
 
 	return replica
 }
 
-// fullResync 全量同步
+// TryPartialResync 尝试部分同步：replID 与主节点一致且 offset（下一个需要的字节）之后的数据
+// 仍在积压缓冲区中时，回复 +CONTINUE 并发送缺失的命令，返回 true；否则返回 false，调用方应进行全量同步
+func (m *Master) TryPartialResync(conn net.Conn, listeningPort int, replID string, offset int64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if replID == "?" {
+		return false
+	}
+	if replID != m.replID || m.backlog == nil {
+		m.syncPartialErr++
+		return false
+	}
+	missing, ok := m.backlog.since(offset-1, m.replOffset)
+	if !ok {
+		m.syncPartialErr++
+		return false
+	}
+
+	replica := &Replica{
+		conn:   conn,
+		writer: bufio.NewWriter(conn),
+		master: m,
+		port:   listeningPort,
+		state:  REPLICA_STATE_ONLINE,
+		offset: offset - 1,
+	}
+	fmt.Fprintf(replica.writer, "+CONTINUE %s\r\n", m.replID)
+	replica.writer.Write(missing)
+	if err := replica.writer.Flush(); err != nil {
+		return true
+	}
+
+	m.replicas[replica] = true
+	m.syncPartialOK++
+	return true
+}
+
+// fullResync 全量同步：发送 FULLRESYNC、RDB 快照，然后发送快照之后缓存的命令
 func (m *Master) fullResync(replica *Replica) {
-	// 发送 FULLRESYNC 响应
-	replID := "0000000000000000000000000000000000000000"
+	// 在写屏障内复制键空间：此前传播的命令都已包含在副本中，之后的命令都进入 pending
+	if m.barrier != nil {
+		m.barrier.Lock()
+	}
+	m.mu.Lock()
 	offset := m.replOffset
+	replica.pending = nil
+	m.replDb = -1 // 新从节点从快照开始接收命令流，下一条命令前重新发送 SELECT
+	m.mu.Unlock()
+	keyspace := m.server.Clone()
+	if m.barrier != nil {
+		m.barrier.Unlock()
+	}
 
-	response := fmt.Sprintf("FULLRESYNC %s %d\r\n", replID, offset)
-	replica.writer.WriteString(response)
-	replica.writer.Flush()
+	var snapshot bytes.Buffer
+	if err := persistence.NewRDBEncoder(nil).SaveTo(keyspace, &snapshot); err != nil {
+		fmt.Printf("Replication snapshot failed: %v\n", err)
+		m.dropReplica(replica)
+		return
+	}
 
-	// 生成 RDB 文件并发送
-	encoder := persistence.NewRDBEncoder(replica.writer)
-	err := encoder.Save(m.server, "")
-	if err != nil {
-		// 如果失败，发送空的 RDB
-		rdbHeader := "REDIS0009"
-		replica.writer.WriteString(rdbHeader)
-		replica.writer.Flush()
+	fmt.Fprintf(replica.writer, "+FULLRESYNC %s %d\r\n", m.ReplID(), offset)
+	fmt.Fprintf(replica.writer, "$%d\r\n", snapshot.Len())
+	replica.writer.Write(snapshot.Bytes())
+	if err := replica.writer.Flush(); err != nil {
+		m.dropReplica(replica)
+		return
 	}
 
-	// 增量同步通过 PropagateCommand 方法实现
-	// 不需要单独的 incrementalSync goroutine
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if replica.closed {
+		return
+	}
+	replica.writer.Write(replica.pending)
+	replica.pending = nil
+	if err := replica.writer.Flush(); err != nil {
+		m.removeReplicaLocked(replica)
+		return
+	}
+	replica.state = REPLICA_STATE_ONLINE
 }
 
-// PropagateCommand 传播命令到所有从节点
-func (m *Master) PropagateCommand(cmd *protocol.RESPValue) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
+// PropagateCommand 传播 dbIndex 中执行的命令到所有从节点（数据库变化时先发送 SELECT）
+func (m *Master) PropagateCommand(dbIndex int, cmd *protocol.RESPValue) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var data []byte
+	if dbIndex >= 0 && dbIndex != m.replDb {
+		data = protocol.NewArray([]*protocol.RESPValue{
+			protocol.NewBulkString("SELECT"),
+			protocol.NewBulkString(strconv.Itoa(dbIndex)),
+		}).Encode()
+		m.replDb = dbIndex
+	}
+	data = append(data, cmd.Encode()...)
 
-	// 更新复制偏移量
-	m.replOffset += int64(len(cmd.Encode()))
+	// 更新复制偏移量，写入积压缓冲区
+	m.replOffset += int64(len(data))
+	if m.backlog != nil {
+		m.backlog.feed(data)
+	}
 
-	// 发送给所有从节点
+	// 发送给所有从节点，快照还没发送完的先缓存
 	for replica := range m.replicas {
-		if !replica.closed {
-			data := cmd.Encode()
-			replica.writer.Write(data)
-			replica.writer.Flush()
+		if replica.closed {
+			continue
+		}
+		if replica.state != REPLICA_STATE_ONLINE {
+			replica.pending = append(replica.pending, data...)
+			continue
+		}
+		replica.writer.Write(data)
+		if err := replica.writer.Flush(); err != nil {
+			m.removeReplicaLocked(replica)
 		}
 	}
 }
 
+// dropReplica 同步失败时关闭并移除从节点
+func (m *Master) dropReplica(replica *Replica) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.removeReplicaLocked(replica)
+}
+
+// removeReplicaLocked 关闭并移除从节点（调用方持有 m.mu）
+func (m *Master) removeReplicaLocked(replica *Replica) {
+	replica.closed = true
+	replica.conn.Close()
+	delete(m.replicas, replica)
+}
+
 // RemoveReplica 移除从节点
 func (m *Master) RemoveReplica(replica *Replica) {
 	m.mu.Lock()
@@ -125,3 +343,93 @@ func (m *Master) RemoveReplica(replica *Replica) {
 
 	delete(m.replicas, replica)
 }
+
+// RemoveConn 从节点的连接关闭时移除对应的从节点
+func (m *Master) RemoveConn(conn net.Conn) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for replica := range m.replicas {
+		if replica.conn == conn {
+			replica.closed = true
+			delete(m.replicas, replica)
+		}
+	}
+}
+
+// ConnectedReplicas 已连接的从节点数量
+func (m *Master) ConnectedReplicas() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.replicas)
+}
+
+// Replicas 所有从节点的信息（按地址排序）
+func (m *Master) Replicas() []ReplicaInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	infos := make([]ReplicaInfo, 0, len(m.replicas))
+	for replica := range m.replicas {
+		ip, _, _ := net.SplitHostPort(replica.conn.RemoteAddr().String())
+		infos = append(infos, ReplicaInfo{
+			IP:     ip,
+			Port:   replica.port,
+			State:  replica.state,
+			Offset: replica.offset,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].IP != infos[j].IP {
+			return infos[i].IP < infos[j].IP
+		}
+		return infos[i].Port < infos[j].Port
+	})
+	return infos
+}
+
+// ReplOffset 当前主节点的复制偏移量
+func (m *Master) ReplOffset() int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.replOffset
+}
+
+// AckedReplicas 已确认收到 offset 之前所有数据的从节点数量（WAIT 使用）
+func (m *Master) AckedReplicas(offset int64) int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	count := 0
+	for replica := range m.replicas {
+		if !replica.closed && replica.offset >= offset {
+			count++
+		}
+	}
+	return count
+}
+
+// Ack 记录从节点确认的复制偏移量（REPLCONF ACK），conn 为从节点的连接
+func (m *Master) Ack(conn net.Conn, offset int64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for replica := range m.replicas {
+		if replica.conn == conn {
+			if offset > replica.offset {
+				replica.offset = offset
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// RequestAcks 向所有从节点发送 REPLCONF GETACK *，要求它们尽快上报复制偏移量
+func (m *Master) RequestAcks() {
+	m.PropagateCommand(-1, protocol.NewArray([]*protocol.RESPValue{
+		protocol.NewBulkString("REPLCONF"),
+		protocol.NewBulkString("GETACK"),
+		protocol.NewBulkString("*"),
+	}))
+}
@@ -2,8 +2,16 @@ package replication
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/code-100-precent/LingCache/protocol"
 )
@@ -13,73 +21,257 @@ import (
  * Redis 主从复制实现（从节点）
  * ============================================================================
  *
- * 从节点连接到主节点，接收数据同步。
+ * 从节点连接到主节点，接收数据同步：
+ * 1. 握手：PING、REPLCONF listening-port，然后发送 PSYNC ? -1 请求全量同步
+ * 2. 主节点回复 +FULLRESYNC <replid> <offset>，随后发送 $<len>\r\n<RDB 数据>，
+ *    交给 SlaveHandler 清空数据集后加载
+ * 3. 之后主节点发送的写命令交给 SlaveHandler 执行，并按命令的字节数累加复制偏移量；
+ *    收到 REPLCONF GETACK 时回复 REPLCONF ACK <offset>
+ * 4. 连接断开后每隔 REPL_RECONNECT_INTERVAL 重连，带上主节点的复制 ID 和偏移量发送
+ *    PSYNC <replid> <offset+1>；主节点回复 +CONTINUE 时只接收缺失的命令（部分同步），
+ *    回复 +FULLRESYNC 时重新加载快照
  */
 
+// REPL_RECONNECT_INTERVAL 与主节点断开后重连的间隔
+const REPL_RECONNECT_INTERVAL = 100 * time.Millisecond
+
+// SlaveHandler 处理从主节点收到的数据（由服务器实现）
+type SlaveHandler interface {
+	// LoadSnapshot 全量同步：丢弃现有数据，加载主节点发来的 RDB 快照
+	LoadSnapshot(rdb io.Reader) error
+	// ApplyCommand 执行主节点传播的命令（包括 SELECT）
+	ApplyCommand(cmd *protocol.RESPValue)
+}
+
 // Slave 从节点
 type Slave struct {
-	masterAddr string
-	conn       net.Conn
-	reader     *bufio.Reader
-	writer     *bufio.Writer
-	running    bool
+	masterAddr    string
+	listeningPort int // 本节点的监听端口（通过 REPLCONF listening-port 报告给主节点）
+	handler       SlaveHandler
+	mu            sync.Mutex // 保护 conn（Close 与重连并发）
+	conn          net.Conn
+	reader        *bufio.Reader
+	writer        *bufio.Writer
+	running       atomic.Bool
+	linkUp        atomic.Bool  // 全量同步完成且连接正常（INFO 的 master_link_status）
+	masterReplID  string       // 主节点的复制 ID（FULLRESYNC 中获得）
+	offset        atomic.Int64 // 已处理的复制偏移量
 }
 
 // NewSlave 创建从节点
-func NewSlave(masterAddr string) *Slave {
+func NewSlave(masterAddr string, listeningPort int, handler SlaveHandler) *Slave {
 	return &Slave{
-		masterAddr: masterAddr,
-		running:    false,
+		masterAddr:    masterAddr,
+		listeningPort: listeningPort,
+		handler:       handler,
 	}
 }
 
-// Connect 连接到主节点
+// MasterAddr 主节点地址（host:port）
+func (s *Slave) MasterAddr() string {
+	return s.masterAddr
+}
+
+// LinkUp 与主节点的连接是否正常
+func (s *Slave) LinkUp() bool {
+	return s.linkUp.Load()
+}
+
+// Offset 已处理的复制偏移量
+func (s *Slave) Offset() int64 {
+	return s.offset.Load()
+}
+
+// Connect 连接到主节点，在后台完成握手、同步并持续接收命令，断开后自动重连
 func (s *Slave) Connect() error {
 	conn, err := net.Dial("tcp", s.masterAddr)
 	if err != nil {
 		return err
 	}
 
+	s.running.Store(true)
+	s.attach(conn)
+
+	// 启动接收线程
+	go s.run()
+
+	return nil
+}
+
+// attach 使用新的连接
+func (s *Slave) attach(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.running.Load() {
+		conn.Close()
+		return false
+	}
 	s.conn = conn
 	s.reader = bufio.NewReader(conn)
 	s.writer = bufio.NewWriter(conn)
-	s.running = true
+	return true
+}
 
-	// 发送 PING
-	s.writer.WriteString("*1\r\n$4\r\nPING\r\n")
-	s.writer.Flush()
+// run 同步并接收命令，连接断开后重连
+func (s *Slave) run() {
+	for {
+		if err := s.handshake(); err != nil {
+			if s.running.Load() {
+				fmt.Printf("Replication handshake with %s failed: %v\n", s.masterAddr, err)
+			}
+		} else {
+			s.linkUp.Store(true)
+			s.receiveCommands()
+			s.linkUp.Store(false)
+		}
+		s.conn.Close()
 
-	// 发送 REPLCONF
-	s.writer.WriteString("*3\r\n$8\r\nREPLCONF\r\n$14\r\nlistening-port\r\n$4\r\n6380\r\n")
-	s.writer.Flush()
+		// 重连
+		for {
+			time.Sleep(REPL_RECONNECT_INTERVAL)
+			if !s.running.Load() {
+				return
+			}
+			conn, err := net.Dial("tcp", s.masterAddr)
+			if err != nil {
+				continue
+			}
+			if !s.attach(conn) {
+				return
+			}
+			break
+		}
+	}
+}
 
-	// 发送 PSYNC
-	s.writer.WriteString("*3\r\n$5\r\nPSYNC\r\n$1\r\n?\r\n$2\r\n-1\r\n")
-	s.writer.Flush()
+// handshake 发送 PING、REPLCONF、PSYNC；部分同步时直接返回，全量同步时接收并加载快照
+func (s *Slave) handshake() error {
+	if _, err := s.request("PING"); err != nil {
+		return err
+	}
+	if _, err := s.request("REPLCONF", "listening-port", strconv.Itoa(s.listeningPort)); err != nil {
+		return err
+	}
 
-	// 启动接收线程
-	go s.receiveCommands()
+	// 之前同步过时请求从下一个字节继续
+	replID, psyncOffset := "?", "-1"
+	if s.masterReplID != "" {
+		replID, psyncOffset = s.masterReplID, strconv.FormatInt(s.offset.Load()+1, 10)
+	}
+	reply, err := s.request("PSYNC", replID, psyncOffset)
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(reply.Str)
+	if len(fields) >= 1 && fields[0] == "CONTINUE" {
+		if len(fields) == 2 {
+			s.masterReplID = fields[1]
+		}
+		return nil
+	}
+	if len(fields) != 3 || fields[0] != "FULLRESYNC" {
+		return fmt.Errorf("unexpected reply to PSYNC: %s", reply.Str)
+	}
+	offset, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid offset in FULLRESYNC: %s", fields[2])
+	}
 
+	snapshot, err := s.readSnapshot()
+	if err != nil {
+		return err
+	}
+	if err := s.handler.LoadSnapshot(bytes.NewReader(snapshot)); err != nil {
+		return fmt.Errorf("failed to load snapshot: %w", err)
+	}
+
+	s.masterReplID = fields[1]
+	s.offset.Store(offset)
 	return nil
 }
 
-// receiveCommands 接收主节点的命令
+// request 发送命令并读取回复，错误回复转换为 error
+func (s *Slave) request(args ...string) (*protocol.RESPValue, error) {
+	values := make([]*protocol.RESPValue, len(args))
+	for i, arg := range args {
+		values[i] = protocol.NewBulkString(arg)
+	}
+	s.writer.Write(protocol.NewArray(values).Encode())
+	if err := s.writer.Flush(); err != nil {
+		return nil, err
+	}
+
+	reply, err := protocol.Decode(s.reader)
+	if err != nil {
+		return nil, err
+	}
+	if reply.Type == protocol.RESP_ERROR {
+		return nil, fmt.Errorf("%s: %s", args[0], reply.Str)
+	}
+	return reply, nil
+}
+
+// readSnapshot 读取 $<len>\r\n 之后的 RDB 数据
+func (s *Slave) readSnapshot() ([]byte, error) {
+	line, err := s.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if !strings.HasPrefix(line, "$") {
+		return nil, errors.New("bad snapshot header: " + line)
+	}
+	size, err := strconv.Atoi(line[1:])
+	if err != nil || size < 0 {
+		return nil, errors.New("bad snapshot length: " + line)
+	}
+
+	snapshot := make([]byte, size)
+	if _, err := io.ReadFull(s.reader, snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// receiveCommands 接收并执行主节点的命令，直到连接断开
 func (s *Slave) receiveCommands() {
-	for s.running {
+	for {
 		cmd, err := protocol.Decode(s.reader)
 		if err != nil {
-			fmt.Printf("Slave receive error: %v\n", err)
-			break
+			if s.running.Load() {
+				fmt.Printf("Slave receive error: %v\n", err)
+			}
+			return
 		}
 
-		// 处理命令（简化实现：只打印）
-		fmt.Printf("Slave received: %s\n", string(cmd.Encode()))
+		if args := cmd.GetArray(); len(args) >= 2 &&
+			strings.EqualFold(args[0].ToString(), "REPLCONF") && strings.EqualFold(args[1].ToString(), "GETACK") {
+			s.sendAck()
+		} else {
+			s.handler.ApplyCommand(cmd)
+		}
+		s.offset.Add(int64(len(cmd.Encode())))
 	}
 }
 
-// Close 关闭连接
+// sendAck 上报已处理的复制偏移量
+func (s *Slave) sendAck() {
+	s.writer.Write(protocol.NewArray([]*protocol.RESPValue{
+		protocol.NewBulkString("REPLCONF"),
+		protocol.NewBulkString("ACK"),
+		protocol.NewBulkString(strconv.FormatInt(s.offset.Load(), 10)),
+	}).Encode())
+	s.writer.Flush()
+}
+
+// Close 关闭连接并停止重连
 func (s *Slave) Close() {
-	s.running = false
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.running.Store(false)
+	s.linkUp.Store(false)
 	if s.conn != nil {
 		s.conn.Close()
 	}
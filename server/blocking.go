@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/code-100-precent/LingCache/protocol"
+	"github.com/code-100-precent/LingCache/storage"
 )
 
 /*
@@ -13,136 +14,181 @@ import (
  * ============================================================================
  *
  * 实现真正的阻塞机制：
- * - 客户端等待队列
+ * - 客户端等待队列（每个键一个，按阻塞的先后顺序排列）
  * - 超时机制
  * - 唤醒机制（当有数据时）
+ *
+ * 【代为执行（serve）】
+ * 等待者注册时带有 serve 回调：键上有新数据时（SignalKeyReady），
+ * 管理器在持有锁的情况下按等待顺序调用 serve，由推入方代替等待时间最长的客户端
+ * 完成弹出/移动，再把结果交给它。这样同一个元素只会交给一个等待者，
+ * 等待者被唤醒时也不会发现数据已经被别人取走（不会丢失唤醒）。
+ *
+ * 注册时管理器会先尝试一次 serve，避免在"检查为空"和"注册"之间推入的数据被错过。
+ * 超时由等待者自己处理：Cancel 返回 false 说明结果已经交付，应从 notify 中取走。
+ *
+ * 【传播】
+ * 代为执行的效果（LPOP/RPOP、ZREM、LMOVE）记录在推入方的命令上下文中，
+ * 在推入命令之后写入 AOF 并传播给从节点（与 Redis 的 handleClientsBlockedOnKeys 相同），
+ * 保证重放时先推入再弹出。被唤醒的等待者不再传播任何命令。
+ * BLMOVE 移动元素后目标列表也有了数据，在同一次 SignalKeyReady 中继续为目标列表的等待者执行。
  */
 
+// blockingKey 等待队列的键（同名的键在不同数据库中互不相干）
+type blockingKey struct {
+	db  *storage.RedisDb
+	key string
+}
+
+// blockingServe 代为执行一次阻塞命令的结果
+type blockingServe struct {
+	reply  *protocol.RESPValue // 交给等待者的回复
+	effect *protocol.RESPValue // 写入 AOF 和传播的确定效果，出错时为 nil
+	ready  string              // 执行后有了新数据的键（BLMOVE 的目标列表），没有时为空
+}
+
 // BlockingClient 阻塞的客户端
 type BlockingClient struct {
-	client     *Client
-	keys       []string
-	timeout    time.Duration
-	notify     chan *protocol.RESPValue
-	expireTime time.Time
+	client  *Client
+	db      *storage.RedisDb
+	keys    []string
+	timeout time.Duration                   // 0 表示一直等待
+	notify  chan *protocol.RESPValue        // 交付结果（取消时交付 nil）
+	serve   func(key string) *blockingServe // 代为执行，键上没有数据时返回 nil
+	served  bool                            // 结果已交付（或已取消），不再等待
 }
 
 // BlockingManager 阻塞管理器
 type BlockingManager struct {
-	waitingClients map[string][]*BlockingClient // key -> clients
-	mu             sync.RWMutex
+	waitingClients map[blockingKey][]*BlockingClient // key -> clients（先阻塞的在前）
+	mu             sync.Mutex
 }
 
 // NewBlockingManager 创建阻塞管理器
 func NewBlockingManager() *BlockingManager {
 	return &BlockingManager{
-		waitingClients: make(map[string][]*BlockingClient),
+		waitingClients: make(map[blockingKey][]*BlockingClient),
 	}
 }
 
-// Wait 等待键有数据
-func (bm *BlockingManager) Wait(client *Client, keys []string, timeout int64) *BlockingClient {
+// Block 注册等待者（timeout 为 0 表示一直等待）。
+// 注册前会先尝试一次 serve，成功时返回执行结果，等待者不会被注册
+func (bm *BlockingManager) Block(client *Client, db *storage.RedisDb, keys []string, timeout time.Duration, serve func(key string) *blockingServe) (*BlockingClient, *blockingServe) {
 	bm.mu.Lock()
 	defer bm.mu.Unlock()
 
+	for _, key := range keys {
+		if served := serve(key); served != nil {
+			return nil, served
+		}
+	}
+
 	bc := &BlockingClient{
-		client:     client,
-		keys:       keys,
-		timeout:    time.Duration(timeout) * time.Second,
-		notify:     make(chan *protocol.RESPValue, 1),
-		expireTime: time.Now().Add(time.Duration(timeout) * time.Second),
+		client:  client,
+		db:      db,
+		keys:    keys,
+		timeout: timeout,
+		notify:  make(chan *protocol.RESPValue, 1),
+		serve:   serve,
 	}
 
-	// 将客户端添加到每个键的等待列表
+	// 将客户端添加到每个键的等待队列末尾
 	for _, key := range keys {
-		if bm.waitingClients[key] == nil {
-			bm.waitingClients[key] = make([]*BlockingClient, 0)
-		}
-		bm.waitingClients[key] = append(bm.waitingClients[key], bc)
+		bk := blockingKey{db: db, key: key}
+		bm.waitingClients[bk] = append(bm.waitingClients[bk], bc)
 	}
+	return bc, nil
+}
 
-	return bc
+// SignalKeyReady ctx 的命令使键上有了新数据：按阻塞的先后顺序为等待者代为执行，
+// 直到没有等待者或键上的数据被取完。执行的效果记录在 ctx 中，在 ctx 的命令之后传播
+func (bm *BlockingManager) SignalKeyReady(ctx *CommandContext, key string) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	ready := []string{key}
+	for len(ready) > 0 {
+		bk := blockingKey{db: ctx.Db, key: ready[0]}
+		ready = ready[1:]
+		for len(bm.waitingClients[bk]) > 0 {
+			bc := bm.waitingClients[bk][0]
+			served := bc.serve(bk.key)
+			if served == nil {
+				break
+			}
+			if served.effect != nil {
+				ctx.addDirty(1)
+				ctx.propagateServed(served.effect)
+			}
+			if served.ready != "" {
+				ready = append(ready, served.ready)
+			}
+
+			bc.served = true
+			bc.notify <- served.reply
+			bm.removeClient(bc)
+		}
+	}
 }
 
-// Notify 通知等待的客户端
-func (bm *BlockingManager) Notify(key string, value *protocol.RESPValue) bool {
+// Cancel 等待超时，取消等待者。返回 false 表示结果已经交付
+func (bm *BlockingManager) Cancel(bc *BlockingClient) bool {
 	bm.mu.Lock()
 	defer bm.mu.Unlock()
 
-	clients, exists := bm.waitingClients[key]
-	if !exists || len(clients) == 0 {
+	if bc.served {
 		return false
 	}
+	bc.served = true
+	bm.removeClient(bc)
+	return true
+}
 
-	// 找到第一个未过期的客户端
-	for i, bc := range clients {
-		if time.Now().Before(bc.expireTime) {
-			// 通知这个客户端
-			select {
-			case bc.notify <- value:
-			default:
-			}
-
-			// 从所有键的等待列表中移除
-			bm.removeClient(bc)
+// RemoveClient 客户端断开：取消它的等待，阻塞中的命令收到 nil 后返回
+func (bm *BlockingManager) RemoveClient(client *Client) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
 
-			// 从当前列表移除
-			bm.waitingClients[key] = append(clients[:i], clients[i+1:]...)
-			if len(bm.waitingClients[key]) == 0 {
-				delete(bm.waitingClients, key)
+	for _, clients := range bm.waitingClients {
+		for _, bc := range clients {
+			if bc.client == client && !bc.served {
+				bc.served = true
+				bc.notify <- nil
+				bm.removeClient(bc)
+				return
 			}
-
-			return true
 		}
 	}
+}
 
-	// 清理过期的客户端
-	bm.cleanExpired(key)
+// IsBlocked 客户端是否正在等待某个键
+func (bm *BlockingManager) IsBlocked(client *Client) bool {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
 
+	for _, clients := range bm.waitingClients {
+		for _, bc := range clients {
+			if bc.client == client {
+				return true
+			}
+		}
+	}
 	return false
 }
 
-// removeClient 从所有键的等待列表中移除客户端
+// removeClient 从所有键的等待队列中移除客户端（保持其它客户端的顺序，调用方持有锁）
 func (bm *BlockingManager) removeClient(bc *BlockingClient) {
 	for _, key := range bc.keys {
-		clients := bm.waitingClients[key]
+		bk := blockingKey{db: bc.db, key: key}
+		clients := bm.waitingClients[bk]
 		for i, c := range clients {
 			if c == bc {
-				bm.waitingClients[key] = append(clients[:i], clients[i+1:]...)
-				if len(bm.waitingClients[key]) == 0 {
-					delete(bm.waitingClients, key)
+				bm.waitingClients[bk] = append(clients[:i:i], clients[i+1:]...)
+				if len(bm.waitingClients[bk]) == 0 {
+					delete(bm.waitingClients, bk)
 				}
 				break
 			}
 		}
 	}
 }
-
-// cleanExpired 清理过期的客户端
-func (bm *BlockingManager) cleanExpired(key string) {
-	clients := bm.waitingClients[key]
-	now := time.Now()
-	valid := make([]*BlockingClient, 0)
-
-	for _, bc := range clients {
-		if now.Before(bc.expireTime) {
-			valid = append(valid, bc)
-		}
-	}
-
-	if len(valid) == 0 {
-		delete(bm.waitingClients, key)
-	} else {
-		bm.waitingClients[key] = valid
-	}
-}
-
-// CleanExpired 清理所有过期的客户端
-func (bm *BlockingManager) CleanExpired() {
-	bm.mu.Lock()
-	defer bm.mu.Unlock()
-
-	for key := range bm.waitingClients {
-		bm.cleanExpired(key)
-	}
-}
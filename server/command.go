@@ -2,6 +2,7 @@ package server
 
 import (
 	"errors"
+	"sort"
 
 	"github.com/code-100-precent/LingCache/protocol"
 	"github.com/code-100-precent/LingCache/storage"
@@ -32,17 +33,192 @@ type CommandContext struct {
 	Server *Server
 	Db     *storage.RedisDb
 	Client *Client
+
+	dirty      int64                 // 写命令对数据的修改次数（由 addDirty 累加）
+	propagated []*protocol.RESPValue // 代替原始命令写入 AOF 和传播的命令（由 Propagate 设置）
+	served     []*protocol.RESPValue // 代替阻塞的客户端执行的命令，在本命令之后传播
+	barrier    bool                  // 是否持有写屏障（Server.writeBarrier 的读锁）
+	inExec     bool                  // 在 EXEC 中执行（阻塞命令不阻塞）
+}
+
+// acquireWriteBarrier 持有写屏障，直到命令的修改传播完成
+func (ctx *CommandContext) acquireWriteBarrier() {
+	ctx.Server.writeBarrier.RLock()
+	ctx.barrier = true
+}
+
+// releaseWriteBarrier 释放写屏障（没有持有时不做任何事）
+func (ctx *CommandContext) releaseWriteBarrier() {
+	if ctx.barrier {
+		ctx.barrier = false
+		ctx.Server.writeBarrier.RUnlock()
+	}
+}
+
+// addDirty 记录写命令对数据的修改。只有修改了数据的写命令才计入 dirty、
+// 写入 AOF 并传播给从节点，出错或没有产生修改的写命令（如删除不存在的键）不会
+func (ctx *CommandContext) addDirty(n int) {
+	ctx.dirty += int64(n)
+}
+
+// Propagate 用 cmd 代替原始命令写入 AOF 并传播给从节点，多次调用时按顺序传播。
+// 结果不确定的写命令（随机选择成员、相对过期时间、浮点运算等）传播确定的效果，
+// 使 AOF 重放和从节点得到与执行时相同的数据
+func (ctx *CommandContext) Propagate(cmd *protocol.RESPValue) {
+	ctx.propagated = append(ctx.propagated, cmd)
+}
+
+// propagateServed 在本命令之后传播代替阻塞的客户端执行的命令（不替换本命令）
+func (ctx *CommandContext) propagateServed(cmd *protocol.RESPValue) {
+	ctx.served = append(ctx.served, cmd)
+}
+
+// newCommand 构造一条命令（RESP 数组）
+func newCommand(name string, args ...string) *protocol.RESPValue {
+	values := make([]*protocol.RESPValue, 0, len(args)+1)
+	values = append(values, protocol.NewBulkString(name))
+	for _, arg := range args {
+		values = append(values, protocol.NewBulkString(arg))
+	}
+	return protocol.NewArray(values)
 }
 
 // Command 命令定义
 type Command struct {
 	Name     string
 	Proc     CommandProc
-	Arity    int // 参数数量，-N 表示 >= N
-	Flags    uint64
+	Arity    int    // 参数数量，-N 表示 >= N
+	Flags    uint64 // CMD_* 标志
 	Category string
 }
 
+// 命令标志（与 Redis 的命令标志一致，COMMAND INFO 中按 commandFlagNames 的顺序列出）
+const (
+	CMD_WRITE    uint64 = 1 << iota // 修改数据集（写入 AOF、传播给从节点、只读从节点拒绝）
+	CMD_READONLY                    // 只读取数据
+	CMD_DENYOOM                     // 可能增加内存，超过 maxmemory 时拒绝
+	CMD_ADMIN                       // 管理命令
+	CMD_PUBSUB                      // 发布订阅
+	CMD_NOSCRIPT                    // 不允许在脚本中执行
+	CMD_BLOCKING                    // 可能阻塞客户端
+	CMD_LOADING                     // 加载数据期间允许执行
+	CMD_STALE                       // 从节点与主节点断开时允许执行
+	CMD_FAST                        // O(1) 或 O(log N) 的命令
+)
+
+// commandFlagNames 标志在 COMMAND INFO 中的名称
+var commandFlagNames = []struct {
+	flag uint64
+	name string
+}{
+	{CMD_WRITE, "write"},
+	{CMD_READONLY, "readonly"},
+	{CMD_DENYOOM, "denyoom"},
+	{CMD_ADMIN, "admin"},
+	{CMD_PUBSUB, "pubsub"},
+	{CMD_NOSCRIPT, "noscript"},
+	{CMD_BLOCKING, "blocking"},
+	{CMD_LOADING, "loading"},
+	{CMD_STALE, "stale"},
+	{CMD_FAST, "fast"},
+}
+
+// flagNames 命令的标志名称
+func (cmd *Command) flagNames() []string {
+	names := make([]string, 0, 4)
+	for _, f := range commandFlagNames {
+		if cmd.Flags&f.flag != 0 {
+			names = append(names, f.name)
+		}
+	}
+	return names
+}
+
+// keySpec 键在命令参数中的位置（与 COMMAND INFO 的 first key、last key、step 一致）：
+// 位置包括命令名，last 为负数表示从末尾倒数（-1 为最后一个参数）
+type keySpec struct {
+	first, last, step int
+}
+
+// keyCategories 第一个参数是键的命令类别（与集群路由取键的方式一致）
+var keyCategories = map[string]bool{
+	"string":    true,
+	"list":      true,
+	"hash":      true,
+	"set":       true,
+	"sortedset": true,
+	"zset":      true,
+	"keyspace":  true,
+}
+
+// commandKeySpecs 键不止第一个参数的命令
+var commandKeySpecs = map[string]keySpec{
+	"DEL":         {1, -1, 1},
+	"UNLINK":      {1, -1, 1},
+	"EXISTS":      {1, -1, 1},
+	"TOUCH":       {1, -1, 1},
+	"MGET":        {1, -1, 1},
+	"MSET":        {1, -1, 2},
+	"MSETNX":      {1, -1, 2},
+	"RENAME":      {1, 2, 1},
+	"RENAMENX":    {1, 2, 1},
+	"COPY":        {1, 2, 1},
+	"SMOVE":       {1, 2, 1},
+	"RPOPLPUSH":   {1, 2, 1},
+	"BRPOPLPUSH":  {1, 2, 1},
+	"LMOVE":       {1, 2, 1},
+	"BLMOVE":      {1, 2, 1},
+	"BLPOP":       {1, -2, 1},
+	"BRPOP":       {1, -2, 1},
+	"BZPOPMIN":    {1, -2, 1},
+	"BZPOPMAX":    {1, -2, 1},
+	"SINTER":      {1, -1, 1},
+	"SUNION":      {1, -1, 1},
+	"SDIFF":       {1, -1, 1},
+	"SINTERSTORE": {1, -1, 1},
+	"SUNIONSTORE": {1, -1, 1},
+	"SDIFFSTORE":  {1, -1, 1},
+	"WATCH":       {1, -1, 1},
+}
+
+// keySpec 命令的键位置（没有键的命令返回全 0）
+func (cmd *Command) keySpec() keySpec {
+	if spec, ok := commandKeySpecs[cmd.Name]; ok {
+		return spec
+	}
+	if keyCategories[cmd.Category] {
+		return keySpec{1, 1, 1}
+	}
+	return keySpec{}
+}
+
+// arityOK 参数个数（包括命令名）是否满足 Arity
+func (cmd *Command) arityOK(argc int) bool {
+	if cmd.Arity > 0 {
+		return argc == cmd.Arity
+	}
+	return argc >= -cmd.Arity
+}
+
+// keys 从完整的命令参数（包括命令名）中取出键
+func (cmd *Command) keys(argv []*protocol.RESPValue) []string {
+	spec := cmd.keySpec()
+	if spec.first == 0 || spec.first >= len(argv) {
+		return nil
+	}
+	last := spec.last
+	if last < 0 {
+		last += len(argv)
+	}
+	last = min(last, len(argv)-1)
+
+	keys := make([]string, 0, last-spec.first+1)
+	for i := spec.first; i <= last; i += spec.step {
+		keys = append(keys, argv[i].ToString())
+	}
+	return keys
+}
+
 // CommandTable 命令表
 type CommandTable struct {
 	commands map[string]*Command
@@ -71,6 +247,16 @@ func (ct *CommandTable) Lookup(name string) (*Command, error) {
 	return cmd, nil
 }
 
+// Commands 按名称排序返回所有命令
+func (ct *CommandTable) Commands() []*Command {
+	commands := make([]*Command, 0, len(ct.commands))
+	for _, cmd := range ct.commands {
+		commands = append(commands, cmd)
+	}
+	sort.Slice(commands, func(i, j int) bool { return commands[i].Name < commands[j].Name })
+	return commands
+}
+
 // registerCommands 注册所有命令
 func (ct *CommandTable) registerCommands() {
 	// ========== String 命令 ==========
@@ -78,6 +264,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "SET",
 		Proc:     cmdSet,
 		Arity:    3,
+		Flags:    CMD_WRITE | CMD_DENYOOM,
 		Category: "string",
 	})
 
@@ -85,6 +272,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "GET",
 		Proc:     cmdGet,
 		Arity:    2,
+		Flags:    CMD_READONLY | CMD_FAST,
 		Category: "string",
 	})
 
@@ -92,6 +280,15 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "DEL",
 		Proc:     cmdDel,
 		Arity:    -2,
+		Flags:    CMD_WRITE,
+		Category: "keyspace",
+	})
+
+	ct.Register(&Command{
+		Name:     "UNLINK",
+		Proc:     cmdUnlink,
+		Arity:    -2,
+		Flags:    CMD_WRITE | CMD_FAST,
 		Category: "keyspace",
 	})
 
@@ -99,6 +296,15 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "EXISTS",
 		Proc:     cmdExists,
 		Arity:    -2,
+		Flags:    CMD_READONLY | CMD_FAST,
+		Category: "keyspace",
+	})
+
+	ct.Register(&Command{
+		Name:     "TOUCH",
+		Proc:     cmdTouch,
+		Arity:    -2,
+		Flags:    CMD_READONLY | CMD_FAST,
 		Category: "keyspace",
 	})
 
@@ -106,6 +312,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "TYPE",
 		Proc:     cmdType,
 		Arity:    2,
+		Flags:    CMD_READONLY | CMD_FAST,
 		Category: "keyspace",
 	})
 
@@ -113,6 +320,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "EXPIRE",
 		Proc:     cmdExpire,
 		Arity:    3,
+		Flags:    CMD_WRITE | CMD_FAST,
 		Category: "keyspace",
 	})
 
@@ -120,6 +328,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "TTL",
 		Proc:     cmdTTL,
 		Arity:    2,
+		Flags:    CMD_READONLY | CMD_FAST,
 		Category: "keyspace",
 	})
 
@@ -127,6 +336,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "EXPIREAT",
 		Proc:     cmdExpireAt,
 		Arity:    3,
+		Flags:    CMD_WRITE | CMD_FAST,
 		Category: "keyspace",
 	})
 
@@ -134,6 +344,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "PEXPIRE",
 		Proc:     cmdPExpire,
 		Arity:    3,
+		Flags:    CMD_WRITE | CMD_FAST,
 		Category: "keyspace",
 	})
 
@@ -141,6 +352,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "PEXPIREAT",
 		Proc:     cmdPExpireAt,
 		Arity:    3,
+		Flags:    CMD_WRITE | CMD_FAST,
 		Category: "keyspace",
 	})
 
@@ -148,6 +360,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "PTTL",
 		Proc:     cmdPTTL,
 		Arity:    2,
+		Flags:    CMD_READONLY | CMD_FAST,
 		Category: "keyspace",
 	})
 
@@ -155,6 +368,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "PERSIST",
 		Proc:     cmdPersist,
 		Arity:    2,
+		Flags:    CMD_WRITE | CMD_FAST,
 		Category: "keyspace",
 	})
 
@@ -162,6 +376,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "RENAME",
 		Proc:     cmdRename,
 		Arity:    3,
+		Flags:    CMD_WRITE,
 		Category: "keyspace",
 	})
 
@@ -169,6 +384,15 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "RENAMENX",
 		Proc:     cmdRenameNx,
 		Arity:    3,
+		Flags:    CMD_WRITE | CMD_FAST,
+		Category: "keyspace",
+	})
+
+	ct.Register(&Command{
+		Name:     "COPY",
+		Proc:     cmdCopy,
+		Arity:    -3,
+		Flags:    CMD_WRITE | CMD_DENYOOM,
 		Category: "keyspace",
 	})
 
@@ -176,6 +400,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "RANDOMKEY",
 		Proc:     cmdRandomKey,
 		Arity:    1,
+		Flags:    CMD_READONLY,
 		Category: "keyspace",
 	})
 
@@ -183,6 +408,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "MOVE",
 		Proc:     cmdMove,
 		Arity:    3,
+		Flags:    CMD_WRITE | CMD_FAST,
 		Category: "keyspace",
 	})
 
@@ -190,6 +416,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "OBJECT",
 		Proc:     cmdObject,
 		Arity:    -2,
+		Flags:    CMD_READONLY,
 		Category: "keyspace",
 	})
 
@@ -197,6 +424,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "SORT",
 		Proc:     cmdSort,
 		Arity:    -2,
+		Flags:    CMD_WRITE | CMD_DENYOOM,
 		Category: "keyspace",
 	})
 
@@ -204,6 +432,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "KEYS",
 		Proc:     cmdKeys,
 		Arity:    2,
+		Flags:    CMD_READONLY,
 		Category: "keyspace",
 	})
 
@@ -211,6 +440,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "DBSIZE",
 		Proc:     cmdDBSize,
 		Arity:    1,
+		Flags:    CMD_READONLY | CMD_FAST,
 		Category: "keyspace",
 	})
 
@@ -218,6 +448,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "SELECT",
 		Proc:     cmdSelect,
 		Arity:    2,
+		Flags:    CMD_LOADING | CMD_STALE | CMD_FAST,
 		Category: "connection",
 	})
 
@@ -225,6 +456,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "FLUSHDB",
 		Proc:     cmdFlushDB,
 		Arity:    1,
+		Flags:    CMD_WRITE,
 		Category: "keyspace",
 	})
 
@@ -232,6 +464,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "FLUSHALL",
 		Proc:     cmdFlushAll,
 		Arity:    1,
+		Flags:    CMD_WRITE,
 		Category: "keyspace",
 	})
 
@@ -239,6 +472,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "SCAN",
 		Proc:     cmdScan,
 		Arity:    -2,
+		Flags:    CMD_READONLY,
 		Category: "keyspace",
 	})
 
@@ -247,6 +481,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "LPUSH",
 		Proc:     cmdLPush,
 		Arity:    -3,
+		Flags:    CMD_WRITE | CMD_DENYOOM | CMD_FAST,
 		Category: "list",
 	})
 
@@ -254,20 +489,23 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "RPUSH",
 		Proc:     cmdRPush,
 		Arity:    -3,
+		Flags:    CMD_WRITE | CMD_DENYOOM | CMD_FAST,
 		Category: "list",
 	})
 
 	ct.Register(&Command{
 		Name:     "LPOP",
 		Proc:     cmdLPop,
-		Arity:    2,
+		Arity:    -2,
+		Flags:    CMD_WRITE | CMD_FAST,
 		Category: "list",
 	})
 
 	ct.Register(&Command{
 		Name:     "RPOP",
 		Proc:     cmdRPop,
-		Arity:    2,
+		Arity:    -2,
+		Flags:    CMD_WRITE | CMD_FAST,
 		Category: "list",
 	})
 
@@ -275,6 +513,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "LLEN",
 		Proc:     cmdLLen,
 		Arity:    2,
+		Flags:    CMD_READONLY | CMD_FAST,
 		Category: "list",
 	})
 
@@ -282,6 +521,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "LRANGE",
 		Proc:     cmdLRange,
 		Arity:    4,
+		Flags:    CMD_READONLY,
 		Category: "list",
 	})
 
@@ -289,6 +529,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "LINDEX",
 		Proc:     cmdLIndex,
 		Arity:    3,
+		Flags:    CMD_READONLY,
 		Category: "list",
 	})
 
@@ -296,6 +537,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "LINSERT",
 		Proc:     cmdLInsert,
 		Arity:    5,
+		Flags:    CMD_WRITE | CMD_DENYOOM,
 		Category: "list",
 	})
 
@@ -303,6 +545,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "LREM",
 		Proc:     cmdLRem,
 		Arity:    4,
+		Flags:    CMD_WRITE,
 		Category: "list",
 	})
 
@@ -310,6 +553,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "LSET",
 		Proc:     cmdLSet,
 		Arity:    4,
+		Flags:    CMD_WRITE | CMD_DENYOOM,
 		Category: "list",
 	})
 
@@ -317,6 +561,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "LTRIM",
 		Proc:     cmdLTrim,
 		Arity:    4,
+		Flags:    CMD_WRITE,
 		Category: "list",
 	})
 
@@ -324,6 +569,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "RPOPLPUSH",
 		Proc:     cmdRPopLPush,
 		Arity:    3,
+		Flags:    CMD_WRITE | CMD_DENYOOM,
 		Category: "list",
 	})
 
@@ -331,6 +577,39 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "BRPOPLPUSH",
 		Proc:     cmdBRPopLPush,
 		Arity:    4,
+		Flags:    CMD_WRITE | CMD_DENYOOM | CMD_BLOCKING,
+		Category: "list",
+	})
+
+	ct.Register(&Command{
+		Name:     "LMOVE",
+		Proc:     cmdLMove,
+		Arity:    5,
+		Flags:    CMD_WRITE | CMD_DENYOOM,
+		Category: "list",
+	})
+
+	ct.Register(&Command{
+		Name:     "BLMOVE",
+		Proc:     cmdBLMove,
+		Arity:    6,
+		Flags:    CMD_WRITE | CMD_DENYOOM | CMD_BLOCKING,
+		Category: "list",
+	})
+
+	ct.Register(&Command{
+		Name:     "LPOS",
+		Proc:     cmdLPos,
+		Arity:    -3,
+		Flags:    CMD_READONLY,
+		Category: "list",
+	})
+
+	ct.Register(&Command{
+		Name:     "LMPOP",
+		Proc:     cmdLMPop,
+		Arity:    -4,
+		Flags:    CMD_WRITE,
 		Category: "list",
 	})
 
@@ -339,6 +618,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "SADD",
 		Proc:     cmdSAdd,
 		Arity:    -3,
+		Flags:    CMD_WRITE | CMD_DENYOOM | CMD_FAST,
 		Category: "set",
 	})
 
@@ -346,6 +626,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "SREM",
 		Proc:     cmdSRem,
 		Arity:    -3,
+		Flags:    CMD_WRITE | CMD_FAST,
 		Category: "set",
 	})
 
@@ -353,6 +634,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "SMEMBERS",
 		Proc:     cmdSMembers,
 		Arity:    2,
+		Flags:    CMD_READONLY,
 		Category: "set",
 	})
 
@@ -360,6 +642,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "SCARD",
 		Proc:     cmdSCard,
 		Arity:    2,
+		Flags:    CMD_READONLY | CMD_FAST,
 		Category: "set",
 	})
 
@@ -367,6 +650,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "SISMEMBER",
 		Proc:     cmdSIsMember,
 		Arity:    3,
+		Flags:    CMD_READONLY | CMD_FAST,
 		Category: "set",
 	})
 
@@ -374,6 +658,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "SINTER",
 		Proc:     cmdSInter,
 		Arity:    -2,
+		Flags:    CMD_READONLY,
 		Category: "set",
 	})
 
@@ -381,6 +666,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "SUNION",
 		Proc:     cmdSUnion,
 		Arity:    -2,
+		Flags:    CMD_READONLY,
 		Category: "set",
 	})
 
@@ -388,6 +674,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "SDIFF",
 		Proc:     cmdSDiff,
 		Arity:    -2,
+		Flags:    CMD_READONLY,
 		Category: "set",
 	})
 
@@ -395,6 +682,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "SPOP",
 		Proc:     cmdSPop,
 		Arity:    -2,
+		Flags:    CMD_WRITE | CMD_FAST,
 		Category: "set",
 	})
 
@@ -402,6 +690,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "SRANDMEMBER",
 		Proc:     cmdSRandMember,
 		Arity:    -2,
+		Flags:    CMD_READONLY,
 		Category: "set",
 	})
 
@@ -409,6 +698,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "SMOVE",
 		Proc:     cmdSMove,
 		Arity:    4,
+		Flags:    CMD_WRITE | CMD_FAST,
 		Category: "set",
 	})
 
@@ -416,6 +706,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "SINTERSTORE",
 		Proc:     cmdSInterStore,
 		Arity:    -3,
+		Flags:    CMD_WRITE | CMD_DENYOOM,
 		Category: "set",
 	})
 
@@ -423,6 +714,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "SUNIONSTORE",
 		Proc:     cmdSUnionStore,
 		Arity:    -3,
+		Flags:    CMD_WRITE | CMD_DENYOOM,
 		Category: "set",
 	})
 
@@ -430,6 +722,23 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "SDIFFSTORE",
 		Proc:     cmdSDiffStore,
 		Arity:    -3,
+		Flags:    CMD_WRITE | CMD_DENYOOM,
+		Category: "set",
+	})
+
+	ct.Register(&Command{
+		Name:     "SINTERCARD",
+		Proc:     cmdSInterCard,
+		Arity:    -3,
+		Flags:    CMD_READONLY,
+		Category: "set",
+	})
+
+	ct.Register(&Command{
+		Name:     "SMISMEMBER",
+		Proc:     cmdSMIsMember,
+		Arity:    -3,
+		Flags:    CMD_READONLY | CMD_FAST,
 		Category: "set",
 	})
 
@@ -438,6 +747,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "ZADD",
 		Proc:     cmdZAdd,
 		Arity:    -4,
+		Flags:    CMD_WRITE | CMD_DENYOOM | CMD_FAST,
 		Category: "sortedset",
 	})
 
@@ -445,6 +755,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "ZREM",
 		Proc:     cmdZRem,
 		Arity:    -3,
+		Flags:    CMD_WRITE | CMD_FAST,
 		Category: "sortedset",
 	})
 
@@ -452,6 +763,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "ZSCORE",
 		Proc:     cmdZScore,
 		Arity:    3,
+		Flags:    CMD_READONLY | CMD_FAST,
 		Category: "sortedset",
 	})
 
@@ -459,6 +771,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "ZCARD",
 		Proc:     cmdZCard,
 		Arity:    2,
+		Flags:    CMD_READONLY | CMD_FAST,
 		Category: "sortedset",
 	})
 
@@ -466,6 +779,15 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "ZRANGE",
 		Proc:     cmdZRange,
 		Arity:    -4,
+		Flags:    CMD_READONLY,
+		Category: "sortedset",
+	})
+
+	ct.Register(&Command{
+		Name:     "ZRANGESTORE",
+		Proc:     cmdZRangeStore,
+		Arity:    -5,
+		Flags:    CMD_WRITE | CMD_DENYOOM,
 		Category: "sortedset",
 	})
 
@@ -473,6 +795,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "ZRANK",
 		Proc:     cmdZRank,
 		Arity:    3,
+		Flags:    CMD_READONLY | CMD_FAST,
 		Category: "sortedset",
 	})
 
@@ -480,6 +803,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "ZREVRANGE",
 		Proc:     cmdZRevRange,
 		Arity:    -4,
+		Flags:    CMD_READONLY,
 		Category: "sortedset",
 	})
 
@@ -487,6 +811,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "ZREVRANK",
 		Proc:     cmdZRevRank,
 		Arity:    3,
+		Flags:    CMD_READONLY | CMD_FAST,
 		Category: "sortedset",
 	})
 
@@ -494,6 +819,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "ZINCRBY",
 		Proc:     cmdZIncrBy,
 		Arity:    4,
+		Flags:    CMD_WRITE | CMD_DENYOOM | CMD_FAST,
 		Category: "sortedset",
 	})
 
@@ -501,6 +827,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "ZRANGEBYSCORE",
 		Proc:     cmdZRangeByScore,
 		Arity:    -4,
+		Flags:    CMD_READONLY,
 		Category: "sortedset",
 	})
 
@@ -508,6 +835,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "ZCOUNT",
 		Proc:     cmdZCount,
 		Arity:    4,
+		Flags:    CMD_READONLY | CMD_FAST,
 		Category: "sortedset",
 	})
 
@@ -515,6 +843,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "ZREVRANGEBYSCORE",
 		Proc:     cmdZRevRangeByScore,
 		Arity:    -4,
+		Flags:    CMD_READONLY,
 		Category: "sortedset",
 	})
 
@@ -522,6 +851,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "ZREMRANGEBYRANK",
 		Proc:     cmdZRemRangeByRank,
 		Arity:    4,
+		Flags:    CMD_WRITE,
 		Category: "sortedset",
 	})
 
@@ -529,6 +859,87 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "ZREMRANGEBYSCORE",
 		Proc:     cmdZRemRangeByScore,
 		Arity:    4,
+		Flags:    CMD_WRITE,
+		Category: "sortedset",
+	})
+
+	ct.Register(&Command{
+		Name:     "ZUNION",
+		Proc:     cmdZUnion,
+		Arity:    -3,
+		Flags:    CMD_READONLY,
+		Category: "sortedset",
+	})
+
+	ct.Register(&Command{
+		Name:     "ZINTER",
+		Proc:     cmdZInter,
+		Arity:    -3,
+		Flags:    CMD_READONLY,
+		Category: "sortedset",
+	})
+
+	ct.Register(&Command{
+		Name:     "ZDIFF",
+		Proc:     cmdZDiff,
+		Arity:    -3,
+		Flags:    CMD_READONLY,
+		Category: "sortedset",
+	})
+
+	ct.Register(&Command{
+		Name:     "ZUNIONSTORE",
+		Proc:     cmdZUnionStore,
+		Arity:    -4,
+		Flags:    CMD_WRITE | CMD_DENYOOM,
+		Category: "sortedset",
+	})
+
+	ct.Register(&Command{
+		Name:     "ZINTERSTORE",
+		Proc:     cmdZInterStore,
+		Arity:    -4,
+		Flags:    CMD_WRITE | CMD_DENYOOM,
+		Category: "sortedset",
+	})
+
+	ct.Register(&Command{
+		Name:     "ZDIFFSTORE",
+		Proc:     cmdZDiffStore,
+		Arity:    -4,
+		Flags:    CMD_WRITE | CMD_DENYOOM,
+		Category: "sortedset",
+	})
+
+	ct.Register(&Command{
+		Name:     "ZMSCORE",
+		Proc:     cmdZMScore,
+		Arity:    -3,
+		Flags:    CMD_READONLY | CMD_FAST,
+		Category: "sortedset",
+	})
+
+	ct.Register(&Command{
+		Name:     "ZINTERCARD",
+		Proc:     cmdZInterCard,
+		Arity:    -3,
+		Flags:    CMD_READONLY,
+		Category: "sortedset",
+	})
+
+	ct.Register(&Command{
+		Name:     "ZMPOP",
+		Proc:     cmdZMPop,
+		Arity:    -4,
+		Flags:    CMD_WRITE,
+		Category: "sortedset",
+	})
+
+	ct.Register(&Command{
+		Name:     "ZSCAN",
+		Proc:     cmdZScan,
+		Arity:    -3,
+		Flags:    CMD_READONLY,
 		Category: "sortedset",
 	})
 
@@ -537,6 +948,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "HSET",
 		Proc:     cmdHSet,
 		Arity:    -4,
+		Flags:    CMD_WRITE | CMD_DENYOOM | CMD_FAST,
 		Category: "hash",
 	})
 
@@ -544,6 +956,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "HGET",
 		Proc:     cmdHGet,
 		Arity:    3,
+		Flags:    CMD_READONLY | CMD_FAST,
 		Category: "hash",
 	})
 
@@ -551,6 +964,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "HDEL",
 		Proc:     cmdHDel,
 		Arity:    -3,
+		Flags:    CMD_WRITE | CMD_FAST,
 		Category: "hash",
 	})
 
@@ -558,6 +972,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "HEXISTS",
 		Proc:     cmdHExists,
 		Arity:    3,
+		Flags:    CMD_READONLY | CMD_FAST,
 		Category: "hash",
 	})
 
@@ -565,6 +980,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "HLEN",
 		Proc:     cmdHLen,
 		Arity:    2,
+		Flags:    CMD_READONLY | CMD_FAST,
 		Category: "hash",
 	})
 
@@ -572,6 +988,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "HGETALL",
 		Proc:     cmdHGetAll,
 		Arity:    2,
+		Flags:    CMD_READONLY,
 		Category: "hash",
 	})
 
@@ -579,6 +996,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "HKEYS",
 		Proc:     cmdHKeys,
 		Arity:    2,
+		Flags:    CMD_READONLY,
 		Category: "hash",
 	})
 
@@ -586,6 +1004,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "HVALS",
 		Proc:     cmdHVals,
 		Arity:    2,
+		Flags:    CMD_READONLY,
 		Category: "hash",
 	})
 
@@ -593,6 +1012,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "HINCRBY",
 		Proc:     cmdHIncrBy,
 		Arity:    4,
+		Flags:    CMD_WRITE | CMD_DENYOOM | CMD_FAST,
 		Category: "hash",
 	})
 
@@ -600,6 +1020,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "HMSET",
 		Proc:     cmdHMSet,
 		Arity:    -4,
+		Flags:    CMD_WRITE | CMD_DENYOOM | CMD_FAST,
 		Category: "hash",
 	})
 
@@ -607,6 +1028,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "HMGET",
 		Proc:     cmdHMGet,
 		Arity:    -3,
+		Flags:    CMD_READONLY | CMD_FAST,
 		Category: "hash",
 	})
 
@@ -614,6 +1036,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "HSETNX",
 		Proc:     cmdHSetNx,
 		Arity:    4,
+		Flags:    CMD_WRITE | CMD_DENYOOM | CMD_FAST,
 		Category: "hash",
 	})
 
@@ -621,6 +1044,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "HSTRLEN",
 		Proc:     cmdHStrLen,
 		Arity:    3,
+		Flags:    CMD_READONLY | CMD_FAST,
 		Category: "hash",
 	})
 
@@ -628,6 +1052,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "HINCRBYFLOAT",
 		Proc:     cmdHIncrByFloat,
 		Arity:    4,
+		Flags:    CMD_WRITE | CMD_DENYOOM | CMD_FAST,
 		Category: "hash",
 	})
 
@@ -635,6 +1060,79 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "HSCAN",
 		Proc:     cmdHScan,
 		Arity:    -3,
+		Flags:    CMD_READONLY,
+		Category: "hash",
+	})
+
+	ct.Register(&Command{
+		Name:     "HGETDEL",
+		Proc:     cmdHGetDel,
+		Arity:    -5,
+		Flags:    CMD_WRITE | CMD_FAST,
+		Category: "hash",
+	})
+
+	ct.Register(&Command{
+		Name:     "HGETEX",
+		Proc:     cmdHGetEx,
+		Arity:    -5,
+		Flags:    CMD_WRITE | CMD_FAST,
+		Category: "hash",
+	})
+
+	ct.Register(&Command{
+		Name:     "HEXPIRE",
+		Proc:     cmdHExpire,
+		Arity:    -6,
+		Flags:    CMD_WRITE | CMD_FAST,
+		Category: "hash",
+	})
+
+	ct.Register(&Command{
+		Name:     "HPEXPIRE",
+		Proc:     cmdHPExpire,
+		Arity:    -6,
+		Flags:    CMD_WRITE | CMD_FAST,
+		Category: "hash",
+	})
+
+	ct.Register(&Command{
+		Name:     "HEXPIREAT",
+		Proc:     cmdHExpireAt,
+		Arity:    -6,
+		Flags:    CMD_WRITE | CMD_FAST,
+		Category: "hash",
+	})
+
+	ct.Register(&Command{
+		Name:     "HPEXPIREAT",
+		Proc:     cmdHPExpireAt,
+		Arity:    -6,
+		Flags:    CMD_WRITE | CMD_FAST,
+		Category: "hash",
+	})
+
+	ct.Register(&Command{
+		Name:     "HTTL",
+		Proc:     cmdHTTL,
+		Arity:    -5,
+		Flags:    CMD_READONLY | CMD_FAST,
+		Category: "hash",
+	})
+
+	ct.Register(&Command{
+		Name:     "HPTTL",
+		Proc:     cmdHPTTL,
+		Arity:    -5,
+		Flags:    CMD_READONLY | CMD_FAST,
+		Category: "hash",
+	})
+
+	ct.Register(&Command{
+		Name:     "HPERSIST",
+		Proc:     cmdHPersist,
+		Arity:    -5,
+		Flags:    CMD_WRITE | CMD_FAST,
 		Category: "hash",
 	})
 
@@ -642,6 +1140,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "MSET",
 		Proc:     cmdMSet,
 		Arity:    -3,
+		Flags:    CMD_WRITE | CMD_DENYOOM,
 		Category: "string",
 	})
 
@@ -649,6 +1148,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "MGET",
 		Proc:     cmdMGet,
 		Arity:    -2,
+		Flags:    CMD_READONLY | CMD_FAST,
 		Category: "string",
 	})
 
@@ -656,6 +1156,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "SETEX",
 		Proc:     cmdSetEx,
 		Arity:    4,
+		Flags:    CMD_WRITE | CMD_DENYOOM,
 		Category: "string",
 	})
 
@@ -663,6 +1164,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "SETNX",
 		Proc:     cmdSetNx,
 		Arity:    3,
+		Flags:    CMD_WRITE | CMD_DENYOOM | CMD_FAST,
 		Category: "string",
 	})
 
@@ -670,6 +1172,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "PSETEX",
 		Proc:     cmdPSetEx,
 		Arity:    4,
+		Flags:    CMD_WRITE | CMD_DENYOOM,
 		Category: "string",
 	})
 
@@ -677,6 +1180,15 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "GETSET",
 		Proc:     cmdGetSet,
 		Arity:    3,
+		Flags:    CMD_WRITE | CMD_DENYOOM | CMD_FAST,
+		Category: "string",
+	})
+
+	ct.Register(&Command{
+		Name:     "GETEX",
+		Proc:     cmdGetEx,
+		Arity:    -2,
+		Flags:    CMD_WRITE | CMD_FAST,
 		Category: "string",
 	})
 
@@ -684,6 +1196,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "APPEND",
 		Proc:     cmdAppend,
 		Arity:    3,
+		Flags:    CMD_WRITE | CMD_DENYOOM | CMD_FAST,
 		Category: "string",
 	})
 
@@ -691,6 +1204,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "STRLEN",
 		Proc:     cmdStrLen,
 		Arity:    2,
+		Flags:    CMD_READONLY | CMD_FAST,
 		Category: "string",
 	})
 
@@ -698,6 +1212,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "INCR",
 		Proc:     cmdIncr,
 		Arity:    2,
+		Flags:    CMD_WRITE | CMD_DENYOOM | CMD_FAST,
 		Category: "string",
 	})
 
@@ -705,6 +1220,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "DECR",
 		Proc:     cmdDecr,
 		Arity:    2,
+		Flags:    CMD_WRITE | CMD_DENYOOM | CMD_FAST,
 		Category: "string",
 	})
 
@@ -712,6 +1228,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "INCRBY",
 		Proc:     cmdIncrBy,
 		Arity:    3,
+		Flags:    CMD_WRITE | CMD_DENYOOM | CMD_FAST,
 		Category: "string",
 	})
 
@@ -719,6 +1236,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "DECRBY",
 		Proc:     cmdDecrBy,
 		Arity:    3,
+		Flags:    CMD_WRITE | CMD_DENYOOM | CMD_FAST,
 		Category: "string",
 	})
 
@@ -726,6 +1244,15 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "GETRANGE",
 		Proc:     cmdGetRange,
 		Arity:    4,
+		Flags:    CMD_READONLY,
+		Category: "string",
+	})
+
+	ct.Register(&Command{
+		Name:     "SUBSTR",
+		Proc:     cmdGetRange,
+		Arity:    4,
+		Flags:    CMD_READONLY,
 		Category: "string",
 	})
 
@@ -733,6 +1260,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "SETRANGE",
 		Proc:     cmdSetRange,
 		Arity:    4,
+		Flags:    CMD_WRITE | CMD_DENYOOM,
 		Category: "string",
 	})
 
@@ -740,6 +1268,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "SETBIT",
 		Proc:     cmdSetBit,
 		Arity:    4,
+		Flags:    CMD_WRITE | CMD_DENYOOM,
 		Category: "string",
 	})
 
@@ -747,6 +1276,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "GETBIT",
 		Proc:     cmdGetBit,
 		Arity:    3,
+		Flags:    CMD_READONLY | CMD_FAST,
 		Category: "string",
 	})
 
@@ -754,6 +1284,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "BITCOUNT",
 		Proc:     cmdBitCount,
 		Arity:    -2,
+		Flags:    CMD_READONLY,
 		Category: "string",
 	})
 
@@ -761,6 +1292,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "BITOP",
 		Proc:     cmdBitOp,
 		Arity:    -4,
+		Flags:    CMD_WRITE | CMD_DENYOOM,
 		Category: "string",
 	})
 
@@ -768,6 +1300,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "BITPOS",
 		Proc:     cmdBitPos,
 		Arity:    -3,
+		Flags:    CMD_READONLY,
 		Category: "string",
 	})
 
@@ -776,6 +1309,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "PING",
 		Proc:     cmdPing,
 		Arity:    -1,
+		Flags:    CMD_FAST,
 		Category: "connection",
 	})
 
@@ -783,6 +1317,39 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "QUIT",
 		Proc:     cmdQuit,
 		Arity:    1,
+		Flags:    CMD_NOSCRIPT | CMD_LOADING | CMD_STALE | CMD_FAST,
+		Category: "connection",
+	})
+
+	ct.Register(&Command{
+		Name:     "RESET",
+		Proc:     cmdReset,
+		Arity:    1,
+		Flags:    CMD_NOSCRIPT | CMD_LOADING | CMD_STALE | CMD_FAST,
+		Category: "connection",
+	})
+
+	ct.Register(&Command{
+		Name:     "AUTH",
+		Proc:     cmdAuth,
+		Arity:    -2,
+		Flags:    CMD_NOSCRIPT | CMD_LOADING | CMD_STALE | CMD_FAST,
+		Category: "connection",
+	})
+
+	ct.Register(&Command{
+		Name:     "HELLO",
+		Proc:     cmdHello,
+		Arity:    -1,
+		Flags:    CMD_NOSCRIPT | CMD_LOADING | CMD_STALE | CMD_FAST,
+		Category: "connection",
+	})
+
+	ct.Register(&Command{
+		Name:     "CLIENT",
+		Proc:     cmdClient,
+		Arity:    -2,
+		Flags:    CMD_NOSCRIPT | CMD_LOADING | CMD_STALE,
 		Category: "connection",
 	})
 
@@ -791,6 +1358,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "INFO",
 		Proc:     cmdInfo,
 		Arity:    -1,
+		Flags:    CMD_LOADING | CMD_STALE,
 		Category: "server",
 	})
 
@@ -798,6 +1366,55 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "CONFIG",
 		Proc:     cmdConfig,
 		Arity:    -2,
+		Flags:    CMD_ADMIN | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE,
+		Category: "server",
+	})
+
+	ct.Register(&Command{
+		Name:     "DEBUG",
+		Proc:     cmdDebug,
+		Arity:    -2,
+		Flags:    CMD_ADMIN | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE,
+		Category: "server",
+	})
+
+	ct.Register(&Command{
+		Name:     "MEMORY",
+		Proc:     cmdMemory,
+		Arity:    -2,
+		Flags:    CMD_READONLY,
+		Category: "server",
+	})
+
+	ct.Register(&Command{
+		Name:     "MONITOR",
+		Proc:     cmdMonitor,
+		Arity:    1,
+		Flags:    CMD_ADMIN | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE,
+		Category: "server",
+	})
+
+	ct.Register(&Command{
+		Name:     "COMMAND",
+		Proc:     cmdCommand,
+		Arity:    -1,
+		Flags:    CMD_LOADING | CMD_STALE,
+		Category: "server",
+	})
+
+	ct.Register(&Command{
+		Name:     "TIME",
+		Proc:     cmdTime,
+		Arity:    1,
+		Flags:    CMD_LOADING | CMD_STALE | CMD_FAST,
+		Category: "server",
+	})
+
+	ct.Register(&Command{
+		Name:     "SLOWLOG",
+		Proc:     cmdSlowlog,
+		Arity:    -2,
+		Flags:    CMD_ADMIN | CMD_LOADING | CMD_STALE,
 		Category: "server",
 	})
 
@@ -806,6 +1423,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "MULTI",
 		Proc:     cmdMulti,
 		Arity:    1,
+		Flags:    CMD_NOSCRIPT | CMD_LOADING | CMD_STALE | CMD_FAST,
 		Category: "transaction",
 	})
 
@@ -813,6 +1431,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "EXEC",
 		Proc:     cmdExec,
 		Arity:    1,
+		Flags:    CMD_NOSCRIPT | CMD_LOADING | CMD_STALE,
 		Category: "transaction",
 	})
 
@@ -820,6 +1439,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "DISCARD",
 		Proc:     cmdDiscard,
 		Arity:    1,
+		Flags:    CMD_NOSCRIPT | CMD_LOADING | CMD_STALE | CMD_FAST,
 		Category: "transaction",
 	})
 
@@ -827,6 +1447,15 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "WATCH",
 		Proc:     cmdWatch,
 		Arity:    -2,
+		Flags:    CMD_NOSCRIPT | CMD_LOADING | CMD_STALE | CMD_FAST,
+		Category: "transaction",
+	})
+
+	ct.Register(&Command{
+		Name:     "UNWATCH",
+		Proc:     cmdUnwatch,
+		Arity:    1,
+		Flags:    CMD_NOSCRIPT | CMD_LOADING | CMD_STALE | CMD_FAST,
 		Category: "transaction",
 	})
 
@@ -835,6 +1464,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "PUBLISH",
 		Proc:     cmdPublish,
 		Arity:    3,
+		Flags:    CMD_PUBSUB | CMD_LOADING | CMD_STALE | CMD_FAST,
 		Category: "pubsub",
 	})
 
@@ -842,6 +1472,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "SUBSCRIBE",
 		Proc:     cmdSubscribe,
 		Arity:    -2,
+		Flags:    CMD_PUBSUB | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE,
 		Category: "pubsub",
 	})
 
@@ -849,6 +1480,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "UNSUBSCRIBE",
 		Proc:     cmdUnsubscribe,
 		Arity:    -1,
+		Flags:    CMD_PUBSUB | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE,
 		Category: "pubsub",
 	})
 
@@ -856,6 +1488,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "PSUBSCRIBE",
 		Proc:     cmdPSubscribe,
 		Arity:    -2,
+		Flags:    CMD_PUBSUB | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE,
 		Category: "pubsub",
 	})
 
@@ -863,6 +1496,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "PUNSUBSCRIBE",
 		Proc:     cmdPUnsubscribe,
 		Arity:    -1,
+		Flags:    CMD_PUBSUB | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE,
 		Category: "pubsub",
 	})
 
@@ -870,6 +1504,31 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "PUBSUB",
 		Proc:     cmdPubsub,
 		Arity:    -2,
+		Flags:    CMD_PUBSUB | CMD_LOADING | CMD_STALE,
+		Category: "pubsub",
+	})
+
+	ct.Register(&Command{
+		Name:     "SPUBLISH",
+		Proc:     cmdSPublish,
+		Arity:    3,
+		Flags:    CMD_PUBSUB | CMD_LOADING | CMD_STALE | CMD_FAST,
+		Category: "pubsub",
+	})
+
+	ct.Register(&Command{
+		Name:     "SSUBSCRIBE",
+		Proc:     cmdSSubscribe,
+		Arity:    -2,
+		Flags:    CMD_PUBSUB | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE,
+		Category: "pubsub",
+	})
+
+	ct.Register(&Command{
+		Name:     "SUNSUBSCRIBE",
+		Proc:     cmdSUnsubscribe,
+		Arity:    -1,
+		Flags:    CMD_PUBSUB | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE,
 		Category: "pubsub",
 	})
 
@@ -878,6 +1537,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "BLPOP",
 		Proc:     cmdBLPop,
 		Arity:    -3,
+		Flags:    CMD_WRITE | CMD_BLOCKING,
 		Category: "list",
 	})
 
@@ -885,6 +1545,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "BRPOP",
 		Proc:     cmdBRPop,
 		Arity:    -3,
+		Flags:    CMD_WRITE | CMD_BLOCKING,
 		Category: "list",
 	})
 
@@ -893,6 +1554,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "SAVE",
 		Proc:     cmdSave,
 		Arity:    1,
+		Flags:    CMD_ADMIN | CMD_NOSCRIPT,
 		Category: "server",
 	})
 
@@ -900,6 +1562,23 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "BGSAVE",
 		Proc:     cmdBGSave,
 		Arity:    1,
+		Flags:    CMD_ADMIN | CMD_NOSCRIPT,
+		Category: "server",
+	})
+
+	ct.Register(&Command{
+		Name:     "LASTSAVE",
+		Proc:     cmdLastSave,
+		Arity:    1,
+		Flags:    CMD_LOADING | CMD_STALE | CMD_FAST,
+		Category: "server",
+	})
+
+	ct.Register(&Command{
+		Name:     "SHUTDOWN",
+		Proc:     cmdShutdown,
+		Arity:    -1,
+		Flags:    CMD_ADMIN | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE,
 		Category: "server",
 	})
 
@@ -911,25 +1590,52 @@ func (ct *CommandTable) registerCommands() {
 		Category: "cluster",
 	})
 
+	ct.Register(&Command{
+		Name:     "ASKING",
+		Proc:     cmdAsking,
+		Arity:    1,
+		Flags:    CMD_FAST,
+		Category: "cluster",
+	})
+
 	// ========== 复制命令 ==========
 	ct.Register(&Command{
 		Name:     "REPLCONF",
 		Proc:     cmdReplConf,
 		Arity:    -2,
+		Flags:    CMD_ADMIN | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE,
 		Category: "replication",
 	})
 
 	ct.Register(&Command{
 		Name:     "PSYNC",
 		Proc:     cmdPSync,
-		Arity:    -2,
+		Arity:    -3,
+		Flags:    CMD_ADMIN | CMD_NOSCRIPT,
 		Category: "replication",
 	})
 
 	ct.Register(&Command{
 		Name:     "SLAVEOF",
 		Proc:     cmdSlaveOf,
-		Arity:    -3,
+		Arity:    3,
+		Flags:    CMD_ADMIN | CMD_NOSCRIPT | CMD_STALE,
+		Category: "replication",
+	})
+
+	ct.Register(&Command{
+		Name:     "REPLICAOF",
+		Proc:     cmdSlaveOf,
+		Arity:    3,
+		Flags:    CMD_ADMIN | CMD_NOSCRIPT | CMD_STALE,
+		Category: "replication",
+	})
+
+	ct.Register(&Command{
+		Name:     "WAIT",
+		Proc:     cmdWait,
+		Arity:    3,
+		Flags:    CMD_NOSCRIPT,
 		Category: "replication",
 	})
 
@@ -938,6 +1644,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "BGREWRITEAOF",
 		Proc:     cmdBGRewriteAOF,
 		Arity:    1,
+		Flags:    CMD_ADMIN | CMD_NOSCRIPT,
 		Category: "server",
 	})
 
@@ -946,6 +1653,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "BZPOPMAX",
 		Proc:     cmdBZPopMax,
 		Arity:    -3,
+		Flags:    CMD_WRITE | CMD_BLOCKING | CMD_FAST,
 		Category: "zset",
 	})
 
@@ -953,6 +1661,7 @@ func (ct *CommandTable) registerCommands() {
 		Name:     "BZPOPMIN",
 		Proc:     cmdBZPopMin,
 		Arity:    -3,
+		Flags:    CMD_WRITE | CMD_BLOCKING | CMD_FAST,
 		Category: "zset",
 	})
 }
@@ -984,18 +1693,23 @@ func (ct *CommandTable) ExecuteCommand(ctx *CommandContext, req *protocol.RESPVa
 	}
 
 	// 验证参数数量
-	argCount := len(array) - 1 // 减去命令名
-	if cmd.Arity > 0 {
-		if argCount != cmd.Arity-1 { // Arity 包括命令名
-			return protocol.NewError("ERR wrong number of arguments for '" + cmdName + "' command")
-		}
-	} else if cmd.Arity < 0 {
-		minArgs := -cmd.Arity - 1
-		if argCount < minArgs {
-			return protocol.NewError("ERR wrong number of arguments for '" + cmdName + "' command")
-		}
+	if !cmd.arityOK(len(array)) {
+		return protocol.NewError("ERR wrong number of arguments for '" + cmdName + "' command")
 	}
 
+	// 只读从节点拒绝普通客户端的写命令（主节点传播的命令和 AOF 重放没有客户端，不受限制）
+	if ctx.Client != nil && ctx.Server.denyReadOnly(cmdName) {
+		return protocol.NewError("READONLY You can't write against a read only replica.")
+	}
+
+	// 设置了 maxmemory 时，可能增加内存的写命令执行前先按淘汰策略释放内存
+	if ctx.Server.denyOOM(cmdName) && !ctx.Server.performEvictions() {
+		return protocol.NewError("OOM command not allowed when used memory > 'maxmemory'.")
+	}
+
+	// 推送给 MONITOR 客户端（未知命令和参数个数错误的命令不推送）
+	ctx.Server.monitors.Feed(ctx.Client, req)
+
 	// 执行命令
 	return cmd.Proc(ctx, array[1:])
 }
@@ -1,12 +1,16 @@
 package server
 
 import (
+	"encoding/json"
 	"fmt"
-	"github.com/code-100-precent/LingCache/persistence"
+	"github.com/code-100-precent/LingCache/cluster"
 	"github.com/code-100-precent/LingCache/protocol"
 	"github.com/code-100-precent/LingCache/replication"
 	"github.com/code-100-precent/LingCache/storage"
 	"github.com/code-100-precent/LingCache/structure"
+	"github.com/code-100-precent/LingCache/utils"
+	"math"
+	"net"
 	"sort"
 	"strconv"
 	"strings"
@@ -19,8 +23,9 @@ func cmdSet(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue
 	key := args[0].ToString()
 	value := args[1].ToString()
 
-	obj := storage.NewStringObject([]byte(value))
+	obj := ctx.Server.createStringObject([]byte(value))
 	ctx.Db.Set(key, obj)
+	ctx.addDirty(1)
 
 	return protocol.NewSimpleString("OK")
 }
@@ -49,9 +54,10 @@ func cmdMSet(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValu
 	for i := 0; i < len(args); i += 2 {
 		key := args[i].ToString()
 		value := args[i+1].ToString()
-		obj := storage.NewStringObject([]byte(value))
+		obj := ctx.Server.createStringObject([]byte(value))
 		ctx.Db.Set(key, obj)
 	}
+	ctx.addDirty(len(args) / 2)
 
 	return protocol.NewSimpleString("OK")
 }
@@ -81,9 +87,12 @@ func cmdSetEx(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPVal
 	}
 	value := args[2].ToString()
 
-	obj := storage.NewStringObject([]byte(value))
+	obj := ctx.Server.createStringObject([]byte(value))
 	ctx.Db.Set(key, obj)
 	ctx.Db.Expire(key, seconds)
+	ctx.addDirty(1)
+	ctx.Propagate(newCommand("SET", key, value))
+	propagateExpire(ctx, key)
 
 	return protocol.NewSimpleString("OK")
 }
@@ -97,8 +106,9 @@ func cmdSetNx(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPVal
 		return protocol.NewInteger(0)
 	}
 
-	obj := storage.NewStringObject([]byte(value))
+	obj := ctx.Server.createStringObject([]byte(value))
 	ctx.Db.Set(key, obj)
+	ctx.addDirty(1)
 
 	return protocol.NewInteger(1)
 }
@@ -111,10 +121,13 @@ func cmdPSetEx(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPVa
 	}
 	value := args[2].ToString()
 
-	obj := storage.NewStringObject([]byte(value))
+	obj := ctx.Server.createStringObject([]byte(value))
 	ctx.Db.Set(key, obj)
 	// 使用秒级过期（简化实现，实际应该支持毫秒级）
 	ctx.Db.Expire(key, milliseconds/1000)
+	ctx.addDirty(1)
+	ctx.Propagate(newCommand("SET", key, value))
+	propagateExpire(ctx, key)
 
 	return protocol.NewSimpleString("OK")
 }
@@ -123,52 +136,129 @@ func cmdGetSet(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPVa
 	key := args[0].ToString()
 	newValue := args[1].ToString()
 
-	// 获取旧值
-	oldObj, err := ctx.Db.Get(key)
-	var oldValue string
-	if err != nil {
-		oldValue = ""
-	} else {
+	// 获取旧值：键不存在时回复 nil，旧值为空字符串时回复空字符串
+	reply := protocol.NewNullBulkString()
+	if oldObj, err := ctx.Db.Get(key); err == nil {
 		val, err := oldObj.GetStringValue()
 		if err != nil {
 			return protocol.NewError("ERR wrong type")
 		}
-		oldValue = string(val)
+		reply = protocol.NewBulkString(string(val))
 	}
 
 	// 设置新值
-	obj := storage.NewStringObject([]byte(newValue))
+	obj := ctx.Server.createStringObject([]byte(newValue))
 	ctx.Db.Set(key, obj)
+	ctx.addDirty(1)
+
+	return reply
+}
+
+// cmdGetEx GETEX key [EX seconds|PX milliseconds|EXAT unix-time-seconds|PXAT unix-time-milliseconds|PERSIST]
+// 返回值的同时设置或移除过期时间；不带选项时与 GET 相同，不修改过期时间
+func cmdGetEx(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	key := args[0].ToString()
+
+	// 解析过期选项（过期时间以秒为精度保存，毫秒向上取整）
+	option := ""
+	var expireAt int64
+	if len(args) > 1 {
+		option = strings.ToUpper(args[1].ToString())
+		switch option {
+		case "EX", "PX", "EXAT", "PXAT":
+			if len(args) != 3 {
+				return protocol.NewError("ERR syntax error")
+			}
+			n, err := strconv.ParseInt(args[2].ToString(), 10, 64)
+			if err != nil {
+				return protocol.NewError("ERR value is not an integer or out of range")
+			}
+			if n <= 0 {
+				return protocol.NewError("ERR invalid expire time in 'getex' command")
+			}
+			switch option {
+			case "EX":
+				expireAt = time.Now().Unix() + n
+			case "PX":
+				expireAt = time.Now().Unix() + (n+999)/1000
+			case "EXAT":
+				expireAt = n
+			case "PXAT":
+				expireAt = (n + 999) / 1000
+			}
+		case "PERSIST":
+			if len(args) != 2 {
+				return protocol.NewError("ERR syntax error")
+			}
+		default:
+			return protocol.NewError("ERR syntax error")
+		}
+	}
 
-	if oldValue == "" {
+	obj, err := ctx.Db.Get(key)
+	if err != nil {
 		return protocol.NewNullBulkString()
 	}
-	return protocol.NewBulkString(oldValue)
+
+	val, err := obj.GetStringValue()
+	if err != nil {
+		return protocol.NewError("ERR wrong type")
+	}
+
+	switch option {
+	case "":
+		// 不修改过期时间
+	case "PERSIST":
+		if ctx.Db.Persist(key) {
+			ctx.addDirty(1)
+		}
+	default:
+		ctx.Db.ExpireAt(key, expireAt)
+		ctx.addDirty(1)
+		propagateExpire(ctx, key)
+	}
+
+	return protocol.NewBulkString(string(val))
 }
 
 func cmdAppend(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
 	key := args[0].ToString()
-	appendValue := args[1].ToString()
+	appendValue := []byte(args[1].ToString())
 
 	obj, err := ctx.Db.Get(key)
-	var currentValue string
 	if err != nil {
 		// 键不存在，创建新字符串
-		currentValue = ""
-	} else {
-		val, err := obj.GetStringValue()
-		if err != nil {
-			return protocol.NewError("ERR wrong type")
-		}
-		currentValue = string(val)
+		ctx.Db.Set(key, storage.NewStringObject(appendValue))
+		ctx.addDirty(1)
+		return protocol.NewInteger(int64(len(appendValue)))
 	}
 
-	// 追加值
-	newValue := currentValue + appendValue
-	newObj := storage.NewStringObject([]byte(newValue))
-	ctx.Db.Set(key, newObj)
+	obj, err = unshareStringValue(ctx, key, obj)
+	if err != nil {
+		return protocol.NewError("ERR wrong type")
+	}
+
+	// 原地追加，SDS 预分配的空间足够时不重新分配
+	length, _ := obj.AppendString(appendValue)
+	ctx.addDirty(1)
+
+	return protocol.NewInteger(int64(length))
+}
+
+// unshareStringValue 原地修改字符串前确保对象没有被共享（与 Redis 的 dbUnshareStringValue 一致）
+// 共享对象（如共享整数）复制一份新的对象替换到键空间中
+func unshareStringValue(ctx *CommandContext, key string, obj *storage.RedisObject) (*storage.RedisObject, error) {
+	val, err := obj.GetStringValue()
+	if err != nil {
+		return nil, err
+	}
+	if !obj.IsShared() && obj.RefCount <= 1 {
+		return obj, nil
+	}
 
-	return protocol.NewInteger(int64(len(newValue)))
+	newObj := storage.NewStringObject(val)
+	ctx.Db.Set(key, newObj)
+	return newObj, nil
 }
 
 func cmdStrLen(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
@@ -188,11 +278,11 @@ func cmdStrLen(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPVa
 }
 
 func cmdIncr(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
-	return cmdIncrBy(ctx, []*protocol.RESPValue{args[0], protocol.NewInteger(1)})
+	return cmdIncrBy(ctx, []*protocol.RESPValue{args[0], protocol.NewBulkString("1")})
 }
 
 func cmdDecr(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
-	return cmdIncrBy(ctx, []*protocol.RESPValue{args[0], protocol.NewInteger(-1)})
+	return cmdIncrBy(ctx, []*protocol.RESPValue{args[0], protocol.NewBulkString("-1")})
 }
 
 func cmdIncrBy(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
@@ -222,8 +312,9 @@ func cmdIncrBy(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPVa
 
 	// 计算新值
 	newValue := currentValue + increment
-	newObj := storage.NewStringObject([]byte(strconv.FormatInt(newValue, 10)))
+	newObj := ctx.Server.createStringObject([]byte(strconv.FormatInt(newValue, 10)))
 	ctx.Db.Set(key, newObj)
+	ctx.addDirty(1)
 
 	return protocol.NewInteger(newValue)
 }
@@ -235,16 +326,17 @@ func cmdDecrBy(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPVa
 	}
 
 	// 使用 INCRBY 的负数实现
-	return cmdIncrBy(ctx, []*protocol.RESPValue{args[0], protocol.NewInteger(-decrement)})
+	return cmdIncrBy(ctx, []*protocol.RESPValue{args[0], protocol.NewBulkString(strconv.FormatInt(-decrement, 10))})
 }
 
+// cmdGetRange GETRANGE/SUBSTR key start end
 func cmdGetRange(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
 	key := args[0].ToString()
-	start, err := strconv.Atoi(args[1].ToString())
+	start, err := strconv.ParseInt(args[1].ToString(), 10, 64)
 	if err != nil {
 		return protocol.NewError("ERR value is not an integer or out of range")
 	}
-	end, err := strconv.Atoi(args[2].ToString())
+	end, err := strconv.ParseInt(args[2].ToString(), 10, 64)
 	if err != nil {
 		return protocol.NewError("ERR value is not an integer or out of range")
 	}
@@ -254,37 +346,12 @@ func cmdGetRange(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESP
 		return protocol.NewBulkString("")
 	}
 
-	val, err := obj.GetStringValue()
+	val, err := obj.GetRangeString(start, end)
 	if err != nil {
 		return protocol.NewError("ERR wrong type")
 	}
 
-	str := string(val)
-	length := len(str)
-
-	// 处理负数索引
-	if start < 0 {
-		start = length + start
-	}
-	if end < 0 {
-		end = length + end
-	}
-
-	// 边界检查
-	if start < 0 {
-		start = 0
-	}
-	if start >= length {
-		return protocol.NewBulkString("")
-	}
-	if end >= length {
-		end = length - 1
-	}
-	if end < start {
-		return protocol.NewBulkString("")
-	}
-
-	return protocol.NewBulkString(str[start : end+1])
+	return protocol.NewBulkString(string(val))
 }
 
 func cmdSetRange(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
@@ -296,33 +363,19 @@ func cmdSetRange(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESP
 	value := args[2].ToString()
 
 	obj, err := ctx.Db.Get(key)
-	var currentValue string
 	if err != nil {
-		// 键不存在，创建空字符串
-		currentValue = ""
-	} else {
-		val, err := obj.GetStringValue()
-		if err != nil {
-			return protocol.NewError("ERR wrong type")
-		}
-		currentValue = string(val)
-	}
-
-	// 扩展字符串到需要的长度
-	requiredLength := offset + len(value)
-	if requiredLength > len(currentValue) {
-		// 用空字符填充
-		padding := make([]byte, requiredLength-len(currentValue))
-		currentValue = currentValue + string(padding)
+		// 键不存在，从空字符串开始
+		obj = storage.NewStringObject(nil)
+		ctx.Db.Set(key, obj)
+	} else if obj, err = unshareStringValue(ctx, key, obj); err != nil {
+		return protocol.NewError("ERR wrong type")
 	}
 
-	// 替换指定范围
-	result := []byte(currentValue)
-	copy(result[offset:], []byte(value))
-	newObj := storage.NewStringObject(result)
-	ctx.Db.Set(key, newObj)
+	// 原地覆盖指定范围，超出原长度的部分用 \0 填充
+	length, _ := obj.SetRangeString(offset, []byte(value))
+	ctx.addDirty(1)
 
-	return protocol.NewInteger(int64(len(result)))
+	return protocol.NewInteger(int64(length))
 }
 
 func cmdSetBit(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
@@ -374,6 +427,7 @@ func cmdSetBit(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPVa
 	// 保存
 	newObj := storage.NewStringObject(currentValue)
 	ctx.Db.Set(key, newObj)
+	ctx.addDirty(1)
 
 	return protocol.NewInteger(int64(oldBit))
 }
@@ -557,6 +611,7 @@ func cmdBitOp(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPVal
 	// 保存结果
 	resultObj := storage.NewStringObject(result)
 	ctx.Db.Set(destKey, resultObj)
+	ctx.addDirty(1)
 
 	return protocol.NewInteger(int64(len(result)))
 }
@@ -638,6 +693,33 @@ func cmdDel(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue
 			count++
 		}
 	}
+	ctx.addDirty(count)
+	return protocol.NewInteger(int64(count))
+}
+
+// cmdUnlink UNLINK key [key ...]
+// 与 DEL 一样立即从键空间删除，但大对象交给后台释放，不阻塞当前命令
+func cmdUnlink(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	count := 0
+	for _, arg := range args {
+		if obj, ok := ctx.Db.Unlink(arg.ToString()); ok {
+			ctx.Server.lazyFree.Free(obj)
+			count++
+		}
+	}
+	ctx.addDirty(count)
+	return protocol.NewInteger(int64(count))
+}
+
+// cmdTouch TOUCH key [key ...]
+// 更新键的访问时间，返回存在的键数量
+func cmdTouch(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	count := 0
+	for _, arg := range args {
+		if _, err := ctx.Db.Get(arg.ToString()); err == nil {
+			count++
+		}
+	}
 	return protocol.NewInteger(int64(count))
 }
 
@@ -652,6 +734,8 @@ func cmdExists(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPVa
 	return protocol.NewInteger(int64(count))
 }
 
+// cmdType TYPE key
+// 逻辑上已过期的键返回 none（Db.Type 会顺便删除它）
 func cmdType(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
 	key := args[0].ToString()
 
@@ -671,6 +755,8 @@ func cmdExpire(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPVa
 	}
 
 	if ctx.Db.Expire(key, seconds) {
+		ctx.addDirty(1)
+		propagateExpire(ctx, key)
 		return protocol.NewInteger(1)
 	}
 	return protocol.NewInteger(0)
@@ -695,6 +781,7 @@ func cmdExpireAt(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESP
 	}
 
 	if ctx.Db.ExpireAt(key, timestamp) {
+		ctx.addDirty(1)
 		return protocol.NewInteger(1)
 	}
 	return protocol.NewInteger(0)
@@ -710,6 +797,8 @@ func cmdPExpire(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPV
 	// 转换为秒（简化实现，实际应该支持毫秒级）
 	seconds := milliseconds / 1000
 	if ctx.Db.Expire(key, seconds) {
+		ctx.addDirty(1)
+		propagateExpire(ctx, key)
 		return protocol.NewInteger(1)
 	}
 	return protocol.NewInteger(0)
@@ -725,6 +814,7 @@ func cmdPExpireAt(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RES
 	// 转换为秒级时间戳（简化实现）
 	timestamp := milliseconds / 1000
 	if ctx.Db.ExpireAt(key, timestamp) {
+		ctx.addDirty(1)
 		return protocol.NewInteger(1)
 	}
 	return protocol.NewInteger(0)
@@ -750,11 +840,23 @@ func cmdPersist(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPV
 	key := args[0].ToString()
 
 	if ctx.Db.Persist(key) {
+		ctx.Server.notifyKeyspaceEvent(NOTIFY_GENERIC, "persist", key, ctx.Db.GetID())
+		ctx.addDirty(1)
 		return protocol.NewInteger(1)
 	}
 	return protocol.NewInteger(0)
 }
 
+// propagateExpire 将相对时间的过期设置传播为绝对时间的 PEXPIREAT（已经过期时传播 DEL），
+// 避免 AOF 重放和从节点按各自的当前时间重新计算过期时间
+func propagateExpire(ctx *CommandContext, key string) {
+	if expireAt, ok := ctx.Db.GetExpireAt(key); ok {
+		ctx.Propagate(newCommand("PEXPIREAT", key, strconv.FormatInt(expireAt*1000, 10)))
+	} else {
+		ctx.Propagate(newCommand("DEL", key))
+	}
+}
+
 func cmdRename(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
 	key := args[0].ToString()
 	newKey := args[1].ToString()
@@ -767,15 +869,20 @@ func cmdRename(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPVa
 	if err != nil {
 		return protocol.NewError("ERR no such key")
 	}
+	expireAt, hasExpire := ctx.Db.GetExpireAt(key)
 
-	// 删除旧键
-	ctx.Db.Del(key)
+	// 摘除旧键（对象的引用转移给新键）
+	ctx.Db.Unlink(key)
 
-	// 如果新键存在，先删除
+	// 如果新键存在，先删除（连同它的过期时间）
 	ctx.Db.Del(newKey)
 
-	// 设置新键
+	// 设置新键，过期时间跟随源键
 	ctx.Db.Set(newKey, obj)
+	if hasExpire {
+		ctx.Db.ExpireAt(newKey, expireAt)
+	}
+	ctx.addDirty(1)
 
 	return protocol.NewSimpleString("OK")
 }
@@ -797,24 +904,86 @@ func cmdRenameNx(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESP
 	if err != nil {
 		return protocol.NewError("ERR no such key")
 	}
+	expireAt, hasExpire := ctx.Db.GetExpireAt(key)
 
-	// 删除旧键
-	ctx.Db.Del(key)
+	// 摘除旧键（对象的引用转移给新键）
+	ctx.Db.Unlink(key)
 
-	// 设置新键
+	// 设置新键，过期时间跟随源键
 	ctx.Db.Set(newKey, obj)
+	if hasExpire {
+		ctx.Db.ExpireAt(newKey, expireAt)
+	}
+	ctx.addDirty(1)
+
+	return protocol.NewInteger(1)
+}
+
+// cmdCopy COPY source destination [DB destination-db] [REPLACE]
+// 深拷贝源对象（连同过期时间）到目标键，目标键已存在且没有 REPLACE 时返回 0
+func cmdCopy(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	key := args[0].ToString()
+	newKey := args[1].ToString()
+
+	dstDb := ctx.Db
+	replace := false
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i].ToString()) {
+		case "REPLACE":
+			replace = true
+		case "DB":
+			if i+1 >= len(args) {
+				return protocol.NewError("ERR syntax error")
+			}
+			dbIndex, err := strconv.Atoi(args[i+1].ToString())
+			if err != nil {
+				return protocol.NewError("ERR value is not an integer or out of range")
+			}
+			db, err := ctx.Server.GetRedisServer().GetDb(dbIndex)
+			if err != nil {
+				return protocol.NewError("ERR DB index is out of range")
+			}
+			dstDb = db
+			i++
+		default:
+			return protocol.NewError("ERR syntax error")
+		}
+	}
+
+	if dstDb == ctx.Db && key == newKey {
+		return protocol.NewError("ERR source and destination objects are the same")
+	}
+
+	obj, err := ctx.Db.Get(key)
+	if err != nil {
+		return protocol.NewInteger(0)
+	}
+
+	if dstDb.Exists(newKey) {
+		if !replace {
+			return protocol.NewInteger(0)
+		}
+		dstDb.Del(newKey)
+	}
+
+	expireAt, hasExpire := ctx.Db.GetExpireAt(key)
+
+	dstDb.Set(newKey, obj.Clone())
+	if hasExpire {
+		dstDb.ExpireAt(newKey, expireAt)
+	}
+	ctx.addDirty(1)
 
 	return protocol.NewInteger(1)
 }
 
+// cmdRandomKey RANDOMKEY：随机返回一个未过期的键，数据库为空时返回 nil
 func cmdRandomKey(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
-	keys := ctx.Db.Keys("*")
-	if len(keys) == 0 {
+	key, ok := ctx.Db.RandomKey()
+	if !ok {
 		return protocol.NewNullBulkString()
 	}
-
-	// 简化实现：返回第一个键（实际应该随机）
-	return protocol.NewBulkString(keys[0])
+	return protocol.NewBulkString(key)
 }
 
 func cmdMove(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
@@ -830,32 +999,35 @@ func cmdMove(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValu
 		return protocol.NewError("ERR DB index is out of range")
 	}
 
-	// 检查键是否存在
-	if !ctx.Db.Exists(key) {
-		return protocol.NewInteger(0)
-	}
-
-	// 检查目标数据库是否已有该键
 	targetDb, err := redisServer.GetDb(dbIndex)
 	if err != nil {
 		return protocol.NewError("ERR invalid DB index")
 	}
-
-	if targetDb.Exists(key) {
-		return protocol.NewInteger(0)
+	if targetDb == ctx.Db {
+		return protocol.NewError("ERR source and destination objects are the same")
 	}
 
-	// 获取对象
+	// 获取对象（逻辑上已过期的键视为不存在）
 	obj, err := ctx.Db.Get(key)
 	if err != nil {
 		return protocol.NewInteger(0)
 	}
 
-	// 从当前数据库删除
-	ctx.Db.Del(key)
+	// 检查目标数据库是否已有该键
+	if targetDb.Exists(key) {
+		return protocol.NewInteger(0)
+	}
+	expireAt, hasExpire := ctx.Db.GetExpireAt(key)
+
+	// 从当前数据库摘除（对象的引用转移给目标数据库）
+	ctx.Db.Unlink(key)
 
-	// 添加到目标数据库
+	// 添加到目标数据库，过期时间跟随键
 	targetDb.Set(key, obj)
+	if hasExpire {
+		targetDb.ExpireAt(key, expireAt)
+	}
+	ctx.addDirty(1)
 
 	return protocol.NewInteger(1)
 }
@@ -868,22 +1040,35 @@ func cmdObject(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPVa
 	subcommand := strings.ToUpper(args[0].ToString())
 	key := args[1].ToString()
 
+	// OBJECT 只查看对象，不更新访问时间
 	switch subcommand {
 	case "ENCODING":
-		obj, err := ctx.Db.Get(key)
+		obj, err := ctx.Db.Peek(key)
 		if err != nil {
 			return protocol.NewError("ERR no such key")
 		}
 		return protocol.NewBulkString(obj.EncodingString())
 	case "REFCOUNT":
-		obj, err := ctx.Db.Get(key)
+		obj, err := ctx.Db.Peek(key)
 		if err != nil {
 			return protocol.NewError("ERR no such key")
 		}
 		return protocol.NewInteger(int64(obj.RefCount))
 	case "IDLETIME":
-		// 简化实现：返回 0
-		return protocol.NewInteger(0)
+		obj, err := ctx.Db.Peek(key)
+		if err != nil {
+			return protocol.NewError("ERR no such key")
+		}
+		return protocol.NewInteger(obj.IdleTime())
+	case "FREQ":
+		obj, err := ctx.Db.Peek(key)
+		if err != nil {
+			return protocol.NewError("ERR no such key")
+		}
+		if !isLFUPolicy(ctx.Server.maxmemoryPolicy()) {
+			return protocol.NewError("ERR An LFU maxmemory policy is not selected, access frequency not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.")
+		}
+		return protocol.NewInteger(int64(obj.Freq()))
 	default:
 		return protocol.NewError("ERR unknown subcommand or wrong number of arguments")
 	}
@@ -933,71 +1118,97 @@ func cmdSelect(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPVa
 
 func cmdFlushDB(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
 	ctx.Db.FlushDB()
+	ctx.addDirty(1) // 数据库为空时也写入 AOF 并传播
 	return protocol.NewSimpleString("OK")
 }
 
 func cmdFlushAll(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
 	ctx.Server.GetRedisServer().FlushAll()
+	ctx.addDirty(1) // 数据库为空时也写入 AOF 并传播
 	return protocol.NewSimpleString("OK")
 }
 
+// cmdScan SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+// 游标由 Db.ScanBucket 维护，遍历期间一直存在的键至少返回一次（插入、删除其它键不影响）
 func cmdScan(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
-	// SCAN cursor [MATCH pattern] [COUNT count]
-	cursor, err := strconv.ParseInt(args[0].ToString(), 10, 64)
-	if err != nil {
-		return protocol.NewError("ERR invalid cursor")
+	sa, errReply := parseScanArgs(args, "scan")
+	if errReply != nil {
+		return errReply
 	}
 
-	// 解析可选参数
-	pattern := "*"
-	count := int64(10)
+	nextCursor, keys := ctx.Db.ScanBucket(sa.cursor, sa.count, sa.pattern, sa.typeName)
 
-	for i := 1; i < len(args); i++ {
-		arg := args[i].ToString()
-		if arg == "MATCH" && i+1 < len(args) {
-			pattern = args[i+1].ToString()
-			i++
-		} else if arg == "COUNT" && i+1 < len(args) {
-			if c, err := strconv.ParseInt(args[i+1].ToString(), 10, 64); err == nil {
-				count = c
-			}
-			i++
-		}
+	keyValues := make([]*protocol.RESPValue, len(keys))
+	for i, key := range keys {
+		keyValues[i] = protocol.NewBulkString(key)
 	}
 
-	// 获取所有键
-	allKeys := ctx.Db.Keys(pattern)
+	return scanReply(nextCursor, keyValues)
+}
 
-	// 分页处理
-	start := int(cursor)
-	if start >= len(allKeys) {
-		// 扫描完成
-		return protocol.NewArray([]*protocol.RESPValue{
-			protocol.NewBulkString("0"),                // 新的 cursor (0 表示完成)
-			protocol.NewArray([]*protocol.RESPValue{}), // 空的键列表
-		})
-	}
+// scanArgs SCAN/ZSCAN/HSCAN 的公共参数
+type scanArgs struct {
+	cursor   int64
+	pattern  string // 空表示不过滤
+	count    int
+	noValues bool   // HSCAN NOVALUES：只返回字段名
+	typeName string // SCAN TYPE：只返回指定类型的键（空表示不过滤）
+}
 
-	end := start + int(count)
-	if end > len(allKeys) {
-		end = len(allKeys)
-	}
+// parseScanArgs 解析 cursor [MATCH pattern] [COUNT count]
+// cmd 为命令名（小写）：只有 hscan 接受 NOVALUES，只有 scan 接受 TYPE
+func parseScanArgs(args []*protocol.RESPValue, cmd string) (*scanArgs, *protocol.RESPValue) {
+	allowNoValues := cmd == "hscan"
+	allowType := cmd == "scan"
 
-	keys := allKeys[start:end]
-	nextCursor := int64(end)
-	if end >= len(allKeys) {
-		nextCursor = 0 // 扫描完成
+	cursor, err := strconv.ParseUint(args[0].ToString(), 10, 64)
+	if err != nil || cursor > math.MaxInt64 {
+		return nil, protocol.NewError("ERR invalid cursor")
 	}
 
-	// 构建响应
-	keyValues := make([]*protocol.RESPValue, len(keys))
-	for i, key := range keys {
-		keyValues[i] = protocol.NewBulkString(key)
+	sa := &scanArgs{cursor: int64(cursor), count: 10}
+	for i := 1; i < len(args); i++ {
+		if allowNoValues && strings.ToUpper(args[i].ToString()) == "NOVALUES" {
+			sa.noValues = true
+			continue
+		}
+		if i+1 >= len(args) {
+			return nil, protocol.NewError("ERR syntax error")
+		}
+		switch strings.ToUpper(args[i].ToString()) {
+		case "MATCH":
+			sa.pattern = args[i+1].ToString()
+			if sa.pattern == "*" {
+				sa.pattern = ""
+			}
+		case "COUNT":
+			count, err := strconv.Atoi(args[i+1].ToString())
+			if err != nil {
+				return nil, protocol.NewError("ERR value is not an integer or out of range")
+			}
+			if count < 1 {
+				return nil, protocol.NewError("ERR syntax error")
+			}
+			sa.count = count
+		case "TYPE":
+			if !allowType {
+				return nil, protocol.NewError("ERR syntax error")
+			}
+			sa.typeName = args[i+1].ToString()
+		default:
+			return nil, protocol.NewError("ERR syntax error")
+		}
+		i++
 	}
 
+	return sa, nil
+}
+
+// scanReply 构造 [nextCursor, [elements...]] 回复
+func scanReply(nextCursor int64, elements []*protocol.RESPValue) *protocol.RESPValue {
 	return protocol.NewArray([]*protocol.RESPValue{
 		protocol.NewBulkString(strconv.FormatInt(nextCursor, 10)),
-		protocol.NewArray(keyValues),
+		protocol.NewArray(elements),
 	})
 }
 
@@ -1026,22 +1237,15 @@ func cmdLPush(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPVal
 		list.Push([]byte(value), 0) // HEAD
 		count++
 	}
+	ctx.addDirty(count)
 
-	// 记录到 AOF
-	if ctx.Server.aofWriter != nil {
-		cmd := protocol.NewArray(args)
-		ctx.Server.aofWriter.Append(cmd)
-	}
+	// 回复推入后的长度（阻塞的客户端随后可能会取走元素）
+	length := list.Len()
 
-	// 通知阻塞的客户端
-	if list.Len() > 0 {
-		ctx.Server.blockingMgr.Notify(key, protocol.NewArray([]*protocol.RESPValue{
-			protocol.NewBulkString(key),
-			protocol.NewBulkString(string(args[1].ToString())),
-		}))
-	}
+	// 按阻塞的先后顺序，代替阻塞在该键上的客户端弹出元素
+	ctx.Server.blockingMgr.SignalKeyReady(ctx, key)
 
-	return protocol.NewInteger(int64(list.Len()))
+	return protocol.NewInteger(int64(length))
 }
 
 func cmdRPush(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
@@ -1067,26 +1271,42 @@ func cmdRPush(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPVal
 		list.Push([]byte(value), 1) // TAIL
 		count++
 	}
+	ctx.addDirty(count)
 
-	// 记录到 AOF
-	if ctx.Server.aofWriter != nil {
-		cmd := protocol.NewArray(args)
-		ctx.Server.aofWriter.Append(cmd)
-	}
+	// 回复推入后的长度（阻塞的客户端随后可能会取走元素）
+	length := list.Len()
 
-	// 通知阻塞的客户端
-	if list.Len() > 0 {
-		ctx.Server.blockingMgr.Notify(key, protocol.NewArray([]*protocol.RESPValue{
-			protocol.NewBulkString(key),
-			protocol.NewBulkString(string(args[1].ToString())),
-		}))
-	}
+	// 按阻塞的先后顺序，代替阻塞在该键上的客户端弹出元素
+	ctx.Server.blockingMgr.SignalKeyReady(ctx, key)
 
-	return protocol.NewInteger(int64(list.Len()))
+	return protocol.NewInteger(int64(length))
 }
 
+// cmdLPop LPOP key [count]
 func cmdLPop(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	return listPopCommand(ctx, args, 0) // HEAD
+}
+
+// cmdRPop RPOP key [count]
+func cmdRPop(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	return listPopCommand(ctx, args, 1) // TAIL
+}
+
+// listPopCommand LPOP/RPOP 的公共实现
+// 不带 count 时返回单个元素；带 count 时总是返回数组（count 为 0 时返回空数组）
+func listPopCommand(ctx *CommandContext, args []*protocol.RESPValue, where int) *protocol.RESPValue {
+	if len(args) > 2 {
+		return protocol.NewError("ERR syntax error")
+	}
+
 	key := args[0].ToString()
+	count := -1 // -1 表示没有 count 参数
+	if len(args) == 2 {
+		var errReply *protocol.RESPValue
+		if count, errReply = parsePopCount(args[1]); errReply != nil {
+			return errReply
+		}
+	}
 
 	obj, err := ctx.Db.Get(key)
 	if err != nil {
@@ -1098,33 +1318,49 @@ func cmdLPop(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValu
 		return protocol.NewError("ERR wrong type")
 	}
 
-	value, err := list.Pop(0) // HEAD
-	if err != nil {
-		return protocol.NewNullBulkString()
-	}
+	if count < 0 {
+		value, err := list.Pop(where)
+		if err != nil {
+			return protocol.NewNullBulkString()
+		}
 
-	return protocol.NewBulkString(string(value))
-}
+		// 列表为空时删除键
+		if list.Len() == 0 {
+			ctx.Db.Del(key)
+		}
+		ctx.addDirty(1)
 
-func cmdRPop(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
-	key := args[0].ToString()
+		return protocol.NewBulkString(string(value))
+	}
 
-	obj, err := ctx.Db.Get(key)
-	if err != nil {
-		return protocol.NewNullBulkString()
+	results := make([]*protocol.RESPValue, 0, count)
+	for len(results) < count {
+		value, err := list.Pop(where)
+		if err != nil {
+			break
+		}
+		results = append(results, protocol.NewBulkString(string(value)))
 	}
+	ctx.addDirty(len(results))
 
-	list, err := obj.GetList()
-	if err != nil {
-		return protocol.NewError("ERR wrong type")
+	// 列表为空时删除键
+	if list.Len() == 0 {
+		ctx.Db.Del(key)
 	}
 
-	value, err := list.Pop(1) // TAIL
+	return protocol.NewArray(results)
+}
+
+// parsePopCount 解析 LPOP/RPOP/SPOP 的 count 参数（必须为非负整数）
+func parsePopCount(arg *protocol.RESPValue) (int, *protocol.RESPValue) {
+	count, err := strconv.Atoi(arg.ToString())
 	if err != nil {
-		return protocol.NewNullBulkString()
+		return 0, protocol.NewError("ERR value is not an integer or out of range")
 	}
-
-	return protocol.NewBulkString(string(value))
+	if count < 0 {
+		return 0, protocol.NewError("ERR value is out of range, must be positive")
+	}
+	return count, nil
 }
 
 func cmdLLen(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
@@ -1261,6 +1497,7 @@ func cmdLInsert(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPV
 	}
 
 	ctx.Db.Set(key, newListObj)
+	ctx.addDirty(1)
 
 	return protocol.NewInteger(int64(list.Len() + 1))
 }
@@ -1340,6 +1577,7 @@ func cmdLRem(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValu
 		}
 		ctx.Db.Set(key, newListObj)
 	}
+	ctx.addDirty(removed)
 
 	return protocol.NewInteger(int64(removed))
 }
@@ -1387,6 +1625,7 @@ func cmdLSet(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValu
 		newList.Push(v, 1) // TAIL
 	}
 	ctx.Db.Set(key, newListObj)
+	ctx.addDirty(1)
 
 	return protocol.NewSimpleString("OK")
 }
@@ -1432,6 +1671,7 @@ func cmdLTrim(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPVal
 	if start > end || start >= length {
 		// 清空列表
 		ctx.Db.Del(key)
+		ctx.addDirty(length)
 		return protocol.NewSimpleString("OK")
 	}
 
@@ -1448,93 +1688,346 @@ func cmdLTrim(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPVal
 		}
 		ctx.Db.Set(key, newListObj)
 	}
+	ctx.addDirty(length - len(values))
 
 	return protocol.NewSimpleString("OK")
 }
 
 func cmdRPopLPush(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
-	source := args[0].ToString()
-	destination := args[1].ToString()
-
-	// 从源列表弹出
-	sourceObj, err := ctx.Db.Get(source)
-	if err != nil {
-		return protocol.NewNullBulkString()
+	source, destination := args[0].ToString(), args[1].ToString()
+	result := listMove(ctx.Db, source, destination, 1, 0) // TAIL -> HEAD
+	if result.Type != protocol.RESP_ERROR && !result.Null {
+		ctx.addDirty(1)
+		ctx.Server.blockingMgr.SignalKeyReady(ctx, destination)
 	}
+	return result
+}
 
+// cmdLMove LMOVE source destination LEFT|RIGHT LEFT|RIGHT
+func cmdLMove(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	wherefrom, errResp := parseListWhere(args[2])
+	if errResp != nil {
+		return errResp
+	}
+	whereto, errResp := parseListWhere(args[3])
+	if errResp != nil {
+		return errResp
+	}
+
+	source, destination := args[0].ToString(), args[1].ToString()
+	result := listMove(ctx.Db, source, destination, wherefrom, whereto)
+	if result.Type != protocol.RESP_ERROR && !result.Null {
+		ctx.addDirty(1)
+		ctx.Server.blockingMgr.SignalKeyReady(ctx, destination)
+	}
+	return result
+}
+
+// cmdBRPopLPush BRPOPLPUSH source destination timeout
+func cmdBRPopLPush(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	timeout, errResp := parseBlockTimeout(args[2])
+	if errResp != nil {
+		return errResp
+	}
+	return blockingListMove(ctx, args[0].ToString(), args[1].ToString(), 1, 0, timeout) // TAIL -> HEAD
+}
+
+// cmdBLMove BLMOVE source destination LEFT|RIGHT LEFT|RIGHT timeout
+func cmdBLMove(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	wherefrom, errResp := parseListWhere(args[2])
+	if errResp != nil {
+		return errResp
+	}
+	whereto, errResp := parseListWhere(args[3])
+	if errResp != nil {
+		return errResp
+	}
+	timeout, errResp := parseBlockTimeout(args[4])
+	if errResp != nil {
+		return errResp
+	}
+	return blockingListMove(ctx, args[0].ToString(), args[1].ToString(), wherefrom, whereto, timeout)
+}
+
+// blockingListMove BRPOPLPUSH/BLMOVE 的公共实现：源列表为空时阻塞，
+// 有元素推入源列表时由推入方代为完成移动，超时返回 nil
+func blockingListMove(ctx *CommandContext, source, destination string, wherefrom, whereto int, timeout time.Duration) *protocol.RESPValue {
+	// 源列表不是列表时立即报错，而不是一直阻塞
+	if obj, err := ctx.Db.Get(source); err == nil {
+		if _, err := obj.GetList(); err != nil {
+			return protocol.NewError("ERR wrong type")
+		}
+	}
+
+	db := ctx.Db
+	result := blockForKeys(ctx, []string{source}, timeout, func(key string) *blockingServe {
+		result := listMove(db, source, destination, wherefrom, whereto)
+		if result.Null {
+			return nil
+		}
+		if result.Type == protocol.RESP_ERROR {
+			return &blockingServe{reply: result}
+		}
+		// 传播为不阻塞的 LMOVE；元素进入了目标列表，继续为等待目标列表的客户端执行
+		return &blockingServe{
+			reply:  result,
+			effect: newCommand("LMOVE", source, destination, listWhereName(wherefrom), listWhereName(whereto)),
+			ready:  destination,
+		}
+	})
+	if result == nil {
+		return protocol.NewNullBulkString()
+	}
+	return result
+}
+
+// listMove 从源列表的 wherefrom 端弹出一个元素并推入目标列表的 whereto 端（0 为头部，1 为尾部），
+// 返回被移动的元素；源列表不存在或为空时返回 nil
+func listMove(db *storage.RedisDb, source, destination string, wherefrom, whereto int) *protocol.RESPValue {
+	sourceObj, err := db.Get(source)
+	if err != nil {
+		return protocol.NewNullBulkString()
+	}
 	sourceList, err := sourceObj.GetList()
 	if err != nil {
 		return protocol.NewError("ERR wrong type")
 	}
-
 	if sourceList.Len() == 0 {
 		return protocol.NewNullBulkString()
 	}
 
-	// 弹出尾部元素
-	value, err := sourceList.Pop(1) // TAIL
+	// 先检查目标类型，避免元素弹出后无处可放
+	var destList *structure.RedisList
+	if destObj, err := db.Get(destination); err == nil {
+		if destList, err = destObj.GetList(); err != nil {
+			return protocol.NewError("ERR wrong type")
+		}
+	}
+
+	value, err := sourceList.Pop(wherefrom)
 	if err != nil {
 		return protocol.NewNullBulkString()
 	}
-
-	// 更新源列表
 	if sourceList.Len() == 0 {
-		ctx.Db.Del(source)
-	} else {
-		// 重建源列表（简化实现）
-		allValues, _ := sourceList.Range(0, sourceList.Len()-1)
-		ctx.Db.Del(source)
-		if len(allValues) > 0 {
-			newSourceObj := storage.NewListObject()
-			newSourceList, _ := newSourceObj.GetList()
-			for _, v := range allValues {
-				newSourceList.Push(v, 1)
-			}
-			ctx.Db.Set(source, newSourceObj)
+		db.Del(source)
+		// 源和目标是同一个列表时，列表已随元素弹出被删除，需要重新创建
+		if source == destination {
+			destList = nil
 		}
 	}
 
-	// 推入目标列表头部
-	destObj, err := ctx.Db.Get(destination)
-	var destList *structure.RedisList
-	if err != nil {
-		// 创建新列表
-		destObj = storage.NewListObject()
-		ctx.Db.Set(destination, destObj)
+	if destList == nil {
+		destObj := storage.NewListObject()
 		destList, _ = destObj.GetList()
-	} else {
-		destList, err = destObj.GetList()
+		db.Set(destination, destObj)
+	}
+	destList.Push(value, whereto)
+
+	return protocol.NewBulkString(string(value))
+}
+
+// parseListWhere 解析 LEFT|RIGHT（LEFT 为头部 0，RIGHT 为尾部 1）
+func parseListWhere(arg *protocol.RESPValue) (int, *protocol.RESPValue) {
+	switch strings.ToUpper(arg.ToString()) {
+	case "LEFT":
+		return 0, nil // HEAD
+	case "RIGHT":
+		return 1, nil // TAIL
+	default:
+		return 0, protocol.NewError("ERR syntax error")
+	}
+}
+
+// listWhereName parseListWhere 的逆操作
+func listWhereName(where int) string {
+	if where == 1 {
+		return "RIGHT"
+	}
+	return "LEFT"
+}
+
+func cmdLPos(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	key := args[0].ToString()
+	element := args[1].ToString()
+
+	rank := 1
+	count := -1 // -1 表示未指定 COUNT，返回单个结果
+	maxLen := 0
+
+	// 解析可选参数
+	for i := 2; i < len(args); i++ {
+		arg := strings.ToUpper(args[i].ToString())
+		if i+1 >= len(args) {
+			return protocol.NewError("ERR syntax error")
+		}
+		value, err := strconv.Atoi(args[i+1].ToString())
 		if err != nil {
-			return protocol.NewError("ERR wrong type")
+			return protocol.NewError("ERR value is not an integer or out of range")
+		}
+		switch arg {
+		case "RANK":
+			if value == 0 {
+				return protocol.NewError("ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list")
+			}
+			rank = value
+		case "COUNT":
+			if value < 0 {
+				return protocol.NewError("ERR COUNT can't be negative")
+			}
+			count = value
+		case "MAXLEN":
+			if value < 0 {
+				return protocol.NewError("ERR MAXLEN can't be negative")
+			}
+			maxLen = value
+		default:
+			return protocol.NewError("ERR syntax error")
 		}
+		i++
 	}
 
-	destList.Push(value, 0) // HEAD
+	obj, err := ctx.Db.Get(key)
+	if err != nil {
+		if count >= 0 {
+			return protocol.NewArray([]*protocol.RESPValue{})
+		}
+		return protocol.NewNullBulkString()
+	}
 
-	return protocol.NewBulkString(string(value))
+	list, err := obj.GetList()
+	if err != nil {
+		return protocol.NewError("ERR wrong type")
+	}
+
+	values, _ := list.Range(0, -1)
+	length := len(values)
+
+	// rank 为负数时从尾部开始查找
+	matches := make([]*protocol.RESPValue, 0)
+	skip := rank
+	if skip < 0 {
+		skip = -skip
+	}
+	for step := 0; step < length; step++ {
+		if maxLen > 0 && step >= maxLen {
+			break
+		}
+		idx := step
+		if rank < 0 {
+			idx = length - 1 - step
+		}
+		if string(values[idx]) != element {
+			continue
+		}
+		if skip > 1 {
+			skip--
+			continue
+		}
+		matches = append(matches, protocol.NewInteger(int64(idx)))
+		if count < 0 || (count > 0 && len(matches) >= count) {
+			break
+		}
+	}
+
+	if count < 0 {
+		if len(matches) == 0 {
+			return protocol.NewNullBulkString()
+		}
+		return matches[0]
+	}
+	return protocol.NewArray(matches)
 }
 
-func cmdBRPopLPush(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
-	source := args[0].ToString()
-	_ = args[1].ToString() // destination
-	_, err := strconv.Atoi(args[2].ToString())
+// parseMPopCount 解析 LMPOP/ZMPOP 末尾的 [COUNT count]
+func parseMPopCount(args []*protocol.RESPValue) (int, *protocol.RESPValue) {
+	if len(args) == 0 {
+		return 1, nil
+	}
+	if len(args) != 2 || strings.ToUpper(args[0].ToString()) != "COUNT" {
+		return 0, protocol.NewError("ERR syntax error")
+	}
+	count, err := strconv.Atoi(args[1].ToString())
+	if err != nil || count <= 0 {
+		return 0, protocol.NewError("ERR count should be greater than 0")
+	}
+	return count, nil
+}
+
+// parseNumKeys 解析 numkeys 参数并校验后续参数数量
+func parseNumKeys(args []*protocol.RESPValue) (int, *protocol.RESPValue) {
+	numKeys, err := strconv.Atoi(args[0].ToString())
 	if err != nil {
-		return protocol.NewError("ERR value is not an integer or out of range")
+		return 0, protocol.NewError("ERR value is not an integer or out of range")
+	}
+	if numKeys <= 0 {
+		return 0, protocol.NewError("ERR numkeys should be greater than 0")
 	}
+	if numKeys > len(args)-1 {
+		return 0, protocol.NewError("ERR syntax error")
+	}
+	return numKeys, nil
+}
 
-	// 先尝试非阻塞操作
-	sourceObj, err := ctx.Db.Get(source)
-	if err == nil {
-		sourceList, err := sourceObj.GetList()
-		if err == nil && sourceList.Len() > 0 {
-			// 有数据，直接执行 RPOPLPUSH
-			return cmdRPopLPush(ctx, args[:2])
+func cmdLMPop(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	numKeys, errResp := parseNumKeys(args)
+	if errResp != nil {
+		return errResp
+	}
+	if 1+numKeys >= len(args) {
+		return protocol.NewError("ERR syntax error")
+	}
+
+	where := 0 // HEAD
+	switch strings.ToUpper(args[1+numKeys].ToString()) {
+	case "LEFT":
+		where = 0
+	case "RIGHT":
+		where = 1 // TAIL
+	default:
+		return protocol.NewError("ERR syntax error")
+	}
+
+	count, errResp := parseMPopCount(args[2+numKeys:])
+	if errResp != nil {
+		return errResp
+	}
+
+	// 从第一个非空列表弹出
+	for i := 1; i <= numKeys; i++ {
+		key := args[i].ToString()
+		obj, err := ctx.Db.Get(key)
+		if err != nil {
+			continue
+		}
+		list, err := obj.GetList()
+		if err != nil {
+			return protocol.NewError("ERR wrong type")
+		}
+		if list.Len() == 0 {
+			continue
 		}
+
+		values := make([]*protocol.RESPValue, 0, count)
+		for j := 0; j < count && list.Len() > 0; j++ {
+			value, err := list.Pop(where)
+			if err != nil {
+				break
+			}
+			values = append(values, protocol.NewBulkString(string(value)))
+		}
+		if list.Len() == 0 {
+			ctx.Db.Del(key)
+		}
+		ctx.addDirty(len(values))
+
+		return protocol.NewArray([]*protocol.RESPValue{
+			protocol.NewBulkString(key),
+			protocol.NewArray(values),
+		})
 	}
 
-	// 没有数据，进入阻塞模式（简化实现：立即返回 nil）
-	// 实际应该实现阻塞逻辑
-	return protocol.NewNullBulkString()
+	// 所有键都为空时返回空数组（RESP2 *-1）
+	return protocol.NewNullArray()
 }
 
 // ========== Set 命令实现 ==========
@@ -1558,11 +2051,12 @@ func cmdSAdd(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValu
 
 	count := 0
 	for i := 1; i < len(args); i++ {
-		member := args[i].ToString()
-		if set.Add([]byte(member)) == nil {
+		member := []byte(args[i].ToString())
+		if !set.IsMember(member) && set.Add(member) == nil {
 			count++
 		}
 	}
+	ctx.addDirty(count)
 
 	return protocol.NewInteger(int64(count))
 }
@@ -1587,6 +2081,12 @@ func cmdSRem(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValu
 			count++
 		}
 	}
+	ctx.addDirty(count)
+
+	// 集合为空时删除键
+	if set.Card() == 0 {
+		ctx.Db.Del(key)
+	}
 
 	return protocol.NewInteger(int64(count))
 }
@@ -1784,19 +2284,27 @@ func cmdSDiff(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPVal
 	return protocol.NewArray(results)
 }
 
+// cmdSPop SPOP key [count]
+// 不带 count 时返回单个成员；带 count 时总是返回数组（count 为 0 时返回空数组）
 func cmdSPop(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	if len(args) > 2 {
+		return protocol.NewError("ERR syntax error")
+	}
+
 	key := args[0].ToString()
-	count := 1
-	if len(args) > 1 {
-		var err error
-		count, err = strconv.Atoi(args[1].ToString())
-		if err != nil || count < 0 {
-			return protocol.NewError("ERR value is not an integer or out of range")
+	count := -1 // -1 表示没有 count 参数
+	if len(args) == 2 {
+		var errReply *protocol.RESPValue
+		if count, errReply = parsePopCount(args[1]); errReply != nil {
+			return errReply
 		}
 	}
 
 	obj, err := ctx.Db.Get(key)
 	if err != nil {
+		if count >= 0 {
+			return protocol.NewArray([]*protocol.RESPValue{})
+		}
 		return protocol.NewNullBulkString()
 	}
 
@@ -1805,31 +2313,41 @@ func cmdSPop(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValu
 		return protocol.NewError("ERR wrong type")
 	}
 
-	if set.Card() == 0 {
-		return protocol.NewNullBulkString()
-	}
-
 	// 获取随机成员并删除
 	members := set.Members()
-	if count >= len(members) {
-		count = len(members)
+	n := count
+	if n < 0 {
+		n = 1
+	}
+	if n > len(members) {
+		n = len(members)
 	}
 
 	// 简化实现：随机选择（实际应该使用真正的随机）
-	popped := make([][]byte, 0, count)
-	for i := 0; i < count && len(members) > 0; i++ {
-		member := members[i]
+	popped := members[:n]
+	for _, member := range popped {
 		set.Remove(member)
-		popped = append(popped, member)
-		// 更新 members（移除已弹出的）
-		members = append(members[:i], members[i+1:]...)
-		i--
 	}
+	ctx.addDirty(len(popped))
 
-	if len(popped) == 0 {
-		return protocol.NewNullBulkString()
+	// 传播为删除被选中成员的 SREM，重放时不再重新选择
+	if len(popped) > 0 {
+		srem := []string{key}
+		for _, member := range popped {
+			srem = append(srem, string(member))
+		}
+		ctx.Propagate(newCommand("SREM", srem...))
+	}
+
+	// 集合为空时删除键
+	if set.Card() == 0 {
+		ctx.Db.Del(key)
 	}
-	if len(popped) == 1 {
+
+	if count < 0 {
+		if len(popped) == 0 {
+			return protocol.NewNullBulkString()
+		}
 		return protocol.NewBulkString(string(popped[0]))
 	}
 
@@ -1912,25 +2430,31 @@ func cmdSMove(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPVal
 		return protocol.NewInteger(0)
 	}
 
-	// 从源集合删除
-	sourceSet.Remove([]byte(member))
-
-	// 添加到目标集合
+	// 先检查目标集合类型，避免源集合的成员丢失
 	destObj, err := ctx.Db.Get(destination)
 	var destSet *structure.RedisSet
-	if err != nil {
-		// 创建新集合
-		destObj = storage.NewSetObject()
-		ctx.Db.Set(destination, destObj)
-		destSet, _ = destObj.GetSet()
-	} else {
+	if err == nil {
 		destSet, err = destObj.GetSet()
 		if err != nil {
 			return protocol.NewError("ERR wrong type")
 		}
 	}
 
+	// 从源集合删除，集合为空时删除键
+	sourceSet.Remove([]byte(member))
+	if sourceSet.Card() == 0 {
+		ctx.Db.Del(source)
+	}
+
+	// 添加到目标集合
+	if destSet == nil {
+		// 创建新集合
+		destObj = storage.NewSetObject()
+		ctx.Db.Set(destination, destObj)
+		destSet, _ = destObj.GetSet()
+	}
 	destSet.Add([]byte(member))
+	ctx.addDirty(1)
 
 	return protocol.NewInteger(1)
 }
@@ -1949,7 +2473,9 @@ func cmdSInterStore(ctx *CommandContext, args []*protocol.RESPValue) *protocol.R
 		obj, err := ctx.Db.Get(key)
 		if err != nil {
 			// 如果任何一个集合不存在，结果为空
-			ctx.Db.Del(destination)
+			if ctx.Db.Del(destination) {
+				ctx.addDirty(1)
+			}
 			return protocol.NewInteger(0)
 		}
 		set, err := obj.GetSet()
@@ -1960,7 +2486,9 @@ func cmdSInterStore(ctx *CommandContext, args []*protocol.RESPValue) *protocol.R
 	}
 
 	if len(sets) == 0 {
-		ctx.Db.Del(destination)
+		if ctx.Db.Del(destination) {
+			ctx.addDirty(1)
+		}
 		return protocol.NewInteger(0)
 	}
 
@@ -1978,6 +2506,7 @@ func cmdSInterStore(ctx *CommandContext, args []*protocol.RESPValue) *protocol.R
 		resultSet.Add(member)
 	}
 	ctx.Db.Set(destination, resultObj)
+	ctx.addDirty(1)
 
 	return protocol.NewInteger(int64(len(members)))
 }
@@ -2005,7 +2534,9 @@ func cmdSUnionStore(ctx *CommandContext, args []*protocol.RESPValue) *protocol.R
 	}
 
 	if len(sets) == 0 {
-		ctx.Db.Del(destination)
+		if ctx.Db.Del(destination) {
+			ctx.addDirty(1)
+		}
 		return protocol.NewInteger(0)
 	}
 
@@ -2023,6 +2554,7 @@ func cmdSUnionStore(ctx *CommandContext, args []*protocol.RESPValue) *protocol.R
 		resultSet.Add(member)
 	}
 	ctx.Db.Set(destination, resultObj)
+	ctx.addDirty(1)
 
 	return protocol.NewInteger(int64(len(members)))
 }
@@ -2038,7 +2570,9 @@ func cmdSDiffStore(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RE
 	key1 := args[1].ToString()
 	obj1, err := ctx.Db.Get(key1)
 	if err != nil {
-		ctx.Db.Del(destination)
+		if ctx.Db.Del(destination) {
+			ctx.addDirty(1)
+		}
 		return protocol.NewInteger(0)
 	}
 
@@ -2076,69 +2610,254 @@ func cmdSDiffStore(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RE
 		resultSet.Add(member)
 	}
 	ctx.Db.Set(destination, resultObj)
+	ctx.addDirty(1)
 
 	return protocol.NewInteger(int64(len(members)))
 }
 
-// ========== ZSet 命令实现 ==========
+func cmdSInterCard(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	numKeys, errResp := parseNumKeys(args)
+	if errResp != nil {
+		return errResp
+	}
 
-func cmdZAdd(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
-	key := args[0].ToString()
+	limit, errResp := parseCardLimit(args[1+numKeys:])
+	if errResp != nil {
+		return errResp
+	}
 
-	obj, err := ctx.Db.Get(key)
-	var zset *structure.RedisZSet
-	if err != nil {
-		// 创建新的 ZSet
-		zsetObj := storage.NewZSetObject()
-		ctx.Db.Set(key, zsetObj)
-		zset, _ = zsetObj.GetZSet()
-	} else {
-		zset, err = obj.GetZSet()
+	sets := make([]*structure.RedisSet, 0, numKeys)
+	for i := 1; i <= numKeys; i++ {
+		obj, err := ctx.Db.Get(args[i].ToString())
+		if err != nil {
+			// 任何一个集合不存在，交集为空
+			return protocol.NewInteger(0)
+		}
+		set, err := obj.GetSet()
 		if err != nil {
 			return protocol.NewError("ERR wrong type")
 		}
+		sets = append(sets, set)
 	}
 
 	count := 0
-	for i := 1; i < len(args); i += 2 {
-		if i+1 >= len(args) {
-			break
-		}
-		score, err := strconv.ParseFloat(args[i].ToString(), 64)
-		if err != nil {
-			return protocol.NewError("ERR value is not a valid float")
+	for _, member := range sets[0].Members() {
+		inAll := true
+		for _, other := range sets[1:] {
+			if !other.IsMember(member) {
+				inAll = false
+				break
+			}
 		}
-		member := args[i+1].ToString()
-		if zset.Add([]byte(member), score) == nil {
+		if inAll {
 			count++
+			if limit > 0 && count >= limit {
+				break
+			}
 		}
 	}
 
 	return protocol.NewInteger(int64(count))
 }
 
-func cmdZRem(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+// parseCardLimit 解析 SINTERCARD/ZINTERCARD 末尾的 [LIMIT limit]，0 表示不限制
+func parseCardLimit(args []*protocol.RESPValue) (int, *protocol.RESPValue) {
+	if len(args) == 0 {
+		return 0, nil
+	}
+	if len(args) != 2 || strings.ToUpper(args[0].ToString()) != "LIMIT" {
+		return 0, protocol.NewError("ERR syntax error")
+	}
+	limit, err := strconv.Atoi(args[1].ToString())
+	if err != nil || limit < 0 {
+		return 0, protocol.NewError("ERR LIMIT can't be negative")
+	}
+	return limit, nil
+}
+
+func cmdSMIsMember(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
 	key := args[0].ToString()
 
+	var set *structure.RedisSet
 	obj, err := ctx.Db.Get(key)
-	if err != nil {
-		return protocol.NewInteger(0)
-	}
-
-	zset, err := obj.GetZSet()
-	if err != nil {
-		return protocol.NewError("ERR wrong type")
+	if err == nil {
+		set, err = obj.GetSet()
+		if err != nil {
+			return protocol.NewError("ERR wrong type")
+		}
 	}
 
-	count := 0
+	results := make([]*protocol.RESPValue, 0, len(args)-1)
 	for i := 1; i < len(args); i++ {
-		member := args[i].ToString()
-		if zset.Remove([]byte(member)) == nil {
-			count++
+		if set != nil && set.IsMember([]byte(args[i].ToString())) {
+			results = append(results, protocol.NewInteger(1))
+		} else {
+			results = append(results, protocol.NewInteger(0))
 		}
 	}
 
-	return protocol.NewInteger(int64(count))
+	return protocol.NewArray(results)
+}
+
+// ========== ZSet 命令实现 ==========
+
+// cmdZAdd ZADD key [NX|XX] [GT|LT] [CH] [INCR] score member [score member ...]
+func cmdZAdd(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	key := args[0].ToString()
+
+	// 解析选项
+	var nx, xx, gt, lt, ch, incr bool
+	i := 1
+options:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i].ToString()) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "GT":
+			gt = true
+		case "LT":
+			lt = true
+		case "CH":
+			ch = true
+		case "INCR":
+			incr = true
+		default:
+			break options
+		}
+	}
+
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return protocol.NewError("ERR syntax error")
+	}
+	if nx && xx {
+		return protocol.NewError("ERR XX and NX options at the same time are not compatible")
+	}
+	if gt && lt || nx && (gt || lt) {
+		return protocol.NewError("ERR GT, LT, and/or NX options at the same time are not compatible")
+	}
+	if incr && len(pairs) > 2 {
+		return protocol.NewError("ERR INCR option supports a single increment-element pair")
+	}
+
+	// 先校验所有 score，避免部分写入
+	scores := make([]float64, 0, len(pairs)/2)
+	for j := 0; j < len(pairs); j += 2 {
+		score, err := strconv.ParseFloat(pairs[j].ToString(), 64)
+		if err != nil || math.IsNaN(score) {
+			return protocol.NewError("ERR value is not a valid float")
+		}
+		scores = append(scores, score)
+	}
+
+	obj, err := ctx.Db.Get(key)
+	var zset *structure.RedisZSet
+	if err != nil {
+		if xx {
+			// XX 不创建新键
+			if incr {
+				return protocol.NewNullBulkString()
+			}
+			return protocol.NewInteger(0)
+		}
+		// 创建新的 ZSet
+		zsetObj := storage.NewZSetObject()
+		ctx.Db.Set(key, zsetObj)
+		zset, _ = zsetObj.GetZSet()
+	} else {
+		zset, err = obj.GetZSet()
+		if err != nil {
+			return protocol.NewError("ERR wrong type")
+		}
+	}
+
+	// 回复计算完成后唤醒阻塞在该键上的 BZPOPMIN/BZPOPMAX
+	defer func() {
+		if zset.Card() > 0 {
+			ctx.Server.blockingMgr.SignalKeyReady(ctx, key)
+		}
+	}()
+
+	added, updated := 0, 0
+	for j := 0; j < len(pairs); j += 2 {
+		member := []byte(pairs[j+1].ToString())
+		score := scores[j/2]
+
+		current, exists := zset.Score(member)
+		if exists && nx || !exists && xx {
+			if incr {
+				return protocol.NewNullBulkString()
+			}
+			continue
+		}
+
+		if incr {
+			score += current
+			if math.IsNaN(score) {
+				return protocol.NewError("ERR resulting score is not a number")
+			}
+		}
+
+		if exists {
+			if gt && score <= current || lt && score >= current {
+				if incr {
+					return protocol.NewNullBulkString()
+				}
+				continue
+			}
+			if score != current {
+				zset.Remove(member)
+				zset.Add(member, score)
+				updated++
+				ctx.addDirty(1)
+			}
+		} else {
+			zset.Add(member, score)
+			added++
+			ctx.addDirty(1)
+		}
+
+		if incr {
+			return protocol.NewDouble(score)
+		}
+	}
+
+	if ch {
+		return protocol.NewInteger(int64(added + updated))
+	}
+	return protocol.NewInteger(int64(added))
+}
+
+func cmdZRem(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	key := args[0].ToString()
+
+	obj, err := ctx.Db.Get(key)
+	if err != nil {
+		return protocol.NewInteger(0)
+	}
+
+	zset, err := obj.GetZSet()
+	if err != nil {
+		return protocol.NewError("ERR wrong type")
+	}
+
+	count := 0
+	for i := 1; i < len(args); i++ {
+		member := args[i].ToString()
+		if zset.Remove([]byte(member)) == nil {
+			count++
+		}
+	}
+	ctx.addDirty(count)
+
+	// 有序集合为空时删除键
+	if zset.Card() == 0 {
+		ctx.Db.Del(key)
+	}
+
+	return protocol.NewInteger(int64(count))
 }
 
 func cmdZScore(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
@@ -2160,7 +2879,7 @@ func cmdZScore(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPVa
 		return protocol.NewNullBulkString()
 	}
 
-	return protocol.NewBulkString(strconv.FormatFloat(score, 'f', -1, 64))
+	return protocol.NewDouble(score)
 }
 
 func cmdZCard(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
@@ -2179,17 +2898,213 @@ func cmdZCard(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPVal
 	return protocol.NewInteger(int64(zset.Card()))
 }
 
+// zrangeSpec ZRANGE/ZRANGESTORE 的统一参数
+type zrangeSpec struct {
+	byScore    bool
+	byLex      bool
+	rev        bool
+	withScores bool
+
+	// 按排名
+	start int
+	stop  int
+
+	// 按分数
+	scoreRange *structure.ZRangeSpec
+
+	// 按字典序
+	minLex lexBound
+	maxLex lexBound
+
+	// LIMIT offset count（count < 0 表示不限制）
+	offset int
+	count  int
+}
+
+// lexBound 字典序区间端点：[member、(member、- 或 +
+type lexBound struct {
+	value     string
+	inclusive bool
+	infinity  int // -1 表示 "-"，1 表示 "+"，0 表示普通端点
+}
+
+// parseLexBound 解析字典序端点
+func parseLexBound(s string) (lexBound, bool) {
+	switch {
+	case s == "-":
+		return lexBound{infinity: -1}, true
+	case s == "+":
+		return lexBound{infinity: 1}, true
+	case strings.HasPrefix(s, "["):
+		return lexBound{value: s[1:], inclusive: true}, true
+	case strings.HasPrefix(s, "("):
+		return lexBound{value: s[1:], inclusive: false}, true
+	}
+	return lexBound{}, false
+}
+
+// lexGte member 是否满足下界
+func (b lexBound) lexGte(member string) bool {
+	switch b.infinity {
+	case -1:
+		return true
+	case 1:
+		return false
+	}
+	if b.inclusive {
+		return member >= b.value
+	}
+	return member > b.value
+}
+
+// lexLte member 是否满足上界
+func (b lexBound) lexLte(member string) bool {
+	switch b.infinity {
+	case -1:
+		return false
+	case 1:
+		return true
+	}
+	if b.inclusive {
+		return member <= b.value
+	}
+	return member < b.value
+}
+
+// parseZRangeSpec 解析 start stop [BYSCORE|BYLEX] [REV] [LIMIT offset count] [WITHSCORES]
+// args 从 start 开始；allowWithScores 为 false 时（ZRANGESTORE）拒绝 WITHSCORES
+func parseZRangeSpec(args []*protocol.RESPValue, allowWithScores bool) (*zrangeSpec, *protocol.RESPValue) {
+	spec := &zrangeSpec{count: -1}
+	hasLimit := false
+
+	for i := 2; i < len(args); i++ {
+		arg := strings.ToUpper(args[i].ToString())
+		switch {
+		case arg == "BYSCORE":
+			spec.byScore = true
+		case arg == "BYLEX":
+			spec.byLex = true
+		case arg == "REV":
+			spec.rev = true
+		case arg == "WITHSCORES" && allowWithScores:
+			spec.withScores = true
+		case arg == "LIMIT" && i+2 < len(args):
+			offset, err1 := strconv.Atoi(args[i+1].ToString())
+			count, err2 := strconv.Atoi(args[i+2].ToString())
+			if err1 != nil || err2 != nil {
+				return nil, protocol.NewError("ERR value is not an integer or out of range")
+			}
+			spec.offset = offset
+			spec.count = count
+			hasLimit = true
+			i += 2
+		default:
+			return nil, protocol.NewError("ERR syntax error")
+		}
+	}
+
+	if spec.byScore && spec.byLex {
+		return nil, protocol.NewError("ERR syntax error")
+	}
+	if hasLimit && !spec.byScore && !spec.byLex {
+		return nil, protocol.NewError("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+	if spec.withScores && spec.byLex {
+		return nil, protocol.NewError("ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
+
+	// REV 时区间参数为 max min
+	minArg, maxArg := args[0].ToString(), args[1].ToString()
+	if spec.rev && (spec.byScore || spec.byLex) {
+		minArg, maxArg = maxArg, minArg
+	}
+
+	switch {
+	case spec.byScore:
+		spec.scoreRange = parseScoreRange(minArg, maxArg)
+	case spec.byLex:
+		var ok1, ok2 bool
+		spec.minLex, ok1 = parseLexBound(minArg)
+		spec.maxLex, ok2 = parseLexBound(maxArg)
+		if !ok1 || !ok2 {
+			return nil, protocol.NewError("ERR min or max not valid string range item")
+		}
+	default:
+		var err1, err2 error
+		spec.start, err1 = strconv.Atoi(minArg)
+		spec.stop, err2 = strconv.Atoi(maxArg)
+		if err1 != nil || err2 != nil {
+			return nil, protocol.NewError("ERR value is not an integer or out of range")
+		}
+	}
+
+	return spec, nil
+}
+
+// zrangeSelect 按 spec 从有序集合中选出元素（顺序即返回顺序）
+func zrangeSelect(zset *structure.RedisZSet, spec *zrangeSpec) []structure.ZSetEntry {
+	if !spec.byScore && !spec.byLex {
+		if !spec.rev {
+			entries, _ := zset.Range(spec.start, spec.stop, false)
+			return entries
+		}
+		// REV 按排名：将反向下标转换为正向下标（Range 的下标始终是正向的）
+		length := zset.Card()
+		start, stop := spec.start, spec.stop
+		if start < 0 {
+			start = length + start
+		}
+		if stop < 0 {
+			stop = length + stop
+		}
+		if start < 0 {
+			start = 0
+		}
+		if start > stop || start >= length {
+			return []structure.ZSetEntry{}
+		}
+		entries, _ := zset.Range(length-1-stop, length-1-start, true)
+		return entries
+	}
+
+	if spec.byScore {
+		return zset.RangeByScore(spec.scoreRange, spec.offset, spec.count, spec.rev)
+	}
+
+	all, _ := zset.Range(0, -1, spec.rev)
+	offset, count := spec.offset, spec.count
+	if offset < 0 {
+		return []structure.ZSetEntry{}
+	}
+
+	result := make([]structure.ZSetEntry, 0)
+	for _, entry := range all {
+		member := string(entry.Member())
+		if !spec.minLex.lexGte(member) || !spec.maxLex.lexLte(member) {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		if count == 0 {
+			break
+		}
+		result = append(result, entry)
+		if count > 0 {
+			count--
+		}
+	}
+
+	return result
+}
+
 func cmdZRange(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
 	key := args[0].ToString()
-	start, _ := strconv.Atoi(args[1].ToString())
-	end, _ := strconv.Atoi(args[2].ToString())
 
-	reverse := false
-	if len(args) > 3 {
-		withScores := args[3].ToString()
-		if withScores == "WITHSCORES" {
-			// 简化实现：返回带分数的结果
-		}
+	spec, errResp := parseZRangeSpec(args[1:], true)
+	if errResp != nil {
+		return errResp
 	}
 
 	obj, err := ctx.Db.Get(key)
@@ -2202,18 +3117,55 @@ func cmdZRange(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPVa
 		return protocol.NewError("ERR wrong type")
 	}
 
-	entries, err := zset.Range(start, end, reverse)
-	if err != nil {
-		return protocol.NewError("ERR " + err.Error())
-	}
+	entries := zrangeSelect(zset, spec)
 
 	results := make([]*protocol.RESPValue, 0, len(entries)*2)
 	for _, entry := range entries {
 		results = append(results, protocol.NewBulkString(string(entry.Member())))
-		results = append(results, protocol.NewBulkString(strconv.FormatFloat(entry.Score(), 'f', -1, 64)))
+		if spec.withScores {
+			results = append(results, protocol.NewDouble(entry.Score()))
+		}
 	}
 
-	return protocol.NewArray(results)
+	return scoredArrayReply(ctx, results, spec.withScores)
+}
+
+func cmdZRangeStore(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	destination := args[0].ToString()
+	source := args[1].ToString()
+
+	spec, errResp := parseZRangeSpec(args[2:], false)
+	if errResp != nil {
+		return errResp
+	}
+
+	var entries []structure.ZSetEntry
+	obj, err := ctx.Db.Get(source)
+	if err == nil {
+		zset, err := obj.GetZSet()
+		if err != nil {
+			return protocol.NewError("ERR wrong type")
+		}
+		entries = zrangeSelect(zset, spec)
+	}
+
+	// 结果为空时删除目标键
+	if len(entries) == 0 {
+		if ctx.Db.Del(destination) {
+			ctx.addDirty(1)
+		}
+		return protocol.NewInteger(0)
+	}
+
+	resultObj := storage.NewZSetObject()
+	resultZSet, _ := resultObj.GetZSet()
+	for _, entry := range entries {
+		resultZSet.Add(entry.Member(), entry.Score())
+	}
+	ctx.Db.Set(destination, resultObj)
+	ctx.addDirty(1)
+
+	return protocol.NewInteger(int64(len(entries)))
 }
 
 func cmdZRank(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
@@ -2239,11 +3191,19 @@ func cmdZRank(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPVal
 
 func cmdZRevRange(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
 	key := args[0].ToString()
-	start, _ := strconv.Atoi(args[1].ToString())
-	end, _ := strconv.Atoi(args[2].ToString())
-	withScores := false
-	if len(args) > 3 && strings.ToUpper(args[3].ToString()) == "WITHSCORES" {
-		withScores = true
+	start, err1 := strconv.Atoi(args[1].ToString())
+	stop, err2 := strconv.Atoi(args[2].ToString())
+	if err1 != nil || err2 != nil {
+		return protocol.NewError("ERR value is not an integer or out of range")
+	}
+
+	// ZREVRANGE key start stop [WITHSCORES] 等价于 ZRANGE key start stop REV [WITHSCORES]
+	spec := &zrangeSpec{start: start, stop: stop, rev: true, count: -1}
+	if len(args) > 3 {
+		if len(args) > 4 || strings.ToUpper(args[3].ToString()) != "WITHSCORES" {
+			return protocol.NewError("ERR syntax error")
+		}
+		spec.withScores = true
 	}
 
 	obj, err := ctx.Db.Get(key)
@@ -2256,46 +3216,17 @@ func cmdZRevRange(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RES
 		return protocol.NewError("ERR wrong type")
 	}
 
-	// 获取所有元素（按 score 排序）
-	entries, _ := zset.Range(0, -1, false)
-	length := len(entries)
-
-	// 处理负数索引
-	if start < 0 {
-		start = length + start
-	}
-	if end < 0 {
-		end = length + end
-	}
-
-	// 边界检查
-	if start < 0 {
-		start = 0
-	}
-	if end >= length {
-		end = length - 1
-	}
-	if start > end {
-		return protocol.NewArray([]*protocol.RESPValue{})
-	}
-
-	// 反转范围（从尾部开始）
-	revStart := length - 1 - end
-	revEnd := length - 1 - start
+	entries := zrangeSelect(zset, spec)
 
-	// 获取反转后的元素
-	results := make([]*protocol.RESPValue, 0)
-	for i := revStart; i <= revEnd; i++ {
-		if i >= 0 && i < length {
-			entry := entries[i]
-			results = append(results, protocol.NewBulkString(string(entry.Member())))
-			if withScores {
-				results = append(results, protocol.NewBulkString(strconv.FormatFloat(entry.Score(), 'f', -1, 64)))
-			}
+	results := make([]*protocol.RESPValue, 0, len(entries)*2)
+	for _, entry := range entries {
+		results = append(results, protocol.NewBulkString(string(entry.Member())))
+		if spec.withScores {
+			results = append(results, protocol.NewDouble(entry.Score()))
 		}
 	}
 
-	return protocol.NewArray(results)
+	return scoredArrayReply(ctx, results, spec.withScores)
 }
 
 func cmdZRevRank(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
@@ -2328,7 +3259,7 @@ func cmdZRevRank(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESP
 func cmdZIncrBy(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
 	key := args[0].ToString()
 	increment, err := strconv.ParseFloat(args[1].ToString(), 64)
-	if err != nil {
+	if err != nil || math.IsNaN(increment) {
 		return protocol.NewError("ERR value is not a valid float")
 	}
 	member := args[2].ToString()
@@ -2348,13 +3279,21 @@ func cmdZIncrBy(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPV
 	}
 
 	// 获取当前 score
-	currentScore, _ := zset.Score([]byte(member))
+	currentScore, exists := zset.Score([]byte(member))
 	newScore := currentScore + increment
+	if math.IsNaN(newScore) {
+		return protocol.NewError("ERR resulting score is not a number")
+	}
 
 	// 更新或添加
+	if exists {
+		zset.Remove([]byte(member))
+	}
 	zset.Add([]byte(member), newScore)
+	ctx.addDirty(1)
+	ctx.Server.blockingMgr.SignalKeyReady(ctx, key)
 
-	return protocol.NewBulkString(strconv.FormatFloat(newScore, 'f', -1, 64))
+	return protocol.NewDouble(newScore)
 }
 
 func cmdZRangeByScore(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
@@ -2388,35 +3327,19 @@ func cmdZRangeByScore(ctx *CommandContext, args []*protocol.RESPValue) *protocol
 	}
 
 	// 解析分数范围
-	minScore, minInclusive := parseScore(min)
-	maxScore, maxInclusive := parseScore(max)
-
-	// 获取所有元素
-	entries, _ := zset.Range(0, -1, false)
-	results := make([]*protocol.RESPValue, 0)
+	spec := parseScoreRange(min, max)
 
+	// 利用 skiplist 定位区间起点，只遍历区间内的元素
+	entries := zset.RangeByScore(spec, offset, count, false)
+	results := make([]*protocol.RESPValue, 0, len(entries))
 	for _, entry := range entries {
-		score := entry.Score()
-		if (minInclusive && score >= minScore || !minInclusive && score > minScore) &&
-			(maxInclusive && score <= maxScore || !maxInclusive && score < maxScore) {
-			if offset > 0 {
-				offset--
-				continue
-			}
-			if count == 0 {
-				break
-			}
-			results = append(results, protocol.NewBulkString(string(entry.Member())))
-			if withScores {
-				results = append(results, protocol.NewBulkString(strconv.FormatFloat(score, 'f', -1, 64)))
-			}
-			if count > 0 {
-				count--
-			}
+		results = append(results, protocol.NewBulkString(string(entry.Member())))
+		if withScores {
+			results = append(results, protocol.NewDouble(entry.Score()))
 		}
 	}
 
-	return protocol.NewArray(results)
+	return scoredArrayReply(ctx, results, withScores)
 }
 
 func cmdZCount(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
@@ -2435,22 +3358,31 @@ func cmdZCount(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPVa
 	}
 
 	// 解析分数范围
-	minScore, minInclusive := parseScore(min)
-	maxScore, maxInclusive := parseScore(max)
+	spec := parseScoreRange(min, max)
 
-	// 获取所有元素并计数
-	entries, _ := zset.Range(0, -1, false)
-	count := 0
+	return protocol.NewInteger(int64(zset.CountByScore(spec)))
+}
 
-	for _, entry := range entries {
-		score := entry.Score()
-		if (minInclusive && score >= minScore || !minInclusive && score > minScore) &&
-			(maxInclusive && score <= maxScore || !maxInclusive && score < maxScore) {
-			count++
-		}
+// scoredArrayReply WITHSCORES 回复：RESP2 为 member、score 交替的扁平数组；
+// RESP3 为 [member, score] 对组成的数组（与 Redis 7 一致）
+func scoredArrayReply(ctx *CommandContext, results []*protocol.RESPValue, withScores bool) *protocol.RESPValue {
+	if !withScores || ctx.Client == nil || ctx.Client.protocolVersion() < 3 {
+		return protocol.NewArray(results)
 	}
 
-	return protocol.NewInteger(int64(count))
+	pairs := make([]*protocol.RESPValue, 0, len(results)/2)
+	for i := 0; i+1 < len(results); i += 2 {
+		pairs = append(pairs, protocol.NewArray(results[i:i+2]))
+	}
+	return protocol.NewArray(pairs)
+}
+
+// parseScoreRange 解析 min/max 分数区间
+func parseScoreRange(min, max string) *structure.ZRangeSpec {
+	spec := &structure.ZRangeSpec{}
+	spec.Min, spec.MinInc = parseScore(min)
+	spec.Max, spec.MaxInc = parseScore(max)
+	return spec
 }
 
 // parseScore 解析分数字符串（支持 (min, [min, -inf, +inf）
@@ -2461,11 +3393,11 @@ func parseScore(scoreStr string) (float64, bool) {
 		scoreStr = scoreStr[1:]
 	}
 
-	if scoreStr == "-inf" {
-		return -1e308, inclusive
-	}
-	if scoreStr == "+inf" || scoreStr == "inf" {
-		return 1e308, inclusive
+	switch strings.ToLower(scoreStr) {
+	case "-inf":
+		return math.Inf(-1), inclusive
+	case "+inf", "inf":
+		return math.Inf(1), inclusive
 	}
 
 	score, err := strconv.ParseFloat(scoreStr, 64)
@@ -2506,35 +3438,19 @@ func cmdZRevRangeByScore(ctx *CommandContext, args []*protocol.RESPValue) *proto
 	}
 
 	// 解析分数范围（注意：ZREVRANGEBYSCORE 中 max 在前，min 在后）
-	maxScore, maxInclusive := parseScore(max)
-	minScore, minInclusive := parseScore(min)
-
-	// 获取所有元素（反向）
-	entries, _ := zset.Range(0, -1, true)
-	results := make([]*protocol.RESPValue, 0)
+	spec := parseScoreRange(min, max)
 
+	// 利用 skiplist 定位区间终点，反向遍历区间内的元素
+	entries := zset.RangeByScore(spec, offset, count, true)
+	results := make([]*protocol.RESPValue, 0, len(entries))
 	for _, entry := range entries {
-		score := entry.Score()
-		if (minInclusive && score >= minScore || !minInclusive && score > minScore) &&
-			(maxInclusive && score <= maxScore || !maxInclusive && score < maxScore) {
-			if offset > 0 {
-				offset--
-				continue
-			}
-			if count == 0 {
-				break
-			}
-			results = append(results, protocol.NewBulkString(string(entry.Member())))
-			if withScores {
-				results = append(results, protocol.NewBulkString(strconv.FormatFloat(score, 'f', -1, 64)))
-			}
-			if count > 0 {
-				count--
-			}
+		results = append(results, protocol.NewBulkString(string(entry.Member())))
+		if withScores {
+			results = append(results, protocol.NewDouble(entry.Score()))
 		}
 	}
 
-	return protocol.NewArray(results)
+	return scoredArrayReply(ctx, results, withScores)
 }
 
 func cmdZRemRangeByRank(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
@@ -2589,6 +3505,12 @@ func cmdZRemRangeByRank(ctx *CommandContext, args []*protocol.RESPValue) *protoc
 			removed++
 		}
 	}
+	ctx.addDirty(removed)
+
+	// 有序集合为空时删除键
+	if zset.Card() == 0 {
+		ctx.Db.Del(key)
+	}
 
 	return protocol.NewInteger(int64(removed))
 }
@@ -2609,23 +3531,423 @@ func cmdZRemRangeByScore(ctx *CommandContext, args []*protocol.RESPValue) *proto
 	}
 
 	// 解析分数范围
-	minScore, minInclusive := parseScore(min)
-	maxScore, maxInclusive := parseScore(max)
+	spec := parseScoreRange(min, max)
 
-	// 获取所有元素
-	entries, _ := zset.Range(0, -1, false)
-	removed := 0
+	// 先取出区间内的元素，再逐个删除
+	entries := zset.RangeByScore(spec, 0, -1, false)
+	for _, entry := range entries {
+		zset.Remove(entry.Member())
+	}
+	ctx.addDirty(len(entries))
+
+	// 有序集合为空时删除键
+	if zset.Card() == 0 {
+		ctx.Db.Del(key)
+	}
+
+	return protocol.NewInteger(int64(len(entries)))
+}
+
+// zsetOpEntry ZUNION/ZINTER/ZDIFF 计算结果条目
+type zsetOpEntry struct {
+	member string
+	score  float64
+}
+
+// zsetOpArgs ZUNION/ZINTER/ZDIFF 系列命令的公共参数
+type zsetOpArgs struct {
+	keys       []string
+	weights    []float64
+	aggregate  string
+	withScores bool
+}
+
+// parseZSetOpArgs 解析 numkeys key [key ...] [WEIGHTS ...] [AGGREGATE ...] [WITHSCORES]
+// args 从 numkeys 开始；allowWeights 控制是否接受 WEIGHTS/AGGREGATE（ZDIFF 不支持），
+// allowWithScores 控制是否接受 WITHSCORES（*STORE 不支持）
+func parseZSetOpArgs(args []*protocol.RESPValue, allowWeights, allowWithScores bool) (*zsetOpArgs, *protocol.RESPValue) {
+	numKeys, err := strconv.Atoi(args[0].ToString())
+	if err != nil {
+		return nil, protocol.NewError("ERR value is not an integer or out of range")
+	}
+	if numKeys <= 0 {
+		return nil, protocol.NewError("ERR at least 1 input key is needed")
+	}
+	if numKeys > len(args)-1 {
+		return nil, protocol.NewError("ERR syntax error")
+	}
+
+	opArgs := &zsetOpArgs{
+		keys:      make([]string, numKeys),
+		weights:   make([]float64, numKeys),
+		aggregate: "SUM",
+	}
+	for i := 0; i < numKeys; i++ {
+		opArgs.keys[i] = args[1+i].ToString()
+		opArgs.weights[i] = 1
+	}
+
+	for i := 1 + numKeys; i < len(args); i++ {
+		arg := strings.ToUpper(args[i].ToString())
+		switch {
+		case arg == "WEIGHTS" && allowWeights && i+numKeys < len(args):
+			for j := 0; j < numKeys; j++ {
+				weight, err := strconv.ParseFloat(args[i+1+j].ToString(), 64)
+				if err != nil {
+					return nil, protocol.NewError("ERR weight value is not a float")
+				}
+				opArgs.weights[j] = weight
+			}
+			i += numKeys
+		case arg == "AGGREGATE" && allowWeights && i+1 < len(args):
+			aggregate := strings.ToUpper(args[i+1].ToString())
+			if aggregate != "SUM" && aggregate != "MIN" && aggregate != "MAX" {
+				return nil, protocol.NewError("ERR syntax error")
+			}
+			opArgs.aggregate = aggregate
+			i++
+		case arg == "WITHSCORES" && allowWithScores:
+			opArgs.withScores = true
+		default:
+			return nil, protocol.NewError("ERR syntax error")
+		}
+	}
+
+	return opArgs, nil
+}
+
+// loadZSetOpInput 读取参与运算的集合，普通 Set 的成员分数视为 1
+// 键不存在时返回 nil（视为空集合）
+func loadZSetOpInput(ctx *CommandContext, key string) ([]zsetOpEntry, *protocol.RESPValue) {
+	obj, err := ctx.Db.Get(key)
+	if err != nil {
+		return nil, nil
+	}
+
+	if zset, err := obj.GetZSet(); err == nil {
+		entries, _ := zset.Range(0, -1, false)
+		result := make([]zsetOpEntry, 0, len(entries))
+		for _, entry := range entries {
+			result = append(result, zsetOpEntry{member: string(entry.Member()), score: entry.Score()})
+		}
+		return result, nil
+	}
+
+	if set, err := obj.GetSet(); err == nil {
+		members := set.Members()
+		result := make([]zsetOpEntry, 0, len(members))
+		for _, member := range members {
+			result = append(result, zsetOpEntry{member: string(member), score: 1})
+		}
+		return result, nil
+	}
+
+	return nil, protocol.NewError("ERR wrong type")
+}
+
+// zsetAggregate 按 AGGREGATE 规则合并分数
+func zsetAggregate(aggregate string, current, score float64) float64 {
+	switch aggregate {
+	case "MIN":
+		if score < current {
+			return score
+		}
+		return current
+	case "MAX":
+		if score > current {
+			return score
+		}
+		return current
+	default:
+		return current + score
+	}
+}
+
+// computeZSetOp 计算 union/inter/diff，结果按 score（相同则按 member）升序排列
+// ZUNION/ZINTER/ZDIFF 与对应的 *STORE 命令共用此逻辑
+func computeZSetOp(ctx *CommandContext, op string, opArgs *zsetOpArgs) ([]zsetOpEntry, *protocol.RESPValue) {
+	inputs := make([]map[string]float64, len(opArgs.keys))
+	order := make([][]zsetOpEntry, len(opArgs.keys))
+	for i, key := range opArgs.keys {
+		entries, errResp := loadZSetOpInput(ctx, key)
+		if errResp != nil {
+			return nil, errResp
+		}
+		order[i] = entries
+		inputs[i] = make(map[string]float64, len(entries))
+		for _, entry := range entries {
+			inputs[i][entry.member] = entry.score
+		}
+	}
+
+	scores := make(map[string]float64)
+	switch op {
+	case "union":
+		for i, entries := range order {
+			for _, entry := range entries {
+				weighted := weightedScore(entry.score, opArgs.weights[i])
+				if current, exists := scores[entry.member]; exists {
+					scores[entry.member] = zsetAggregate(opArgs.aggregate, current, weighted)
+				} else {
+					scores[entry.member] = weighted
+				}
+			}
+		}
+	case "inter":
+		for _, entry := range order[0] {
+			score := weightedScore(entry.score, opArgs.weights[0])
+			inAll := true
+			for i := 1; i < len(inputs); i++ {
+				other, exists := inputs[i][entry.member]
+				if !exists {
+					inAll = false
+					break
+				}
+				score = zsetAggregate(opArgs.aggregate, score, weightedScore(other, opArgs.weights[i]))
+			}
+			if inAll {
+				scores[entry.member] = score
+			}
+		}
+	case "diff":
+		for _, entry := range order[0] {
+			inOther := false
+			for i := 1; i < len(inputs); i++ {
+				if _, exists := inputs[i][entry.member]; exists {
+					inOther = true
+					break
+				}
+			}
+			if !inOther {
+				scores[entry.member] = entry.score
+			}
+		}
+	}
+
+	result := make([]zsetOpEntry, 0, len(scores))
+	for member, score := range scores {
+		result = append(result, zsetOpEntry{member: member, score: score})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].score != result[j].score {
+			return result[i].score < result[j].score
+		}
+		return result[i].member < result[j].member
+	})
+
+	return result, nil
+}
+
+// weightedScore 计算加权分数（0 * inf 视为 0，与 Redis 一致）
+func weightedScore(score, weight float64) float64 {
+	if score == 0 || weight == 0 {
+		return 0
+	}
+	return score * weight
+}
+
+// zsetOpCommand ZUNION/ZINTER/ZDIFF 的公共实现：直接返回结果
+func zsetOpCommand(ctx *CommandContext, args []*protocol.RESPValue, op string) *protocol.RESPValue {
+	opArgs, errResp := parseZSetOpArgs(args, op != "diff", true)
+	if errResp != nil {
+		return errResp
+	}
+
+	entries, errResp := computeZSetOp(ctx, op, opArgs)
+	if errResp != nil {
+		return errResp
+	}
+
+	results := make([]*protocol.RESPValue, 0, len(entries)*2)
+	for _, entry := range entries {
+		results = append(results, protocol.NewBulkString(entry.member))
+		if opArgs.withScores {
+			results = append(results, protocol.NewDouble(entry.score))
+		}
+	}
+
+	return scoredArrayReply(ctx, results, opArgs.withScores)
+}
+
+// zsetOpStoreCommand ZUNIONSTORE/ZINTERSTORE/ZDIFFSTORE 的公共实现：结果写入 destination
+func zsetOpStoreCommand(ctx *CommandContext, args []*protocol.RESPValue, op string) *protocol.RESPValue {
+	destination := args[0].ToString()
+
+	opArgs, errResp := parseZSetOpArgs(args[1:], op != "diff", false)
+	if errResp != nil {
+		return errResp
+	}
+
+	entries, errResp := computeZSetOp(ctx, op, opArgs)
+	if errResp != nil {
+		return errResp
+	}
 
+	if len(entries) == 0 {
+		if ctx.Db.Del(destination) {
+			ctx.addDirty(1)
+		}
+		return protocol.NewInteger(0)
+	}
+
+	resultObj := storage.NewZSetObject()
+	resultZSet, _ := resultObj.GetZSet()
 	for _, entry := range entries {
-		score := entry.Score()
-		if (minInclusive && score >= minScore || !minInclusive && score > minScore) &&
-			(maxInclusive && score <= maxScore || !maxInclusive && score < maxScore) {
+		resultZSet.Add([]byte(entry.member), entry.score)
+	}
+	ctx.Db.Set(destination, resultObj)
+	ctx.addDirty(1)
+
+	return protocol.NewInteger(int64(len(entries)))
+}
+
+func cmdZUnion(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	return zsetOpCommand(ctx, args, "union")
+}
+
+func cmdZInter(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	return zsetOpCommand(ctx, args, "inter")
+}
+
+func cmdZDiff(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	return zsetOpCommand(ctx, args, "diff")
+}
+
+func cmdZUnionStore(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	return zsetOpStoreCommand(ctx, args, "union")
+}
+
+func cmdZInterStore(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	return zsetOpStoreCommand(ctx, args, "inter")
+}
+
+func cmdZDiffStore(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	return zsetOpStoreCommand(ctx, args, "diff")
+}
+
+func cmdZMScore(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	key := args[0].ToString()
+
+	var zset *structure.RedisZSet
+	obj, err := ctx.Db.Get(key)
+	if err == nil {
+		zset, err = obj.GetZSet()
+		if err != nil {
+			return protocol.NewError("ERR wrong type")
+		}
+	}
+
+	results := make([]*protocol.RESPValue, 0, len(args)-1)
+	for i := 1; i < len(args); i++ {
+		if zset == nil {
+			results = append(results, protocol.NewNullBulkString())
+			continue
+		}
+		score, exists := zset.Score([]byte(args[i].ToString()))
+		if !exists {
+			results = append(results, protocol.NewNullBulkString())
+			continue
+		}
+		results = append(results, protocol.NewDouble(score))
+	}
+
+	return protocol.NewArray(results)
+}
+
+func cmdZInterCard(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	numKeys, errResp := parseNumKeys(args)
+	if errResp != nil {
+		return errResp
+	}
+
+	limit, errResp := parseCardLimit(args[1+numKeys:])
+	if errResp != nil {
+		return errResp
+	}
+
+	opArgs, errResp := parseZSetOpArgs(args[:1+numKeys], false, false)
+	if errResp != nil {
+		return errResp
+	}
+
+	entries, errResp := computeZSetOp(ctx, "inter", opArgs)
+	if errResp != nil {
+		return errResp
+	}
+
+	count := len(entries)
+	if limit > 0 && count > limit {
+		count = limit
+	}
+	return protocol.NewInteger(int64(count))
+}
+
+func cmdZMPop(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	numKeys, errResp := parseNumKeys(args)
+	if errResp != nil {
+		return errResp
+	}
+	if 1+numKeys >= len(args) {
+		return protocol.NewError("ERR syntax error")
+	}
+
+	reverse := false
+	switch strings.ToUpper(args[1+numKeys].ToString()) {
+	case "MIN":
+		reverse = false
+	case "MAX":
+		reverse = true
+	default:
+		return protocol.NewError("ERR syntax error")
+	}
+
+	count, errResp := parseMPopCount(args[2+numKeys:])
+	if errResp != nil {
+		return errResp
+	}
+
+	// 从第一个非空有序集合弹出
+	for i := 1; i <= numKeys; i++ {
+		key := args[i].ToString()
+		obj, err := ctx.Db.Get(key)
+		if err != nil {
+			continue
+		}
+		zset, err := obj.GetZSet()
+		if err != nil {
+			return protocol.NewError("ERR wrong type")
+		}
+		if zset.Card() == 0 {
+			continue
+		}
+
+		// Range 的 start/end 始终是正向下标，reverse 只决定返回顺序
+		start, end := 0, count-1
+		if reverse {
+			start, end = -count, -1
+		}
+		entries, _ := zset.Range(start, end, reverse)
+		results := make([]*protocol.RESPValue, 0, len(entries))
+		for _, entry := range entries {
 			zset.Remove(entry.Member())
-			removed++
+			results = append(results, protocol.NewArray([]*protocol.RESPValue{
+				protocol.NewBulkString(string(entry.Member())),
+				protocol.NewDouble(entry.Score()),
+			}))
 		}
+		if zset.Card() == 0 {
+			ctx.Db.Del(key)
+		}
+		ctx.addDirty(len(results))
+
+		return protocol.NewArray([]*protocol.RESPValue{
+			protocol.NewBulkString(key),
+			protocol.NewArray(results),
+		})
 	}
 
-	return protocol.NewInteger(int64(removed))
+	// 所有键都为空时返回空数组（RESP2 *-1）
+	return protocol.NewNullArray()
 }
 
 func cmdSort(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
@@ -2681,7 +4003,9 @@ func cmdSort(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValu
 	obj, err := ctx.Db.Get(key)
 	if err != nil {
 		if store != "" {
-			ctx.Db.Del(store)
+			if ctx.Db.Del(store) {
+				ctx.addDirty(1)
+			}
 			return protocol.NewInteger(0)
 		}
 		return protocol.NewArray([]*protocol.RESPValue{})
@@ -2790,6 +4114,7 @@ func cmdSort(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValu
 				}
 			}
 			ctx.Db.Set(store, storeListObj)
+			ctx.addDirty(1)
 			return protocol.NewInteger(int64(len(results)))
 		}
 		return protocol.NewArray(results)
@@ -2804,6 +4129,7 @@ func cmdSort(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValu
 			storeList.Push(val, 1)
 		}
 		ctx.Db.Set(store, storeListObj)
+		ctx.addDirty(1)
 		return protocol.NewInteger(int64(len(sortedValues)))
 	}
 
@@ -2814,6 +4140,52 @@ func cmdSort(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValu
 	return protocol.NewArray(results)
 }
 
+// cmdZScan ZSCAN key cursor [MATCH pattern] [COUNT count]
+// listpack 编码的小集合一次返回全部元素（cursor 为 0）；
+// skiplist 编码以排名作为 cursor，每次遍历 COUNT 个元素
+func cmdZScan(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	key := args[0].ToString()
+	sa, errReply := parseScanArgs(args[1:], "zscan")
+	if errReply != nil {
+		return errReply
+	}
+
+	obj, err := ctx.Db.Get(key)
+	if err != nil {
+		return scanReply(0, []*protocol.RESPValue{})
+	}
+
+	zset, err := obj.GetZSet()
+	if err != nil {
+		return protocol.NewError("ERR wrong type")
+	}
+
+	var entries []structure.ZSetEntry
+	nextCursor := int64(0)
+	if zset.Encoding() == structure.OBJ_ENCODING_LISTPACK {
+		entries, _ = zset.Range(0, -1, false)
+	} else {
+		start := int(sa.cursor)
+		entries, _ = zset.Range(start, start+sa.count-1, false)
+		if end := int64(start + sa.count); end < int64(zset.Card()) {
+			nextCursor = end
+		}
+	}
+
+	results := make([]*protocol.RESPValue, 0, len(entries)*2)
+	for _, entry := range entries {
+		member := string(entry.Member())
+		if sa.pattern != "" && !utils.GlobMatch(sa.pattern, member) {
+			continue
+		}
+		results = append(results,
+			protocol.NewBulkString(member),
+			protocol.NewDouble(entry.Score()))
+	}
+
+	return scanReply(nextCursor, results)
+}
+
 // ========== Hash 命令实现 ==========
 
 func cmdHSet(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
@@ -2844,6 +4216,7 @@ func cmdHSet(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValu
 			count++
 		}
 	}
+	ctx.addDirty((len(args) - 1) / 2)
 
 	return protocol.NewInteger(int64(count))
 }
@@ -2890,6 +4263,12 @@ func cmdHDel(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValu
 			count++
 		}
 	}
+	ctx.addDirty(count)
+
+	// 哈希表为空时删除键
+	if hash.Len() == 0 {
+		ctx.Db.Del(key)
+	}
 
 	return protocol.NewInteger(int64(count))
 }
@@ -2935,7 +4314,7 @@ func cmdHGetAll(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPV
 
 	obj, err := ctx.Db.Get(key)
 	if err != nil {
-		return protocol.NewArray([]*protocol.RESPValue{})
+		return protocol.NewMap([]*protocol.RESPValue{})
 	}
 
 	hash, err := obj.GetHash()
@@ -2950,7 +4329,7 @@ func cmdHGetAll(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPV
 		results = append(results, protocol.NewBulkString(string(entry.Value())))
 	}
 
-	return protocol.NewArray(results)
+	return protocol.NewMap(results)
 }
 
 func cmdHKeys(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
@@ -3023,6 +4402,7 @@ func cmdHIncrBy(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPV
 	if err != nil {
 		return protocol.NewError("ERR hash value is not an integer")
 	}
+	ctx.addDirty(1)
 
 	return protocol.NewInteger(newVal)
 }
@@ -3056,6 +4436,7 @@ func cmdHMSet(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPVal
 		value := args[i+1].ToString()
 		hash.Set([]byte(field), []byte(value))
 	}
+	ctx.addDirty((len(args) - 1) / 2)
 
 	return protocol.NewSimpleString("OK")
 }
@@ -3098,27 +4479,29 @@ func cmdHSetNx(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPVa
 	value := args[2].ToString()
 
 	obj, err := ctx.Db.Get(key)
-	var hash *structure.RedisHash
 	if err != nil {
-		// 创建新的 Hash
+		// 键不存在：先写入字段再保存新的 Hash，不会留下空 Hash
 		hashObj := storage.NewHashObject()
+		hash, _ := hashObj.GetHash()
+		hash.Set([]byte(field), []byte(value))
 		ctx.Db.Set(key, hashObj)
-		hash, _ = hashObj.GetHash()
-	} else {
-		hash, err = obj.GetHash()
-		if err != nil {
-			return protocol.NewError("ERR wrong type")
-		}
+		ctx.addDirty(1)
+		return protocol.NewInteger(1)
+	}
+
+	hash, err := obj.GetHash()
+	if err != nil {
+		return protocol.NewError("ERR wrong type")
 	}
 
 	// 检查字段是否存在
-	_, exists := hash.Get([]byte(field))
-	if exists {
+	if hash.Exists([]byte(field)) {
 		return protocol.NewInteger(0)
 	}
 
 	// 设置字段
 	hash.Set([]byte(field), []byte(value))
+	ctx.addDirty(1)
 	return protocol.NewInteger(1)
 }
 
@@ -3166,40 +4549,40 @@ func cmdHIncrByFloat(ctx *CommandContext, args []*protocol.RESPValue) *protocol.
 		}
 	}
 
-	// 获取当前值
-	value, exists := hash.Get([]byte(field))
-	var currentValue float64
-	if !exists {
-		currentValue = 0
-	} else {
-		parsed, err := strconv.ParseFloat(string(value), 64)
-		if err != nil {
-			return protocol.NewError("ERR hash value is not a valid float")
+	newValue, err := hash.IncrByFloat([]byte(field), increment)
+	if err != nil {
+		// 不保留因失败而新建的空哈希表
+		if hash.Len() == 0 {
+			ctx.Db.Del(key)
 		}
-		currentValue = parsed
+		return protocol.NewError("ERR " + err.Error())
 	}
+	ctx.addDirty(1)
 
-	// 计算新值
-	newValue := currentValue + increment
-	newValueStr := strconv.FormatFloat(newValue, 'f', -1, 64)
-	hash.Set([]byte(field), []byte(newValueStr))
+	// 传播为 HSET 计算结果，避免重放时浮点运算的结果不一致；
+	// HSET 会移除字段的过期时间，字段设置了 TTL 时随后传播 HPEXPIREAT 恢复
+	value := strconv.FormatFloat(newValue, 'f', -1, 64)
+	ctx.Propagate(newCommand("HSET", key, field, value))
+	if expireAt, ok := hash.FieldExpireAt([]byte(field)); ok {
+		ctx.Propagate(newCommand("HPEXPIREAT", key, strconv.FormatInt(expireAt, 10), "FIELDS", "1", field))
+	}
 
-	return protocol.NewBulkString(newValueStr)
+	return protocol.NewBulkString(value)
 }
 
+// cmdHScan HSCAN key cursor [MATCH pattern] [COUNT count] [NOVALUES]
+// listpack 编码的小哈希表一次返回全部字段（cursor 为 0）；
+// hashtable 编码与 SCAN 一样以字段桶索引的桶下标作为 cursor，每次访问 COUNT 个左右的字段
 func cmdHScan(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
 	key := args[0].ToString()
-	cursor, err := strconv.ParseInt(args[1].ToString(), 10, 64)
-	if err != nil {
-		return protocol.NewError("ERR invalid cursor")
+	sa, errReply := parseScanArgs(args[1:], "hscan")
+	if errReply != nil {
+		return errReply
 	}
 
 	obj, err := ctx.Db.Get(key)
 	if err != nil {
-		return protocol.NewArray([]*protocol.RESPValue{
-			protocol.NewBulkString("0"),
-			protocol.NewArray([]*protocol.RESPValue{}),
-		})
+		return scanReply(0, []*protocol.RESPValue{})
 	}
 
 	hash, err := obj.GetHash()
@@ -3207,36 +4590,357 @@ func cmdHScan(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPVal
 		return protocol.NewError("ERR wrong type")
 	}
 
-	// 获取所有字段和值
-	fields := hash.Keys()
-	values := hash.Values()
-
-	// 简化实现：返回所有字段值对
-	if cursor >= int64(len(fields)) {
-		return protocol.NewArray([]*protocol.RESPValue{
-			protocol.NewBulkString("0"),
-			protocol.NewArray([]*protocol.RESPValue{}),
-		})
-	}
-
-	// 返回字段值对
 	results := make([]*protocol.RESPValue, 0)
-	for i := int(cursor); i < len(fields); i++ {
-		results = append(results, protocol.NewBulkString(string(fields[i])))
-		if i < len(values) {
-			results = append(results, protocol.NewBulkString(string(values[i])))
+	nextCursor := hash.Scan(uint64(sa.cursor), sa.count, func(field, value []byte) {
+		if sa.pattern != "" && !utils.GlobMatch(sa.pattern, string(field)) {
+			return
+		}
+		results = append(results, protocol.NewBulkString(string(field)))
+		if !sa.noValues {
+			results = append(results, protocol.NewBulkString(string(value)))
 		}
-	}
-
-	return protocol.NewArray([]*protocol.RESPValue{
-		protocol.NewBulkString("0"), // 扫描完成
-		protocol.NewArray(results),
 	})
+
+	return scanReply(int64(nextCursor), results)
 }
 
-// ========== 连接命令实现 ==========
+// parseHashFields 解析 FIELDS numfields field [field ...]（args 从 FIELDS 开始）
+func parseHashFields(args []*protocol.RESPValue) ([][]byte, *protocol.RESPValue) {
+	if len(args) < 2 || strings.ToUpper(args[0].ToString()) != "FIELDS" {
+		return nil, protocol.NewError("ERR Mandatory argument FIELDS is missing or not at the right position")
+	}
 
-func cmdPing(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	numFields, err := strconv.Atoi(args[1].ToString())
+	if err != nil || numFields <= 0 {
+		return nil, protocol.NewError("ERR Number of fields must be a positive integer")
+	}
+	if numFields != len(args)-2 {
+		return nil, protocol.NewError("ERR The `numfields` parameter must match the number of arguments")
+	}
+
+	fields := make([][]byte, numFields)
+	for i := range fields {
+		fields[i] = []byte(args[i+2].ToString())
+	}
+	return fields, nil
+}
+
+// cmdHGetDel HGETDEL key FIELDS numfields field [field ...]
+// 返回字段值并删除这些字段，哈希表为空时删除键
+func cmdHGetDel(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	key := args[0].ToString()
+	fields, errReply := parseHashFields(args[1:])
+	if errReply != nil {
+		return errReply
+	}
+
+	results := make([]*protocol.RESPValue, len(fields))
+	for i := range results {
+		results[i] = protocol.NewNullBulkString()
+	}
+
+	obj, err := ctx.Db.Get(key)
+	if err != nil {
+		return protocol.NewArray(results)
+	}
+
+	hash, err := obj.GetHash()
+	if err != nil {
+		return protocol.NewError("ERR wrong type")
+	}
+
+	for i, field := range fields {
+		if value, exists := hash.Get(field); exists {
+			results[i] = protocol.NewBulkString(string(value))
+			hash.Del(field)
+			ctx.addDirty(1)
+		}
+	}
+
+	if hash.Len() == 0 {
+		ctx.Db.Del(key)
+	}
+
+	return protocol.NewArray(results)
+}
+
+// cmdHGetEx HGETEX key [EX seconds|PX milliseconds|EXAT unix-time-seconds|PXAT unix-time-milliseconds|PERSIST] FIELDS numfields field [field ...]
+// 返回字段值，同时设置或移除这些字段的过期时间（过期时间点已过去时直接删除字段）
+func cmdHGetEx(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	key := args[0].ToString()
+
+	// 解析过期选项
+	pos := 1
+	expireAt := int64(-1) // -1 表示不修改 TTL
+	persist := false
+	switch option := strings.ToUpper(args[pos].ToString()); option {
+	case "EX", "PX", "EXAT", "PXAT":
+		if pos+1 >= len(args) {
+			return protocol.NewError("ERR syntax error")
+		}
+		n, err := strconv.ParseInt(args[pos+1].ToString(), 10, 64)
+		if err != nil {
+			return protocol.NewError("ERR value is not an integer or out of range")
+		}
+		if n <= 0 {
+			return protocol.NewError("ERR invalid expire time in 'hgetex' command")
+		}
+		switch option {
+		case "EX":
+			expireAt = time.Now().UnixMilli() + n*1000
+		case "PX":
+			expireAt = time.Now().UnixMilli() + n
+		case "EXAT":
+			expireAt = n * 1000
+		case "PXAT":
+			expireAt = n
+		}
+		pos += 2
+	case "PERSIST":
+		persist = true
+		pos++
+	}
+
+	fields, errReply := parseHashFields(args[pos:])
+	if errReply != nil {
+		return errReply
+	}
+
+	results := make([]*protocol.RESPValue, len(fields))
+	for i := range results {
+		results[i] = protocol.NewNullBulkString()
+	}
+
+	obj, err := ctx.Db.Get(key)
+	if err != nil {
+		return protocol.NewArray(results)
+	}
+
+	hash, err := obj.GetHash()
+	if err != nil {
+		return protocol.NewError("ERR wrong type")
+	}
+
+	for i, field := range fields {
+		value, exists := hash.Get(field)
+		if !exists {
+			continue
+		}
+		results[i] = protocol.NewBulkString(string(value))
+		if persist {
+			if hash.PersistField(field) {
+				ctx.addDirty(1)
+			}
+		} else if expireAt >= 0 {
+			if expireAt <= time.Now().UnixMilli() {
+				hash.Del(field)
+			} else {
+				hash.SetFieldExpire(field, expireAt)
+			}
+			ctx.addDirty(1)
+		}
+	}
+
+	// 字段全部过期后由数据库删除键
+	if hash.Len() == 0 {
+		ctx.Db.Del(key)
+	} else {
+		ctx.Db.TrackFieldExpires(key)
+	}
+
+	// 相对时间传播为绝对时间（毫秒）
+	if expireAt >= 0 {
+		argv := []string{key, "PXAT", strconv.FormatInt(expireAt, 10)}
+		for _, arg := range args[pos:] {
+			argv = append(argv, arg.ToString())
+		}
+		ctx.Propagate(newCommand("HGETEX", argv...))
+	}
+
+	return protocol.NewArray(results)
+}
+
+// hashFieldExpireCommand HEXPIRE/HPEXPIRE/HEXPIREAT/HPEXPIREAT 的公共实现
+// HEXPIRE key time [NX|XX|GT|LT] FIELDS numfields field [field ...]
+// unit 为时间单位（毫秒数），absolute 表示 time 是 Unix 时间戳
+// 每个字段返回：-2 字段不存在，0 条件不满足，1 已设置，2 时间已过、字段被删除
+func hashFieldExpireCommand(ctx *CommandContext, args []*protocol.RESPValue, unit int64, absolute bool) *protocol.RESPValue {
+	key := args[0].ToString()
+	n, err := strconv.ParseInt(args[1].ToString(), 10, 64)
+	if err != nil {
+		return protocol.NewError("ERR value is not an integer or out of range")
+	}
+	if n < 0 || n > math.MaxInt64/unit {
+		return protocol.NewError("ERR invalid expire time, must be >= 0 and <= 2^63-1")
+	}
+
+	expireAt := n * unit
+	if !absolute {
+		expireAt += time.Now().UnixMilli()
+	}
+
+	// 解析条件选项
+	pos := 2
+	condition := ""
+	switch opt := strings.ToUpper(args[pos].ToString()); opt {
+	case "NX", "XX", "GT", "LT":
+		condition = opt
+		pos++
+	}
+
+	fields, errReply := parseHashFields(args[pos:])
+	if errReply != nil {
+		return errReply
+	}
+
+	results := make([]*protocol.RESPValue, len(fields))
+	for i := range results {
+		results[i] = protocol.NewInteger(-2)
+	}
+
+	obj, err := ctx.Db.Get(key)
+	if err != nil {
+		return protocol.NewArray(results)
+	}
+
+	hash, err := obj.GetHash()
+	if err != nil {
+		return protocol.NewError("ERR wrong type")
+	}
+
+	now := time.Now().UnixMilli()
+	for i, field := range fields {
+		if !hash.Exists(field) {
+			continue
+		}
+
+		// 没有 TTL 视为无限大
+		current, hasTTL := hash.FieldExpireAt(field)
+		if condition == "NX" && hasTTL ||
+			condition == "XX" && !hasTTL ||
+			condition == "GT" && (!hasTTL || expireAt <= current) ||
+			condition == "LT" && hasTTL && expireAt >= current {
+			results[i] = protocol.NewInteger(0)
+			continue
+		}
+
+		ctx.addDirty(1)
+		if expireAt <= now {
+			hash.Del(field)
+			results[i] = protocol.NewInteger(2)
+			continue
+		}
+		hash.SetFieldExpire(field, expireAt)
+		results[i] = protocol.NewInteger(1)
+	}
+
+	// 字段全部过期后由数据库删除键
+	if hash.Len() == 0 {
+		ctx.Db.Del(key)
+	} else {
+		ctx.Db.TrackFieldExpires(key)
+	}
+
+	// 统一传播为毫秒级绝对时间的 HPEXPIREAT
+	if !absolute || unit != 1 {
+		argv := []string{key, strconv.FormatInt(expireAt, 10)}
+		if condition != "" {
+			argv = append(argv, condition)
+		}
+		for _, arg := range args[pos:] {
+			argv = append(argv, arg.ToString())
+		}
+		ctx.Propagate(newCommand("HPEXPIREAT", argv...))
+	}
+
+	return protocol.NewArray(results)
+}
+
+func cmdHExpire(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	return hashFieldExpireCommand(ctx, args, 1000, false)
+}
+
+func cmdHPExpire(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	return hashFieldExpireCommand(ctx, args, 1, false)
+}
+
+func cmdHExpireAt(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	return hashFieldExpireCommand(ctx, args, 1000, true)
+}
+
+func cmdHPExpireAt(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	return hashFieldExpireCommand(ctx, args, 1, true)
+}
+
+// hashFieldTTLCommand HTTL/HPTTL/HPERSIST 的公共实现（key FIELDS numfields field [field ...]）
+// fn 根据字段的过期时间计算返回值；字段不存在返回 -2
+func hashFieldTTLCommand(ctx *CommandContext, args []*protocol.RESPValue, fn func(hash *structure.RedisHash, field []byte) int64) *protocol.RESPValue {
+	key := args[0].ToString()
+	fields, errReply := parseHashFields(args[1:])
+	if errReply != nil {
+		return errReply
+	}
+
+	results := make([]*protocol.RESPValue, len(fields))
+	for i := range results {
+		results[i] = protocol.NewInteger(-2)
+	}
+
+	obj, err := ctx.Db.Get(key)
+	if err != nil {
+		return protocol.NewArray(results)
+	}
+
+	hash, err := obj.GetHash()
+	if err != nil {
+		return protocol.NewError("ERR wrong type")
+	}
+
+	for i, field := range fields {
+		if hash.Exists(field) {
+			results[i] = protocol.NewInteger(fn(hash, field))
+		}
+	}
+
+	return protocol.NewArray(results)
+}
+
+// cmdHTTL HTTL key FIELDS numfields field [field ...]：剩余秒数，没有 TTL 返回 -1
+func cmdHTTL(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	return hashFieldTTLCommand(ctx, args, func(hash *structure.RedisHash, field []byte) int64 {
+		expireAt, ok := hash.FieldExpireAt(field)
+		if !ok {
+			return -1
+		}
+		return (expireAt - time.Now().UnixMilli() + 999) / 1000
+	})
+}
+
+// cmdHPTTL HPTTL key FIELDS numfields field [field ...]：剩余毫秒数，没有 TTL 返回 -1
+func cmdHPTTL(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	return hashFieldTTLCommand(ctx, args, func(hash *structure.RedisHash, field []byte) int64 {
+		expireAt, ok := hash.FieldExpireAt(field)
+		if !ok {
+			return -1
+		}
+		return expireAt - time.Now().UnixMilli()
+	})
+}
+
+// cmdHPersist HPERSIST key FIELDS numfields field [field ...]：移除 TTL 返回 1，没有 TTL 返回 -1
+func cmdHPersist(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	return hashFieldTTLCommand(ctx, args, func(hash *structure.RedisHash, field []byte) int64 {
+		if hash.PersistField(field) {
+			ctx.addDirty(1)
+			return 1
+		}
+		return -1
+	})
+}
+
+// ========== 连接命令实现 ==========
+
+func cmdPing(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
 	if len(args) > 0 {
 		// PING message - 返回 message
 		return protocol.NewBulkString(args[0].ToString())
@@ -3246,13 +4950,291 @@ func cmdPing(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValu
 }
 
 func cmdQuit(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
-	// 关闭客户端连接
-	ctx.Client.Close()
+	// 不能在这里直接关闭连接，否则 +OK 来不及发送；
+	// 标记后由 handleClient 在刷出回复后关闭，并丢弃未执行的事务
+	ctx.Client.quitting = true
+	ctx.Client.inMulti = false
+	ctx.Client.transaction = nil
 	return protocol.NewSimpleString("OK")
 }
 
+// cmdReset RESET：将连接恢复到初始状态，回复 +RESET
+func cmdReset(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	ctx.Client.resetState()
+	return protocol.NewSimpleString("RESET")
+}
+
+// cmdAuth AUTH [username] password：认证当前连接
+// 只有一个参数时用户名为 default
+func cmdAuth(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	if len(args) > 2 {
+		return protocol.NewError("ERR syntax error")
+	}
+
+	username, password := "default", args[0].ToString()
+	if len(args) == 2 {
+		username, password = args[0].ToString(), args[1].ToString()
+	} else if pass, _ := ctx.Server.config.Get("requirepass"); pass == "" {
+		return protocol.NewError("ERR AUTH <password> called without any password configured for the default user. " +
+			"Are you sure your configuration is correct?")
+	}
+
+	if !ctx.Server.checkPassword(username, password) {
+		return protocol.NewError("WRONGPASS invalid username-password pair or user is disabled.")
+	}
+	ctx.Client.authenticated = true
+	return protocol.NewSimpleString("OK")
+}
+
+// cmdHello HELLO [protover [AUTH username password] [SETNAME clientname]]
+// 切换连接使用的协议版本（2 或 3），可同时认证和设置客户端名称，返回服务器信息（RESP3 下为映射）
+func cmdHello(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	proto := 0
+	if len(args) > 0 {
+		ver, err := strconv.Atoi(args[0].ToString())
+		if err != nil {
+			return protocol.NewError("ERR Protocol version is not an integer or out of range")
+		}
+		if ver != 2 && ver != 3 {
+			return protocol.NewError("NOPROTO unsupported protocol version")
+		}
+		proto = ver
+	}
+
+	name, setName, authenticated := "", false, false
+	for i := 1; i < len(args); i++ {
+		option := strings.ToUpper(args[i].ToString())
+		switch {
+		case option == "AUTH" && i+2 < len(args):
+			if !ctx.Server.checkPassword(args[i+1].ToString(), args[i+2].ToString()) {
+				return protocol.NewError("WRONGPASS invalid username-password pair or user is disabled.")
+			}
+			authenticated = true
+			i += 2
+		case option == "SETNAME" && i+1 < len(args):
+			name, setName = args[i+1].ToString(), true
+			if !validClientName(name) {
+				return protocol.NewError("ERR Client names cannot contain spaces, newlines or special characters.")
+			}
+			i++
+		default:
+			return protocol.NewError("ERR Syntax error in HELLO option '" + args[i].ToString() + "'")
+		}
+	}
+
+	if ctx.Client != nil {
+		if authenticated {
+			ctx.Client.authenticated = true
+		}
+		if ctx.Server.authRequired(ctx.Client) {
+			return protocol.NewError("NOAUTH HELLO must be called with the client already authenticated, " +
+				"otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client " +
+				"and select the RESP protocol version at the same time")
+		}
+		if proto != 0 {
+			ctx.Client.resp.Store(int32(proto))
+		}
+		if setName {
+			ctx.Client.name = name
+		}
+		proto = ctx.Client.protocolVersion()
+	} else if proto == 0 {
+		proto = 2
+	}
+
+	clientID := int64(0)
+	if ctx.Client != nil {
+		clientID = ctx.Client.id
+	}
+	mode := "standalone"
+	if ctx.Server.clusterEnabled {
+		mode = "cluster"
+	}
+	return protocol.NewMap([]*protocol.RESPValue{
+		protocol.NewBulkString("server"), protocol.NewBulkString("redis"),
+		protocol.NewBulkString("version"), protocol.NewBulkString("7.0.0"),
+		protocol.NewBulkString("proto"), protocol.NewInteger(int64(proto)),
+		protocol.NewBulkString("id"), protocol.NewInteger(clientID),
+		protocol.NewBulkString("mode"), protocol.NewBulkString(mode),
+		protocol.NewBulkString("role"), protocol.NewBulkString(ctx.Server.role()),
+		protocol.NewBulkString("modules"), protocol.NewArray([]*protocol.RESPValue{}),
+	})
+}
+
+// validClientName 客户端名称只能包含可见 ASCII 字符（不能有空格和换行）
+func validClientName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] < '!' || name[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// cmdClient CLIENT 子命令
+// CLIENT ID：返回当前连接的 ID
+// CLIENT SETNAME name / CLIENT GETNAME：设置/获取当前连接的名称（空字符串表示清除名称）
+// CLIENT LIST：每个连接一行，包含 id、addr、name、age、idle、db 等信息
+// CLIENT KILL addr 或 CLIENT KILL [ID id] [ADDR addr] [SKIPME yes|no]：关闭匹配的连接
+// CLIENT NO-EVICT on|off：内存淘汰时是否跳过当前连接
+func cmdClient(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	subcommand := strings.ToUpper(args[0].ToString())
+
+	switch subcommand {
+	case "ID":
+		if len(args) != 1 {
+			return protocol.NewError("ERR wrong number of arguments for 'client|id' command")
+		}
+		return protocol.NewInteger(ctx.Client.id)
+
+	case "GETNAME":
+		if len(args) != 1 {
+			return protocol.NewError("ERR wrong number of arguments for 'client|getname' command")
+		}
+		if ctx.Client.name == "" {
+			return protocol.NewNullBulkString()
+		}
+		return protocol.NewBulkString(ctx.Client.name)
+
+	case "SETNAME":
+		if len(args) != 2 {
+			return protocol.NewError("ERR wrong number of arguments for 'client|setname' command")
+		}
+		name := args[1].ToString()
+		if !validClientName(name) {
+			return protocol.NewError("ERR Client names cannot contain spaces, newlines or special characters.")
+		}
+		ctx.Client.name = name
+		return protocol.NewSimpleString("OK")
+
+	case "LIST":
+		if len(args) != 1 {
+			return protocol.NewError("ERR syntax error")
+		}
+		now := time.Now()
+		var list strings.Builder
+		for _, client := range ctx.Server.connectedClients() {
+			list.WriteString(client.infoString(now))
+			list.WriteString("\n")
+		}
+		return protocol.NewBulkString(list.String())
+
+	case "KILL":
+		return clientKill(ctx, args[1:])
+
+	case "NO-EVICT":
+		if len(args) != 2 {
+			return protocol.NewError("ERR wrong number of arguments for 'client|no-evict' command")
+		}
+		switch strings.ToLower(args[1].ToString()) {
+		case "on":
+			ctx.Client.noEvict = true
+		case "off":
+			ctx.Client.noEvict = false
+		default:
+			return protocol.NewError("ERR syntax error")
+		}
+		return protocol.NewSimpleString("OK")
+
+	default:
+		return protocol.NewError("ERR unknown subcommand '" + args[0].ToString() + "'. Try CLIENT HELP.")
+	}
+}
+
+// clientKill CLIENT KILL 的实现
+// 旧格式 CLIENT KILL addr 只关闭一个连接，返回 OK；
+// 新格式按过滤条件关闭所有匹配的连接（默认跳过自己），返回关闭的数量。
+// 关闭自己时先标记，等回复发出后再由 handleClient 关闭
+func clientKill(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	if len(args) == 0 {
+		return protocol.NewError("ERR wrong number of arguments for 'client|kill' command")
+	}
+
+	kill := func(client *Client) {
+		if client == ctx.Client {
+			client.quitting = true
+			return
+		}
+		client.Close()
+	}
+
+	// 旧格式：CLIENT KILL addr
+	if len(args) == 1 {
+		addr := args[0].ToString()
+		for _, client := range ctx.Server.connectedClients() {
+			if client.conn.RemoteAddr().String() == addr {
+				kill(client)
+				return protocol.NewSimpleString("OK")
+			}
+		}
+		return protocol.NewError("ERR No such client")
+	}
+
+	if len(args)%2 != 0 {
+		return protocol.NewError("ERR syntax error")
+	}
+
+	id, addr, skipMe := int64(0), "", true
+	for i := 0; i < len(args); i += 2 {
+		value := args[i+1].ToString()
+		switch strings.ToUpper(args[i].ToString()) {
+		case "ID":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || n <= 0 {
+				return protocol.NewError("ERR client-id should be greater than 0")
+			}
+			id = n
+		case "ADDR":
+			addr = value
+		case "SKIPME":
+			switch strings.ToLower(value) {
+			case "yes":
+				skipMe = true
+			case "no":
+				skipMe = false
+			default:
+				return protocol.NewError("ERR syntax error")
+			}
+		default:
+			return protocol.NewError("ERR syntax error")
+		}
+	}
+
+	killed := int64(0)
+	for _, client := range ctx.Server.connectedClients() {
+		if id != 0 && client.id != id {
+			continue
+		}
+		if addr != "" && client.conn.RemoteAddr().String() != addr {
+			continue
+		}
+		if skipMe && client == ctx.Client {
+			continue
+		}
+		kill(client)
+		killed++
+	}
+	return protocol.NewInteger(killed)
+}
+
 // ========== 服务器命令实现 ==========
 
+// boolToInt INFO 中的布尔字段（1/0）
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// statusString INFO 中的操作状态字段（ok/err）
+func statusString(ok bool) string {
+	if ok {
+		return "ok"
+	}
+	return "err"
+}
+
 func cmdInfo(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
 	section := ""
 	if len(args) > 0 {
@@ -3288,6 +5270,8 @@ func cmdInfo(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValu
 		info.WriteString(fmt.Sprintf("used_memory_human:%s\n", ctx.Server.memoryStats.GetUsedMemoryHuman()))
 		info.WriteString(fmt.Sprintf("used_memory_peak:%d\n", ctx.Server.memoryStats.GetUsedMemoryPeak()))
 		info.WriteString(fmt.Sprintf("used_memory_peak_human:%s\n", ctx.Server.memoryStats.GetUsedMemoryHuman()))
+		info.WriteString(fmt.Sprintf("maxmemory:%d\n", ctx.Server.config.GetMemory("maxmemory")))
+		info.WriteString(fmt.Sprintf("maxmemory_policy:%s\n", ctx.Server.maxmemoryPolicy()))
 
 	case "stats":
 		info.WriteString("# Stats\n")
@@ -3296,8 +5280,80 @@ func cmdInfo(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValu
 		info.WriteString(fmt.Sprintf("total_commands_processed:%d\n", ctx.Server.stats.TotalCommandsProcessed))
 		info.WriteString(fmt.Sprintf("keyspace_hits:%d\n", ctx.Server.stats.KeyspaceHits))
 		info.WriteString(fmt.Sprintf("keyspace_misses:%d\n", ctx.Server.stats.KeyspaceMisses))
+		info.WriteString(fmt.Sprintf("evicted_keys:%d\n", ctx.Server.stats.EvictedKeys))
+		ctx.Server.stats.mu.RUnlock()
+		if ctx.Server.master != nil {
+			full, partialOK, partialErr := ctx.Server.master.SyncStats()
+			info.WriteString(fmt.Sprintf("sync_full:%d\n", full))
+			info.WriteString(fmt.Sprintf("sync_partial_ok:%d\n", partialOK))
+			info.WriteString(fmt.Sprintf("sync_partial_err:%d\n", partialErr))
+		}
+
+	case "commandstats":
+		info.WriteString("# Commandstats\n")
+		ctx.Server.stats.mu.RLock()
+		names := make([]string, 0, len(ctx.Server.stats.CommandStats))
+		for name := range ctx.Server.stats.CommandStats {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			stat := ctx.Server.stats.CommandStats[name]
+			usec := stat.TotalTime.Microseconds()
+			info.WriteString(fmt.Sprintf("cmdstat_%s:calls=%d,usec=%d,usec_per_call=%.2f\n",
+				strings.ToLower(name), stat.Calls, usec, float64(usec)/float64(stat.Calls)))
+		}
 		ctx.Server.stats.mu.RUnlock()
 
+	case "replication":
+		info.WriteString("# Replication\n")
+		if slave := ctx.Server.getSlave(); slave != nil {
+			host, port, _ := net.SplitHostPort(slave.MasterAddr())
+			linkStatus := "down"
+			if slave.LinkUp() {
+				linkStatus = "up"
+			}
+			info.WriteString("role:slave\n")
+			info.WriteString(fmt.Sprintf("master_host:%s\n", host))
+			info.WriteString(fmt.Sprintf("master_port:%s\n", port))
+			info.WriteString(fmt.Sprintf("master_link_status:%s\n", linkStatus))
+			info.WriteString(fmt.Sprintf("master_sync_in_progress:%d\n", boolToInt(!slave.LinkUp())))
+			info.WriteString(fmt.Sprintf("slave_repl_offset:%d\n", slave.Offset()))
+		} else {
+			info.WriteString("role:master\n")
+		}
+		if ctx.Server.master != nil {
+			replicas := ctx.Server.master.Replicas()
+			info.WriteString(fmt.Sprintf("connected_slaves:%d\n", len(replicas)))
+			for i, replica := range replicas {
+				info.WriteString(fmt.Sprintf("slave%d:ip=%s,port=%d,state=%s,offset=%d\n",
+					i, replica.IP, replica.Port, replica.State, replica.Offset))
+			}
+			info.WriteString(fmt.Sprintf("master_replid:%s\n", ctx.Server.master.ReplID()))
+			info.WriteString(fmt.Sprintf("master_repl_offset:%d\n", ctx.Server.master.ReplOffset()))
+			active, size, histlen := ctx.Server.master.BacklogInfo()
+			info.WriteString(fmt.Sprintf("repl_backlog_active:%d\n", boolToInt(active)))
+			info.WriteString(fmt.Sprintf("repl_backlog_size:%d\n", size))
+			info.WriteString(fmt.Sprintf("repl_backlog_histlen:%d\n", histlen))
+		} else {
+			info.WriteString("connected_slaves:0\n")
+			info.WriteString("master_repl_offset:0\n")
+		}
+
+	case "persistence":
+		redisServer := ctx.Server.GetRedisServer()
+		aofWriter := ctx.Server.aofWriter
+		info.WriteString("# Persistence\n")
+		info.WriteString(fmt.Sprintf("loading:%d\n", boolToInt(ctx.Server.loading.Load())))
+		info.WriteString(fmt.Sprintf("rdb_changes_since_last_save:%d\n", redisServer.Dirty()))
+		info.WriteString(fmt.Sprintf("rdb_bgsave_in_progress:%d\n", boolToInt(ctx.Server.bgsaveRunning.Load())))
+		info.WriteString(fmt.Sprintf("rdb_last_save_time:%d\n", redisServer.LastSave()))
+		info.WriteString(fmt.Sprintf("rdb_last_bgsave_status:%s\n", statusString(redisServer.LastSaveOK())))
+		info.WriteString(fmt.Sprintf("aof_enabled:%d\n", boolToInt(aofWriter != nil)))
+		info.WriteString(fmt.Sprintf("aof_rewrite_in_progress:%d\n", boolToInt(ctx.Server.aofRewriting.Load())))
+		info.WriteString(fmt.Sprintf("aof_last_bgrewrite_status:%s\n", statusString(!ctx.Server.aofRewriteErr.Load())))
+		info.WriteString(fmt.Sprintf("aof_last_write_status:%s\n", statusString(aofWriter == nil || aofWriter.LastWriteOK())))
+
 	case "keyspace":
 		info.WriteString("# Keyspace\n")
 		for i := 0; i < ctx.Server.GetRedisServer().GetDbNum(); i++ {
@@ -3351,21 +5407,58 @@ func cmdConfig(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPVa
 		return protocol.NewError("ERR wrong number of arguments for 'config' command")
 	}
 
-	subcommand := args[0].ToString()
+	subcommand := strings.ToUpper(args[0].ToString())
 
 	switch subcommand {
 	case "GET":
 		if len(args) < 2 {
 			return protocol.NewError("ERR wrong number of arguments for 'config|get' command")
 		}
-		// 简化实现：返回空数组
-		return protocol.NewArray([]*protocol.RESPValue{})
+		// 参数支持 glob 模式，多个模式匹配到同一参数时只返回一次
+		results := make([]*protocol.RESPValue, 0)
+		seen := make(map[string]bool)
+		for _, arg := range args[1:] {
+			for _, name := range ctx.Server.config.Match(arg.ToString()) {
+				if seen[name] {
+					continue
+				}
+				seen[name] = true
+				value, _ := ctx.Server.config.Get(name)
+				results = append(results, protocol.NewBulkString(name), protocol.NewBulkString(value))
+			}
+		}
+		return protocol.NewArray(results)
 
 	case "SET":
-		if len(args) < 3 {
+		if len(args) < 3 || len(args)%2 == 0 {
 			return protocol.NewError("ERR wrong number of arguments for 'config|set' command")
 		}
-		// 简化实现：返回 OK
+		for i := 1; i+1 < len(args); i += 2 {
+			name := args[i].ToString()
+			if err := ctx.Server.config.Set(name, args[i+1].ToString()); err != nil {
+				if err == ErrUnknownConfig {
+					return protocol.NewError("ERR Unknown option or number of arguments for CONFIG SET - '" + name + "'")
+				}
+				return protocol.NewError("ERR Invalid argument '" + args[i+1].ToString() + "' for CONFIG SET '" + name + "'")
+			}
+			ctx.Server.onConfigSet(strings.ToLower(name))
+		}
+		return protocol.NewSimpleString("OK")
+
+	case "REWRITE":
+		if err := ctx.Server.config.Rewrite(); err != nil {
+			if err == ErrNoConfigFile {
+				return protocol.NewError("ERR The server is running without a config file")
+			}
+			return protocol.NewError("ERR Rewriting config file: " + err.Error())
+		}
+		return protocol.NewSimpleString("OK")
+
+	case "RESETSTAT":
+		if len(args) != 1 {
+			return protocol.NewError("ERR wrong number of arguments for 'config|resetstat' command")
+		}
+		ctx.Server.stats.Reset()
 		return protocol.NewSimpleString("OK")
 
 	default:
@@ -3373,6 +5466,395 @@ func cmdConfig(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPVa
 	}
 }
 
+// cmdDebug DEBUG 子命令
+// DEBUG OBJECT key：返回对象的内部信息（地址、引用计数、编码）
+// DEBUG DUMPDB：以 JSON 返回当前数据库所有键的类型、编码和 TTL（测试/运维用）
+// DEBUG SLEEP seconds：阻塞当前连接指定的秒数（支持小数，用于测试超时）
+func cmdDebug(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	subcommand := strings.ToUpper(args[0].ToString())
+
+	switch subcommand {
+	case "OBJECT":
+		if len(args) != 2 {
+			return protocol.NewError("ERR wrong number of arguments for 'debug|object' command")
+		}
+		obj, err := ctx.Db.Peek(args[1].ToString())
+		if err != nil {
+			return protocol.NewError("ERR no such key")
+		}
+		return protocol.NewSimpleString(fmt.Sprintf("Value at:%p refcount:%d encoding:%s",
+			obj, obj.RefCount, obj.EncodingString()))
+
+	case "DUMPDB":
+		if len(args) != 1 {
+			return protocol.NewError("ERR wrong number of arguments for 'debug|dumpdb' command")
+		}
+		data, err := json.Marshal(dumpDb(ctx.Db))
+		if err != nil {
+			return protocol.NewError("ERR " + err.Error())
+		}
+		return protocol.NewBulkString(string(data))
+
+	case "SLEEP":
+		if len(args) != 2 {
+			return protocol.NewError("ERR wrong number of arguments for 'debug|sleep' command")
+		}
+		seconds, err := strconv.ParseFloat(args[1].ToString(), 64)
+		if err != nil || seconds < 0 || math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+			return protocol.NewError("ERR value is not a valid float")
+		}
+		time.Sleep(time.Duration(seconds * float64(time.Second)))
+		return protocol.NewSimpleString("OK")
+
+	case "QUICKLIST-PACKED-THRESHOLD":
+		// 超过阈值的列表元素单独存放在 quicklist 的 PLAIN 节点中，0 表示恢复默认值
+		if len(args) != 2 {
+			return protocol.NewError("ERR wrong number of arguments for 'debug|quicklist-packed-threshold' command")
+		}
+		size, err := parseMemoryValue(args[1].ToString())
+		if err != nil || !structure.SetQuicklistPackedThreshold(size) {
+			return protocol.NewError("ERR argument must be a memory value bigger than 1 and smaller than 4gb")
+		}
+		return protocol.NewSimpleString("OK")
+
+	default:
+		return protocol.NewError("ERR unknown subcommand '" + args[0].ToString() + "'. Try DEBUG HELP.")
+	}
+}
+
+// debugKeyDump DEBUG DUMPDB 中的一个键
+type debugKeyDump struct {
+	Key      string `json:"key"`
+	Type     string `json:"type"`
+	Encoding string `json:"encoding"`
+	TTL      int64  `json:"ttl"` // 剩余秒数，-1 表示没有过期时间
+}
+
+// dumpDb 按键名排序导出数据库中所有键的概要（不更新访问时间和命中统计）
+func dumpDb(db *storage.RedisDb) []debugKeyDump {
+	keys := db.Keys("*")
+	sort.Strings(keys)
+
+	now := time.Now().Unix()
+	result := make([]debugKeyDump, 0, len(keys))
+	for _, key := range keys {
+		obj, err := db.Peek(key)
+		if err != nil {
+			continue
+		}
+		ttl := int64(-1)
+		if expireAt, ok := db.GetExpireAt(key); ok {
+			ttl = max(expireAt-now, 0)
+		}
+		result = append(result, debugKeyDump{
+			Key:      key,
+			Type:     obj.TypeString(),
+			Encoding: obj.EncodingString(),
+			TTL:      ttl,
+		})
+	}
+	return result
+}
+
+// memoryUsageDefaultSamples MEMORY USAGE 未指定 SAMPLES 时的默认抽样数
+const memoryUsageDefaultSamples = 5
+
+// cmdMemory MEMORY 子命令
+// MEMORY USAGE key [SAMPLES count]：返回键及其值占用的内存字节数，
+// 集合类型只抽样 count 个元素估算（SAMPLES 0 表示遍历全部元素）
+// MEMORY STATS：内存使用概况（已分配、峰值、客户端数、各数据库的键数量）
+// MEMORY DOCTOR：根据内存统计给出诊断建议
+func cmdMemory(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	subcommand := strings.ToUpper(args[0].ToString())
+
+	switch subcommand {
+	case "USAGE":
+		if len(args) != 2 && len(args) != 4 {
+			return protocol.NewError("ERR syntax error")
+		}
+
+		samples := memoryUsageDefaultSamples
+		if len(args) == 4 {
+			if strings.ToUpper(args[2].ToString()) != "SAMPLES" {
+				return protocol.NewError("ERR syntax error")
+			}
+			n, err := strconv.Atoi(args[3].ToString())
+			if err != nil || n < 0 {
+				return protocol.NewError("ERR value is not an integer or out of range")
+			}
+			samples = n
+		}
+
+		key := args[1].ToString()
+		obj, err := ctx.Db.Peek(key)
+		if err != nil {
+			return protocol.NewNullBulkString()
+		}
+
+		// 键名（SDS）和所在 dictEntry 的开销一并计入
+		usage := obj.SizeInBytes(samples) + structure.SDSSizeOf(len(key)) + 24
+		return protocol.NewInteger(usage)
+
+	case "STATS":
+		if len(args) != 1 {
+			return protocol.NewError("ERR wrong number of arguments for 'memory|stats' command")
+		}
+		return memoryStatsReply(ctx)
+
+	case "DOCTOR":
+		if len(args) != 1 {
+			return protocol.NewError("ERR wrong number of arguments for 'memory|doctor' command")
+		}
+		return protocol.NewVerbatimString("txt", memoryDoctorReport(ctx.Server))
+
+	default:
+		return protocol.NewError("ERR unknown subcommand '" + args[0].ToString() + "'. Try MEMORY HELP.")
+	}
+}
+
+// memoryStatsReply MEMORY STATS 的回复（映射）
+func memoryStatsReply(ctx *CommandContext) *protocol.RESPValue {
+	stats := ctx.Server.memoryStats
+	stats.Update()
+
+	ctx.Server.mu.RLock()
+	clients := len(ctx.Server.clients)
+	ctx.Server.mu.RUnlock()
+
+	used := stats.GetUsedMemory()
+	pairs := []*protocol.RESPValue{
+		protocol.NewBulkString("peak.allocated"), protocol.NewInteger(stats.GetUsedMemoryPeak()),
+		protocol.NewBulkString("total.allocated"), protocol.NewInteger(used),
+		protocol.NewBulkString("clients.normal"), protocol.NewInteger(int64(clients)),
+	}
+
+	redisServer := ctx.Server.GetRedisServer()
+	keys := int64(0)
+	for i := 0; i < redisServer.GetDbNum(); i++ {
+		db, _ := redisServer.GetDb(i)
+		size := db.DBSize()
+		if size == 0 {
+			continue
+		}
+		keys += int64(size)
+		pairs = append(pairs,
+			protocol.NewBulkString("db."+strconv.Itoa(i)),
+			protocol.NewMap([]*protocol.RESPValue{
+				protocol.NewBulkString("keys"), protocol.NewInteger(int64(size)),
+				protocol.NewBulkString("expires"), protocol.NewInteger(int64(db.ExpiresCount())),
+			}))
+	}
+
+	bytesPerKey := int64(0)
+	if keys > 0 {
+		bytesPerKey = used / keys
+	}
+	pairs = append(pairs,
+		protocol.NewBulkString("keys.count"), protocol.NewInteger(keys),
+		protocol.NewBulkString("keys.bytes-per-key"), protocol.NewInteger(bytesPerKey),
+	)
+	return protocol.NewMap(pairs)
+}
+
+// memoryDoctorMinUsed 已用内存低于该值时不做诊断（数据太少，结论没有意义）
+const memoryDoctorMinUsed = 5 * 1024 * 1024
+
+// memoryDoctorReport MEMORY DOCTOR 的诊断报告
+func memoryDoctorReport(s *Server) string {
+	s.memoryStats.Update()
+	used := s.memoryStats.GetUsedMemory()
+	peak := s.memoryStats.GetUsedMemoryPeak()
+
+	if used < memoryDoctorMinUsed {
+		return "Hi Sam, this instance is empty or is using very little memory, " +
+			"my issues detector can't be used in these conditions. " +
+			"Please, leave for your mission on Earth and fill it with some data. " +
+			"The new Sam and I will be back to our programming as soon as I finished rebooting."
+	}
+
+	// 峰值明显高于当前使用量：曾经出现过内存尖峰
+	if peak > used*3/2 {
+		return fmt.Sprintf("Sam, I detected a few issues in this LingCache instance memory implants:\n\n"+
+			" * Peak memory: In the past this instance used more than 150%% the memory that is currently using "+
+			"(peak %s, used %s). The allocator is normally not able to release memory after a peak, "+
+			"so you can expect to see a big fragmentation ratio.\n\n"+
+			"I'm here to keep you safe, Sam. I want to help you.\n",
+			formatBytes(peak), formatBytes(used))
+	}
+
+	return "Hi Sam, I can't find any memory issue in your instance. " +
+		"I can only account for what occurs on this base."
+}
+
+// cmdMonitor MONITOR：进入监视模式，此后实时接收所有客户端执行的命令
+// 订阅状态下的客户端只能执行订阅相关命令，不能进入监视模式
+func cmdMonitor(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	if ctx.Server.pubsub.IsSubscribed(ctx.Client) {
+		return protocol.NewError("ERR Can't execute 'monitor': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context")
+	}
+	if ctx.Server.monitors.IsMonitor(ctx.Client) {
+		return protocol.NewSimpleString("OK")
+	}
+
+	// 先回复 OK 再加入监视列表，保证 OK 出现在第一条监视输出之前
+	ctx.Client.writeResponse(protocol.NewSimpleString("OK"))
+	ctx.Server.monitors.Add(ctx.Client)
+	return nil
+}
+
+// cmdCommand COMMAND 子命令（客户端库用于自省）
+// COMMAND：返回所有命令的描述
+// COMMAND COUNT：返回命令总数
+// COMMAND INFO name [name ...]：返回指定命令的描述，未知命令为 nil
+// COMMAND DOCS [name ...]：返回命令文档（映射：命令名 -> 文档），未知命令忽略
+func cmdCommand(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	table := ctx.Server.cmdTable
+	if len(args) == 0 {
+		commands := table.Commands()
+		results := make([]*protocol.RESPValue, 0, len(commands))
+		for _, cmd := range commands {
+			results = append(results, commandInfoReply(ctx, cmd))
+		}
+		return protocol.NewArray(results)
+	}
+
+	subcommand := strings.ToUpper(args[0].ToString())
+
+	switch subcommand {
+	case "COUNT":
+		if len(args) != 1 {
+			return protocol.NewError("ERR wrong number of arguments for 'command|count' command")
+		}
+		return protocol.NewInteger(int64(len(table.commands)))
+
+	case "INFO":
+		if len(args) == 1 {
+			return cmdCommand(ctx, nil)
+		}
+		results := make([]*protocol.RESPValue, 0, len(args)-1)
+		for _, arg := range args[1:] {
+			cmd, err := table.Lookup(toUpper(arg.ToString()))
+			if err != nil {
+				results = append(results, protocol.NewNull())
+				continue
+			}
+			results = append(results, commandInfoReply(ctx, cmd))
+		}
+		return protocol.NewArray(results)
+
+	case "DOCS":
+		commands := table.Commands()
+		if len(args) > 1 {
+			commands = commands[:0:0]
+			for _, arg := range args[1:] {
+				if cmd, err := table.Lookup(toUpper(arg.ToString())); err == nil {
+					commands = append(commands, cmd)
+				}
+			}
+		}
+		pairs := make([]*protocol.RESPValue, 0, len(commands)*2)
+		for _, cmd := range commands {
+			pairs = append(pairs,
+				protocol.NewBulkString(strings.ToLower(cmd.Name)),
+				protocol.NewMap([]*protocol.RESPValue{
+					protocol.NewBulkString("group"), protocol.NewBulkString(cmd.Category),
+					protocol.NewBulkString("arity"), protocol.NewInteger(int64(cmd.Arity)),
+				}))
+		}
+		return protocol.NewMap(pairs)
+
+	default:
+		return protocol.NewError("ERR unknown subcommand '" + args[0].ToString() + "'. Try COMMAND HELP.")
+	}
+}
+
+// commandInfoReply COMMAND INFO 中单个命令的描述：
+// [名称, arity, 标志, 第一个键, 最后一个键, 步长, ACL 类别]
+func commandInfoReply(ctx *CommandContext, cmd *Command) *protocol.RESPValue {
+	names := cmd.flagNames()
+	flags := make([]*protocol.RESPValue, len(names))
+	for i, name := range names {
+		flags[i] = protocol.NewSimpleString(name)
+	}
+
+	spec := cmd.keySpec()
+	return protocol.NewArray([]*protocol.RESPValue{
+		protocol.NewBulkString(strings.ToLower(cmd.Name)),
+		protocol.NewInteger(int64(cmd.Arity)),
+		protocol.NewSet(flags),
+		protocol.NewInteger(int64(spec.first)),
+		protocol.NewInteger(int64(spec.last)),
+		protocol.NewInteger(int64(spec.step)),
+		protocol.NewSet([]*protocol.RESPValue{protocol.NewSimpleString("@" + cmd.Category)}),
+	})
+}
+
+// cmdTime TIME：返回服务器当前时间 [Unix 秒, 微秒]
+func cmdTime(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	now := time.Now()
+	return protocol.NewArray([]*protocol.RESPValue{
+		protocol.NewBulkString(strconv.FormatInt(now.Unix(), 10)),
+		protocol.NewBulkString(strconv.Itoa(now.Nanosecond() / 1000)),
+	})
+}
+
+// cmdSlowlog SLOWLOG 子命令
+// SLOWLOG GET [count]：按从新到旧返回最多 count 条慢查询（默认 10，-1 表示全部）
+// SLOWLOG LEN：返回慢查询条数
+// SLOWLOG RESET：清空慢查询日志
+func cmdSlowlog(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	subcommand := strings.ToUpper(args[0].ToString())
+
+	switch subcommand {
+	case "GET":
+		if len(args) > 2 {
+			return protocol.NewError("ERR wrong number of arguments for 'slowlog|get' command")
+		}
+		count := 10
+		if len(args) == 2 {
+			n, err := strconv.Atoi(args[1].ToString())
+			if err != nil || n < -1 {
+				return protocol.NewError("ERR count should be greater than or equal to -1")
+			}
+			count = n
+		}
+
+		entries := ctx.Server.slowlog.Get(count)
+		results := make([]*protocol.RESPValue, 0, len(entries))
+		for _, entry := range entries {
+			entryArgs := make([]*protocol.RESPValue, 0, len(entry.Args))
+			for _, arg := range entry.Args {
+				entryArgs = append(entryArgs, protocol.NewBulkString(arg))
+			}
+			results = append(results, protocol.NewArray([]*protocol.RESPValue{
+				protocol.NewInteger(entry.ID),
+				protocol.NewInteger(entry.Timestamp.Unix()),
+				protocol.NewInteger(entry.Duration.Microseconds()),
+				protocol.NewArray(entryArgs),
+				protocol.NewBulkString(entry.ClientAddr),
+				protocol.NewBulkString(entry.ClientName),
+			}))
+		}
+		return protocol.NewArray(results)
+
+	case "LEN":
+		if len(args) != 1 {
+			return protocol.NewError("ERR wrong number of arguments for 'slowlog|len' command")
+		}
+		return protocol.NewInteger(int64(ctx.Server.slowlog.Len()))
+
+	case "RESET":
+		if len(args) != 1 {
+			return protocol.NewError("ERR wrong number of arguments for 'slowlog|reset' command")
+		}
+		ctx.Server.slowlog.Reset()
+		return protocol.NewSimpleString("OK")
+
+	default:
+		return protocol.NewError("ERR unknown subcommand '" + args[0].ToString() + "'. Try SLOWLOG HELP.")
+	}
+}
+
 // ========== 事务命令实现 ==========
 
 func cmdMulti(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
@@ -3393,34 +5875,29 @@ func cmdExec(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValu
 
 	ctx.Client.inMulti = false
 
+	// 入队时出现过错误：丢弃整个事务
+	if ctx.Client.transaction != nil && ctx.Client.transaction.dirty {
+		ctx.Client.transaction = nil
+		ctx.Client.unwatchAll()
+		return protocol.NewError("EXECABORT Transaction discarded because of previous errors.")
+	}
+
+	// 监视的键被修改过：放弃执行
+	modified := ctx.Client.watchedKeysModified()
+	ctx.Client.unwatchAll()
+	if modified {
+		ctx.Client.transaction = nil
+		return protocol.NewNullArray()
+	}
+
 	if ctx.Client.transaction == nil || len(ctx.Client.transaction.commands) == 0 {
 		ctx.Client.transaction = nil
 		return protocol.NewArray([]*protocol.RESPValue{})
 	}
 
-	// 保存命令列表用于 AOF 写入
-	commands := make([]*QueuedCommand, len(ctx.Client.transaction.commands))
-	copy(commands, ctx.Client.transaction.commands)
-
-	// 执行事务
+	// 执行事务（修改了数据的写命令逐条写入 AOF 并传播给从节点）
 	results := ctx.Client.transaction.Execute(ctx)
 
-	// 如果 AOF 已启用，写入事务中的所有写命令
-	if ctx.Server.aofWriter != nil {
-		for _, queuedCmd := range commands {
-			if len(queuedCmd.cmd.GetArray()) > 0 {
-				cmdName := queuedCmd.cmd.GetArray()[0].ToString()
-				cmdName = toUpper(cmdName)
-				if ctx.Server.isWriteCommand(cmdName) {
-					// 检查命令执行结果是否成功（简化：总是写入）
-					if err := ctx.Server.aofWriter.Append(queuedCmd.cmd); err != nil {
-						fmt.Printf("AOF write error in transaction: %v\n", err)
-					}
-				}
-			}
-		}
-	}
-
 	ctx.Client.transaction = nil
 
 	return protocol.NewArray(results)
@@ -3436,6 +5913,7 @@ func cmdDiscard(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPV
 		ctx.Client.transaction.Discard()
 		ctx.Client.transaction = nil
 	}
+	ctx.Client.unwatchAll()
 
 	return protocol.NewSimpleString("OK")
 }
@@ -3445,16 +5923,17 @@ func cmdWatch(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPVal
 		return protocol.NewError("ERR WATCH inside MULTI is not allowed")
 	}
 
-	if ctx.Client.transaction == nil {
-		ctx.Client.transaction = NewTransaction()
+	for _, arg := range args {
+		ctx.Client.watch(ctx.Client.db, arg.ToString())
 	}
 
-	for i := 0; i < len(args); i++ {
-		key := args[i].ToString()
-		ctx.Client.transaction.Watch(key)
-	}
+	return protocol.NewSimpleString("OK")
+}
 
-	return protocol.NewInteger(int64(len(args)))
+// cmdUnwatch UNWATCH：取消监视所有键
+func cmdUnwatch(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	ctx.Client.unwatchAll()
+	return protocol.NewSimpleString("OK")
 }
 
 // ========== 发布订阅命令实现 ==========
@@ -3526,12 +6005,93 @@ func cmdPUnsubscribe(ctx *CommandContext, args []*protocol.RESPValue) *protocol.
 	return protocol.NewArray([]*protocol.RESPValue{})
 }
 
+// shardChannelSlotError 集群模式下检查分片频道：所有频道必须属于同一个槽，且该槽由当前节点负责
+func shardChannelSlotError(ctx *CommandContext, channels []*protocol.RESPValue) *protocol.RESPValue {
+	c := ctx.Server.cluster
+	if !ctx.Server.clusterEnabled || c == nil || len(channels) == 0 {
+		return nil
+	}
+
+	slot := cluster.HashSlot(channels[0].ToString())
+	for _, channel := range channels[1:] {
+		if cluster.HashSlot(channel.ToString()) != slot {
+			return protocol.NewError("CROSSSLOT Keys in request don't hash to the same slot")
+		}
+	}
+
+	node := c.GetSlotNode(slot)
+	if node == nil {
+		return protocol.NewError("CLUSTERDOWN Hash slot not served")
+	}
+	if node.NodeID != c.GetMyself().NodeID {
+		return protocol.NewError(fmt.Sprintf("MOVED %d %s", slot, node.Addr))
+	}
+	return nil
+}
+
+// cmdSPublish SPUBLISH shardchannel message：向分片频道发布消息，返回收到消息的客户端数
+func cmdSPublish(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	if errResp := shardChannelSlotError(ctx, args[:1]); errResp != nil {
+		return errResp
+	}
+	count := ctx.Server.pubsub.SPublish(args[0].ToString(), args[1].ToString())
+	return protocol.NewInteger(int64(count))
+}
+
+// cmdSSubscribe SSUBSCRIBE shardchannel [shardchannel ...]：订阅分片频道
+func cmdSSubscribe(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	if errResp := shardChannelSlotError(ctx, args); errResp != nil {
+		return errResp
+	}
+	for _, arg := range args {
+		channel := arg.ToString()
+		count := ctx.Server.pubsub.SSubscribe(ctx.Client, channel)
+		ctx.Client.writeResponse(protocol.NewArray([]*protocol.RESPValue{
+			protocol.NewBulkString("ssubscribe"),
+			protocol.NewBulkString(channel),
+			protocol.NewInteger(int64(count)),
+		}))
+	}
+	return nil
+}
+
+// cmdSUnsubscribe SUNSUBSCRIBE [shardchannel ...]：取消订阅分片频道（不带参数时取消全部）
+func cmdSUnsubscribe(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	channels := make([]string, 0, len(args))
+	for _, arg := range args {
+		channels = append(channels, arg.ToString())
+	}
+	if len(args) == 0 {
+		channels = ctx.Server.pubsub.ClientShardChannels(ctx.Client)
+		sort.Strings(channels)
+	}
+
+	// 没有任何订阅时也回复一次，频道为 nil
+	if len(channels) == 0 {
+		return protocol.NewArray([]*protocol.RESPValue{
+			protocol.NewBulkString("sunsubscribe"),
+			protocol.NewNullBulkString(),
+			protocol.NewInteger(0),
+		})
+	}
+
+	for _, channel := range channels {
+		count := ctx.Server.pubsub.SUnsubscribe(ctx.Client, channel)
+		ctx.Client.writeResponse(protocol.NewArray([]*protocol.RESPValue{
+			protocol.NewBulkString("sunsubscribe"),
+			protocol.NewBulkString(channel),
+			protocol.NewInteger(int64(count)),
+		}))
+	}
+	return nil
+}
+
 func cmdPubsub(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
 	if len(args) == 0 {
 		return protocol.NewError("ERR wrong number of arguments for 'pubsub' command")
 	}
 
-	subcommand := args[0].ToString()
+	subcommand := strings.ToUpper(args[0].ToString())
 
 	switch subcommand {
 	case "NUMSUB":
@@ -3560,92 +6120,126 @@ func cmdPubsub(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPVa
 		}
 		return protocol.NewArray(result)
 
+	case "SHARDCHANNELS":
+		if len(args) > 2 {
+			return protocol.NewError("ERR wrong number of arguments for 'pubsub|shardchannels' command")
+		}
+		pattern := ""
+		if len(args) == 2 {
+			pattern = args[1].ToString()
+		}
+		channels := ctx.Server.pubsub.ShardChannels(pattern)
+		sort.Strings(channels)
+		result := make([]*protocol.RESPValue, len(channels))
+		for i, ch := range channels {
+			result[i] = protocol.NewBulkString(ch)
+		}
+		return protocol.NewArray(result)
+
+	case "SHARDNUMSUB":
+		result := make([]*protocol.RESPValue, 0, (len(args)-1)*2)
+		for _, arg := range args[1:] {
+			channel := arg.ToString()
+			result = append(result,
+				protocol.NewBulkString(channel),
+				protocol.NewInteger(int64(ctx.Server.pubsub.ShardNumSub(channel))))
+		}
+		return protocol.NewArray(result)
+
 	default:
 		return protocol.NewError("ERR unknown subcommand or wrong number of arguments for 'pubsub'")
 	}
 }
 
-// ========== 阻塞命令实现 ==========
-
-func cmdBLPop(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
-	if len(args) < 2 {
-		return protocol.NewError("ERR wrong number of arguments for 'blpop' command")
-	}
-
-	timeout, err := strconv.ParseInt(args[len(args)-1].ToString(), 10, 64)
-	if err != nil {
-		return protocol.NewError("ERR invalid timeout")
-	}
-
-	if timeout < 0 {
-		return protocol.NewError("ERR timeout is negative")
-	}
-
-	keys := make([]string, len(args)-1)
-	for i := 0; i < len(args)-1; i++ {
-		keys[i] = args[i].ToString()
-	}
-
-	// 先尝试非阻塞弹出
-	for _, key := range keys {
-		obj, err := ctx.Db.Get(key)
-		if err != nil {
-			continue
-		}
-
-		list, err := obj.GetList()
-		if err != nil {
-			continue
-		}
+// ========== 阻塞命令实现 ==========
 
-		if list.Len() > 0 {
-			val, _ := list.Pop(0) // HEAD
+// parseBlockTimeout 解析阻塞命令的超时时间（秒，可以是小数；0 表示一直阻塞）
+func parseBlockTimeout(arg *protocol.RESPValue) (time.Duration, *protocol.RESPValue) {
+	seconds, err := strconv.ParseFloat(arg.ToString(), 64)
+	if err != nil || math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+		return 0, protocol.NewError("ERR timeout is not a float or out of range")
+	}
+	if seconds < 0 {
+		return 0, protocol.NewError("ERR timeout is negative")
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
 
-			// 记录到 AOF
-			if ctx.Server.aofWriter != nil {
-				cmd := protocol.NewArray([]*protocol.RESPValue{
-					protocol.NewBulkString("LPOP"),
-					protocol.NewBulkString(key),
-				})
-				ctx.Server.aofWriter.Append(cmd)
+// blockForKeys 阻塞等待 keys 中任意一个键上有数据，由 serve 代为执行并返回其结果；
+// 超时返回 nil（由调用方决定回复的空值类型），timeout 为 0 表示一直等待
+func blockForKeys(ctx *CommandContext, keys []string, timeout time.Duration, serve func(key string) *blockingServe) *protocol.RESPValue {
+	// 没有客户端（AOF 重放、主节点的复制流）或在事务中执行时不阻塞（与 Redis 一致）：
+	// 有数据就立即处理，否则按超时返回
+	if ctx.Client == nil || ctx.inExec {
+		for _, key := range keys {
+			if served := serve(key); served != nil {
+				return applyBlockingServe(ctx, served)
 			}
-
-			return protocol.NewArray([]*protocol.RESPValue{
-				protocol.NewBulkString(key),
-				protocol.NewBulkString(string(val)),
-			})
 		}
+		return nil
 	}
 
-	// 如果超时为0，立即返回
-	if timeout == 0 {
-		return protocol.NewNullBulkString()
+	bm := ctx.Server.blockingMgr
+	bc, served := bm.Block(ctx.Client, ctx.Db, keys, timeout, serve)
+	if served != nil {
+		return applyBlockingServe(ctx, served)
+	}
+
+	// 等待期间释放写屏障，否则全量同步会一直等待阻塞中的客户端
+	if ctx.barrier {
+		ctx.releaseWriteBarrier()
+		defer ctx.acquireWriteBarrier()
 	}
 
-	// 阻塞等待
-	bc := ctx.Server.blockingMgr.Wait(ctx.Client, keys, timeout)
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
 
-	// 等待通知或超时
+	// 推入方代为执行时已经记录了传播的效果
 	select {
 	case result := <-bc.notify:
 		return result
-	case <-time.After(time.Duration(timeout) * time.Second):
-		return protocol.NewNullBulkString()
+	case <-expired:
+		if bm.Cancel(bc) {
+			return nil
+		}
+		// 超时的同时结果已经交付
+		return <-bc.notify
 	}
 }
 
-func cmdBRPop(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
-	if len(args) < 2 {
-		return protocol.NewError("ERR wrong number of arguments for 'brpop' command")
+// applyBlockingServe 阻塞命令不需要等待就执行成功：用确定的效果代替阻塞命令传播，
+// 并为等待 served.ready 的客户端代为执行
+func applyBlockingServe(ctx *CommandContext, served *blockingServe) *protocol.RESPValue {
+	if served.effect != nil {
+		ctx.addDirty(1)
+		ctx.Propagate(served.effect)
 	}
-
-	timeout, err := strconv.ParseInt(args[len(args)-1].ToString(), 10, 64)
-	if err != nil {
-		return protocol.NewError("ERR invalid timeout")
+	if served.ready != "" {
+		ctx.Server.blockingMgr.SignalKeyReady(ctx, served.ready)
 	}
+	return served.reply
+}
 
-	if timeout < 0 {
-		return protocol.NewError("ERR timeout is negative")
+// cmdBLPop BLPOP key [key ...] timeout
+func cmdBLPop(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	return blockingListPop(ctx, args, 0) // HEAD
+}
+
+// cmdBRPop BRPOP key [key ...] timeout
+func cmdBRPop(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	return blockingListPop(ctx, args, 1) // TAIL
+}
+
+// blockingListPop BLPOP/BRPOP 的公共实现：所有列表都为空时阻塞，
+// 有元素推入时由推入方代替等待时间最长的客户端弹出，超时返回 nil
+func blockingListPop(ctx *CommandContext, args []*protocol.RESPValue, where int) *protocol.RESPValue {
+	timeout, errResp := parseBlockTimeout(args[len(args)-1])
+	if errResp != nil {
+		return errResp
 	}
 
 	keys := make([]string, len(args)-1)
@@ -3653,84 +6247,139 @@ func cmdBRPop(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPVal
 		keys[i] = args[i].ToString()
 	}
 
-	// 先尝试非阻塞弹出
+	// 任意一个键不是列表时立即报错，而不是一直阻塞
 	for _, key := range keys {
-		obj, err := ctx.Db.Get(key)
-		if err != nil {
-			continue
+		if obj, err := ctx.Db.Get(key); err == nil {
+			if _, err := obj.GetList(); err != nil {
+				return protocol.NewError("ERR wrong type")
+			}
 		}
+	}
 
-		list, err := obj.GetList()
-		if err != nil {
-			continue
+	db := ctx.Db
+	pop := "LPOP"
+	if where == 1 {
+		pop = "RPOP"
+	}
+	result := blockForKeys(ctx, keys, timeout, func(key string) *blockingServe {
+		result := listPopOne(db, key, where)
+		if result == nil {
+			return nil
 		}
-
-		if list.Len() > 0 {
-			val, _ := list.Pop(1) // TAIL
-
-			// 记录到 AOF
-			if ctx.Server.aofWriter != nil {
-				cmd := protocol.NewArray([]*protocol.RESPValue{
-					protocol.NewBulkString("RPOP"),
-					protocol.NewBulkString(key),
-				})
-				ctx.Server.aofWriter.Append(cmd)
-			}
-
-			return protocol.NewArray([]*protocol.RESPValue{
-				protocol.NewBulkString(key),
-				protocol.NewBulkString(string(val)),
-			})
+		if result.Type == protocol.RESP_ERROR {
+			return &blockingServe{reply: result}
 		}
+		// 传播为实际弹出元素的键上的 LPOP/RPOP
+		return &blockingServe{reply: result, effect: newCommand(pop, key)}
+	})
+	if result == nil {
+		return protocol.NewNullArray()
 	}
+	return result
+}
 
-	// 如果超时为0，立即返回
-	if timeout == 0 {
-		return protocol.NewNullBulkString()
+// listPopOne 从列表的 where 端弹出一个元素，返回 [key, value]；键不存在或为空时返回 nil
+func listPopOne(db *storage.RedisDb, key string, where int) *protocol.RESPValue {
+	obj, err := db.Get(key)
+	if err != nil {
+		return nil
+	}
+	list, err := obj.GetList()
+	if err != nil {
+		return protocol.NewError("ERR wrong type")
 	}
 
-	// 阻塞等待
-	bc := ctx.Server.blockingMgr.Wait(ctx.Client, keys, timeout)
-
-	// 等待通知或超时
-	select {
-	case result := <-bc.notify:
-		return result
-	case <-time.After(time.Duration(timeout) * time.Second):
-		return protocol.NewNullBulkString()
+	value, err := list.Pop(where)
+	if err != nil {
+		return nil
+	}
+	if list.Len() == 0 {
+		db.Del(key)
 	}
+
+	return protocol.NewArray([]*protocol.RESPValue{
+		protocol.NewBulkString(key),
+		protocol.NewBulkString(string(value)),
+	})
 }
 
 // ========== 持久化命令实现 ==========
 
 func cmdSave(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
-	// 获取 RDB 文件路径（默认：dump.rdb）
-	filename := "dump.rdb"
-	if len(args) > 0 {
-		filename = args[0].ToString()
-	}
-
 	// 创建 RDB 编码器
-	encoder := persistence.NewRDBEncoder(nil)
+	encoder := ctx.Server.newRDBEncoder()
 
-	// 保存到文件
-	err := encoder.Save(ctx.Server.GetRedisServer(), filename)
+	// 保存到文件（与 BGSAVE 使用同一个 RDB 文件）
+	redisServer := ctx.Server.GetRedisServer()
+	dirty := redisServer.Dirty()
+	err := encoder.Save(redisServer, ctx.Server.rdbFilename)
 	if err != nil {
+		redisServer.MarkSaveFailed()
 		return protocol.NewError("ERR " + err.Error())
 	}
+	redisServer.MarkSaved(dirty)
 
 	return protocol.NewSimpleString("OK")
 }
 
+// cmdShutdown SHUTDOWN [NOSAVE|SAVE]：停止服务器
+// 默认在配置了 save 保存点时先保存 RDB；SAVE 强制保存，NOSAVE 不保存。
+// 成功时不回复（连接随服务器关闭），保存失败时返回错误且不关闭
+func cmdShutdown(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	savePoints, _ := ctx.Server.config.Get("save")
+	save := strings.TrimSpace(savePoints) != ""
+	for _, arg := range args {
+		switch strings.ToUpper(arg.ToString()) {
+		case "SAVE":
+			save = true
+		case "NOSAVE":
+			save = false
+		default:
+			return protocol.NewError("ERR syntax error")
+		}
+	}
+
+	if err := ctx.Server.Shutdown(save); err != nil {
+		fmt.Printf("Error trying to save the DB before shutdown: %v\n", err)
+		return protocol.NewError("ERR Errors trying to SHUTDOWN. Check logs.")
+	}
+	return nil
+}
+
+// cmdLastSave LASTSAVE：返回上次成功保存 RDB 的时间（Unix 秒）
+func cmdLastSave(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	return protocol.NewInteger(ctx.Server.GetRedisServer().LastSave())
+}
+
 func cmdBGSave(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
-	// 在后台 goroutine 中执行保存
+	if !ctx.Server.startBackgroundSave() {
+		return protocol.NewError("ERR Background save already in progress")
+	}
+	return protocol.NewSimpleString("Background saving started")
+}
+
+// startBackgroundSave 在后台 goroutine 中保存 RDB（按批遍历数据库，不会长时间阻塞其它命令）
+// 已有后台保存在进行时返回 false
+func (s *Server) startBackgroundSave() bool {
+	if !s.bgsaveRunning.CompareAndSwap(false, true) {
+		return false
+	}
+
 	go func() {
-		filename := "dump.rdb"
-		encoder := persistence.NewRDBEncoder(nil)
-		encoder.Save(ctx.Server.GetRedisServer(), filename)
+		defer s.bgsaveRunning.Store(false)
+
+		redisServer := s.GetRedisServer()
+		dirty := redisServer.Dirty()
+		encoder := s.newRDBEncoder()
+		if err := encoder.Save(redisServer, s.rdbFilename); err != nil {
+			fmt.Printf("Background saving error: %v\n", err)
+			redisServer.MarkSaveFailed()
+			return
+		}
+		redisServer.MarkSaved(dirty)
 	}()
 
-	return protocol.NewSimpleString("Background saving started")
+	return true
 }
 
 // ========== 集群命令实现 ==========
@@ -3823,6 +6472,9 @@ func cmdCluster(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPV
 			if node.Master != nil {
 				flags = "slave"
 			}
+			if node == cluster.GetMyself() {
+				flags = "myself," + flags
+			}
 
 			masterID := "-"
 			if node.Master != nil {
@@ -3891,11 +6543,154 @@ func cmdCluster(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPV
 		ctx.Server.cluster.AssignSlots(myself.NodeID, slots)
 		return protocol.NewSimpleString("OK")
 
+	case "SETSLOT":
+		// CLUSTER SETSLOT slot IMPORTING|MIGRATING|NODE node-id 或 CLUSTER SETSLOT slot STABLE
+		if len(args) < 3 {
+			return protocol.NewError("ERR wrong number of arguments for 'cluster|setslot' command")
+		}
+		slot, err := parseClusterSlot(args[1].ToString())
+		if err != nil {
+			return protocol.NewError(err.Error())
+		}
+		resharding := ctx.Server.cluster.GetReshardingManager()
+		action := strings.ToUpper(args[2].ToString())
+		if action == "STABLE" {
+			if len(args) != 3 {
+				return protocol.NewError("ERR syntax error")
+			}
+			resharding.SetSlotStable(slot)
+			return protocol.NewSimpleString("OK")
+		}
+		if len(args) != 4 {
+			return protocol.NewError("ERR syntax error")
+		}
+		nodeID := args[3].ToString()
+		switch action {
+		case "MIGRATING":
+			err = resharding.SetSlotMigrating(slot, nodeID)
+		case "IMPORTING":
+			err = resharding.SetSlotImporting(slot, nodeID)
+		case "NODE":
+			err = resharding.SetSlotNode(slot, nodeID)
+		default:
+			return protocol.NewError("ERR Invalid CLUSTER SETSLOT action or number of arguments. Try CLUSTER HELP")
+		}
+		if err != nil {
+			return protocol.NewError("ERR " + err.Error())
+		}
+		return protocol.NewSimpleString("OK")
+
+	case "FORGET":
+		// CLUSTER FORGET node-id：从本节点的集群视图中移除节点
+		if len(args) != 2 {
+			return protocol.NewError("ERR wrong number of arguments for 'cluster|forget' command")
+		}
+		if err := ctx.Server.cluster.ForgetNode(args[1].ToString()); err != nil {
+			return protocol.NewError("ERR " + err.Error())
+		}
+		return saveClusterConfig(ctx)
+
+	case "REPLICATE":
+		// CLUSTER REPLICATE node-id：成为指定主节点的从节点
+		if len(args) != 2 {
+			return protocol.NewError("ERR wrong number of arguments for 'cluster|replicate' command")
+		}
+		if err := ctx.Server.cluster.ReplicateNode(args[1].ToString()); err != nil {
+			return protocol.NewError("ERR " + err.Error())
+		}
+		return saveClusterConfig(ctx)
+
+	case "RESET":
+		// CLUSTER RESET [HARD|SOFT]：清除槽分配和其它节点，HARD 同时生成新的节点 ID
+		hard := false
+		if len(args) > 2 {
+			return protocol.NewError("ERR wrong number of arguments for 'cluster|reset' command")
+		}
+		if len(args) == 2 {
+			switch strings.ToUpper(args[1].ToString()) {
+			case "HARD":
+				hard = true
+			case "SOFT":
+			default:
+				return protocol.NewError("ERR syntax error")
+			}
+		}
+		if err := ctx.Server.cluster.Reset(hard); err != nil {
+			return protocol.NewError("ERR " + err.Error())
+		}
+		return saveClusterConfig(ctx)
+
+	case "KEYSLOT":
+		// CLUSTER KEYSLOT key：键对应的槽号
+		if len(args) != 2 {
+			return protocol.NewError("ERR wrong number of arguments for 'cluster|keyslot' command")
+		}
+		return protocol.NewInteger(int64(cluster.HashSlot(args[1].ToString())))
+
+	case "COUNTKEYSINSLOT":
+		// CLUSTER COUNTKEYSINSLOT slot：当前数据库中属于该槽的键的数量
+		if len(args) != 2 {
+			return protocol.NewError("ERR wrong number of arguments for 'cluster|countkeysinslot' command")
+		}
+		slot, err := parseClusterSlot(args[1].ToString())
+		if err != nil {
+			return protocol.NewError(err.Error())
+		}
+		return protocol.NewInteger(int64(len(cluster.KeysInSlot(ctx.Db, slot, -1))))
+
+	case "GETKEYSINSLOT":
+		// CLUSTER GETKEYSINSLOT slot count：当前数据库中属于该槽的最多 count 个键
+		if len(args) != 3 {
+			return protocol.NewError("ERR wrong number of arguments for 'cluster|getkeysinslot' command")
+		}
+		slot, err := parseClusterSlot(args[1].ToString())
+		if err != nil {
+			return protocol.NewError(err.Error())
+		}
+		count, err := strconv.Atoi(args[2].ToString())
+		if err != nil || count < 0 {
+			return protocol.NewError("ERR Invalid number of keys")
+		}
+		keys := cluster.KeysInSlot(ctx.Db, slot, count)
+		result := make([]*protocol.RESPValue, len(keys))
+		for i, key := range keys {
+			result[i] = protocol.NewBulkString(key)
+		}
+		return protocol.NewArray(result)
+
 	default:
 		return protocol.NewError("ERR unknown subcommand or wrong number of arguments for 'cluster'")
 	}
 }
 
+// saveClusterConfig 集群配置变更后保存到配置文件，成功时回复 OK
+func saveClusterConfig(ctx *CommandContext) *protocol.RESPValue {
+	if err := ctx.Server.cluster.SaveConfig(); err != nil {
+		return protocol.NewError("ERR Error saving the cluster node config: " + err.Error())
+	}
+	return protocol.NewSimpleString("OK")
+}
+
+// cmdAsking ASKING：收到 ASK 重定向后，允许下一条命令访问本节点正在导入的槽
+func cmdAsking(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	if ctx.Server.cluster == nil {
+		return protocol.NewError("ERR This instance has cluster support disabled")
+	}
+	if ctx.Client != nil {
+		ctx.Client.asking = true
+	}
+	return protocol.NewSimpleString("OK")
+}
+
+// parseClusterSlot 解析槽号参数（0 ~ 16383）
+func parseClusterSlot(arg string) (int, error) {
+	slot, err := strconv.Atoi(arg)
+	if err != nil || slot < 0 || slot >= cluster.CLUSTER_SLOTS {
+		return 0, fmt.Errorf("ERR Invalid or out of range slot")
+	}
+	return slot, nil
+}
+
 // parsePort 解析端口字符串
 func parsePort(portStr string) int64 {
 	port, err := strconv.Atoi(portStr)
@@ -3919,16 +6714,28 @@ func cmdReplConf(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESP
 
 	switch option {
 	case "LISTENING-PORT":
-		// 从节点报告监听端口
-		// 简化实现：直接返回 OK
+		// 从节点报告监听端口（INFO replication 中显示）
+		port, err := strconv.Atoi(args[1].ToString())
+		if err != nil || port < 0 || port > 65535 {
+			return protocol.NewError("ERR value is not an integer or out of range")
+		}
+		if ctx.Client != nil {
+			ctx.Client.replPort = port
+		}
 		return protocol.NewSimpleString("OK")
 	case "CAPA":
 		// 能力协商
 		return protocol.NewSimpleString("OK")
 	case "ACK":
-		// 从节点确认接收到的数据量
-		// 简化实现：直接返回 OK
-		return protocol.NewSimpleString("OK")
+		// 从节点确认已处理的复制偏移量（WAIT 依赖），按 Redis 的约定不回复
+		offset, err := strconv.ParseInt(args[1].ToString(), 10, 64)
+		if err != nil {
+			return nil
+		}
+		if ctx.Server.master != nil && ctx.Client != nil {
+			ctx.Server.master.Ack(ctx.Client.conn, offset)
+		}
+		return nil
 	default:
 		return protocol.NewSimpleString("OK")
 	}
@@ -3946,51 +6753,180 @@ func cmdPSync(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPVal
 		return protocol.NewError("ERR no client connection")
 	}
 
-	// 添加从节点（fullResync 会在 goroutine 中执行）
-	_ = ctx.Server.master.AddReplica(ctx.Client.conn)
+	offset, err := strconv.ParseInt(args[1].ToString(), 10, 64)
+	if err != nil {
+		return protocol.NewError("ERR value is not an integer or out of range")
+	}
 
-	// fullResync 会在 goroutine 中执行，这里不需要等待
-	// 返回会被 fullResync 中的响应覆盖，但为了兼容性先返回 OK
-	return protocol.NewSimpleString("OK")
+	// 复制 ID 匹配且缺失的数据仍在积压缓冲区中时进行部分同步（+CONTINUE）
+	if ctx.Server.master.TryPartialResync(ctx.Client.conn, ctx.Client.replPort, args[0].ToString(), offset) {
+		return nil
+	}
+
+	// 添加从节点，全量同步在后台执行：+FULLRESYNC 回复、RDB 快照和之后的命令流
+	// 都由主节点直接写入连接，这里不再回复
+	ctx.Server.master.AddReplica(ctx.Client.conn, ctx.Client.replPort)
+	return nil
 }
 
+// cmdSlaveOf SLAVEOF/REPLICAOF host port：成为 host:port 的从节点，
+// 丢弃本地数据后加载主节点的快照，之后执行主节点传播的写命令，断开后自动重连并尝试部分同步。
+// SLAVEOF NO ONE：停止复制并提升为主节点（保留已同步的数据，生成新的复制 ID）
 func cmdSlaveOf(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
-	// SLAVEOF 命令用于将当前服务器设置为从节点
-	// 格式: SLAVEOF <host> <port> 或 SLAVEOF NO ONE
-	if len(args) < 2 {
-		return protocol.NewError("ERR wrong number of arguments for 'slaveof' command")
+	if ctx.Server.clusterEnabled {
+		return protocol.NewError("ERR REPLICAOF not allowed in cluster mode.")
 	}
 
 	host := args[0].ToString()
 	port := args[1].ToString()
 
 	if strings.ToUpper(host) == "NO" && strings.ToUpper(port) == "ONE" {
-		// SLAVEOF NO ONE - 停止复制，成为主节点
-		// 简化实现：直接返回 OK
+		// 停止复制，成为主节点
+		if ctx.Server.getSlave() != nil {
+			ctx.Server.setSlave(nil)
+			ctx.Server.master.ChangeReplID()
+		}
 		return protocol.NewSimpleString("OK")
 	}
 
-	// 连接到主节点
-	masterAddr := fmt.Sprintf("%s:%s", host, port)
-	slave := replication.NewSlave(masterAddr)
+	if _, err := strconv.Atoi(port); err != nil {
+		return protocol.NewError("ERR Invalid master port")
+	}
+	masterAddr := net.JoinHostPort(host, port)
+	if slave := ctx.Server.getSlave(); slave != nil && slave.MasterAddr() == masterAddr {
+		return protocol.NewSimpleString("OK Already connected to specified master")
+	}
+
+	// 连接到主节点，握手和同步在后台进行
+	slave := replication.NewSlave(masterAddr, ctx.Server.listeningPort(), &replicaLink{server: ctx.Server})
 	if err := slave.Connect(); err != nil {
 		return protocol.NewError(fmt.Sprintf("ERR failed to connect to master: %v", err))
 	}
+	ctx.Server.setSlave(slave)
+
+	// 本节点的从节点需要重新同步新的数据集
+	ctx.Server.master.DisconnectReplicas()
 
-	// 简化实现：不保存 slave 引用，只返回 OK
 	return protocol.NewSimpleString("OK")
 }
 
+// cmdWait WAIT numreplicas timeout
+// 阻塞直到至少 numreplicas 个从节点确认收到了本客户端最近一次写命令（REPLCONF ACK），
+// 或超时（毫秒，0 表示一直等待），返回已确认的从节点数量。
+// numreplicas 为 0 时不阻塞，直接返回当前已确认的数量
+func cmdWait(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	numReplicas, err := strconv.ParseInt(args[0].ToString(), 10, 64)
+	if err != nil {
+		return protocol.NewError("ERR value is not an integer or out of range")
+	}
+	timeout, err := strconv.ParseInt(args[1].ToString(), 10, 64)
+	if err != nil {
+		return protocol.NewError("ERR timeout is not an integer or out of range")
+	}
+	if timeout < 0 {
+		return protocol.NewError("ERR timeout is negative")
+	}
+
+	master := ctx.Server.master
+	if master == nil {
+		return protocol.NewInteger(0)
+	}
+
+	offset := master.ReplOffset()
+	if ctx.Client != nil {
+		offset = ctx.Client.woff
+	}
+	acked := master.AckedReplicas(offset)
+	if numReplicas <= 0 || int64(acked) >= numReplicas {
+		return protocol.NewInteger(int64(acked))
+	}
+
+	// 要求从节点立即上报偏移量，而不是等它们的定期 ACK
+	master.RequestAcks()
+
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(time.Duration(timeout) * time.Millisecond)
+	}
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for range ticker.C {
+		acked = master.AckedReplicas(offset)
+		if int64(acked) >= numReplicas {
+			break
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			break
+		}
+	}
+	return protocol.NewInteger(int64(acked))
+}
+
 // ========== AOF 命令实现 ==========
 
+// aofRewriteKeys AOF 重写期间判断写命令修改了哪些键。
+// 会修改其它数据库或键的位置不固定的命令返回 false，重写结束时重新写入整个键空间
+func (s *Server) aofRewriteKeys(req *protocol.RESPValue) ([]string, bool) {
+	argv := req.GetArray()
+	if len(argv) == 0 {
+		return nil, false
+	}
+	name := toUpper(argv[0].ToString())
+
+	switch name {
+	case "MOVE", "COPY", "SWAPDB", "FLUSHDB", "FLUSHALL", "SORT", "BITOP":
+		return nil, false
+	}
+
+	keys := s.commandKeys(argv)
+	return keys, len(keys) > 0
+}
+
+// commandKeys 返回命令参数中的键（未知命令或没有键时返回 nil）
+func (s *Server) commandKeys(argv []*protocol.RESPValue) []string {
+	name := toUpper(argv[0].ToString())
+	switch name {
+	case "LMPOP", "ZMPOP":
+		// LMPOP numkeys key [key ...] ...
+		if len(argv) < 2 {
+			return nil
+		}
+		numkeys, err := strconv.Atoi(argv[1].ToString())
+		if err != nil || numkeys <= 0 || 2+numkeys > len(argv) {
+			return nil
+		}
+		keys := make([]string, 0, numkeys)
+		for _, arg := range argv[2 : 2+numkeys] {
+			keys = append(keys, arg.ToString())
+		}
+		return keys
+	}
+
+	cmd, err := s.cmdTable.Lookup(name)
+	if err != nil {
+		return nil
+	}
+	return cmd.keys(argv)
+}
+
 func cmdBGRewriteAOF(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
 	if ctx.Server.aofWriter == nil {
 		return protocol.NewError("ERR AOF is not enabled")
 	}
 
-	// 在后台 goroutine 中执行 AOF 重写
+	if !ctx.Server.aofRewriting.CompareAndSwap(false, true) {
+		return protocol.NewError("ERR Background append only file rewriting already in progress")
+	}
+
+	// 在后台 goroutine 中执行 AOF 重写，记录结果供 INFO persistence 查看
 	go func() {
-		ctx.Server.aofWriter.Rewrite(ctx.Server.GetRedisServer())
+		defer ctx.Server.aofRewriting.Store(false)
+		err := ctx.Server.aofWriter.Rewrite(ctx.Server.GetRedisServer(), ctx.Server.aofRewriteKeys)
+		if err != nil {
+			fmt.Printf("Background AOF rewrite error: %v\n", err)
+		}
+		ctx.Server.aofRewriteErr.Store(err != nil)
 	}()
 
 	return protocol.NewSimpleString("Background append only file rewriting started")
@@ -3998,18 +6934,22 @@ func cmdBGRewriteAOF(ctx *CommandContext, args []*protocol.RESPValue) *protocol.
 
 // ========== 阻塞 ZSet 命令实现 ==========
 
+// cmdBZPopMax BZPOPMAX key [key ...] timeout
 func cmdBZPopMax(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
-	if len(args) < 2 {
-		return protocol.NewError("ERR wrong number of arguments for 'bzpopmax' command")
-	}
+	return blockingZSetPop(ctx, args, true)
+}
 
-	timeout, err := strconv.ParseInt(args[len(args)-1].ToString(), 10, 64)
-	if err != nil {
-		return protocol.NewError("ERR invalid timeout")
-	}
+// cmdBZPopMin BZPOPMIN key [key ...] timeout
+func cmdBZPopMin(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
+	return blockingZSetPop(ctx, args, false)
+}
 
-	if timeout < 0 {
-		return protocol.NewError("ERR timeout is negative")
+// blockingZSetPop BZPOPMIN/BZPOPMAX 的公共实现：所有有序集合都为空时阻塞，
+// 有成员加入时由 ZADD 一方代为弹出，超时返回 nil
+func blockingZSetPop(ctx *CommandContext, args []*protocol.RESPValue, max bool) *protocol.RESPValue {
+	timeout, errResp := parseBlockTimeout(args[len(args)-1])
+	if errResp != nil {
+		return errResp
 	}
 
 	keys := make([]string, len(args)-1)
@@ -4017,115 +6957,66 @@ func cmdBZPopMax(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESP
 		keys[i] = args[i].ToString()
 	}
 
-	// 先尝试非阻塞弹出
+	// 任意一个键不是有序集合时立即报错，而不是一直阻塞
 	for _, key := range keys {
-		obj, err := ctx.Db.Get(key)
-		if err != nil {
-			continue
-		}
-
-		zset, err := obj.GetZSet()
-		if err != nil {
-			continue
-		}
-
-		if zset.Card() > 0 {
-			// 获取最大元素
-			entries, _ := zset.Range(0, 0, true) // 降序，第一个
-			if len(entries) > 0 {
-				entry := entries[0]
-				zset.Remove(entry.Member())
-
-				// 记录到 AOF
-				if ctx.Server.aofWriter != nil {
-					cmd := protocol.NewArray([]*protocol.RESPValue{
-						protocol.NewBulkString("ZREM"),
-						protocol.NewBulkString(key),
-						protocol.NewBulkString(string(entry.Member())),
-					})
-					ctx.Server.aofWriter.Append(cmd)
-				}
-
-				return protocol.NewArray([]*protocol.RESPValue{
-					protocol.NewBulkString(key),
-					protocol.NewBulkString(string(entry.Member())),
-					protocol.NewBulkString(strconv.FormatFloat(entry.Score(), 'f', -1, 64)),
-				})
+		if obj, err := ctx.Db.Get(key); err == nil {
+			if _, err := obj.GetZSet(); err != nil {
+				return protocol.NewError("ERR wrong type")
 			}
 		}
 	}
 
-	// 如果超时为0，立即返回
-	if timeout == 0 {
-		return protocol.NewNullBulkString()
+	db := ctx.Db
+	result := blockForKeys(ctx, keys, timeout, func(key string) *blockingServe {
+		result := zsetPopOne(db, key, max)
+		if result == nil {
+			return nil
+		}
+		if result.Type == protocol.RESP_ERROR {
+			return &blockingServe{reply: result}
+		}
+		// 传播为删除被弹出成员的 ZREM
+		return &blockingServe{reply: result, effect: newCommand("ZREM", key, result.Array[1].Str)}
+	})
+	if result == nil {
+		return protocol.NewNullArray()
 	}
-
-	// 阻塞等待（简化实现：不实现真正的阻塞）
-	return protocol.NewNullBulkString()
+	return result
 }
 
-func cmdBZPopMin(ctx *CommandContext, args []*protocol.RESPValue) *protocol.RESPValue {
-	if len(args) < 2 {
-		return protocol.NewError("ERR wrong number of arguments for 'bzpopmin' command")
+// zsetPopOne 弹出有序集合中分数最小（max 为 true 时最大）的成员，返回 [key, member, score]；
+// 键不存在或为空时返回 nil
+func zsetPopOne(db *storage.RedisDb, key string, max bool) *protocol.RESPValue {
+	obj, err := db.Get(key)
+	if err != nil {
+		return nil
 	}
-
-	timeout, err := strconv.ParseInt(args[len(args)-1].ToString(), 10, 64)
+	zset, err := obj.GetZSet()
 	if err != nil {
-		return protocol.NewError("ERR invalid timeout")
+		return protocol.NewError("ERR wrong type")
 	}
-
-	if timeout < 0 {
-		return protocol.NewError("ERR timeout is negative")
+	if zset.Card() == 0 {
+		return nil
 	}
 
-	keys := make([]string, len(args)-1)
-	for i := 0; i < len(args)-1; i++ {
-		keys[i] = args[i].ToString()
+	// Range 的 start/end 始终是正向下标
+	index := 0
+	if max {
+		index = -1
 	}
-
-	// 先尝试非阻塞弹出
-	for _, key := range keys {
-		obj, err := ctx.Db.Get(key)
-		if err != nil {
-			continue
-		}
-
-		zset, err := obj.GetZSet()
-		if err != nil {
-			continue
-		}
-
-		if zset.Card() > 0 {
-			// 获取最小元素
-			entries, _ := zset.Range(0, 0, false) // 升序，第一个
-			if len(entries) > 0 {
-				entry := entries[0]
-				zset.Remove(entry.Member())
-
-				// 记录到 AOF
-				if ctx.Server.aofWriter != nil {
-					cmd := protocol.NewArray([]*protocol.RESPValue{
-						protocol.NewBulkString("ZREM"),
-						protocol.NewBulkString(key),
-						protocol.NewBulkString(string(entry.Member())),
-					})
-					ctx.Server.aofWriter.Append(cmd)
-				}
-
-				return protocol.NewArray([]*protocol.RESPValue{
-					protocol.NewBulkString(key),
-					protocol.NewBulkString(string(entry.Member())),
-					protocol.NewBulkString(strconv.FormatFloat(entry.Score(), 'f', -1, 64)),
-				})
-			}
-		}
+	entries, _ := zset.Range(index, index, max)
+	if len(entries) == 0 {
+		return nil
 	}
-
-	// 如果超时为0，立即返回
-	if timeout == 0 {
-		return protocol.NewNullBulkString()
+	entry := entries[0]
+	zset.Remove(entry.Member())
+	if zset.Card() == 0 {
+		db.Del(key)
 	}
 
-	// 阻塞等待（简化实现：不实现真正的阻塞）
-	return protocol.NewNullBulkString()
+	return protocol.NewArray([]*protocol.RESPValue{
+		protocol.NewBulkString(key),
+		protocol.NewBulkString(string(entry.Member())),
+		protocol.NewDouble(entry.Score()),
+	})
 }
@@ -0,0 +1,2113 @@
+package server
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/code-100-precent/LingCache/protocol"
+	"github.com/code-100-precent/LingCache/storage"
+)
+
+// newTestContext 创建用于命令测试的上下文（不监听端口）
+func newTestContext(t *testing.T) *CommandContext {
+	server := NewServer(":0", 16)
+	db, err := server.redisServer.GetDb(0)
+	if err != nil {
+		t.Fatalf("Failed to get db: %v", err)
+	}
+	return &CommandContext{Server: server, Db: db}
+}
+
+// execCommand 通过命令表执行一条命令
+func execCommand(ctx *CommandContext, args ...string) *protocol.RESPValue {
+	values := make([]*protocol.RESPValue, len(args))
+	for i, arg := range args {
+		values[i] = protocol.NewBulkString(arg)
+	}
+	return ctx.Server.cmdTable.ExecuteCommand(ctx, protocol.NewArray(values))
+}
+
+// replyStrings 将数组回复转换为字符串切片
+func replyStrings(t *testing.T, reply *protocol.RESPValue) []string {
+	t.Helper()
+	if reply.Type == protocol.RESP_ERROR {
+		t.Fatalf("Unexpected error reply: %s", reply.Str)
+	}
+	result := make([]string, 0, len(reply.Array))
+	for _, v := range reply.Array {
+		result = append(result, v.ToString())
+	}
+	return result
+}
+
+// assertStrings 比较字符串切片
+func assertStrings(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Expected %v, got %v", want, got)
+		}
+	}
+}
+
+// TestZSetOpMatchesStore 测试 ZUNION/ZINTER/ZDIFF 与 *STORE 结果一致
+func TestZSetOpMatchesStore(t *testing.T) {
+	ctx := newTestContext(t)
+	execCommand(ctx, "ZADD", "z1", "1", "a", "2", "b", "3", "c")
+	execCommand(ctx, "ZADD", "z2", "10", "b", "20", "c", "30", "d")
+
+	cases := [][2]string{
+		{"ZUNION", "ZUNIONSTORE"},
+		{"ZINTER", "ZINTERSTORE"},
+		{"ZDIFF", "ZDIFFSTORE"},
+	}
+	for _, c := range cases {
+		direct := replyStrings(t, execCommand(ctx, c[0], "2", "z1", "z2"))
+
+		stored := execCommand(ctx, c[1], "dest", "2", "z1", "z2")
+		if stored.Int != int64(len(direct)) {
+			t.Fatalf("%s stored %d members, %s returned %d", c[1], stored.Int, c[0], len(direct))
+		}
+		fromStore := replyStrings(t, execCommand(ctx, "ZRANGE", "dest", "0", "-1", "WITHSCORES"))
+		members := make([]string, 0, len(fromStore)/2)
+		for i := 0; i < len(fromStore); i += 2 {
+			members = append(members, fromStore[i])
+		}
+		assertStrings(t, direct, members...)
+	}
+
+	t.Log("ZUNION/ZINTER/ZDIFF store parity test passed")
+}
+
+// TestZSetOpWithScores 测试 WITHSCORES 按分数排序且支持 WEIGHTS/AGGREGATE
+func TestZSetOpWithScores(t *testing.T) {
+	ctx := newTestContext(t)
+	execCommand(ctx, "ZADD", "z1", "5", "a", "1", "b")
+	execCommand(ctx, "ZADD", "z2", "1", "a", "1", "c")
+
+	reply := replyStrings(t, execCommand(ctx, "ZUNION", "2", "z1", "z2", "WITHSCORES"))
+	assertStrings(t, reply, "b", "1", "c", "1", "a", "6")
+
+	reply = replyStrings(t, execCommand(ctx, "ZINTER", "2", "z1", "z2", "WEIGHTS", "1", "10", "AGGREGATE", "MAX", "WITHSCORES"))
+	assertStrings(t, reply, "a", "10")
+
+	reply = replyStrings(t, execCommand(ctx, "ZDIFF", "2", "z1", "z2", "WITHSCORES"))
+	assertStrings(t, reply, "b", "1")
+
+	if r := execCommand(ctx, "ZDIFF", "2", "z1", "z2", "WEIGHTS", "1", "1"); r.Type != protocol.RESP_ERROR {
+		t.Fatal("ZDIFF should reject WEIGHTS")
+	}
+
+	t.Log("ZUNION/ZINTER/ZDIFF WITHSCORES test passed")
+}
+
+// TestNewCommandsArity 测试新注册命令参数不足时返回标准参数数量错误
+func TestNewCommandsArity(t *testing.T) {
+	ctx := newTestContext(t)
+
+	cases := [][]string{
+		{"SINTERCARD", "1"},
+		{"SMISMEMBER", "key"},
+		{"ZMSCORE", "key"},
+		{"ZINTERCARD", "1"},
+		{"LPOS", "key"},
+		{"LMPOP", "1", "key"},
+		{"ZMPOP", "1", "key"},
+	}
+	for _, c := range cases {
+		reply := execCommand(ctx, c...)
+		want := "ERR wrong number of arguments for '" + c[0] + "' command"
+		if reply.Type != protocol.RESP_ERROR || reply.Str != want {
+			t.Fatalf("%s: expected %q, got %q", c[0], want, reply.Str)
+		}
+	}
+
+	t.Log("New commands arity test passed")
+}
+
+// TestNewCommandsBasic 测试新命令的基本行为
+func TestNewCommandsBasic(t *testing.T) {
+	ctx := newTestContext(t)
+	execCommand(ctx, "SADD", "s1", "a", "b", "c")
+	execCommand(ctx, "SADD", "s2", "b", "c", "d")
+	execCommand(ctx, "ZADD", "z", "1", "a", "2", "b", "3", "c")
+	execCommand(ctx, "RPUSH", "l", "a", "b", "a", "c")
+
+	if r := execCommand(ctx, "SINTERCARD", "2", "s1", "s2"); r.Int != 2 {
+		t.Fatalf("SINTERCARD expected 2, got %d", r.Int)
+	}
+	if r := execCommand(ctx, "SINTERCARD", "2", "s1", "s2", "LIMIT", "1"); r.Int != 1 {
+		t.Fatalf("SINTERCARD LIMIT expected 1, got %d", r.Int)
+	}
+	if r := execCommand(ctx, "SMISMEMBER", "s1", "a", "d"); r.Array[0].Int != 1 || r.Array[1].Int != 0 {
+		t.Fatal("SMISMEMBER returned wrong result")
+	}
+	if r := execCommand(ctx, "ZMSCORE", "z", "b", "x"); r.Array[0].ToString() != "2" || !r.Array[1].Null {
+		t.Fatal("ZMSCORE returned wrong result")
+	}
+	if r := execCommand(ctx, "LPOS", "l", "a", "RANK", "2"); r.Int != 2 {
+		t.Fatalf("LPOS RANK 2 expected 2, got %d", r.Int)
+	}
+
+	reply := execCommand(ctx, "ZMPOP", "1", "z", "MAX", "COUNT", "2")
+	popped := reply.Array[1].Array
+	if len(popped) != 2 || popped[0].Array[0].ToString() != "c" || popped[1].Array[0].ToString() != "b" {
+		t.Fatal("ZMPOP MAX COUNT 2 returned wrong members")
+	}
+
+	reply = execCommand(ctx, "LMPOP", "2", "missing", "l", "LEFT")
+	if reply.Array[0].ToString() != "l" || reply.Array[1].Array[0].ToString() != "a" {
+		t.Fatal("LMPOP should pop from the first non-empty list")
+	}
+
+	// 所有键都为空时返回空数组而不是空字符串
+	for _, cmd := range [][]string{
+		{"LMPOP", "2", "missing", "other", "LEFT"},
+		{"ZMPOP", "1", "missing", "MIN"},
+	} {
+		if reply := execCommand(ctx, cmd...); reply.Type != protocol.RESP_ARRAY || !reply.Null {
+			t.Fatalf("%s on empty keys: expected a null array, got %v", cmd[0], reply)
+		}
+	}
+
+	t.Log("New commands basic test passed")
+}
+
+// TestZRangeStore 测试 ZRANGESTORE 按分数子区间保存以及空结果删除目标键
+func TestZRangeStore(t *testing.T) {
+	ctx := newTestContext(t)
+	execCommand(ctx, "ZADD", "src", "1", "a", "2", "b", "3", "c", "4", "d")
+
+	if r := execCommand(ctx, "ZRANGESTORE", "dest", "src", "2", "(4", "BYSCORE"); r.Int != 2 {
+		t.Fatalf("ZRANGESTORE expected 2, got %d", r.Int)
+	}
+	reply := replyStrings(t, execCommand(ctx, "ZRANGE", "dest", "0", "-1", "WITHSCORES"))
+	assertStrings(t, reply, "b", "2", "c", "3")
+
+	if r := execCommand(ctx, "ZRANGESTORE", "dest", "src", "+inf", "-inf", "BYSCORE", "REV", "LIMIT", "0", "1"); r.Int != 1 {
+		t.Fatalf("ZRANGESTORE REV LIMIT expected 1, got %d", r.Int)
+	}
+	reply = replyStrings(t, execCommand(ctx, "ZRANGE", "dest", "0", "-1"))
+	assertStrings(t, reply, "d")
+
+	if r := execCommand(ctx, "ZRANGESTORE", "dest", "src", "10", "20", "BYSCORE"); r.Int != 0 {
+		t.Fatalf("ZRANGESTORE empty range expected 0, got %d", r.Int)
+	}
+	if r := execCommand(ctx, "EXISTS", "dest"); r.Int != 0 {
+		t.Fatal("Empty ZRANGESTORE should delete destination")
+	}
+
+	t.Log("ZRANGESTORE test passed")
+}
+
+// TestDebugObject 测试 DEBUG OBJECT 的错误与输出格式
+func TestDebugObject(t *testing.T) {
+	ctx := newTestContext(t)
+
+	reply := execCommand(ctx, "DEBUG", "OBJECT", "missing")
+	if reply.Type != protocol.RESP_ERROR || reply.Str != "ERR no such key" {
+		t.Fatalf("Expected 'ERR no such key', got %v", reply)
+	}
+
+	reply = execCommand(ctx, "DEBUG", "OBJECT")
+	if reply.Type != protocol.RESP_ERROR {
+		t.Fatalf("Expected arity error, got %v", reply)
+	}
+
+	execCommand(ctx, "SET", "k", "hello")
+	reply = execCommand(ctx, "DEBUG", "OBJECT", "k")
+	if reply.Type != protocol.RESP_SIMPLE_STRING {
+		t.Fatalf("Expected status reply, got %v", reply)
+	}
+	pattern := regexp.MustCompile(`^Value at:0x[0-9a-f]+ refcount:\d+ encoding:embstr$`)
+	if !pattern.MatchString(reply.Str) {
+		t.Fatalf("Unexpected DEBUG OBJECT line: %q", reply.Str)
+	}
+}
+
+// TestDebugQuicklistPackedThreshold 测试 DEBUG QUICKLIST-PACKED-THRESHOLD 的参数检查，
+// 超过阈值的元素写入列表后可以正常读取
+func TestDebugQuicklistPackedThreshold(t *testing.T) {
+	ctx := newTestContext(t)
+	defer execCommand(ctx, "DEBUG", "QUICKLIST-PACKED-THRESHOLD", "0")
+
+	for _, arg := range []string{"abc", "-1", "4gb"} {
+		if reply := execCommand(ctx, "DEBUG", "QUICKLIST-PACKED-THRESHOLD", arg); reply.Type != protocol.RESP_ERROR {
+			t.Fatalf("Expected an error for %q, got %v", arg, reply)
+		}
+	}
+	if reply := execCommand(ctx, "DEBUG", "QUICKLIST-PACKED-THRESHOLD", "1kb"); reply.Str != "OK" {
+		t.Fatalf("Expected OK, got %v", reply)
+	}
+
+	large := strings.Repeat("x", 10*1024)
+	execCommand(ctx, "RPUSH", "l", "a", large, "b")
+	if reply := execCommand(ctx, "OBJECT", "ENCODING", "l"); reply.Str != "quicklist" {
+		t.Fatalf("Expected quicklist encoding, got %v", reply)
+	}
+	assertStrings(t, replyStrings(t, execCommand(ctx, "LRANGE", "l", "0", "-1")), "a", large, "b")
+	if reply := execCommand(ctx, "LINDEX", "l", "1"); reply.Str != large {
+		t.Fatalf("Expected LINDEX to return the large element, got %d bytes", len(reply.Str))
+	}
+}
+
+// zscanAll 用 ZSCAN 遍历整个 ZSet，返回 member -> score
+func zscanAll(t *testing.T, ctx *CommandContext, key string, extra ...string) map[string]string {
+	t.Helper()
+	result := make(map[string]string)
+	cursor := "0"
+	for {
+		reply := execCommand(ctx, append([]string{"ZSCAN", key, cursor}, extra...)...)
+		if reply.Type == protocol.RESP_ERROR {
+			t.Fatalf("ZSCAN error: %s", reply.Str)
+		}
+		items := replyStrings(t, reply.Array[1])
+		if len(items)%2 != 0 {
+			t.Fatalf("ZSCAN returned odd number of elements: %v", items)
+		}
+		for i := 0; i < len(items); i += 2 {
+			result[items[i]] = items[i+1]
+		}
+		cursor = reply.Array[0].ToString()
+		if cursor == "0" {
+			return result
+		}
+	}
+}
+
+// TestZScan 测试 ZSCAN 在 listpack 和 skiplist 编码下都能完整遍历
+func TestZScan(t *testing.T) {
+	ctx := newTestContext(t)
+
+	for _, n := range []int{5, 300} {
+		key := "z" + strconv.Itoa(n)
+		for i := 0; i < n; i++ {
+			execCommand(ctx, "ZADD", key, strconv.Itoa(i)+".5", "m"+strconv.Itoa(i))
+		}
+
+		got := zscanAll(t, ctx, key, "COUNT", "7")
+		if len(got) != n {
+			t.Fatalf("ZSCAN %s returned %d members, want %d", key, len(got), n)
+		}
+		for i := 0; i < n; i++ {
+			if score := got["m"+strconv.Itoa(i)]; score != strconv.Itoa(i)+".5" {
+				t.Fatalf("Member m%d has score %q", i, score)
+			}
+		}
+
+		got = zscanAll(t, ctx, key, "MATCH", "m1*")
+		for member, score := range got {
+			if !strings.HasPrefix(member, "m1") {
+				t.Fatalf("MATCH returned unexpected member %s", member)
+			}
+			if score != member[1:]+".5" {
+				t.Fatalf("MATCH dropped score of %s: %q", member, score)
+			}
+		}
+		if _, ok := got["m1"]; !ok {
+			t.Fatalf("MATCH m1* missed member m1")
+		}
+	}
+
+	if reply := execCommand(ctx, "ZSCAN", "z5", "abc"); reply.Type != protocol.RESP_ERROR {
+		t.Fatalf("Expected invalid cursor error, got %v", reply)
+	}
+}
+
+// TestZAddIncrNaN 测试 ZADD 选项、INCR 结果为 NaN 时报错以及 inf 的格式化
+func TestZAddIncrNaN(t *testing.T) {
+	ctx := newTestContext(t)
+
+	execCommand(ctx, "ZADD", "z", "+inf", "a", "1", "b")
+	if reply := execCommand(ctx, "ZSCORE", "z", "a"); reply.Str != "inf" {
+		t.Fatalf("Expected inf, got %q", reply.Str)
+	}
+	execCommand(ctx, "ZADD", "z", "-inf", "c")
+	if reply := execCommand(ctx, "ZSCORE", "z", "c"); reply.Str != "-inf" {
+		t.Fatalf("Expected -inf, got %q", reply.Str)
+	}
+
+	for _, args := range [][]string{
+		{"ZINCRBY", "z", "-inf", "a"},
+		{"ZADD", "z", "INCR", "-inf", "a"},
+	} {
+		reply := execCommand(ctx, args...)
+		if reply.Type != protocol.RESP_ERROR || reply.Str != "ERR resulting score is not a number" {
+			t.Fatalf("%v: expected NaN error, got %v", args, reply)
+		}
+	}
+	if reply := execCommand(ctx, "ZSCORE", "z", "a"); reply.Str != "inf" {
+		t.Fatalf("Score changed after NaN error: %q", reply.Str)
+	}
+
+	if reply := execCommand(ctx, "ZADD", "z", "INCR", "2.5", "b"); reply.Str != "3.5" {
+		t.Fatalf("ZADD INCR returned %v", reply)
+	}
+	if reply := execCommand(ctx, "ZADD", "z", "NX", "INCR", "1", "b"); !reply.Null {
+		t.Fatalf("ZADD NX INCR on existing member should return nil, got %v", reply)
+	}
+	if reply := execCommand(ctx, "ZADD", "z", "CH", "GT", "10", "b", "0", "a", "5", "d"); reply.Int != 2 {
+		t.Fatalf("ZADD CH GT returned %v", reply)
+	}
+	if reply := execCommand(ctx, "ZADD", "z", "NX", "XX", "1", "a"); reply.Type != protocol.RESP_ERROR {
+		t.Fatalf("Expected NX/XX error, got %v", reply)
+	}
+	if reply := execCommand(ctx, "ZADD", "z", "nan", "a"); reply.Type != protocol.RESP_ERROR {
+		t.Fatalf("Expected invalid float error, got %v", reply)
+	}
+}
+
+// TestHGetDelAndHGetEx 测试 HGETDEL 删除字段、HGETEX 设置和移除字段 TTL
+func TestHGetDelAndHGetEx(t *testing.T) {
+	ctx := newTestContext(t)
+	execCommand(ctx, "HSET", "h", "a", "1")
+	execCommand(ctx, "HSET", "h", "b", "2")
+	execCommand(ctx, "HSET", "h", "c", "3")
+
+	reply := execCommand(ctx, "HGETDEL", "h", "FIELDS", "2", "a", "missing")
+	if len(reply.Array) != 2 || reply.Array[0].Str != "1" || !reply.Array[1].Null {
+		t.Fatalf("HGETDEL returned %v", reply.Array)
+	}
+	if reply := execCommand(ctx, "HEXISTS", "h", "a"); reply.Int != 0 {
+		t.Fatal("HGETDEL did not remove field a")
+	}
+	if reply := execCommand(ctx, "HLEN", "h"); reply.Int != 2 {
+		t.Fatalf("Expected 2 fields left, got %d", reply.Int)
+	}
+
+	obj, _ := ctx.Db.Get("h")
+	hash, _ := obj.GetHash()
+
+	reply = execCommand(ctx, "HGETEX", "h", "EX", "100", "FIELDS", "1", "b")
+	assertStrings(t, replyStrings(t, reply), "2")
+	if _, ok := hash.FieldExpireAt([]byte("b")); !ok {
+		t.Fatal("HGETEX EX did not set a field TTL")
+	}
+
+	reply = execCommand(ctx, "HGETEX", "h", "PERSIST", "FIELDS", "1", "b")
+	assertStrings(t, replyStrings(t, reply), "2")
+	if _, ok := hash.FieldExpireAt([]byte("b")); ok {
+		t.Fatal("HGETEX PERSIST did not clear the field TTL")
+	}
+
+	at := time.Now().Add(time.Hour).Unix()
+	reply = execCommand(ctx, "HGETEX", "h", "EXAT", strconv.FormatInt(at, 10), "FIELDS", "1", "b")
+	assertStrings(t, replyStrings(t, reply), "2")
+	if expireAt, ok := hash.FieldExpireAt([]byte("b")); !ok || expireAt != at*1000 {
+		t.Fatalf("HGETEX EXAT set %d, want %d", expireAt, at*1000)
+	}
+
+	atMs := time.Now().Add(time.Hour).UnixMilli()
+	execCommand(ctx, "HGETEX", "h", "PXAT", strconv.FormatInt(atMs, 10), "FIELDS", "1", "c")
+	if expireAt, ok := hash.FieldExpireAt([]byte("c")); !ok || expireAt != atMs {
+		t.Fatalf("HGETEX PXAT set %d, want %d", expireAt, atMs)
+	}
+	execCommand(ctx, "HGETEX", "h", "PERSIST", "FIELDS", "2", "b", "c")
+
+	// 过去的时间点直接删除字段
+	execCommand(ctx, "HSET", "h", "old", "x")
+	reply = execCommand(ctx, "HGETEX", "h", "PXAT", "1", "FIELDS", "1", "old")
+	assertStrings(t, replyStrings(t, reply), "x")
+	if reply := execCommand(ctx, "HEXISTS", "h", "old"); reply.Int != 0 {
+		t.Fatal("HGETEX with a past PXAT should delete the field")
+	}
+
+	reply = execCommand(ctx, "HGETDEL", "h", "FIELDS", "1", "b")
+	assertStrings(t, replyStrings(t, reply), "2")
+	reply = execCommand(ctx, "HGETALL", "h")
+	assertStrings(t, replyStrings(t, reply), "c", "3")
+
+	execCommand(ctx, "HGETDEL", "h", "FIELDS", "1", "c")
+	if ctx.Db.Exists("h") {
+		t.Fatal("Empty hash should be deleted")
+	}
+
+	if reply := execCommand(ctx, "HGETDEL", "h", "FIELDS", "2", "a"); reply.Type != protocol.RESP_ERROR {
+		t.Fatalf("Expected numfields mismatch error, got %v", reply)
+	}
+}
+
+// TestZRangeByScoreInfinity 测试 ±inf 区间与分数恰好为 1e308 的元素
+func TestZRangeByScoreInfinity(t *testing.T) {
+	ctx := newTestContext(t)
+	execCommand(ctx, "ZADD", "z", "-inf", "low", "0", "mid", "1e308", "big", "+inf", "high")
+
+	reply := execCommand(ctx, "ZRANGEBYSCORE", "z", "-inf", "+inf")
+	assertStrings(t, replyStrings(t, reply), "low", "mid", "big", "high")
+
+	reply = execCommand(ctx, "ZRANGEBYSCORE", "z", "(1e308", "+inf")
+	assertStrings(t, replyStrings(t, reply), "high")
+
+	reply = execCommand(ctx, "ZRANGEBYSCORE", "z", "1e308", "(+inf", "WITHSCORES")
+	assertStrings(t, replyStrings(t, reply), "big", "100000000"+strings.Repeat("0", 300))
+
+	if reply := execCommand(ctx, "ZCOUNT", "z", "(-inf", "(+inf"); reply.Int != 2 {
+		t.Fatalf("Expected 2 finite members, got %d", reply.Int)
+	}
+	if reply := execCommand(ctx, "ZSCORE", "z", "high"); reply.Str != "inf" {
+		t.Fatalf("Expected inf, got %q", reply.Str)
+	}
+}
+
+// TestZRevRangeWithScores 测试 ZREVRANGE WITHSCORES 与 ZRANGE WITHSCORES 反转后一致
+func TestZRevRangeWithScores(t *testing.T) {
+	ctx := newTestContext(t)
+	for _, n := range []int{5, 200} {
+		key := "z" + strconv.Itoa(n)
+		for i := 0; i < n; i++ {
+			execCommand(ctx, "ZADD", key, strconv.Itoa(i*3%n), "m"+strconv.Itoa(i))
+		}
+
+		forward := replyStrings(t, execCommand(ctx, "ZRANGE", key, "0", "-1", "WITHSCORES"))
+		want := make([]string, 0, len(forward))
+		for i := len(forward) - 2; i >= 0; i -= 2 {
+			want = append(want, forward[i], forward[i+1])
+		}
+		assertStrings(t, replyStrings(t, execCommand(ctx, "ZREVRANGE", key, "0", "-1", "WITHSCORES")), want...)
+
+		members := replyStrings(t, execCommand(ctx, "ZREVRANGE", key, "0", "1"))
+		assertStrings(t, members, want[0], want[2])
+	}
+
+	if reply := execCommand(ctx, "ZREVRANGE", "z5", "0", "-1", "BAD"); reply.Type != protocol.RESP_ERROR {
+		t.Fatalf("Expected syntax error, got %v", reply)
+	}
+}
+
+// TestHIncrByFloat 测试 HINCRBYFLOAT 小数累加以及非数字字段报错
+func TestHIncrByFloat(t *testing.T) {
+	ctx := newTestContext(t)
+	execCommand(ctx, "HSET", "h", "f", "0")
+
+	if reply := execCommand(ctx, "HINCRBYFLOAT", "h", "f", "1.5"); reply.Str != "1.5" {
+		t.Fatalf("Expected 1.5, got %v", reply)
+	}
+	if reply := execCommand(ctx, "HINCRBYFLOAT", "h", "f", "0.25"); reply.Str != "1.75" {
+		t.Fatalf("Expected 1.75, got %v", reply)
+	}
+	if reply := execCommand(ctx, "HINCRBYFLOAT", "h", "f", "-2"); reply.Str != "-0.25" {
+		t.Fatalf("Expected -0.25, got %v", reply)
+	}
+	if reply := execCommand(ctx, "HGET", "h", "f"); reply.Str != "-0.25" {
+		t.Fatalf("Stored value is %q", reply.Str)
+	}
+	if reply := execCommand(ctx, "HINCRBYFLOAT", "h", "new", "2.5"); reply.Str != "2.5" {
+		t.Fatalf("Expected 2.5 for a new field, got %v", reply)
+	}
+
+	execCommand(ctx, "HSET", "h", "s", "abc")
+	reply := execCommand(ctx, "HINCRBYFLOAT", "h", "s", "1")
+	if reply.Type != protocol.RESP_ERROR || reply.Str != "ERR hash value is not a float" {
+		t.Fatalf("Expected not a float error, got %v", reply)
+	}
+
+	execCommand(ctx, "HINCRBYFLOAT", "fresh", "f", "inf")
+	if ctx.Db.Exists("fresh") {
+		t.Fatal("Failed HINCRBYFLOAT should not leave an empty hash")
+	}
+}
+
+// TestHScan 测试 HSCAN 多次调用遍历大哈希表以及 NOVALUES、MATCH
+func TestHScan(t *testing.T) {
+	ctx := newTestContext(t)
+	const n = 600
+	for i := 0; i < n; i++ {
+		execCommand(ctx, "HSET", "h", "f"+strconv.Itoa(i), "v"+strconv.Itoa(i))
+	}
+
+	seen := make(map[string]string)
+	cursor, calls := "0", 0
+	for {
+		reply := execCommand(ctx, "HSCAN", "h", cursor, "COUNT", "50")
+		items := replyStrings(t, reply.Array[1])
+		for i := 0; i+1 < len(items); i += 2 {
+			seen[items[i]] = items[i+1]
+		}
+		calls++
+		cursor = reply.Array[0].ToString()
+		if cursor == "0" {
+			break
+		}
+	}
+	if calls < 2 {
+		t.Fatalf("Expected multiple HSCAN calls, got %d", calls)
+	}
+	if len(seen) != n {
+		t.Fatalf("HSCAN returned %d fields, want %d", len(seen), n)
+	}
+	for field, value := range seen {
+		if value != "v"+field[1:] {
+			t.Fatalf("Field %s paired with %s", field, value)
+		}
+	}
+
+	reply := execCommand(ctx, "HSCAN", "h", "0", "COUNT", "1000", "MATCH", "f1?", "NOVALUES")
+	fields := replyStrings(t, reply.Array[1])
+	if len(fields) != 10 {
+		t.Fatalf("Expected 10 fields for f1?, got %v", fields)
+	}
+	for _, field := range fields {
+		if !strings.HasPrefix(field, "f1") || len(field) != 3 {
+			t.Fatalf("NOVALUES returned unexpected element %q", field)
+		}
+	}
+
+	// 遍历期间新增和删除字段（桶索引扩容、缩容），一直存在的字段至少返回一次
+	returned := make(map[string]bool)
+	cursor, added := "0", 0
+	for {
+		reply := execCommand(ctx, "HSCAN", "h", cursor, "COUNT", "20", "NOVALUES")
+		for _, field := range replyStrings(t, reply.Array[1]) {
+			returned[field] = true
+		}
+		for i := 0; i < 30; i++ {
+			execCommand(ctx, "HSET", "h", "new"+strconv.Itoa(added), "v")
+			added++
+		}
+		if cursor = reply.Array[0].ToString(); cursor == "0" {
+			break
+		}
+	}
+	for i := 0; i < n; i++ {
+		if !returned["f"+strconv.Itoa(i)] {
+			t.Fatalf("f%d present for the whole scan was not returned", i)
+		}
+	}
+
+	execCommand(ctx, "HSET", "small", "a", "1")
+	assertStrings(t, replyStrings(t, execCommand(ctx, "HSCAN", "small", "0", "NOVALUES").Array[1]), "a")
+	assertStrings(t, replyStrings(t, execCommand(ctx, "HSCAN", "small", "0").Array[1]), "a", "1")
+}
+
+// TestHashFieldExpire 测试字段过期后从 HGETALL 和 HLEN 中消失，其它字段保留
+func TestHashFieldExpire(t *testing.T) {
+	ctx := newTestContext(t)
+	execCommand(ctx, "HSET", "h", "a", "1")
+	execCommand(ctx, "HSET", "h", "b", "2")
+	execCommand(ctx, "HSET", "h", "c", "3")
+
+	reply := execCommand(ctx, "HPEXPIRE", "h", "50", "FIELDS", "2", "a", "missing")
+	if reply.Array[0].Int != 1 || reply.Array[1].Int != -2 {
+		t.Fatalf("HPEXPIRE returned %v", reply.Array)
+	}
+	if reply := execCommand(ctx, "HEXPIRE", "h", "100", "NX", "FIELDS", "1", "a"); reply.Array[0].Int != 0 {
+		t.Fatalf("HEXPIRE NX on a volatile field should return 0, got %d", reply.Array[0].Int)
+	}
+	if reply := execCommand(ctx, "HEXPIRE", "h", "100", "GT", "FIELDS", "1", "b"); reply.Array[0].Int != 0 {
+		t.Fatalf("HEXPIRE GT on a persistent field should return 0, got %d", reply.Array[0].Int)
+	}
+	execCommand(ctx, "HEXPIRE", "h", "100", "FIELDS", "1", "b")
+
+	reply = execCommand(ctx, "HTTL", "h", "FIELDS", "3", "b", "c", "missing")
+	if reply.Array[0].Int != 100 || reply.Array[1].Int != -1 || reply.Array[2].Int != -2 {
+		t.Fatalf("HTTL returned %v", reply.Array)
+	}
+	reply = execCommand(ctx, "HPERSIST", "h", "FIELDS", "2", "b", "c")
+	if reply.Array[0].Int != 1 || reply.Array[1].Int != -1 {
+		t.Fatalf("HPERSIST returned %v", reply.Array)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if reply := execCommand(ctx, "HLEN", "h"); reply.Int != 2 {
+		t.Fatalf("Expected 2 fields after expiry, got %d", reply.Int)
+	}
+	assertStrings(t, replyStrings(t, execCommand(ctx, "HGETALL", "h")), "b", "2", "c", "3")
+	if reply := execCommand(ctx, "HGET", "h", "a"); !reply.Null {
+		t.Fatalf("Expired field still readable: %v", reply)
+	}
+
+	if reply := execCommand(ctx, "HEXPIREAT", "h", "1", "FIELDS", "1", "b"); reply.Array[0].Int != 2 {
+		t.Fatalf("HEXPIREAT in the past should delete the field, got %d", reply.Array[0].Int)
+	}
+}
+
+// TestHashFieldExpireDeletesKey 测试所有字段过期后键被删除：查找时惰性删除，主动过期抽样删除
+func TestHashFieldExpireDeletesKey(t *testing.T) {
+	ctx := newTestContext(t)
+	execCommand(ctx, "HSET", "lazy", "a", "1", "b", "2")
+	execCommand(ctx, "HSET", "active", "a", "1")
+	execCommand(ctx, "HSET", "partial", "a", "1", "b", "2")
+	execCommand(ctx, "HPEXPIRE", "lazy", "50", "FIELDS", "2", "a", "b")
+	execCommand(ctx, "HPEXPIRE", "active", "50", "FIELDS", "1", "a")
+	execCommand(ctx, "HPEXPIRE", "partial", "50", "FIELDS", "1", "a")
+	time.Sleep(100 * time.Millisecond)
+
+	if reply := execCommand(ctx, "EXISTS", "lazy"); reply.Int != 0 {
+		t.Fatalf("Expected the hash to be deleted after its last field expired, EXISTS returned %d", reply.Int)
+	}
+	if reply := execCommand(ctx, "TYPE", "lazy"); reply.Str != "none" {
+		t.Fatalf("Expected TYPE none, got %q", reply.Str)
+	}
+
+	// 主动过期删除字段全部过期的键，只删除部分字段的键保留
+	if _, expired := ctx.Db.ActiveExpireCycle(20); expired != 1 {
+		t.Fatalf("Expected active expiry to delete one key, deleted %d", expired)
+	}
+	if reply := execCommand(ctx, "DBSIZE"); reply.Int != 1 {
+		t.Fatalf("Expected only the partially expired hash to remain, DBSIZE returned %d", reply.Int)
+	}
+	assertStrings(t, replyStrings(t, execCommand(ctx, "HGETALL", "partial")), "b", "2")
+}
+
+// TestHashFieldOverwriteClearsTTL 测试 HSET 覆盖字段时移除字段原来的过期时间
+func TestHashFieldOverwriteClearsTTL(t *testing.T) {
+	ctx := newTestContext(t)
+	execCommand(ctx, "HSET", "h", "f", "old")
+	execCommand(ctx, "HPEXPIRE", "h", "50", "FIELDS", "1", "f")
+	execCommand(ctx, "HSET", "h", "f", "new")
+
+	if reply := execCommand(ctx, "HTTL", "h", "FIELDS", "1", "f"); reply.Array[0].Int != -1 {
+		t.Fatalf("Expected HSET to clear the field TTL, got %d", reply.Array[0].Int)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if reply := execCommand(ctx, "HGET", "h", "f"); reply.Str != "new" {
+		t.Fatalf("Expected the overwritten field to survive the old TTL, got %v", reply)
+	}
+}
+
+// TestHashFieldIncrKeepsTTL 测试 HINCRBY/HINCRBYFLOAT 保留字段的过期时间，
+// HINCRBYFLOAT 传播的 HSET 之后恢复字段的过期时间
+func TestHashFieldIncrKeepsTTL(t *testing.T) {
+	ctx := newTestContext(t)
+	execCommand(ctx, "HSET", "h", "n", "1", "x", "1.5")
+	execCommand(ctx, "HEXPIRE", "h", "100", "FIELDS", "2", "n", "x")
+
+	if reply := execCommand(ctx, "HINCRBY", "h", "n", "2"); reply.Int != 3 {
+		t.Fatalf("Expected 3, got %v", reply)
+	}
+	ctx.propagated = nil
+	if reply := execCommand(ctx, "HINCRBYFLOAT", "h", "x", "1"); reply.Str != "2.5" {
+		t.Fatalf("Expected 2.5, got %v", reply)
+	}
+	ttls := execCommand(ctx, "HTTL", "h", "FIELDS", "2", "n", "x")
+	for i, ttl := range ttls.Array {
+		if ttl.Int <= 0 || ttl.Int > 100 {
+			t.Fatalf("Expected field %d to keep its TTL, got %d", i, ttl.Int)
+		}
+	}
+
+	obj, _ := ctx.Db.Get("h")
+	hash, _ := obj.GetHash()
+	expireAt, _ := hash.FieldExpireAt([]byte("x"))
+	var propagated []string
+	for _, cmd := range ctx.propagated {
+		argv := make([]string, 0, len(cmd.GetArray()))
+		for _, arg := range cmd.GetArray() {
+			argv = append(argv, arg.ToString())
+		}
+		propagated = append(propagated, strings.Join(argv, " "))
+	}
+	assertStrings(t, propagated, "HSET h x 2.5", "HPEXPIREAT h "+strconv.FormatInt(expireAt, 10)+" FIELDS 1 x")
+}
+
+// TestCompactEncodingForNewCollections 测试新建集合立即使用紧凑编码，清空后键被删除
+func TestCompactEncodingForNewCollections(t *testing.T) {
+	ctx := newTestContext(t)
+
+	execCommand(ctx, "SADD", "s", "42")
+	if reply := execCommand(ctx, "OBJECT", "ENCODING", "s"); reply.Str != "intset" {
+		t.Fatalf("Expected intset after SADD of an integer, got %q", reply.Str)
+	}
+
+	cases := []struct {
+		add, remove []string
+		encoding    string
+	}{
+		{[]string{"SADD", "s", "7"}, []string{"SREM", "s", "42", "7"}, "intset"},
+		{[]string{"ZADD", "z", "1", "a"}, []string{"ZREM", "z", "a"}, "listpack"},
+		{[]string{"HSET", "h", "f", "v"}, []string{"HDEL", "h", "f"}, "listpack"},
+		{[]string{"RPUSH", "l", "a"}, []string{"LPOP", "l"}, "listpack"},
+	}
+	for _, c := range cases {
+		key := c.add[1]
+		execCommand(ctx, c.add...)
+		if reply := execCommand(ctx, "OBJECT", "ENCODING", key); reply.Str != c.encoding {
+			t.Fatalf("%s: expected %s, got %q", c.add[0], c.encoding, reply.Str)
+		}
+
+		execCommand(ctx, c.remove...)
+		if ctx.Db.Exists(key) {
+			t.Fatalf("%s should delete the emptied key %s", c.remove[0], key)
+		}
+
+		execCommand(ctx, c.add...)
+		if reply := execCommand(ctx, "OBJECT", "ENCODING", key); reply.Str != c.encoding {
+			t.Fatalf("%s after re-creation: expected %s, got %q", c.add[0], c.encoding, reply.Str)
+		}
+	}
+
+	// 字段过期清空哈希表（HEXPIRE 或 HGETEX 设置的 TTL）时同样删除键
+	execCommand(ctx, "FLUSHDB")
+	execCommand(ctx, "HSET", "h1", "f", "v")
+	execCommand(ctx, "HSET", "h2", "f", "v")
+	execCommand(ctx, "HPEXPIRE", "h1", "50", "FIELDS", "1", "f")
+	execCommand(ctx, "HGETEX", "h2", "PX", "50", "FIELDS", "1", "f")
+	time.Sleep(100 * time.Millisecond)
+	for _, key := range []string{"h1", "h2"} {
+		if reply := execCommand(ctx, "EXISTS", key); reply.Int != 0 {
+			t.Fatalf("Expected %s to be deleted after its fields expired, EXISTS returned %d", key, reply.Int)
+		}
+	}
+	if reply := execCommand(ctx, "DBSIZE"); reply.Int != 0 {
+		t.Fatalf("Expected an empty database, DBSIZE returned %d", reply.Int)
+	}
+}
+
+// TestHSetNx 测试 HSETNX 在字段已存在时不修改 Hash，在新键上只创建一个字段
+func TestHSetNx(t *testing.T) {
+	ctx := newTestContext(t)
+
+	if reply := execCommand(ctx, "HSETNX", "h", "f", "1"); reply.Int != 1 {
+		t.Fatalf("HSETNX on missing key should return 1, got %d", reply.Int)
+	}
+	if reply := execCommand(ctx, "HLEN", "h"); reply.Int != 1 {
+		t.Fatalf("Expected exactly 1 field, got %d", reply.Int)
+	}
+
+	if reply := execCommand(ctx, "HSETNX", "h", "f", "2"); reply.Int != 0 {
+		t.Fatalf("HSETNX on existing field should return 0, got %d", reply.Int)
+	}
+	if reply := execCommand(ctx, "HLEN", "h"); reply.Int != 1 {
+		t.Fatalf("HLEN changed after failed HSETNX: %d", reply.Int)
+	}
+	if reply := execCommand(ctx, "HGET", "h", "f"); reply.Str != "1" {
+		t.Fatalf("Value changed after failed HSETNX: %q", reply.Str)
+	}
+
+	execCommand(ctx, "SET", "s", "v")
+	if reply := execCommand(ctx, "HSETNX", "s", "f", "1"); reply.Type != protocol.RESP_ERROR {
+		t.Fatalf("Expected wrong type error, got %v", reply)
+	}
+}
+
+// TestPopCount 测试 LPOP/RPOP/SPOP 的 count 参数：负数报错，0 返回空数组
+func TestPopCount(t *testing.T) {
+	ctx := newTestContext(t)
+	execCommand(ctx, "RPUSH", "l", "a", "b", "c", "d")
+
+	if reply := execCommand(ctx, "LPOP", "l", "-1"); reply.Type != protocol.RESP_ERROR ||
+		reply.Str != "ERR value is out of range, must be positive" {
+		t.Fatalf("Expected out of range error, got %v", reply)
+	}
+	reply := execCommand(ctx, "LPOP", "l", "0")
+	if reply.Type != protocol.RESP_ARRAY || len(reply.Array) != 0 {
+		t.Fatalf("Expected empty array, got %v", reply)
+	}
+
+	assertStrings(t, replyStrings(t, execCommand(ctx, "LPOP", "l", "2")), "a", "b")
+	assertStrings(t, replyStrings(t, execCommand(ctx, "RPOP", "l", "1")), "d")
+	assertStrings(t, replyStrings(t, execCommand(ctx, "RPOP", "l", "5")), "c")
+	if ctx.Db.Exists("l") {
+		t.Fatal("Empty list should be deleted")
+	}
+
+	execCommand(ctx, "SADD", "s", "a", "b", "c")
+	if reply := execCommand(ctx, "SPOP", "s", "-1"); reply.Type != protocol.RESP_ERROR {
+		t.Fatalf("Expected error for negative SPOP count, got %v", reply)
+	}
+	if reply := execCommand(ctx, "SPOP", "s", "0"); reply.Type != protocol.RESP_ARRAY || len(reply.Array) != 0 {
+		t.Fatalf("Expected empty array, got %v", reply)
+	}
+	if reply := execCommand(ctx, "SPOP", "s", "2"); len(reply.Array) != 2 {
+		t.Fatalf("Expected 2 popped members, got %v", reply.Array)
+	}
+	if reply := execCommand(ctx, "SCARD", "s"); reply.Int != 1 {
+		t.Fatalf("Expected 1 member left, got %d", reply.Int)
+	}
+}
+
+// TestKeysPattern 测试 KEYS 和 SCAN MATCH 的 glob 匹配
+func TestKeysPattern(t *testing.T) {
+	ctx := newTestContext(t)
+	for _, key := range []string{"hello", "hallo", "hxllo", "hllo", "heeeello", "user:1", "user:2", "other"} {
+		execCommand(ctx, "SET", key, "v")
+	}
+
+	keys := replyStrings(t, execCommand(ctx, "KEYS", "h[a-e]llo"))
+	sort.Strings(keys)
+	assertStrings(t, keys, "hallo", "hello")
+
+	keys = replyStrings(t, execCommand(ctx, "KEYS", "h*llo"))
+	sort.Strings(keys)
+	assertStrings(t, keys, "hallo", "heeeello", "hello", "hllo", "hxllo")
+
+	reply := execCommand(ctx, "SCAN", "0", "match", "user:?", "COUNT", "100")
+	keys = replyStrings(t, reply.Array[1])
+	sort.Strings(keys)
+	assertStrings(t, keys, "user:1", "user:2")
+}
+
+// TestTypeExpiredKey 测试已过期的键 TYPE 返回 none 并被惰性删除
+func TestTypeExpiredKey(t *testing.T) {
+	ctx := newTestContext(t)
+	execCommand(ctx, "HSET", "h", "f", "v")
+	if reply := execCommand(ctx, "TYPE", "h"); reply.Str != "hash" {
+		t.Fatalf("Expected hash, got %q", reply.Str)
+	}
+
+	execCommand(ctx, "PEXPIRE", "h", "1")
+	time.Sleep(5 * time.Millisecond)
+
+	if reply := execCommand(ctx, "TYPE", "h"); reply.Str != "none" {
+		t.Fatalf("Expected none for expired key, got %q", reply.Str)
+	}
+	if ctx.Db.ExpiresCount() != 0 {
+		t.Fatal("TYPE should remove the expired key")
+	}
+}
+
+// TestScanStableCursor 测试遍历期间并发插入、删除其它键时，一直存在的键都会被返回
+func TestScanStableCursor(t *testing.T) {
+	ctx := newTestContext(t)
+	const n = 1000
+	for i := 0; i < n; i++ {
+		execCommand(ctx, "SET", "key:"+strconv.Itoa(i), "v")
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			key := "extra:" + strconv.Itoa(i)
+			ctx.Db.Set(key, storage.NewStringObject([]byte("v")))
+			if i%2 == 0 {
+				ctx.Db.Del(key)
+			}
+		}
+	}()
+
+	seen := make(map[string]int)
+	cursor := "0"
+	for {
+		reply := execCommand(ctx, "SCAN", cursor, "COUNT", "7")
+		if reply.Type == protocol.RESP_ERROR {
+			t.Fatalf("SCAN failed: %s", reply.Str)
+		}
+		for _, key := range replyStrings(t, reply.Array[1]) {
+			seen[key]++
+		}
+		cursor = reply.Array[0].Str
+		if cursor == "0" {
+			break
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	for i := 0; i < n; i++ {
+		key := "key:" + strconv.Itoa(i)
+		if seen[key] == 0 {
+			t.Fatalf("Key %s present for the whole scan was not returned", key)
+		}
+	}
+}
+
+// TestScanType 测试 SCAN 的 TYPE 过滤和参数校验
+func TestScanType(t *testing.T) {
+	ctx := newTestContext(t)
+	execCommand(ctx, "SET", "s1", "v")
+	execCommand(ctx, "SET", "s2", "v")
+	execCommand(ctx, "RPUSH", "l1", "a")
+	execCommand(ctx, "HSET", "h1", "f", "v")
+
+	reply := execCommand(ctx, "SCAN", "0", "TYPE", "string", "COUNT", "100")
+	keys := replyStrings(t, reply.Array[1])
+	sort.Strings(keys)
+	assertStrings(t, keys, "s1", "s2")
+
+	reply = execCommand(ctx, "SCAN", "0", "type", "LIST", "COUNT", "100")
+	assertStrings(t, replyStrings(t, reply.Array[1]), "l1")
+
+	if reply := execCommand(ctx, "SCAN", "abc"); reply.Type != protocol.RESP_ERROR {
+		t.Fatalf("Expected invalid cursor error, got %v", reply)
+	}
+	if reply := execCommand(ctx, "SCAN", "0", "COUNT"); reply.Type != protocol.RESP_ERROR {
+		t.Fatalf("Expected syntax error, got %v", reply)
+	}
+	if reply := execCommand(ctx, "HSCAN", "h1", "0", "TYPE", "string"); reply.Type != protocol.RESP_ERROR {
+		t.Fatalf("HSCAN should not accept TYPE, got %v", reply)
+	}
+}
+
+// TestCopy 测试 COPY 深拷贝、过期时间、DB 和 REPLACE 选项
+func TestCopy(t *testing.T) {
+	ctx := newTestContext(t)
+
+	// 修改副本不影响原列表
+	execCommand(ctx, "RPUSH", "list", "a", "b", "c")
+	if reply := execCommand(ctx, "COPY", "list", "list2"); reply.Int != 1 {
+		t.Fatalf("COPY should return 1, got %v", reply)
+	}
+	execCommand(ctx, "RPUSH", "list2", "d")
+	execCommand(ctx, "LPOP", "list2")
+	assertStrings(t, replyStrings(t, execCommand(ctx, "LRANGE", "list", "0", "-1")), "a", "b", "c")
+	assertStrings(t, replyStrings(t, execCommand(ctx, "LRANGE", "list2", "0", "-1")), "b", "c", "d")
+
+	// 修改副本不影响原 Hash，过期时间一并复制
+	execCommand(ctx, "HSET", "hash", "f1", "v1")
+	execCommand(ctx, "HSET", "hash", "f2", "v2")
+	execCommand(ctx, "EXPIRE", "hash", "100")
+	execCommand(ctx, "COPY", "hash", "hash2")
+	execCommand(ctx, "HSET", "hash2", "f1", "changed")
+	execCommand(ctx, "HDEL", "hash2", "f2")
+	if reply := execCommand(ctx, "HGET", "hash", "f1"); reply.Str != "v1" {
+		t.Fatalf("Source hash changed: %q", reply.Str)
+	}
+	if reply := execCommand(ctx, "HLEN", "hash"); reply.Int != 2 {
+		t.Fatalf("Source hash lost fields: %d", reply.Int)
+	}
+	if reply := execCommand(ctx, "TTL", "hash2"); reply.Int <= 0 {
+		t.Fatalf("Copy should keep the TTL, got %d", reply.Int)
+	}
+
+	// 目标已存在：没有 REPLACE 返回 0，有 REPLACE 覆盖
+	execCommand(ctx, "SET", "dst", "old")
+	execCommand(ctx, "SET", "src", "new")
+	if reply := execCommand(ctx, "COPY", "src", "dst"); reply.Int != 0 {
+		t.Fatalf("COPY to existing key should return 0, got %d", reply.Int)
+	}
+	if reply := execCommand(ctx, "COPY", "src", "dst", "REPLACE"); reply.Int != 1 {
+		t.Fatalf("COPY REPLACE should return 1, got %d", reply.Int)
+	}
+	if reply := execCommand(ctx, "GET", "dst"); reply.Str != "new" {
+		t.Fatalf("Expected new, got %q", reply.Str)
+	}
+
+	// 复制到其它数据库
+	if reply := execCommand(ctx, "COPY", "src", "src", "DB", "1"); reply.Int != 1 {
+		t.Fatalf("COPY to DB 1 should return 1, got %v", reply)
+	}
+	db1, _ := ctx.Server.GetRedisServer().GetDb(1)
+	obj, err := db1.Get("src")
+	if err != nil {
+		t.Fatal("Key not copied to DB 1")
+	}
+	if val, _ := obj.GetStringValue(); string(val) != "new" {
+		t.Fatalf("Expected new in DB 1, got %q", val)
+	}
+
+	if reply := execCommand(ctx, "COPY", "src", "src"); reply.Type != protocol.RESP_ERROR {
+		t.Fatalf("Expected same object error, got %v", reply)
+	}
+	if reply := execCommand(ctx, "COPY", "src", "x", "DB", "99"); reply.Type != protocol.RESP_ERROR {
+		t.Fatalf("Expected DB out of range error, got %v", reply)
+	}
+	if reply := execCommand(ctx, "COPY", "missing", "x"); reply.Int != 0 {
+		t.Fatalf("COPY of missing key should return 0, got %d", reply.Int)
+	}
+}
+
+// TestGetEx 测试 GETEX 不带选项时不修改过期时间，PERSIST/EX/EXAT 修改过期时间
+func TestCopyDbTTL(t *testing.T) {
+	ctx := newTestContext(t)
+
+	execCommand(ctx, "SET", "session", "data")
+	execCommand(ctx, "EXPIRE", "session", "100")
+	if reply := execCommand(ctx, "COPY", "session", "session", "DB", "2"); reply.Int != 1 {
+		t.Fatalf("COPY to DB 2 should return 1, got %v", reply)
+	}
+
+	// 源键不变，副本带有相同的剩余 TTL
+	if reply := execCommand(ctx, "GET", "session"); reply.Str != "data" {
+		t.Fatalf("Source changed: %q", reply.Str)
+	}
+	srcTTL := execCommand(ctx, "TTL", "session").Int
+	if srcTTL <= 0 || srcTTL > 100 {
+		t.Fatalf("Source TTL changed: %d", srcTTL)
+	}
+	ctx.Db, _ = ctx.Server.GetRedisServer().GetDb(2)
+	if reply := execCommand(ctx, "GET", "session"); reply.Str != "data" {
+		t.Fatalf("Expected data in DB 2, got %q", reply.Str)
+	}
+	if reply := execCommand(ctx, "TTL", "session"); reply.Int != srcTTL {
+		t.Fatalf("Expected copy TTL %d, got %d", srcTTL, reply.Int)
+	}
+
+	// REPLACE 覆盖带 TTL 的目标键时，目标的旧 TTL 不会保留
+	execCommand(ctx, "SET", "plain", "v")
+	execCommand(ctx, "SET", "target", "old")
+	execCommand(ctx, "EXPIRE", "target", "100")
+	execCommand(ctx, "COPY", "plain", "target", "REPLACE")
+	if reply := execCommand(ctx, "TTL", "target"); reply.Int != -1 {
+		t.Fatalf("Expected no TTL on replaced key, got %d", reply.Int)
+	}
+}
+
+func TestGetEx(t *testing.T) {
+	ctx := newTestContext(t)
+	execCommand(ctx, "SET", "k", "v")
+	execCommand(ctx, "EXPIRE", "k", "100")
+
+	if reply := execCommand(ctx, "GETEX", "k"); reply.Str != "v" {
+		t.Fatalf("Expected v, got %v", reply)
+	}
+	if reply := execCommand(ctx, "TTL", "k"); reply.Int <= 0 {
+		t.Fatalf("GETEX without options should keep the TTL, got %d", reply.Int)
+	}
+
+	if reply := execCommand(ctx, "GETEX", "k", "PERSIST"); reply.Str != "v" {
+		t.Fatalf("Expected v, got %v", reply)
+	}
+	if reply := execCommand(ctx, "TTL", "k"); reply.Int != -1 {
+		t.Fatalf("GETEX PERSIST should clear the TTL, got %d", reply.Int)
+	}
+
+	execCommand(ctx, "GETEX", "k", "EX", "50")
+	if reply := execCommand(ctx, "TTL", "k"); reply.Int <= 0 || reply.Int > 50 {
+		t.Fatalf("GETEX EX 50 set TTL %d", reply.Int)
+	}
+
+	execCommand(ctx, "GETEX", "k", "EXAT", "1")
+	if reply := execCommand(ctx, "GET", "k"); !reply.Null {
+		t.Fatalf("GETEX with a past EXAT should expire the key, got %v", reply)
+	}
+
+	if reply := execCommand(ctx, "GETEX", "missing"); !reply.Null {
+		t.Fatalf("Expected nil for missing key, got %v", reply)
+	}
+	execCommand(ctx, "SET", "k", "v")
+	for _, args := range [][]string{
+		{"GETEX", "k", "EX", "0"},
+		{"GETEX", "k", "EX"},
+		{"GETEX", "k", "PERSIST", "EX", "10"},
+		{"GETEX", "k", "BOGUS"},
+	} {
+		if reply := execCommand(ctx, args...); reply.Type != protocol.RESP_ERROR {
+			t.Fatalf("%v: expected error, got %v", args, reply)
+		}
+	}
+	execCommand(ctx, "RPUSH", "l", "a")
+	if reply := execCommand(ctx, "GETEX", "l"); reply.Type != protocol.RESP_ERROR {
+		t.Fatalf("Expected wrong type error, got %v", reply)
+	}
+}
+
+// TestUnlink 测试 UNLINK 立即从键空间删除，大对象在后台释放
+func TestUnlink(t *testing.T) {
+	ctx := newTestContext(t)
+	execCommand(ctx, "SET", "small", "v")
+	for i := 0; i < 1000; i++ {
+		execCommand(ctx, "HSET", "big", "field:"+strconv.Itoa(i), "v")
+	}
+
+	if reply := execCommand(ctx, "UNLINK", "small", "big", "missing"); reply.Int != 2 {
+		t.Fatalf("UNLINK should remove 2 keys, got %d", reply.Int)
+	}
+	if size := ctx.Db.DBSize(); size != 0 {
+		t.Fatalf("Keys should be removed immediately, DBSize is %d", size)
+	}
+
+	// 只有大对象进入后台释放
+	deadline := time.Now().Add(time.Second)
+	for ctx.Server.lazyFree.Freed() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Large value not reclaimed in background (freed=%d, pending=%d)",
+				ctx.Server.lazyFree.Freed(), ctx.Server.lazyFree.Pending())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if pending := ctx.Server.lazyFree.Pending(); pending != 0 {
+		t.Fatalf("Expected empty lazyfree queue, got %d pending", pending)
+	}
+}
+
+// TestTouchIdleTime 测试 TOUCH 返回存在的键数并重置 OBJECT IDLETIME，以及命中统计
+func TestTouchIdleTime(t *testing.T) {
+	ctx := newTestContext(t)
+	execCommand(ctx, "SET", "a", "1")
+	execCommand(ctx, "SET", "b", "2")
+
+	obj, _ := ctx.Db.Peek("a")
+	obj.SetLastAccess(time.Now().Add(-10 * time.Second))
+
+	// OBJECT IDLETIME 本身不更新访问时间
+	for i := 0; i < 2; i++ {
+		if reply := execCommand(ctx, "OBJECT", "IDLETIME", "a"); reply.Int < 10 {
+			t.Fatalf("Expected idle time >= 10, got %d", reply.Int)
+		}
+	}
+
+	hits, misses := ctx.Server.stats.KeyspaceHits, ctx.Server.stats.KeyspaceMisses
+	if reply := execCommand(ctx, "TOUCH", "a", "b", "missing"); reply.Int != 2 {
+		t.Fatalf("TOUCH should count 2 existing keys, got %d", reply.Int)
+	}
+	if reply := execCommand(ctx, "OBJECT", "IDLETIME", "a"); reply.Int != 0 {
+		t.Fatalf("Expected idle time 0 after TOUCH, got %d", reply.Int)
+	}
+	if ctx.Server.stats.KeyspaceHits != hits+2 || ctx.Server.stats.KeyspaceMisses != misses+1 {
+		t.Fatalf("Expected 2 hits and 1 miss, got %d and %d",
+			ctx.Server.stats.KeyspaceHits-hits, ctx.Server.stats.KeyspaceMisses-misses)
+	}
+
+	if reply := execCommand(ctx, "OBJECT", "IDLETIME", "missing"); reply.Type != protocol.RESP_ERROR {
+		t.Fatalf("Expected no such key error, got %v", reply)
+	}
+}
+
+func TestMemoryUsageSamples(t *testing.T) {
+	ctx := newTestContext(t)
+
+	for i := 0; i < 1000; i++ {
+		execCommand(ctx, "HSET", "big", "field:"+strconv.Itoa(1000+i), "value:"+strconv.Itoa(1000+i))
+	}
+	if reply := execCommand(ctx, "OBJECT", "ENCODING", "big"); reply.Str != "hashtable" {
+		t.Fatalf("Expected hashtable encoding, got %q", reply.Str)
+	}
+
+	exact := execCommand(ctx, "MEMORY", "USAGE", "big", "SAMPLES", "0")
+	estimate := execCommand(ctx, "MEMORY", "USAGE", "big", "SAMPLES", "5")
+	if exact.Type != protocol.RESP_INTEGER || estimate.Type != protocol.RESP_INTEGER {
+		t.Fatalf("Expected integer replies, got %v and %v", exact, estimate)
+	}
+	if exact.Int <= 1000*20 {
+		t.Fatalf("Exact usage %d is too small for 1000 fields", exact.Int)
+	}
+	diff := exact.Int - estimate.Int
+	if diff < 0 {
+		diff = -diff
+	}
+	if diff*10 > exact.Int {
+		t.Fatalf("Estimate %d is not within 10%% of exact %d", estimate.Int, exact.Int)
+	}
+	if reply := execCommand(ctx, "MEMORY", "USAGE", "big"); reply.Int != estimate.Int {
+		t.Fatalf("Expected default SAMPLES 5 to match, got %d and %d", reply.Int, estimate.Int)
+	}
+
+	if reply := execCommand(ctx, "MEMORY", "USAGE", "missing"); !reply.Null {
+		t.Fatalf("Expected nil for missing key, got %v", reply)
+	}
+	if reply := execCommand(ctx, "MEMORY", "USAGE", "big", "SAMPLES", "-1"); reply.Type != protocol.RESP_ERROR {
+		t.Fatalf("Expected error for negative SAMPLES, got %v", reply)
+	}
+}
+
+func TestRandomKey(t *testing.T) {
+	ctx := newTestContext(t)
+
+	if reply := execCommand(ctx, "RANDOMKEY"); !reply.Null {
+		t.Fatalf("Expected nil on empty database, got %v", reply)
+	}
+
+	keys := []string{"a", "b", "c", "d", "e"}
+	for _, key := range keys {
+		execCommand(ctx, "SET", key, "v")
+	}
+	// 逻辑上已过期但尚未被删除的键不能被返回
+	execCommand(ctx, "SET", "expired", "v")
+	ctx.Db.ExpireAt("expired", time.Now().Unix()-1)
+
+	seen := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		reply := execCommand(ctx, "RANDOMKEY")
+		if reply.Null || reply.Str == "expired" {
+			t.Fatalf("Unexpected RANDOMKEY reply: %v", reply)
+		}
+		seen[reply.Str]++
+	}
+	for _, key := range keys {
+		if seen[key] < 100 {
+			t.Fatalf("Key %q returned only %d times out of 1000: %v", key, seen[key], seen)
+		}
+	}
+
+	// 只剩过期键时返回 nil
+	execCommand(ctx, "FLUSHDB")
+	execCommand(ctx, "SET", "expired", "v")
+	ctx.Db.ExpireAt("expired", time.Now().Unix()-1)
+	if reply := execCommand(ctx, "RANDOMKEY"); !reply.Null {
+		t.Fatalf("Expected nil when only expired keys remain, got %v", reply)
+	}
+}
+
+func TestObjectFreq(t *testing.T) {
+	ctx := newTestContext(t)
+
+	execCommand(ctx, "SET", "k", "v")
+	if reply := execCommand(ctx, "OBJECT", "FREQ", "k"); reply.Type != protocol.RESP_ERROR {
+		t.Fatalf("Expected error without an LFU policy, got %v", reply)
+	}
+
+	execCommand(ctx, "CONFIG", "SET", "maxmemory-policy", "allkeys-lfu")
+	initial := execCommand(ctx, "OBJECT", "FREQ", "k").Int
+	for i := 0; i < 100; i++ {
+		execCommand(ctx, "GET", "k")
+	}
+	if reply := execCommand(ctx, "OBJECT", "FREQ", "k"); reply.Int <= initial {
+		t.Fatalf("Expected FREQ to grow above %d after repeated GETs, got %d", initial, reply.Int)
+	}
+	if reply := execCommand(ctx, "OBJECT", "FREQ", "missing"); reply.Type != protocol.RESP_ERROR {
+		t.Fatalf("Expected no such key error, got %v", reply)
+	}
+}
+
+func TestObjectRefCountSharedIntegers(t *testing.T) {
+	ctx := newTestContext(t)
+
+	execCommand(ctx, "SET", "a", "100")
+	execCommand(ctx, "SET", "b", "100")
+	if reply := execCommand(ctx, "OBJECT", "REFCOUNT", "a"); reply.Int != storage.OBJ_SHARED_REFCOUNT {
+		t.Fatalf("Expected shared integer refcount %d, got %d", storage.OBJ_SHARED_REFCOUNT, reply.Int)
+	}
+	if reply := execCommand(ctx, "OBJECT", "ENCODING", "a"); reply.Str != "int" {
+		t.Fatalf("Expected int encoding, got %q", reply.Str)
+	}
+
+	// 超出共享范围或非规范形式的整数、普通字符串都是独立对象
+	for _, value := range []string{"10000", "007", "hello"} {
+		execCommand(ctx, "SET", "c", value)
+		if reply := execCommand(ctx, "OBJECT", "REFCOUNT", "c"); reply.Int != 1 {
+			t.Fatalf("Expected refcount 1 for %q, got %d", value, reply.Int)
+		}
+	}
+
+	// RENAME 转移引用，不改变引用计数
+	execCommand(ctx, "RENAME", "c", "d")
+	if reply := execCommand(ctx, "OBJECT", "REFCOUNT", "d"); reply.Int != 1 {
+		t.Fatalf("Expected refcount 1 after RENAME, got %d", reply.Int)
+	}
+
+	// LRU/LFU 淘汰策略下每个键需要独立的访问信息，不使用共享整数
+	execCommand(ctx, "CONFIG", "SET", "maxmemory", "100mb")
+	execCommand(ctx, "CONFIG", "SET", "maxmemory-policy", "allkeys-lru")
+	execCommand(ctx, "SET", "e", "100")
+	if reply := execCommand(ctx, "OBJECT", "REFCOUNT", "e"); reply.Int != 1 {
+		t.Fatalf("Expected unshared integer under LRU policy, got %d", reply.Int)
+	}
+}
+
+// TestSharedIntegersConcurrentClients 测试多个连接在不同数据库中并发写入和删除共享整数时，
+// 共享对象的引用计数保持不变
+func TestSharedIntegersConcurrentClients(t *testing.T) {
+	srv := NewServer(":0", 16)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		db, _ := srv.redisServer.GetDb(i)
+		ctx := &CommandContext{Server: srv, Db: db}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				execCommand(ctx, "SET", "k", "100")
+				execCommand(ctx, "DEL", "k")
+			}
+			execCommand(ctx, "SET", "k", "100")
+		}()
+	}
+	wg.Wait()
+
+	db, _ := srv.redisServer.GetDb(0)
+	ctx := &CommandContext{Server: srv, Db: db}
+	if reply := execCommand(ctx, "OBJECT", "REFCOUNT", "k"); reply.Int != storage.OBJ_SHARED_REFCOUNT {
+		t.Fatalf("Expected refcount %d, got %d", storage.OBJ_SHARED_REFCOUNT, reply.Int)
+	}
+
+	// 原地修改共享整数的键先复制出独立的对象
+	execCommand(ctx, "APPEND", "k", "0")
+	if reply := execCommand(ctx, "OBJECT", "REFCOUNT", "k"); reply.Int != 1 {
+		t.Fatalf("Expected APPEND to unshare the value, got refcount %d", reply.Int)
+	}
+	if val, _ := srv.sharedObjects.GetSharedInteger(100).GetStringValue(); string(val) != "100" {
+		t.Fatalf("Expected the shared integer to be unchanged, got %q", val)
+	}
+}
+
+func TestWaitNoReplicas(t *testing.T) {
+	ctx := newTestContext(t)
+
+	start := time.Now()
+	if reply := execCommand(ctx, "WAIT", "0", "0"); reply.Type != protocol.RESP_INTEGER || reply.Int != 0 {
+		t.Fatalf("Expected WAIT 0 0 to return 0, got %v", reply)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("WAIT 0 0 should not block, took %v", elapsed)
+	}
+
+	// 没有从节点时等到超时，返回 0
+	start = time.Now()
+	if reply := execCommand(ctx, "WAIT", "1", "50"); reply.Int != 0 {
+		t.Fatalf("Expected WAIT 1 50 to return 0, got %v", reply)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("WAIT should block until the timeout, took %v", elapsed)
+	}
+
+	if reply := execCommand(ctx, "WAIT", "0", "-1"); reply.Type != protocol.RESP_ERROR {
+		t.Fatalf("Expected error for negative timeout, got %v", reply)
+	}
+
+	info := execCommand(ctx, "INFO", "replication").ToString()
+	if !strings.Contains(info, "connected_slaves:0") {
+		t.Fatalf("Expected connected_slaves in INFO replication, got %q", info)
+	}
+}
+
+func TestDebugDumpDb(t *testing.T) {
+	ctx := newTestContext(t)
+
+	execCommand(ctx, "SET", "s", "hello")
+	execCommand(ctx, "EXPIRE", "s", "100")
+	execCommand(ctx, "RPUSH", "l", "a", "b")
+	execCommand(ctx, "HSET", "h", "f", "v")
+
+	reply := execCommand(ctx, "DEBUG", "DUMPDB")
+	if reply.Type != protocol.RESP_BULK_STRING {
+		t.Fatalf("Expected bulk string, got %v", reply)
+	}
+
+	var dump []debugKeyDump
+	if err := json.Unmarshal([]byte(reply.Str), &dump); err != nil {
+		t.Fatalf("DUMPDB returned invalid JSON %q: %v", reply.Str, err)
+	}
+	if len(dump) != 3 {
+		t.Fatalf("Expected 3 keys, got %v", dump)
+	}
+
+	// 按键名排序
+	want := []debugKeyDump{
+		{Key: "h", Type: "hash", Encoding: "listpack"},
+		{Key: "l", Type: "list", Encoding: "listpack"},
+		{Key: "s", Type: "string", Encoding: "embstr"},
+	}
+	for i, entry := range dump {
+		if entry.Key != want[i].Key || entry.Type != want[i].Type || entry.Encoding != want[i].Encoding {
+			t.Fatalf("Entry %d: expected %+v, got %+v", i, want[i], entry)
+		}
+	}
+	if dump[0].TTL != -1 || dump[2].TTL <= 0 || dump[2].TTL > 100 {
+		t.Fatalf("Unexpected TTLs: %+v", dump)
+	}
+}
+
+func TestCommandIntrospection(t *testing.T) {
+	ctx := newTestContext(t)
+
+	reply := execCommand(ctx, "COMMAND", "COUNT")
+	if reply.Int != int64(len(ctx.Server.cmdTable.commands)) {
+		t.Fatalf("Expected COMMAND COUNT %d, got %v", len(ctx.Server.cmdTable.commands), reply)
+	}
+
+	reply = execCommand(ctx, "COMMAND", "INFO", "get", "nosuchcommand")
+	if len(reply.Array) != 2 {
+		t.Fatalf("Expected 2 entries, got %v", reply)
+	}
+	info := reply.Array[0].Array
+	if info[0].Str != "get" || info[1].Int != 2 {
+		t.Fatalf("Unexpected COMMAND INFO GET: %v", reply.Array[0])
+	}
+	if info[2].Array[0].Str != "readonly" || info[6].Array[0].Str != "@string" {
+		t.Fatalf("Unexpected flags/category for GET: %v", reply.Array[0])
+	}
+	if !reply.Array[1].Null {
+		t.Fatalf("Expected nil for unknown command, got %v", reply.Array[1])
+	}
+
+	info = execCommand(ctx, "COMMAND", "INFO", "SET").Array[0].Array
+	if info[2].Array[0].Str != "write" {
+		t.Fatalf("Expected SET to be a write command, got %v", info[2])
+	}
+
+	if reply := execCommand(ctx, "COMMAND"); len(reply.Array) != len(ctx.Server.cmdTable.commands) {
+		t.Fatalf("Expected one entry per command, got %d", len(reply.Array))
+	}
+
+	docs := execCommand(ctx, "COMMAND", "DOCS", "hset").Array
+	if len(docs) != 2 || docs[0].Str != "hset" || docs[1].Array[1].Str != "hash" {
+		t.Fatalf("Unexpected COMMAND DOCS HSET: %v", docs)
+	}
+}
+
+// TestCommandFlags 测试命令标志：写命令带 CMD_WRITE，只读命令带 CMD_READONLY，COMMAND INFO 列出所有标志
+func TestCommandFlags(t *testing.T) {
+	ctx := newTestContext(t)
+
+	for _, name := range []string{"SET", "LPUSH", "DEL", "FLUSHALL", "BLPOP"} {
+		cmd, _ := ctx.Server.cmdTable.Lookup(name)
+		if cmd.Flags&CMD_WRITE == 0 || cmd.Flags&CMD_READONLY != 0 || !ctx.Server.isWriteCommand(name) {
+			t.Fatalf("Expected %s to be a write command, flags=%b", name, cmd.Flags)
+		}
+	}
+	for _, name := range []string{"GET", "LRANGE", "EXISTS", "ZSCORE"} {
+		cmd, _ := ctx.Server.cmdTable.Lookup(name)
+		if cmd.Flags&CMD_READONLY == 0 || cmd.Flags&CMD_WRITE != 0 || ctx.Server.isWriteCommand(name) {
+			t.Fatalf("Expected %s to be a read-only command, flags=%b", name, cmd.Flags)
+		}
+	}
+
+	// 写命令和只读命令互斥，可能增加内存的命令一定是写命令
+	for _, cmd := range ctx.Server.cmdTable.Commands() {
+		if cmd.Flags&CMD_WRITE != 0 && cmd.Flags&CMD_READONLY != 0 {
+			t.Fatalf("%s is flagged both write and readonly", cmd.Name)
+		}
+		if cmd.Flags&CMD_DENYOOM != 0 && cmd.Flags&CMD_WRITE == 0 {
+			t.Fatalf("%s is flagged denyoom but not write", cmd.Name)
+		}
+	}
+
+	if !ctx.Server.denyOOM("SET") || ctx.Server.denyOOM("DEL") || ctx.Server.denyOOM("GET") {
+		t.Fatal("Expected only SET to be denied when out of memory")
+	}
+
+	assertStrings(t, replyStrings(t, execCommand(ctx, "COMMAND", "INFO", "lpush").Array[0].Array[2]), "write", "denyoom", "fast")
+	assertStrings(t, replyStrings(t, execCommand(ctx, "COMMAND", "INFO", "config").Array[0].Array[2]), "admin", "noscript", "loading", "stale")
+}
+
+func TestTime(t *testing.T) {
+	ctx := newTestContext(t)
+
+	reply := execCommand(ctx, "TIME")
+	if len(reply.Array) != 2 {
+		t.Fatalf("Expected 2 elements, got %v", reply)
+	}
+	seconds, err := strconv.ParseInt(reply.Array[0].Str, 10, 64)
+	if err != nil || seconds < time.Now().Unix()-1 {
+		t.Fatalf("Unexpected seconds %q", reply.Array[0].Str)
+	}
+	micros, err := strconv.ParseInt(reply.Array[1].Str, 10, 64)
+	if err != nil || micros < 0 || micros >= 1000000 {
+		t.Fatalf("Unexpected microseconds %q", reply.Array[1].Str)
+	}
+}
+
+func TestLastSave(t *testing.T) {
+	ctx := newTestContext(t)
+	ctx.Server.rdbFilename = filepath.Join(t.TempDir(), "dump.rdb")
+
+	before := execCommand(ctx, "LASTSAVE").Int
+	// LASTSAVE 精度为秒，等到下一秒再保存
+	for time.Now().Unix() <= before {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if reply := execCommand(ctx, "SAVE"); reply.Str != "OK" {
+		t.Fatalf("SAVE failed: %v", reply)
+	}
+	if after := execCommand(ctx, "LASTSAVE").Int; after <= before {
+		t.Fatalf("Expected LASTSAVE to advance past %d, got %d", before, after)
+	}
+}
+
+func TestDebugSleep(t *testing.T) {
+	ctx := newTestContext(t)
+
+	start := time.Now()
+	if reply := execCommand(ctx, "DEBUG", "SLEEP", "0.2"); reply.Str != "OK" {
+		t.Fatalf("DEBUG SLEEP failed: %v", reply)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond || elapsed > 2*time.Second {
+		t.Fatalf("Expected to sleep about 200ms, slept %v", elapsed)
+	}
+	if reply := execCommand(ctx, "DEBUG", "SLEEP", "abc"); reply.Type != protocol.RESP_ERROR {
+		t.Fatalf("Expected error for invalid duration, got %v", reply)
+	}
+}
+
+func TestMemoryUsageEncodings(t *testing.T) {
+	ctx := newTestContext(t)
+
+	// 相同的成员：一个保持 intset，另一个插入字符串后转换为 hashtable 再删掉该字符串
+	for i := 0; i < 100; i++ {
+		member := strconv.Itoa(i)
+		execCommand(ctx, "SADD", "compact", member)
+		execCommand(ctx, "SADD", "table", member)
+	}
+	execCommand(ctx, "SADD", "table", "x")
+	execCommand(ctx, "SREM", "table", "x")
+
+	if reply := execCommand(ctx, "OBJECT", "ENCODING", "compact"); reply.Str != "intset" {
+		t.Fatalf("Expected intset encoding, got %q", reply.Str)
+	}
+	if reply := execCommand(ctx, "OBJECT", "ENCODING", "table"); reply.Str != "hashtable" {
+		t.Fatalf("Expected hashtable encoding, got %q", reply.Str)
+	}
+
+	compact := execCommand(ctx, "MEMORY", "USAGE", "compact", "SAMPLES", "0").Int
+	table := execCommand(ctx, "MEMORY", "USAGE", "table", "SAMPLES", "0").Int
+	if compact <= 100 || table <= compact*2 {
+		t.Fatalf("Expected intset (%d bytes) to be much smaller than hashtable (%d bytes)", compact, table)
+	}
+}
+
+func TestMemoryStatsAndDoctor(t *testing.T) {
+	ctx := newTestContext(t)
+
+	execCommand(ctx, "SET", "a", "1")
+	execCommand(ctx, "SET", "b", "2")
+	execCommand(ctx, "EXPIRE", "b", "100")
+
+	reply := execCommand(ctx, "MEMORY", "STATS")
+	if reply.Type != protocol.RESP_MAP {
+		t.Fatalf("Expected map reply, got %v", reply)
+	}
+	stats := make(map[string]*protocol.RESPValue)
+	for i := 0; i+1 < len(reply.Array); i += 2 {
+		stats[reply.Array[i].Str] = reply.Array[i+1]
+	}
+	if stats["keys.count"] == nil || stats["keys.count"].Int != 2 {
+		t.Fatalf("Expected keys.count 2, got %v", stats["keys.count"])
+	}
+	if stats["total.allocated"] == nil || stats["total.allocated"].Int <= 0 {
+		t.Fatalf("Expected positive total.allocated, got %v", stats["total.allocated"])
+	}
+	db0 := stats["db.0"]
+	if db0 == nil || db0.Array[1].Int != 2 || db0.Array[3].Int != 1 {
+		t.Fatalf("Unexpected db.0 stats: %v", db0)
+	}
+
+	if reply := execCommand(ctx, "MEMORY", "DOCTOR"); !strings.Contains(reply.Str, "Sam") {
+		t.Fatalf("Unexpected MEMORY DOCTOR reply: %v", reply)
+	}
+}
+
+// TestLMove 测试 LMOVE 的方向参数、同一列表轮转和目标类型错误
+func TestLMove(t *testing.T) {
+	ctx := newTestContext(t)
+	execCommand(ctx, "RPUSH", "src", "a", "b", "c")
+
+	if reply := execCommand(ctx, "LMOVE", "src", "dst", "LEFT", "RIGHT"); reply.Str != "a" {
+		t.Fatalf("Expected a, got %v", reply)
+	}
+	if reply := execCommand(ctx, "LMOVE", "src", "dst", "right", "left"); reply.Str != "c" {
+		t.Fatalf("Expected c, got %v", reply)
+	}
+	assertStrings(t, replyStrings(t, execCommand(ctx, "LRANGE", "dst", "0", "-1")), "c", "a")
+
+	// 同一个列表：轮转
+	execCommand(ctx, "RPUSH", "ring", "1", "2", "3")
+	execCommand(ctx, "LMOVE", "ring", "ring", "LEFT", "RIGHT")
+	assertStrings(t, replyStrings(t, execCommand(ctx, "LRANGE", "ring", "0", "-1")), "2", "3", "1")
+
+	// 只剩一个元素时移动到自身
+	execCommand(ctx, "RPUSH", "one", "x")
+	if reply := execCommand(ctx, "RPOPLPUSH", "one", "one"); reply.Str != "x" {
+		t.Fatalf("Expected x, got %v", reply)
+	}
+	assertStrings(t, replyStrings(t, execCommand(ctx, "LRANGE", "one", "0", "-1")), "x")
+
+	// 目标类型错误时源列表不受影响
+	execCommand(ctx, "SET", "str", "v")
+	if reply := execCommand(ctx, "LMOVE", "src", "str", "LEFT", "LEFT"); reply.Type != protocol.RESP_ERROR {
+		t.Fatalf("Expected wrong type error, got %v", reply)
+	}
+	assertStrings(t, replyStrings(t, execCommand(ctx, "LRANGE", "src", "0", "-1")), "b")
+
+	if reply := execCommand(ctx, "LMOVE", "src", "dst", "UP", "LEFT"); reply.Type != protocol.RESP_ERROR {
+		t.Fatalf("Expected syntax error, got %v", reply)
+	}
+	if reply := execCommand(ctx, "LMOVE", "missing", "dst", "LEFT", "LEFT"); !reply.Null {
+		t.Fatalf("Expected nil for missing source, got %v", reply)
+	}
+}
+
+// TestMaxmemoryEviction 测试内存超过 maxmemory 时 allkeys-random 淘汰键、noeviction 拒绝写命令
+func TestMaxmemoryEviction(t *testing.T) {
+	ctx := newTestContext(t)
+
+	const keys = 4000
+	value := strings.Repeat("x", 200)
+	for i := 0; i < keys; i++ {
+		execCommand(ctx, "SET", "key:"+strconv.Itoa(i), value)
+	}
+
+	// 上限比当前已使用内存少 200KB：写命令需要淘汰一部分键
+	runtime.GC()
+	ctx.Server.memoryStats.Update()
+	used := ctx.Server.memoryStats.GetUsedMemory()
+	execCommand(ctx, "CONFIG", "SET", "maxmemory-policy", "allkeys-random")
+	execCommand(ctx, "CONFIG", "SET", "maxmemory", strconv.FormatInt(used-200*1024, 10))
+
+	if reply := execCommand(ctx, "SET", "new", "v"); reply.Str != "OK" {
+		t.Fatalf("Expected SET to succeed after eviction, got %v", reply)
+	}
+	size := ctx.Db.DBSize()
+	if size >= keys+1 || size <= 1 {
+		t.Fatalf("Expected some but not all keys to be evicted, dbsize=%d", size)
+	}
+	if ctx.Server.stats.EvictedKeys != int64(keys+1-size) {
+		t.Fatalf("Expected evicted_keys=%d, got %d", keys+1-size, ctx.Server.stats.EvictedKeys)
+	}
+
+	// noeviction：写命令返回 OOM，读命令和删除命令不受影响
+	execCommand(ctx, "CONFIG", "SET", "maxmemory-policy", "noeviction")
+	execCommand(ctx, "CONFIG", "SET", "maxmemory", "1")
+	reply := execCommand(ctx, "SET", "rejected", "v")
+	if reply.Type != protocol.RESP_ERROR || !strings.HasPrefix(reply.Str, "OOM ") {
+		t.Fatalf("Expected OOM error, got %v", reply)
+	}
+	if reply := execCommand(ctx, "GET", "new"); reply.Str != "v" {
+		t.Fatalf("Expected GET to work under OOM, got %v", reply)
+	}
+	if reply := execCommand(ctx, "DEL", "new"); reply.Int != 1 {
+		t.Fatalf("Expected DEL to work under OOM, got %v", reply)
+	}
+
+	if reply := execCommand(ctx, "CONFIG", "SET", "maxmemory-policy", "allkeys-fifo"); reply.Type != protocol.RESP_ERROR {
+		t.Fatalf("Expected unknown policy to be rejected, got %v", reply)
+	}
+}
+
+// TestEvictionPropagatesDel 测试淘汰的键以 DEL 写入 AOF
+func TestEvictionPropagatesDel(t *testing.T) {
+	ctx := newTestContext(t)
+	aofFile := filepath.Join(t.TempDir(), "appendonly.aof")
+	if err := ctx.Server.InitAOF(true, aofFile); err != nil {
+		t.Fatalf("InitAOF failed: %v", err)
+	}
+
+	const keys = 4000
+	value := strings.Repeat("x", 200)
+	for i := 0; i < keys; i++ {
+		execCommand(ctx, "SET", "key:"+strconv.Itoa(i), value)
+	}
+
+	runtime.GC()
+	ctx.Server.memoryStats.Update()
+	used := ctx.Server.memoryStats.GetUsedMemory()
+	execCommand(ctx, "CONFIG", "SET", "maxmemory-policy", "allkeys-lru")
+	execCommand(ctx, "CONFIG", "SET", "maxmemory", strconv.FormatInt(used-200*1024, 10))
+	if reply := execCommand(ctx, "SET", "new", "v"); reply.Str != "OK" {
+		t.Fatalf("Expected SET to succeed after eviction, got %v", reply)
+	}
+	ctx.Server.aofWriter.Close()
+
+	// execCommand 不经过 propagate，AOF 中只有淘汰产生的 DEL
+	logged := aofCommands(t, aofFile)
+	if int64(len(logged)) != ctx.Server.stats.EvictedKeys || len(logged) == 0 {
+		t.Fatalf("Expected one DEL per evicted key (%d), got %d", ctx.Server.stats.EvictedKeys, len(logged))
+	}
+	for _, cmd := range logged {
+		key, ok := strings.CutPrefix(cmd, "DEL ")
+		if !ok {
+			t.Fatalf("Expected DEL, got %q", cmd)
+		}
+		if ctx.Db.Exists(key) {
+			t.Fatalf("Expected %s to be evicted", key)
+		}
+	}
+}
+
+// TestConfigEncodingThresholds 测试 CONFIG SET 修改编码转换阈值后立即生效，CONFIG GET 支持 glob
+func TestConfigEncodingThresholds(t *testing.T) {
+	ctx := newTestContext(t)
+	t.Cleanup(func() {
+		execCommand(ctx, "CONFIG", "SET", "hash-max-listpack-entries", "512")
+	})
+
+	if reply := execCommand(ctx, "CONFIG", "SET", "hash-max-listpack-entries", "2"); reply.Str != "OK" {
+		t.Fatalf("Expected OK, got %v", reply)
+	}
+	assertStrings(t, replyStrings(t, execCommand(ctx, "CONFIG", "GET", "hash-max-listpack-entries")),
+		"hash-max-listpack-entries", "2")
+
+	execCommand(ctx, "HSET", "h", "f1", "v", "f2", "v")
+	if reply := execCommand(ctx, "OBJECT", "ENCODING", "h"); reply.Str != "listpack" {
+		t.Fatalf("Expected listpack at the threshold, got %q", reply.Str)
+	}
+	execCommand(ctx, "HSET", "h", "f3", "v")
+	if reply := execCommand(ctx, "OBJECT", "ENCODING", "h"); reply.Str != "hashtable" {
+		t.Fatalf("Expected hashtable above the new threshold, got %q", reply.Str)
+	}
+
+	assertStrings(t, replyStrings(t, execCommand(ctx, "CONFIG", "GET", "maxmemory*", "maxmemory")),
+		"maxmemory", "0", "maxmemory-policy", "noeviction", "maxmemory-samples", "5")
+
+	if reply := execCommand(ctx, "CONFIG", "SET", "appendfsync", "ALWAYS"); reply.Str != "OK" {
+		t.Fatalf("Expected OK, got %v", reply)
+	}
+	assertStrings(t, replyStrings(t, execCommand(ctx, "CONFIG", "GET", "appendfsync")), "appendfsync", "always")
+	if reply := execCommand(ctx, "CONFIG", "SET", "appendfsync", "sometimes"); reply.Type != protocol.RESP_ERROR {
+		t.Fatalf("Expected invalid appendfsync to be rejected, got %v", reply)
+	}
+}
+
+// TestConfigSetMaxIntsetEntries 测试调低 set-max-intset-entries 后，第 5 个整数成员使 intset 转换为 hashtable
+func TestConfigSetMaxIntsetEntries(t *testing.T) {
+	ctx := newTestContext(t)
+	t.Cleanup(func() {
+		execCommand(ctx, "CONFIG", "SET", "set-max-intset-entries", "512")
+	})
+
+	execCommand(ctx, "CONFIG", "SET", "set-max-intset-entries", "4")
+	for i := 1; i <= 4; i++ {
+		execCommand(ctx, "SADD", "s", strconv.Itoa(i))
+		if reply := execCommand(ctx, "OBJECT", "ENCODING", "s"); reply.Str != "intset" {
+			t.Fatalf("Expected intset with %d members, got %q", i, reply.Str)
+		}
+	}
+
+	execCommand(ctx, "SADD", "s", "5")
+	if reply := execCommand(ctx, "OBJECT", "ENCODING", "s"); reply.Str != "hashtable" {
+		t.Fatalf("Expected hashtable after the 5th member, got %q", reply.Str)
+	}
+	if reply := execCommand(ctx, "SCARD", "s"); reply.Int != 5 {
+		t.Fatalf("Expected 5 members after conversion, got %d", reply.Int)
+	}
+
+	// 有序集合的阈值同样可以调整
+	execCommand(ctx, "CONFIG", "SET", "zset-max-listpack-entries", "1")
+	t.Cleanup(func() {
+		execCommand(ctx, "CONFIG", "SET", "zset-max-listpack-entries", "128")
+	})
+	execCommand(ctx, "ZADD", "z", "1", "a", "2", "b")
+	if reply := execCommand(ctx, "OBJECT", "ENCODING", "z"); reply.Str != "skiplist" {
+		t.Fatalf("Expected skiplist above zset-max-listpack-entries, got %q", reply.Str)
+	}
+}
+
+// TestIncrDecr 测试 INCR/DECR/DECRBY 在键不存在时从 0 开始
+func TestIncrDecr(t *testing.T) {
+	ctx := newTestContext(t)
+
+	if reply := execCommand(ctx, "INCR", "n"); reply.Int != 1 {
+		t.Fatalf("Expected INCR to return 1, got %v", reply)
+	}
+	if reply := execCommand(ctx, "DECR", "n"); reply.Int != 0 {
+		t.Fatalf("Expected DECR to return 0, got %v", reply)
+	}
+	if reply := execCommand(ctx, "DECRBY", "n", "5"); reply.Int != -5 {
+		t.Fatalf("Expected DECRBY to return -5, got %v", reply)
+	}
+	if reply := execCommand(ctx, "GET", "n"); reply.Str != "-5" {
+		t.Fatalf("Expected -5, got %v", reply)
+	}
+}
+
+// TestAppendSetRange 测试 APPEND/SETRANGE 原地修改字符串（包括超过 255 字节的值），且不会修改共享对象
+func TestAppendSetRange(t *testing.T) {
+	ctx := newTestContext(t)
+
+	if reply := execCommand(ctx, "APPEND", "s", "hello"); reply.Int != 5 {
+		t.Fatalf("Expected APPEND to return 5, got %v", reply)
+	}
+	want := "hello"
+	for i := 0; i < 100; i++ {
+		chunk := strings.Repeat(strconv.Itoa(i%10), 10)
+		want += chunk
+		if reply := execCommand(ctx, "APPEND", "s", chunk); reply.Int != int64(len(want)) {
+			t.Fatalf("Expected APPEND to return %d, got %v", len(want), reply)
+		}
+	}
+	if reply := execCommand(ctx, "GET", "s"); reply.Str != want {
+		t.Fatalf("Expected %d bytes, got %d", len(want), len(reply.Str))
+	}
+
+	if reply := execCommand(ctx, "SETRANGE", "s", "0", "HELLO"); reply.Int != int64(len(want)) {
+		t.Fatalf("Expected SETRANGE to return %d, got %v", len(want), reply)
+	}
+	if reply := execCommand(ctx, "GETRANGE", "s", "0", "6"); reply.Str != "HELLO00" {
+		t.Fatalf("Expected HELLO00, got %q", reply.Str)
+	}
+	if reply := execCommand(ctx, "SETRANGE", "missing", "3", "abc"); reply.Int != 6 {
+		t.Fatalf("Expected SETRANGE to return 6, got %v", reply)
+	}
+	if reply := execCommand(ctx, "GET", "missing"); reply.Str != "\x00\x00\x00abc" {
+		t.Fatalf("Expected zero padding, got %q", reply.Str)
+	}
+
+	// 共享整数对象被复制后再修改
+	execCommand(ctx, "SET", "a", "12")
+	execCommand(ctx, "SET", "b", "12")
+	execCommand(ctx, "APPEND", "a", "3")
+	execCommand(ctx, "SETRANGE", "b", "0", "9")
+	execCommand(ctx, "SET", "c", "12")
+	for key, value := range map[string]string{"a": "123", "b": "92", "c": "12"} {
+		if reply := execCommand(ctx, "GET", key); reply.Str != value {
+			t.Fatalf("Expected %s to be %q, got %q", key, value, reply.Str)
+		}
+	}
+}
+
+// TestGetRange 测试 GETRANGE/SUBSTR 的负数索引和越界处理
+func TestGetRange(t *testing.T) {
+	ctx := newTestContext(t)
+	execCommand(ctx, "SET", "s", "This is a string")
+
+	for _, tc := range []struct {
+		cmd, start, end, want string
+	}{
+		{"GETRANGE", "0", "3", "This"},
+		{"GETRANGE", "-3", "-1", "ing"},
+		{"GETRANGE", "0", "-1", "This is a string"},
+		{"GETRANGE", "10", "100", "string"},
+		{"GETRANGE", "0", "-100", "T"},
+		{"GETRANGE", "5", "3", ""},
+		{"SUBSTR", "5", "6", "is"},
+		{"GETRANGE", "100", "200", ""},
+	} {
+		if reply := execCommand(ctx, tc.cmd, "s", tc.start, tc.end); reply.Str != tc.want {
+			t.Fatalf("%s s %s %s: expected %q, got %q", tc.cmd, tc.start, tc.end, tc.want, reply.Str)
+		}
+	}
+	if reply := execCommand(ctx, "GET", "s"); reply.Str != "This is a string" {
+		t.Fatalf("GETRANGE modified the value: %q", reply.Str)
+	}
+	if reply := execCommand(ctx, "GETRANGE", "missing", "0", "-1"); reply.Str != "" {
+		t.Fatalf("Expected empty string for a missing key, got %q", reply.Str)
+	}
+	if reply := execCommand(ctx, "GETRANGE", "s", "a", "1"); reply.Type != protocol.RESP_ERROR {
+		t.Fatalf("Expected error for a non-integer start, got %v", reply)
+	}
+}
+
+// TestRenameTTL 测试 RENAME/RENAMENX 把源键的过期时间带到新键，并覆盖目标键原来的过期时间
+func TestRenameTTL(t *testing.T) {
+	ctx := newTestContext(t)
+
+	execCommand(ctx, "SET", "src", "v")
+	execCommand(ctx, "EXPIRE", "src", "100")
+	execCommand(ctx, "RENAME", "src", "dst")
+	if reply := execCommand(ctx, "TTL", "dst"); reply.Int < 99 || reply.Int > 100 {
+		t.Fatalf("Expected dst to keep the 100s TTL, got %d", reply.Int)
+	}
+	if reply := execCommand(ctx, "EXISTS", "src"); reply.Int != 0 {
+		t.Fatal("Expected src to be gone after RENAME")
+	}
+
+	// 目标键的过期时间被覆盖：源键没有过期时间时目标键也没有
+	execCommand(ctx, "SET", "plain", "p")
+	execCommand(ctx, "RENAME", "plain", "dst")
+	if reply := execCommand(ctx, "TTL", "dst"); reply.Int != -1 {
+		t.Fatalf("Expected dst to have no TTL after renaming a persistent key onto it, got %d", reply.Int)
+	}
+
+	// 源键的过期时间替换目标键原来的过期时间
+	execCommand(ctx, "SET", "short", "s")
+	execCommand(ctx, "EXPIRE", "short", "50")
+	execCommand(ctx, "EXPIRE", "dst", "1000")
+	execCommand(ctx, "RENAME", "short", "dst")
+	if reply := execCommand(ctx, "TTL", "dst"); reply.Int < 49 || reply.Int > 50 {
+		t.Fatalf("Expected dst to take the 50s TTL, got %d", reply.Int)
+	}
+
+	execCommand(ctx, "SET", "nx", "n")
+	execCommand(ctx, "EXPIRE", "nx", "100")
+	if reply := execCommand(ctx, "RENAMENX", "nx", "nxdst"); reply.Int != 1 {
+		t.Fatalf("Expected RENAMENX to return 1, got %v", reply)
+	}
+	if reply := execCommand(ctx, "TTL", "nxdst"); reply.Int < 99 || reply.Int > 100 {
+		t.Fatalf("Expected nxdst to keep the 100s TTL, got %d", reply.Int)
+	}
+}
+
+// TestMoveTTL 测试 MOVE 把过期时间带到目标数据库，已过期的源键视为不存在，源和目标相同时报错
+func TestMoveTTL(t *testing.T) {
+	ctx := newTestContext(t)
+	db1, err := ctx.Server.GetRedisServer().GetDb(1)
+	if err != nil {
+		t.Fatalf("Failed to get db: %v", err)
+	}
+
+	execCommand(ctx, "SET", "k", "v")
+	execCommand(ctx, "EXPIRE", "k", "100")
+	if reply := execCommand(ctx, "MOVE", "k", "1"); reply.Int != 1 {
+		t.Fatalf("Expected MOVE to return 1, got %v", reply)
+	}
+	if ctx.Db.Exists("k") {
+		t.Fatal("Expected k to be gone from the source db")
+	}
+	expireAt, ok := db1.GetExpireAt("k")
+	if !ok {
+		t.Fatal("Expected k to keep its TTL in the destination db")
+	}
+	if ttl := expireAt - time.Now().Unix(); ttl < 99 || ttl > 100 {
+		t.Fatalf("Expected a 100s TTL in the destination db, got %d", ttl)
+	}
+
+	// 没有过期时间的键移动后仍然没有
+	execCommand(ctx, "SET", "plain", "p")
+	execCommand(ctx, "MOVE", "plain", "1")
+	if _, ok := db1.GetExpireAt("plain"); ok {
+		t.Fatal("Expected plain to stay persistent after MOVE")
+	}
+
+	// 逻辑上已过期的源键视为不存在
+	execCommand(ctx, "SET", "old", "o")
+	ctx.Db.ExpireAt("old", time.Now().Unix()-1)
+	if reply := execCommand(ctx, "MOVE", "old", "1"); reply.Int != 0 {
+		t.Fatalf("Expected MOVE of an expired key to return 0, got %v", reply)
+	}
+	if db1.Exists("old") {
+		t.Fatal("Expected the expired key not to reach the destination db")
+	}
+
+	// 目标数据库已有该键时不移动
+	execCommand(ctx, "SET", "k", "again")
+	if reply := execCommand(ctx, "MOVE", "k", "1"); reply.Int != 0 {
+		t.Fatalf("Expected MOVE onto an existing key to return 0, got %v", reply)
+	}
+
+	if reply := execCommand(ctx, "MOVE", "k", "0"); reply.Type != protocol.RESP_ERROR {
+		t.Fatalf("Expected an error when source and destination are the same, got %v", reply)
+	}
+}
+
+// TestGetSetEmptyValue 测试 GETSET 区分旧值为空字符串和键不存在
+func TestGetSetEmptyValue(t *testing.T) {
+	ctx := newTestContext(t)
+
+	if reply := execCommand(ctx, "GETSET", "k", "a"); !reply.Null {
+		t.Fatalf("Expected nil for a missing key, got %v", reply)
+	}
+
+	execCommand(ctx, "SET", "k", "")
+	reply := execCommand(ctx, "GETSET", "k", "v")
+	if reply.Null || reply.Type != protocol.RESP_BULK_STRING || reply.Str != "" {
+		t.Fatalf("Expected an empty bulk string, got %v", reply)
+	}
+	if reply := execCommand(ctx, "GET", "k"); reply.Str != "v" {
+		t.Fatalf("Expected v, got %q", reply.Str)
+	}
+}
+
+// TestAppendFsyncConfig 测试 appendfsync 在 AOF 初始化和 CONFIG SET 时应用到 AOF 写入器，INFO persistence 报告重写状态
+func TestAppendFsyncConfig(t *testing.T) {
+	ctx := newTestContext(t)
+	ctx.Server.config.Set("appendfsync", "no")
+	if err := ctx.Server.InitAOF(true, filepath.Join(t.TempDir(), "appendonly.aof")); err != nil {
+		t.Fatalf("InitAOF failed: %v", err)
+	}
+	t.Cleanup(func() { ctx.Server.aofWriter.Close() })
+
+	if policy := ctx.Server.aofWriter.FsyncPolicy(); policy != "no" {
+		t.Fatalf("Expected fsync policy no from config, got %s", policy)
+	}
+	execCommand(ctx, "CONFIG", "SET", "appendfsync", "always")
+	if policy := ctx.Server.aofWriter.FsyncPolicy(); policy != "always" {
+		t.Fatalf("Expected CONFIG SET to switch the fsync policy, got %s", policy)
+	}
+
+	info := execCommand(ctx, "INFO", "persistence").ToString()
+	for _, field := range []string{"aof_enabled:1", "aof_rewrite_in_progress:0", "aof_last_bgrewrite_status:ok"} {
+		if !strings.Contains(info, field) {
+			t.Fatalf("Expected %s in INFO persistence, got %q", field, info)
+		}
+	}
+}
+
+// TestClusterKeySlot 测试 CLUSTER KEYSLOT / COUNTKEYSINSLOT / GETKEYSINSLOT
+func TestClusterKeySlot(t *testing.T) {
+	ctx := newTestContext(t)
+	ctx.Server.InitCluster(true, "node1", "127.0.0.1:7000")
+
+	for key, want := range map[string]int64{"foo": 12182, "bar": 5061, "{user1000}.following": 3443} {
+		if reply := execCommand(ctx, "CLUSTER", "KEYSLOT", key); reply.Int != want {
+			t.Fatalf("KEYSLOT %s: expected %d, got %v", key, want, reply)
+		}
+	}
+
+	for _, key := range []string{"{user1000}.following", "{user1000}.followers", "user1000", "foo", "bar"} {
+		execCommand(ctx, "SET", key, "v")
+	}
+	if reply := execCommand(ctx, "CLUSTER", "COUNTKEYSINSLOT", "3443"); reply.Int != 3 {
+		t.Fatalf("Expected 3 keys in slot 3443, got %v", reply)
+	}
+	keys := replyStrings(t, execCommand(ctx, "CLUSTER", "GETKEYSINSLOT", "3443", "10"))
+	sort.Strings(keys)
+	assertStrings(t, keys, "user1000", "{user1000}.followers", "{user1000}.following")
+	if keys := replyStrings(t, execCommand(ctx, "CLUSTER", "GETKEYSINSLOT", "3443", "2")); len(keys) != 2 {
+		t.Fatalf("Expected count to limit the result, got %v", keys)
+	}
+	assertStrings(t, replyStrings(t, execCommand(ctx, "CLUSTER", "GETKEYSINSLOT", "12182", "10")), "foo")
+
+	if reply := execCommand(ctx, "CLUSTER", "COUNTKEYSINSLOT", "16384"); reply.Type != protocol.RESP_ERROR {
+		t.Fatalf("Expected error for out of range slot, got %v", reply)
+	}
+	if reply := execCommand(ctx, "CLUSTER", "GETKEYSINSLOT", "0", "-1"); reply.Type != protocol.RESP_ERROR {
+		t.Fatalf("Expected error for negative count, got %v", reply)
+	}
+}
+
+// TestClusterForgetReplicateReset 测试 CLUSTER FORGET / REPLICATE / RESET 修改节点视图并保存配置
+func TestClusterForgetReplicateReset(t *testing.T) {
+	ctx := newTestContext(t)
+	ctx.Server.InitCluster(true, "node1", "127.0.0.1:7000")
+	c := ctx.Server.GetCluster()
+	configPath := filepath.Join(t.TempDir(), "nodes.conf")
+	c.GetConfigPersistence().SetConfigPath(configPath)
+	c.AddNode("node2", "127.0.0.1:7001")
+	c.AddNode("node3", "127.0.0.1:7002")
+	c.AssignSlots("node2", []int{0, 1, 2})
+
+	nodeLine := func(nodeID string) string {
+		for _, line := range strings.Split(execCommand(ctx, "CLUSTER", "NODES").Str, "\n") {
+			if strings.HasPrefix(line, nodeID+" ") {
+				return line
+			}
+		}
+		return ""
+	}
+
+	if reply := execCommand(ctx, "CLUSTER", "FORGET", "node3"); reply.Str != "OK" {
+		t.Fatalf("FORGET failed: %v", reply)
+	}
+	if line := nodeLine("node3"); line != "" {
+		t.Fatalf("Expected node3 to be removed from CLUSTER NODES, got %q", line)
+	}
+	for _, nodeID := range []string{"node1", "node3"} {
+		if reply := execCommand(ctx, "CLUSTER", "FORGET", nodeID); reply.Type != protocol.RESP_ERROR {
+			t.Fatalf("Expected FORGET %s to fail, got %v", nodeID, reply)
+		}
+	}
+
+	if reply := execCommand(ctx, "CLUSTER", "REPLICATE", "node2"); reply.Str != "OK" {
+		t.Fatalf("REPLICATE failed: %v", reply)
+	}
+	if fields := strings.Fields(nodeLine("node1")); len(fields) < 4 || fields[2] != "myself,slave" || fields[3] != "node2" {
+		t.Fatalf("Expected node1 to be reported as a replica of node2, got %q", nodeLine("node1"))
+	}
+	if reply := execCommand(ctx, "CLUSTER", "FORGET", "node2"); reply.Type != protocol.RESP_ERROR {
+		t.Fatalf("Expected FORGET of our master to fail, got %v", reply)
+	}
+
+	// 配置已保存到文件
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatalf("Expected cluster config to be saved: %v", err)
+	}
+	if !strings.Contains(string(data), `"master_id": "node2"`) || strings.Contains(string(data), "node3") {
+		t.Fatalf("Unexpected saved config: %s", data)
+	}
+
+	// 软重置：成为主节点，忘记其它节点，节点 ID 不变
+	if reply := execCommand(ctx, "CLUSTER", "RESET", "SOFT"); reply.Str != "OK" {
+		t.Fatalf("RESET SOFT failed: %v", reply)
+	}
+	if nodeLine("node2") != "" || !strings.Contains(nodeLine("node1"), "myself,master") {
+		t.Fatalf("Unexpected nodes after RESET: %q", execCommand(ctx, "CLUSTER", "NODES").Str)
+	}
+	if c.GetSlotNode(0) != nil {
+		t.Fatal("Expected slot assignments to be cleared by RESET")
+	}
+
+	// 硬重置生成新的节点 ID；有数据的主节点不能重置
+	if reply := execCommand(ctx, "CLUSTER", "RESET", "HARD"); reply.Str != "OK" {
+		t.Fatalf("RESET HARD failed: %v", reply)
+	}
+	if id := c.GetMyself().NodeID; id == "node1" || len(id) != 40 {
+		t.Fatalf("Expected a new 40 character node ID, got %q", id)
+	}
+	execCommand(ctx, "SET", "k", "v")
+	if reply := execCommand(ctx, "CLUSTER", "RESET"); reply.Type != protocol.RESP_ERROR {
+		t.Fatalf("Expected RESET of a master with keys to fail, got %v", reply)
+	}
+}
@@ -0,0 +1,295 @@
+package server
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/code-100-precent/LingCache/persistence"
+	"github.com/code-100-precent/LingCache/utils"
+)
+
+/*
+ * ============================================================================
+ * 运行时配置（CONFIG GET/SET/REWRITE）
+ * ============================================================================
+ *
+ * 配置参数使用 Redis 的参数名（如 maxmemory、appendonly），
+ * 每个参数对应 .env 文件中的一个键（如 REDIS_MAXMEMORY）。
+ *
+ * 【CONFIG REWRITE】
+ * 将当前生效的配置写回启动时加载的 .env 文件：
+ * - 已存在的键原地更新，保留注释和其它行
+ * - 新的键追加到文件末尾
+ * - 先写临时文件再 rename，保证原子替换
+ */
+
+var (
+	ErrUnknownConfig  = errors.New("unknown config parameter")
+	ErrInvalidConfig  = errors.New("invalid config value")
+	ErrNoConfigFile   = errors.New("the server is running without a config file")
+	configMemoryUnits = map[string]int64{
+		"b":  1,
+		"k":  1000,
+		"kb": 1024,
+		"m":  1000 * 1000,
+		"mb": 1024 * 1024,
+		"g":  1000 * 1000 * 1000,
+		"gb": 1024 * 1024 * 1024,
+	}
+)
+
+// configKind 配置值类型（用于校验和 .env 格式转换）
+type configKind int
+
+const (
+	configString configKind = iota
+	configInt
+	configBool
+	configMemory
+	configKeyspaceEvents
+)
+
+// configDef 配置参数定义
+type configDef struct {
+	name         string     // Redis 参数名
+	envKey       string     // .env 文件中的键
+	defaultValue string     // 默认值
+	kind         configKind // 值类型
+	enum         []string   // 允许的取值（为空表示不限制）
+}
+
+// configDefs 支持的配置参数（顺序即 REWRITE 追加顺序）
+var configDefs = []configDef{
+	{name: "maxmemory", envKey: "REDIS_MAXMEMORY", defaultValue: "0", kind: configMemory},
+	{name: "maxmemory-policy", envKey: "REDIS_MAXMEMORY_POLICY", defaultValue: "noeviction", kind: configString, enum: maxmemoryPolicies},
+	{name: "maxmemory-samples", envKey: "REDIS_MAXMEMORY_SAMPLES", defaultValue: "5", kind: configInt},
+	{name: "appendonly", envKey: "REDIS_AOF_ENABLED", defaultValue: "no", kind: configBool},
+	{name: "appendfsync", envKey: "REDIS_APPENDFSYNC", defaultValue: "everysec", kind: configString, enum: persistence.FsyncPolicies},
+	{name: "aof-load-truncated", envKey: "REDIS_AOF_LOAD_TRUNCATED", defaultValue: "yes", kind: configBool},
+	{name: "appendfilename", envKey: "REDIS_AOF_FILENAME", defaultValue: "appendonly.aof", kind: configString},
+	{name: "dbfilename", envKey: "REDIS_RDB_FILENAME", defaultValue: "dump.rdb", kind: configString},
+	{name: "rdbchecksum", envKey: "REDIS_RDB_CHECKSUM", defaultValue: "yes", kind: configBool},
+	{name: "save", envKey: "REDIS_SAVE", defaultValue: "3600 1 300 100 60 10000", kind: configString},
+	{name: "maxclients", envKey: "REDIS_MAX_CLIENTS", defaultValue: "10000", kind: configInt},
+	{name: "loglevel", envKey: "REDIS_LOG_LEVEL", defaultValue: "notice", kind: configString},
+	{name: "slowlog-log-slower-than", envKey: "REDIS_SLOWLOG_THRESHOLD", defaultValue: "10000", kind: configInt},
+	{name: "slowlog-max-len", envKey: "REDIS_SLOWLOG_MAX_LEN", defaultValue: "128", kind: configInt},
+	{name: "notify-keyspace-events", envKey: "REDIS_NOTIFY_KEYSPACE_EVENTS", defaultValue: "", kind: configKeyspaceEvents},
+	{name: "replica-read-only", envKey: "REDIS_REPLICA_READ_ONLY", defaultValue: "yes", kind: configBool},
+	{name: "repl-backlog-size", envKey: "REDIS_REPL_BACKLOG_SIZE", defaultValue: "1mb", kind: configMemory},
+	{name: "hz", envKey: "REDIS_HZ", defaultValue: "10", kind: configInt},
+	{name: "timeout", envKey: "REDIS_TIMEOUT", defaultValue: "0", kind: configInt},
+	{name: "protected-mode", envKey: "REDIS_PROTECTED_MODE", defaultValue: "yes", kind: configBool},
+	{name: "requirepass", envKey: "REDIS_REQUIREPASS", defaultValue: "", kind: configString},
+	{name: "hash-max-listpack-entries", envKey: "REDIS_HASH_MAX_LISTPACK_ENTRIES", defaultValue: "512", kind: configInt},
+	{name: "hash-max-listpack-value", envKey: "REDIS_HASH_MAX_LISTPACK_VALUE", defaultValue: "64", kind: configInt},
+	{name: "list-max-listpack-size", envKey: "REDIS_LIST_MAX_LISTPACK_SIZE", defaultValue: "-2", kind: configInt},
+	{name: "set-max-intset-entries", envKey: "REDIS_SET_MAX_INTSET_ENTRIES", defaultValue: "512", kind: configInt},
+	{name: "zset-max-listpack-entries", envKey: "REDIS_ZSET_MAX_LISTPACK_ENTRIES", defaultValue: "128", kind: configInt},
+	{name: "zset-max-listpack-value", envKey: "REDIS_ZSET_MAX_LISTPACK_VALUE", defaultValue: "64", kind: configInt},
+}
+
+// RuntimeConfig 运行时配置
+type RuntimeConfig struct {
+	values     map[string]string     // 参数名 -> 当前值（Redis 格式）
+	defs       map[string]*configDef // 参数名 -> 定义
+	configFile string                // 启动时加载的配置文件（为空表示没有配置文件）
+	mu         sync.RWMutex
+}
+
+// NewRuntimeConfig 创建运行时配置，初始值从环境变量/.env 读取
+func NewRuntimeConfig() *RuntimeConfig {
+	rc := &RuntimeConfig{
+		values: make(map[string]string),
+		defs:   make(map[string]*configDef),
+	}
+
+	for i := range configDefs {
+		def := &configDefs[i]
+		rc.defs[def.name] = def
+
+		value := def.defaultValue
+		if envValue, ok := utils.LookupEnv(def.envKey); ok && envValue != "" {
+			value = fromEnvValue(def, envValue)
+		}
+		rc.values[def.name] = value
+	}
+
+	return rc
+}
+
+// Get 获取配置值
+func (rc *RuntimeConfig) Get(name string) (string, bool) {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	value, ok := rc.values[strings.ToLower(name)]
+	return value, ok
+}
+
+// Match 返回与 glob 模式匹配的参数名（按名称排序，用于 CONFIG GET maxmemory*）
+func (rc *RuntimeConfig) Match(pattern string) []string {
+	pattern = strings.ToLower(pattern)
+
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	names := make([]string, 0)
+	for name := range rc.values {
+		if utils.GlobMatch(pattern, name) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Set 设置配置值（会校验值的格式）
+func (rc *RuntimeConfig) Set(name, value string) error {
+	name = strings.ToLower(name)
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	def, ok := rc.defs[name]
+	if !ok {
+		return ErrUnknownConfig
+	}
+
+	switch def.kind {
+	case configInt:
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return ErrInvalidConfig
+		}
+	case configBool:
+		value = strings.ToLower(value)
+		if value != "yes" && value != "no" {
+			return ErrInvalidConfig
+		}
+	case configMemory:
+		if _, err := parseMemoryValue(value); err != nil {
+			return ErrInvalidConfig
+		}
+	case configKeyspaceEvents:
+		if _, ok := parseKeyspaceEvents(value); !ok {
+			return ErrInvalidConfig
+		}
+	}
+
+	if len(def.enum) > 0 {
+		value = strings.ToLower(value)
+		if !slices.Contains(def.enum, value) {
+			return ErrInvalidConfig
+		}
+	}
+
+	rc.values[name] = value
+	return nil
+}
+
+// GetMemory 获取内存类配置（如 maxmemory）的字节数
+func (rc *RuntimeConfig) GetMemory(name string) int64 {
+	value, _ := rc.Get(name)
+	bytes, _ := parseMemoryValue(value)
+	return bytes
+}
+
+// GetInt 获取整数类配置的值（格式错误时返回 0）
+func (rc *RuntimeConfig) GetInt(name string) int64 {
+	value, _ := rc.Get(name)
+	n, _ := strconv.ParseInt(value, 10, 64)
+	return n
+}
+
+// GetBool 获取布尔类配置的值（yes 为 true）
+func (rc *RuntimeConfig) GetBool(name string) bool {
+	value, _ := rc.Get(name)
+	return value == "yes"
+}
+
+// SetConfigFile 设置配置文件路径
+func (rc *RuntimeConfig) SetConfigFile(path string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.configFile = path
+}
+
+// ConfigFile 获取配置文件路径
+func (rc *RuntimeConfig) ConfigFile() string {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+	return rc.configFile
+}
+
+// Rewrite 将当前配置写回配置文件
+func (rc *RuntimeConfig) Rewrite() error {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	if rc.configFile == "" {
+		return ErrNoConfigFile
+	}
+
+	values := make(map[string]string, len(configDefs))
+	order := make([]string, 0, len(configDefs))
+	for i := range configDefs {
+		def := &configDefs[i]
+		values[def.envKey] = toEnvValue(def, rc.values[def.name])
+		order = append(order, def.envKey)
+	}
+
+	return utils.RewriteEnvFile(rc.configFile, values, order)
+}
+
+// fromEnvValue 将 .env 中的值转换为 Redis 格式（true/false -> yes/no）
+func fromEnvValue(def *configDef, value string) string {
+	if def.kind == configBool {
+		if utils.ParseConfigBool(value, false) {
+			return "yes"
+		}
+		return "no"
+	}
+	return value
+}
+
+// toEnvValue 将 Redis 格式的值转换为 .env 格式（yes/no -> true/false）
+func toEnvValue(def *configDef, value string) string {
+	if def.kind == configBool {
+		return strconv.FormatBool(value == "yes")
+	}
+	if strings.ContainsAny(value, " #") {
+		return "\"" + value + "\""
+	}
+	return value
+}
+
+// parseMemoryValue 解析内存大小（支持 kb/mb/gb 等单位）
+func parseMemoryValue(value string) (int64, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" {
+		return 0, ErrInvalidConfig
+	}
+
+	i := len(value)
+	for i > 0 && (value[i-1] < '0' || value[i-1] > '9') {
+		i--
+	}
+
+	unit := int64(1)
+	if i < len(value) {
+		u, ok := configMemoryUnits[value[i:]]
+		if !ok {
+			return 0, ErrInvalidConfig
+		}
+		unit = u
+	}
+
+	n, err := strconv.ParseInt(value[:i], 10, 64)
+	if err != nil || n < 0 {
+		return 0, ErrInvalidConfig
+	}
+	return n * unit, nil
+}
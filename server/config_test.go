@@ -0,0 +1,57 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/code-100-precent/LingCache/protocol"
+)
+
+// TestConfigRewrite 测试 CONFIG SET 后 CONFIG REWRITE 写回配置文件
+func TestConfigRewrite(t *testing.T) {
+	ctx := newTestContext(t)
+
+	configFile := filepath.Join(t.TempDir(), ".env.test")
+	if err := os.WriteFile(configFile, []byte("# LingCache\nREDIS_ADDR=:6380\nREDIS_MAXMEMORY=0\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	ctx.Server.SetConfigFile(configFile)
+
+	if r := execCommand(ctx, "CONFIG", "SET", "maxmemory", "100mb"); r.Type == protocol.RESP_ERROR {
+		t.Fatalf("CONFIG SET failed: %s", r.Str)
+	}
+	if r := execCommand(ctx, "CONFIG", "REWRITE"); r.Type == protocol.RESP_ERROR {
+		t.Fatalf("CONFIG REWRITE failed: %s", r.Str)
+	}
+
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		t.Fatalf("Failed to read config file: %v", err)
+	}
+	content := string(data)
+	if !strings.Contains(content, "REDIS_MAXMEMORY=100mb") {
+		t.Fatalf("Rewritten config should contain new maxmemory, got:\n%s", content)
+	}
+	if !strings.Contains(content, "REDIS_ADDR=:6380") || !strings.Contains(content, "# LingCache") {
+		t.Fatalf("Rewritten config should keep existing lines, got:\n%s", content)
+	}
+	if strings.Count(content, "REDIS_MAXMEMORY=") != 1 {
+		t.Fatalf("maxmemory should be updated in place, got:\n%s", content)
+	}
+
+	t.Log("CONFIG REWRITE test passed")
+}
+
+// TestConfigRewriteWithoutFile 测试没有配置文件时 CONFIG REWRITE 返回错误
+func TestConfigRewriteWithoutFile(t *testing.T) {
+	ctx := newTestContext(t)
+
+	r := execCommand(ctx, "CONFIG", "REWRITE")
+	if r.Type != protocol.RESP_ERROR {
+		t.Fatal("CONFIG REWRITE without config file should fail")
+	}
+
+	t.Log("CONFIG REWRITE without file test passed")
+}
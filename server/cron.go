@@ -0,0 +1,181 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/code-100-precent/LingCache/structure"
+)
+
+/*
+ * ============================================================================
+ * 定时任务（serverCron）
+ * ============================================================================
+ *
+ * 与 Redis 的 serverCron 一致，按 hz 配置的频率（每秒 hz 次）执行后台任务：
+ * - 客户端超时：关闭空闲时间超过 timeout 秒的客户端
+ * - 保存点检查：满足 save 配置中任意一个 "秒数 修改次数" 条件时触发后台保存
+ *
+ * 主动过期（抽样删除已过期但没有被访问的键）由 storage.RedisServer 的后台任务执行，
+ * 同样按 hz 的频率运行，随服务器启动和停止。
+ *
+ * 【hz】
+ * 取值范围 1~500（与 Redis 相同），超出范围时截断。
+ * CONFIG SET hz 会立即通知 serverCron 和主动过期任务调整频率。
+ */
+
+const (
+	configMinHz = 1
+	configMaxHz = 500
+)
+
+// serverCron 定时任务主循环（Start 时启动，服务器停止后退出）
+func (s *Server) serverCron() {
+	hz := s.cronHz()
+	ticker := time.NewTicker(time.Second / time.Duration(hz))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.hzChanged:
+			if newHz := s.cronHz(); newHz != hz {
+				hz = newHz
+				ticker.Reset(time.Second / time.Duration(hz))
+			}
+			continue
+		}
+
+		if !s.running.Load() {
+			return
+		}
+
+		s.clientsCron()
+		s.saveCron()
+	}
+}
+
+// cronHz 获取当前的 hz 配置（截断到有效范围）
+func (s *Server) cronHz() int {
+	hz := int(s.config.GetInt("hz"))
+	if hz < configMinHz {
+		hz = configMinHz
+	}
+	if hz > configMaxHz {
+		hz = configMaxHz
+	}
+	return hz
+}
+
+// onConfigSet CONFIG SET 修改参数后，让需要立即生效的参数生效
+func (s *Server) onConfigSet(name string) {
+	switch name {
+	case "hz":
+		s.redisServer.SetActiveExpireHz(s.cronHz())
+		select {
+		case s.hzChanged <- struct{}{}:
+		default:
+		}
+	case "repl-backlog-size":
+		s.master.SetBacklogSize(s.config.GetMemory(name))
+	case "appendfsync":
+		if s.aofWriter != nil {
+			value, _ := s.config.Get(name)
+			s.aofWriter.SetFsyncPolicy(value)
+		}
+	default:
+		if structure.IsEncodingLimit(name) {
+			structure.SetEncodingLimit(name, s.config.GetInt(name))
+		}
+	}
+}
+
+// applyEncodingLimits 将配置中的编码转换阈值应用到数据结构（启动时调用）
+func (s *Server) applyEncodingLimits() {
+	for i := range configDefs {
+		if name := configDefs[i].name; structure.IsEncodingLimit(name) {
+			structure.SetEncodingLimit(name, s.config.GetInt(name))
+		}
+	}
+}
+
+// clientsCron 关闭空闲超时的客户端（订阅中和阻塞中的客户端除外）
+func (s *Server) clientsCron() {
+	timeout := s.config.GetInt("timeout")
+	if timeout <= 0 {
+		return
+	}
+
+	deadline := time.Now().UnixMilli() - timeout*1000
+
+	s.mu.RLock()
+	idle := make([]*Client, 0)
+	for _, client := range s.clients {
+		if client.lastActive.Load() < deadline {
+			idle = append(idle, client)
+		}
+	}
+	s.mu.RUnlock()
+
+	// Client.Close 会获取 s.mu，在锁外关闭
+	for _, client := range idle {
+		if s.pubsub.IsSubscribed(client) || s.blockingMgr.IsBlocked(client) {
+			continue
+		}
+		client.Close()
+	}
+}
+
+// saveCron 检查 save 保存点，满足条件时触发后台保存
+func (s *Server) saveCron() {
+	dirty := s.redisServer.Dirty()
+	if dirty == 0 {
+		return
+	}
+
+	value, _ := s.config.Get("save")
+	params, err := parseSaveParams(value)
+	if err != nil {
+		return
+	}
+
+	elapsed := time.Now().Unix() - s.redisServer.LastSave()
+	for _, param := range params {
+		if elapsed >= param.seconds && dirty >= param.changes {
+			if s.startBackgroundSave() {
+				fmt.Printf("%d changes in %d seconds. Saving...\n", param.changes, param.seconds)
+			}
+			return
+		}
+	}
+}
+
+// saveParam 保存点：seconds 秒内至少 changes 次修改
+type saveParam struct {
+	seconds int64
+	changes int64
+}
+
+// parseSaveParams 解析 save 配置（"秒数 修改次数" 成对出现，空字符串表示关闭自动保存）
+func parseSaveParams(value string) ([]saveParam, error) {
+	fields := strings.Fields(value)
+	if len(fields)%2 != 0 {
+		return nil, ErrInvalidConfig
+	}
+
+	params := make([]saveParam, 0, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err := strconv.ParseInt(fields[i], 10, 64)
+		if err != nil || seconds < 1 {
+			return nil, ErrInvalidConfig
+		}
+		changes, err := strconv.ParseInt(fields[i+1], 10, 64)
+		if err != nil || changes < 0 {
+			return nil, ErrInvalidConfig
+		}
+		params = append(params, saveParam{seconds: seconds, changes: changes})
+	}
+	return params, nil
+}
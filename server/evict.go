@@ -0,0 +1,152 @@
+package server
+
+import (
+	"math/rand"
+
+	"github.com/code-100-precent/LingCache/storage"
+)
+
+/*
+ * ============================================================================
+ * maxmemory 淘汰
+ * ============================================================================
+ *
+ * 设置了 maxmemory 后，执行可能增加内存的写命令之前先检查已使用内存，
+ * 超过上限时按 maxmemory-policy 淘汰键，直到释放的内存足以回到上限以下：
+ *
+ * - noeviction: 不淘汰，写命令返回 OOM 错误
+ * - allkeys-lru / volatile-lru: 淘汰最久未访问的键
+ * - allkeys-lfu / volatile-lfu: 淘汰访问频率最低的键
+ * - allkeys-random / volatile-random: 随机淘汰
+ * - volatile-ttl: 淘汰最快过期的键
+ *
+ * allkeys-* 从所有键中选择，volatile-* 只从设置了过期时间的键中选择。
+ * 与 Redis 一样使用近似算法：每个数据库抽样 maxmemory-samples 个键，
+ * 从所有抽样中选出最适合淘汰的一个，而不是维护全局有序结构。
+ *
+ * 已淘汰对象占用的内存要等到 GC 才真正回收，释放量通过 MemoryStats.Release 先行扣除，
+ * 避免下一个写命令看到尚未回收的内存而继续淘汰。已使用内存按 MEMORY_SAMPLE_INTERVAL 采样，
+ * 不在每个写命令上读取运行时统计。
+ *
+ * 被淘汰的键以 DEL 写入 AOF 并传播给从节点（在触发淘汰的写命令之前），
+ * 保证重放 AOF 和从节点得到与主节点相同的键空间。
+ */
+
+// maxmemoryPolicies 支持的淘汰策略
+var maxmemoryPolicies = []string{
+	"noeviction",
+	"allkeys-lru", "allkeys-lfu", "allkeys-random",
+	"volatile-lru", "volatile-lfu", "volatile-random", "volatile-ttl",
+}
+
+// EVICTION_SIZE_SAMPLES 估算被淘汰对象大小时集合类型抽样的元素个数
+const EVICTION_SIZE_SAMPLES = 5
+
+// evictionCandidate 淘汰候选键
+type evictionCandidate struct {
+	db    *storage.RedisDb
+	key   string
+	score float64 // 越大越应该被淘汰
+}
+
+// denyOOM 命令在内存超限时是否应被拒绝（带 CMD_DENYOOM 标志，可能增加内存的写命令）
+// 加载 AOF 期间不拒绝（重放的是已经执行过的命令）
+func (s *Server) denyOOM(cmdName string) bool {
+	return !s.loading.Load() && s.commandFlags(cmdName)&CMD_DENYOOM != 0
+}
+
+// performEvictions 已使用内存超过 maxmemory 时按淘汰策略淘汰键。
+// 返回 false 表示无法回到上限以下（noeviction 或没有可淘汰的键），调用方应拒绝写命令
+func (s *Server) performEvictions() bool {
+	maxmemory := s.config.GetMemory("maxmemory")
+	if maxmemory <= 0 {
+		return true
+	}
+
+	s.memoryStats.Sample()
+	used := s.memoryStats.GetUsedMemory()
+	if used <= maxmemory {
+		return true
+	}
+
+	policy := s.maxmemoryPolicy()
+	if policy == "noeviction" {
+		return false
+	}
+
+	toFree := used - maxmemory
+	var freed int64
+	for freed < toFree {
+		candidate, ok := s.evictionCandidate(policy)
+		if !ok {
+			break
+		}
+
+		obj, ok := candidate.db.Unlink(candidate.key)
+		if !ok {
+			continue
+		}
+		freed += int64(len(candidate.key)) + obj.SizeInBytes(EVICTION_SIZE_SAMPLES)
+		obj.DecrRefCount()
+		s.propagateDeletion(candidate.db, candidate.key)
+
+		s.stats.RecordEvictedKey()
+		s.notifyKeyspaceEvent(NOTIFY_EVICTED, "evicted", candidate.key, candidate.db.GetID())
+	}
+
+	s.memoryStats.Release(freed)
+	return freed >= toFree
+}
+
+// evictionCandidate 按淘汰策略从各数据库的抽样中选出最适合淘汰的键
+func (s *Server) evictionCandidate(policy string) (evictionCandidate, bool) {
+	volatile := policy == "volatile-lru" || policy == "volatile-lfu" ||
+		policy == "volatile-random" || policy == "volatile-ttl"
+	random := policy == "allkeys-random" || policy == "volatile-random"
+
+	samples := int(s.config.GetInt("maxmemory-samples"))
+	if samples <= 0 {
+		samples = 5
+	}
+	if random {
+		samples = 1
+	}
+
+	var best evictionCandidate
+	found := false
+	seen := 0
+
+	redisServer := s.GetRedisServer()
+	for i := 0; i < redisServer.GetDbNum(); i++ {
+		db, err := redisServer.GetDb(i)
+		if err != nil {
+			continue
+		}
+
+		db.SampleKeys(samples, volatile, func(key string, obj *storage.RedisObject, expireAt int64) {
+			var score float64
+			switch {
+			case random:
+				// 蓄水池抽样：每个数据库的候选以相同概率被选中
+				seen++
+				if rand.Intn(seen) != 0 {
+					return
+				}
+			case policy == "volatile-ttl":
+				score = -float64(expireAt)
+			case isLFUPolicy(policy):
+				score = float64(255 - obj.Freq())
+			default:
+				// 最近访问时间越早越应该被淘汰
+				score = -float64(obj.LastAccess())
+			}
+
+			if !found || random || score > best.score {
+				best = evictionCandidate{db: db, key: key, score: score}
+				found = true
+			}
+		})
+	}
+
+	return best, found
+}
@@ -3,7 +3,10 @@ package server
 import (
 	"fmt"
 	"runtime"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/code-100-precent/LingCache/storage"
 )
@@ -18,11 +21,19 @@ import (
  * - 内存统计
  */
 
+// MEMORY_SAMPLE_INTERVAL 写命令检查 maxmemory 时重新读取运行时内存统计的最小间隔
+const MEMORY_SAMPLE_INTERVAL = 100 * time.Millisecond
+
+// OBJ_SHARED_INTEGERS 共享整数对象的个数（0 ~ OBJ_SHARED_INTEGERS-1）
+const OBJ_SHARED_INTEGERS = 10000
+
 // SharedObjects 共享对象
+//
+// 共享对象创建后只读，引用计数固定为 storage.OBJ_SHARED_REFCOUNT，
+// 多个连接并发获取时不需要加锁，也不修改引用计数
 type SharedObjects struct {
 	integers map[int64]*storage.RedisObject // 共享的小整数
 	emptyStr *storage.RedisObject           // 共享的空字符串
-	mu       sync.RWMutex
 }
 
 // NewSharedObjects 创建共享对象管理器
@@ -32,42 +43,78 @@ func NewSharedObjects() *SharedObjects {
 	}
 
 	// 预创建 0-9999 的小整数
-	for i := int64(0); i < 10000; i++ {
-		so.integers[i] = storage.NewStringObject([]byte(string(rune(i))))
+	for i := int64(0); i < OBJ_SHARED_INTEGERS; i++ {
+		so.integers[i] = storage.NewStringObject([]byte(strconv.FormatInt(i, 10))).MakeShared()
 	}
 
 	// 创建共享的空字符串
-	so.emptyStr = storage.NewStringObject([]byte(""))
+	so.emptyStr = storage.NewStringObject([]byte("")).MakeShared()
 
 	return so
 }
 
 // GetSharedInteger 获取共享的整数对象
 func (so *SharedObjects) GetSharedInteger(val int64) *storage.RedisObject {
-	if val >= 0 && val < 10000 {
-		so.mu.RLock()
-		defer so.mu.RUnlock()
-		if obj, exists := so.integers[val]; exists {
-			obj.IncrRefCount()
-			return obj
-		}
+	if val >= 0 && val < OBJ_SHARED_INTEGERS {
+		return so.integers[val]
 	}
 	return nil
 }
 
 // GetSharedEmptyString 获取共享的空字符串对象
 func (so *SharedObjects) GetSharedEmptyString() *storage.RedisObject {
-	so.mu.RLock()
-	defer so.mu.RUnlock()
-	so.emptyStr.IncrRefCount()
 	return so.emptyStr
 }
 
+// createStringObject 创建字符串对象
+// 值为 0 ~ 9999 的规范整数时复用共享对象（OBJECT REFCOUNT 为 OBJ_SHARED_REFCOUNT）；
+// 设置了 maxmemory 且使用 LRU/LFU 淘汰策略时不共享，因为每个键需要独立的访问时间/频率
+func (s *Server) createStringObject(value []byte) *storage.RedisObject {
+	if len(value) <= 4 && s.sharedIntegersAllowed() {
+		if n, err := strconv.ParseInt(string(value), 10, 64); err == nil && strconv.FormatInt(n, 10) == string(value) {
+			if obj := s.sharedObjects.GetSharedInteger(n); obj != nil {
+				return obj
+			}
+		}
+	}
+	return storage.NewStringObject(value)
+}
+
+// sharedIntegersAllowed 当前淘汰策略下是否允许使用共享整数
+func (s *Server) sharedIntegersAllowed() bool {
+	if s.config.GetMemory("maxmemory") == 0 {
+		return true
+	}
+	return !isLRUOrLFUPolicy(s.maxmemoryPolicy())
+}
+
+// maxmemoryPolicy 当前的 maxmemory-policy
+func (s *Server) maxmemoryPolicy() string {
+	policy, _ := s.config.Get("maxmemory-policy")
+	return strings.ToLower(policy)
+}
+
+// isLFUPolicy 是否为 LFU 淘汰策略
+func isLFUPolicy(policy string) bool {
+	return strings.HasSuffix(policy, "-lfu")
+}
+
+// isLRUOrLFUPolicy 是否为 LRU 或 LFU 淘汰策略
+func isLRUOrLFUPolicy(policy string) bool {
+	return strings.HasSuffix(policy, "-lru") || isLFUPolicy(policy)
+}
+
 // MemoryStats 内存统计
+//
+// 已使用内存取自 Go 运行时的堆分配量。淘汰键之后内存要等到下一次 GC 才真正回收，
+// 因此 Release 记录的释放量会一直从堆分配量中扣除，直到发生新的 GC
 type MemoryStats struct {
 	usedMemory      int64
 	usedMemoryPeak  int64
 	usedMemoryHuman string
+	releasedBytes   int64     // 上次 GC 以来淘汰释放（尚未被回收）的字节数
+	lastNumGC       uint32    // 上次更新时的 GC 次数
+	lastUpdate      time.Time // 上次更新的时间
 	mu              sync.RWMutex
 }
 
@@ -83,7 +130,15 @@ func (ms *MemoryStats) Update() {
 
 	var m runtime.MemStats
 	runtime.ReadMemStats(&m)
-	ms.usedMemory = int64(m.Alloc)
+	ms.lastUpdate = time.Now()
+	if m.NumGC != ms.lastNumGC {
+		ms.lastNumGC = m.NumGC
+		ms.releasedBytes = 0
+	}
+	ms.usedMemory = int64(m.Alloc) - ms.releasedBytes
+	if ms.usedMemory < 0 {
+		ms.usedMemory = 0
+	}
 
 	if ms.usedMemory > ms.usedMemoryPeak {
 		ms.usedMemoryPeak = ms.usedMemory
@@ -93,6 +148,30 @@ func (ms *MemoryStats) Update() {
 	ms.usedMemoryHuman = formatBytes(ms.usedMemory)
 }
 
+// Sample 距上次更新超过 MEMORY_SAMPLE_INTERVAL 时才更新内存统计。
+// runtime.ReadMemStats 需要暂停所有 goroutine，不能在每个写命令上调用，
+// 两次采样之间的淘汰由 Release 扣除
+func (ms *MemoryStats) Sample() {
+	ms.mu.RLock()
+	fresh := time.Since(ms.lastUpdate) < MEMORY_SAMPLE_INTERVAL
+	ms.mu.RUnlock()
+	if !fresh {
+		ms.Update()
+	}
+}
+
+// Release 记录淘汰释放的内存（在下一次 GC 之前从已使用内存中扣除）
+func (ms *MemoryStats) Release(bytes int64) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.releasedBytes += bytes
+	ms.usedMemory -= bytes
+	if ms.usedMemory < 0 {
+		ms.usedMemory = 0
+	}
+}
+
 // GetUsedMemory 获取已使用内存
 func (ms *MemoryStats) GetUsedMemory() int64 {
 	ms.mu.RLock()
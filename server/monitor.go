@@ -0,0 +1,160 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/code-100-precent/LingCache/protocol"
+)
+
+/*
+ * ============================================================================
+ * MONITOR 实现
+ * ============================================================================
+ *
+ * 执行 MONITOR 的客户端进入监视模式，此后任何客户端执行的命令都会以
+ * 状态回复的形式推送给它，格式与 Redis 一致：
+ *
+ *   +1339518083.107412 [0 127.0.0.1:60866] "set" "foo" "bar"
+ *
+ * - 时间戳精确到微秒
+ * - 方括号内为执行命令的客户端所在的数据库和地址
+ * - 命令名和参数按原样输出并加引号，不可打印字符转义为 \xHH
+ *
+ * 推送发生在 CommandTable.ExecuteCommand 中（参数校验通过、执行之前），
+ * 事务中入队的命令在 EXEC 真正执行时推送。
+ * 管理类命令（CONFIG、DEBUG 等）以及带密码的 AUTH 不会推送；
+ * 监视客户端自己执行的命令也不会推送给它自己
+ */
+
+// monitorSkipCommands 不推送给监视客户端的命令
+var monitorSkipCommands = map[string]bool{
+	"MONITOR":   true,
+	"CONFIG":    true,
+	"DEBUG":     true,
+	"SHUTDOWN":  true,
+	"AUTH":      true,
+	"HELLO":     true,
+	"SLAVEOF":   true,
+	"REPLICAOF": true,
+	"SYNC":      true,
+	"PSYNC":     true,
+}
+
+// MonitorManager 监视客户端管理器
+type MonitorManager struct {
+	clients map[*Client]bool
+	mu      sync.RWMutex
+}
+
+// NewMonitorManager 创建监视客户端管理器
+func NewMonitorManager() *MonitorManager {
+	return &MonitorManager{
+		clients: make(map[*Client]bool),
+	}
+}
+
+// Add 将客户端加入监视列表
+func (mm *MonitorManager) Add(client *Client) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	mm.clients[client] = true
+}
+
+// Remove 将客户端移出监视列表（客户端断开时调用）
+func (mm *MonitorManager) Remove(client *Client) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	delete(mm.clients, client)
+}
+
+// IsMonitor 客户端是否处于监视模式
+func (mm *MonitorManager) IsMonitor(client *Client) bool {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+	return mm.clients[client]
+}
+
+// Feed 将 client 执行的命令推送给所有监视客户端
+func (mm *MonitorManager) Feed(client *Client, req *protocol.RESPValue) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	if len(mm.clients) == 0 {
+		return
+	}
+
+	args := req.GetArray()
+	if len(args) == 0 || monitorSkipCommands[toUpper(args[0].ToString())] {
+		return
+	}
+
+	var line *protocol.RESPValue
+	for monitor := range mm.clients {
+		if monitor == client {
+			continue
+		}
+		if line == nil {
+			line = protocol.NewSimpleString(formatMonitorLine(client, args))
+		}
+		monitor.writeResponse(line)
+	}
+}
+
+// formatMonitorLine 生成一条监视输出：<时间戳> [<db> <addr>] "cmd" "arg"...
+func formatMonitorLine(client *Client, args []*protocol.RESPValue) string {
+	now := time.Now()
+
+	var line strings.Builder
+	line.WriteString(fmt.Sprintf("%d.%06d ", now.Unix(), now.Nanosecond()/1000))
+
+	addr := "unknown"
+	if client != nil && client.conn != nil && client.conn.RemoteAddr() != nil {
+		addr = client.conn.RemoteAddr().String()
+	}
+	dbIndex := 0
+	if client != nil {
+		dbIndex = client.dbIndex
+	}
+	line.WriteString(fmt.Sprintf("[%d %s]", dbIndex, addr))
+
+	for _, arg := range args {
+		line.WriteByte(' ')
+		line.WriteString(quoteMonitorArg(arg.ToString()))
+	}
+	return line.String()
+}
+
+// quoteMonitorArg 按 Redis 的 sdscatrepr 规则给参数加引号并转义
+func quoteMonitorArg(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '\\', '"':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\n':
+			b.WriteString("\\n")
+		case '\r':
+			b.WriteString("\\r")
+		case '\t':
+			b.WriteString("\\t")
+		case '\a':
+			b.WriteString("\\a")
+		case '\b':
+			b.WriteString("\\b")
+		default:
+			if c < 0x20 || c > 0x7e {
+				b.WriteString(fmt.Sprintf("\\x%02x", c))
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -1,9 +1,11 @@
 package server
 
 import (
+	"strconv"
 	"sync"
 
 	"github.com/code-100-precent/LingCache/protocol"
+	"github.com/code-100-precent/LingCache/utils"
 )
 
 /*
@@ -18,20 +20,63 @@ import (
  * - PSUBSCRIBE: 模式订阅
  * - PUNSUBSCRIBE: 取消模式订阅
  * - PUBSUB: 查看订阅信息
+ *
+ * 【分片发布订阅】
+ * SSUBSCRIBE / SPUBLISH 使用独立的分片频道表。集群模式下频道和键一样按
+ * HashSlot 归属到某个节点，消息只在负责该槽的节点上分发，不会广播到整个集群
+ *
+ * 【键空间通知】
+ * 由 notify-keyspace-events 配置开启（默认关闭），事件发布到两个频道：
+ * - __keyspace@<db>__:<key>   消息为事件名（K）
+ * - __keyevent@<db>__:<event> 消息为键名（E）
  */
 
+// 键空间通知类型（对应 notify-keyspace-events 的字符）
+const (
+	NOTIFY_KEYSPACE = 1 << iota // K
+	NOTIFY_KEYEVENT             // E
+	NOTIFY_GENERIC              // g
+	NOTIFY_STRING               // $
+	NOTIFY_LIST                 // l
+	NOTIFY_SET                  // s
+	NOTIFY_HASH                 // h
+	NOTIFY_ZSET                 // z
+	NOTIFY_EXPIRED              // x
+	NOTIFY_EVICTED              // e
+
+	NOTIFY_ALL = NOTIFY_GENERIC | NOTIFY_STRING | NOTIFY_LIST | NOTIFY_SET |
+		NOTIFY_HASH | NOTIFY_ZSET | NOTIFY_EXPIRED | NOTIFY_EVICTED // A
+)
+
+// keyspaceEventFlags 配置字符 -> 通知类型
+var keyspaceEventFlags = map[rune]int{
+	'K': NOTIFY_KEYSPACE,
+	'E': NOTIFY_KEYEVENT,
+	'g': NOTIFY_GENERIC,
+	'$': NOTIFY_STRING,
+	'l': NOTIFY_LIST,
+	's': NOTIFY_SET,
+	'h': NOTIFY_HASH,
+	'z': NOTIFY_ZSET,
+	'x': NOTIFY_EXPIRED,
+	'e': NOTIFY_EVICTED,
+	'A': NOTIFY_ALL,
+}
+
 // PubSubManager 发布订阅管理器
 type PubSubManager struct {
-	channels map[string]map[*Client]bool // 频道 -> 客户端集合
-	patterns map[string]map[*Client]bool // 模式 -> 客户端集合
-	mu       sync.RWMutex
+	channels      map[string]map[*Client]bool // 频道 -> 客户端集合
+	patterns      map[string]map[*Client]bool // 模式 -> 客户端集合
+	shardChannels map[string]map[*Client]bool // 分片频道 -> 客户端集合
+	mu            sync.RWMutex
 }
 
 // NewPubSubManager 创建发布订阅管理器
 func NewPubSubManager() *PubSubManager {
 	return &PubSubManager{
-		channels: make(map[string]map[*Client]bool),
-		patterns: make(map[string]map[*Client]bool),
+		channels:      make(map[string]map[*Client]bool),
+		patterns:      make(map[string]map[*Client]bool),
+		shardChannels: make(map[string]map[*Client]bool),
 	}
 }
 
@@ -87,6 +132,147 @@ func (ps *PubSubManager) PUnsubscribe(client *Client, pattern string) int {
 	return 0
 }
 
+// RemoveClient 取消客户端的所有频道和模式订阅（客户端断开时调用）
+func (ps *PubSubManager) RemoveClient(client *Client) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	for channel, clients := range ps.channels {
+		delete(clients, client)
+		if len(clients) == 0 {
+			delete(ps.channels, channel)
+		}
+	}
+	for pattern, clients := range ps.patterns {
+		delete(clients, client)
+		if len(clients) == 0 {
+			delete(ps.patterns, pattern)
+		}
+	}
+	for channel, clients := range ps.shardChannels {
+		delete(clients, client)
+		if len(clients) == 0 {
+			delete(ps.shardChannels, channel)
+		}
+	}
+}
+
+// IsSubscribed 客户端是否订阅了任何频道或模式
+func (ps *PubSubManager) IsSubscribed(client *Client) bool {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	for _, clients := range ps.channels {
+		if clients[client] {
+			return true
+		}
+	}
+	for _, clients := range ps.patterns {
+		if clients[client] {
+			return true
+		}
+	}
+	for _, clients := range ps.shardChannels {
+		if clients[client] {
+			return true
+		}
+	}
+	return false
+}
+
+// SSubscribe 订阅分片频道，返回客户端订阅的分片频道数
+func (ps *PubSubManager) SSubscribe(client *Client, channel string) int {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if ps.shardChannels[channel] == nil {
+		ps.shardChannels[channel] = make(map[*Client]bool)
+	}
+	ps.shardChannels[channel][client] = true
+	return ps.shardCountLocked(client)
+}
+
+// SUnsubscribe 取消订阅分片频道，返回客户端剩余的分片频道数
+func (ps *PubSubManager) SUnsubscribe(client *Client, channel string) int {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if clients, exists := ps.shardChannels[channel]; exists {
+		delete(clients, client)
+		if len(clients) == 0 {
+			delete(ps.shardChannels, channel)
+		}
+	}
+	return ps.shardCountLocked(client)
+}
+
+// ClientShardChannels 获取客户端订阅的所有分片频道
+func (ps *PubSubManager) ClientShardChannels(client *Client) []string {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	channels := make([]string, 0)
+	for channel, clients := range ps.shardChannels {
+		if clients[client] {
+			channels = append(channels, channel)
+		}
+	}
+	return channels
+}
+
+// shardCountLocked 客户端订阅的分片频道数（调用方持有锁）
+func (ps *PubSubManager) shardCountLocked(client *Client) int {
+	count := 0
+	for _, clients := range ps.shardChannels {
+		if clients[client] {
+			count++
+		}
+	}
+	return count
+}
+
+// SPublish 向分片频道发布消息，返回收到消息的客户端数
+func (ps *PubSubManager) SPublish(channel string, message string) int {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	clients := ps.shardChannels[channel]
+	if len(clients) == 0 {
+		return 0
+	}
+
+	resp := protocol.NewArray([]*protocol.RESPValue{
+		protocol.NewBulkString("smessage"),
+		protocol.NewBulkString(channel),
+		protocol.NewBulkString(message),
+	})
+	for client := range clients {
+		client.writeResponse(resp)
+	}
+	return len(clients)
+}
+
+// ShardNumSub 获取分片频道的订阅者数量
+func (ps *PubSubManager) ShardNumSub(channel string) int {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	return len(ps.shardChannels[channel])
+}
+
+// ShardChannels 获取匹配 pattern 的分片频道（pattern 为空表示全部）
+func (ps *PubSubManager) ShardChannels(pattern string) []string {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	channels := make([]string, 0, len(ps.shardChannels))
+	for channel := range ps.shardChannels {
+		if pattern == "" || utils.GlobMatch(pattern, channel) {
+			channels = append(channels, channel)
+		}
+	}
+	return channels
+}
+
 // Publish 发布消息
 func (ps *PubSubManager) Publish(channel string, message string) int {
 	ps.mu.RLock()
@@ -108,7 +294,22 @@ func (ps *PubSubManager) Publish(channel string, message string) int {
 		}
 	}
 
-	// 发送给模式订阅者（简化实现：不匹配模式）
+	// 发送给模式订阅者：*4\r\n$8\r\npmessage\r\n<pattern>\r\n<channel>\r\n<message>\r\n
+	for pattern, clients := range ps.patterns {
+		if !utils.GlobMatch(pattern, channel) {
+			continue
+		}
+		for client := range clients {
+			resp := protocol.NewArray([]*protocol.RESPValue{
+				protocol.NewBulkString("pmessage"),
+				protocol.NewBulkString(pattern),
+				protocol.NewBulkString(channel),
+				protocol.NewBulkString(message),
+			})
+			client.writeResponse(resp)
+			count++
+		}
+	}
 
 	return count
 }
@@ -143,3 +344,33 @@ func (ps *PubSubManager) Channels() []string {
 	}
 	return channels
 }
+
+// parseKeyspaceEvents 解析 notify-keyspace-events 配置
+func parseKeyspaceEvents(value string) (int, bool) {
+	flags := 0
+	for _, c := range value {
+		flag, ok := keyspaceEventFlags[c]
+		if !ok {
+			return 0, false
+		}
+		flags |= flag
+	}
+	return flags, true
+}
+
+// notifyKeyspaceEvent 发布键空间通知（未开启对应类型时不做任何事）
+func (s *Server) notifyKeyspaceEvent(class int, event, key string, dbid int) {
+	value, _ := s.config.Get("notify-keyspace-events")
+	flags, _ := parseKeyspaceEvents(value)
+	if flags&class == 0 {
+		return
+	}
+
+	db := strconv.Itoa(dbid)
+	if flags&NOTIFY_KEYSPACE != 0 {
+		s.pubsub.Publish("__keyspace@"+db+"__:"+key, event)
+	}
+	if flags&NOTIFY_KEYEVENT != 0 {
+		s.pubsub.Publish("__keyevent@"+db+"__:"+event, key)
+	}
+}
@@ -2,12 +2,16 @@ package server
 
 import (
 	"bufio"
+	"crypto/subtle"
 	"fmt"
+	"io"
 	"net"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/code-100-precent/LingCache/cluster"
@@ -35,34 +39,62 @@ type Server struct {
 	redisServer    *storage.RedisServer
 	cmdTable       *CommandTable
 	listener       net.Listener
-	clients        map[*Client]bool
+	clients        map[int64]*Client // 客户端 ID -> 客户端
+	nextClientID   atomic.Int64      // 下一个客户端 ID（单调递增，从 1 开始）
 	pubsub         *PubSubManager
+	monitors       *MonitorManager
 	stats          *Stats
+	slowlog        *SlowLog
 	blockingMgr    *BlockingManager
 	aofWriter      *persistence.AOFWriter
 	sharedObjects  *SharedObjects
 	memoryStats    *MemoryStats
 	rdbFilename    string
 	aofFilename    string
-	master         *replication.Master // 主节点（如果当前节点是主节点）
-	cluster        *cluster.Cluster    // 集群（如果启用集群模式）
-	clusterEnabled bool                // 是否启用集群模式
+	aofLoaded      bool                   // 启动时已从 AOF 恢复数据（此时不再加载 RDB）
+	loading        atomic.Bool            // 正在从 AOF 加载数据
+	master         *replication.Master    // 主节点（如果当前节点是主节点）
+	slave          *replication.Slave     // 与主节点的复制连接（执行了 SLAVEOF 时，受 mu 保护）
+	cluster        *cluster.Cluster       // 集群（如果启用集群模式）
+	clusterEnabled bool                   // 是否启用集群模式
+	config         *RuntimeConfig         // 运行时配置（CONFIG 命令）
+	bgsaveRunning  atomic.Bool            // 是否有 BGSAVE 正在进行
+	aofRewriting   atomic.Bool            // 是否有 BGREWRITEAOF 正在进行
+	aofRewriteErr  atomic.Bool            // 上一次 AOF 重写是否失败（aof_last_bgrewrite_status）
+	hzChanged      chan struct{}          // CONFIG SET hz 后通知 serverCron 调整频率
+	lazyFree       *storage.LazyFreeQueue // UNLINK 的后台释放队列
+	done           chan struct{}          // Stop 后关闭（SHUTDOWN 通过它通知进程退出）
+	stopOnce       sync.Once
+	writeBarrier   sync.RWMutex // 写命令从执行到传播完成期间持有读锁，全量同步复制键空间时持有写锁
 	mu             sync.RWMutex
-	running        bool
+	running        atomic.Bool
 }
 
 // Client 客户端连接
 type Client struct {
-	conn        net.Conn
-	reader      *bufio.Reader
-	writer      *bufio.Writer
-	server      *Server
-	db          *storage.RedisDb
-	dbIndex     int // 当前选择的数据库索引
-	closed      bool
-	transaction *Transaction    // 事务（如果处于事务模式）
-	inMulti     bool            // 是否在 MULTI 模式
-	pipeline    *PipelineBuffer // 管道缓冲区
+	id            int64 // 客户端 ID（CLIENT ID，服务器内唯一且单调递增）
+	conn          net.Conn
+	reader        *bufio.Reader
+	writer        *bufio.Writer
+	server        *Server
+	db            *storage.RedisDb
+	dbIndex       int // 当前选择的数据库索引
+	closed        bool
+	transaction   *Transaction     // 事务（如果处于事务模式）
+	inMulti       bool             // 是否在 MULTI 模式
+	pipeline      *PipelineBuffer  // 管道缓冲区
+	quitting      bool             // 回复发送后关闭连接（QUIT）
+	asking        bool             // 执行过 ASKING：下一条命令可以访问本节点正在导入的槽
+	writeMu       sync.Mutex       // 串行化对连接的写入（PUBLISH 可能在其它 goroutine 中写同一个客户端）
+	lastActive    atomic.Int64     // 最近一次收到请求的时间（Unix 毫秒，用于空闲超时）
+	resp          atomic.Int32     // 协议版本（2 或 3，由 HELLO 切换；0 视为 2）
+	name          string           // 客户端名称（CLIENT SETNAME / HELLO SETNAME）
+	ctime         time.Time        // 连接建立时间（CLIENT LIST 的 age）
+	noEvict       bool             // CLIENT NO-EVICT：内存淘汰时不驱逐该客户端
+	authenticated bool             // 是否已通过 AUTH / HELLO AUTH 认证
+	watchedKeys   []*watchedKeyRef // WATCH 监视的键
+	replPort      int              // 从节点通过 REPLCONF listening-port 报告的端口
+	woff          int64            // 最近一次写命令传播后的主节点复制偏移量（WAIT 等待从节点确认到该偏移量）
 }
 
 // NewServer 创建新的服务器
@@ -72,9 +104,11 @@ func NewServer(addr string, dbnum int) *Server {
 		addr:           addr,
 		redisServer:    redisServer,
 		cmdTable:       NewCommandTable(),
-		clients:        make(map[*Client]bool),
+		clients:        make(map[int64]*Client),
 		pubsub:         NewPubSubManager(),
+		monitors:       NewMonitorManager(),
 		stats:          NewStats(),
+		slowlog:        NewSlowLog(),
 		blockingMgr:    NewBlockingManager(),
 		sharedObjects:  NewSharedObjects(),
 		memoryStats:    NewMemoryStats(),
@@ -82,15 +116,38 @@ func NewServer(addr string, dbnum int) *Server {
 		aofFilename:    "appendonly.aof",
 		master:         replication.NewMaster(redisServer), // 默认作为主节点
 		clusterEnabled: false,
-		running:        false,
+		config:         NewRuntimeConfig(),
+		hzChanged:      make(chan struct{}, 1),
+		lazyFree:       storage.NewLazyFreeQueue(1024),
+		done:           make(chan struct{}),
 	}
 
-	// 启动定期清理过期阻塞客户端
-	go server.cleanBlockingClients()
+	// 全量同步在写屏障内生成快照
+	server.master.SetWriteBarrier(&server.writeBarrier)
+
+	// 启动后台释放（UNLINK）
+	server.lazyFree.Start()
+
+	// 统计键空间命中/未命中
+	for i := 0; i < redisServer.GetDbNum(); i++ {
+		db, _ := redisServer.GetDb(i)
+		db.SetLookupObserver(server.stats.RecordKeyspaceLookup)
+	}
+
+	// 编码转换阈值（hash-max-listpack-entries 等）
+	server.applyEncodingLimits()
+
+	// 复制积压缓冲区大小
+	server.master.SetBacklogSize(server.config.GetMemory("repl-backlog-size"))
 
 	return server
 }
 
+// SetConfigFile 设置启动时加载的配置文件（CONFIG REWRITE 写回该文件）
+func (s *Server) SetConfigFile(path string) {
+	s.config.SetConfigFile(path)
+}
+
 // InitCluster 初始化集群（如果启用）
 func (s *Server) InitCluster(clusterEnabled bool, nodeID string, clusterAddr string) error {
 	if !clusterEnabled {
@@ -124,8 +181,10 @@ func (s *Server) InitAOF(aofEnabled bool, aofFilename string) error {
 	if err := s.LoadAOF(aofFilename); err != nil {
 		// 如果文件不存在，这是正常的（首次启动）
 		if !os.IsNotExist(err) {
-			fmt.Printf("Warning: Failed to load AOF file: %v\n", err)
+			return fmt.Errorf("failed to load AOF file: %w", err)
 		}
+	} else {
+		s.aofLoaded = true
 	}
 
 	// 然后创建 AOF writer 用于后续写入
@@ -133,12 +192,71 @@ func (s *Server) InitAOF(aofEnabled bool, aofFilename string) error {
 	if err != nil {
 		return err
 	}
+	fsync, _ := s.config.Get("appendfsync")
+	aofWriter.SetFsyncPolicy(fsync)
 	s.aofWriter = aofWriter
 	fmt.Printf("AOF initialized: %s\n", aofFilename)
 	return nil
 }
 
+// InitRDB 初始化 RDB：设置快照文件，启用时从已有的快照恢复数据。
+// 需要在 InitAOF 之后、Start 之前调用：与 Redis 一样，AOF 和 RDB 都存在时优先使用 AOF
+func (s *Server) InitRDB(rdbEnabled bool, rdbFilename string) error {
+	if rdbFilename != "" {
+		s.rdbFilename = rdbFilename
+	}
+	if !rdbEnabled {
+		return nil
+	}
+	if s.aofLoaded {
+		fmt.Printf("Data loaded from AOF, skipping RDB file: %s\n", s.rdbFilename)
+		return nil
+	}
+
+	if err := s.LoadRDB(s.rdbFilename); err != nil {
+		// 如果文件不存在，这是正常的（首次启动）
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// LoadRDB 从 RDB 文件恢复数据（按 rdbchecksum 决定是否校验校验和）
+func (s *Server) LoadRDB(filename string) error {
+	start := time.Now()
+
+	decoder := persistence.NewRDBDecoder(nil)
+	decoder.SetChecksum(s.config.GetBool("rdbchecksum"))
+	if err := decoder.Load(s.redisServer, filename); err != nil {
+		return err
+	}
+
+	// 刚加载的数据与快照一致，不需要再次保存
+	s.redisServer.MarkSaved(s.redisServer.Dirty())
+
+	// 启用了 AOF 但没有 AOF 文件时数据来自 RDB，重写 AOF，否则下次启动会从空的 AOF 恢复
+	if s.aofWriter != nil {
+		if err := s.aofWriter.Rewrite(s.redisServer, s.aofRewriteKeys); err != nil {
+			return fmt.Errorf("failed to rewrite AOF after loading RDB: %w", err)
+		}
+	}
+
+	keys := 0
+	for i := 0; i < s.redisServer.GetDbNum(); i++ {
+		if db, err := s.redisServer.GetDb(i); err == nil {
+			keys += db.DBSize()
+		}
+	}
+	fmt.Printf("DB loaded from disk: %s (%d keys, %.3f seconds)\n", filename, keys, time.Since(start).Seconds())
+	return nil
+}
+
 // LoadAOF 从 AOF 文件加载并重放命令
+// 逐条解析命令，通过命令表在当前键空间上重新执行写命令（不会再次写入 AOF）。
+// 文件末尾的命令不完整时（写入过程中宕机），aof-load-truncated 为 yes 则丢弃该命令、
+// 把文件截断到最后一条完整命令处并继续启动，否则返回错误
 func (s *Server) LoadAOF(filename string) error {
 	// 检查文件是否存在
 	if _, err := os.Stat(filename); os.IsNotExist(err) {
@@ -149,21 +267,17 @@ func (s *Server) LoadAOF(filename string) error {
 	if err != nil {
 		return err
 	}
+	defer loader.Close()
 
-	commands, err := loader.Load()
-	if err != nil {
-		return err
-	}
+	fmt.Printf("Loading AOF file: %s\n", filename)
+	start := time.Now()
 
-	if len(commands) == 0 {
-		return nil
-	}
-
-	fmt.Printf("Loading AOF file: %s (%d commands)\n", filename, len(commands))
+	// 加载期间不做内存淘汰
+	s.loading.Store(true)
+	defer s.loading.Store(false)
 
 	// 获取默认数据库（数据库 0）
 	defaultDb, _ := s.redisServer.GetDb(0)
-	currentDbIndex := 0
 
 	// 创建临时上下文用于重放命令（不写入 AOF，避免重复）
 	ctx := &CommandContext{
@@ -175,59 +289,58 @@ func (s *Server) LoadAOF(filename string) error {
 	// 临时禁用 AOF 写入（避免重复记录）
 	originalAofWriter := s.aofWriter
 	s.aofWriter = nil
+	defer func() { s.aofWriter = originalAofWriter }()
 
 	// 重放所有命令
-	for i, cmd := range commands {
-		if !cmd.IsArray() || len(cmd.GetArray()) == 0 {
-			continue
+	replayed := 0
+	for i := 1; ; i++ {
+		cmd, err := loader.Next()
+		if err == io.EOF {
+			break
 		}
-
-		cmdArray := cmd.GetArray()
-		cmdName := cmdArray[0].ToString()
-		if cmdName == "" {
-			continue
+		if err == persistence.ErrAOFTruncated {
+			if !s.config.GetBool("aof-load-truncated") {
+				return fmt.Errorf("%w (set aof-load-truncated yes to load it anyway)", err)
+			}
+			fmt.Printf("Warning: AOF file is truncated, discarding the last incomplete command and truncating the file to %d bytes\n", loader.ValidSize())
+			if err := os.Truncate(filename, loader.ValidSize()); err != nil {
+				return err
+			}
+			break
+		}
+		if err != nil {
+			return err
 		}
 
-		cmdName = toUpper(cmdName)
+		cmdArray := cmd.GetArray()
+		cmdName := toUpper(cmdArray[0].ToString())
 
 		// 处理 SELECT 命令（切换数据库）
 		if cmdName == "SELECT" && len(cmdArray) >= 2 {
 			dbIndex, err := strconv.Atoi(cmdArray[1].ToString())
 			if err == nil && dbIndex >= 0 && dbIndex < s.redisServer.GetDbNum() {
-				currentDbIndex = dbIndex
-				db, _ := s.redisServer.GetDb(currentDbIndex)
-				ctx.Db = db
+				ctx.Db, _ = s.redisServer.GetDb(dbIndex)
 			}
 			continue
 		}
 
+		// 只有写命令会改变键空间
+		if !s.isWriteCommand(cmdName) {
+			continue
+		}
+
 		// 执行命令
 		resp := s.cmdTable.ExecuteCommand(ctx, cmd)
 		if resp != nil && resp.Type == protocol.RESP_ERROR {
-			fmt.Printf("Warning: AOF replay error at command %d (%s): %s\n", i+1, cmdName, resp.Str)
+			fmt.Printf("Warning: AOF replay error at command %d (%s): %s\n", i, cmdName, resp.Str)
 		}
+		replayed++
 	}
 
-	// 恢复 AOF writer
-	s.aofWriter = originalAofWriter
-
-	fmt.Printf("AOF file loaded successfully\n")
+	fmt.Printf("AOF file loaded successfully (%d commands, %.3f seconds)\n", replayed, time.Since(start).Seconds())
 	return nil
 }
 
-// cleanBlockingClients 定期清理过期的阻塞客户端
-func (s *Server) cleanBlockingClients() {
-	ticker := time.NewTicker(1 * time.Second)
-	defer ticker.Stop()
-
-	for range ticker.C {
-		if !s.running {
-			return
-		}
-		s.blockingMgr.CleanExpired()
-	}
-}
-
 // Start 启动服务器
 func (s *Server) Start() error {
 	listener, err := net.Listen("tcp", s.addr)
@@ -236,22 +349,33 @@ func (s *Server) Start() error {
 	}
 
 	s.listener = listener
-	s.running = true
+	s.running.Store(true)
+
+	// 启动定时任务（客户端超时、保存点检查）和主动过期
+	go s.serverCron()
+	s.redisServer.StartActiveExpire(s.cronHz())
 
 	fmt.Printf("Redis server started on %s\n", s.addr)
 
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
-			if !s.running {
+			if !s.running.Load() {
 				return nil
 			}
 			continue
 		}
 
+		// 保护模式：拒绝外部连接
+		if s.protectedModeDenies(conn.RemoteAddr()) {
+			s.denyConnection(conn)
+			continue
+		}
+
 		// 每个客户端默认使用数据库 0
 		defaultDb, _ := s.redisServer.GetDb(0)
 		client := &Client{
+			id:          s.nextClientID.Add(1),
 			conn:        conn,
 			reader:      bufio.NewReader(conn),
 			writer:      bufio.NewWriter(conn),
@@ -262,29 +386,266 @@ func (s *Server) Start() error {
 			transaction: nil,
 			inMulti:     false,
 			pipeline:    NewPipelineBuffer(),
+			ctime:       time.Now(),
 		}
+		client.lastActive.Store(time.Now().UnixMilli())
 
 		s.mu.Lock()
-		s.clients[client] = true
+		s.clients[client.id] = client
 		s.mu.Unlock()
 
 		go s.handleClient(client)
 	}
 }
 
+// protectedModeDeniedMsg 保护模式下拒绝外部连接时返回的错误
+const protectedModeDeniedMsg = "DENIED LingCache is running in protected mode because protected mode is enabled " +
+	"and no password is set. In this mode connections are only accepted from the loopback interface. " +
+	"If you want to connect from external computers you may adopt one of the following solutions: " +
+	"1) Disable protected mode by sending 'CONFIG SET protected-mode no' from the loopback interface " +
+	"(use CONFIG REWRITE to make this change permanent), MAKE SURE the server is not publicly accessible from internet if you do so. " +
+	"2) Set REDIS_PROTECTED_MODE=false in the configuration file and restart the server. " +
+	"3) Bind the server to a specific interface instead of all interfaces. " +
+	"4) Set up an authentication password with requirepass. " +
+	"NOTE: You only need to do one of the above things in order for the server to start accepting connections from the outside."
+
+// protectedModeDenies 判断保护模式下是否拒绝来自 remote 的连接：
+// 开启了 protected-mode、没有设置 requirepass、监听所有网卡，且连接不是来自本机回环地址
+func (s *Server) protectedModeDenies(remote net.Addr) bool {
+	if mode, _ := s.config.Get("protected-mode"); mode != "yes" {
+		return false
+	}
+	if pass, _ := s.config.Get("requirepass"); pass != "" {
+		return false
+	}
+
+	// 只有监听所有网卡（地址为空、0.0.0.0 或 ::）时才需要保护
+	host, _, err := net.SplitHostPort(s.addr)
+	if err != nil {
+		return false
+	}
+	if host != "" {
+		if ip := net.ParseIP(host); ip == nil || !ip.IsUnspecified() {
+			return false
+		}
+	}
+
+	tcpAddr, ok := remote.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	return !tcpAddr.IP.IsLoopback()
+}
+
+// denyConnection 返回 DENIED 错误并关闭连接
+func (s *Server) denyConnection(conn net.Conn) {
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+	conn.Write(protocol.NewError(protectedModeDeniedMsg).Encode())
+	conn.Close()
+}
+
+// listeningPort 服务器实际监听的端口（监听地址为 :0 时取系统分配的端口）
+func (s *Server) listeningPort() int {
+	if s.listener != nil {
+		if addr, ok := s.listener.Addr().(*net.TCPAddr); ok {
+			return addr.Port
+		}
+	}
+	return int(parsePort(s.addr[strings.LastIndex(s.addr, ":")+1:]))
+}
+
+// setSlave 替换与主节点的复制连接（nil 表示不再作为从节点），关闭旧的连接
+func (s *Server) setSlave(slave *replication.Slave) {
+	s.mu.Lock()
+	old := s.slave
+	s.slave = slave
+	s.mu.Unlock()
+
+	if old != nil {
+		old.Close()
+	}
+}
+
+// getSlave 获取与主节点的复制连接（不是从节点时返回 nil）
+func (s *Server) getSlave() *replication.Slave {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.slave
+}
+
+// role 当前的复制角色（HELLO 的 role 字段）
+func (s *Server) role() string {
+	if s.getSlave() != nil {
+		return "replica"
+	}
+	return "master"
+}
+
+// denyReadOnly 命令是否因为本节点是只读从节点（replica-read-only yes）而被拒绝
+func (s *Server) denyReadOnly(cmdName string) bool {
+	return s.isWriteCommand(cmdName) && s.getSlave() != nil && s.config.GetBool("replica-read-only")
+}
+
+// replicaLink 从节点一侧的复制处理：加载主节点的快照，执行主节点传播的命令
+type replicaLink struct {
+	server *Server
+	db     *storage.RedisDb // 命令流当前选择的数据库
+}
+
+// LoadSnapshot 清空所有数据库后加载全量同步的 RDB 快照
+func (l *replicaLink) LoadSnapshot(rdb io.Reader) error {
+	s := l.server
+	s.loading.Store(true)
+	defer s.loading.Store(false)
+
+	s.redisServer.FlushAll()
+	l.db, _ = s.redisServer.GetDb(0)
+	return persistence.NewRDBDecoder(nil).LoadFrom(s.redisServer, rdb)
+}
+
+// ApplyCommand 执行主节点传播的命令，与 AOF 加载一样由 SELECT 切换数据库
+func (l *replicaLink) ApplyCommand(cmd *protocol.RESPValue) {
+	s := l.server
+	args := cmd.GetArray()
+	if len(args) == 0 {
+		return
+	}
+
+	cmdName := toUpper(args[0].ToString())
+	if cmdName == "SELECT" && len(args) >= 2 {
+		if dbIndex, err := strconv.Atoi(args[1].ToString()); err == nil {
+			if db, err := s.redisServer.GetDb(dbIndex); err == nil {
+				l.db = db
+			}
+		}
+		return
+	}
+
+	ctx := &CommandContext{Server: s, Db: l.db}
+	ctx.acquireWriteBarrier()
+	defer ctx.releaseWriteBarrier()
+
+	resp := s.cmdTable.ExecuteCommand(ctx, cmd)
+	if resp != nil && resp.Type == protocol.RESP_ERROR {
+		fmt.Printf("Warning: error applying replicated command %s: %s\n", cmdName, resp.Str)
+		return
+	}
+
+	s.propagate(ctx, cmd, resp, ctx.dirty)
+}
+
+// propagate 写命令执行成功并且修改了数据（dirty 为本次执行的修改次数）时，
+// 计入上次保存以来的修改次数，使监视了这些键的事务失效，写入 AOF 并传播给从节点
+func (s *Server) propagate(ctx *CommandContext, req *protocol.RESPValue, resp *protocol.RESPValue, dirty int64) {
+	if dirty <= 0 || resp == nil || resp.Type == protocol.RESP_ERROR {
+		return
+	}
+	argv := req.GetArray()
+	if len(argv) == 0 || !s.isWriteCommand(toUpper(argv[0].ToString())) {
+		return
+	}
+
+	s.redisServer.AddDirty(dirty)
+	s.signalModifiedKeys(ctx.Db, req, resp)
+
+	// 命令通过 Propagate 指定了确定的效果时传播效果，否则原样传播
+	cmds := ctx.propagated
+	if cmds == nil {
+		cmds = []*protocol.RESPValue{req}
+	}
+	if len(ctx.served) > 0 {
+		cmds = append(append([]*protocol.RESPValue{}, cmds...), ctx.served...)
+	}
+	for _, cmd := range cmds {
+		if s.aofWriter != nil {
+			// AOF 写入失败，记录错误但不影响命令执行
+			if err := s.aofWriter.Append(ctx.Db.GetID(), cmd); err != nil {
+				fmt.Printf("AOF write error: %v\n", err)
+			}
+		}
+		if s.master != nil {
+			s.master.PropagateCommand(ctx.Db.GetID(), cmd)
+		}
+	}
+	if s.master != nil && ctx.Client != nil {
+		ctx.Client.woff = s.master.ReplOffset()
+	}
+}
+
+// propagateDeletion 将服务器主动删除的键（maxmemory 淘汰）以 DEL 写入 AOF 并传播给从节点
+func (s *Server) propagateDeletion(db *storage.RedisDb, key string) {
+	cmd := newCommand("DEL", key)
+	if s.aofWriter != nil {
+		if err := s.aofWriter.Append(db.GetID(), cmd); err != nil {
+			fmt.Printf("AOF write error: %v\n", err)
+		}
+	}
+	if s.master != nil {
+		s.master.PropagateCommand(db.GetID(), cmd)
+	}
+}
+
 // Stop 停止服务器
 func (s *Server) Stop() {
-	s.running = false
+	s.running.Store(false)
 	if s.listener != nil {
 		s.listener.Close()
 	}
+	s.lazyFree.Stop()
+	s.redisServer.StopActiveExpire()
+	s.setSlave(nil)
 
+	// Client.Close 会获取 s.mu，因此先取出客户端列表再逐个关闭
 	s.mu.Lock()
-	for client := range s.clients {
-		client.Close()
+	clients := make([]*Client, 0, len(s.clients))
+	for _, client := range s.clients {
+		clients = append(clients, client)
 	}
-	s.clients = make(map[*Client]bool)
+	s.clients = make(map[int64]*Client)
 	s.mu.Unlock()
+
+	for _, client := range clients {
+		client.Close()
+	}
+
+	s.stopOnce.Do(func() {
+		close(s.done)
+	})
+}
+
+// Done 返回服务器停止后关闭的 channel
+func (s *Server) Done() <-chan struct{} {
+	return s.done
+}
+
+// newRDBEncoder 创建 RDB 编码器（按 rdbchecksum 决定是否写入校验和）
+func (s *Server) newRDBEncoder() *persistence.RDBEncoder {
+	encoder := persistence.NewRDBEncoder(nil)
+	encoder.SetChecksum(s.config.GetBool("rdbchecksum"))
+	return encoder
+}
+
+// Shutdown 关闭服务器（SHUTDOWN 命令）：save 为 true 时先同步保存 RDB，
+// 保存失败则不关闭；随后刷出 AOF 并停止服务器
+func (s *Server) Shutdown(save bool) error {
+	if save {
+		redisServer := s.GetRedisServer()
+		dirty := redisServer.Dirty()
+		if err := s.newRDBEncoder().Save(redisServer, s.rdbFilename); err != nil {
+			redisServer.MarkSaveFailed()
+			return err
+		}
+		redisServer.MarkSaved(dirty)
+	}
+
+	if s.aofWriter != nil {
+		if err := s.aofWriter.Close(); err != nil {
+			fmt.Printf("AOF close error: %v\n", err)
+		}
+	}
+
+	s.Stop()
+	return nil
 }
 
 // handleClient 处理客户端连接
@@ -293,9 +654,9 @@ func (s *Server) handleClient(client *Client) {
 
 	for {
 		// 读取请求
-		req, err := protocol.Decode(client.reader)
+		req, err := protocol.DecodeRequest(client.reader)
 		if err != nil {
-			if client.closed {
+			if client.isClosed() {
 				return
 			}
 			// 发送错误响应
@@ -303,6 +664,15 @@ func (s *Server) handleClient(client *Client) {
 			client.writeResponse(resp)
 			return
 		}
+		client.lastActive.Store(time.Now().UnixMilli())
+
+		// 设置了 requirepass 时，未认证的客户端只能执行 AUTH、HELLO 和 QUIT
+		if args := req.GetArray(); len(args) > 0 && s.authRequired(client) && !noAuthCommands[toUpper(args[0].ToString())] {
+			if err := client.writeResponse(protocol.NewError("NOAUTH Authentication required.")); err != nil {
+				return
+			}
+			continue
+		}
 
 		// 创建命令上下文
 		ctx := &CommandContext{
@@ -311,57 +681,43 @@ func (s *Server) handleClient(client *Client) {
 			Client: client,
 		}
 
-		// 检查是否在事务模式
-		if client.inMulti {
-			// 事务模式：命令入队
-			cmdName := req.GetArray()[0].ToString()
-			cmdName = toUpper(cmdName)
-
-			// 某些命令不能在事务中执行
-			if cmdName == "EXEC" || cmdName == "DISCARD" || cmdName == "WATCH" || cmdName == "MULTI" {
-				// 这些命令直接执行
-				resp := s.cmdTable.ExecuteCommand(ctx, req)
-				if resp != nil {
-					if err := client.writeResponse(resp); err != nil {
-						return
+		queued := client.inMulti && !multiImmediateCommands[toUpper(req.GetArray()[0].ToString())]
+
+		// 如果是集群模式，先检查路由（事务中的命令入队前同样检查）
+		if s.clusterEnabled && s.cluster != nil {
+			// 检查是否需要路由到其他节点（ASKING 只对紧接着的一条命令有效）
+			redirectResp := s.checkClusterRedirect(ctx, req)
+			if toUpper(req.GetArray()[0].ToString()) != "ASKING" {
+				client.asking = false
+			}
+			if redirectResp != nil {
+				// 事务中的命令需要重定向：EXEC 时丢弃整个事务
+				if queued {
+					if client.transaction == nil {
+						client.transaction = NewTransaction()
 					}
+					client.transaction.dirty = true
 				}
-				continue
-			}
-
-			// 其他命令入队
-			cmd, err := s.cmdTable.Lookup(cmdName)
-			if err != nil {
-				resp := protocol.NewError("ERR " + err.Error())
-				if err := client.writeResponse(resp); err != nil {
+				if err := client.writeResponse(redirectResp); err != nil {
 					return
 				}
 				continue
 			}
+		}
 
-			if client.transaction == nil {
-				client.transaction = NewTransaction()
-			}
-			client.transaction.AddCommand(req, cmd.Proc)
-
-			// 返回 QUEUED
-			resp := protocol.NewSimpleString("QUEUED")
-			if err := client.writeResponse(resp); err != nil {
+		// 事务模式：除 EXEC、DISCARD 等命令外，其它命令只入队不执行
+		if queued {
+			if err := client.writeResponse(s.queueCommand(client, req)); err != nil {
 				return
 			}
 			continue
 		}
 
 		// 正常模式：执行命令
-		// 如果是集群模式，先检查路由
-		if s.clusterEnabled && s.cluster != nil {
-			// 检查是否需要路由到其他节点
-			if redirectResp := s.checkClusterRedirect(ctx, req); redirectResp != nil {
-				if err := client.writeResponse(redirectResp); err != nil {
-					return
-				}
-				continue
-			}
+
+		// 可能修改数据的命令在执行和传播完成之前持有写屏障
+		if s.needsWriteBarrier(toUpper(req.GetArray()[0].ToString())) {
+			ctx.acquireWriteBarrier()
 		}
 
 		startTime := time.Now()
@@ -373,21 +729,12 @@ func (s *Server) handleClient(client *Client) {
 			cmdName := req.GetArray()[0].ToString()
 			cmdName = toUpper(cmdName) // 转换为大写
 			s.stats.RecordCommand(cmdName, duration)
+			s.recordSlowCommand(client, req, duration)
 
-			// 如果是写命令且 AOF 已启用，写入 AOF
-			if s.aofWriter != nil && s.isWriteCommand(cmdName) && resp != nil && resp.Type != protocol.RESP_ERROR {
-				// 写入 AOF（使用原始请求）
-				if err := s.aofWriter.Append(req); err != nil {
-					// AOF 写入失败，记录错误但不影响命令执行
-					fmt.Printf("AOF write error: %v\n", err)
-				}
-			}
-
-			// 如果是写命令且是主节点，传播到从节点
-			if s.master != nil && s.isWriteCommand(cmdName) && resp != nil && resp.Type != protocol.RESP_ERROR {
-				s.master.PropagateCommand(req)
-			}
+			// 写命令修改了数据：计入 dirty、写入 AOF 并传播给从节点
+			s.propagate(ctx, req, resp, ctx.dirty)
 		}
+		ctx.releaseWriteBarrier()
 
 		// 发送响应（某些命令如 SUBSCRIBE 可能返回 nil）
 		if resp != nil {
@@ -395,12 +742,73 @@ func (s *Server) handleClient(client *Client) {
 				return
 			}
 		}
+
+		// QUIT：回复已刷出，关闭连接
+		if client.quitting {
+			return
+		}
+	}
+}
+
+// noAuthCommands 未认证时也允许执行的命令
+var noAuthCommands = map[string]bool{
+	"AUTH":  true,
+	"HELLO": true,
+	"QUIT":  true,
+	"RESET": true,
+}
+
+// authRequired 客户端执行命令前是否需要先认证（设置了 requirepass 且尚未认证）
+func (s *Server) authRequired(c *Client) bool {
+	if c.authenticated {
+		return false
+	}
+	pass, _ := s.config.Get("requirepass")
+	return pass != ""
+}
+
+// checkPassword 校验用户名和密码：只有 default 用户，
+// 没有设置 requirepass 时任意密码都可以通过（与 Redis 的 nopass 一致）
+func (s *Server) checkPassword(username, password string) bool {
+	if username != "default" {
+		return false
+	}
+	pass, _ := s.config.Get("requirepass")
+	if pass == "" {
+		return true
+	}
+	// 恒定时间比较，避免通过响应时间猜测密码
+	return subtle.ConstantTimeCompare([]byte(password), []byte(pass)) == 1
+}
+
+// recordSlowCommand 执行时间超过 slowlog-log-slower-than（微秒）时记入慢查询日志
+func (s *Server) recordSlowCommand(client *Client, req *protocol.RESPValue, duration time.Duration) {
+	threshold := s.config.GetInt("slowlog-log-slower-than")
+	if threshold < 0 || duration.Microseconds() < threshold {
+		return
+	}
+
+	array := req.GetArray()
+	args := make([]string, len(array))
+	for i, arg := range array {
+		args[i] = arg.ToString()
 	}
+	s.slowlog.Add(args, duration, client.conn.RemoteAddr().String(), client.name,
+		int(s.config.GetInt("slowlog-max-len")))
 }
 
 // writeResponse 写入响应
+// 同一个客户端可能被多个 goroutine 同时写入（自身的回复和其它客户端的 PUBLISH），
+// 加锁保证每条回复完整写出，不会与其它回复交错
 func (c *Client) writeResponse(resp *protocol.RESPValue) error {
-	data := resp.Encode()
+	data := resp.EncodeProto(c.protocolVersion())
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.closed {
+		return net.ErrClosed
+	}
 	_, err := c.writer.Write(data)
 	if err != nil {
 		return err
@@ -408,40 +816,119 @@ func (c *Client) writeResponse(resp *protocol.RESPValue) error {
 	return c.writer.Flush()
 }
 
+// protocolVersion 客户端使用的协议版本（默认 RESP2）
+func (c *Client) protocolVersion() int {
+	if v := c.resp.Load(); v != 0 {
+		return int(v)
+	}
+	return 2
+}
+
+// infoString CLIENT LIST 中描述客户端的一行（不含换行）
+func (c *Client) infoString(now time.Time) string {
+	return fmt.Sprintf("id=%d addr=%s laddr=%s name=%s age=%d idle=%d db=%d resp=%d",
+		c.id, c.conn.RemoteAddr(), c.conn.LocalAddr(), c.name,
+		int64(now.Sub(c.ctime).Seconds()), (now.UnixMilli()-c.lastActive.Load())/1000,
+		c.dbIndex, c.protocolVersion())
+}
+
+// lookupClient 根据 ID 查找已连接的客户端
+func (s *Server) lookupClient(id int64) (*Client, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	client, ok := s.clients[id]
+	return client, ok
+}
+
+// connectedClients 按 ID 升序返回当前连接的客户端
+func (s *Server) connectedClients() []*Client {
+	s.mu.RLock()
+	clients := make([]*Client, 0, len(s.clients))
+	for _, client := range s.clients {
+		clients = append(clients, client)
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(clients, func(i, j int) bool { return clients[i].id < clients[j].id })
+	return clients
+}
+
+// isClosed 连接是否已关闭
+func (c *Client) isClosed() bool {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.closed
+}
+
 // Close 关闭客户端连接
 func (c *Client) Close() {
+	c.writeMu.Lock()
 	if c.closed {
+		c.writeMu.Unlock()
 		return
 	}
-
 	c.closed = true
 	c.conn.Close()
+	c.writeMu.Unlock()
+
+	c.server.pubsub.RemoveClient(c)
+	c.server.monitors.Remove(c)
+	if c.server.master != nil {
+		c.server.master.RemoveConn(c.conn)
+	}
+	c.server.blockingMgr.RemoveClient(c)
+	c.unwatchAll()
 
 	c.server.mu.Lock()
-	delete(c.server.clients, c)
+	delete(c.server.clients, c.id)
 	c.server.mu.Unlock()
 }
 
-// isWriteCommand 判断是否是写命令
+// resetState 将连接恢复到刚建立时的状态（RESET）：
+// 放弃事务、取消 WATCH、退订所有频道和模式、退出 MONITOR、取消认证、
+// 切回 0 号数据库和 RESP2，并清除客户端名称和 NO-EVICT 标记
+func (c *Client) resetState() {
+	if c.transaction != nil {
+		c.transaction.Discard()
+		c.transaction = nil
+	}
+	c.inMulti = false
+	c.unwatchAll()
+
+	c.server.pubsub.RemoveClient(c)
+	c.server.monitors.Remove(c)
+	if c.server.master != nil {
+		c.server.master.RemoveConn(c.conn)
+	}
+
+	if db, err := c.server.redisServer.GetDb(0); err == nil {
+		c.db = db
+		c.dbIndex = 0
+	}
+	c.resp.Store(2)
+	c.authenticated = false
+	c.name = ""
+	c.noEvict = false
+}
+
+// commandFlags 命令的 CMD_* 标志（未知命令返回 0）
+func (s *Server) commandFlags(cmdName string) uint64 {
+	cmd, err := s.cmdTable.Lookup(cmdName)
+	if err != nil {
+		return 0
+	}
+	return cmd.Flags
+}
+
+// isWriteCommand 判断是否是写命令（带 CMD_WRITE 标志）
 func (s *Server) isWriteCommand(cmdName string) bool {
-	writeCommands := map[string]bool{
-		"SET": true, "MSET": true, "SETEX": true, "SETNX": true, "PSETEX": true,
-		"DEL": true, "EXPIRE": true, "EXPIREAT": true, "PEXPIRE": true, "PEXPIREAT": true, "PERSIST": true,
-		"RENAME": true, "RENAMENX": true, "MOVE": true,
-		"LPUSH": true, "RPUSH": true, "LPOP": true, "RPOP": true,
-		"LREM": true, "LSET": true, "LTRIM": true, "LINSERT": true,
-		"RPOPLPUSH": true, "BRPOPLPUSH": true,
-		"SADD": true, "SREM": true, "SPOP": true, "SMOVE": true,
-		"SINTERSTORE": true, "SUNIONSTORE": true, "SDIFFSTORE": true,
-		"ZADD": true, "ZREM": true, "ZINCRBY": true,
-		"ZREMRANGEBYRANK": true, "ZREMRANGEBYSCORE": true,
-		"HSET": true, "HMSET": true, "HDEL": true, "HINCRBY": true, "HINCRBYFLOAT": true, "HSETNX": true,
-		"INCR": true, "DECR": true, "INCRBY": true, "DECRBY": true,
-		"APPEND": true, "GETSET": true, "SETRANGE": true,
-		"SETBIT": true, "BITOP": true,
-		"SORT": true,
-	}
-	return writeCommands[cmdName]
+	return s.commandFlags(cmdName)&CMD_WRITE != 0
+}
+
+// needsWriteBarrier 命令是否可能修改数据（写命令和执行队列中写命令的 EXEC），
+// 这些命令从执行到传播完成期间持有写屏障，全量同步不会拍下执行了一半的修改
+func (s *Server) needsWriteBarrier(cmdName string) bool {
+	return s.isWriteCommand(cmdName) || cmdName == "EXEC"
 }
 
 // GetRedisServer 获取 Redis 服务器实例
@@ -454,58 +941,51 @@ func (s *Server) GetCluster() *cluster.Cluster {
 	return s.cluster
 }
 
-// checkClusterRedirect 检查是否需要重定向到其他节点
+// checkClusterRedirect 检查命令的键是否由当前节点负责，不是时返回重定向错误：
+//   - 键分布在多个槽：CROSSSLOT
+//   - 槽没有分配：CLUSTERDOWN
+//   - 槽属于其他节点：MOVED <slot> <ip:port>
+//   - 槽正在迁出且有键已经不在本节点：ASK <slot> <ip:port>（客户端到目标节点用 ASKING 重试）
+//
+// 本节点正在导入的槽（SETSLOT IMPORTING）只接受 ASKING 之后的请求
 func (s *Server) checkClusterRedirect(ctx *CommandContext, req *protocol.RESPValue) *protocol.RESPValue {
 	if !req.IsArray() || len(req.GetArray()) == 0 {
 		return nil
 	}
 
-	cmdArray := req.GetArray()
-	cmdName := cmdArray[0].ToString()
-	cmdName = toUpper(cmdName)
-
-	// 集群管理命令不需要路由
-	if cmdName == "CLUSTER" || cmdName == "PING" || cmdName == "INFO" {
+	// 没有键的命令（包括集群管理命令）不需要路由
+	keys := s.commandKeys(req.GetArray())
+	if len(keys) == 0 {
 		return nil
 	}
 
-	// 计算键的槽号
-	var slot int = -1
-	if len(cmdArray) > 1 {
-		key := cmdArray[1].ToString()
-		slot = cluster.HashSlot(key)
-	}
-
-	// 如果无法计算槽号，直接执行（可能是无键命令）
-	if slot < 0 {
-		return nil
+	slot := cluster.HashSlot(keys[0])
+	for _, key := range keys[1:] {
+		if cluster.HashSlot(key) != slot {
+			return protocol.NewError("CROSSSLOT Keys in request don't hash to the same slot")
+		}
 	}
 
 	// 获取负责该槽的节点
 	slotNode := s.cluster.GetSlotNode(slot)
 	if slotNode == nil {
-		// 槽未分配，返回错误
-		return protocol.NewError(fmt.Sprintf("CLUSTERDOWN Hash slot not served"))
+		return protocol.NewError("CLUSTERDOWN Hash slot not served")
 	}
-
-	// 检查是否是当前节点
 	if slotNode.NodeID != s.cluster.GetMyself().NodeID {
-		// 需要重定向到其他节点
-		// 解析地址
-		addr := slotNode.Addr
-		host := "127.0.0.1"
-		port := "6379"
-
-		if strings.Contains(addr, ":") {
-			parts := strings.Split(addr, ":")
-			if len(parts) == 2 {
-				host = parts[0]
-				port = parts[1]
-			}
+		// 正在导入的槽：客户端按 ASK 重定向发送 ASKING 后在本节点执行
+		if ctx.Client != nil && ctx.Client.asking && s.cluster.GetReshardingManager().IsSlotImporting(slot) {
+			return nil
 		}
+		return protocol.NewError(fmt.Sprintf("MOVED %d %s", slot, slotNode.Addr))
+	}
 
-		// 返回 MOVED 重定向
-		return protocol.NewError(fmt.Sprintf("MOVED %d %s:%s", slot, host, port))
+	// 槽正在迁出：已经迁走（本节点不存在）的键由目标节点处理
+	if target, ok := s.cluster.GetReshardingManager().GetMigratingTargetNode(slot); ok {
+		for _, key := range keys {
+			if !ctx.Db.Exists(key) {
+				return protocol.NewError(fmt.Sprintf("ASK %d %s", slot, target.Addr))
+			}
+		}
 	}
 
 	return nil
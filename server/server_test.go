@@ -1,7 +1,24 @@
 package server
 
 import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/code-100-precent/LingCache/cluster"
+	"github.com/code-100-precent/LingCache/persistence"
+	"github.com/code-100-precent/LingCache/protocol"
+	"github.com/code-100-precent/LingCache/storage"
 )
 
 // TestServerCreation 测试服务器创建
@@ -31,9 +48,19 @@ func TestBlockingManager(t *testing.T) {
 		t.Fatal("Failed to create blocking manager")
 	}
 
-	// 测试等待和通知
-	// 注意：需要实际的客户端连接，这里简化测试
-	t.Log("Blocking manager test passed")
+	// 注册时已有数据：直接由 serve 交付，不进入等待队列
+	bc, served := bm.Block(nil, nil, []string{"k"}, 0, func(key string) *blockingServe {
+		return &blockingServe{reply: protocol.NewBulkString(key)}
+	})
+	if bc != nil || served == nil || served.reply.Str != "k" || len(bm.waitingClients) != 0 {
+		t.Fatalf("Expected immediate delivery, got %v", served)
+	}
+
+	// 没有数据：进入等待队列，取消后移除
+	bc, _ = bm.Block(nil, nil, []string{"k"}, 0, func(key string) *blockingServe { return nil })
+	if len(bm.waitingClients) != 1 || !bm.Cancel(bc) || len(bm.waitingClients) != 0 {
+		t.Fatal("Expected waiter to be queued and then cancelled")
+	}
 }
 
 // TestMemoryStats 测试内存统计
@@ -76,3 +103,2630 @@ func TestSharedObjects(t *testing.T) {
 
 	t.Log("Shared objects test passed")
 }
+
+// TestBGSaveResponsive 测试 BGSAVE 期间其它命令仍能及时响应
+func TestBGSaveResponsive(t *testing.T) {
+	ctx := newTestContext(t)
+	ctx.Server.rdbFilename = filepath.Join(t.TempDir(), "dump.rdb")
+
+	value := strings.Repeat("v", 64)
+	for i := 0; i < 200000; i++ {
+		ctx.Db.Set("key:"+strconv.Itoa(i), storage.NewStringObject([]byte(value)))
+	}
+
+	reply := execCommand(ctx, "BGSAVE")
+	if reply.Type == protocol.RESP_ERROR {
+		t.Fatalf("BGSAVE failed: %s", reply.Str)
+	}
+	if reply := execCommand(ctx, "BGSAVE"); reply.Type != protocol.RESP_ERROR {
+		t.Fatalf("Expected concurrent BGSAVE to be rejected, got %v", reply)
+	}
+
+	served := 0
+	var slowest time.Duration
+	deadline := time.Now().Add(30 * time.Second)
+	for ctx.Server.bgsaveRunning.Load() {
+		if time.Now().After(deadline) {
+			t.Fatal("BGSAVE did not finish in time")
+		}
+		start := time.Now()
+		if reply := execCommand(ctx, "GET", "key:"+strconv.Itoa(served%200000)); reply.Str != value {
+			t.Fatalf("GET during BGSAVE returned %v", reply)
+		}
+		execCommand(ctx, "SET", "new:"+strconv.Itoa(served), "x")
+		if d := time.Since(start); d > slowest {
+			slowest = d
+		}
+		served++
+	}
+
+	if served == 0 {
+		t.Log("BGSAVE finished before any command was issued")
+	}
+	if slowest > time.Second {
+		t.Fatalf("Commands blocked for %v during BGSAVE", slowest)
+	}
+	if _, err := os.Stat(ctx.Server.rdbFilename); err != nil {
+		t.Fatalf("RDB file not written: %v", err)
+	}
+	t.Logf("Served %d commands during BGSAVE, slowest %v", served, slowest)
+}
+
+// startTestServer 在随机端口启动服务器，返回服务器和地址
+func startTestServer(t *testing.T) (*Server, string) {
+	t.Helper()
+	return startTestServerWith(t, nil)
+}
+
+// startTestServerWith 启动测试服务器，init 在开始接受连接之前调用（用于加载持久化文件等）
+func startTestServerWith(t *testing.T, init func(*Server)) (*Server, string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to pick port: %v", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	server := NewServer(addr, 16)
+	if init != nil {
+		init(server)
+	}
+	go server.Start()
+	t.Cleanup(server.Stop)
+
+	for i := 0; i < 100; i++ {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			return server, addr
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Server did not start on %s", addr)
+	return nil, ""
+}
+
+// TestQuitClosesConnection 测试 QUIT 先返回 +OK 再关闭连接
+func TestQuitClosesConnection(t *testing.T) {
+	_, addr := startTestServer(t)
+
+	cases := map[string][]string{
+		"plain":     nil,
+		"subscribe": {"*2\r\n$9\r\nSUBSCRIBE\r\n$2\r\nch\r\n"},
+		"multi":     {"*1\r\n$5\r\nMULTI\r\n", "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$1\r\nv\r\n"},
+	}
+
+	for name, setup := range cases {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("%s: dial failed: %v", name, err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		reader := bufio.NewReader(conn)
+
+		for _, cmd := range setup {
+			conn.Write([]byte(cmd))
+			if _, err := protocol.Decode(reader); err != nil {
+				t.Fatalf("%s: setup reply failed: %v", name, err)
+			}
+		}
+
+		conn.Write([]byte("*1\r\n$4\r\nQUIT\r\n"))
+		reply, err := protocol.Decode(reader)
+		if err != nil || reply.Type != protocol.RESP_SIMPLE_STRING || reply.Str != "OK" {
+			t.Fatalf("%s: expected +OK, got %v (%v)", name, reply, err)
+		}
+		if _, err := reader.ReadByte(); err != io.EOF {
+			t.Fatalf("%s: expected connection to be closed, got %v", name, err)
+		}
+		conn.Close()
+	}
+}
+
+// sendCommand 通过连接发送命令并读取一条回复
+func sendCommand(t *testing.T, conn net.Conn, reader *bufio.Reader, args ...string) *protocol.RESPValue {
+	t.Helper()
+	values := make([]*protocol.RESPValue, len(args))
+	for i, arg := range args {
+		values[i] = protocol.NewBulkString(arg)
+	}
+	if _, err := conn.Write(protocol.NewArray(values).Encode()); err != nil {
+		t.Fatalf("Write %v failed: %v", args, err)
+	}
+	reply, err := protocol.Decode(reader)
+	if err != nil {
+		t.Fatalf("Read reply of %v failed: %v", args, err)
+	}
+	return reply
+}
+
+// TestPersistNotification 测试 PERSIST 清除过期时间并发布 persist 事件
+func TestPersistNotification(t *testing.T) {
+	_, addr := startTestServer(t)
+
+	sub, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer sub.Close()
+	sub.SetDeadline(time.Now().Add(5 * time.Second))
+	subReader := bufio.NewReader(sub)
+	sendCommand(t, sub, subReader, "SUBSCRIBE", "__keyevent@0__:persist")
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+
+	sendCommand(t, conn, reader, "CONFIG", "SET", "notify-keyspace-events", "Eg")
+	sendCommand(t, conn, reader, "SET", "k", "v")
+	if reply := sendCommand(t, conn, reader, "PERSIST", "k"); reply.Int != 0 {
+		t.Fatalf("PERSIST without TTL should return 0, got %d", reply.Int)
+	}
+	sendCommand(t, conn, reader, "EXPIRE", "k", "100")
+	if reply := sendCommand(t, conn, reader, "PERSIST", "k"); reply.Int != 1 {
+		t.Fatalf("PERSIST should return 1, got %d", reply.Int)
+	}
+	if reply := sendCommand(t, conn, reader, "TTL", "k"); reply.Int != -1 {
+		t.Fatalf("Expected TTL -1 after PERSIST, got %d", reply.Int)
+	}
+
+	msg, err := protocol.Decode(subReader)
+	if err != nil {
+		t.Fatalf("Subscriber read failed: %v", err)
+	}
+	if len(msg.Array) != 3 || msg.Array[0].Str != "message" ||
+		msg.Array[1].Str != "__keyevent@0__:persist" || msg.Array[2].Str != "k" {
+		t.Fatalf("Unexpected notification %v", msg.Array)
+	}
+}
+
+// TestConcurrentPublish 测试多个发布者并发 PUBLISH 时订阅者收到的消息完整且各发布者内有序
+// 建议使用 go test -race 运行
+func TestConcurrentPublish(t *testing.T) {
+	_, addr := startTestServer(t)
+	const publishers = 8
+	const messages = 200
+
+	sub, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer sub.Close()
+	sub.SetDeadline(time.Now().Add(30 * time.Second))
+	subReader := bufio.NewReader(sub)
+	sendCommand(t, sub, subReader, "SUBSCRIBE", "ch")
+
+	payload := strings.Repeat("x", 512)
+	var wg sync.WaitGroup
+	for p := 0; p < publishers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Errorf("Publisher %d dial failed: %v", p, err)
+				return
+			}
+			defer conn.Close()
+			reader := bufio.NewReader(conn)
+			for m := 0; m < messages; m++ {
+				msg := strconv.Itoa(p) + ":" + strconv.Itoa(m) + ":" + payload
+				cmd := protocol.NewArray([]*protocol.RESPValue{
+					protocol.NewBulkString("PUBLISH"),
+					protocol.NewBulkString("ch"),
+					protocol.NewBulkString(msg),
+				})
+				if _, err := conn.Write(cmd.Encode()); err != nil {
+					t.Errorf("Publisher %d write failed: %v", p, err)
+					return
+				}
+				if _, err := protocol.Decode(reader); err != nil {
+					t.Errorf("Publisher %d read failed: %v", p, err)
+					return
+				}
+			}
+		}(p)
+	}
+
+	next := make([]int, publishers)
+	for i := 0; i < publishers*messages; i++ {
+		reply, err := protocol.Decode(subReader)
+		if err != nil {
+			t.Fatalf("Subscriber read %d failed: %v", i, err)
+		}
+		if len(reply.Array) != 3 || reply.Array[0].Str != "message" || reply.Array[1].Str != "ch" {
+			t.Fatalf("Corrupted message %d: %v", i, reply.Array)
+		}
+		parts := strings.SplitN(reply.Array[2].Str, ":", 3)
+		if len(parts) != 3 || parts[2] != payload {
+			t.Fatalf("Corrupted payload in message %d", i)
+		}
+		p, _ := strconv.Atoi(parts[0])
+		m, _ := strconv.Atoi(parts[1])
+		if p < 0 || p >= publishers || m != next[p] {
+			t.Fatalf("Publisher %d message %d arrived out of order (expected %d)", p, m, next[p])
+		}
+		next[p]++
+	}
+
+	wg.Wait()
+}
+
+// TestServerCronHz 测试 hz 越高，未被访问的过期键被主动回收得越快
+func TestServerCronHz(t *testing.T) {
+	server, addr := startTestServer(t)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+
+	db, _ := server.GetRedisServer().GetDb(0)
+	addExpiredKey := func() {
+		db.Set("stale", storage.NewStringObject([]byte("v")))
+		db.ExpireAt("stale", time.Now().Unix()-1)
+	}
+
+	// hz=1：一秒才执行一次，短时间内不会回收
+	sendCommand(t, conn, reader, "CONFIG", "SET", "hz", "1")
+	time.Sleep(50 * time.Millisecond)
+	addExpiredKey()
+	time.Sleep(300 * time.Millisecond)
+	if db.ExpiresCount() != 1 {
+		t.Fatal("Expired key reclaimed too early with hz=1")
+	}
+
+	// hz=100：每 10ms 执行一次，很快回收
+	sendCommand(t, conn, reader, "CONFIG", "SET", "hz", "100")
+	deadline := time.Now().Add(300 * time.Millisecond)
+	for db.ExpiresCount() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expired key not reclaimed with hz=100")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestPSubscribePattern 测试 PUBLISH 按 glob 模式分发给 PSUBSCRIBE 客户端
+func TestPSubscribePattern(t *testing.T) {
+	_, addr := startTestServer(t)
+
+	sub, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer sub.Close()
+	sub.SetDeadline(time.Now().Add(5 * time.Second))
+	subReader := bufio.NewReader(sub)
+	sendCommand(t, sub, subReader, "PSUBSCRIBE", "news.[a-m]*")
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+
+	if reply := sendCommand(t, conn, reader, "PUBLISH", "news.zoo", "skip"); reply.Int != 0 {
+		t.Fatalf("news.zoo should not match, got %d receivers", reply.Int)
+	}
+	if reply := sendCommand(t, conn, reader, "PUBLISH", "news.art", "hello"); reply.Int != 1 {
+		t.Fatalf("news.art should match, got %d receivers", reply.Int)
+	}
+
+	msg, err := protocol.Decode(subReader)
+	if err != nil {
+		t.Fatalf("Read pmessage failed: %v", err)
+	}
+	got := make([]string, len(msg.Array))
+	for i, v := range msg.Array {
+		got[i] = v.Str
+	}
+	want := []string{"pmessage", "news.[a-m]*", "news.art", "hello"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("Expected %v, got %v", want, got)
+	}
+}
+
+// TestShardedPubSub 测试分片频道只投递给 SSUBSCRIBE 客户端，且与普通频道相互独立
+func TestShardedPubSub(t *testing.T) {
+	_, addr := startTestServer(t)
+
+	sub, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer sub.Close()
+	sub.SetDeadline(time.Now().Add(5 * time.Second))
+	subReader := bufio.NewReader(sub)
+	ack := sendCommand(t, sub, subReader, "SSUBSCRIBE", "orders")
+	if len(ack.Array) != 3 || ack.Array[0].Str != "ssubscribe" || ack.Array[2].Int != 1 {
+		t.Fatalf("Unexpected SSUBSCRIBE ack: %v", ack)
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+
+	if reply := sendCommand(t, conn, reader, "PUBLISH", "orders", "skip"); reply.Int != 0 {
+		t.Fatalf("PUBLISH should not reach shard subscribers, got %d receivers", reply.Int)
+	}
+	if reply := sendCommand(t, conn, reader, "SPUBLISH", "orders", "hello"); reply.Int != 1 {
+		t.Fatalf("SPUBLISH should reach 1 receiver, got %d", reply.Int)
+	}
+
+	msg, err := protocol.Decode(subReader)
+	if err != nil {
+		t.Fatalf("Read smessage failed: %v", err)
+	}
+	got := make([]string, len(msg.Array))
+	for i, v := range msg.Array {
+		got[i] = v.Str
+	}
+	want := []string{"smessage", "orders", "hello"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("Expected %v, got %v", want, got)
+	}
+
+	reply := sendCommand(t, conn, reader, "PUBSUB", "SHARDCHANNELS")
+	if len(reply.Array) != 1 || reply.Array[0].Str != "orders" {
+		t.Fatalf("Expected SHARDCHANNELS [orders], got %v", reply)
+	}
+	reply = sendCommand(t, conn, reader, "PUBSUB", "shardnumsub", "orders", "other")
+	if len(reply.Array) != 4 || reply.Array[1].Int != 1 || reply.Array[3].Int != 0 {
+		t.Fatalf("Unexpected SHARDNUMSUB reply: %v", reply)
+	}
+
+	ack = sendCommand(t, sub, subReader, "SUNSUBSCRIBE")
+	if len(ack.Array) != 3 || ack.Array[0].Str != "sunsubscribe" || ack.Array[1].Str != "orders" || ack.Array[2].Int != 0 {
+		t.Fatalf("Unexpected SUNSUBSCRIBE ack: %v", ack)
+	}
+	if reply := sendCommand(t, conn, reader, "SPUBLISH", "orders", "gone"); reply.Int != 0 {
+		t.Fatalf("Expected 0 receivers after SUNSUBSCRIBE, got %d", reply.Int)
+	}
+}
+
+// TestProtectedMode 测试保护模式拒绝外部连接、允许本机回环连接
+func TestProtectedMode(t *testing.T) {
+	server := NewServer(":6379", 16)
+	external := &net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 50000}
+	loopback := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 50000}
+
+	if !server.protectedModeDenies(external) {
+		t.Fatal("External connection should be denied in protected mode")
+	}
+	if server.protectedModeDenies(loopback) {
+		t.Fatal("Loopback connection should be allowed in protected mode")
+	}
+	if server.protectedModeDenies(&net.TCPAddr{IP: net.IPv6loopback}) {
+		t.Fatal("IPv6 loopback connection should be allowed in protected mode")
+	}
+
+	// 模拟外部连接：收到 DENIED 错误后连接被关闭
+	serverSide, clientSide := net.Pipe()
+	go server.denyConnection(serverSide)
+	reply, err := protocol.Decode(bufio.NewReader(clientSide))
+	if err != nil {
+		t.Fatalf("Read DENIED reply failed: %v", err)
+	}
+	if reply.Type != protocol.RESP_ERROR || !strings.HasPrefix(reply.Str, "DENIED ") {
+		t.Fatalf("Expected DENIED error, got %v", reply)
+	}
+	if _, err := clientSide.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("Connection should be closed after DENIED, got %v", err)
+	}
+
+	// 设置密码、关闭保护模式或只监听指定网卡时都允许外部连接
+	server.config.Set("requirepass", "secret")
+	if server.protectedModeDenies(external) {
+		t.Fatal("External connection should be allowed when requirepass is set")
+	}
+	server.config.Set("requirepass", "")
+	server.config.Set("protected-mode", "no")
+	if server.protectedModeDenies(external) {
+		t.Fatal("External connection should be allowed with protected-mode no")
+	}
+	server.config.Set("protected-mode", "yes")
+	if NewServer("192.168.1.10:6379", 16).protectedModeDenies(external) {
+		t.Fatal("Server bound to a specific interface should not be protected")
+	}
+}
+
+// TestConfigResetStat 测试 CONFIG RESETSTAT 清零 INFO stats 中的计数器
+func TestConfigResetStat(t *testing.T) {
+	_, addr := startTestServer(t)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+
+	infoField := func(section, field string) string {
+		info := sendCommand(t, conn, reader, "INFO", section).ToString()
+		for _, line := range strings.Split(info, "\n") {
+			if value, ok := strings.CutPrefix(strings.TrimSpace(line), field+":"); ok {
+				return value
+			}
+		}
+		return ""
+	}
+
+	sendCommand(t, conn, reader, "SET", "k", "v")
+	sendCommand(t, conn, reader, "GET", "k")
+	sendCommand(t, conn, reader, "GET", "missing")
+	if infoField("stats", "keyspace_hits") != "1" || infoField("stats", "keyspace_misses") != "1" {
+		t.Fatal("Expected one keyspace hit and one miss before RESETSTAT")
+	}
+	if !strings.HasPrefix(infoField("commandstats", "cmdstat_get"), "calls=2,") {
+		t.Fatal("Expected 2 GET calls in commandstats")
+	}
+
+	if reply := sendCommand(t, conn, reader, "CONFIG", "RESETSTAT"); reply.Str != "OK" {
+		t.Fatalf("CONFIG RESETSTAT failed: %v", reply)
+	}
+
+	// RESETSTAT 自身执行后才计入统计，因此命令数为 1
+	if got := infoField("stats", "total_commands_processed"); got != "1" {
+		t.Fatalf("Expected total_commands_processed 1 after RESETSTAT, got %s", got)
+	}
+	if infoField("stats", "keyspace_hits") != "0" || infoField("stats", "keyspace_misses") != "0" {
+		t.Fatal("Expected keyspace hits/misses to be zero after RESETSTAT")
+	}
+	if got := infoField("commandstats", "cmdstat_get"); got != "" {
+		t.Fatalf("Expected no GET stats after RESETSTAT, got %s", got)
+	}
+}
+
+// TestMonitor 测试监视客户端能看到其它连接执行的命令
+func TestMonitor(t *testing.T) {
+	_, addr := startTestServer(t)
+
+	monitor, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer monitor.Close()
+	monitor.SetDeadline(time.Now().Add(5 * time.Second))
+	monitorReader := bufio.NewReader(monitor)
+	if reply := sendCommand(t, monitor, monitorReader, "MONITOR"); reply.Str != "OK" {
+		t.Fatalf("MONITOR failed: %v", reply)
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+
+	// CONFIG 属于管理命令，不会推送
+	sendCommand(t, conn, reader, "CONFIG", "GET", "hz")
+	sendCommand(t, conn, reader, "SELECT", "1")
+	sendCommand(t, conn, reader, "SET", "foo", "a \"b\"\n")
+
+	line, err := protocol.Decode(monitorReader)
+	if err != nil {
+		t.Fatalf("Read monitor line failed: %v", err)
+	}
+	if !regexp.MustCompile(`^\d+\.\d{6} \[0 127\.0\.0\.1:\d+\] "SELECT" "1"$`).MatchString(line.Str) {
+		t.Fatalf("Unexpected monitor line: %q", line.Str)
+	}
+	line, err = protocol.Decode(monitorReader)
+	if err != nil {
+		t.Fatalf("Read monitor line failed: %v", err)
+	}
+	if !strings.HasSuffix(line.Str, ` [1 `+conn.LocalAddr().String()+`] "SET" "foo" "a \"b\"\n"`) {
+		t.Fatalf("Unexpected monitor line: %q", line.Str)
+	}
+
+	// 事务中的命令在 EXEC 执行时推送
+	sendCommand(t, conn, reader, "MULTI")
+	sendCommand(t, conn, reader, "INCR", "counter")
+	sendCommand(t, conn, reader, "EXEC")
+	var fed []string
+	for i := 0; i < 3; i++ {
+		line, err := protocol.Decode(monitorReader)
+		if err != nil {
+			t.Fatalf("Read monitor line failed: %v", err)
+		}
+		fed = append(fed, line.Str[strings.Index(line.Str, "] ")+2:])
+	}
+	if fed[0] != `"MULTI"` || fed[1] != `"EXEC"` || fed[2] != `"INCR" "counter"` {
+		t.Fatalf("Unexpected monitor lines for transaction: %q", fed)
+	}
+
+	// 订阅状态下不能进入监视模式
+	sub, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer sub.Close()
+	sub.SetDeadline(time.Now().Add(5 * time.Second))
+	subReader := bufio.NewReader(sub)
+	sendCommand(t, sub, subReader, "SUBSCRIBE", "news")
+	if reply := sendCommand(t, sub, subReader, "MONITOR"); reply.Type != protocol.RESP_ERROR {
+		t.Fatalf("Expected MONITOR to be rejected while subscribed, got %v", reply)
+	}
+}
+
+// TestHelloRESP3 测试 HELLO 3 切换协议后回复使用 RESP3 类型
+func TestHelloRESP3(t *testing.T) {
+	_, addr := startTestServer(t)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+
+	sendCommand(t, conn, reader, "HSET", "h", "f", "v")
+	sendCommand(t, conn, reader, "ZADD", "z", "1.5", "a")
+
+	// 默认 RESP2
+	if reply := sendCommand(t, conn, reader, "HGETALL", "h"); reply.Type != protocol.RESP_ARRAY {
+		t.Fatalf("Expected RESP2 array for HGETALL, got %v", reply)
+	}
+
+	hello := sendCommand(t, conn, reader, "HELLO", "3", "SETNAME", "tester")
+	if hello.Type != protocol.RESP_MAP {
+		t.Fatalf("Expected map reply for HELLO 3, got %v", hello)
+	}
+	if hello.Array[4].Str != "proto" || hello.Array[5].Int != 3 {
+		t.Fatalf("Expected proto 3 in HELLO reply, got %v", hello.Array)
+	}
+
+	reply := sendCommand(t, conn, reader, "HGETALL", "h")
+	if reply.Type != protocol.RESP_MAP || len(reply.Array) != 2 || reply.Array[1].Str != "v" {
+		t.Fatalf("Expected RESP3 map for HGETALL, got %+v", reply)
+	}
+	reply = sendCommand(t, conn, reader, "ZRANGE", "z", "0", "-1", "WITHSCORES")
+	if len(reply.Array) != 1 || len(reply.Array[0].Array) != 2 {
+		t.Fatalf("Expected [member, score] pairs, got %+v", reply)
+	}
+	if score := reply.Array[0].Array[1]; score.Type != protocol.RESP_DOUBLE || score.Double != 1.5 {
+		t.Fatalf("Expected double score, got %+v", score)
+	}
+	if reply := sendCommand(t, conn, reader, "GET", "missing"); reply.Type != protocol.RESP_NULL {
+		t.Fatalf("Expected RESP3 null, got %+v", reply)
+	}
+
+	// 切回 RESP2
+	sendCommand(t, conn, reader, "HELLO", "2")
+	if reply := sendCommand(t, conn, reader, "HGETALL", "h"); reply.Type != protocol.RESP_ARRAY {
+		t.Fatalf("Expected RESP2 array after HELLO 2, got %v", reply)
+	}
+
+	if reply := sendCommand(t, conn, reader, "HELLO", "4"); reply.Type != protocol.RESP_ERROR || !strings.HasPrefix(reply.Str, "NOPROTO") {
+		t.Fatalf("Expected NOPROTO error, got %v", reply)
+	}
+}
+
+// TestInlineCommand 测试服务器接受内联命令（telnet 调试）
+func TestInlineCommand(t *testing.T) {
+	_, addr := startTestServer(t)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+
+	conn.Write([]byte("SET greeting \"hello world\"\r\nGET greeting\r\n"))
+	if reply, err := protocol.Decode(reader); err != nil || reply.Str != "OK" {
+		t.Fatalf("Expected OK, got %v (%v)", reply, err)
+	}
+	if reply, err := protocol.Decode(reader); err != nil || reply.Str != "hello world" {
+		t.Fatalf("Expected hello world, got %v (%v)", reply, err)
+	}
+}
+
+// TestWaitReplicaAck 测试 WAIT 等待模拟从节点的 REPLCONF ACK
+func TestWaitReplicaAck(t *testing.T) {
+	_, addr := startTestServer(t)
+
+	// 模拟从节点：发送 PSYNC 后读取全量同步数据，之后持续丢弃主节点的传播流
+	replica, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer replica.Close()
+	replica.SetDeadline(time.Now().Add(5 * time.Second))
+	replicaReader := bufio.NewReader(replica)
+	replica.Write(protocol.NewArray([]*protocol.RESPValue{
+		protocol.NewBulkString("PSYNC"), protocol.NewBulkString("?"), protocol.NewBulkString("-1"),
+	}).Encode())
+	go io.Copy(io.Discard, replicaReader)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+
+	for !strings.Contains(sendCommand(t, conn, reader, "INFO", "replication").ToString(), "connected_slaves:1") {
+		time.Sleep(10 * time.Millisecond)
+	}
+	sendCommand(t, conn, reader, "SET", "k", "v")
+
+	// 从节点尚未确认，等到超时返回 0
+	if reply := sendCommand(t, conn, reader, "WAIT", "1", "50"); reply.Int != 0 {
+		t.Fatalf("Expected 0 acked replicas before ACK, got %v", reply)
+	}
+
+	info := sendCommand(t, conn, reader, "INFO", "replication").ToString()
+	var offset string
+	for _, line := range strings.Split(info, "\n") {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(line), "master_repl_offset:"); ok {
+			offset = value
+		}
+	}
+	if offset == "" || offset == "0" {
+		t.Fatalf("Expected a non-zero master_repl_offset, got %q", info)
+	}
+
+	// 从节点在 WAIT 阻塞期间确认偏移量
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		replica.Write(protocol.NewArray([]*protocol.RESPValue{
+			protocol.NewBulkString("REPLCONF"), protocol.NewBulkString("ACK"), protocol.NewBulkString(offset),
+		}).Encode())
+	}()
+	if reply := sendCommand(t, conn, reader, "WAIT", "1", "2000"); reply.Int != 1 {
+		t.Fatalf("Expected 1 acked replica, got %v", reply)
+	}
+}
+
+func TestClientCommand(t *testing.T) {
+	_, addr := startTestServer(t)
+
+	dial := func() (net.Conn, *bufio.Reader) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		return conn, bufio.NewReader(conn)
+	}
+
+	conn, reader := dial()
+	defer conn.Close()
+	other, otherReader := dial()
+	defer other.Close()
+
+	if reply := sendCommand(t, conn, reader, "CLIENT", "GETNAME"); !reply.Null {
+		t.Fatalf("Expected nil name before SETNAME, got %v", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "CLIENT", "SETNAME", "bad name"); reply.Type != protocol.RESP_ERROR {
+		t.Fatalf("Expected error for name with spaces, got %v", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "CLIENT", "SETNAME", "worker-1"); reply.Str != "OK" {
+		t.Fatalf("CLIENT SETNAME failed: %v", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "CLIENT", "GETNAME"); reply.Str != "worker-1" {
+		t.Fatalf("Expected worker-1, got %v", reply)
+	}
+
+	id := sendCommand(t, conn, reader, "CLIENT", "ID").Int
+	otherID := sendCommand(t, other, otherReader, "CLIENT", "ID").Int
+	if id <= 0 || otherID <= id {
+		t.Fatalf("Expected increasing client IDs, got %d and %d", id, otherID)
+	}
+
+	sendCommand(t, conn, reader, "SELECT", "2")
+	list := sendCommand(t, conn, reader, "CLIENT", "LIST").Str
+	self := fmt.Sprintf("id=%d addr=%s ", id, conn.LocalAddr())
+	found := false
+	for _, line := range strings.Split(strings.TrimSuffix(list, "\n"), "\n") {
+		if strings.HasPrefix(line, self) {
+			found = strings.Contains(line, " name=worker-1 ") && strings.Contains(line, " db=2 ")
+		}
+	}
+	if !found {
+		t.Fatalf("CLIENT LIST does not describe the current client:\n%s", list)
+	}
+
+	reply := sendCommand(t, conn, reader, "CLIENT", "KILL", "ID", strconv.FormatInt(otherID, 10))
+	if reply.Int != 1 {
+		t.Fatalf("Expected CLIENT KILL ID to kill 1 client, got %v", reply)
+	}
+	if _, err := otherReader.ReadByte(); err == nil {
+		t.Fatal("Expected killed connection to be closed")
+	}
+	if reply := sendCommand(t, conn, reader, "CLIENT", "KILL", other.LocalAddr().String()); reply.Type != protocol.RESP_ERROR {
+		t.Fatalf("Expected error killing a closed client, got %v", reply)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	srv, addr := startTestServer(t)
+	srv.config.Set("requirepass", "secret")
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+
+	if reply := sendCommand(t, conn, reader, "SET", "k", "v"); reply.Str != "NOAUTH Authentication required." {
+		t.Fatalf("Expected NOAUTH before AUTH, got %v", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "AUTH", "wrong"); !strings.HasPrefix(reply.Str, "WRONGPASS") {
+		t.Fatalf("Expected WRONGPASS, got %v", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "GET", "k"); reply.Type != protocol.RESP_ERROR {
+		t.Fatalf("Expected GET to be rejected after a failed AUTH, got %v", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "AUTH", "secret"); reply.Str != "OK" {
+		t.Fatalf("AUTH failed: %v", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "SET", "k", "v"); reply.Str != "OK" {
+		t.Fatalf("Expected SET to succeed after AUTH, got %v", reply)
+	}
+
+	// HELLO 内联认证
+	other, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer other.Close()
+	other.SetDeadline(time.Now().Add(5 * time.Second))
+	otherReader := bufio.NewReader(other)
+
+	if reply := sendCommand(t, other, otherReader, "HELLO", "2"); !strings.HasPrefix(reply.Str, "NOAUTH") {
+		t.Fatalf("Expected HELLO without AUTH to be rejected, got %v", reply)
+	}
+	if reply := sendCommand(t, other, otherReader, "HELLO", "2", "AUTH", "default", "wrong"); !strings.HasPrefix(reply.Str, "WRONGPASS") {
+		t.Fatalf("Expected WRONGPASS from HELLO, got %v", reply)
+	}
+	if reply := sendCommand(t, other, otherReader, "HELLO", "2", "AUTH", "default", "secret"); reply.Type != protocol.RESP_ARRAY {
+		t.Fatalf("HELLO AUTH failed: %v", reply)
+	}
+	if reply := sendCommand(t, other, otherReader, "GET", "k"); reply.Str != "v" {
+		t.Fatalf("Expected GET to succeed after HELLO AUTH, got %v", reply)
+	}
+}
+
+func TestShutdownNoSave(t *testing.T) {
+	srv, addr := startTestServer(t)
+	srv.rdbFilename = filepath.Join(t.TempDir(), "dump.rdb")
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+
+	sendCommand(t, conn, reader, "SET", "k", "v")
+	if reply := sendCommand(t, conn, reader, "SHUTDOWN", "BOGUS"); reply.Type != protocol.RESP_ERROR {
+		t.Fatalf("Expected syntax error, got %v", reply)
+	}
+
+	conn.Write(protocol.NewArray([]*protocol.RESPValue{
+		protocol.NewBulkString("SHUTDOWN"), protocol.NewBulkString("NOSAVE"),
+	}).Encode())
+	// 成功时没有回复，连接直接关闭
+	if _, err := reader.ReadByte(); err == nil {
+		t.Fatal("Expected connection to be closed without a reply")
+	}
+
+	select {
+	case <-srv.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Server did not stop after SHUTDOWN")
+	}
+	if _, err := net.Dial("tcp", addr); err == nil {
+		t.Fatal("Expected server to stop accepting connections")
+	}
+	if _, err := os.Stat(srv.rdbFilename); !os.IsNotExist(err) {
+		t.Fatalf("Expected no dump file after SHUTDOWN NOSAVE, got %v", err)
+	}
+}
+
+func TestSlowlog(t *testing.T) {
+	srv, addr := startTestServer(t)
+	srv.config.Set("slowlog-log-slower-than", "50000")
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+
+	sendCommand(t, conn, reader, "CLIENT", "SETNAME", "slow-client")
+	sendCommand(t, conn, reader, "SET", "fast", "1")
+	sendCommand(t, conn, reader, "DEBUG", "SLEEP", "0.1")
+
+	if reply := sendCommand(t, conn, reader, "SLOWLOG", "LEN"); reply.Int != 1 {
+		t.Fatalf("Expected 1 slow command, got %v", reply)
+	}
+	entries := sendCommand(t, conn, reader, "SLOWLOG", "GET").Array
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 entry, got %v", entries)
+	}
+	entry := entries[0].Array
+	if entry[2].Int < 100000 {
+		t.Fatalf("Expected duration >= 100000us, got %d", entry[2].Int)
+	}
+	assertArgs := []string{"DEBUG", "SLEEP", "0.1"}
+	for i, arg := range entry[3].Array {
+		if arg.Str != assertArgs[i] {
+			t.Fatalf("Unexpected slowlog args: %v", entry[3])
+		}
+	}
+	if entry[4].Str != conn.LocalAddr().String() || entry[5].Str != "slow-client" {
+		t.Fatalf("Unexpected client info: %v %v", entry[4], entry[5])
+	}
+
+	if reply := sendCommand(t, conn, reader, "SLOWLOG", "RESET"); reply.Str != "OK" {
+		t.Fatalf("SLOWLOG RESET failed: %v", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "SLOWLOG", "LEN"); reply.Int != 0 {
+		t.Fatalf("Expected empty slowlog after RESET, got %v", reply)
+	}
+}
+
+// TestSlowLogRingBuffer 测试慢查询日志写满后覆盖最旧的记录
+func TestSlowLogRingBuffer(t *testing.T) {
+	sl := NewSlowLog()
+	for i := 0; i < 5; i++ {
+		sl.Add([]string{"CMD", strconv.Itoa(i)}, time.Millisecond, "127.0.0.1:1", "", 3)
+	}
+	entries := sl.Get(-1)
+	if len(entries) != 3 || entries[0].ID != 4 || entries[2].ID != 2 {
+		t.Fatalf("Expected newest 3 entries (4, 3, 2), got %d entries starting at %d", len(entries), entries[0].ID)
+	}
+
+	// 缩小容量时保留最新的记录
+	sl.Add([]string{"CMD", "5"}, time.Millisecond, "127.0.0.1:1", "", 2)
+	entries = sl.Get(-1)
+	if len(entries) != 2 || entries[0].ID != 5 || entries[1].ID != 4 {
+		t.Fatalf("Unexpected entries after shrinking: %d entries starting at %d", len(entries), entries[0].ID)
+	}
+
+	args := make([]string, 40)
+	for i := range args {
+		args[i] = strings.Repeat("x", 200)
+	}
+	sl.Add(args, time.Millisecond, "127.0.0.1:1", "", 2)
+	entry := sl.Get(1)[0]
+	if len(entry.Args) != SLOWLOG_ENTRY_MAX_ARGC || entry.Args[31] != "... (9 more arguments)" {
+		t.Fatalf("Expected arguments to be truncated, got %d args ending with %q", len(entry.Args), entry.Args[len(entry.Args)-1])
+	}
+	if entry.Args[0] != strings.Repeat("x", 128)+"... (72 more bytes)" {
+		t.Fatalf("Expected long argument to be truncated, got %q", entry.Args[0])
+	}
+}
+
+func TestWatchAbortsExec(t *testing.T) {
+	_, addr := startTestServer(t)
+
+	dial := func() (net.Conn, *bufio.Reader) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		return conn, bufio.NewReader(conn)
+	}
+	conn, reader := dial()
+	defer conn.Close()
+	other, otherReader := dial()
+	defer other.Close()
+
+	// 未被修改的键：事务正常执行
+	sendCommand(t, conn, reader, "SET", "balance", "10")
+	if reply := sendCommand(t, conn, reader, "WATCH", "balance"); reply.Str != "OK" {
+		t.Fatalf("WATCH failed: %v", reply)
+	}
+	sendCommand(t, conn, reader, "MULTI")
+	sendCommand(t, conn, reader, "INCRBY", "balance", "5")
+	reply := sendCommand(t, conn, reader, "EXEC")
+	if reply.Null || len(reply.Array) != 1 || reply.Array[0].Int != 15 {
+		t.Fatalf("Expected EXEC to succeed, got %v", reply)
+	}
+
+	// 其它客户端修改了监视的键：事务放弃执行
+	sendCommand(t, conn, reader, "WATCH", "balance")
+	sendCommand(t, other, otherReader, "SET", "balance", "100")
+	sendCommand(t, conn, reader, "MULTI")
+	sendCommand(t, conn, reader, "INCRBY", "balance", "5")
+	if reply := sendCommand(t, conn, reader, "EXEC"); reply.Type != protocol.RESP_ARRAY || !reply.Null {
+		t.Fatalf("Expected null array from aborted EXEC, got %v", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "GET", "balance"); reply.Str != "100" {
+		t.Fatalf("Expected aborted transaction not to run, got %v", reply)
+	}
+
+	// 原地修改（LPUSH 到已存在的列表）同样使事务失效
+	sendCommand(t, conn, reader, "RPUSH", "queue", "a")
+	sendCommand(t, conn, reader, "WATCH", "queue")
+	sendCommand(t, other, otherReader, "LPUSH", "queue", "b")
+	sendCommand(t, conn, reader, "MULTI")
+	sendCommand(t, conn, reader, "RPOP", "queue")
+	if reply := sendCommand(t, conn, reader, "EXEC"); !reply.Null {
+		t.Fatalf("Expected EXEC to abort after LPUSH on watched list, got %v", reply)
+	}
+
+	// EXEC 之后监视状态被清除，DISCARD 也会清除监视
+	sendCommand(t, other, otherReader, "SET", "balance", "1")
+	sendCommand(t, conn, reader, "WATCH", "balance")
+	sendCommand(t, conn, reader, "MULTI")
+	sendCommand(t, conn, reader, "DISCARD")
+	sendCommand(t, other, otherReader, "SET", "balance", "2")
+	sendCommand(t, conn, reader, "MULTI")
+	sendCommand(t, conn, reader, "GET", "balance")
+	if reply := sendCommand(t, conn, reader, "EXEC"); reply.Null || reply.Array[0].Str != "2" {
+		t.Fatalf("Expected EXEC to run after DISCARD cleared the watch, got %v", reply)
+	}
+}
+
+func TestMultiQueueing(t *testing.T) {
+	_, addr := startTestServer(t)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+
+	sendCommand(t, conn, reader, "MULTI")
+	if reply := sendCommand(t, conn, reader, "SET", "k", "v"); reply.Str != "QUEUED" {
+		t.Fatalf("Expected QUEUED, got %v", reply)
+	}
+	// 入队的命令不会立即执行
+	other, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer other.Close()
+	other.SetDeadline(time.Now().Add(5 * time.Second))
+	otherReader := bufio.NewReader(other)
+	if reply := sendCommand(t, other, otherReader, "GET", "k"); !reply.Null {
+		t.Fatalf("Expected queued SET not to run before EXEC, got %v", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "EXEC"); len(reply.Array) != 1 || reply.Array[0].Str != "OK" {
+		t.Fatalf("Unexpected EXEC reply: %v", reply)
+	}
+
+	// 参数个数错误或未知命令：入队失败，EXEC 丢弃整个事务
+	sendCommand(t, conn, reader, "MULTI")
+	sendCommand(t, conn, reader, "SET", "k", "v2")
+	if reply := sendCommand(t, conn, reader, "GET"); reply.Type != protocol.RESP_ERROR {
+		t.Fatalf("Expected arity error at queue time, got %v", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "NOSUCHCOMMAND"); reply.Type != protocol.RESP_ERROR {
+		t.Fatalf("Expected unknown command error at queue time, got %v", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "EXEC"); !strings.HasPrefix(reply.Str, "EXECABORT") {
+		t.Fatalf("Expected EXECABORT, got %v", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "GET", "k"); reply.Str != "v" {
+		t.Fatalf("Expected aborted transaction not to run, got %v", reply)
+	}
+
+	// 超过 maxmemory：可能增加内存的写命令入队失败，EXEC 丢弃整个事务
+	sendCommand(t, conn, reader, "CONFIG", "SET", "maxmemory-policy", "noeviction")
+	sendCommand(t, conn, reader, "CONFIG", "SET", "maxmemory", "1")
+	sendCommand(t, conn, reader, "MULTI")
+	if reply := sendCommand(t, conn, reader, "SET", "k", "v3"); reply.Type != protocol.RESP_ERROR || !strings.HasPrefix(reply.Str, "OOM ") {
+		t.Fatalf("Expected OOM error at queue time, got %v", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "GET", "k"); reply.Str != "QUEUED" {
+		t.Fatalf("Expected GET to be queued under OOM, got %v", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "EXEC"); !strings.HasPrefix(reply.Str, "EXECABORT") {
+		t.Fatalf("Expected EXECABORT, got %v", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "GET", "k"); reply.Str != "v" {
+		t.Fatalf("Expected aborted transaction not to run, got %v", reply)
+	}
+}
+
+// TestReset 测试 RESET 放弃事务、退订频道、切回 0 号数据库，之后命令正常执行
+func TestReset(t *testing.T) {
+	_, addr := startTestServer(t)
+
+	dial := func() (net.Conn, *bufio.Reader) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		return conn, bufio.NewReader(conn)
+	}
+	conn, reader := dial()
+	defer conn.Close()
+	other, otherReader := dial()
+	defer other.Close()
+
+	sendCommand(t, conn, reader, "SELECT", "1")
+	sendCommand(t, conn, reader, "SUBSCRIBE", "news")
+	sendCommand(t, conn, reader, "MULTI")
+	if reply := sendCommand(t, conn, reader, "SET", "k", "queued"); reply.Str != "QUEUED" {
+		t.Fatalf("Expected QUEUED, got %v", reply)
+	}
+
+	if reply := sendCommand(t, conn, reader, "RESET"); reply.Type != protocol.RESP_SIMPLE_STRING || reply.Str != "RESET" {
+		t.Fatalf("Expected +RESET, got %v", reply)
+	}
+
+	// 事务已放弃：EXEC 报错，排队的 SET 没有执行
+	if reply := sendCommand(t, conn, reader, "EXEC"); reply.Type != protocol.RESP_ERROR {
+		t.Fatalf("Expected EXEC without MULTI error, got %v", reply)
+	}
+	// 已退订：PUBLISH 没有接收者
+	if reply := sendCommand(t, other, otherReader, "PUBLISH", "news", "hi"); reply.Int != 0 {
+		t.Fatalf("Expected 0 receivers after RESET, got %d", reply.Int)
+	}
+	// 已切回 0 号数据库，普通命令正常执行
+	if reply := sendCommand(t, conn, reader, "SET", "k", "v"); reply.Str != "OK" {
+		t.Fatalf("SET after RESET failed: %v", reply)
+	}
+	if reply := sendCommand(t, other, otherReader, "GET", "k"); reply.Str != "v" {
+		t.Fatalf("Expected RESET to select db 0, got %v", reply)
+	}
+}
+
+// TestBlockingInMulti 测试事务中的阻塞命令不阻塞：有数据时立即弹出，否则立即按超时返回
+func TestBlockingInMulti(t *testing.T) {
+	_, addr := startTestServer(t)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+
+	sendCommand(t, conn, reader, "MULTI")
+	for _, cmd := range [][]string{
+		{"RPUSH", "list", "a"},
+		{"BLPOP", "nolist", "list", "0"},
+		{"BLPOP", "nolist", "0"},
+		{"BRPOP", "nolist", "0"},
+	} {
+		if reply := sendCommand(t, conn, reader, cmd...); reply.Str != "QUEUED" {
+			t.Fatalf("Expected %s to be queued, got %v", cmd[0], reply)
+		}
+	}
+
+	start := time.Now()
+	reply := sendCommand(t, conn, reader, "EXEC")
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("EXEC took %v, expected blocking commands not to block", elapsed)
+	}
+	if len(reply.Array) != 4 {
+		t.Fatalf("Expected 4 replies, got %v", reply)
+	}
+	if popped := reply.Array[1]; len(popped.Array) != 2 || popped.Array[0].Str != "list" || popped.Array[1].Str != "a" {
+		t.Fatalf("Expected BLPOP to pop [list a], got %v", popped)
+	}
+	for i, result := range reply.Array[2:] {
+		if !result.Null {
+			t.Fatalf("Expected reply %d to be nil, got %v", i+2, result)
+		}
+	}
+
+	// BLMOVE / BRPOPLPUSH
+	sendCommand(t, conn, reader, "MULTI")
+	for _, cmd := range [][]string{
+		{"RPUSH", "src", "job"},
+		{"BLMOVE", "src", "dst", "LEFT", "RIGHT", "0"},
+		{"BLMOVE", "src", "dst", "LEFT", "RIGHT", "0"},
+		{"BRPOPLPUSH", "src", "dst", "0"},
+	} {
+		sendCommand(t, conn, reader, cmd...)
+	}
+	reply = sendCommand(t, conn, reader, "EXEC")
+	if len(reply.Array) != 4 || reply.Array[1].Str != "job" || !reply.Array[2].Null || !reply.Array[3].Null {
+		t.Fatalf("Expected [1 job nil nil], got %v", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "LRANGE", "dst", "0", "-1"); len(reply.Array) != 1 || reply.Array[0].Str != "job" {
+		t.Fatalf("Expected dst [job], got %v", reply)
+	}
+
+	// BZPOPMIN / BZPOPMAX
+	sendCommand(t, conn, reader, "MULTI")
+	for _, cmd := range [][]string{
+		{"ZADD", "z", "1", "low", "9", "high"},
+		{"BZPOPMAX", "noz", "z", "0"},
+		{"BZPOPMIN", "noz", "z", "0"},
+		{"BZPOPMIN", "noz", "z", "0"},
+		{"BZPOPMAX", "noz", "0"},
+	} {
+		sendCommand(t, conn, reader, cmd...)
+	}
+	reply = sendCommand(t, conn, reader, "EXEC")
+	if len(reply.Array) != 5 {
+		t.Fatalf("Expected 5 replies, got %v", reply)
+	}
+	if popped := reply.Array[1]; len(popped.Array) != 3 || popped.Array[1].Str != "high" {
+		t.Fatalf("Expected BZPOPMAX to pop high, got %v", popped)
+	}
+	if popped := reply.Array[2]; len(popped.Array) != 3 || popped.Array[1].Str != "low" {
+		t.Fatalf("Expected BZPOPMIN to pop low, got %v", popped)
+	}
+	if !reply.Array[3].Null || !reply.Array[4].Null {
+		t.Fatalf("Expected nil from empty sorted sets, got %v %v", reply.Array[3], reply.Array[4])
+	}
+}
+
+// TestBlockingListMove 测试 BRPOPLPUSH / BLMOVE 阻塞到源列表有元素推入，超时返回 nil
+func TestBlockingListMove(t *testing.T) {
+	_, addr := startTestServer(t)
+
+	dial := func() (net.Conn, *bufio.Reader) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		return conn, bufio.NewReader(conn)
+	}
+	blocked, blockedReader := dial()
+	defer blocked.Close()
+	conn, reader := dial()
+	defer conn.Close()
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		conn.Write(protocol.NewArray([]*protocol.RESPValue{
+			protocol.NewBulkString("RPUSH"),
+			protocol.NewBulkString("src"),
+			protocol.NewBulkString("job"),
+		}).Encode())
+	}()
+
+	start := time.Now()
+	if reply := sendCommand(t, blocked, blockedReader, "BRPOPLPUSH", "src", "dst", "5"); reply.Str != "job" {
+		t.Fatalf("Expected BRPOPLPUSH to return job, got %v", reply)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("BRPOPLPUSH returned after %v, expected it to block", elapsed)
+	}
+
+	// RPUSH 的回复：元素已被移走，推入时列表长度为 1
+	if reply, err := protocol.Decode(reader); err != nil || reply.Int != 1 {
+		t.Fatalf("Unexpected RPUSH reply %v (%v)", reply, err)
+	}
+	if reply := sendCommand(t, conn, reader, "LLEN", "src"); reply.Int != 0 {
+		t.Fatalf("Expected source to be empty, got %d", reply.Int)
+	}
+	if reply := sendCommand(t, conn, reader, "LRANGE", "dst", "0", "-1"); len(reply.Array) != 1 || reply.Array[0].Str != "job" {
+		t.Fatalf("Expected dst [job], got %v", reply)
+	}
+
+	// BLMOVE：从左侧取出放到目标右侧
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		conn.Write(protocol.NewArray([]*protocol.RESPValue{
+			protocol.NewBulkString("RPUSH"),
+			protocol.NewBulkString("src"),
+			protocol.NewBulkString("first"),
+			protocol.NewBulkString("second"),
+		}).Encode())
+	}()
+	if reply := sendCommand(t, blocked, blockedReader, "BLMOVE", "src", "dst", "LEFT", "RIGHT", "5"); reply.Str != "first" {
+		t.Fatalf("Expected BLMOVE to return first, got %v", reply)
+	}
+	protocol.Decode(reader)
+	if reply := sendCommand(t, conn, reader, "LRANGE", "dst", "0", "-1"); len(reply.Array) != 2 || reply.Array[1].Str != "first" {
+		t.Fatalf("Expected dst [job first], got %v", reply)
+	}
+
+	// 超时返回 nil
+	start = time.Now()
+	if reply := sendCommand(t, blocked, blockedReader, "BRPOPLPUSH", "empty", "dst", "0.1"); !reply.Null {
+		t.Fatalf("Expected nil on timeout, got %v", reply)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Fatalf("BRPOPLPUSH timed out after %v, expected at least 100ms", elapsed)
+	}
+}
+
+// TestBlockingZSetPop 测试 BZPOPMIN 阻塞到 ZADD 加入成员，弹出新加入的最小成员
+func TestBlockingZSetPop(t *testing.T) {
+	_, addr := startTestServer(t)
+
+	dial := func() (net.Conn, *bufio.Reader) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		return conn, bufio.NewReader(conn)
+	}
+	blocked, blockedReader := dial()
+	defer blocked.Close()
+	conn, reader := dial()
+	defer conn.Close()
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		conn.Write(protocol.NewArray([]*protocol.RESPValue{
+			protocol.NewBulkString("ZADD"),
+			protocol.NewBulkString("z2"),
+			protocol.NewBulkString("3"),
+			protocol.NewBulkString("c"),
+			protocol.NewBulkString("1.5"),
+			protocol.NewBulkString("a"),
+		}).Encode())
+	}()
+
+	reply := sendCommand(t, blocked, blockedReader, "BZPOPMIN", "z1", "z2", "5")
+	if len(reply.Array) != 3 || reply.Array[0].Str != "z2" || reply.Array[1].Str != "a" || reply.Array[2].Str != "1.5" {
+		t.Fatalf("Expected [z2 a 1.5], got %v", reply)
+	}
+	if reply, err := protocol.Decode(reader); err != nil || reply.Int != 2 {
+		t.Fatalf("Unexpected ZADD reply %v (%v)", reply, err)
+	}
+	if reply := sendCommand(t, conn, reader, "ZRANGE", "z2", "0", "-1"); len(reply.Array) != 1 || reply.Array[0].Str != "c" {
+		t.Fatalf("Expected z2 [c], got %v", reply)
+	}
+
+	// 有数据时不阻塞，BZPOPMAX 弹出最大成员
+	sendCommand(t, conn, reader, "ZADD", "z1", "1", "low", "9", "high")
+	reply = sendCommand(t, blocked, blockedReader, "BZPOPMAX", "z1", "0")
+	if len(reply.Array) != 3 || reply.Array[1].Str != "high" || reply.Array[2].Str != "9" {
+		t.Fatalf("Expected [z1 high 9], got %v", reply)
+	}
+
+	// 超时返回 nil
+	if reply := sendCommand(t, blocked, blockedReader, "BZPOPMIN", "empty", "0.1"); !reply.Null {
+		t.Fatalf("Expected nil on timeout, got %v", reply)
+	}
+}
+
+// TestBlockingPopFIFO 测试多个客户端阻塞在同一个键上时，按阻塞的先后顺序依次收到推入的元素
+func TestBlockingPopFIFO(t *testing.T) {
+	srv, addr := startTestServer(t)
+
+	dial := func() (net.Conn, *bufio.Reader) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		return conn, bufio.NewReader(conn)
+	}
+	db, _ := srv.redisServer.GetDb(0)
+	waiters := func() int {
+		srv.blockingMgr.mu.Lock()
+		defer srv.blockingMgr.mu.Unlock()
+		return len(srv.blockingMgr.waitingClients[blockingKey{db: db, key: "queue"}])
+	}
+
+	readers := make([]*bufio.Reader, 3)
+	for i := range readers {
+		conn, reader := dial()
+		defer conn.Close()
+		readers[i] = reader
+		conn.Write(protocol.NewArray([]*protocol.RESPValue{
+			protocol.NewBulkString("BLPOP"),
+			protocol.NewBulkString("queue"),
+			protocol.NewBulkString("5"),
+		}).Encode())
+
+		// 确认前一个客户端已经进入等待队列，保证阻塞顺序
+		deadline := time.Now().Add(2 * time.Second)
+		for waiters() != i+1 {
+			if time.Now().After(deadline) {
+				t.Fatalf("Client %d did not block", i)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	conn, reader := dial()
+	defer conn.Close()
+	if reply := sendCommand(t, conn, reader, "RPUSH", "queue", "first", "second", "third"); reply.Int != 3 {
+		t.Fatalf("Expected RPUSH to report 3, got %v", reply)
+	}
+
+	for i, want := range []string{"first", "second", "third"} {
+		reply, err := protocol.Decode(readers[i])
+		if err != nil {
+			t.Fatalf("Client %d read failed: %v", i, err)
+		}
+		if len(reply.Array) != 2 || reply.Array[0].Str != "queue" || reply.Array[1].Str != want {
+			t.Fatalf("Client %d expected [queue %s], got %v", i, want, reply)
+		}
+	}
+	if reply := sendCommand(t, conn, reader, "EXISTS", "queue"); reply.Int != 0 {
+		t.Fatalf("Expected queue to be drained, got %v", reply)
+	}
+
+	// 超时返回 nil
+	if reply := sendCommand(t, conn, reader, "BRPOP", "queue", "0.1"); !reply.Null {
+		t.Fatalf("Expected nil on timeout, got %v", reply)
+	}
+}
+
+// TestLoadRDBOnStartup 测试启动时加载已有的 dump.rdb，保存过的键（包括 TTL）立即可用；AOF 存在时优先使用 AOF
+func TestLoadRDBOnStartup(t *testing.T) {
+	dir := t.TempDir()
+	rdbFile := filepath.Join(dir, "dump.rdb")
+
+	ctx := newTestContext(t)
+	ctx.Server.rdbFilename = rdbFile
+	execCommand(ctx, "SET", "greeting", "hello")
+	execCommand(ctx, "RPUSH", "list", "a", "b", "c")
+	ctx.Db, _ = ctx.Server.GetRedisServer().GetDb(2)
+	execCommand(ctx, "HSET", "hash", "f", "v")
+	execCommand(ctx, "EXPIRE", "hash", "100")
+	if reply := execCommand(ctx, "SAVE"); reply.Str != "OK" {
+		t.Fatalf("SAVE failed: %v", reply)
+	}
+
+	srv, addr := startTestServerWith(t, func(s *Server) {
+		if err := s.InitRDB(true, rdbFile); err != nil {
+			t.Fatalf("InitRDB failed: %v", err)
+		}
+	})
+	if srv.rdbFilename != rdbFile {
+		t.Fatalf("Expected rdbFilename %s, got %s", rdbFile, srv.rdbFilename)
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	if reply := sendCommand(t, conn, reader, "GET", "greeting"); reply.Str != "hello" {
+		t.Fatalf("Expected hello, got %v", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "LRANGE", "list", "0", "-1"); len(reply.Array) != 3 {
+		t.Fatalf("Expected 3 list elements, got %v", reply)
+	}
+	sendCommand(t, conn, reader, "SELECT", "2")
+	if reply := sendCommand(t, conn, reader, "HGET", "hash", "f"); reply.Str != "v" {
+		t.Fatalf("Expected v, got %v", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "TTL", "hash"); reply.Int <= 0 || reply.Int > 100 {
+		t.Fatalf("Expected TTL to survive the restart, got %v", reply)
+	}
+
+	// AOF 已经恢复数据时不再加载 RDB
+	aofFile := filepath.Join(dir, "appendonly.aof")
+	aof := protocol.NewArray([]*protocol.RESPValue{
+		protocol.NewBulkString("SET"), protocol.NewBulkString("greeting"), protocol.NewBulkString("from-aof"),
+	})
+	os.WriteFile(aofFile, aof.Encode(), 0644)
+
+	server := NewServer("127.0.0.1:0", 16)
+	if err := server.InitAOF(true, aofFile); err != nil {
+		t.Fatalf("InitAOF failed: %v", err)
+	}
+	t.Cleanup(func() { server.aofWriter.Close() })
+	if err := server.InitRDB(true, rdbFile); err != nil {
+		t.Fatalf("InitRDB failed: %v", err)
+	}
+	db, _ := server.GetRedisServer().GetDb(0)
+	if db.Exists("list") {
+		t.Fatal("RDB should not be loaded when the AOF exists")
+	}
+	obj, err := db.Get("greeting")
+	if err != nil {
+		t.Fatalf("Expected greeting from AOF: %v", err)
+	}
+	if value, _ := obj.GetStringValue(); string(value) != "from-aof" {
+		t.Fatalf("Expected from-aof, got %q", value)
+	}
+
+	// 快照文件不存在时正常启动
+	if err := NewServer("127.0.0.1:0", 16).InitRDB(true, filepath.Join(dir, "missing.rdb")); err != nil {
+		t.Fatalf("Expected missing RDB to be ignored, got %v", err)
+	}
+}
+
+// TestAOFReplayOnRestart 测试重启时重放 AOF 恢复键空间（包括其它数据库中的键），并处理末尾不完整的命令
+func TestAOFReplayOnRestart(t *testing.T) {
+	aofFile := filepath.Join(t.TempDir(), "appendonly.aof")
+
+	srv, addr := startTestServerWith(t, func(s *Server) {
+		if err := s.InitAOF(true, aofFile); err != nil {
+			t.Fatalf("InitAOF failed: %v", err)
+		}
+	})
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	reader := bufio.NewReader(conn)
+	for _, cmd := range [][]string{
+		{"SET", "s", "v"},
+		{"INCR", "counter"},
+		{"INCR", "counter"},
+		{"RPUSH", "list", "a", "b", "c"},
+		{"LPOP", "list"},
+		{"HSET", "hash", "f1", "v1", "f2", "v2"},
+		{"SADD", "set", "x", "y"},
+		{"ZADD", "zset", "1", "one", "2", "two"},
+		{"SET", "gone", "v"},
+		{"DEL", "gone"},
+		{"GET", "s"},
+		{"SELECT", "1"},
+		{"SET", "other", "db1"},
+	} {
+		if reply := sendCommand(t, conn, reader, cmd...); reply.Type == protocol.RESP_ERROR {
+			t.Fatalf("%v failed: %s", cmd, reply.Str)
+		}
+	}
+	conn.Close()
+	srv.aofWriter.Close()
+
+	// 模拟重启：新的服务器从同一个 AOF 恢复
+	aofLoadTruncated := "yes"
+	restart := func() (*Server, error) {
+		s := NewServer("127.0.0.1:0", 16)
+		s.config.Set("aof-load-truncated", aofLoadTruncated)
+		err := s.InitAOF(true, aofFile)
+		if err == nil {
+			t.Cleanup(func() { s.aofWriter.Close() })
+		}
+		return s, err
+	}
+	check := func(s *Server) {
+		t.Helper()
+		db0, _ := s.GetRedisServer().GetDb(0)
+		ctx := &CommandContext{Server: s, Db: db0}
+		if reply := execCommand(ctx, "GET", "s"); reply.Str != "v" {
+			t.Fatalf("Expected s=v, got %v", reply)
+		}
+		if reply := execCommand(ctx, "GET", "counter"); reply.Str != "2" {
+			t.Fatalf("Expected counter=2, got %v", reply)
+		}
+		assertStrings(t, replyStrings(t, execCommand(ctx, "LRANGE", "list", "0", "-1")), "b", "c")
+		assertStrings(t, replyStrings(t, execCommand(ctx, "HMGET", "hash", "f1", "f2")), "v1", "v2")
+		if reply := execCommand(ctx, "SCARD", "set"); reply.Int != 2 {
+			t.Fatalf("Expected 2 set members, got %v", reply)
+		}
+		assertStrings(t, replyStrings(t, execCommand(ctx, "ZRANGE", "zset", "0", "-1", "WITHSCORES")), "one", "1", "two", "2")
+		if db0.Exists("gone") || db0.Exists("other") || db0.DBSize() != 6 {
+			t.Fatalf("Unexpected keys in db 0: %v", db0.Keys("*"))
+		}
+		db1, _ := s.GetRedisServer().GetDb(1)
+		ctx.Db = db1
+		if reply := execCommand(ctx, "GET", "other"); reply.Str != "db1" {
+			t.Fatalf("Expected other=db1 in db 1, got %v", reply)
+		}
+	}
+
+	s, err := restart()
+	if err != nil {
+		t.Fatalf("InitAOF failed: %v", err)
+	}
+	check(s)
+	s.aofWriter.Close()
+
+	// 末尾追加一条不完整的命令（写入过程中宕机）
+	info, _ := os.Stat(aofFile)
+	validSize := info.Size()
+	f, _ := os.OpenFile(aofFile, os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString("*3\r\n$3\r\nSET\r\n$1\r\ns\r\n$5\r\nchan")
+	f.Close()
+
+	aofLoadTruncated = "no"
+	if _, err := restart(); err == nil {
+		t.Fatal("Expected truncated AOF to be rejected with aof-load-truncated no")
+	}
+
+	aofLoadTruncated = "yes"
+	s, err = restart()
+	if err != nil {
+		t.Fatalf("Expected truncated AOF to load with aof-load-truncated yes: %v", err)
+	}
+	check(s)
+	if info, _ := os.Stat(aofFile); info.Size() != validSize {
+		t.Fatalf("Expected AOF to be truncated to %d bytes, got %d", validSize, info.Size())
+	}
+}
+
+// TestAOFLogsOnlyWriteCommands 测试只有带 CMD_WRITE 标志的命令会写入 AOF
+func TestAOFLogsOnlyWriteCommands(t *testing.T) {
+	aofFile := filepath.Join(t.TempDir(), "appendonly.aof")
+
+	srv, addr := startTestServerWith(t, func(s *Server) {
+		if err := s.InitAOF(true, aofFile); err != nil {
+			t.Fatalf("InitAOF failed: %v", err)
+		}
+	})
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	var want []string
+	for _, cmd := range [][]string{
+		{"SET", "s", "v"},
+		{"GET", "s"},
+		{"INCR", "counter"},
+		{"EXISTS", "s", "counter"},
+		{"HSET", "hash", "f", "v"},
+		{"HGET", "hash", "f"},
+		{"SADD", "set", "x"},
+		{"SMEMBERS", "set"},
+		{"TTL", "s"},
+		{"PING"},
+		{"EXPIREAT", "s", "4102444800"},
+		{"DEL", "counter"},
+	} {
+		if reply := sendCommand(t, conn, reader, cmd...); reply.Type == protocol.RESP_ERROR {
+			t.Fatalf("%v failed: %s", cmd, reply.Str)
+		}
+		if command, _ := srv.cmdTable.Lookup(cmd[0]); command.Flags&CMD_WRITE != 0 {
+			want = append(want, cmd[0])
+		}
+	}
+	srv.aofWriter.Close()
+
+	var got []string
+	for _, cmd := range aofCommands(t, aofFile) {
+		got = append(got, strings.Fields(cmd)[0])
+	}
+	assertStrings(t, got, want...)
+}
+
+// TestAOFSkipsFailedAndNoopWrites 测试出错或没有修改数据的写命令不写入 AOF（包括事务中的命令）
+func TestAOFSkipsFailedAndNoopWrites(t *testing.T) {
+	aofFile := filepath.Join(t.TempDir(), "appendonly.aof")
+
+	srv, addr := startTestServerWith(t, func(s *Server) {
+		if err := s.InitAOF(true, aofFile); err != nil {
+			t.Fatalf("InitAOF failed: %v", err)
+		}
+	})
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	for _, cmd := range [][]string{
+		{"SET", "s", "v"},
+		{"LPUSH", "s", "a"},    // 类型错误
+		{"LPUSH", "list", "a"}, // 成功
+		{"DEL", "missing"},     // 键不存在
+		{"SADD", "set", "x"},   // 成功
+		{"SADD", "set", "x"},   // 成员已存在
+		{"EXPIRE", "missing", "10"},
+		{"LPOP", "missing"},
+		{"MULTI"},
+		{"LPUSH", "s", "b"},
+		{"RPUSH", "list", "b"},
+		{"SREM", "set", "nope"},
+		{"EXEC"},
+	} {
+		sendCommand(t, conn, reader, cmd...)
+	}
+	srv.aofWriter.Close()
+
+	assertStrings(t, aofCommands(t, aofFile),
+		"SET s v", "LPUSH list a", "SADD set x", "RPUSH list b")
+}
+
+// TestAOFPropagatesEffects 测试结果不确定的写命令以确定的效果写入 AOF：
+// SPOP 写入被选中成员的 SREM，EXPIRE 写入绝对时间的 PEXPIREAT
+func TestAOFPropagatesEffects(t *testing.T) {
+	aofFile := filepath.Join(t.TempDir(), "appendonly.aof")
+
+	srv, addr := startTestServerWith(t, func(s *Server) {
+		if err := s.InitAOF(true, aofFile); err != nil {
+			t.Fatalf("InitAOF failed: %v", err)
+		}
+	})
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	sendCommand(t, conn, reader, "SADD", "set", "a", "b", "c")
+	popped := sendCommand(t, conn, reader, "SPOP", "set", "2")
+	members := replyStrings(t, popped)
+	sendCommand(t, conn, reader, "SET", "k", "v")
+	sendCommand(t, conn, reader, "EXPIRE", "k", "100")
+	sendCommand(t, conn, reader, "EXPIRE", "k", "-1")
+	sendCommand(t, conn, reader, "HINCRBYFLOAT", "h", "f", "0.5")
+	sendCommand(t, conn, reader, "RPUSH", "list", "x")
+	sendCommand(t, conn, reader, "BLPOP", "empty", "list", "0")
+	srv.aofWriter.Close()
+
+	logged := aofCommands(t, aofFile)
+	if len(logged) != 8 {
+		t.Fatalf("Unexpected AOF commands: %v", logged)
+	}
+	assertStrings(t, logged[:2], "SADD set a b c", "SREM set "+strings.Join(members, " "))
+	var ms int64
+	if _, err := fmt.Sscanf(logged[3], "PEXPIREAT k %d", &ms); err != nil || ms < time.Now().Add(99*time.Second).UnixMilli() {
+		t.Fatalf("Expected EXPIRE to be logged as PEXPIREAT with an absolute time, got %q", logged[3])
+	}
+	assertStrings(t, logged[4:], "DEL k", "HSET h f 0.5", "RPUSH list x", "LPOP list")
+}
+
+// TestAOFServedBlockingPops 测试推入方代替阻塞的客户端弹出时，弹出的效果紧跟在推入命令之后写入 AOF，
+// BLMOVE 移入目标列表后继续为等待目标列表的客户端弹出
+func TestAOFServedBlockingPops(t *testing.T) {
+	aofFile := filepath.Join(t.TempDir(), "appendonly.aof")
+
+	srv, addr := startTestServerWith(t, func(s *Server) {
+		if err := s.InitAOF(true, aofFile); err != nil {
+			t.Fatalf("InitAOF failed: %v", err)
+		}
+	})
+	dial := func() (net.Conn, *bufio.Reader) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		return conn, bufio.NewReader(conn)
+	}
+	// block 发送阻塞命令并等待它进入等待队列，返回读取回复的 channel
+	waiting := 0
+	block := func(args ...string) <-chan *protocol.RESPValue {
+		conn, reader := dial()
+		t.Cleanup(func() { conn.Close() })
+		values := make([]*protocol.RESPValue, len(args))
+		for i, arg := range args {
+			values[i] = protocol.NewBulkString(arg)
+		}
+		conn.Write(protocol.NewArray(values).Encode())
+		replies := make(chan *protocol.RESPValue, 1)
+		go func() {
+			reply, _ := protocol.Decode(reader)
+			replies <- reply
+		}()
+
+		waiting++
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			srv.blockingMgr.mu.Lock()
+			n := len(srv.blockingMgr.waitingClients)
+			srv.blockingMgr.mu.Unlock()
+			if n >= waiting {
+				return replies
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s did not block", args[0])
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	conn, reader := dial()
+	defer conn.Close()
+
+	popped := block("BLPOP", "list", "5")
+	zpopped := block("BZPOPMIN", "z", "5")
+	moved := block("BLMOVE", "src", "dst", "LEFT", "RIGHT", "5")
+	chained := block("BRPOP", "dst", "5")
+
+	sendCommand(t, conn, reader, "RPUSH", "list", "x")
+	sendCommand(t, conn, reader, "ZADD", "z", "1", "a")
+	sendCommand(t, conn, reader, "RPUSH", "src", "job")
+	for _, replies := range []<-chan *protocol.RESPValue{popped, zpopped, moved, chained} {
+		if reply := <-replies; reply == nil || reply.Type == protocol.RESP_ERROR {
+			t.Fatalf("Unexpected reply from a served client: %v", reply)
+		}
+	}
+	srv.aofWriter.Close()
+
+	assertStrings(t, aofCommands(t, aofFile),
+		"RPUSH list x", "LPOP list",
+		"ZADD z 1 a", "ZREM z a",
+		"RPUSH src job", "LMOVE src dst LEFT RIGHT", "RPOP dst")
+}
+
+// aofCommands 读取 AOF 中的命令（每条命令的参数以空格连接，不包括 SELECT）
+func aofCommands(t *testing.T, aofFile string) []string {
+	t.Helper()
+	loader, err := persistence.NewAOFLoader(aofFile)
+	if err != nil {
+		t.Fatalf("Failed to open AOF: %v", err)
+	}
+	defer loader.Close()
+	logged, err := loader.Load()
+	if err != nil {
+		t.Fatalf("Failed to load AOF: %v", err)
+	}
+
+	var commands []string
+	for _, cmd := range logged {
+		argv := make([]string, 0, len(cmd.GetArray()))
+		for _, arg := range cmd.GetArray() {
+			argv = append(argv, arg.ToString())
+		}
+		if argv[0] != "SELECT" {
+			commands = append(commands, strings.Join(argv, " "))
+		}
+	}
+	return commands
+}
+
+// keyspaceDump 将服务器所有数据库的键展开为可比较的字符串（类型、值、过期时间）
+func keyspaceDump(t *testing.T, s *Server) map[string]string {
+	t.Helper()
+	result := make(map[string]string)
+	for i := 0; i < s.GetRedisServer().GetDbNum(); i++ {
+		db, _ := s.GetRedisServer().GetDb(i)
+		ctx := &CommandContext{Server: s, Db: db}
+		for _, key := range db.Keys("*") {
+			typ := execCommand(ctx, "TYPE", key).Str
+			var value []string
+			switch typ {
+			case "string":
+				value = []string{execCommand(ctx, "GET", key).Str}
+			case "list":
+				value = replyStrings(t, execCommand(ctx, "LRANGE", key, "0", "-1"))
+			case "set":
+				value = replyStrings(t, execCommand(ctx, "SMEMBERS", key))
+				sort.Strings(value)
+			case "zset":
+				value = replyStrings(t, execCommand(ctx, "ZRANGE", key, "0", "-1", "WITHSCORES"))
+			case "hash":
+				value = replyStrings(t, execCommand(ctx, "HGETALL", key))
+				fields := make([]string, 0, len(value)/2)
+				for j := 0; j+1 < len(value); j += 2 {
+					fields = append(fields, value[j]+"="+value[j+1])
+				}
+				sort.Strings(fields)
+				value = fields
+			}
+			expireAt, _ := db.GetExpireAt(key)
+			result[fmt.Sprintf("%d:%s", i, key)] = fmt.Sprintf("%s|%q|%d", typ, value, expireAt)
+		}
+	}
+	return result
+}
+
+// TestAOFRewrite 测试 BGREWRITEAOF 生成每个键一条命令的 AOF，重放后得到相同的键空间；
+// 重写期间执行的写命令在重写后的 AOF 中恰好生效一次
+func TestAOFRewrite(t *testing.T) {
+	aofFile := filepath.Join(t.TempDir(), "appendonly.aof")
+	srv, addr := startTestServerWith(t, func(s *Server) {
+		if err := s.InitAOF(true, aofFile); err != nil {
+			t.Fatalf("InitAOF failed: %v", err)
+		}
+	})
+	t.Cleanup(func() { srv.aofWriter.Close() })
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for _, cmd := range [][]string{
+		{"SET", "s", "old"},
+		{"SET", "s", "new"},
+		{"RPUSH", "list", "a", "b", "c", "d"},
+		{"LPOP", "list"},
+		{"HSET", "hash", "f1", "v1", "f2", "v2"},
+		{"HPEXPIREAT", "hash", "99999999999999", "FIELDS", "1", "f2"},
+		{"SADD", "set", "1", "2", "x"},
+		{"ZADD", "zset", "1.5", "a", "-inf", "b", "3", "c"},
+		{"SET", "volatile", "v"},
+		{"PEXPIREAT", "volatile", "99999999999000"},
+		{"SET", "gone", "v"},
+		{"DEL", "gone"},
+		{"SELECT", "3"},
+		{"SET", "db3", "v"},
+		{"SELECT", "0"},
+	} {
+		if reply := sendCommand(t, conn, reader, cmd...); reply.Type == protocol.RESP_ERROR {
+			t.Fatalf("%v failed: %s", cmd, reply.Str)
+		}
+	}
+	for i := 0; i < 2000; i++ {
+		sendCommand(t, conn, reader, "SET", "key:"+strconv.Itoa(i), strconv.Itoa(i))
+	}
+
+	// 重写期间另一个客户端持续修改已有的键
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		r := bufio.NewReader(c)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				sendCommand(t, c, r, "INCR", "counter")
+				sendCommand(t, c, r, "RPUSH", "list", "x")
+			}
+		}
+	}()
+
+	if reply := sendCommand(t, conn, reader, "BGREWRITEAOF"); reply.Type == protocol.RESP_ERROR {
+		t.Fatalf("BGREWRITEAOF failed: %s", reply.Str)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for srv.aofRewriting.Load() {
+		if time.Now().After(deadline) {
+			t.Fatal("AOF rewrite did not finish in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	// 重写完成后的写入追加到新文件
+	sendCommand(t, conn, reader, "SET", "after", "rewrite")
+	close(stop)
+	wg.Wait()
+
+	info := sendCommand(t, conn, reader, "INFO", "persistence").Str
+	if !strings.Contains(info, "aof_last_bgrewrite_status:ok") {
+		t.Fatalf("Expected successful rewrite, got %q", info)
+	}
+
+	// 被覆盖和删除的历史命令不再出现在 AOF 中
+	data, _ := os.ReadFile(aofFile)
+	if strings.Contains(string(data), "old") || strings.Contains(string(data), "gone") {
+		t.Fatalf("Rewritten AOF still contains overwritten commands")
+	}
+
+	// 重放重写后的 AOF，键空间与原服务器一致
+	replayed := NewServer("127.0.0.1:0", 16)
+	if err := replayed.InitAOF(true, aofFile); err != nil {
+		t.Fatalf("Replaying rewritten AOF failed: %v", err)
+	}
+	t.Cleanup(func() { replayed.aofWriter.Close() })
+
+	want, got := keyspaceDump(t, srv), keyspaceDump(t, replayed)
+	if len(want) != len(got) {
+		t.Fatalf("Expected %d keys after replay, got %d", len(want), len(got))
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Fatalf("%s: expected %s, got %s", key, value, got[key])
+		}
+	}
+
+	db0, _ := replayed.GetRedisServer().GetDb(0)
+	ctx := &CommandContext{Server: replayed, Db: db0}
+	if reply := execCommand(ctx, "HPTTL", "hash", "FIELDS", "2", "f1", "f2"); len(reply.Array) != 2 ||
+		reply.Array[0].Int != -1 || reply.Array[1].Int <= 0 {
+		t.Fatalf("Expected hash field TTL to survive the rewrite, got %v", reply)
+	}
+}
+
+// infoField 从 INFO 回复中取出字段的值
+func infoField(t *testing.T, info, field string) string {
+	t.Helper()
+	for _, line := range strings.Split(info, "\n") {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(line), field+":"); ok {
+			return value
+		}
+	}
+	t.Fatalf("INFO has no field %s: %q", field, info)
+	return ""
+}
+
+// TestInfoPersistence 测试 INFO persistence：写命令增加 rdb_changes_since_last_save，SAVE 后清零；
+// 保存失败时 rdb_last_bgsave_status 为 err；aof_enabled 反映是否开启 AOF
+func TestInfoPersistence(t *testing.T) {
+	dir := t.TempDir()
+	srv, addr := startTestServerWith(t, func(s *Server) {
+		s.rdbFilename = filepath.Join(dir, "dump.rdb")
+	})
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	persistence := func() string {
+		return sendCommand(t, conn, reader, "INFO", "persistence").Str
+	}
+
+	info := persistence()
+	for field, want := range map[string]string{
+		"loading":                     "0",
+		"rdb_changes_since_last_save": "0",
+		"rdb_last_bgsave_status":      "ok",
+		"aof_enabled":                 "0",
+		"aof_rewrite_in_progress":     "0",
+		"aof_last_write_status":       "ok",
+	} {
+		if got := infoField(t, info, field); got != want {
+			t.Fatalf("Expected %s:%s, got %s", field, want, got)
+		}
+	}
+
+	sendCommand(t, conn, reader, "SET", "a", "1")
+	sendCommand(t, conn, reader, "SET", "b", "2")
+	sendCommand(t, conn, reader, "GET", "a")
+	if got := infoField(t, persistence(), "rdb_changes_since_last_save"); got != "2" {
+		t.Fatalf("Expected 2 changes since last save, got %s", got)
+	}
+
+	before := time.Now().Unix()
+	if reply := sendCommand(t, conn, reader, "SAVE"); reply.Str != "OK" {
+		t.Fatalf("SAVE failed: %v", reply)
+	}
+	info = persistence()
+	if got := infoField(t, info, "rdb_changes_since_last_save"); got != "0" {
+		t.Fatalf("Expected dirty counter to reset after SAVE, got %s", got)
+	}
+	if saved, _ := strconv.ParseInt(infoField(t, info, "rdb_last_save_time"), 10, 64); saved < before {
+		t.Fatalf("Expected rdb_last_save_time >= %d, got %d", before, saved)
+	}
+
+	// 保存到不存在的目录失败，修改计数保留
+	sendCommand(t, conn, reader, "SET", "c", "3")
+	srv.rdbFilename = filepath.Join(dir, "missing", "dump.rdb")
+	if reply := sendCommand(t, conn, reader, "SAVE"); reply.Type != protocol.RESP_ERROR {
+		t.Fatalf("Expected SAVE to fail, got %v", reply)
+	}
+	info = persistence()
+	if got := infoField(t, info, "rdb_last_bgsave_status"); got != "err" {
+		t.Fatalf("Expected rdb_last_bgsave_status:err, got %s", got)
+	}
+	if got := infoField(t, info, "rdb_changes_since_last_save"); got != "1" {
+		t.Fatalf("Expected failed save to keep the dirty counter, got %s", got)
+	}
+
+	// 开启 AOF 的服务器
+	_, aofAddr := startTestServerWith(t, func(s *Server) {
+		if err := s.InitAOF(true, filepath.Join(dir, "appendonly.aof")); err != nil {
+			t.Fatalf("InitAOF failed: %v", err)
+		}
+		t.Cleanup(func() { s.aofWriter.Close() })
+	})
+	aofConn, err := net.Dial("tcp", aofAddr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer aofConn.Close()
+	aofReader := bufio.NewReader(aofConn)
+	sendCommand(t, aofConn, aofReader, "SET", "a", "1")
+	info = sendCommand(t, aofConn, aofReader, "INFO", "persistence").Str
+	if got := infoField(t, info, "aof_enabled"); got != "1" {
+		t.Fatalf("Expected aof_enabled:1, got %s", got)
+	}
+	if got := infoField(t, info, "aof_last_write_status"); got != "ok" {
+		t.Fatalf("Expected aof_last_write_status:ok, got %s", got)
+	}
+}
+
+// TestInfoReplication 测试 INFO replication：主节点显示已连接的从节点，从节点显示 role:slave 和主节点地址
+func TestInfoReplication(t *testing.T) {
+	master, masterAddr := startTestServer(t)
+	slave, slaveAddr := startTestServer(t)
+
+	masterConn, err := net.Dial("tcp", masterAddr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer masterConn.Close()
+	masterReader := bufio.NewReader(masterConn)
+
+	info := sendCommand(t, masterConn, masterReader, "INFO", "replication").Str
+	if infoField(t, info, "role") != "master" || infoField(t, info, "connected_slaves") != "0" {
+		t.Fatalf("Unexpected replication info before SLAVEOF: %q", info)
+	}
+
+	slaveConn, err := net.Dial("tcp", slaveAddr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer slaveConn.Close()
+	slaveReader := bufio.NewReader(slaveConn)
+
+	host, port, _ := net.SplitHostPort(masterAddr)
+	if reply := sendCommand(t, slaveConn, slaveReader, "SLAVEOF", host, port); reply.Str != "OK" {
+		t.Fatalf("SLAVEOF failed: %v", reply)
+	}
+	t.Cleanup(func() { slave.setSlave(nil) })
+
+	// 从节点异步发送 PSYNC，等待主节点登记
+	deadline := time.Now().Add(5 * time.Second)
+	for master.master.ConnectedReplicas() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Replica was not registered on the master")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	info = sendCommand(t, masterConn, masterReader, "INFO", "replication").Str
+	if got := infoField(t, info, "connected_slaves"); got != "1" {
+		t.Fatalf("Expected connected_slaves:1, got %s", got)
+	}
+	_, slavePort, _ := net.SplitHostPort(slaveAddr)
+	if got := infoField(t, info, "slave0"); !strings.HasPrefix(got, "ip=127.0.0.1,port="+slavePort+",state=") {
+		t.Fatalf("Unexpected slave0 line: %s", got)
+	}
+
+	info = sendCommand(t, slaveConn, slaveReader, "INFO", "replication").Str
+	if got := infoField(t, info, "role"); got != "slave" {
+		t.Fatalf("Expected role:slave, got %s", got)
+	}
+	if infoField(t, info, "master_host") != host || infoField(t, info, "master_port") != port {
+		t.Fatalf("Unexpected master address in %q", info)
+	}
+	if got := infoField(t, info, "master_link_status"); got != "up" && got != "down" {
+		t.Fatalf("Unexpected master_link_status %s", got)
+	}
+
+	// SLAVEOF NO ONE 恢复为主节点
+	sendCommand(t, slaveConn, slaveReader, "SLAVEOF", "NO", "ONE")
+	info = sendCommand(t, slaveConn, slaveReader, "INFO", "replication").Str
+	if got := infoField(t, info, "role"); got != "master" {
+		t.Fatalf("Expected role:master after SLAVEOF NO ONE, got %s", got)
+	}
+}
+
+// TestFullResync 测试全量同步：从节点加载主节点的 RDB 快照（包括多个数据库），
+// 之后在主节点执行的写命令传播到从节点，主从复制偏移量一致
+func TestFullResync(t *testing.T) {
+	master, masterAddr := startTestServer(t)
+	replica, replicaAddr := startTestServer(t)
+
+	masterConn, err := net.Dial("tcp", masterAddr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer masterConn.Close()
+	masterReader := bufio.NewReader(masterConn)
+
+	sendCommand(t, masterConn, masterReader, "SET", "str", "hello")
+	sendCommand(t, masterConn, masterReader, "RPUSH", "list", "a", "b", "c")
+	sendCommand(t, masterConn, masterReader, "HSET", "hash", "f1", "v1", "f2", "v2")
+	sendCommand(t, masterConn, masterReader, "SET", "volatile", "v", "EX", "100")
+	sendCommand(t, masterConn, masterReader, "SELECT", "2")
+	sendCommand(t, masterConn, masterReader, "SADD", "set", "x", "y")
+
+	replicaConn, err := net.Dial("tcp", replicaAddr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer replicaConn.Close()
+	replicaReader := bufio.NewReader(replicaConn)
+
+	// 从节点原有的数据在加载快照时被丢弃
+	sendCommand(t, replicaConn, replicaReader, "SET", "stale", "x")
+
+	host, port, _ := net.SplitHostPort(masterAddr)
+	if reply := sendCommand(t, replicaConn, replicaReader, "SLAVEOF", host, port); reply.Str != "OK" {
+		t.Fatalf("SLAVEOF failed: %v", reply)
+	}
+	t.Cleanup(func() { replica.setSlave(nil) })
+
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatalf("Timed out waiting for %s", what)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	waitFor("the initial sync", func() bool { return replica.getSlave().LinkUp() })
+
+	want, got := keyspaceDump(t, master), keyspaceDump(t, replica)
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d keys after sync, got %v", len(want), got)
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Fatalf("Key %s: expected %s, got %s", key, value, got[key])
+		}
+	}
+
+	// 同步完成后的写命令（在 db 2 和 db 0 中）传播到从节点
+	sendCommand(t, masterConn, masterReader, "SET", "after", "sync")
+	sendCommand(t, masterConn, masterReader, "SELECT", "0")
+	sendCommand(t, masterConn, masterReader, "INCR", "counter")
+	waitFor("the propagated commands", func() bool {
+		return replica.getSlave().Offset() == master.master.ReplOffset()
+	})
+
+	db2, _ := replica.GetRedisServer().GetDb(2)
+	db0, _ := replica.GetRedisServer().GetDb(0)
+	if reply := execCommand(&CommandContext{Server: replica, Db: db2}, "GET", "after"); reply.Str != "sync" {
+		t.Fatalf("Expected the post-sync SET on the replica, got %v", reply)
+	}
+	if reply := execCommand(&CommandContext{Server: replica, Db: db0}, "GET", "counter"); reply.Str != "1" {
+		t.Fatalf("Expected counter=1 on the replica, got %v", reply)
+	}
+
+	info := sendCommand(t, masterConn, masterReader, "INFO", "replication").Str
+	if got := infoField(t, info, "slave0"); !strings.Contains(got, "state=online") {
+		t.Fatalf("Expected the replica to be online, got %s", got)
+	}
+	if len(infoField(t, info, "master_replid")) != 40 {
+		t.Fatalf("Expected a 40 character replication ID in %q", info)
+	}
+}
+
+// TestPartialResync 测试部分同步：从节点短暂断开后重连，主节点回复 +CONTINUE 只发送缺失的命令；
+// 复制 ID 不匹配或偏移量超出积压缓冲区时退回全量同步
+func TestPartialResync(t *testing.T) {
+	master, masterAddr := startTestServer(t)
+	replica, replicaAddr := startTestServer(t)
+
+	masterConn, err := net.Dial("tcp", masterAddr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer masterConn.Close()
+	masterReader := bufio.NewReader(masterConn)
+	sendCommand(t, masterConn, masterReader, "SET", "before", "1")
+
+	replicaConn, err := net.Dial("tcp", replicaAddr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer replicaConn.Close()
+	replicaReader := bufio.NewReader(replicaConn)
+
+	host, port, _ := net.SplitHostPort(masterAddr)
+	sendCommand(t, replicaConn, replicaReader, "SLAVEOF", host, port)
+	t.Cleanup(func() { replica.setSlave(nil) })
+
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatalf("Timed out waiting for %s", what)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	waitFor("the initial sync", func() bool {
+		return replica.getSlave().LinkUp() && replica.getSlave().Offset() == master.master.ReplOffset()
+	})
+
+	// 主节点断开从节点的连接，期间的写命令只进入积压缓冲区
+	for _, client := range master.connectedClients() {
+		if client.replPort != 0 {
+			client.Close()
+		}
+	}
+	sendCommand(t, masterConn, masterReader, "SET", "during", "2")
+	sendCommand(t, masterConn, masterReader, "INCR", "counter")
+
+	waitFor("the replica to catch up", func() bool {
+		return replica.getSlave().LinkUp() && replica.getSlave().Offset() == master.master.ReplOffset()
+	})
+	db0, _ := replica.GetRedisServer().GetDb(0)
+	ctx := &CommandContext{Server: replica, Db: db0}
+	if execCommand(ctx, "GET", "during").Str != "2" || execCommand(ctx, "GET", "counter").Str != "1" ||
+		execCommand(ctx, "GET", "before").Str != "1" {
+		t.Fatal("Expected the replica to have all keys after the partial resync")
+	}
+
+	info := sendCommand(t, masterConn, masterReader, "INFO", "stats").Str
+	if infoField(t, info, "sync_full") != "1" || infoField(t, info, "sync_partial_ok") != "1" {
+		t.Fatalf("Expected one full and one partial sync, got %q", info)
+	}
+
+	// 直接发送 PSYNC 检查边界：当前偏移量之后继续、偏移量超出范围、复制 ID 不匹配
+	replID := master.master.ReplID()
+	psync := func(id string, offset int64) string {
+		t.Helper()
+		conn, err := net.Dial("tcp", masterAddr)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer conn.Close()
+		return sendCommand(t, conn, bufio.NewReader(conn), "PSYNC", id, strconv.FormatInt(offset, 10)).Str
+	}
+	if reply := psync(replID, master.master.ReplOffset()+1); reply != "CONTINUE "+replID {
+		t.Fatalf("Expected CONTINUE at the current offset, got %q", reply)
+	}
+	if reply := psync(replID, master.master.ReplOffset()+100); !strings.HasPrefix(reply, "FULLRESYNC ") {
+		t.Fatalf("Expected FULLRESYNC for an offset past the end, got %q", reply)
+	}
+	if reply := psync(strings.Repeat("0", 40), 1); !strings.HasPrefix(reply, "FULLRESYNC "+replID+" ") {
+		t.Fatalf("Expected FULLRESYNC for an unknown replid, got %q", reply)
+	}
+	info = sendCommand(t, masterConn, masterReader, "INFO", "stats").Str
+	if got := infoField(t, info, "sync_partial_err"); got != "2" {
+		t.Fatalf("Expected sync_partial_err:2, got %s", got)
+	}
+}
+
+// TestReplicaOf 测试 REPLICAOF：主节点上写入的键出现在从节点上，NO ONE 停止复制并提升为主节点
+func TestReplicaOf(t *testing.T) {
+	_, masterAddr := startTestServer(t)
+	replica, replicaAddr := startTestServer(t)
+
+	masterConn, err := net.Dial("tcp", masterAddr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer masterConn.Close()
+	masterReader := bufio.NewReader(masterConn)
+	sendCommand(t, masterConn, masterReader, "SET", "k1", "v1")
+
+	replicaConn, err := net.Dial("tcp", replicaAddr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer replicaConn.Close()
+	replicaReader := bufio.NewReader(replicaConn)
+	replIDBefore := infoField(t, sendCommand(t, replicaConn, replicaReader, "INFO", "replication").Str, "master_replid")
+
+	host, port, _ := net.SplitHostPort(masterAddr)
+	if reply := sendCommand(t, replicaConn, replicaReader, "REPLICAOF", host, port); reply.Str != "OK" {
+		t.Fatalf("REPLICAOF failed: %v", reply)
+	}
+	t.Cleanup(func() { replica.setSlave(nil) })
+	if reply := sendCommand(t, replicaConn, replicaReader, "REPLICAOF", host, port); reply.Str != "OK Already connected to specified master" {
+		t.Fatalf("Unexpected reply for the same master: %v", reply)
+	}
+	if reply := sendCommand(t, replicaConn, replicaReader, "REPLICAOF", host, "abc"); reply.Type != protocol.RESP_ERROR {
+		t.Fatalf("Expected an error for an invalid port, got %v", reply)
+	}
+
+	// 主节点上的写入出现在从节点上
+	sendCommand(t, masterConn, masterReader, "SET", "k2", "v2")
+	deadline := time.Now().Add(5 * time.Second)
+	for sendCommand(t, replicaConn, replicaReader, "GET", "k2").Str != "v2" {
+		if time.Now().After(deadline) {
+			t.Fatal("Key written on the master did not appear on the replica")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := sendCommand(t, replicaConn, replicaReader, "GET", "k1").Str; got != "v1" {
+		t.Fatalf("Expected k1 from the initial sync, got %q", got)
+	}
+	info := sendCommand(t, replicaConn, replicaReader, "INFO", "replication").Str
+	if infoField(t, info, "role") != "slave" || infoField(t, info, "master_link_status") != "up" ||
+		infoField(t, info, "master_sync_in_progress") != "0" {
+		t.Fatalf("Unexpected replication info on the replica: %q", info)
+	}
+
+	// NO ONE：保留数据，成为主节点，不再接收主节点的写入
+	if reply := sendCommand(t, replicaConn, replicaReader, "REPLICAOF", "NO", "ONE"); reply.Str != "OK" {
+		t.Fatalf("REPLICAOF NO ONE failed: %v", reply)
+	}
+	info = sendCommand(t, replicaConn, replicaReader, "INFO", "replication").Str
+	if infoField(t, info, "role") != "master" || infoField(t, info, "master_replid") == replIDBefore {
+		t.Fatalf("Expected a promoted master with a new replication ID: %q", info)
+	}
+	sendCommand(t, masterConn, masterReader, "SET", "k3", "v3")
+	time.Sleep(50 * time.Millisecond)
+	if reply := sendCommand(t, replicaConn, replicaReader, "GET", "k3"); !reply.Null {
+		t.Fatalf("Expected writes after promotion not to be replicated, got %v", reply)
+	}
+	if got := sendCommand(t, replicaConn, replicaReader, "GET", "k2").Str; got != "v2" {
+		t.Fatalf("Expected the promoted master to keep its data, got %q", got)
+	}
+}
+
+// TestFullSyncConcurrentWrites 测试全量同步期间并发执行的 INCR 不会既包含在快照中又在命令流中重放，
+// 同步完成后从节点的计数与主节点一致
+func TestFullSyncConcurrentWrites(t *testing.T) {
+	master, masterAddr := startTestServer(t)
+	replica, replicaAddr := startTestServer(t)
+
+	// 键足够多，使生成快照需要一段时间
+	db, _ := master.GetRedisServer().GetDb(0)
+	for i := 0; i < 50000; i++ {
+		db.Set("filler:"+strconv.Itoa(i), storage.NewStringObject([]byte("x")))
+	}
+
+	masterConn, err := net.Dial("tcp", masterAddr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer masterConn.Close()
+	masterReader := bufio.NewReader(masterConn)
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn, err := net.Dial("tcp", masterAddr)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			conn.Write(protocol.NewArray([]*protocol.RESPValue{
+				protocol.NewBulkString("INCR"), protocol.NewBulkString("counter"),
+			}).Encode())
+			if _, err := protocol.DecodeRequest(reader); err != nil {
+				return
+			}
+		}
+	}()
+
+	replicaConn, err := net.Dial("tcp", replicaAddr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer replicaConn.Close()
+	replicaReader := bufio.NewReader(replicaConn)
+
+	host, port, _ := net.SplitHostPort(masterAddr)
+	if reply := sendCommand(t, replicaConn, replicaReader, "REPLICAOF", host, port); reply.Str != "OK" {
+		t.Fatalf("REPLICAOF failed: %v", reply)
+	}
+	t.Cleanup(func() { replica.setSlave(nil) })
+
+	// 同步完成后再写一段时间，然后停止写入
+	deadline := time.Now().Add(10 * time.Second)
+	for infoField(t, sendCommand(t, replicaConn, replicaReader, "INFO", "replication").Str, "master_link_status") != "up" {
+		if time.Now().After(deadline) {
+			t.Fatal("Replica did not finish the full sync")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(stop)
+	<-done
+
+	want := sendCommand(t, masterConn, masterReader, "GET", "counter").Str
+	for {
+		got := sendCommand(t, replicaConn, replicaReader, "GET", "counter").Str
+		if got == want {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Replica counter %s diverged from master counter %s", got, want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestReadOnlyReplica 测试只读从节点：普通客户端的写命令返回 READONLY，
+// 主节点通过复制流传播的同一命令正常执行；replica-read-only no 时允许写入
+func TestReadOnlyReplica(t *testing.T) {
+	_, masterAddr := startTestServer(t)
+	replica, replicaAddr := startTestServer(t)
+
+	masterConn, err := net.Dial("tcp", masterAddr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer masterConn.Close()
+	masterReader := bufio.NewReader(masterConn)
+
+	replicaConn, err := net.Dial("tcp", replicaAddr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer replicaConn.Close()
+	replicaReader := bufio.NewReader(replicaConn)
+
+	host, port, _ := net.SplitHostPort(masterAddr)
+	sendCommand(t, replicaConn, replicaReader, "REPLICAOF", host, port)
+	t.Cleanup(func() { replica.setSlave(nil) })
+
+	reply := sendCommand(t, replicaConn, replicaReader, "SET", "k", "replica")
+	if reply.Type != protocol.RESP_ERROR || reply.Str != "READONLY You can't write against a read only replica." {
+		t.Fatalf("Expected READONLY error, got %v", reply)
+	}
+	if reply := sendCommand(t, replicaConn, replicaReader, "LPUSH", "list", "a"); reply.Type != protocol.RESP_ERROR {
+		t.Fatalf("Expected LPUSH to be rejected, got %v", reply)
+	}
+
+	// 事务中的写命令入队时被拒绝，EXEC 丢弃整个事务
+	sendCommand(t, replicaConn, replicaReader, "MULTI")
+	if reply := sendCommand(t, replicaConn, replicaReader, "SET", "k", "multi"); reply.Type != protocol.RESP_ERROR || !strings.HasPrefix(reply.Str, "READONLY ") {
+		t.Fatalf("Expected queued SET to be rejected, got %v", reply)
+	}
+	if reply := sendCommand(t, replicaConn, replicaReader, "GET", "k"); reply.Str != "QUEUED" {
+		t.Fatalf("Expected GET to be queued, got %v", reply)
+	}
+	if reply := sendCommand(t, replicaConn, replicaReader, "EXEC"); reply.Type != protocol.RESP_ERROR || !strings.HasPrefix(reply.Str, "EXECABORT ") {
+		t.Fatalf("Expected EXECABORT, got %v", reply)
+	}
+	if reply := sendCommand(t, replicaConn, replicaReader, "GET", "k"); !reply.Null {
+		t.Fatalf("Expected the transaction not to write, got %v", reply)
+	}
+
+	// 同一命令通过复制流到达时执行成功
+	sendCommand(t, masterConn, masterReader, "SET", "k", "master")
+	deadline := time.Now().Add(5 * time.Second)
+	for sendCommand(t, replicaConn, replicaReader, "GET", "k").Str != "master" {
+		if time.Now().After(deadline) {
+			t.Fatal("Replicated SET was not applied on the replica")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	sendCommand(t, replicaConn, replicaReader, "CONFIG", "SET", "replica-read-only", "no")
+	if reply := sendCommand(t, replicaConn, replicaReader, "SET", "local", "v"); reply.Str != "OK" {
+		t.Fatalf("Expected writes with replica-read-only no, got %v", reply)
+	}
+}
+
+// TestClusterRedirect 测试集群模式下的 MOVED / ASK / CROSSSLOT 重定向
+func TestClusterRedirect(t *testing.T) {
+	srv, addr := startTestServerWith(t, func(s *Server) {
+		s.InitCluster(true, "node1", "")
+	})
+	c := srv.GetCluster()
+	c.AddNode("node2", "127.0.0.1:7001")
+	fooSlot := cluster.HashSlot("foo") // 12182
+	mine := make([]int, 0, cluster.CLUSTER_SLOTS)
+	for slot := 0; slot < cluster.CLUSTER_SLOTS; slot++ {
+		if slot != fooSlot {
+			mine = append(mine, slot)
+		}
+	}
+	c.AssignSlots("node1", mine)
+	c.AssignSlots("node2", []int{fooSlot})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	if reply := sendCommand(t, conn, reader, "SET", "bar", "v"); reply.Str != "OK" {
+		t.Fatalf("Expected local SET to succeed, got %v", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "GET", "foo"); reply.Type != protocol.RESP_ERROR || reply.Str != "MOVED 12182 127.0.0.1:7001" {
+		t.Fatalf("Expected MOVED redirect, got %v", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "MGET", "bar", "hello"); reply.Type != protocol.RESP_ERROR || !strings.HasPrefix(reply.Str, "CROSSSLOT") {
+		t.Fatalf("Expected CROSSSLOT error, got %v", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "MSET", "{t}a", "1", "{t}b", "2"); reply.Str != "OK" {
+		t.Fatalf("Expected keys with the same hash tag to succeed, got %v", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "CLUSTER", "KEYSLOT", "foo"); reply.Int != int64(fooSlot) {
+		t.Fatalf("Expected CLUSTER commands not to be redirected, got %v", reply)
+	}
+
+	// 事务中的命令入队前同样重定向，EXEC 丢弃整个事务
+	sendCommand(t, conn, reader, "MULTI")
+	sendCommand(t, conn, reader, "SET", "bar", "multi")
+	if reply := sendCommand(t, conn, reader, "SET", "foo", "x"); reply.Type != protocol.RESP_ERROR || reply.Str != "MOVED 12182 127.0.0.1:7001" {
+		t.Fatalf("Expected queued SET to be redirected, got %v", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "EXEC"); reply.Type != protocol.RESP_ERROR || !strings.HasPrefix(reply.Str, "EXECABORT ") {
+		t.Fatalf("Expected EXECABORT, got %v", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "GET", "bar"); reply.Str != "v" {
+		t.Fatalf("Expected the transaction not to write, got %v", reply)
+	}
+	if db, _ := srv.GetRedisServer().GetDb(0); db.Exists("foo") {
+		t.Fatal("Expected the redirected SET not to run locally")
+	}
+
+	// 槽正在迁往 node2：仍在本节点的键正常执行，已迁走的键返回 ASK
+	barSlot := cluster.HashSlot("bar")
+	if err := c.GetReshardingManager().StartMigration(barSlot, "node1", "node2"); err != nil {
+		t.Fatalf("StartMigration failed: %v", err)
+	}
+	if reply := sendCommand(t, conn, reader, "GET", "bar"); reply.Str != "v" {
+		t.Fatalf("Expected existing key to be served locally, got %v", reply)
+	}
+	sendCommand(t, conn, reader, "DEL", "bar")
+	if reply := sendCommand(t, conn, reader, "GET", "bar"); reply.Type != protocol.RESP_ERROR || reply.Str != fmt.Sprintf("ASK %d 127.0.0.1:7001", barSlot) {
+		t.Fatalf("Expected ASK redirect, got %v", reply)
+	}
+}
+
+// TestClusterSetSlot 测试 CLUSTER SETSLOT：MIGRATING 时不存在的键返回 ASK，
+// IMPORTING 时只接受 ASKING 之后的一条命令，NODE 完成槽的归属变更
+func TestClusterSetSlot(t *testing.T) {
+	srv, addr := startTestServerWith(t, func(s *Server) {
+		s.InitCluster(true, "node1", "")
+	})
+	c := srv.GetCluster()
+	c.AddNode("node2", "127.0.0.1:7001")
+	all := make([]int, cluster.CLUSTER_SLOTS)
+	for slot := range all {
+		all[slot] = slot
+	}
+	c.AssignSlots("node1", all)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	fooSlot := strconv.Itoa(cluster.HashSlot("foo"))
+	sendCommand(t, conn, reader, "SET", "foo", "v")
+
+	if reply := sendCommand(t, conn, reader, "CLUSTER", "SETSLOT", fooSlot, "MIGRATING", "node3"); reply.Type != protocol.RESP_ERROR {
+		t.Fatalf("Expected error for unknown node, got %v", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "CLUSTER", "SETSLOT", fooSlot, "MIGRATING", "node2"); reply.Str != "OK" {
+		t.Fatalf("SETSLOT MIGRATING failed: %v", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "GET", "foo"); reply.Str != "v" {
+		t.Fatalf("Expected key not yet migrated to be served locally, got %v", reply)
+	}
+	// {foo} 与 foo 在同一个槽，但不在本节点
+	if reply := sendCommand(t, conn, reader, "GET", "{foo}x"); reply.Str != "ASK "+fooSlot+" 127.0.0.1:7001" {
+		t.Fatalf("Expected ASK redirect, got %v", reply)
+	}
+
+	if reply := sendCommand(t, conn, reader, "CLUSTER", "SETSLOT", fooSlot, "NODE", "node2"); reply.Str != "OK" {
+		t.Fatalf("SETSLOT NODE failed: %v", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "GET", "foo"); reply.Str != "MOVED "+fooSlot+" 127.0.0.1:7001" {
+		t.Fatalf("Expected MOVED after ownership change, got %v", reply)
+	}
+	if node := c.GetSlotNode(cluster.HashSlot("foo")); node.NodeID != "node2" {
+		t.Fatalf("Expected slot to belong to node2, got %s", node.NodeID)
+	}
+	if mine, _ := c.GetNode("node1"); len(mine.Slots) != cluster.CLUSTER_SLOTS-1 {
+		t.Fatalf("Expected node1 to lose the slot, has %d slots", len(mine.Slots))
+	}
+
+	// 把槽导入回本节点：只有 ASKING 之后的一条命令在本节点执行
+	if reply := sendCommand(t, conn, reader, "CLUSTER", "SETSLOT", "0", "IMPORTING", "node2"); reply.Type != protocol.RESP_ERROR {
+		t.Fatalf("Expected error importing a slot we already own, got %v", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "CLUSTER", "SETSLOT", fooSlot, "IMPORTING", "node2"); reply.Str != "OK" {
+		t.Fatalf("SETSLOT IMPORTING failed: %v", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "GET", "foo"); !strings.HasPrefix(reply.Str, "MOVED") {
+		t.Fatalf("Expected MOVED without ASKING, got %v", reply)
+	}
+	sendCommand(t, conn, reader, "ASKING")
+	if reply := sendCommand(t, conn, reader, "GET", "foo"); reply.Str != "v" {
+		t.Fatalf("Expected ASKING to allow the importing slot, got %v", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "GET", "foo"); !strings.HasPrefix(reply.Str, "MOVED") {
+		t.Fatalf("Expected ASKING to apply to a single command, got %v", reply)
+	}
+
+	sendCommand(t, conn, reader, "CLUSTER", "SETSLOT", fooSlot, "STABLE")
+	sendCommand(t, conn, reader, "ASKING")
+	if reply := sendCommand(t, conn, reader, "GET", "foo"); !strings.HasPrefix(reply.Str, "MOVED") {
+		t.Fatalf("Expected STABLE to clear the importing state, got %v", reply)
+	}
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+ * ============================================================================
+ * 慢查询日志（SLOWLOG）
+ * ============================================================================
+ *
+ * 执行时间超过 slowlog-log-slower-than（微秒）的命令会被记录下来：
+ * - 阈值为负数时关闭慢查询日志，为 0 时记录所有命令
+ * - 日志保存在固定容量的环形缓冲区中，容量由 slowlog-max-len 决定，
+ *   写满后覆盖最旧的记录
+ * - 与 Redis 一样，每条记录最多保存 SLOWLOG_ENTRY_MAX_ARGC 个参数，
+ *   每个参数最多保存 SLOWLOG_ENTRY_MAX_STRING 个字节
+ */
+
+const (
+	SLOWLOG_ENTRY_MAX_ARGC   = 32
+	SLOWLOG_ENTRY_MAX_STRING = 128
+)
+
+// SlowLogEntry 慢查询日志条目
+type SlowLogEntry struct {
+	ID         int64
+	Timestamp  time.Time
+	Duration   time.Duration
+	Args       []string // 命令及参数（已截断）
+	ClientAddr string
+	ClientName string
+}
+
+// SlowLog 慢查询日志（环形缓冲区）
+type SlowLog struct {
+	entries []*SlowLogEntry // 环形缓冲区
+	next    int             // 下一条记录写入的位置
+	size    int             // 当前记录数
+	nextID  int64           // 下一条记录的 ID（RESET 后不清零）
+	mu      sync.Mutex
+}
+
+// NewSlowLog 创建慢查询日志
+func NewSlowLog() *SlowLog {
+	return &SlowLog{}
+}
+
+// Add 记录一条慢查询，maxLen 为当前的 slowlog-max-len（容量变化时保留最新的记录）
+func (sl *SlowLog) Add(args []string, duration time.Duration, clientAddr, clientName string, maxLen int) {
+	if maxLen <= 0 {
+		return
+	}
+
+	argc := min(len(args), SLOWLOG_ENTRY_MAX_ARGC)
+	entryArgs := make([]string, argc)
+	for i := 0; i < argc; i++ {
+		switch {
+		case argc != len(args) && i == argc-1:
+			entryArgs[i] = "... (" + strconv.Itoa(len(args)-argc+1) + " more arguments)"
+		case len(args[i]) > SLOWLOG_ENTRY_MAX_STRING:
+			entryArgs[i] = args[i][:SLOWLOG_ENTRY_MAX_STRING] + "... (" + strconv.Itoa(len(args[i])-SLOWLOG_ENTRY_MAX_STRING) + " more bytes)"
+		default:
+			entryArgs[i] = args[i]
+		}
+	}
+
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	if len(sl.entries) != maxLen {
+		sl.resize(maxLen)
+	}
+
+	sl.entries[sl.next] = &SlowLogEntry{
+		ID:         sl.nextID,
+		Timestamp:  time.Now(),
+		Duration:   duration,
+		Args:       entryArgs,
+		ClientAddr: clientAddr,
+		ClientName: clientName,
+	}
+	sl.nextID++
+	sl.next = (sl.next + 1) % maxLen
+	sl.size = min(sl.size+1, maxLen)
+}
+
+// resize 调整缓冲区容量，保留最新的记录（调用方持有锁）
+func (sl *SlowLog) resize(maxLen int) {
+	latest := sl.latest(maxLen)
+	sl.entries = make([]*SlowLogEntry, maxLen)
+	// latest 按从新到旧排列，倒序写回
+	for i := range latest {
+		sl.entries[i] = latest[len(latest)-1-i]
+	}
+	sl.size = len(latest)
+	sl.next = sl.size % maxLen
+}
+
+// latest 按从新到旧返回最多 count 条记录（调用方持有锁）
+func (sl *SlowLog) latest(count int) []*SlowLogEntry {
+	if count < 0 || count > sl.size {
+		count = sl.size
+	}
+	result := make([]*SlowLogEntry, 0, count)
+	for i := 1; i <= count; i++ {
+		idx := (sl.next - i + len(sl.entries)) % len(sl.entries)
+		result = append(result, sl.entries[idx])
+	}
+	return result
+}
+
+// Get 按从新到旧返回最多 count 条记录（count < 0 表示全部）
+func (sl *SlowLog) Get(count int) []*SlowLogEntry {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	return sl.latest(count)
+}
+
+// Len 当前记录数
+func (sl *SlowLog) Len() int {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	return sl.size
+}
+
+// Reset 清空所有记录
+func (sl *SlowLog) Reset() {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	for i := range sl.entries {
+		sl.entries[i] = nil
+	}
+	sl.next = 0
+	sl.size = 0
+}
@@ -24,20 +24,11 @@ type Stats struct {
 	TotalConnectionsReceived int64
 	KeyspaceHits             int64
 	KeyspaceMisses           int64
-	SlowLog                  []*SlowLogEntry
+	EvictedKeys              int64
 	CommandStats             map[string]*CommandStat
 	mu                       sync.RWMutex
 }
 
-// SlowLogEntry 慢查询日志条目
-type SlowLogEntry struct {
-	ID        int64
-	Timestamp time.Time
-	Duration  time.Duration
-	Command   string
-	Args      []string
-}
-
 // CommandStat 命令统计
 type CommandStat struct {
 	Calls     int64
@@ -48,7 +39,6 @@ type CommandStat struct {
 // NewStats 创建统计信息
 func NewStats() *Stats {
 	return &Stats{
-		SlowLog:      make([]*SlowLogEntry, 0),
 		CommandStats: make(map[string]*CommandStat),
 	}
 }
@@ -71,22 +61,6 @@ func (s *Stats) RecordCommand(cmdName string, duration time.Duration) {
 	if duration > stat.MaxTime {
 		stat.MaxTime = duration
 	}
-
-	// 记录慢查询（超过 10ms）
-	if duration > 10*time.Millisecond {
-		entry := &SlowLogEntry{
-			ID:        s.TotalCommandsProcessed,
-			Timestamp: time.Now(),
-			Duration:  duration,
-			Command:   cmdName,
-		}
-		s.SlowLog = append(s.SlowLog, entry)
-
-		// 只保留最近 128 条
-		if len(s.SlowLog) > 128 {
-			s.SlowLog = s.SlowLog[1:]
-		}
-	}
 }
 
 // RecordConnection 记录连接
@@ -110,21 +84,32 @@ func (s *Stats) RecordKeyspaceMiss() {
 	s.KeyspaceMisses++
 }
 
-// GetSlowLog 获取慢查询日志
-func (s *Stats) GetSlowLog(count int) []*SlowLogEntry {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+// RecordEvictedKey 记录一次 maxmemory 淘汰
+func (s *Stats) RecordEvictedKey() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.EvictedKeys++
+}
 
-	if count <= 0 || count > len(s.SlowLog) {
-		count = len(s.SlowLog)
+// RecordKeyspaceLookup 记录一次键查找（命中或未命中）
+func (s *Stats) RecordKeyspaceLookup(hit bool) {
+	if hit {
+		s.RecordKeyspaceHit()
+	} else {
+		s.RecordKeyspaceMiss()
 	}
+}
 
-	start := len(s.SlowLog) - count
-	if start < 0 {
-		start = 0
-	}
+// Reset 清零统计计数器（CONFIG RESETSTAT）：命令计数、连接计数、
+// 键空间命中/未命中以及每个命令的统计。慢查询日志由 SLOWLOG RESET 单独清空
+func (s *Stats) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	result := make([]*SlowLogEntry, count)
-	copy(result, s.SlowLog[start:])
-	return result
+	s.TotalCommandsProcessed = 0
+	s.TotalConnectionsReceived = 0
+	s.KeyspaceHits = 0
+	s.KeyspaceMisses = 0
+	s.EvictedKeys = 0
+	s.CommandStats = make(map[string]*CommandStat)
 }
@@ -4,6 +4,7 @@ import (
 	"sync"
 
 	"github.com/code-100-precent/LingCache/protocol"
+	"github.com/code-100-precent/LingCache/storage"
 )
 
 /*
@@ -22,15 +23,42 @@ import (
  * 2. 命令入队 - 所有命令进入队列，不执行
  * 3. EXEC - 执行队列中的所有命令
  * 4. DISCARD - 清空队列，退出事务模式
+ *
+ * 【命令入队】
+ * MULTI 之后的命令（multiImmediateCommands 除外）入队前做与立即执行时相同的检查：
+ * 命令名、参数个数、只读从节点拒绝写命令、超过 maxmemory 时拒绝可能增加内存的写命令，
+ * 通过则入队并回复 QUEUED；检查失败时回复错误并标记事务，EXEC 时整个事务被丢弃（EXECABORT）
+ *
+ * 【WATCH】
+ * WATCH 记录键在数据库中的版本号（storage.RedisDb.WatchKey），
+ * 之后任何客户端修改了这些键（包括自己在 MULTI 之前修改），EXEC 都会放弃执行并返回 nil 数组。
+ * 监视状态属于客户端而不是事务：EXEC、DISCARD、UNWATCH 以及断开连接时清除
  */
 
 // Transaction 事务
 type Transaction struct {
 	commands []*QueuedCommand
-	watched  map[string]bool // 监视的键
+	dirty    bool // 入队时出现错误，EXEC 时丢弃整个事务
 	mu       sync.Mutex
 }
 
+// multiImmediateCommands 事务模式下立即执行而不入队的命令
+var multiImmediateCommands = map[string]bool{
+	"EXEC":    true,
+	"DISCARD": true,
+	"MULTI":   true,
+	"WATCH":   true,
+	"QUIT":    true,
+	"RESET":   true,
+}
+
+// watchedKeyRef 客户端监视的键及 WATCH 时的版本号
+type watchedKeyRef struct {
+	db      *storage.RedisDb
+	key     string
+	version uint64
+}
+
 // QueuedCommand 队列中的命令
 type QueuedCommand struct {
 	cmd  *protocol.RESPValue
@@ -41,7 +69,6 @@ type QueuedCommand struct {
 func NewTransaction() *Transaction {
 	return &Transaction{
 		commands: make([]*QueuedCommand, 0),
-		watched:  make(map[string]bool),
 	}
 }
 
@@ -56,6 +83,36 @@ func (tx *Transaction) AddCommand(cmd *protocol.RESPValue, proc CommandProc) {
 	})
 }
 
+// queueCommand 事务模式下将命令入队，返回 QUEUED 或错误（出错时标记事务）
+func (s *Server) queueCommand(client *Client, req *protocol.RESPValue) *protocol.RESPValue {
+	if client.transaction == nil {
+		client.transaction = NewTransaction()
+	}
+
+	argv := req.GetArray()
+	cmdName := toUpper(argv[0].ToString())
+	cmd, err := s.cmdTable.Lookup(cmdName)
+	if err != nil {
+		client.transaction.dirty = true
+		return protocol.NewError("ERR unknown command '" + cmdName + "'")
+	}
+	if !cmd.arityOK(len(argv)) {
+		client.transaction.dirty = true
+		return protocol.NewError("ERR wrong number of arguments for '" + cmdName + "' command")
+	}
+	if s.denyReadOnly(cmdName) {
+		client.transaction.dirty = true
+		return protocol.NewError("READONLY You can't write against a read only replica.")
+	}
+	if s.denyOOM(cmdName) && !s.performEvictions() {
+		client.transaction.dirty = true
+		return protocol.NewError("OOM command not allowed when used memory > 'maxmemory'.")
+	}
+
+	client.transaction.AddCommand(req, cmd.Proc)
+	return protocol.NewSimpleString("QUEUED")
+}
+
 // Execute 执行事务
 func (tx *Transaction) Execute(ctx *CommandContext) []*protocol.RESPValue {
 	tx.mu.Lock()
@@ -63,12 +120,18 @@ func (tx *Transaction) Execute(ctx *CommandContext) []*protocol.RESPValue {
 
 	results := make([]*protocol.RESPValue, 0, len(tx.commands))
 
-	for _, queuedCmd := range tx.commands {
-		// 检查 WATCH 的键是否被修改（简化实现：总是执行）
-		// 实际应该检查 watched 键是否被修改
+	// 事务中的阻塞命令不阻塞：有数据就立即处理，否则按超时返回
+	ctx.inExec = true
+	defer func() { ctx.inExec = false }()
 
-		// 执行命令
+	for _, queuedCmd := range tx.commands {
+		// 执行命令（入队时没有推送给 MONITOR，在真正执行时推送）
+		ctx.Server.monitors.Feed(ctx.Client, queuedCmd.cmd)
+		dirty := ctx.dirty
+		ctx.propagated = nil
+		ctx.served = nil
 		result := queuedCmd.proc(ctx, queuedCmd.cmd.GetArray()[1:])
+		ctx.Server.propagate(ctx, queuedCmd.cmd, result, ctx.dirty-dirty)
 		results = append(results, result)
 	}
 
@@ -81,22 +144,59 @@ func (tx *Transaction) Discard() {
 	defer tx.mu.Unlock()
 
 	tx.commands = make([]*QueuedCommand, 0)
-	tx.watched = make(map[string]bool)
 }
 
-// Watch 监视键
-func (tx *Transaction) Watch(key string) {
-	tx.mu.Lock()
-	defer tx.mu.Unlock()
+// watch 监视 db 中的键（重复监视同一个键时保留第一次的版本号）
+func (c *Client) watch(db *storage.RedisDb, key string) {
+	for _, wk := range c.watchedKeys {
+		if wk.db == db && wk.key == key {
+			return
+		}
+	}
+	c.watchedKeys = append(c.watchedKeys, &watchedKeyRef{
+		db:      db,
+		key:     key,
+		version: db.WatchKey(key),
+	})
+}
 
-	tx.watched[key] = true
+// unwatchAll 取消监视所有键
+func (c *Client) unwatchAll() {
+	for _, wk := range c.watchedKeys {
+		wk.db.UnwatchKey(wk.key)
+	}
+	c.watchedKeys = nil
 }
 
-// IsWatched 检查键是否被监视
-func (tx *Transaction) IsWatched(key string) bool {
-	tx.mu.Lock()
-	defer tx.mu.Unlock()
+// watchedKeysModified 监视的键在 WATCH 之后是否被修改过
+func (c *Client) watchedKeysModified() bool {
+	for _, wk := range c.watchedKeys {
+		if wk.db.KeyVersion(wk.key) != wk.version {
+			return true
+		}
+	}
+	return false
+}
 
-	_, exists := tx.watched[key]
-	return exists
+// signalModifiedKeys 写命令执行成功后标记它修改的键（使监视这些键的事务失效）。
+// 数据库层面的 Set/Del 等已经会标记，这里覆盖命令原地修改对象的情况
+func (s *Server) signalModifiedKeys(db *storage.RedisDb, req *protocol.RESPValue, resp *protocol.RESPValue) {
+	if resp == nil || resp.Type == protocol.RESP_ERROR {
+		return
+	}
+	argv := req.GetArray()
+	if len(argv) == 0 {
+		return
+	}
+	name := toUpper(argv[0].ToString())
+	if !s.isWriteCommand(name) {
+		return
+	}
+	cmd, err := s.cmdTable.Lookup(name)
+	if err != nil {
+		return
+	}
+	for _, key := range cmd.keys(argv) {
+		db.SignalModifiedKey(key)
+	}
 }
@@ -1,8 +1,12 @@
 package storage
 
 import (
+	"math/rand"
 	"sync"
 	"time"
+
+	"github.com/code-100-precent/LingCache/structure"
+	"github.com/code-100-precent/LingCache/utils"
 )
 
 /*
@@ -23,6 +27,9 @@ import (
  * 【过期机制】
  * 使用单独的哈希表存储 key -> expire time 的映射。
  * expire time 是 Unix 时间戳（秒）。
+ *
+ * 设置了字段过期时间的哈希表另外记录在 hexpires 中：所有字段都过期后，
+ * 查找键时惰性删除整个键，主动过期也会抽样这些哈希表删除过期字段，字段全部过期时删除键。
  */
 
 // 错误定义在 errors.go 中
@@ -32,7 +39,12 @@ type RedisDb struct {
 	id      int                     // 数据库 ID
 	keys    map[string]*RedisObject // 键值对存储
 	expires map[string]int64        // 过期时间存储（key -> Unix 时间戳，秒）
+	watched map[string]*watchedKey  // 被 WATCH 的键的版本号
+	scan    *structure.ScanIndex    // SCAN 使用的桶索引（见 structure/scan.go）
 	mu      sync.RWMutex            // 读写锁（保证并发安全）
+
+	hexpires map[string]struct{} // 设置了字段过期时间的哈希表（字段全部过期时删除键）
+	onLookup func(hit bool)      // 键查找回调（统计命中率，可为 nil）
 }
 
 // NewRedisDb 创建新的 Redis 数据库
@@ -41,6 +53,10 @@ func NewRedisDb(id int) *RedisDb {
 		id:      id,
 		keys:    make(map[string]*RedisObject),
 		expires: make(map[string]int64),
+		watched: make(map[string]*watchedKey),
+		scan:    structure.NewScanIndex(),
+
+		hexpires: make(map[string]struct{}),
 	}
 }
 
@@ -50,17 +66,58 @@ func (db *RedisDb) Set(key string, obj *RedisObject) {
 	defer db.mu.Unlock()
 
 	// 如果 key 已存在，减少旧对象的引用计数
-	if oldObj, exists := db.keys[key]; exists {
+	if oldObj, exists := db.keys[key]; !exists {
+		db.scan.Add(key)
+	} else if oldObj != obj {
 		oldObj.DecrRefCount()
 	}
 
-	// 设置新对象
-	obj.IncrRefCount()
+	// 设置新对象：调用方持有的引用转交给键空间（与 Redis 的 dbAdd 一致），
+	// 共享对象由调用方在获取时增加引用计数
+	obj.Touch()
 	db.keys[key] = obj
+	db.trackFieldExpires(key, obj)
+	db.touchWatchedKey(key)
+}
+
+// TrackFieldExpires 哈希表的字段设置了过期时间后调用，使字段全部过期时键被删除
+func (db *RedisDb) TrackFieldExpires(key string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if obj, ok := db.keys[key]; ok {
+		db.trackFieldExpires(key, obj)
+	}
 }
 
-// Get 获取键值对
+// trackFieldExpires 按对象是否为设置了字段过期时间的哈希表更新 hexpires（必须在写锁内调用）
+func (db *RedisDb) trackFieldExpires(key string, obj *RedisObject) {
+	if hash, err := obj.GetHash(); err == nil && hash.HasFieldExpires() {
+		db.hexpires[key] = struct{}{}
+	} else {
+		delete(db.hexpires, key)
+	}
+}
+
+// Get 获取键值对（更新对象的访问时间，并记录键空间命中/未命中）
 func (db *RedisDb) Get(key string) (*RedisObject, error) {
+	obj, err := db.Peek(key)
+	if err != nil {
+		if db.onLookup != nil {
+			db.onLookup(false)
+		}
+		return nil, err
+	}
+
+	obj.Touch()
+	if db.onLookup != nil {
+		db.onLookup(true)
+	}
+	return obj, nil
+}
+
+// Peek 获取键值对，但不更新访问时间、不计入命中统计（OBJECT、DEBUG 等内省命令使用）
+func (db *RedisDb) Peek(key string) (*RedisObject, error) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
@@ -77,6 +134,12 @@ func (db *RedisDb) Get(key string) (*RedisObject, error) {
 	return obj, nil
 }
 
+// SetLookupObserver 设置键查找回调（hit 表示是否命中），用于统计 keyspace_hits/misses
+// 必须在数据库投入使用前设置
+func (db *RedisDb) SetLookupObserver(fn func(hit bool)) {
+	db.onLookup = fn
+}
+
 // Del 删除键值对
 func (db *RedisDb) Del(key string) bool {
 	db.mu.Lock()
@@ -92,11 +155,36 @@ func (db *RedisDb) Del(key string) bool {
 
 	// 删除键值对
 	delete(db.keys, key)
+	db.scan.Remove(key)
 	delete(db.expires, key)
+	delete(db.hexpires, key)
+	db.touchWatchedKey(key)
 
 	return true
 }
 
+// Unlink 从键空间摘除键并返回对象，由调用方负责释放（UNLINK 交给后台释放）
+func (db *RedisDb) Unlink(key string) (*RedisObject, bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.isExpired(key) {
+		return nil, false
+	}
+
+	obj, exists := db.keys[key]
+	if !exists {
+		return nil, false
+	}
+
+	delete(db.keys, key)
+	db.scan.Remove(key)
+	delete(db.expires, key)
+	delete(db.hexpires, key)
+	db.touchWatchedKey(key)
+	return obj, true
+}
+
 // Exists 检查键是否存在
 func (db *RedisDb) Exists(key string) bool {
 	db.mu.RLock()
@@ -110,10 +198,11 @@ func (db *RedisDb) Exists(key string) bool {
 	return exists
 }
 
-// Type 获取键的类型
+// Type 获取键的类型（已过期的键会被惰性删除，返回 ErrKeyNotFound）
 func (db *RedisDb) Type(key string) (string, error) {
-	db.mu.RLock()
-	defer db.mu.RUnlock()
+	// isExpired 可能删除键，需要写锁
+	db.mu.Lock()
+	defer db.mu.Unlock()
 
 	if db.isExpired(key) {
 		return "", ErrKeyNotFound
@@ -172,6 +261,7 @@ func (db *RedisDb) Expire(key string, seconds int64) bool {
 
 	expire := time.Now().Unix() + seconds
 	db.expires[key] = expire
+	db.touchWatchedKey(key)
 
 	return true
 }
@@ -192,58 +282,78 @@ func (db *RedisDb) ExpireAt(key string, timestamp int64) bool {
 	}
 
 	db.expires[key] = timestamp
+	db.touchWatchedKey(key)
 	return true
 }
 
+// GetExpireAt 获取键的过期时间（Unix 秒），键不存在或没有过期时间时返回 false
+func (db *RedisDb) GetExpireAt(key string) (int64, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	expireAt, exists := db.expires[key]
+	if !exists || time.Now().Unix() >= expireAt {
+		return 0, false
+	}
+	return expireAt, true
+}
+
 // Persist 移除键的过期时间
 func (db *RedisDb) Persist(key string) bool {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	if _, exists := db.expires[key]; !exists {
+	// 键不存在、没有过期时间或已经过期，都不算移除了过期时间
+	if _, exists := db.expires[key]; !exists || db.isExpired(key) {
 		return false
 	}
 
 	delete(db.expires, key)
+	db.touchWatchedKey(key)
 	return true
 }
 
-// isExpired 检查键是否过期（必须在锁内调用）
+// isExpired 检查键是否过期（必须在锁内调用）。
+// 设置了字段过期时间的哈希表所有字段都已过期时，同样视为过期并删除键
 func (db *RedisDb) isExpired(key string) bool {
 	expire, exists := db.expires[key]
-	if !exists {
-		return false
-	}
-
-	now := time.Now().Unix()
-	if now >= expire {
+	if (exists && time.Now().Unix() >= expire) || db.fieldsExpired(key) {
 		// 已过期，删除键
 		if obj, ok := db.keys[key]; ok {
 			obj.DecrRefCount()
 		}
 		delete(db.keys, key)
+		db.scan.Remove(key)
 		delete(db.expires, key)
+		delete(db.hexpires, key)
+		db.touchWatchedKey(key)
 		return true
 	}
 
 	return false
 }
 
-// Keys 获取所有键（支持模式匹配，简化实现：返回所有键）
+// fieldsExpired 键是否为所有字段都已过期的哈希表（必须在锁内调用）
+func (db *RedisDb) fieldsExpired(key string) bool {
+	if _, ok := db.hexpires[key]; !ok {
+		return false
+	}
+	hash, err := db.keys[key].GetHash()
+	return err == nil && hash.AllFieldsExpired(time.Now().UnixMilli())
+}
+
+// Keys 获取匹配 glob 模式的所有键（pattern 为空或 "*" 时返回所有键）
 func (db *RedisDb) Keys(pattern string) []string {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
+	matchAll := pattern == "" || pattern == "*"
 	keys := make([]string, 0, len(db.keys))
 	for key := range db.keys {
 		if db.isExpired(key) {
 			continue
 		}
-		// 简化实现：如果 pattern 为空或 "*"，返回所有键
-		if pattern == "" || pattern == "*" {
-			keys = append(keys, key)
-		} else {
-			// 实际应该实现模式匹配（如 glob pattern）
+		if matchAll || utils.GlobMatch(pattern, key) {
 			keys = append(keys, key)
 		}
 	}
@@ -251,6 +361,85 @@ func (db *RedisDb) Keys(pattern string) []string {
 	return keys
 }
 
+// RandomKey 均匀随机返回一个未过期的键，数据库为空时返回 false
+// 不复制整个键列表：随机选一个位置后在 map 上走到该位置；
+// 选中已过期的键时顺便删除并重新选择
+func (db *RedisDb) RandomKey() (string, bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for len(db.keys) > 0 {
+		target := rand.Intn(len(db.keys))
+		var key string
+		i := 0
+		for k := range db.keys {
+			if i == target {
+				key = k
+				break
+			}
+			i++
+		}
+
+		if !db.isExpired(key) {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// ForEachChunk 分批遍历数据库中的键值对（用于 RDB 保存等后台任务）
+// 先在读锁下拍下键列表，再每 chunkSize 个键加一次读锁回调 fn，
+// 遍历期间写命令最多只需等待一个批次，读命令不受影响。
+// 遍历开始后被删除或已过期的键会被跳过；expireAt 为过期时间（Unix 秒，0 表示不过期）
+func (db *RedisDb) ForEachChunk(chunkSize int, fn func(key string, obj *RedisObject, expireAt int64) error) error {
+	if chunkSize <= 0 {
+		chunkSize = 1
+	}
+
+	db.mu.RLock()
+	keys := make([]string, 0, len(db.keys))
+	for key := range db.keys {
+		keys = append(keys, key)
+	}
+	db.mu.RUnlock()
+
+	for start := 0; start < len(keys); start += chunkSize {
+		end := start + chunkSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		if err := db.forEachKeys(keys[start:end], fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// forEachKeys 在读锁下对一批键回调 fn
+func (db *RedisDb) forEachKeys(keys []string, fn func(key string, obj *RedisObject, expireAt int64) error) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	now := time.Now().Unix()
+	for _, key := range keys {
+		obj, ok := db.keys[key]
+		if !ok {
+			continue
+		}
+		expireAt := db.expires[key]
+		if expireAt > 0 && now >= expireAt {
+			continue
+		}
+		if err := fn(key, obj, expireAt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // DBSize 获取数据库中的键数量
 func (db *RedisDb) DBSize() int {
 	db.mu.RLock()
@@ -287,6 +476,9 @@ func (db *RedisDb) FlushDB() {
 	// 清空所有数据
 	db.keys = make(map[string]*RedisObject)
 	db.expires = make(map[string]int64)
+	db.hexpires = make(map[string]struct{})
+	db.scan = structure.NewScanIndex()
+	db.touchAllWatchedKeys()
 }
 
 // CleanExpiredKeys 清理过期键（应该在后台定期调用）
@@ -303,7 +495,10 @@ func (db *RedisDb) CleanExpiredKeys() int {
 				obj.DecrRefCount()
 			}
 			delete(db.keys, key)
+			db.scan.Remove(key)
 			delete(db.expires, key)
+			delete(db.hexpires, key)
+			db.touchWatchedKey(key)
 			count++
 		}
 	}
@@ -311,6 +506,112 @@ func (db *RedisDb) CleanExpiredKeys() int {
 	return count
 }
 
+// ActiveExpireCycle 主动过期：从带过期时间的键中抽样最多 samples 个，删除其中已过期的键；
+// 再从设置了字段过期时间的哈希表中抽样最多 samples 个，删除过期的字段，字段全部过期时删除键。
+// 返回抽样数和删除的键数（调用方根据过期比例决定是否继续抽样）
+func (db *RedisDb) ActiveExpireCycle(samples int) (sampled, expired int) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	now := time.Now().Unix()
+
+	// map 的遍历顺序是随机的，可以直接作为抽样
+	for key, expire := range db.expires {
+		if sampled >= samples {
+			break
+		}
+		sampled++
+
+		if now >= expire {
+			if obj, ok := db.keys[key]; ok {
+				obj.DecrRefCount()
+			}
+			delete(db.keys, key)
+			db.scan.Remove(key)
+			delete(db.expires, key)
+			delete(db.hexpires, key)
+			db.touchWatchedKey(key)
+			expired++
+		}
+	}
+
+	hashes := 0
+	for key := range db.hexpires {
+		if hashes >= samples {
+			break
+		}
+		hashes++
+
+		obj, ok := db.keys[key]
+		if !ok {
+			delete(db.hexpires, key)
+			continue
+		}
+		hash, err := obj.GetHash()
+		if err != nil {
+			delete(db.hexpires, key)
+			continue
+		}
+		// Len 会删除已过期的字段
+		if hash.Len() == 0 {
+			obj.DecrRefCount()
+			delete(db.keys, key)
+			db.scan.Remove(key)
+			delete(db.expires, key)
+			delete(db.hexpires, key)
+			db.touchWatchedKey(key)
+			expired++
+		} else if !hash.HasFieldExpires() {
+			delete(db.hexpires, key)
+		}
+	}
+	sampled += hashes
+
+	return sampled, expired
+}
+
+// SampleKeys 随机抽样最多 count 个未过期的键（volatile 为 true 时只从设置了过期时间的键中抽样），
+// 对每个抽样的键调用 fn（持有读锁，fn 中不能再访问数据库）。用于 maxmemory 淘汰
+func (db *RedisDb) SampleKeys(count int, volatile bool, fn func(key string, obj *RedisObject, expireAt int64)) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	now := time.Now().Unix()
+	sampled := 0
+
+	// map 的遍历顺序是随机的，可以直接作为抽样
+	if volatile {
+		for key, expire := range db.expires {
+			if sampled >= count {
+				break
+			}
+			if now >= expire {
+				continue
+			}
+			if obj, ok := db.keys[key]; ok {
+				fn(key, obj, expire)
+				sampled++
+			}
+		}
+		return
+	}
+
+	for key, obj := range db.keys {
+		if sampled >= count {
+			break
+		}
+		expire, hasExpire := db.expires[key]
+		if hasExpire && now >= expire {
+			continue
+		}
+		if !hasExpire {
+			expire = -1
+		}
+		fn(key, obj, expire)
+		sampled++
+	}
+}
+
 // GetID 获取数据库 ID
 func (db *RedisDb) GetID() int {
 	return db.id
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+/*
+ * ============================================================================
+ * 惰性释放 (lazyfree)
+ * ============================================================================
+ *
+ * 删除一个包含大量元素的对象需要遍历并释放所有元素，在主流程中同步完成会阻塞其它命令。
+ * 与 Redis 的 lazyfree 一致，UNLINK 只把键从键空间中摘除，
+ * 释放工作量超过 LAZYFREE_THRESHOLD 的对象交给后台 goroutine 释放。
+ *
+ * 【释放工作量】
+ * - 集合类对象：元素个数
+ * - 字符串：1（释放开销与长度无关）
+ *
+ * 队列满时退化为同步释放，不会阻塞调用方。
+ */
+
+// LAZYFREE_THRESHOLD 释放工作量超过该值的对象才放入后台释放
+const LAZYFREE_THRESHOLD = 64
+
+// LazyFreeQueue 后台释放队列
+type LazyFreeQueue struct {
+	queue   chan *RedisObject
+	pending atomic.Int64 // 等待释放的对象数
+	freed   atomic.Int64 // 已在后台释放的对象数
+	stop    chan struct{}
+	once    sync.Once
+}
+
+// NewLazyFreeQueue 创建后台释放队列，size 为队列容量
+func NewLazyFreeQueue(size int) *LazyFreeQueue {
+	return &LazyFreeQueue{
+		queue: make(chan *RedisObject, size),
+		stop:  make(chan struct{}),
+	}
+}
+
+// Start 启动后台释放 goroutine
+func (q *LazyFreeQueue) Start() {
+	go q.run()
+}
+
+// Stop 停止后台释放 goroutine（队列中剩余的对象交给 GC）
+func (q *LazyFreeQueue) Stop() {
+	q.once.Do(func() {
+		close(q.stop)
+	})
+}
+
+// run 不断从队列中取出对象释放
+func (q *LazyFreeQueue) run() {
+	for {
+		select {
+		case obj := <-q.queue:
+			freeObject(obj)
+			q.pending.Add(-1)
+			q.freed.Add(1)
+		case <-q.stop:
+			return
+		}
+	}
+}
+
+// Free 释放对象：工作量小的对象同步释放，大的对象放入后台队列
+// 返回 true 表示对象放入了后台队列
+func (q *LazyFreeQueue) Free(obj *RedisObject) bool {
+	if obj.FreeEffort() <= LAZYFREE_THRESHOLD {
+		freeObject(obj)
+		return false
+	}
+
+	q.pending.Add(1)
+	select {
+	case q.queue <- obj:
+		return true
+	default:
+		// 队列已满，同步释放
+		q.pending.Add(-1)
+		freeObject(obj)
+		return false
+	}
+}
+
+// Pending 等待后台释放的对象数
+func (q *LazyFreeQueue) Pending() int64 {
+	return q.pending.Load()
+}
+
+// Freed 已在后台释放的对象数
+func (q *LazyFreeQueue) Freed() int64 {
+	return q.freed.Load()
+}
+
+// freeObject 释放对象：减少引用计数并丢弃引用，底层数据由 GC 回收
+// （不清空 Ptr：BGSAVE 等后台任务可能仍持有该对象）
+func freeObject(obj *RedisObject) {
+	obj.DecrRefCount()
+}
+
+// FreeEffort 释放对象的工作量（集合类对象为元素个数，字符串为 1）
+func (obj *RedisObject) FreeEffort() int {
+	switch obj.Type {
+	case OBJ_LIST:
+		list, _ := obj.GetList()
+		return list.Len()
+	case OBJ_SET:
+		set, _ := obj.GetSet()
+		return set.Card()
+	case OBJ_ZSET:
+		zset, _ := obj.GetZSet()
+		return zset.Card()
+	case OBJ_HASH:
+		hash, _ := obj.GetHash()
+		return hash.Len()
+	default:
+		return 1
+	}
+}
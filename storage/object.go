@@ -3,6 +3,10 @@ package storage
 import (
 	"bytes"
 	"errors"
+	"math/rand"
+	"strconv"
+	"sync/atomic"
+	"time"
 
 	"github.com/code-100-precent/LingCache/structure"
 )
@@ -26,6 +30,9 @@ var (
  * - ptr: 指向实际数据的指针
  * - refcount: 引用计数（用于内存管理）
  *
+ * 共享对象（如共享整数）的引用计数固定为 OBJ_SHARED_REFCOUNT，多个连接并发使用它们时
+ * IncrRefCount/DecrRefCount 不做任何修改，避免非原子的计数竞争
+ *
  * 【对象类型】
  * - OBJ_STRING: 字符串对象
  * - OBJ_LIST: 列表对象
@@ -42,6 +49,9 @@ var (
  * - Hash: LISTPACK、HT
  */
 
+// OBJ_SHARED_REFCOUNT 共享对象的引用计数（与 Redis 相同），不会被增减
+const OBJ_SHARED_REFCOUNT = 1<<31 - 1
+
 // ObjectType 对象类型
 type ObjectType byte
 
@@ -59,6 +69,8 @@ type RedisObject struct {
 	Encoding structure.Encoding // 编码方式
 	Ptr      interface{}        // 指向实际数据的指针
 	RefCount int                // 引用计数
+	lru      int64              // 最近一次访问时间（Unix 毫秒，读锁下并发更新，使用原子操作）
+	lfu      int64              // LFU 访问频率：高位为上次衰减时间（分钟），低 8 位为对数计数器
 }
 
 // NewStringObject 创建字符串对象
@@ -112,13 +124,106 @@ func NewHashObject() *RedisObject {
 	}
 }
 
-// IncrRefCount 增加引用计数
+// LFU 计数器参数（与 Redis 默认的 lfu-log-factor、lfu-decay-time 一致）
+const (
+	LFU_INIT_VAL   = 5  // 新对象的初始计数，避免刚写入的键马上被淘汰
+	LFU_LOG_FACTOR = 10 // 对数增长因子：计数越大，再次增长的概率越低
+	LFU_DECAY_TIME = 1  // 每经过多少分钟计数器减 1
+)
+
+// Touch 更新最近访问时间和访问频率（读写键时调用，用于 LRU/LFU 和 OBJECT IDLETIME/FREQ）
+func (obj *RedisObject) Touch() {
+	now := time.Now()
+	atomic.StoreInt64(&obj.lru, now.UnixMilli())
+
+	counter := obj.lfuDecr(now)
+	counter = lfuLogIncr(counter)
+	atomic.StoreInt64(&obj.lfu, now.Unix()/60<<8|int64(counter))
+}
+
+// Freq 获取访问频率计数器（已按空闲时间衰减）
+func (obj *RedisObject) Freq() int {
+	return obj.lfuDecr(time.Now())
+}
+
+// lfuDecr 按距离上次衰减经过的分钟数递减计数器，返回递减后的值（不写回）
+// 从未访问过的对象返回 LFU_INIT_VAL
+func (obj *RedisObject) lfuDecr(now time.Time) int {
+	lfu := atomic.LoadInt64(&obj.lfu)
+	if lfu == 0 {
+		return LFU_INIT_VAL
+	}
+
+	counter := int(lfu & 0xff)
+	elapsed := now.Unix()/60 - lfu>>8
+	if periods := int(elapsed / LFU_DECAY_TIME); periods > 0 {
+		counter -= periods
+		if counter < 0 {
+			counter = 0
+		}
+	}
+	return counter
+}
+
+// lfuLogIncr 以对数概率增加计数器：counter 越大，增加的概率越小，上限 255
+func lfuLogIncr(counter int) int {
+	if counter >= 255 {
+		return 255
+	}
+	baseval := counter - LFU_INIT_VAL
+	if baseval < 0 {
+		baseval = 0
+	}
+	p := 1.0 / float64(baseval*LFU_LOG_FACTOR+1)
+	if rand.Float64() < p {
+		counter++
+	}
+	return counter
+}
+
+// SetLastAccess 设置最近访问时间
+func (obj *RedisObject) SetLastAccess(t time.Time) {
+	atomic.StoreInt64(&obj.lru, t.UnixMilli())
+}
+
+// LastAccess 获取最近访问时间（Unix 毫秒）
+func (obj *RedisObject) LastAccess() int64 {
+	return atomic.LoadInt64(&obj.lru)
+}
+
+// IdleTime 获取空闲时间（秒），即距离最近一次访问的时间
+func (obj *RedisObject) IdleTime() int64 {
+	idle := time.Now().UnixMilli() - obj.LastAccess()
+	if idle < 0 {
+		return 0
+	}
+	return idle / 1000
+}
+
+// MakeShared 将对象标记为共享对象（创建后、被多个连接使用之前调用）
+func (obj *RedisObject) MakeShared() *RedisObject {
+	obj.RefCount = OBJ_SHARED_REFCOUNT
+	return obj
+}
+
+// IsShared 是否为共享对象
+func (obj *RedisObject) IsShared() bool {
+	return obj.RefCount == OBJ_SHARED_REFCOUNT
+}
+
+// IncrRefCount 增加引用计数（共享对象不变）
 func (obj *RedisObject) IncrRefCount() {
+	if obj.RefCount == OBJ_SHARED_REFCOUNT {
+		return
+	}
 	obj.RefCount++
 }
 
-// DecrRefCount 减少引用计数
+// DecrRefCount 减少引用计数（共享对象不变）
 func (obj *RedisObject) DecrRefCount() {
+	if obj.RefCount == OBJ_SHARED_REFCOUNT {
+		return
+	}
 	obj.RefCount--
 	if obj.RefCount <= 0 {
 		// 引用计数为 0，可以释放对象
@@ -136,6 +241,41 @@ func (obj *RedisObject) GetStringValue() ([]byte, error) {
 	return structure.SdsBytes(sds), nil
 }
 
+// AppendString 在字符串对象末尾原地追加 value（APPEND），返回新的长度
+// SDS 预分配的空间足够时复用原来的缓冲区，调用方需保证对象没有被共享（RefCount 为 1）
+func (obj *RedisObject) AppendString(value []byte) (int, error) {
+	if obj.Type != OBJ_STRING {
+		return 0, ErrWrongType
+	}
+
+	sds := structure.SDSCatLen(obj.Ptr.(structure.SDS), value, uint64(len(value)))
+	obj.Ptr = sds
+	return structure.SDSLen(sds), nil
+}
+
+// GetRangeString 获取字符串 [start, end] 范围的内容（GETRANGE），索引规则与 SDSRange 一致
+func (obj *RedisObject) GetRangeString(start, end int64) ([]byte, error) {
+	if obj.Type != OBJ_STRING {
+		return nil, ErrWrongType
+	}
+
+	sds := structure.SDSDup(obj.Ptr.(structure.SDS))
+	structure.SDSRange(sds, start, end)
+	return structure.SdsBytes(sds), nil
+}
+
+// SetRangeString 从 offset 开始原地覆盖字符串对象（SETRANGE），返回新的长度
+// 调用方需保证对象没有被共享（RefCount 为 1）
+func (obj *RedisObject) SetRangeString(offset int, value []byte) (int, error) {
+	if obj.Type != OBJ_STRING {
+		return 0, ErrWrongType
+	}
+
+	sds := structure.SDSSetRange(obj.Ptr.(structure.SDS), uint64(offset), value)
+	obj.Ptr = sds
+	return structure.SDSLen(sds), nil
+}
+
 // GetList 获取列表对象
 func (obj *RedisObject) GetList() (*structure.RedisList, error) {
 	if obj.Type != OBJ_LIST {
@@ -186,13 +326,56 @@ func (obj *RedisObject) TypeString() string {
 	}
 }
 
-// EncodingString 返回编码方式的字符串表示
+// OBJ_ENCODING_EMBSTR_SIZE_LIMIT 不超过该长度的字符串使用 embstr 编码
+const OBJ_ENCODING_EMBSTR_SIZE_LIMIT = 44
+
+// ActualEncoding 返回对象当前的实际编码
+// 集合类对象在元素增长时会在内部转换编码，因此以底层结构的编码为准；
+// 字符串按值判断 int/embstr/raw
+func (obj *RedisObject) ActualEncoding() structure.Encoding {
+	switch obj.Type {
+	case OBJ_STRING:
+		val, err := obj.GetStringValue()
+		if err != nil {
+			return obj.Encoding
+		}
+		if len(val) <= 20 {
+			// 只有规范形式的整数（无前导零、无正号）才使用 int 编码
+			if n, err := strconv.ParseInt(string(val), 10, 64); err == nil && strconv.FormatInt(n, 10) == string(val) {
+				return structure.OBJ_ENCODING_INT
+			}
+		}
+		if len(val) <= OBJ_ENCODING_EMBSTR_SIZE_LIMIT {
+			return structure.OBJ_ENCODING_EMBSTR
+		}
+		return structure.OBJ_ENCODING_RAW
+	case OBJ_LIST:
+		list, _ := obj.GetList()
+		return list.Encoding()
+	case OBJ_SET:
+		set, _ := obj.GetSet()
+		return set.Encoding()
+	case OBJ_ZSET:
+		zset, _ := obj.GetZSet()
+		return zset.Encoding()
+	case OBJ_HASH:
+		hash, _ := obj.GetHash()
+		return hash.Encoding()
+	default:
+		return obj.Encoding
+	}
+}
+
+// EncodingString 返回编码方式的字符串表示（与 Redis 7 OBJECT ENCODING 一致）
+// 只读取对象，不回写 Encoding 字段（OBJECT ENCODING 只持有读锁，可能与其它读命令并发执行）
 func (obj *RedisObject) EncodingString() string {
-	switch obj.Encoding {
+	switch obj.ActualEncoding() {
 	case structure.OBJ_ENCODING_RAW:
 		return "raw"
 	case structure.OBJ_ENCODING_INT:
 		return "int"
+	case structure.OBJ_ENCODING_EMBSTR:
+		return "embstr"
 	case structure.OBJ_ENCODING_HT:
 		return "hashtable"
 	case structure.OBJ_ENCODING_INTSET:
@@ -203,11 +386,104 @@ func (obj *RedisObject) EncodingString() string {
 		return "quicklist"
 	case structure.OBJ_ENCODING_LISTPACK:
 		return "listpack"
+	case structure.OBJ_ENCODING_STREAM:
+		return "stream"
 	default:
 		return "unknown"
 	}
 }
 
+// Clone 深拷贝对象（COPY 命令使用）：副本与原对象不共享任何底层数据，
+// 修改副本不会影响原对象。返回的副本引用计数为 1
+func (obj *RedisObject) Clone() *RedisObject {
+	switch obj.Type {
+	case OBJ_STRING:
+		val, _ := obj.GetStringValue()
+		clone := NewStringObject(bytes.Clone(val))
+		clone.Encoding = obj.Encoding
+		return clone
+
+	case OBJ_LIST:
+		list, _ := obj.GetList()
+		clone := NewListObject()
+		cloneList, _ := clone.GetList()
+		values, _ := list.Range(0, -1)
+		for _, value := range values {
+			cloneList.Push(bytes.Clone(value), 1) // TAIL
+		}
+		return clone
+
+	case OBJ_SET:
+		set, _ := obj.GetSet()
+		clone := NewSetObject()
+		cloneSet, _ := clone.GetSet()
+		for _, member := range set.Members() {
+			cloneSet.Add(bytes.Clone(member))
+		}
+		return clone
+
+	case OBJ_ZSET:
+		zset, _ := obj.GetZSet()
+		clone := NewZSetObject()
+		cloneZSet, _ := clone.GetZSet()
+		entries, _ := zset.Range(0, -1, false)
+		for _, entry := range entries {
+			cloneZSet.Add(bytes.Clone(entry.Member()), entry.Score())
+		}
+		return clone
+
+	case OBJ_HASH:
+		hash, _ := obj.GetHash()
+		clone := NewHashObject()
+		cloneHash, _ := clone.GetHash()
+		for _, entry := range hash.GetAll() {
+			field := bytes.Clone(entry.Field())
+			cloneHash.Set(field, bytes.Clone(entry.Value()))
+			// 字段级过期时间一并复制
+			if expireAt, ok := hash.FieldExpireAt(field); ok {
+				cloneHash.SetFieldExpire(field, expireAt)
+			}
+		}
+		return clone
+
+	default:
+		return &RedisObject{
+			Type:     obj.Type,
+			Encoding: obj.Encoding,
+			Ptr:      obj.Ptr,
+			RefCount: 1,
+		}
+	}
+}
+
+// robjSize redisObject 结构体大小（type/encoding/lru 共 8 字节 + refcount + ptr）
+const robjSize = 16
+
+// SizeInBytes 估算对象占用的内存（不含键名），集合类型只抽样 samples 个元素，
+// samples <= 0 表示精确计算（MEMORY USAGE 使用）
+func (obj *RedisObject) SizeInBytes(samples int) int64 {
+	size := int64(robjSize)
+	switch obj.Type {
+	case OBJ_STRING:
+		if sds, ok := obj.Ptr.(structure.SDS); ok {
+			size += structure.SDSAllocSize(sds)
+		}
+	case OBJ_LIST:
+		list, _ := obj.GetList()
+		size += list.SizeInBytes(samples)
+	case OBJ_SET:
+		set, _ := obj.GetSet()
+		size += set.SizeInBytes(samples)
+	case OBJ_ZSET:
+		zset, _ := obj.GetZSet()
+		size += zset.SizeInBytes(samples)
+	case OBJ_HASH:
+		hash, _ := obj.GetHash()
+		size += hash.SizeInBytes(samples)
+	}
+	return size
+}
+
 // Equal 比较两个对象是否相等（简化实现：比较类型和值）
 func (obj *RedisObject) Equal(other *RedisObject) bool {
 	if obj.Type != other.Type {
@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/code-100-precent/LingCache/structure"
+)
+
+// TestEncodingString 测试各类型对象在小/大两种形态下的 OBJECT ENCODING 名称
+func TestEncodingString(t *testing.T) {
+	// String
+	cases := []struct {
+		value string
+		want  string
+	}{
+		{"12345", "int"},
+		{"007", "embstr"},
+		{"hello", "embstr"},
+		{strings.Repeat("x", 45), "raw"},
+	}
+	for _, c := range cases {
+		obj := NewStringObject([]byte(c.value))
+		if got := obj.EncodingString(); got != c.want {
+			t.Fatalf("String %q: expected %s, got %s", c.value, c.want, got)
+		}
+		// 只读取编码，不修改对象
+		if obj.Encoding != structure.OBJ_ENCODING_RAW {
+			t.Fatalf("String %q: EncodingString modified Encoding to %v", c.value, obj.Encoding)
+		}
+	}
+
+	// List
+	listObj := NewListObject()
+	list, _ := listObj.GetList()
+	list.Push([]byte("a"), 1)
+	if got := listObj.EncodingString(); got != "listpack" {
+		t.Fatalf("Small list: expected listpack, got %s", got)
+	}
+	for i := 0; i < 1000; i++ {
+		list.Push([]byte("element:"+strconv.Itoa(i)), 1)
+	}
+	if got := listObj.EncodingString(); got != "quicklist" {
+		t.Fatalf("Large list: expected quicklist, got %s", got)
+	}
+
+	// Set
+	setObj := NewSetObject()
+	set, _ := setObj.GetSet()
+	set.Add([]byte("1"))
+	if got := setObj.EncodingString(); got != "intset" {
+		t.Fatalf("Small integer set: expected intset, got %s", got)
+	}
+	for i := 0; i < 1000; i++ {
+		set.Add([]byte(strconv.Itoa(i)))
+	}
+	if got := setObj.EncodingString(); got != "hashtable" {
+		t.Fatalf("Large set: expected hashtable, got %s", got)
+	}
+
+	// ZSet
+	zsetObj := NewZSetObject()
+	zset, _ := zsetObj.GetZSet()
+	zset.Add([]byte("a"), 1)
+	if got := zsetObj.EncodingString(); got != "listpack" {
+		t.Fatalf("Small zset: expected listpack, got %s", got)
+	}
+	for i := 0; i < 200; i++ {
+		zset.Add([]byte("member:"+strconv.Itoa(i)), float64(i))
+	}
+	if got := zsetObj.EncodingString(); got != "skiplist" {
+		t.Fatalf("Large zset: expected skiplist, got %s", got)
+	}
+
+	// Hash
+	hashObj := NewHashObject()
+	hash, _ := hashObj.GetHash()
+	hash.Set([]byte("field"), []byte("value"))
+	if got := hashObj.EncodingString(); got != "listpack" {
+		t.Fatalf("Small hash: expected listpack, got %s", got)
+	}
+	hash.Set([]byte("big"), []byte(strings.Repeat("v", 100)))
+	if got := hashObj.EncodingString(); got != "hashtable" {
+		t.Fatalf("Large hash: expected hashtable, got %s", got)
+	}
+
+	t.Log("Encoding string test passed")
+}
+
+// TestClone 测试 Clone 深拷贝各类型对象
+func TestClone(t *testing.T) {
+	setObj := NewSetObject()
+	set, _ := setObj.GetSet()
+	set.Add([]byte("a"))
+	set.Add([]byte("b"))
+	setClone, _ := setObj.Clone().GetSet()
+	setClone.Remove([]byte("a"))
+	if set.Card() != 2 || setClone.Card() != 1 {
+		t.Fatalf("Set clone not independent: %d/%d", set.Card(), setClone.Card())
+	}
+
+	zsetObj := NewZSetObject()
+	zset, _ := zsetObj.GetZSet()
+	zset.Add([]byte("a"), 1)
+	zset.Add([]byte("b"), 2)
+	zsetClone, _ := zsetObj.Clone().GetZSet()
+	zsetClone.Remove([]byte("b"))
+	if score, ok := zsetClone.Score([]byte("a")); !ok || score != 1 {
+		t.Fatalf("ZSet clone lost member a: %v %v", score, ok)
+	}
+	if zset.Card() != 2 {
+		t.Fatalf("Source zset changed: %d", zset.Card())
+	}
+
+	strObj := NewStringObject([]byte("hello"))
+	strClone := strObj.Clone()
+	if !strObj.Equal(strClone) || strClone.RefCount != 1 {
+		t.Fatal("String clone differs from source")
+	}
+}
+
+// TestIdleTime 测试未访问的对象空闲时间增长，访问后清零
+func TestIdleTime(t *testing.T) {
+	obj := NewStringObject([]byte("v"))
+	obj.Touch()
+	if idle := obj.IdleTime(); idle != 0 {
+		t.Fatalf("Expected idle time 0 right after access, got %d", idle)
+	}
+
+	obj.SetLastAccess(time.Now().Add(-3 * time.Second))
+	if idle := obj.IdleTime(); idle < 3 {
+		t.Fatalf("Expected idle time >= 3 for an untouched object, got %d", idle)
+	}
+
+	obj.Touch()
+	if idle := obj.IdleTime(); idle != 0 {
+		t.Fatalf("Expected idle time reset by access, got %d", idle)
+	}
+}
+
+// TestLFUCounter 测试 LFU 计数器按对数增长、按空闲分钟数衰减
+func TestLFUCounter(t *testing.T) {
+	obj := NewStringObject([]byte("v"))
+	if freq := obj.Freq(); freq != LFU_INIT_VAL {
+		t.Fatalf("Expected new object to start at %d, got %d", LFU_INIT_VAL, freq)
+	}
+
+	// 1000 次访问：计数器增长，但远小于访问次数（LFU_LOG_FACTOR=10 时约为 18）
+	for i := 0; i < 1000; i++ {
+		obj.Touch()
+	}
+	freq := obj.Freq()
+	if freq <= LFU_INIT_VAL || freq > 40 {
+		t.Fatalf("Expected logarithmic growth after 1000 accesses, got %d", freq)
+	}
+
+	// 每空闲 LFU_DECAY_TIME 分钟计数器减 1，不低于 0
+	lastDecay := time.Now().Unix()/60 - 3*LFU_DECAY_TIME
+	obj.lfu = lastDecay<<8 | 20
+	if freq := obj.Freq(); freq != 17 {
+		t.Fatalf("Expected counter to decay from 20 to 17 after 3 periods, got %d", freq)
+	}
+	obj.lfu = lastDecay<<8 | 2
+	if freq := obj.Freq(); freq != 0 {
+		t.Fatalf("Expected decayed counter to stop at 0, got %d", freq)
+	}
+}
@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"strings"
+	"time"
+
+	"github.com/code-100-precent/LingCache/utils"
+)
+
+/*
+ * ============================================================================
+ * SCAN
+ * ============================================================================
+ *
+ * Go 的 map 无法从中间位置继续遍历，SCAN 使用数据库维护的按哈希值分桶的键索引
+ * （structure.ScanIndex，见 structure/scan.go）：游标是桶下标，按"反向二进制"递增，
+ * 从第一次调用到游标回到 0 期间一直存在的键至少返回一次（桶数缩小时可能重复返回）。
+ *
+ * 每次调用只访问 COUNT 个左右的键，与数据库大小无关。
+ */
+
+// ScanBucket 按游标增量遍历键空间（SCAN 命令）
+//
+// 从 cursor 对应的桶开始按整桶访问，访问的键数达到 count（或连续访问了 10*count 个空桶）时停止。
+// match 和 typeFilter 在访问之后再过滤（与 Redis 一致，COUNT 表示工作量），
+// 因此一次调用返回的键可能少于 count 个甚至为空，但只要游标不为 0 就应继续遍历。
+// 逻辑上已过期的键不会返回。返回下一次的游标（0 表示遍历完成）和本批匹配的键。
+func (db *RedisDb) ScanBucket(cursor int64, count int, match, typeFilter string) (int64, []string) {
+	if count <= 0 {
+		count = 10
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	now := time.Now().Unix()
+	matchAll := match == "" || match == "*"
+
+	keys := make([]string, 0, count)
+	next := db.scan.Scan(uint64(cursor), count, func(key string) {
+		// 跳过逻辑上已过期的键（读锁下不删除）
+		if expireAt, ok := db.expires[key]; ok && now >= expireAt {
+			return
+		}
+		if !matchAll && !utils.GlobMatch(match, key) {
+			return
+		}
+		if typeFilter != "" && !strings.EqualFold(db.keys[key].TypeString(), typeFilter) {
+			return
+		}
+		keys = append(keys, key)
+	})
+
+	return int64(next), keys
+}
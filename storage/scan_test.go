@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// scanAll 用 ScanBucket 遍历整个数据库，每次调用之间执行 between（可以为 nil），返回键 -> 返回次数
+func scanAll(t *testing.T, db *RedisDb, count int, match, typeFilter string, between func()) map[string]int {
+	t.Helper()
+	seen := make(map[string]int)
+	var cursor int64
+	for calls := 0; ; calls++ {
+		if calls > 1000000 {
+			t.Fatal("SCAN did not terminate")
+		}
+		next, keys := db.ScanBucket(cursor, count, match, typeFilter)
+		for _, key := range keys {
+			seen[key]++
+		}
+		if next == 0 {
+			return seen
+		}
+		cursor = next
+		if between != nil {
+			between()
+		}
+	}
+}
+
+// TestScanBucket 测试遍历期间桶索引扩容、缩容时，一直存在的键仍然至少返回一次，
+// 已删除的键不会留在索引中
+func TestScanBucket(t *testing.T) {
+	db := NewRedisDb(0)
+	const n = 1000
+	for i := 0; i < n; i++ {
+		db.Set("key:"+strconv.Itoa(i), NewStringObject([]byte("v")))
+	}
+
+	// 遍历期间不断插入新键（多次扩容）
+	extra := 0
+	seen := scanAll(t, db, 10, "", "", func() {
+		for j := 0; j < 50; j++ {
+			db.Set("extra:"+strconv.Itoa(extra), NewStringObject([]byte("v")))
+			extra++
+		}
+	})
+	for i := 0; i < n; i++ {
+		if seen["key:"+strconv.Itoa(i)] == 0 {
+			t.Fatalf("key:%d present for the whole scan was not returned", i)
+		}
+	}
+
+	// 遍历期间删除这些新键（多次缩容）
+	seen = scanAll(t, db, 10, "", "", func() {
+		for j := 0; j < 50 && extra > 0; j++ {
+			extra--
+			db.Del("extra:" + strconv.Itoa(extra))
+		}
+	})
+	for i := 0; i < n; i++ {
+		if seen["key:"+strconv.Itoa(i)] == 0 {
+			t.Fatalf("key:%d present for the whole scan was not returned", i)
+		}
+	}
+
+	// 没有并发修改时每个键恰好返回一次
+	seen = scanAll(t, db, 100, "", "", nil)
+	if len(seen) != n {
+		t.Fatalf("Expected %d keys, got %d", n, len(seen))
+	}
+	for key, times := range seen {
+		if times != 1 {
+			t.Fatalf("%s returned %d times", key, times)
+		}
+	}
+
+	db.FlushDB()
+	if next, keys := db.ScanBucket(0, 10, "", ""); next != 0 || len(keys) != 0 {
+		t.Fatalf("Expected an empty scan after FLUSHDB, got %d %v", next, keys)
+	}
+}
+
+// TestScanBucketFilters 测试 MATCH、TYPE 过滤以及跳过逻辑上已过期的键
+func TestScanBucketFilters(t *testing.T) {
+	db := NewRedisDb(0)
+	db.Set("s1", NewStringObject([]byte("v")))
+	db.Set("s2", NewStringObject([]byte("v")))
+	db.Set("l1", NewListObject())
+	db.Set("h1", NewHashObject())
+	db.Set("gone", NewStringObject([]byte("v")))
+	db.ExpireAt("gone", time.Now().Unix()-1)
+
+	keysOf := func(seen map[string]int) []string {
+		keys := make([]string, 0, len(seen))
+		for key := range seen {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return keys
+	}
+	check := func(got []string, want ...string) {
+		t.Helper()
+		if len(got) != len(want) {
+			t.Fatalf("Expected %v, got %v", want, got)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("Expected %v, got %v", want, got)
+			}
+		}
+	}
+
+	check(keysOf(scanAll(t, db, 2, "", "", nil)), "h1", "l1", "s1", "s2")
+	check(keysOf(scanAll(t, db, 2, "", "string", nil)), "s1", "s2")
+	check(keysOf(scanAll(t, db, 2, "", "LIST", nil)), "l1")
+	check(keysOf(scanAll(t, db, 2, "", "zset", nil)))
+	check(keysOf(scanAll(t, db, 2, "s*", "", nil)), "s1", "s2")
+	check(keysOf(scanAll(t, db, 2, "*1", "hash", nil)), "h1")
+}
+
+// BenchmarkScanBucket 测试 1M 个键的数据库中 SCAN COUNT 100 的性能
+func BenchmarkScanBucket(b *testing.B) {
+	db := NewRedisDb(0)
+	for i := 0; i < 1000000; i++ {
+		db.Set("key:"+strconv.Itoa(i), NewStringObject([]byte("v")))
+	}
+
+	b.ResetTimer()
+	var cursor int64
+	for i := 0; i < b.N; i++ {
+		cursor, _ = db.ScanBucket(cursor, 100, "", "")
+	}
+}
@@ -2,6 +2,8 @@ package storage
 
 import (
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 /*
@@ -18,16 +20,42 @@ import (
  * 【数据库选择】
  * 客户端可以使用 SELECT 命令选择不同的数据库。
  * 每个数据库独立存储键值对，互不干扰。
+ *
+ * 【主动过期】
+ * 过期键默认只在访问时惰性删除，从不访问的键会一直占用内存。
+ * StartActiveExpire 启动后台任务，每秒执行 hz 次 activeExpireCycle：
+ * 与 Redis 一致，每个数据库每轮抽样 20 个带过期时间的键，
+ * 如果其中超过 25% 已过期，说明过期键较多，继续抽样，直到比例降下来或用完时间预算
+ * （每个周期的 25%）。
+ *
+ * 【脏数据计数】
+ * dirty 记录上次保存以来的写命令数，配合 lastSave 判断是否满足 save 保存点。
+ * 保存失败时 dirty 不清零，lastSaveFailed 记录失败状态（INFO 的 rdb_last_bgsave_status）。
  */
 
 // RedisServer Redis 服务器
 type RedisServer struct {
-	dbs       []*RedisDb // 数据库数组
-	dbnum     int        // 数据库数量
-	currentDb int        // 当前选中的数据库
+	dbs       []*RedisDb   // 数据库数组
+	dbnum     int          // 数据库数量
+	currentDb int          // 当前选中的数据库
+	dirty     atomic.Int64 // 上次保存以来的修改次数
+	lastSave  atomic.Int64 // 上次成功保存的时间（Unix 秒）
 	mu        sync.RWMutex
+
+	lastSaveFailed atomic.Bool // 最近一次保存是否失败
+
+	// 主动过期后台任务
+	expireHz   atomic.Int64  // 每秒执行主动过期的次数
+	expireWake chan struct{} // 通知后台任务 hz 已改变
+	expireStop chan struct{} // 关闭后后台任务退出（nil 表示未运行）
+	expireMu   sync.Mutex    // 保护 expireStop
 }
 
+const (
+	activeExpireSamples   = 20 // 每轮每个数据库抽样的键数
+	activeExpireThreshold = 25 // 过期比例（%）超过该值时继续抽样
+)
+
 // NewRedisServer 创建新的 Redis 服务器
 func NewRedisServer(dbnum int) *RedisServer {
 	if dbnum <= 0 {
@@ -35,19 +63,42 @@ func NewRedisServer(dbnum int) *RedisServer {
 	}
 
 	server := &RedisServer{
-		dbs:       make([]*RedisDb, dbnum),
-		dbnum:     dbnum,
-		currentDb: 0,
+		dbs:        make([]*RedisDb, dbnum),
+		dbnum:      dbnum,
+		currentDb:  0,
+		expireWake: make(chan struct{}, 1),
 	}
 
 	// 初始化所有数据库
 	for i := 0; i < dbnum; i++ {
 		server.dbs[i] = NewRedisDb(i)
 	}
+	server.lastSave.Store(time.Now().Unix())
 
 	return server
 }
 
+// Clone 深拷贝所有数据库的键和过期时间（全量复制在写屏障内用它生成时间点快照），
+// 已过期的键不复制
+func (s *RedisServer) Clone() *RedisServer {
+	clone := NewRedisServer(s.dbnum)
+	for i := 0; i < s.dbnum; i++ {
+		db, err := s.GetDb(i)
+		if err != nil {
+			continue
+		}
+		target := clone.dbs[i]
+		db.ForEachChunk(1024, func(key string, obj *RedisObject, expireAt int64) error {
+			target.Set(key, obj.Clone())
+			if expireAt > 0 {
+				target.ExpireAt(key, expireAt)
+			}
+			return nil
+		})
+	}
+	return clone
+}
+
 // SelectDb 选择数据库
 func (s *RedisServer) SelectDb(dbIndex int) error {
 	s.mu.Lock()
@@ -96,6 +147,117 @@ func (s *RedisServer) GetDbNum() int {
 	return s.dbnum
 }
 
+// ActiveExpire 执行一轮主动过期，最多占用 budget 时间，返回删除的键数
+func (s *RedisServer) ActiveExpire(budget time.Duration) int {
+	deadline := time.Now().Add(budget)
+	total := 0
+
+	for _, db := range s.dbs {
+		for {
+			sampled, expired := db.ActiveExpireCycle(activeExpireSamples)
+			total += expired
+			if sampled == 0 || expired*100 <= sampled*activeExpireThreshold {
+				break
+			}
+			if time.Now().After(deadline) {
+				return total
+			}
+		}
+	}
+
+	return total
+}
+
+// StartActiveExpire 启动主动过期后台任务（已在运行时只更新频率）
+func (s *RedisServer) StartActiveExpire(hz int) {
+	s.SetActiveExpireHz(hz)
+
+	s.expireMu.Lock()
+	defer s.expireMu.Unlock()
+	if s.expireStop != nil {
+		return
+	}
+	s.expireStop = make(chan struct{})
+	go s.activeExpireLoop(s.expireStop)
+}
+
+// StopActiveExpire 停止主动过期后台任务
+func (s *RedisServer) StopActiveExpire() {
+	s.expireMu.Lock()
+	defer s.expireMu.Unlock()
+	if s.expireStop != nil {
+		close(s.expireStop)
+		s.expireStop = nil
+	}
+}
+
+// SetActiveExpireHz 设置主动过期的频率（每秒次数，至少为 1）
+func (s *RedisServer) SetActiveExpireHz(hz int) {
+	if hz < 1 {
+		hz = 1
+	}
+	s.expireHz.Store(int64(hz))
+
+	select {
+	case s.expireWake <- struct{}{}:
+	default:
+	}
+}
+
+// activeExpireLoop 主动过期后台任务主循环
+func (s *RedisServer) activeExpireLoop(stop chan struct{}) {
+	period := time.Second / time.Duration(s.expireHz.Load())
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-s.expireWake:
+			if newPeriod := time.Second / time.Duration(s.expireHz.Load()); newPeriod != period {
+				period = newPeriod
+				ticker.Reset(period)
+			}
+		case <-ticker.C:
+			s.ActiveExpire(period / 4)
+		}
+	}
+}
+
+// AddDirty 记录 n 次修改
+func (s *RedisServer) AddDirty(n int64) {
+	s.dirty.Add(n)
+}
+
+// Dirty 获取上次保存以来的修改次数
+func (s *RedisServer) Dirty() int64 {
+	return s.dirty.Load()
+}
+
+// MarkSaved 记录一次成功的保存：dirtyAtStart 为开始保存时的修改次数，
+// 保存期间产生的修改仍然计入 dirty
+func (s *RedisServer) MarkSaved(dirtyAtStart int64) {
+	s.dirty.Add(-dirtyAtStart)
+	s.lastSave.Store(time.Now().Unix())
+	s.lastSaveFailed.Store(false)
+}
+
+// MarkSaveFailed 记录一次失败的保存（dirty 和 lastSave 保持不变）
+func (s *RedisServer) MarkSaveFailed() {
+	s.lastSaveFailed.Store(true)
+}
+
+// LastSaveOK 最近一次保存是否成功（还没有保存过时为 true）
+func (s *RedisServer) LastSaveOK() bool {
+	return !s.lastSaveFailed.Load()
+}
+
+// LastSave 获取上次成功保存的时间（Unix 秒）
+func (s *RedisServer) LastSave() int64 {
+	return s.lastSave.Load()
+}
+
 // ServerError 服务器错误
 type ServerError struct {
 	Message string
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// keyCount 数据库中实际保存的键数（包括逻辑上已过期但尚未删除的键）
+func keyCount(db *RedisDb) int {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return len(db.keys)
+}
+
+// TestActiveExpire 测试从不访问的过期键会被主动过期任务回收
+func TestActiveExpire(t *testing.T) {
+	server := NewRedisServer(2)
+	server.StartActiveExpire(50)
+	defer server.StopActiveExpire()
+
+	db, _ := server.GetDb(1)
+	db.Set("forgotten", NewStringObject([]byte("v")))
+	db.Expire("forgotten", 1)
+	db.Set("kept", NewStringObject([]byte("v")))
+
+	deadline := time.Now().Add(3 * time.Second)
+	for keyCount(db) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expired key was not reclaimed without access")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if db.DBSize() != 1 || !db.Exists("kept") {
+		t.Fatal("Key without TTL should be kept")
+	}
+}
+
+// TestActiveExpireAdaptive 测试过期键较多时同一轮内持续抽样，直到全部回收
+func TestActiveExpireAdaptive(t *testing.T) {
+	server := NewRedisServer(1)
+	db, _ := server.GetDb(0)
+	past := time.Now().Unix() - 1
+	for i := 0; i < 1000; i++ {
+		key := "key:" + strconv.Itoa(i)
+		db.Set(key, NewStringObject([]byte("v")))
+		db.ExpireAt(key, past)
+	}
+
+	if expired := server.ActiveExpire(time.Second); expired != 1000 {
+		t.Fatalf("Expected 1000 keys reclaimed in one cycle, got %d", expired)
+	}
+
+	// 停止后不再回收
+	server.StartActiveExpire(100)
+	server.StopActiveExpire()
+	db.Set("late", NewStringObject([]byte("v")))
+	db.ExpireAt("late", past)
+	time.Sleep(50 * time.Millisecond)
+	if keyCount(db) != 1 {
+		t.Fatal("Stopped active expire should not reclaim keys")
+	}
+}
@@ -0,0 +1,83 @@
+package storage
+
+/*
+ * ============================================================================
+ * 键版本号（WATCH 乐观锁）
+ * ============================================================================
+ *
+ * WATCH 记录键当时的版本号，EXEC 时版本号变化说明键在此期间被修改过，事务放弃执行。
+ *
+ * 只为被 WATCH 的键维护版本号（记录监视者数量，最后一个监视者取消后删除），
+ * 这样键不存在时也能被监视：键被创建后再删除，版本号同样会变化。
+ *
+ * 版本号在以下情况下递增：
+ * - 数据库层面的写操作：Set、Del、Unlink、设置/移除过期时间、过期删除、FlushDB
+ * - 命令原地修改对象（如 LPUSH 到已存在的列表）：由命令分发层调用 SignalModifiedKey
+ */
+
+// watchedKey 被监视键的版本信息
+type watchedKey struct {
+	version  uint64 // 版本号（每次修改递增）
+	watchers int    // 监视该键的客户端数量
+}
+
+// WatchKey 开始监视键，返回当前版本号
+func (db *RedisDb) WatchKey(key string) uint64 {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	wk, exists := db.watched[key]
+	if !exists {
+		wk = &watchedKey{}
+		db.watched[key] = wk
+	}
+	wk.watchers++
+	return wk.version
+}
+
+// UnwatchKey 取消监视键
+func (db *RedisDb) UnwatchKey(key string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	wk, exists := db.watched[key]
+	if !exists {
+		return
+	}
+	wk.watchers--
+	if wk.watchers <= 0 {
+		delete(db.watched, key)
+	}
+}
+
+// KeyVersion 获取被监视键的当前版本号（未被监视的键返回 0）
+func (db *RedisDb) KeyVersion(key string) uint64 {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if wk, exists := db.watched[key]; exists {
+		return wk.version
+	}
+	return 0
+}
+
+// SignalModifiedKey 标记键已被修改（命令原地修改对象后调用）
+func (db *RedisDb) SignalModifiedKey(key string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.touchWatchedKey(key)
+}
+
+// touchWatchedKey 递增被监视键的版本号（必须在锁内调用）
+func (db *RedisDb) touchWatchedKey(key string) {
+	if wk, exists := db.watched[key]; exists {
+		wk.version++
+	}
+}
+
+// touchAllWatchedKeys 递增所有被监视键的版本号（FlushDB，必须在锁内调用）
+func (db *RedisDb) touchAllWatchedKeys() {
+	for _, wk := range db.watched {
+		wk.version++
+	}
+}
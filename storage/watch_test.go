@@ -0,0 +1,40 @@
+package storage
+
+import "testing"
+
+// TestWatchKeyVersion 测试被监视键的版本号在写操作后变化
+func TestWatchKeyVersion(t *testing.T) {
+	db := NewRedisDb(0)
+
+	// 不存在的键也可以监视：创建后再删除，版本号仍然变化
+	v := db.WatchKey("k")
+	db.Set("k", NewStringObject([]byte("v")))
+	db.Del("k")
+	if db.KeyVersion("k") == v {
+		t.Fatal("Expected version to change after create and delete")
+	}
+
+	v = db.KeyVersion("k")
+	db.SignalModifiedKey("other")
+	if db.KeyVersion("k") != v {
+		t.Fatal("Modifying another key should not change the version")
+	}
+	db.SignalModifiedKey("k")
+	if db.KeyVersion("k") == v {
+		t.Fatal("Expected SignalModifiedKey to change the version")
+	}
+
+	v = db.KeyVersion("k")
+	db.FlushDB()
+	if db.KeyVersion("k") == v {
+		t.Fatal("Expected FLUSHDB to change the version of watched keys")
+	}
+
+	// 最后一个监视者取消后不再维护版本号
+	db.WatchKey("k")
+	db.UnwatchKey("k")
+	db.UnwatchKey("k")
+	if _, exists := db.watched["k"]; exists {
+		t.Fatal("Expected version tracking to stop after the last UnwatchKey")
+	}
+}
@@ -3,6 +3,9 @@ package structure
 import (
 	"bytes"
 	"errors"
+	"math"
+	"strconv"
+	"time"
 )
 
 /*
@@ -74,11 +77,17 @@ import (
 // HashEncoding 编码类型（使用 Encoding 别名）
 // 常量定义在 encoding.go 中
 
+// 默认阈值，运行时的值见 limits.go（hash-max-listpack-entries / hash-max-listpack-value）
 const (
 	HASH_MAX_LISTPACK_ENTRIES = 512 // 超过此数量转换为 dict
 	HASH_MAX_LISTPACK_VALUE   = 64  // 超过此大小转换为 dict
 )
 
+var (
+	ErrHashValueNotFloat = errors.New("hash value is not a float")
+	ErrIncrNaNOrInfinity = errors.New("increment would produce NaN or Infinity")
+)
+
 // HashEntry 哈希表条目
 type HashEntry struct {
 	field []byte
@@ -100,6 +109,8 @@ type RedisHash struct {
 	encoding  HashEncoding
 	listpack  *ListpackFull     // 小哈希表使用 ListpackFull（存储 field-value 对）
 	hashtable map[string][]byte // 大哈希表使用（简化实现，实际使用 dict）
+	scan      *ScanIndex        // hashtable 编码时 HSCAN 使用的字段桶索引（见 scan.go）
+	expires   map[string]int64  // 字段过期时间（Unix 毫秒），未设置过字段 TTL 时为 nil
 }
 
 // NewHash 创建新的 Redis Hash
@@ -111,8 +122,21 @@ func NewHash() *RedisHash {
 	}
 }
 
-// Set 设置字段值
+// Encoding 获取当前编码（listpack 或 hashtable）
+func (rh *RedisHash) Encoding() Encoding {
+	return rh.encoding
+}
+
+// Set 设置字段值，覆盖已有字段时同时移除字段的过期时间（与 Redis 的 HSET 一致）
 func (rh *RedisHash) Set(field, value []byte) error {
+	if rh.expires != nil {
+		delete(rh.expires, string(field))
+	}
+	return rh.setValue(field, value)
+}
+
+// setValue 设置字段值，保留字段的过期时间（HINCRBY/HINCRBYFLOAT 修改字段值时使用）
+func (rh *RedisHash) setValue(field, value []byte) error {
 	if rh.encoding == OBJ_ENCODING_LISTPACK {
 		return rh.setListpack(field, value)
 	} else {
@@ -127,9 +151,9 @@ func (rh *RedisHash) setListpack(field, value []byte) error {
 	}
 
 	// 检查是否需要转换（listpack 中 field-value 对算作 2 个元素）
-	if rh.listpack.Length()/2 >= HASH_MAX_LISTPACK_ENTRIES ||
-		len(field) > HASH_MAX_LISTPACK_VALUE ||
-		len(value) > HASH_MAX_LISTPACK_VALUE {
+	if int64(rh.listpack.Length()/2) >= hashMaxListpackEntries.Load() ||
+		int64(len(field)) > hashMaxListpackValue.Load() ||
+		int64(len(value)) > hashMaxListpackValue.Load() {
 		rh.convertToHashtable()
 		return rh.setHashtable(field, value)
 	}
@@ -158,7 +182,7 @@ func (rh *RedisHash) findFieldInListpack(field []byte) int {
 
 	idx := 0
 	p := rh.listpack.First()
-	for p != nil {
+	for p != 0 {
 		sval, _, isInt, err := rh.listpack.GetValue(p)
 		if err != nil {
 			break
@@ -169,7 +193,7 @@ func (rh *RedisHash) findFieldInListpack(field []byte) int {
 		}
 		var nextErr error
 		p, nextErr = rh.listpack.Next(p)
-		if nextErr != nil || p == nil {
+		if nextErr != nil || p == 0 {
 			break
 		}
 		idx++
@@ -187,7 +211,7 @@ func (rh *RedisHash) updateFieldValue(fieldIdx int, newValue []byte) {
 	idx := 0
 	var currentField []byte
 
-	for p != nil {
+	for p != 0 {
 		sval, _, _, err := rh.listpack.GetValue(p)
 		if err != nil {
 			break
@@ -214,7 +238,7 @@ func (rh *RedisHash) updateFieldValue(fieldIdx int, newValue []byte) {
 
 		var nextErr error
 		p, nextErr = rh.listpack.Next(p)
-		if nextErr != nil || p == nil {
+		if nextErr != nil || p == 0 {
 			break
 		}
 		idx++
@@ -232,18 +256,25 @@ func (rh *RedisHash) updateFieldValue(fieldIdx int, newValue []byte) {
 func (rh *RedisHash) setHashtable(field, value []byte) error {
 	if rh.hashtable == nil {
 		rh.hashtable = make(map[string][]byte)
+		rh.scan = NewScanIndex()
 	}
 
 	// 复制 value（避免外部修改）
 	valueCopy := make([]byte, len(value))
 	copy(valueCopy, value)
 
+	if _, exists := rh.hashtable[string(field)]; !exists {
+		rh.scan.Add(string(field))
+	}
 	rh.hashtable[string(field)] = valueCopy
 	return nil
 }
 
 // Get 获取字段值
 func (rh *RedisHash) Get(field []byte) ([]byte, bool) {
+	if rh.expireFieldIfNeeded(field) {
+		return nil, false
+	}
 	if rh.encoding == OBJ_ENCODING_LISTPACK {
 		return rh.getListpack(field)
 	} else {
@@ -266,7 +297,7 @@ func (rh *RedisHash) getListpack(field []byte) ([]byte, bool) {
 	// 需要找到 value
 	p := rh.listpack.First()
 	idx := 0
-	for p != nil && idx <= fieldIdx+1 {
+	for p != 0 && idx <= fieldIdx+1 {
 		if idx == fieldIdx+1 {
 			// 这是 value
 			sval, ival, isInt, err := rh.listpack.GetValue(p)
@@ -281,7 +312,7 @@ func (rh *RedisHash) getListpack(field []byte) ([]byte, bool) {
 		}
 		var err error
 		p, err = rh.listpack.Next(p)
-		if err != nil || p == nil {
+		if err != nil || p == 0 {
 			break
 		}
 		idx++
@@ -307,6 +338,9 @@ func (rh *RedisHash) getHashtable(field []byte) ([]byte, bool) {
 
 // Del 删除字段
 func (rh *RedisHash) Del(field []byte) error {
+	if rh.expires != nil {
+		delete(rh.expires, string(field))
+	}
 	if rh.encoding == OBJ_ENCODING_LISTPACK {
 		return rh.delListpack(field)
 	} else {
@@ -332,7 +366,7 @@ func (rh *RedisHash) delListpack(field []byte) error {
 	idx := 0
 	var currentField []byte
 
-	for p != nil {
+	for p != 0 {
 		sval, _, _, err := rh.listpack.GetValue(p)
 		if err != nil {
 			break
@@ -343,11 +377,13 @@ func (rh *RedisHash) delListpack(field []byte) error {
 			if idx == fieldIdx {
 				// 跳过这个 field 和它的 value
 				var nextErr error
-				p, nextErr = rh.listpack.Next(p)
-				if nextErr != nil || p == nil {
+				for skip := 0; skip < 2 && p != 0 && nextErr == nil; skip++ {
+					p, nextErr = rh.listpack.Next(p)
+				}
+				if nextErr != nil || p == 0 {
 					break
 				}
-				idx++ // 跳过 value
+				idx += 2
 				continue
 			}
 			currentField = sval
@@ -361,7 +397,7 @@ func (rh *RedisHash) delListpack(field []byte) error {
 
 		var nextErr error
 		p, nextErr = rh.listpack.Next(p)
-		if nextErr != nil || p == nil {
+		if nextErr != nil || p == 0 {
 			break
 		}
 		idx++
@@ -388,11 +424,15 @@ func (rh *RedisHash) delHashtable(field []byte) error {
 	}
 
 	delete(rh.hashtable, string(field))
+	rh.scan.Remove(string(field))
 	return nil
 }
 
 // Exists 检查字段是否存在
 func (rh *RedisHash) Exists(field []byte) bool {
+	if rh.expireFieldIfNeeded(field) {
+		return false
+	}
 	if rh.encoding == OBJ_ENCODING_LISTPACK {
 		return rh.findFieldInListpack(field) >= 0
 	} else {
@@ -404,6 +444,89 @@ func (rh *RedisHash) Exists(field []byte) bool {
 	}
 }
 
+// SetFieldExpire 设置字段的过期时间（Unix 毫秒），字段不存在时返回 false
+func (rh *RedisHash) SetFieldExpire(field []byte, expireAtMs int64) bool {
+	if !rh.Exists(field) {
+		return false
+	}
+	if rh.expires == nil {
+		rh.expires = make(map[string]int64)
+	}
+	rh.expires[string(field)] = expireAtMs
+	return true
+}
+
+// FieldExpireAt 获取字段的过期时间（Unix 毫秒），未设置 TTL 时返回 false
+func (rh *RedisHash) FieldExpireAt(field []byte) (int64, bool) {
+	if rh.expires == nil {
+		return 0, false
+	}
+	expireAt, ok := rh.expires[string(field)]
+	return expireAt, ok
+}
+
+// PersistField 移除字段的过期时间，原来设置了 TTL 时返回 true
+func (rh *RedisHash) PersistField(field []byte) bool {
+	if _, ok := rh.FieldExpireAt(field); !ok {
+		return false
+	}
+	delete(rh.expires, string(field))
+	return true
+}
+
+// HasFieldExpires 是否有字段设置了过期时间
+func (rh *RedisHash) HasFieldExpires() bool {
+	return len(rh.expires) > 0
+}
+
+// AllFieldsExpired 是否所有字段都设置了过期时间并且都已过期（now 为 Unix 毫秒）。
+// 只读取不删除字段，数据库在读锁下查找键时用它判断哈希表是否已经逻辑上为空
+func (rh *RedisHash) AllFieldsExpired(now int64) bool {
+	if len(rh.expires) == 0 || len(rh.expires) < rh.rawLen() {
+		return false
+	}
+	for _, expireAt := range rh.expires {
+		if now < expireAt {
+			return false
+		}
+	}
+	return true
+}
+
+// rawLen 字段数量（包括已过期但尚未删除的字段）
+func (rh *RedisHash) rawLen() int {
+	if rh.encoding == OBJ_ENCODING_LISTPACK {
+		if rh.listpack == nil {
+			return 0
+		}
+		return int(rh.listpack.Length()) / 2
+	}
+	return len(rh.hashtable)
+}
+
+// purgeExpiredFields 删除所有已过期的字段（没有设置字段 TTL 时不做任何事）
+func (rh *RedisHash) purgeExpiredFields() {
+	if len(rh.expires) == 0 {
+		return
+	}
+	now := time.Now().UnixMilli()
+	for field, expireAt := range rh.expires {
+		if now >= expireAt {
+			rh.Del([]byte(field))
+		}
+	}
+}
+
+// expireFieldIfNeeded 惰性删除已过期的字段，返回字段是否已过期
+func (rh *RedisHash) expireFieldIfNeeded(field []byte) bool {
+	expireAt, ok := rh.FieldExpireAt(field)
+	if !ok || time.Now().UnixMilli() < expireAt {
+		return false
+	}
+	rh.Del(field)
+	return true
+}
+
 // convertToHashtable 转换为 hashtable
 func (rh *RedisHash) convertToHashtable() {
 	if rh.encoding == OBJ_ENCODING_HT {
@@ -411,6 +534,7 @@ func (rh *RedisHash) convertToHashtable() {
 	}
 
 	rh.hashtable = make(map[string][]byte)
+	rh.scan = NewScanIndex()
 
 	// 将 listpack 中的所有字段添加到 hashtable
 	if rh.listpack != nil {
@@ -418,7 +542,7 @@ func (rh *RedisHash) convertToHashtable() {
 		idx := 0
 		var currentField []byte
 
-		for p != nil {
+		for p != 0 {
 			sval, _, _, err := rh.listpack.GetValue(p)
 			if err != nil {
 				break
@@ -432,11 +556,12 @@ func (rh *RedisHash) convertToHashtable() {
 				valueCopy := make([]byte, len(sval))
 				copy(valueCopy, sval)
 				rh.hashtable[string(currentField)] = valueCopy
+				rh.scan.Add(string(currentField))
 			}
 
 			var nextErr error
 			p, nextErr = rh.listpack.Next(p)
-			if nextErr != nil || p == nil {
+			if nextErr != nil || p == 0 {
 				break
 			}
 			idx++
@@ -449,6 +574,7 @@ func (rh *RedisHash) convertToHashtable() {
 
 // Len 获取字段数量
 func (rh *RedisHash) Len() int {
+	rh.purgeExpiredFields()
 	if rh.encoding == OBJ_ENCODING_LISTPACK {
 		if rh.listpack == nil {
 			return 0
@@ -462,6 +588,7 @@ func (rh *RedisHash) Len() int {
 
 // GetAll 获取所有字段值对
 func (rh *RedisHash) GetAll() []HashEntry {
+	rh.purgeExpiredFields()
 	if rh.encoding == OBJ_ENCODING_LISTPACK {
 		return rh.getAllListpack()
 	} else {
@@ -480,7 +607,7 @@ func (rh *RedisHash) getAllListpack() []HashEntry {
 	idx := 0
 	var currentField []byte
 
-	for p != nil {
+	for p != 0 {
 		sval, _, _, err := rh.listpack.GetValue(p)
 		if err != nil {
 			break
@@ -502,7 +629,7 @@ func (rh *RedisHash) getAllListpack() []HashEntry {
 
 		var nextErr error
 		p, nextErr = rh.listpack.Next(p)
-		if nextErr != nil || p == nil {
+		if nextErr != nil || p == 0 {
 			break
 		}
 		idx++
@@ -530,8 +657,34 @@ func (rh *RedisHash) getAllHashtable() []HashEntry {
 	return result
 }
 
+// Scan 按游标增量遍历字段（HSCAN），返回下一次的游标（0 表示遍历完成）。
+// listpack 编码一次访问全部字段；hashtable 编码使用桶索引（见 scan.go），每次访问 count 个左右的字段，
+// 从第一次调用到游标回到 0 期间一直存在的字段至少访问一次。已过期的字段不会访问。
+// visit 收到的 value 在下一次修改哈希表之前有效
+func (rh *RedisHash) Scan(cursor uint64, count int, visit func(field, value []byte)) uint64 {
+	if rh.encoding == OBJ_ENCODING_LISTPACK {
+		for _, entry := range rh.GetAll() {
+			visit(entry.field, entry.value)
+		}
+		return 0
+	}
+	if rh.hashtable == nil {
+		return 0
+	}
+
+	now := time.Now().UnixMilli()
+	return rh.scan.Scan(cursor, count, func(field string) {
+		// 遍历期间不能修改桶索引，已过期的字段跳过而不删除
+		if expireAt, ok := rh.expires[field]; ok && now >= expireAt {
+			return
+		}
+		visit([]byte(field), rh.hashtable[field])
+	})
+}
+
 // Keys 获取所有字段名
 func (rh *RedisHash) Keys() [][]byte {
+	rh.purgeExpiredFields()
 	if rh.encoding == OBJ_ENCODING_LISTPACK {
 		return rh.keysListpack()
 	} else {
@@ -549,7 +702,7 @@ func (rh *RedisHash) keysListpack() [][]byte {
 	p := rh.listpack.First()
 	idx := 0
 
-	for p != nil {
+	for p != 0 {
 		sval, _, isInt, err := rh.listpack.GetValue(p)
 		if err != nil {
 			break
@@ -564,7 +717,7 @@ func (rh *RedisHash) keysListpack() [][]byte {
 
 		var nextErr error
 		p, nextErr = rh.listpack.Next(p)
-		if nextErr != nil || p == nil {
+		if nextErr != nil || p == 0 {
 			break
 		}
 		idx++
@@ -588,6 +741,7 @@ func (rh *RedisHash) keysHashtable() [][]byte {
 
 // Values 获取所有字段值
 func (rh *RedisHash) Values() [][]byte {
+	rh.purgeExpiredFields()
 	if rh.encoding == OBJ_ENCODING_LISTPACK {
 		return rh.valuesListpack()
 	} else {
@@ -605,7 +759,7 @@ func (rh *RedisHash) valuesListpack() [][]byte {
 	p := rh.listpack.First()
 	idx := 0
 
-	for p != nil {
+	for p != 0 {
 		sval, _, _, err := rh.listpack.GetValue(p)
 		if err != nil {
 			break
@@ -620,7 +774,7 @@ func (rh *RedisHash) valuesListpack() [][]byte {
 
 		var nextErr error
 		p, nextErr = rh.listpack.Next(p)
-		if nextErr != nil || p == nil {
+		if nextErr != nil || p == 0 {
 			break
 		}
 		idx++
@@ -644,7 +798,7 @@ func (rh *RedisHash) valuesHashtable() [][]byte {
 	return result
 }
 
-// IncrBy 将字段值增加指定数值
+// IncrBy 将字段值增加指定数值（保留字段的过期时间）
 func (rh *RedisHash) IncrBy(field []byte, increment int64) (int64, error) {
 	value, exists := rh.Get(field)
 
@@ -661,7 +815,7 @@ func (rh *RedisHash) IncrBy(field []byte, increment int64) (int64, error) {
 	newVal := currentVal + increment
 	newValBytes := rh.intToBytes(newVal)
 
-	err := rh.Set(field, newValBytes)
+	err := rh.setValue(field, newValBytes)
 	if err != nil {
 		return 0, err
 	}
@@ -669,7 +823,7 @@ func (rh *RedisHash) IncrBy(field []byte, increment int64) (int64, error) {
 	return newVal, nil
 }
 
-// IncrByFloat 将字段值增加指定浮点数
+// IncrByFloat 将字段值增加指定浮点数（保留字段的过期时间）
 func (rh *RedisHash) IncrByFloat(field []byte, increment float64) (float64, error) {
 	value, exists := rh.Get(field)
 
@@ -678,15 +832,18 @@ func (rh *RedisHash) IncrByFloat(field []byte, increment float64) (float64, erro
 		// 尝试解析为浮点数
 		parsed, err := rh.parseFloat(value)
 		if err != nil {
-			return 0, errors.New("value is not a number")
+			return 0, ErrHashValueNotFloat
 		}
 		currentVal = parsed
 	}
 
 	newVal := currentVal + increment
+	if math.IsNaN(newVal) || math.IsInf(newVal, 0) {
+		return 0, ErrIncrNaNOrInfinity
+	}
 	newValBytes := rh.floatToBytes(newVal)
 
-	err := rh.Set(field, newValBytes)
+	err := rh.setValue(field, newValBytes)
 	if err != nil {
 		return 0, err
 	}
@@ -729,17 +886,13 @@ func (rh *RedisHash) parseInt(data []byte) (int64, error) {
 	return result, nil
 }
 
-// parseFloat 解析浮点数（简化实现）
+// parseFloat 解析浮点数（不接受 NaN 和无穷大）
 func (rh *RedisHash) parseFloat(data []byte) (float64, error) {
-	// 简化实现：先尝试解析为整数
-	val, err := rh.parseInt(data)
-	if err == nil {
-		return float64(val), nil
+	val, err := strconv.ParseFloat(string(data), 64)
+	if err != nil || math.IsNaN(val) || math.IsInf(val, 0) {
+		return 0, ErrHashValueNotFloat
 	}
-
-	// 实际应该使用 strconv.ParseFloat
-	// 这里简化处理
-	return 0, errors.New("invalid float")
+	return val, nil
 }
 
 // intToBytes 整数转字节数组（辅助函数，用于从 listpack 获取整数时转换）
@@ -778,10 +931,9 @@ func (rh *RedisHash) intToBytes(val int64) []byte {
 	return result
 }
 
-// floatToBytes 浮点数转字节数组（简化实现）
+// floatToBytes 浮点数转字节数组
 func (rh *RedisHash) floatToBytes(val float64) []byte {
-	// 简化实现：转换为整数
-	return rh.intToBytes(int64(val))
+	return []byte(strconv.FormatFloat(val, 'f', -1, 64))
 }
 
 // MSet 批量设置字段
@@ -0,0 +1,105 @@
+package structure
+
+import "sync/atomic"
+
+/*
+ * ============================================================================
+ * 编码转换阈值
+ * ============================================================================
+ *
+ * 小对象使用紧凑编码（listpack、intset），超过阈值后转换为 hashtable/quicklist。
+ * 阈值对应 Redis 的同名配置参数，默认值为各结构中定义的常量，
+ * 服务器启动和 CONFIG SET 时通过 SetEncodingLimit 修改，对之后的写入生效
+ * （已经转换的对象不会因为调大阈值而转换回紧凑编码，与 Redis 一致）。
+ *
+ * 【list-max-listpack-size】
+ * 与 Redis 相同：
+ * - 正数：listpack 最多包含的元素个数
+ * - 负数：listpack 的字节数上限，-1 ~ -5 分别为 4KB、8KB、16KB、32KB、64KB
+ *
+ * 【quicklist packed threshold】
+ * 超过此大小的列表元素不写入 listpack，单独存放在 quicklist 的 PLAIN 节点中。
+ * 与 Redis 相同不是配置参数，只能通过 DEBUG QUICKLIST-PACKED-THRESHOLD 修改。
+ */
+
+var (
+	hashMaxListpackEntries atomic.Int64
+	hashMaxListpackValue   atomic.Int64
+	listMaxListpackSize    atomic.Int64
+	setMaxIntsetEntries    atomic.Int64
+	zsetMaxListpackEntries atomic.Int64
+	zsetMaxListpackValue   atomic.Int64
+
+	quicklistPackedThreshold atomic.Int64
+)
+
+// encodingLimits 配置参数名 -> 阈值
+var encodingLimits = map[string]*atomic.Int64{
+	"hash-max-listpack-entries": &hashMaxListpackEntries,
+	"hash-max-listpack-value":   &hashMaxListpackValue,
+	"list-max-listpack-size":    &listMaxListpackSize,
+	"set-max-intset-entries":    &setMaxIntsetEntries,
+	"zset-max-listpack-entries": &zsetMaxListpackEntries,
+	"zset-max-listpack-value":   &zsetMaxListpackValue,
+}
+
+func init() {
+	hashMaxListpackEntries.Store(HASH_MAX_LISTPACK_ENTRIES)
+	hashMaxListpackValue.Store(HASH_MAX_LISTPACK_VALUE)
+	listMaxListpackSize.Store(-2) // 8KB，即 LIST_MAX_LISTPACK_SIZE
+	setMaxIntsetEntries.Store(SET_MAX_INTSET_ENTRIES)
+	zsetMaxListpackEntries.Store(ZSET_MAX_LISTPACK_ENTRIES)
+	zsetMaxListpackValue.Store(ZSET_MAX_LISTPACK_VALUE)
+	quicklistPackedThreshold.Store(QUICKLIST_PACKED_THRESHOLD)
+}
+
+// SetEncodingLimit 设置编码转换阈值，name 不是阈值参数时返回 false
+func SetEncodingLimit(name string, value int64) bool {
+	limit, ok := encodingLimits[name]
+	if !ok {
+		return false
+	}
+	limit.Store(value)
+	return true
+}
+
+// IsEncodingLimit name 是否为编码转换阈值参数
+func IsEncodingLimit(name string) bool {
+	_, ok := encodingLimits[name]
+	return ok
+}
+
+// listpackExceedsListLimit 列表的 listpack 是否超过 list-max-listpack-size
+func listpackExceedsListLimit(size, count int) bool {
+	fill := listMaxListpackSize.Load()
+	if fill > 0 {
+		return int64(count) > fill
+	}
+
+	// 负数按字节数限制，元素个数仍受 LIST_MAX_LISTPACK_ENTRIES 约束
+	level := -fill - 1
+	if level < 0 {
+		level = 0
+	} else if level > 4 {
+		level = 4
+	}
+	return size > 4096<<level || count > LIST_MAX_LISTPACK_ENTRIES
+}
+
+// SetQuicklistPackedThreshold 设置 PLAIN 节点的大小阈值，0 表示恢复默认值；
+// 与 Redis 相同不允许接近 4GB，超出范围时返回 false
+func SetQuicklistPackedThreshold(size int64) bool {
+	if size < 0 || size > 1<<32-1<<20 {
+		return false
+	}
+	if size == 0 {
+		size = QUICKLIST_PACKED_THRESHOLD
+	}
+	quicklistPackedThreshold.Store(size)
+	return true
+}
+
+// isLargeListElement 列表元素是否超过 PLAIN 节点阈值
+func isLargeListElement(size int) bool {
+	return int64(size) > quicklistPackedThreshold.Load()
+}
@@ -74,12 +74,23 @@ import (
 // ListEncoding 编码类型（使用 Encoding 别名）
 // 常量定义在 encoding.go 中
 
+// LIST_MAX_LISTPACK_SIZE 对应 list-max-listpack-size 的默认值 -2，运行时的值见 limits.go
 const (
 	LIST_MAX_LISTPACK_SIZE    = 8192 // 8KB，超过此大小转换为 quicklist
 	LIST_MAX_LISTPACK_ENTRIES = 512  // 超过此元素数转换为 quicklist
 	LIST_MIN_QUICKLIST_SIZE   = 4096 // 4KB，quicklist 转换回 listpack 的阈值
 )
 
+// QUICKLIST_PACKED_THRESHOLD 超过此大小的元素单独存放在 PLAIN 节点中（与 Redis 相同为 1GB），
+// 运行时的值见 limits.go，可以通过 DEBUG QUICKLIST-PACKED-THRESHOLD 修改
+const QUICKLIST_PACKED_THRESHOLD = 1 << 30
+
+// quicklist 节点的容器类型
+const (
+	QUICKLIST_NODE_CONTAINER_PLAIN  = 1 // entry 就是单个元素本身
+	QUICKLIST_NODE_CONTAINER_PACKED = 2 // entry 是 listpack
+)
+
 // QuicklistNode quicklist 节点
 type QuicklistNode struct {
 	prev      *QuicklistNode
@@ -118,6 +129,11 @@ func NewList() *RedisList {
 	}
 }
 
+// Encoding 获取当前编码（listpack 或 quicklist）
+func (rl *RedisList) Encoding() Encoding {
+	return rl.encoding
+}
+
 // Len 获取列表长度
 func (rl *RedisList) Len() int {
 	if rl.encoding == OBJ_ENCODING_LISTPACK {
@@ -130,6 +146,11 @@ func (rl *RedisList) Len() int {
 // Push 向列表添加元素（头部或尾部）
 // where: 0 = HEAD, 1 = TAIL
 func (rl *RedisList) Push(value []byte, where int) {
+	// 大元素不写入 listpack：先转换为 quicklist，再单独存放在 PLAIN 节点中
+	if rl.encoding == OBJ_ENCODING_LISTPACK && isLargeListElement(len(value)) {
+		rl.convertToQuicklist()
+	}
+
 	if rl.encoding == OBJ_ENCODING_LISTPACK {
 		rl.pushListpack(value, where)
 		// 检查是否需要转换为 quicklist
@@ -171,7 +192,7 @@ func (rl *RedisList) insertAtHead(value []byte, intVal int64) {
 
 	p := rl.listpack.First()
 	idx := 0
-	for p != nil {
+	for p != 0 {
 		sval, ival, isInt, _ := rl.listpack.GetValue(p)
 		if isInt {
 			oldInts = append(oldInts, ival)
@@ -181,7 +202,7 @@ func (rl *RedisList) insertAtHead(value []byte, intVal int64) {
 		}
 		var err error
 		p, err = rl.listpack.Next(p)
-		if err != nil || p == nil {
+		if err != nil || p == 0 {
 			break
 		}
 		idx++
@@ -258,6 +279,13 @@ func (rl *RedisList) pushQuicklist(value []byte, where int) {
 		}
 	}
 
+	// 超过阈值的大元素单独存放在 PLAIN 节点中
+	if isLargeListElement(len(value)) {
+		rl.pushPlainNode(value, where)
+		rl.quicklist.count++
+		return
+	}
+
 	var targetNode *QuicklistNode
 	if where == 0 { // HEAD
 		targetNode = rl.quicklist.head
@@ -265,18 +293,15 @@ func (rl *RedisList) pushQuicklist(value []byte, where int) {
 		targetNode = rl.quicklist.tail
 	}
 
-	// 如果节点不存在，创建新节点
-	if targetNode == nil {
-		node := rl.newQuicklistNode()
-		rl.quicklist.head = node
-		rl.quicklist.tail = node
-		rl.quicklist.len = 1
-		targetNode = node
+	// 节点不存在或者是 PLAIN 节点时，在这一端创建新节点
+	if targetNode == nil || targetNode.isPlain() {
+		targetNode = rl.newQuicklistNode()
+		rl.linkQuicklistNode(targetNode, where)
 	}
 
-	// 如果节点的 listpack 为空，初始化
-	if targetNode.listpack == nil {
-		targetNode.listpack = NewListpackFull(256)
+	// 确保节点的 listpack 对象存在（entry 损坏时不写入，避免覆盖已有数据）
+	if err := targetNode.ensureListpack(); err != nil {
+		return
 	}
 
 	// 尝试解析为整数
@@ -302,11 +327,7 @@ func (rl *RedisList) pushQuicklist(value []byte, where int) {
 
 	// 检查是否需要创建新节点（尾部插入时）
 	if where == 1 && targetNode.count >= uint16(rl.quicklist.fill) {
-		newNode := rl.newQuicklistNode()
-		rl.quicklist.tail.next = newNode
-		newNode.prev = rl.quicklist.tail
-		rl.quicklist.tail = newNode
-		rl.quicklist.len++
+		rl.linkQuicklistNode(rl.newQuicklistNode(), 1)
 	}
 
 	rl.quicklist.count++
@@ -316,34 +337,113 @@ func (rl *RedisList) pushQuicklist(value []byte, where int) {
 func (rl *RedisList) newQuicklistNode() *QuicklistNode {
 	return &QuicklistNode{
 		entry:     make([]byte, 0),
-		container: 2, // PACKED (listpack)
+		container: QUICKLIST_NODE_CONTAINER_PACKED,
 		encoding:  1, // RAW
 		listpack:  NewListpackFull(256),
 	}
 }
 
-// insertAtQuicklistHead 在 quicklist 节点头部插入（需要重建 listpack）
-func (rl *RedisList) insertAtQuicklistHead(node *QuicklistNode, value []byte, intVal int64) {
-	if node.listpack == nil {
+// pushPlainNode 在头部或尾部新建 PLAIN 节点存放单个大元素，这一端预先创建的空节点先摘除
+func (rl *RedisList) pushPlainNode(value []byte, where int) {
+	end := rl.quicklist.tail
+	if where == 0 {
+		end = rl.quicklist.head
+	}
+	if end != nil && end.count == 0 {
+		rl.removeQuicklistNode(end)
+	}
+
+	entry := make([]byte, len(value))
+	copy(entry, value)
+	rl.linkQuicklistNode(&QuicklistNode{
+		entry:     entry,
+		sz:        uint32(len(entry)),
+		count:     1,
+		encoding:  1, // RAW
+		container: QUICKLIST_NODE_CONTAINER_PLAIN,
+	}, where)
+}
+
+// isPlain 节点是否为只存放单个元素的 PLAIN 节点
+func (node *QuicklistNode) isPlain() bool {
+	return node.container == QUICKLIST_NODE_CONTAINER_PLAIN
+}
+
+// ensureListpack 确保节点的 listpack 对象存在，不存在时从 entry 的二进制数据重建
+func (node *QuicklistNode) ensureListpack() error {
+	if node.listpack != nil {
+		return nil
+	}
+	if node.isPlain() {
+		return errors.New("plain quicklist node has no listpack")
+	}
+	if len(node.entry) == 0 {
 		node.listpack = NewListpackFull(256)
+		return nil
 	}
 
-	// 收集所有现有元素
+	lp, err := NewListpackFromBytes(node.entry)
+	if err != nil {
+		return err
+	}
+	node.listpack = lp
+	return nil
+}
+
+// linkQuicklistNode 把节点链接到 quicklist 的头部（where=0）或尾部（where=1）
+func (rl *RedisList) linkQuicklistNode(node *QuicklistNode, where int) {
+	ql := rl.quicklist
+	if ql.head == nil {
+		ql.head = node
+		ql.tail = node
+	} else if where == 0 {
+		node.next = ql.head
+		ql.head.prev = node
+		ql.head = node
+	} else {
+		node.prev = ql.tail
+		ql.tail.next = node
+		ql.tail = node
+	}
+	ql.len++
+}
+
+// removeQuicklistNode 从 quicklist 中摘除节点
+func (rl *RedisList) removeQuicklistNode(node *QuicklistNode) {
+	if node.prev != nil {
+		node.prev.next = node.next
+	} else {
+		rl.quicklist.head = node.next
+	}
+	if node.next != nil {
+		node.next.prev = node.prev
+	} else {
+		rl.quicklist.tail = node.prev
+	}
+	node.prev = nil
+	node.next = nil
+	rl.quicklist.len--
+}
+
+// insertAtQuicklistHead 在 quicklist 节点头部插入（需要重建 listpack）
+func (rl *RedisList) insertAtQuicklistHead(node *QuicklistNode, value []byte, intVal int64) {
+	// 收集所有现有元素（oldInts 与 oldEntries 按下标一一对应）
 	oldEntries := make([][]byte, 0, node.listpack.Length())
 	oldInts := make([]int64, 0, node.listpack.Length())
 
 	p := node.listpack.First()
-	for p != nil {
+	for p != 0 {
 		sval, ival, isInt, _ := node.listpack.GetValue(p)
 		if isInt {
 			oldInts = append(oldInts, ival)
 			oldEntries = append(oldEntries, nil) // 标记为整数
 		} else {
+			oldInts = append(oldInts, 0)
 			oldEntries = append(oldEntries, sval)
 		}
 		var err error
 		p, err = node.listpack.Next(p)
-		if err != nil || p == nil {
+		if err != nil || p == 0 {
 			break
 		}
 	}
@@ -390,7 +490,7 @@ func (rl *RedisList) popListpack(where int) ([]byte, error) {
 
 	if where == 0 { // HEAD
 		p := rl.listpack.First()
-		if p == nil {
+		if p == 0 {
 			return nil, errors.New("list is empty")
 		}
 		var err error
@@ -401,16 +501,16 @@ func (rl *RedisList) popListpack(where int) ([]byte, error) {
 	} else { // TAIL
 		// 找到最后一个元素
 		p := rl.listpack.First()
-		var lastP []byte
-		for p != nil {
+		var lastP int
+		for p != 0 {
 			lastP = p
 			var err error
 			p, err = rl.listpack.Next(p)
-			if err != nil || p == nil {
+			if err != nil || p == 0 {
 				break
 			}
 		}
-		if lastP == nil {
+		if lastP == 0 {
 			return nil, errors.New("list is empty")
 		}
 		var err error
@@ -426,7 +526,7 @@ func (rl *RedisList) popListpack(where int) ([]byte, error) {
 
 	p := rl.listpack.First()
 	idx := 0
-	for p != nil {
+	for p != 0 {
 		sval, ival, entryIsInt, _ := rl.listpack.GetValue(p)
 
 		// 检查是否是要删除的元素
@@ -439,23 +539,26 @@ func (rl *RedisList) popListpack(where int) ([]byte, error) {
 		} else {
 			// TAIL - 跳过最后一个
 			next, _ := rl.listpack.Next(p)
-			if next == nil {
+			if next == 0 {
 				shouldSkip = true
 			}
 		}
 
 		if !shouldSkip {
+			// oldInts 与 oldEntries 按下标一一对应
 			if entryIsInt {
 				oldInts = append(oldInts, ival)
 				oldEntries = append(oldEntries, nil)
 			} else {
+				oldInts = append(oldInts, 0)
 				oldEntries = append(oldEntries, sval)
 			}
 		}
+		idx++
 
 		var err error
 		p, err = rl.listpack.Next(p)
-		if err != nil || p == nil {
+		if err != nil || p == 0 {
 			break
 		}
 	}
@@ -527,17 +630,31 @@ func (rl *RedisList) popQuicklist(where int) ([]byte, error) {
 		node = rl.quicklist.tail
 	}
 
+	// 尾部插入时会预先创建空节点，弹出时跳过
+	if node != nil && node.count == 0 && rl.quicklist.len > 1 {
+		rl.removeQuicklistNode(node)
+		if where == 0 {
+			node = rl.quicklist.head
+		} else {
+			node = rl.quicklist.tail
+		}
+	}
+
 	if node == nil || node.count == 0 {
 		return nil, errors.New("node is empty")
 	}
 
+	// PLAIN 节点只有一个元素，弹出后整个节点摘除
+	if node.isPlain() {
+		rl.removeQuicklistNode(node)
+		rl.quicklist.count--
+		rl.tryConvertToListpack()
+		return node.entry, nil
+	}
+
 	// 确保 listpack 对象存在
-	if node.listpack == nil {
-		// 从 entry 重建 listpack
-		node.listpack = NewListpackFull(256)
-		// 这里需要从 entry 解析，简化处理：直接使用 popListpack 的逻辑
-		// 实际应该解析 entry 的二进制数据
-		return nil, errors.New("node listpack not initialized")
+	if err := node.ensureListpack(); err != nil {
+		return nil, err
 	}
 
 	// 使用 listpack 的方法删除元素（需要重建）
@@ -547,7 +664,7 @@ func (rl *RedisList) popQuicklist(where int) ([]byte, error) {
 
 	if where == 0 { // HEAD
 		p := node.listpack.First()
-		if p == nil {
+		if p == 0 {
 			return nil, errors.New("node is empty")
 		}
 		var err error
@@ -558,16 +675,16 @@ func (rl *RedisList) popQuicklist(where int) ([]byte, error) {
 	} else { // TAIL
 		// 找到最后一个元素
 		p := node.listpack.First()
-		var lastP []byte
-		for p != nil {
+		var lastP int
+		for p != 0 {
 			lastP = p
 			var err error
 			p, err = node.listpack.Next(p)
-			if err != nil || p == nil {
+			if err != nil || p == 0 {
 				break
 			}
 		}
-		if lastP == nil {
+		if lastP == 0 {
 			return nil, errors.New("node is empty")
 		}
 		var err error
@@ -583,7 +700,7 @@ func (rl *RedisList) popQuicklist(where int) ([]byte, error) {
 
 	p := node.listpack.First()
 	idx := 0
-	for p != nil {
+	for p != 0 {
 		sval, ival, entryIsInt, _ := node.listpack.GetValue(p)
 
 		// 检查是否是要删除的元素
@@ -596,23 +713,25 @@ func (rl *RedisList) popQuicklist(where int) ([]byte, error) {
 		} else {
 			// TAIL - 跳过最后一个
 			next, _ := node.listpack.Next(p)
-			if next == nil {
+			if next == 0 {
 				shouldSkip = true
 			}
 		}
 
 		if !shouldSkip {
+			// oldInts 与 oldEntries 按下标一一对应
 			if entryIsInt {
 				oldInts = append(oldInts, ival)
 				oldEntries = append(oldEntries, nil)
 			} else {
+				oldInts = append(oldInts, 0)
 				oldEntries = append(oldEntries, sval)
 			}
 		}
 
 		var err error
 		p, err = node.listpack.Next(p)
-		if err != nil || p == nil {
+		if err != nil || p == 0 {
 			break
 		}
 		idx++
@@ -636,18 +755,7 @@ func (rl *RedisList) popQuicklist(where int) ([]byte, error) {
 
 	// 如果节点为空，删除节点
 	if node.count == 0 && rl.quicklist.len > 1 {
-		if where == 0 {
-			rl.quicklist.head = node.next
-			if rl.quicklist.head != nil {
-				rl.quicklist.head.prev = nil
-			}
-		} else {
-			rl.quicklist.tail = node.prev
-			if rl.quicklist.tail != nil {
-				rl.quicklist.tail.next = nil
-			}
-		}
-		rl.quicklist.len--
+		rl.removeQuicklistNode(node)
 	}
 
 	// 检查是否需要转换回 listpack
@@ -674,36 +782,46 @@ func (rl *RedisList) tryConvertToQuicklist() {
 	currentSize := len(rl.listpack.Bytes())
 	currentCount := int(rl.listpack.Length())
 
-	if currentSize > LIST_MAX_LISTPACK_SIZE || currentCount > LIST_MAX_LISTPACK_ENTRIES {
-		// 转换为 quicklist
-		ql := &Quicklist{
-			head:      nil,
-			tail:      nil,
-			count:     uint64(currentCount),
-			len:       0,
-			allocSize: uint64(currentSize),
-			fill:      16,
-			compress:  0,
-		}
-
-		// 创建节点并复制 listpack 数据
-		node := &QuicklistNode{
-			entry:     rl.listpack.Bytes(),
-			container: 2, // PACKED
-			count:     rl.listpack.Length(),
-			sz:        uint32(currentSize),
-			encoding:  1,           // RAW
-			listpack:  rl.listpack, // 保留引用
-		}
-
-		ql.head = node
-		ql.tail = node
-		ql.len = 1
+	if listpackExceedsListLimit(currentSize, currentCount) {
+		rl.convertToQuicklist()
+	}
+}
 
-		rl.quicklist = ql
-		rl.encoding = OBJ_ENCODING_QUICKLIST
-		rl.listpack = nil // 不再直接使用，由 quicklist 节点持有
+// convertToQuicklist 把 listpack 转换为只有一个节点的 quicklist
+func (rl *RedisList) convertToQuicklist() {
+	if rl.listpack == nil {
+		rl.listpack = NewListpackFull(256)
 	}
+	currentSize := len(rl.listpack.Bytes())
+	currentCount := int(rl.listpack.Length())
+
+	ql := &Quicklist{
+		head:      nil,
+		tail:      nil,
+		count:     uint64(currentCount),
+		len:       0,
+		allocSize: uint64(currentSize),
+		fill:      16,
+		compress:  0,
+	}
+
+	// 创建节点并复制 listpack 数据
+	node := &QuicklistNode{
+		entry:     rl.listpack.Bytes(),
+		container: QUICKLIST_NODE_CONTAINER_PACKED,
+		count:     rl.listpack.Length(),
+		sz:        uint32(currentSize),
+		encoding:  1,           // RAW
+		listpack:  rl.listpack, // 保留引用
+	}
+
+	ql.head = node
+	ql.tail = node
+	ql.len = 1
+
+	rl.quicklist = ql
+	rl.encoding = OBJ_ENCODING_QUICKLIST
+	rl.listpack = nil // 不再直接使用，由 quicklist 节点持有
 }
 
 // tryConvertToListpack 尝试转换为 listpack
@@ -712,22 +830,24 @@ func (rl *RedisList) tryConvertToListpack() {
 		return
 	}
 
-	// 只有当 quicklist 只有一个节点时才考虑转换
-	if rl.quicklist.len == 1 && rl.quicklist.head != nil {
-		// 检查大小是否足够小
-		if rl.quicklist.head.sz < LIST_MIN_QUICKLIST_SIZE {
-			// 转换回 listpack
-			if rl.quicklist.head.listpack != nil {
-				rl.listpack = rl.quicklist.head.listpack
-			} else {
-				// 从 entry 重建 listpack（简化处理）
-				rl.listpack = NewListpackFull(256)
-				// 实际应该解析 entry 的二进制数据
-			}
-			rl.encoding = OBJ_ENCODING_LISTPACK
-			rl.quicklist = nil
-		}
+	// 只有当 quicklist 只有一个 PACKED 节点时才考虑转换
+	head := rl.quicklist.head
+	if rl.quicklist.len != 1 || head == nil || head.isPlain() {
+		return
+	}
+
+	// 检查大小是否足够小（同时不能超过 listpack 的阈值，否则下次写入又会转换回来）
+	if head.sz >= LIST_MIN_QUICKLIST_SIZE || listpackExceedsListLimit(int(head.sz), int(head.count)) {
+		return
 	}
+
+	// 转换回 listpack，listpack 对象不存在时从 entry 重建；entry 无法解析时保持 quicklist 编码
+	if err := head.ensureListpack(); err != nil {
+		return
+	}
+	rl.listpack = head.listpack
+	rl.encoding = OBJ_ENCODING_LISTPACK
+	rl.quicklist = nil
 }
 
 // Range 获取列表指定范围的元素
@@ -767,10 +887,13 @@ func (rl *RedisList) rangeListpack(start, end int) ([][]byte, error) {
 	}
 
 	result := make([][]byte, 0, end-start+1)
-	p := rl.listpack.First()
-	idx := 0
+	p, err := rl.listpack.Seek(start)
+	if err != nil {
+		return nil, err
+	}
+	idx := start
 
-	for p != nil && idx <= end {
+	for p != 0 && idx <= end {
 		if idx >= start {
 			sval, ival, isInt, err := rl.listpack.GetValue(p)
 			if err != nil {
@@ -782,9 +905,8 @@ func (rl *RedisList) rangeListpack(start, end int) ([][]byte, error) {
 				result = append(result, sval)
 			}
 		}
-		var err error
 		p, err = rl.listpack.Next(p)
-		if err != nil || p == nil {
+		if err != nil || p == 0 {
 			break
 		}
 		idx++
@@ -821,18 +943,39 @@ func (rl *RedisList) rangeQuicklist(start, end int) ([][]byte, error) {
 	currentIndex := 0
 
 	for current != nil && currentIndex <= end {
-		// 确保 listpack 对象存在
-		if current.listpack == nil {
-			// 从 entry 重建（简化处理）
-			current.listpack = NewListpackFull(256)
-			// 实际应该解析 entry 的二进制数据
+		// 整个节点都在 start 之前时直接跳过
+		if currentIndex+int(current.count) <= start {
+			currentIndex += int(current.count)
 			current = current.next
 			continue
 		}
 
-		// 遍历当前节点的 listpack
-		p := current.listpack.First()
-		for p != nil && currentIndex <= end {
+		// PLAIN 节点只有一个元素
+		if current.isPlain() {
+			value := make([]byte, len(current.entry))
+			copy(value, current.entry)
+			result = append(result, value)
+			currentIndex++
+			current = current.next
+			continue
+		}
+
+		// 确保 listpack 对象存在
+		if err := current.ensureListpack(); err != nil {
+			return nil, err
+		}
+
+		// 遍历当前节点的 listpack（从 start 所在的位置开始）
+		skip := 0
+		if currentIndex < start {
+			skip = start - currentIndex
+		}
+		p, err := current.listpack.Seek(skip)
+		if err != nil {
+			return nil, err
+		}
+		currentIndex += skip
+		for p != 0 && currentIndex <= end {
 			if currentIndex >= start {
 				sval, ival, isInt, err := current.listpack.GetValue(p)
 				if err != nil {
@@ -844,12 +987,12 @@ func (rl *RedisList) rangeQuicklist(start, end int) ([][]byte, error) {
 					result = append(result, sval)
 				}
 			}
-			var err error
+			currentIndex++
+
 			p, err = current.listpack.Next(p)
-			if err != nil || p == nil {
-				break
+			if err != nil {
+				return nil, err
 			}
-			currentIndex++
 		}
 
 		current = current.next
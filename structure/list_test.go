@@ -0,0 +1,206 @@
+package structure
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// dropNodeListpacks 丢弃 quicklist 各节点的 listpack 对象，只保留 entry 二进制数据
+func dropNodeListpacks(rl *RedisList) {
+	for node := rl.quicklist.head; node != nil; node = node.next {
+		node.listpack = nil
+	}
+}
+
+// assertListElements 检查列表的全部元素
+func assertListElements(t *testing.T, rl *RedisList, want []string) {
+	t.Helper()
+	if rl.Len() != len(want) {
+		t.Fatalf("Expected length %d, got %d", len(want), rl.Len())
+	}
+	got, err := rl.Range(0, -1)
+	if err != nil {
+		t.Fatalf("Range failed: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d elements, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if string(got[i]) != want[i] {
+			t.Fatalf("Element %d: expected %q, got %q", i, want[i], got[i])
+		}
+	}
+}
+
+// TestListQuicklistToListpack 测试列表转换为 quicklist 后缩小到阈值以下，
+// 即使节点只保留 entry 二进制数据，转换回 listpack 时也不丢失元素
+func TestListQuicklistToListpack(t *testing.T) {
+	rl := NewList()
+	var want []string
+
+	// 整数和字符串交替，分别覆盖两种编码
+	value := func(i int) string {
+		if i%2 == 0 {
+			return strconv.Itoa(i * 1000)
+		}
+		return "value:" + strconv.Itoa(i)
+	}
+	for i := 0; i < 600; i++ {
+		rl.Push([]byte(value(i)), 1)
+		want = append(want, value(i))
+	}
+	for i := 600; i < 620; i++ {
+		rl.Push([]byte(value(i)), 0)
+		want = append([]string{value(i)}, want...)
+	}
+	if rl.Encoding() != OBJ_ENCODING_QUICKLIST {
+		t.Fatalf("Expected quicklist encoding, got %d", rl.Encoding())
+	}
+
+	dropNodeListpacks(rl)
+	assertListElements(t, rl, want)
+
+	// 跨节点的子范围
+	got, err := rl.Range(510, 530)
+	if err != nil {
+		t.Fatalf("Range failed: %v", err)
+	}
+	for i := range got {
+		if string(got[i]) != want[510+i] {
+			t.Fatalf("Range element %d: expected %q, got %q", 510+i, want[510+i], got[i])
+		}
+	}
+
+	// 两端交替弹出，直到转换回 listpack
+	for pops := 0; rl.Encoding() == OBJ_ENCODING_QUICKLIST; pops++ {
+		if pops > 600 {
+			t.Fatal("List never converted back to listpack")
+		}
+		dropNodeListpacks(rl)
+
+		where := pops % 2
+		popped, err := rl.Pop(where)
+		if err != nil {
+			t.Fatalf("Pop failed: %v", err)
+		}
+		var expected string
+		if where == 0 {
+			expected, want = want[0], want[1:]
+		} else {
+			expected, want = want[len(want)-1], want[:len(want)-1]
+		}
+		if string(popped) != expected {
+			t.Fatalf("Expected popped %q, got %q", expected, popped)
+		}
+	}
+
+	if rl.Len() > LIST_MAX_LISTPACK_ENTRIES {
+		t.Fatalf("Expected at most %d elements after conversion, got %d", LIST_MAX_LISTPACK_ENTRIES, rl.Len())
+	}
+	assertListElements(t, rl, want)
+
+	// 转换后的 listpack 可以继续写入
+	rl.Push([]byte("tail"), 1)
+	want = append(want, "tail")
+	assertListElements(t, rl, want)
+}
+
+// TestNewListpackFromBytes 测试从二进制数据重建 listpack 以及对损坏数据的校验
+func TestNewListpackFromBytes(t *testing.T) {
+	lp := NewListpackFull(16)
+	lp.AppendInteger(-5000)
+	lp.AppendString([]byte("hello"))
+	lp.AppendString(make([]byte, 300))
+
+	rebuilt, err := NewListpackFromBytes(lp.Bytes())
+	if err != nil {
+		t.Fatalf("NewListpackFromBytes failed: %v", err)
+	}
+	if rebuilt.Length() != 3 {
+		t.Fatalf("Expected 3 elements, got %d", rebuilt.Length())
+	}
+	_, ival, isInt, _ := rebuilt.Get(0)
+	if !isInt || ival != -5000 {
+		t.Fatalf("Expected integer -5000, got %d (isInt=%v)", ival, isInt)
+	}
+	sval, _, _, _ := rebuilt.Get(1)
+	if string(sval) != "hello" {
+		t.Fatalf("Expected hello, got %q", sval)
+	}
+
+	// 重建的 listpack 不与原数据共享内存
+	rebuilt.AppendString([]byte("more"))
+	if lp.Length() != 3 {
+		t.Fatalf("Original listpack modified, length %d", lp.Length())
+	}
+
+	data := lp.Bytes()
+	if _, err := NewListpackFromBytes(data[:len(data)-1]); err == nil {
+		t.Fatal("Expected error for truncated listpack")
+	}
+	corrupted := append([]byte(nil), data...)
+	corrupted[4]++ // 元素数量与实际不符
+	if _, err := NewListpackFromBytes(corrupted); err == nil {
+		t.Fatal("Expected error for element count mismatch")
+	}
+}
+
+// TestListPlainNodes 测试超过 packed threshold 的元素单独存放在 PLAIN 节点中，
+// 可以正常读取和弹出，PLAIN 节点全部弹出后转换回 listpack
+func TestListPlainNodes(t *testing.T) {
+	SetQuicklistPackedThreshold(1024)
+	defer SetQuicklistPackedThreshold(0)
+
+	large1 := strings.Repeat("x", 10*1024)
+	large2 := strings.Repeat("y", 2048)
+
+	rl := NewList()
+	rl.Push([]byte("a"), 1)
+	rl.Push([]byte("b"), 1)
+	rl.Push([]byte(large1), 1)
+	rl.Push([]byte("c"), 1)
+	rl.Push([]byte(large2), 0)
+	if rl.Encoding() != OBJ_ENCODING_QUICKLIST {
+		t.Fatalf("Expected quicklist encoding, got %d", rl.Encoding())
+	}
+
+	plain := 0
+	for node := rl.quicklist.head; node != nil; node = node.next {
+		if node.isPlain() {
+			plain++
+			if node.count != 1 || node.listpack != nil {
+				t.Fatalf("Expected a plain node to hold a single raw element, got count %d", node.count)
+			}
+		}
+	}
+	if plain != 2 {
+		t.Fatalf("Expected 2 plain nodes, got %d", plain)
+	}
+
+	dropNodeListpacks(rl)
+	assertListElements(t, rl, []string{large2, "a", "b", large1, "c"})
+	if got, _ := rl.Range(3, 3); len(got) != 1 || string(got[0]) != large1 {
+		t.Fatal("Expected Range to return the plain element by index")
+	}
+
+	if v, err := rl.Pop(0); err != nil || string(v) != large2 {
+		t.Fatalf("Expected to pop the large head element, got %d bytes (%v)", len(v), err)
+	}
+	if v, err := rl.Pop(1); err != nil || string(v) != "c" {
+		t.Fatalf("Expected c, got %q (%v)", v, err)
+	}
+	if v, err := rl.Pop(1); err != nil || string(v) != large1 {
+		t.Fatalf("Expected to pop the large tail element, got %d bytes (%v)", len(v), err)
+	}
+	if rl.Encoding() != OBJ_ENCODING_LISTPACK {
+		t.Fatalf("Expected listpack encoding once the plain nodes are gone, got %d", rl.Encoding())
+	}
+	assertListElements(t, rl, []string{"a", "b"})
+
+	// 阈值以内的元素仍然留在 listpack 中
+	rl.Push([]byte(strings.Repeat("z", 1024)), 1)
+	if rl.Encoding() != OBJ_ENCODING_LISTPACK {
+		t.Fatal("Expected an element at the threshold to stay packed")
+	}
+}
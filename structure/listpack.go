@@ -33,6 +33,11 @@ import (
  * 【Backlen】
  * 每个元素后面都有一个反向编码的长度字段（backlen），用于向前遍历。
  * 使用变长编码，1-5 字节。
+ *
+ * 【元素指针】
+ * First/Next/Prev/Seek 返回的元素指针是元素在 data 中的字节偏移，0 表示没有元素
+ * （偏移 0 是 header，不可能是元素）。偏移与底层数组无关，grow() 重新分配后仍然有效；
+ * Delete/Replace/Insert 会移动之后的字节，之后应使用它们的返回值继续遍历。
  */
 
 const (
@@ -59,7 +64,8 @@ const (
 
 // ListpackFull 完整的 listpack 实现
 type ListpackFull struct {
-	data []byte // 二进制数据
+	data    []byte   // 二进制数据
+	offsets []uint32 // 元素下标 -> 字节偏移（Seek 时懒构建，Append 时同步追加，其他修改时失效）
 }
 
 // NewListpackFull 创建新的 listpack
@@ -80,6 +86,46 @@ func NewListpackFull(capacity int) *ListpackFull {
 	return lp
 }
 
+// NewListpackFromBytes 从 listpack 二进制数据（Bytes() 的结果）重建 listpack
+// 会复制 data，并校验 header 中的总长度、EOF 以及元素数量
+func NewListpackFromBytes(data []byte) (*ListpackFull, error) {
+	if len(data) < LP_HDR_SIZE+1 {
+		return nil, errors.New("listpack too short")
+	}
+
+	lp := &ListpackFull{
+		data: make([]byte, len(data)),
+	}
+	copy(lp.data, data)
+
+	if int(lp.getTotalBytes()) != len(data) {
+		return nil, errors.New("listpack total bytes mismatch")
+	}
+	if lp.data[len(data)-1] != LP_EOF {
+		return nil, errors.New("listpack missing EOF")
+	}
+
+	// 逐个遍历元素，确认编码完整且数量与 header 一致
+	count := 0
+	pos := LP_HDR_SIZE
+	for pos < len(data)-1 {
+		entryLen, err := lp.getEntryLen(lp.data[pos:])
+		if err != nil {
+			return nil, err
+		}
+		pos += entryLen + lp.encodeBacklenSize(uint64(entryLen))
+		count++
+	}
+	if pos != len(data)-1 {
+		return nil, errors.New("listpack entry overflows EOF")
+	}
+	if count != int(lp.getNumElements()) {
+		return nil, errors.New("listpack element count mismatch")
+	}
+
+	return lp, nil
+}
+
 // setTotalBytes 设置总字节数
 func (lp *ListpackFull) setTotalBytes(v uint32) {
 	lp.data[0] = byte(v)
@@ -136,8 +182,8 @@ func (lp *ListpackFull) AppendString(s []byte) error {
 		lp.grow(newTotalBytes)
 	}
 
-	// 编码字符串
-	entryStart := int(totalBytes)
+	// 编码字符串（新元素覆盖原 EOF 字节所在位置）
+	entryStart := int(totalBytes) - 1
 	lp.encodeString(lp.data[entryStart:], s)
 
 	// 编码 backlen
@@ -151,6 +197,10 @@ func (lp *ListpackFull) AppendString(s []byte) error {
 	// 设置新的 EOF
 	lp.data[newTotalBytes-1] = LP_EOF
 
+	if lp.offsets != nil {
+		lp.offsets = append(lp.offsets, uint32(entryStart))
+	}
+
 	return nil
 }
 
@@ -167,8 +217,8 @@ func (lp *ListpackFull) AppendInteger(v int64) error {
 		lp.grow(newTotalBytes)
 	}
 
-	// 编码整数
-	entryStart := int(totalBytes)
+	// 编码整数（新元素覆盖原 EOF 字节所在位置）
+	entryStart := int(totalBytes) - 1
 	lp.encodeInteger(lp.data[entryStart:], v)
 
 	// 编码 backlen
@@ -182,29 +232,67 @@ func (lp *ListpackFull) AppendInteger(v int64) error {
 	// 设置新的 EOF
 	lp.data[newTotalBytes-1] = LP_EOF
 
+	if lp.offsets != nil {
+		lp.offsets = append(lp.offsets, uint32(entryStart))
+	}
+
 	return nil
 }
 
 // Get 获取指定索引的元素
 func (lp *ListpackFull) Get(index int) ([]byte, int64, bool, error) {
+	p, err := lp.Seek(index)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	return lp.GetValue(p)
+}
+
+// Seek 获取指定索引的元素指针
+// 第一次调用时遍历一次建立偏移索引，之后在下一次 Delete/Replace/Insert 之前都是 O(1)
+func (lp *ListpackFull) Seek(index int) (int, error) {
 	if index < 0 || index >= int(lp.getNumElements()) {
-		return nil, 0, false, errors.New("index out of range")
+		return 0, errors.New("index out of range")
 	}
 
-	p := lp.First()
-	for i := 0; i < index; i++ {
-		var err error
-		p, err = lp.Next(p)
+	if lp.offsets == nil {
+		if err := lp.buildOffsets(); err != nil {
+			return 0, err
+		}
+	}
+	return int(lp.offsets[index]), nil
+}
+
+// buildOffsets 遍历所有元素，建立下标到字节偏移的索引
+func (lp *ListpackFull) buildOffsets() error {
+	offsets := make([]uint32, 0, lp.getNumElements())
+	end := int(lp.getTotalBytes()) - 1
+	for pos := LP_HDR_SIZE; pos < end; {
+		entryLen, err := lp.getEntryLen(lp.data[pos:])
 		if err != nil {
-			return nil, 0, false, err
+			return err
 		}
+		offsets = append(offsets, uint32(pos))
+		pos += entryLen + lp.encodeBacklenSize(uint64(entryLen))
+	}
+	if len(offsets) != int(lp.getNumElements()) {
+		return errors.New("listpack element count mismatch")
 	}
 
-	return lp.GetValue(p)
+	lp.offsets = offsets
+	return nil
 }
 
 // GetValue 获取指针指向的元素值
-func (lp *ListpackFull) GetValue(p []byte) ([]byte, int64, bool, error) {
+func (lp *ListpackFull) GetValue(p int) ([]byte, int64, bool, error) {
+	if !lp.validPos(p) {
+		return nil, 0, false, errors.New("invalid pointer")
+	}
+	return lp.decodeValue(lp.data[p:lp.getTotalBytes()])
+}
+
+// decodeValue 解码 p 开头的元素
+func (lp *ListpackFull) decodeValue(p []byte) ([]byte, int64, bool, error) {
 	if len(p) == 0 {
 		return nil, 0, false, errors.New("invalid pointer")
 	}
@@ -268,7 +356,7 @@ func (lp *ListpackFull) GetValue(p []byte) ([]byte, int64, bool, error) {
 		if len(p) < 4 {
 			return nil, 0, false, errors.New("invalid 24-bit int")
 		}
-		val := int64(int32(p[1] | p[2]<<8 | p[3]<<16))
+		val := int64(uint32(p[1]) | uint32(p[2])<<8 | uint32(p[3])<<16)
 		if val >= 8388608 {
 			val -= 16777216
 		}
@@ -310,73 +398,157 @@ func (lp *ListpackFull) GetValue(p []byte) ([]byte, int64, bool, error) {
 	return nil, 0, false, errors.New("unknown encoding")
 }
 
-// First 获取第一个元素
-func (lp *ListpackFull) First() []byte {
+// First 获取第一个元素，列表为空时返回 0
+func (lp *ListpackFull) First() int {
 	if lp.getNumElements() == 0 {
-		return nil
+		return 0
 	}
-	return lp.data[LP_HDR_SIZE:]
+	return LP_HDR_SIZE
 }
 
-// Next 获取下一个元素
-func (lp *ListpackFull) Next(p []byte) ([]byte, error) {
-	if len(p) == 0 {
-		return nil, errors.New("invalid pointer")
+// Next 获取下一个元素，已经是最后一个元素时返回 0
+func (lp *ListpackFull) Next(p int) (int, error) {
+	if !lp.validPos(p) {
+		return 0, errors.New("invalid pointer")
 	}
 
 	// 获取当前元素长度
-	entryLen, err := lp.getEntryLen(p)
+	entryLen, err := lp.getEntryLen(lp.data[p:lp.getTotalBytes()])
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 
-	// 计算当前元素在数据中的位置
-	currentPos := len(lp.data) - int(lp.getTotalBytes()) + len(p)
+	// 下一个元素（backlen 编码的是 entryLen，长度可直接算出）
+	nextStart := p + entryLen + lp.encodeBacklenSize(uint64(entryLen))
+	if nextStart >= int(lp.getTotalBytes()) {
+		return 0, errors.New("invalid backlen")
+	}
 
-	// 获取 backlen 长度
-	backlenStart := currentPos + entryLen
-	if backlenStart >= len(lp.data) {
-		return nil, errors.New("invalid backlen")
+	if lp.data[nextStart] == LP_EOF {
+		return 0, nil
 	}
 
-	backlenSize := lp.decodeBacklenSize(lp.data[backlenStart:])
+	return nextStart, nil
+}
 
-	// 下一个元素
-	nextStart := currentPos + entryLen + backlenSize
-	if nextStart >= len(lp.data) {
-		return nil, nil // EOF
+// Prev 获取上一个元素，已经是第一个元素时返回 0
+func (lp *ListpackFull) Prev(p int) (int, error) {
+	if !lp.validPos(p) {
+		return 0, errors.New("invalid pointer")
+	}
+	if p == LP_HDR_SIZE {
+		return 0, nil // 已经是第一个元素
 	}
 
-	if lp.data[nextStart] == LP_EOF {
-		return nil, nil
+	// 读取 backlen（从当前元素之前的最后一个字节向前解码）
+	backlen, backlenSize := lp.decodeBacklen(lp.data[:p])
+
+	// 上一个元素的位置
+	prevStart := p - int(backlen) - backlenSize
+	if prevStart < LP_HDR_SIZE {
+		return 0, errors.New("invalid previous element")
 	}
 
-	return lp.data[nextStart:], nil
+	return prevStart, nil
 }
 
-// Prev 获取上一个元素
-func (lp *ListpackFull) Prev(p []byte) ([]byte, error) {
-	if len(p) == 0 {
-		return nil, errors.New("invalid pointer")
+// validPos p 是否落在元素区域内（header 之后、EOF 之前）
+func (lp *ListpackFull) validPos(p int) bool {
+	return p >= LP_HDR_SIZE && p < int(lp.getTotalBytes())-1
+}
+
+// Delete 原地删除 p 指向的元素，返回下一个元素（删除的是最后一个元素时返回 0）
+// 删除后 p 之后的元素指针全部失效，应使用返回值继续遍历
+func (lp *ListpackFull) Delete(p int) (int, error) {
+	size, err := lp.entrySize(p)
+	if err != nil {
+		return 0, err
 	}
 
-	// 计算当前元素在数据中的位置
-	currentPos := len(lp.data) - int(lp.getTotalBytes()) + len(p)
-	if currentPos <= LP_HDR_SIZE {
-		return nil, nil // 已经是第一个元素
+	lp.splice(p, size, nil)
+	lp.setNumElements(lp.getNumElements() - 1)
+
+	if lp.data[p] == LP_EOF {
+		return 0, nil
+	}
+	return p, nil
+}
+
+// Replace 原地把 p 指向的元素替换为字符串 newval，返回新元素的指针
+// 替换后 p 之后的元素指针全部失效（新元素更长时可能触发扩容）
+func (lp *ListpackFull) Replace(p int, newval []byte) (int, error) {
+	size, err := lp.entrySize(p)
+	if err != nil {
+		return 0, err
 	}
 
-	// 读取 backlen
-	backlenStart := currentPos - 1
-	backlen, backlenSize := lp.decodeBacklen(lp.data[backlenStart:])
+	lp.splice(p, size, lp.encodeStringEntry(newval))
+	return p, nil
+}
 
-	// 上一个元素的位置
-	prevStart := currentPos - int(backlen) - backlenSize
-	if prevStart <= LP_HDR_SIZE {
-		return nil, errors.New("invalid previous element")
+// InsertBefore 在 p 指向的元素之前原地插入字符串 val，返回新元素的指针
+// 只移动插入位置之后的字节，插入后 p 及之后的元素指针全部失效
+func (lp *ListpackFull) InsertBefore(p int, val []byte) (int, error) {
+	if _, err := lp.entrySize(p); err != nil {
+		return 0, err
+	}
+	return lp.insertAt(p, val), nil
+}
+
+// InsertAfter 在 p 指向的元素之后原地插入字符串 val，返回新元素的指针
+// 只移动插入位置之后的字节，插入后 p 之后的元素指针全部失效
+func (lp *ListpackFull) InsertAfter(p int, val []byte) (int, error) {
+	size, err := lp.entrySize(p)
+	if err != nil {
+		return 0, err
+	}
+	return lp.insertAt(p+size, val), nil
+}
+
+// insertAt 在 pos 处插入字符串元素（pos 必须是某个元素的起始位置或 EOF 的位置）
+func (lp *ListpackFull) insertAt(pos int, val []byte) int {
+	lp.splice(pos, 0, lp.encodeStringEntry(val))
+	lp.setNumElements(lp.getNumElements() + 1)
+	return pos
+}
+
+// entrySize 返回 p 指向的元素包含 backlen 的总长度
+func (lp *ListpackFull) entrySize(p int) (int, error) {
+	if !lp.validPos(p) {
+		return 0, errors.New("invalid pointer")
+	}
+
+	entryLen, err := lp.getEntryLen(lp.data[p:lp.getTotalBytes()])
+	if err != nil {
+		return 0, err
+	}
+	return entryLen + lp.encodeBacklenSize(uint64(entryLen)), nil
+}
+
+// splice 把 [pos, pos+oldSize) 的字节替换为 entry，后面的字节（包括 EOF）整体移动并更新总长度
+func (lp *ListpackFull) splice(pos, oldSize int, entry []byte) {
+	totalBytes := int(lp.getTotalBytes())
+	newTotalBytes := totalBytes - oldSize + len(entry)
+
+	if newTotalBytes > len(lp.data) {
+		lp.grow(uint32(newTotalBytes))
 	}
 
-	return lp.data[prevStart:], nil
+	copy(lp.data[pos+len(entry):newTotalBytes], lp.data[pos+oldSize:totalBytes])
+	copy(lp.data[pos:], entry)
+	lp.setTotalBytes(uint32(newTotalBytes))
+
+	// 之后元素的偏移都变了，下次 Seek 时重建索引
+	lp.offsets = nil
+}
+
+// encodeStringEntry 编码包含 backlen 的完整字符串元素
+func (lp *ListpackFull) encodeStringEntry(s []byte) []byte {
+	entryLen := lp.encodeStringSize(len(s))
+	buf := make([]byte, entryLen+lp.encodeBacklenSize(uint64(entryLen)))
+	lp.encodeString(buf, s)
+	lp.encodeBacklen(buf[entryLen:], uint64(entryLen))
+	return buf
 }
 
 // 编码相关辅助函数
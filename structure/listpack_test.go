@@ -0,0 +1,345 @@
+package structure
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// lpValue 把元素值转换为字符串
+func lpValue(t *testing.T, lp *ListpackFull, p int) string {
+	t.Helper()
+	sval, ival, isInt, err := lp.GetValue(p)
+	if err != nil {
+		t.Fatalf("GetValue failed: %v", err)
+	}
+	if isInt {
+		return strconv.FormatInt(ival, 10)
+	}
+	return string(sval)
+}
+
+// assertListpack 正向、反向遍历 listpack，检查元素和 Length
+func assertListpack(t *testing.T, lp *ListpackFull, want ...string) {
+	t.Helper()
+	if int(lp.Length()) != len(want) {
+		t.Fatalf("Expected length %d, got %d", len(want), lp.Length())
+	}
+
+	var forward []string
+	var last int
+	for p := lp.First(); p != 0; {
+		forward = append(forward, lpValue(t, lp, p))
+		last = p
+		var err error
+		if p, err = lp.Next(p); err != nil {
+			t.Fatalf("Next failed: %v", err)
+		}
+	}
+
+	var backward []string
+	for p := last; p != 0; {
+		backward = append([]string{lpValue(t, lp, p)}, backward...)
+		var err error
+		if p, err = lp.Prev(p); err != nil {
+			t.Fatalf("Prev failed: %v", err)
+		}
+	}
+
+	for _, got := range [][]string{forward, backward} {
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Fatalf("Expected %v, got forward %v backward %v", want, forward, backward)
+		}
+	}
+	if lp.Bytes()[len(lp.Bytes())-1] != LP_EOF {
+		t.Fatal("Missing EOF")
+	}
+}
+
+// newTestListpack 创建包含整数、短字符串和长字符串（2 字节 backlen）的 listpack
+func newTestListpack() (*ListpackFull, []string) {
+	long := strings.Repeat("x", 200)
+	lp := NewListpackFull(16)
+	lp.AppendString([]byte("a"))
+	lp.AppendInteger(-5000)
+	lp.AppendString([]byte(long))
+	lp.AppendString([]byte("b"))
+	lp.AppendInteger(7)
+	return lp, []string{"a", "-5000", long, "b", "7"}
+}
+
+// lpAt 返回第 index 个元素的指针
+func lpAt(t *testing.T, lp *ListpackFull, index int) int {
+	t.Helper()
+	p := lp.First()
+	for i := 0; i < index; i++ {
+		var err error
+		if p, err = lp.Next(p); err != nil || p == 0 {
+			t.Fatalf("Element %d not found", index)
+		}
+	}
+	return p
+}
+
+// TestListpackDelete 测试删除第一个、中间和最后一个元素
+func TestListpackDelete(t *testing.T) {
+	for _, index := range []int{0, 2, 4} {
+		lp, want := newTestListpack()
+		next, err := lp.Delete(lpAt(t, lp, index))
+		if err != nil {
+			t.Fatalf("Delete(%d) failed: %v", index, err)
+		}
+		want = append(want[:index:index], want[index+1:]...)
+		assertListpack(t, lp, want...)
+
+		if index == len(want) {
+			if next != 0 {
+				t.Fatalf("Expected nil after deleting the last element, got %q", lpValue(t, lp, next))
+			}
+		} else if got := lpValue(t, lp, next); got != want[index] {
+			t.Fatalf("Expected next %q, got %q", want[index], got)
+		}
+	}
+
+	// 依次删除全部元素
+	lp, _ := newTestListpack()
+	p := lp.First()
+	for p != 0 {
+		var err error
+		if p, err = lp.Delete(p); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+	}
+	assertListpack(t, lp)
+	if lp.First() != 0 || len(lp.Bytes()) != LP_HDR_SIZE+1 {
+		t.Fatalf("Expected an empty listpack, got %d bytes", len(lp.Bytes()))
+	}
+
+	lp.AppendString([]byte("again"))
+	assertListpack(t, lp, "again")
+}
+
+// TestListpackReplace 测试用更短、更长和长度相同的值替换元素
+func TestListpackReplace(t *testing.T) {
+	long := strings.Repeat("y", 300)
+	for _, tc := range []struct {
+		index int
+		value string
+	}{
+		{0, long},   // 变长，需要扩容并改变 backlen 长度
+		{2, "z"},    // 变短
+		{4, "same"}, // 最后一个元素
+		{3, "c"},    // 长度不变
+	} {
+		lp, want := newTestListpack()
+		p, err := lp.Replace(lpAt(t, lp, tc.index), []byte(tc.value))
+		if err != nil {
+			t.Fatalf("Replace(%d) failed: %v", tc.index, err)
+		}
+		if got := lpValue(t, lp, p); got != tc.value {
+			t.Fatalf("Expected replaced element %q, got %q", tc.value, got)
+		}
+		want[tc.index] = tc.value
+		assertListpack(t, lp, want...)
+
+		// 替换后可以继续追加
+		lp.AppendInteger(99)
+		assertListpack(t, lp, append(want, "99")...)
+	}
+
+	lp, _ := newTestListpack()
+	if _, err := lp.Replace(0, []byte("x")); err == nil {
+		t.Fatal("Expected error for an empty pointer")
+	}
+	if _, err := lp.Delete(0); err == nil {
+		t.Fatal("Expected error for an empty pointer")
+	}
+}
+
+// TestListpackInsert 测试在第一个元素之前、最后一个元素之后以及中间插入
+func TestListpackInsert(t *testing.T) {
+	huge := strings.Repeat("h", 5000) // 32 位长度的字符串，需要扩容
+
+	lp, want := newTestListpack()
+	p, err := lp.InsertBefore(lp.First(), []byte("head"))
+	if err != nil {
+		t.Fatalf("InsertBefore failed: %v", err)
+	}
+	if got := lpValue(t, lp, p); got != "head" {
+		t.Fatalf("Expected inserted element head, got %q", got)
+	}
+	want = append([]string{"head"}, want...)
+	assertListpack(t, lp, want...)
+
+	p, err = lp.InsertAfter(lpAt(t, lp, len(want)-1), []byte(huge))
+	if err != nil {
+		t.Fatalf("InsertAfter failed: %v", err)
+	}
+	if got := lpValue(t, lp, p); got != huge {
+		t.Fatal("Expected inserted element to be the huge string")
+	}
+	if next, _ := lp.Next(p); next != 0 {
+		t.Fatal("Expected the inserted element to be the last one")
+	}
+	want = append(want, huge)
+	assertListpack(t, lp, want...)
+
+	// 中间插入，返回的指针可以继续遍历
+	p, err = lp.InsertAfter(lpAt(t, lp, 2), []byte("mid"))
+	if err != nil {
+		t.Fatalf("InsertAfter failed: %v", err)
+	}
+	if next, _ := lp.Next(p); lpValue(t, lp, next) != want[3] {
+		t.Fatalf("Expected %q after the inserted element", want[3])
+	}
+	if prev, _ := lp.Prev(p); lpValue(t, lp, prev) != want[2] {
+		t.Fatalf("Expected %q before the inserted element", want[2])
+	}
+	want = append(want[:3:3], append([]string{"mid"}, want[3:]...)...)
+	assertListpack(t, lp, want...)
+
+	// 插入、删除后追加仍然正确
+	if _, err := lp.Delete(lp.First()); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	lp.AppendString([]byte("tail"))
+	assertListpack(t, lp, append(want[1:], "tail")...)
+
+	// 空 listpack 没有可以作为插入位置的元素
+	if _, err := NewListpackFull(16).InsertBefore(0, []byte("x")); err == nil {
+		t.Fatal("Expected error for an empty pointer")
+	}
+}
+
+// assertListpackGet 检查 Get 按下标返回的元素
+func assertListpackGet(t *testing.T, lp *ListpackFull, want ...string) {
+	t.Helper()
+	for i := range want {
+		sval, ival, isInt, err := lp.Get(i)
+		if err != nil {
+			t.Fatalf("Get(%d) failed: %v", i, err)
+		}
+		got := string(sval)
+		if isInt {
+			got = strconv.FormatInt(ival, 10)
+		}
+		if got != want[i] {
+			t.Fatalf("Get(%d): expected %q, got %q", i, want[i], got)
+		}
+	}
+	if _, _, _, err := lp.Get(len(want)); err == nil {
+		t.Fatalf("Expected error for Get(%d)", len(want))
+	}
+}
+
+// TestListpackGetOffsets 测试偏移索引在 Append、Delete、Insert、Replace 之后仍然正确
+func TestListpackGetOffsets(t *testing.T) {
+	lp, want := newTestListpack()
+	assertListpackGet(t, lp, want...)
+
+	// Append 同步追加索引（包括触发扩容的情况）
+	lp.AppendString([]byte(strings.Repeat("g", 1000)))
+	lp.AppendInteger(123456789)
+	want = append(want, strings.Repeat("g", 1000), "123456789")
+	assertListpackGet(t, lp, want...)
+
+	if _, err := lp.Delete(lpAt(t, lp, 1)); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	want = append(want[:1:1], want[2:]...)
+	assertListpackGet(t, lp, want...)
+
+	if _, err := lp.InsertBefore(lpAt(t, lp, 0), []byte("first")); err != nil {
+		t.Fatalf("InsertBefore failed: %v", err)
+	}
+	want = append([]string{"first"}, want...)
+	assertListpackGet(t, lp, want...)
+
+	if _, err := lp.Replace(lpAt(t, lp, 3), []byte(strings.Repeat("r", 500))); err != nil {
+		t.Fatalf("Replace failed: %v", err)
+	}
+	want[3] = strings.Repeat("r", 500)
+	assertListpackGet(t, lp, want...)
+
+	lp.AppendString([]byte("last"))
+	assertListpackGet(t, lp, append(want, "last")...)
+}
+
+// newBenchListpack 创建 512 个元素的 listpack
+func newBenchListpack() *ListpackFull {
+	lp := NewListpackFull(256)
+	for i := 0; i < 512; i++ {
+		lp.AppendString([]byte("element:" + strconv.Itoa(i)))
+	}
+	return lp
+}
+
+// BenchmarkListpackGetWalk 从 First 开始逐个遍历到目标下标（没有偏移索引时的做法）
+func BenchmarkListpackGetWalk(b *testing.B) {
+	lp := newBenchListpack()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		index := rand.Intn(512)
+		p := lp.First()
+		for j := 0; j < index; j++ {
+			p, _ = lp.Next(p)
+		}
+		lp.GetValue(p)
+	}
+}
+
+// BenchmarkListpackGet 使用偏移索引随机访问（LINDEX）
+func BenchmarkListpackGet(b *testing.B) {
+	lp := newBenchListpack()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lp.Get(rand.Intn(512))
+	}
+}
+
+// TestListpackTraversalAcrossGrow 测试遍历过程中 Append 触发扩容后，已经拿到的元素指针仍然可以继续遍历
+func TestListpackTraversalAcrossGrow(t *testing.T) {
+	lp := NewListpackFull(16)
+	lp.AppendString([]byte("a"))
+	lp.AppendInteger(1000)
+	want := []string{"a", "1000"}
+
+	var got []string
+	p := lp.First()
+	for i := 0; p != 0; i++ {
+		got = append(got, lpValue(t, lp, p))
+
+		// 前几步每次都追加一个足以触发扩容的元素
+		if i < 3 {
+			before := len(lp.data)
+			value := strings.Repeat(strconv.Itoa(i), 100*(i+1)*(i+1))
+			lp.AppendString([]byte(value))
+			want = append(want, value)
+			if len(lp.data) == before {
+				t.Fatalf("Expected Append %d to grow the buffer", i)
+			}
+
+			// 扩容后当前指针仍然指向同一个元素，并且可以向前遍历
+			if cur := lpValue(t, lp, p); cur != got[i] {
+				t.Fatalf("Pointer moved after grow: expected %q, got %q", got[i], cur)
+			}
+			if i > 0 {
+				prev, err := lp.Prev(p)
+				if err != nil || lpValue(t, lp, prev) != got[i-1] {
+					t.Fatalf("Prev after grow: expected %q, got err %v", got[i-1], err)
+				}
+			}
+		}
+
+		var err error
+		if p, err = lp.Next(p); err != nil {
+			t.Fatalf("Next failed: %v", err)
+		}
+	}
+
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("Expected %v, got %v", want, got)
+	}
+	assertListpack(t, lp, want...)
+}
@@ -0,0 +1,156 @@
+package structure
+
+/*
+ * ============================================================================
+ * 内存占用估算（MEMORY USAGE）
+ * ============================================================================
+ *
+ * 与 Redis 的 objectComputeSize 思路一致：
+ * - 紧凑编码（listpack、intset）本身就是一块连续内存，直接返回精确大小
+ * - 哈希表、跳表等逐元素分配的编码，只抽样前 samples 个元素计算平均大小，
+ *   再乘以元素总数得到估算值，避免对大集合做全量遍历
+ * - samples <= 0 表示遍历全部元素（精确计算）
+ *
+ * 这里的常量是 64 位平台上对应 C 结构体的大小，
+ * 用于让结果与 Redis 在量级上保持一致，而不是 Go 运行时的实际分配
+ */
+
+const (
+	dictHeaderSize      = 56 // dict 结构体（两张表 + rehash 状态）
+	dictEntrySize       = 24 // dictEntry：key、val、next 三个指针
+	dictBucketSize      = 8  // 桶数组中的一个指针
+	skiplistHeaderSize  = 32 // zskiplist 结构体
+	skiplistNodeSize    = 24 // zskiplistNode：ele、score、backward
+	skiplistLevelSize   = 16 // zskiplistLevel：forward、span
+	quicklistHeaderSize = 40 // quicklist 结构体
+	quicklistNodeSize   = 32 // quicklistNode 结构体
+	intsetHeaderSize    = 8  // intset：encoding、length
+)
+
+// SDSAllocSize 获取 SDS 占用的内存（header + 已分配空间 + 结尾的 \0）
+func SDSAllocSize(s SDS) int64 {
+	if s == nil {
+		return 0
+	}
+	return int64(sdsHdrSize(sdsType(s))) + int64(sdsAlloc(s)) + 1
+}
+
+// SDSSizeOf 估算长度为 n 的字符串按 SDS 存储时占用的内存
+func SDSSizeOf(n int) int64 {
+	return int64(sdsHdrSize(sdsReqType(uint64(n)))) + int64(n) + 1
+}
+
+// dictSizeOf 估算包含 n 个元素的 dict 的固定开销（不含元素本身）
+func dictSizeOf(n int) int64 {
+	buckets := 4
+	for buckets < n {
+		buckets *= 2
+	}
+	return dictHeaderSize + int64(buckets)*dictBucketSize
+}
+
+// sampleSize 抽样估算：累加前 samples 个元素的大小后按平均值外推到 total 个元素
+// next 每次返回一个元素的大小，返回 false 表示元素已遍历完
+func sampleSize(total, samples int, next func(yield func(size int64) bool)) int64 {
+	var sum int64
+	sampled := 0
+	next(func(size int64) bool {
+		sum += size
+		sampled++
+		return samples <= 0 || sampled < samples
+	})
+	if sampled == 0 {
+		return 0
+	}
+	if sampled >= total {
+		return sum
+	}
+	return sum / int64(sampled) * int64(total)
+}
+
+// SizeInBytes 估算列表占用的内存（quicklist 按节点抽样）
+func (rl *RedisList) SizeInBytes(samples int) int64 {
+	if rl.encoding == OBJ_ENCODING_LISTPACK {
+		return int64(len(rl.listpack.Bytes()))
+	}
+
+	ql := rl.quicklist
+	size := int64(quicklistHeaderSize)
+	size += sampleSize(int(ql.len), samples, func(yield func(int64) bool) {
+		for node := ql.head; node != nil; node = node.next {
+			nodeSize := int64(quicklistNodeSize)
+			if node.listpack != nil {
+				nodeSize += int64(len(node.listpack.Bytes()))
+			} else {
+				nodeSize += int64(len(node.entry))
+			}
+			if !yield(nodeSize) {
+				return
+			}
+		}
+	})
+	return size
+}
+
+// SizeInBytes 估算集合占用的内存（hashtable 编码按元素抽样）
+func (rs *RedisSet) SizeInBytes(samples int) int64 {
+	if rs.encoding == OBJ_ENCODING_INTSET {
+		width := int64(rs.intset.encoding)
+		if width == 0 {
+			width = 8
+		}
+		return intsetHeaderSize + int64(len(rs.intset.contents))*width
+	}
+
+	size := dictSizeOf(len(rs.hashtable))
+	size += sampleSize(len(rs.hashtable), samples, func(yield func(int64) bool) {
+		for member := range rs.hashtable {
+			if !yield(dictEntrySize + SDSSizeOf(len(member))) {
+				return
+			}
+		}
+	})
+	return size
+}
+
+// SizeInBytes 估算有序集合占用的内存（skiplist 编码按元素抽样）
+func (rz *RedisZSet) SizeInBytes(samples int) int64 {
+	if rz.encoding == OBJ_ENCODING_LISTPACK {
+		return int64(len(rz.listpack.Bytes()))
+	}
+
+	size := dictSizeOf(len(rz.dict)) + skiplistHeaderSize
+	size += sampleSize(len(rz.dict), samples, func(yield func(int64) bool) {
+		for node := rz.skiplist.header.level[0].forward; node != nil; node = node.level[0].forward {
+			nodeSize := int64(skiplistNodeSize) + int64(len(node.level))*skiplistLevelSize
+			nodeSize += dictEntrySize + SDSSizeOf(len(node.member))
+			if !yield(nodeSize) {
+				return
+			}
+		}
+	})
+	return size
+}
+
+// SizeInBytes 估算哈希占用的内存（hashtable 编码按字段抽样）
+func (rh *RedisHash) SizeInBytes(samples int) int64 {
+	var size int64
+	if rh.encoding == OBJ_ENCODING_LISTPACK {
+		size = int64(len(rh.listpack.Bytes()))
+	} else {
+		size = dictSizeOf(len(rh.hashtable))
+		size += sampleSize(len(rh.hashtable), samples, func(yield func(int64) bool) {
+			for field, value := range rh.hashtable {
+				if !yield(dictEntrySize + SDSSizeOf(len(field)) + SDSSizeOf(len(value))) {
+					return
+				}
+			}
+		})
+	}
+
+	// 字段过期时间单独保存在一个 dict 中（field -> 毫秒时间戳）
+	if len(rh.expires) > 0 {
+		size += dictSizeOf(len(rh.expires)) + int64(len(rh.expires))*(dictEntrySize+8)
+	}
+	return size
+}
@@ -0,0 +1,122 @@
+package structure
+
+import "math/bits"
+
+/*
+ * ============================================================================
+ * SCAN 桶索引
+ * ============================================================================
+ *
+ * Go 的 map 无法从中间位置继续遍历，因此另外维护一个按哈希值分桶的索引：
+ * 元素按 scanHash 的低位分到 2^n 个桶中，桶数随元素数翻倍（元素数超过桶数）或减半（元素数不足桶数的 1/8）。
+ * 数据库的键空间（SCAN）和 hashtable 编码的哈希表（HSCAN）都使用它。
+ *
+ * 【游标】
+ * 与 Redis 的 dictScan 一致，游标是桶下标，按"反向二进制"递增（从高位加 1）：
+ * 桶数翻倍时桶 i 拆分成 i 和 i+size，缩小时 i 和 i+size 合并成 i，
+ * 反向递增保证已经遍历过的桶在扩缩后对应的桶也已经遍历过。
+ * 因此从第一次调用到游标回到 0 期间一直存在的元素至少返回一次（桶数缩小时可能重复返回）。
+ *
+ * 每次调用只访问 COUNT 个左右的元素，与集合大小无关。
+ */
+
+// SCAN_MIN_BUCKETS 桶索引的最小桶数
+const SCAN_MIN_BUCKETS = 16
+
+// ScanIndex 按哈希值分桶的索引（不是并发安全的，由使用者的锁保护）
+type ScanIndex struct {
+	buckets [][]string
+	count   int
+}
+
+// NewScanIndex 创建空的桶索引
+func NewScanIndex() *ScanIndex {
+	return &ScanIndex{buckets: make([][]string, SCAN_MIN_BUCKETS)}
+}
+
+// Add 添加新元素（调用方保证元素不在索引中）
+func (si *ScanIndex) Add(key string) {
+	si.count++
+	if si.count > len(si.buckets) {
+		si.resize(len(si.buckets) * 2)
+	}
+	b := scanHash(key) & uint64(len(si.buckets)-1)
+	si.buckets[b] = append(si.buckets[b], key)
+}
+
+// Remove 删除元素（元素不在索引中时什么也不做）
+func (si *ScanIndex) Remove(key string) {
+	b := scanHash(key) & uint64(len(si.buckets)-1)
+	bucket := si.buckets[b]
+	for i, k := range bucket {
+		if k == key {
+			bucket[i] = bucket[len(bucket)-1]
+			bucket[len(bucket)-1] = ""
+			si.buckets[b] = bucket[:len(bucket)-1]
+			si.count--
+			break
+		}
+	}
+
+	if len(si.buckets) > SCAN_MIN_BUCKETS && si.count < len(si.buckets)/8 {
+		si.resize(len(si.buckets) / 2)
+	}
+}
+
+// Scan 从 cursor 对应的桶开始按整桶访问元素，访问的元素数达到 count（或连续访问了 10*count 个空桶）时停止。
+// 返回下一次的游标（0 表示遍历完成）
+func (si *ScanIndex) Scan(cursor uint64, count int, visit func(key string)) uint64 {
+	if count <= 0 {
+		count = 10
+	}
+
+	mask := uint64(len(si.buckets) - 1)
+	v := cursor
+	visited, emptyBudget := 0, count*10
+	for {
+		bucket := si.buckets[v&mask]
+		if len(bucket) == 0 {
+			emptyBudget--
+		}
+		for _, key := range bucket {
+			visit(key)
+		}
+		visited += len(bucket)
+
+		v = nextCursor(v, mask)
+		if v == 0 || visited >= count || emptyBudget <= 0 {
+			return v
+		}
+	}
+}
+
+// resize 按新的桶数重新分桶（size 为 2 的幂）
+func (si *ScanIndex) resize(size int) {
+	buckets := make([][]string, size)
+	mask := uint64(size - 1)
+	for _, bucket := range si.buckets {
+		for _, key := range bucket {
+			b := scanHash(key) & mask
+			buckets[b] = append(buckets[b], key)
+		}
+	}
+	si.buckets = buckets
+}
+
+// nextCursor 游标按反向二进制递增，遍历完所有桶时回到 0
+func nextCursor(cursor, mask uint64) uint64 {
+	cursor |= ^mask
+	cursor = bits.Reverse64(cursor)
+	cursor++
+	return bits.Reverse64(cursor)
+}
+
+// scanHash 计算元素的哈希值（FNV-1a）
+func scanHash(key string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	return h
+}
@@ -81,6 +81,7 @@ const (
 	INTSET_ENC_INT64 = 8 // int64 编码
 )
 
+// 默认阈值，运行时的值见 limits.go（set-max-intset-entries）
 const (
 	SET_MAX_INTSET_ENTRIES = 512 // 超过此数量转换为 hashtable
 )
@@ -111,6 +112,11 @@ func NewSet() *RedisSet {
 	}
 }
 
+// Encoding 获取当前编码（intset 或 hashtable）
+func (rs *RedisSet) Encoding() Encoding {
+	return rs.encoding
+}
+
 // Add 添加元素到 Set
 func (rs *RedisSet) Add(member []byte) error {
 	if rs.encoding == OBJ_ENCODING_INTSET {
@@ -147,7 +153,7 @@ func (rs *RedisSet) addIntset(member []byte) error {
 	rs.intset.length++
 
 	// 检查是否需要转换为 hashtable
-	if rs.intset.length > SET_MAX_INTSET_ENTRIES {
+	if int64(rs.intset.length) > setMaxIntsetEntries.Load() {
 		rs.convertToHashtable()
 	}
 
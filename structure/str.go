@@ -129,22 +129,22 @@ func sdsType(s SDS) SDSType {
 
 // sdsHdr8 获取 sdshdr8 的头部指针
 func sdsHdr8(s SDS) *sdshdr8 {
-	return (*sdshdr8)(unsafe.Pointer(uintptr(unsafe.Pointer(s)) - unsafe.Sizeof(sdshdr8{})))
+	return (*sdshdr8)(unsafe.Pointer(uintptr(unsafe.Pointer(s)) - sdsHdrSize(SDS_TYPE_8)))
 }
 
 // sdsHdr16 获取 sdshdr16 的头部指针
 func sdsHdr16(s SDS) *sdshdr16 {
-	return (*sdshdr16)(unsafe.Pointer(uintptr(unsafe.Pointer(s)) - unsafe.Sizeof(sdshdr16{})))
+	return (*sdshdr16)(unsafe.Pointer(uintptr(unsafe.Pointer(s)) - sdsHdrSize(SDS_TYPE_16)))
 }
 
 // sdsHdr32 获取 sdshdr32 的头部指针
 func sdsHdr32(s SDS) *sdshdr32 {
-	return (*sdshdr32)(unsafe.Pointer(uintptr(unsafe.Pointer(s)) - unsafe.Sizeof(sdshdr32{})))
+	return (*sdshdr32)(unsafe.Pointer(uintptr(unsafe.Pointer(s)) - sdsHdrSize(SDS_TYPE_32)))
 }
 
 // sdsHdr64 获取 sdshdr64 的头部指针
 func sdsHdr64(s SDS) *sdshdr64 {
-	return (*sdshdr64)(unsafe.Pointer(uintptr(unsafe.Pointer(s)) - unsafe.Sizeof(sdshdr64{})))
+	return (*sdshdr64)(unsafe.Pointer(uintptr(unsafe.Pointer(s)) - sdsHdrSize(SDS_TYPE_64)))
 }
 
 // sdsLen 获取字符串长度 - O(1) 时间复杂度
@@ -212,20 +212,20 @@ func sdsReqType(size uint64) SDSType {
 }
 
 // sdsHdrSize 获取 header 大小
+// 按 flags 之后的位置计算而不是 unsafe.Sizeof：结构体末尾的对齐填充会让 flags 与 buf 之间隔出空字节，
+// 而 sdsType 依赖 flags 紧挨在 buf 之前（s[-1]）
 func sdsHdrSize(t SDSType) uintptr {
 	switch t {
 	case SDS_TYPE_5:
-		return unsafe.Sizeof(struct {
-			flags byte
-		}{})
+		return 1
 	case SDS_TYPE_8:
-		return unsafe.Sizeof(sdshdr8{})
+		return unsafe.Offsetof(sdshdr8{}.flags) + 1
 	case SDS_TYPE_16:
-		return unsafe.Sizeof(sdshdr16{})
+		return unsafe.Offsetof(sdshdr16{}.flags) + 1
 	case SDS_TYPE_32:
-		return unsafe.Sizeof(sdshdr32{})
+		return unsafe.Offsetof(sdshdr32{}.flags) + 1
 	case SDS_TYPE_64:
-		return unsafe.Sizeof(sdshdr64{})
+		return unsafe.Offsetof(sdshdr64{}.flags) + 1
 	}
 	return 0
 }
@@ -241,12 +241,17 @@ func NewSDSFromBytes(init []byte) SDS {
 	if initlen == 0 {
 		return NewSDSEmpty()
 	}
+	return sdsNewAlloc(init, initlen)
+}
 
-	sdsType := sdsReqType(initlen)
+// sdsNewAlloc 创建内容为 init、容量为 alloc 的 SDS（header 类型按 alloc 选择）
+func sdsNewAlloc(init []byte, alloc uint64) SDS {
+	sdsType := sdsReqType(alloc)
 	hdrSize := sdsHdrSize(sdsType)
+	initlen := uint64(len(init))
 
-	// 分配内存：头部 + 字符串 + \0
-	totalSize := hdrSize + uintptr(initlen) + 1
+	// 分配内存：头部 + 容量 + \0
+	totalSize := hdrSize + uintptr(alloc) + 1
 	buf := make([]byte, totalSize)
 
 	// 设置头部
@@ -254,38 +259,28 @@ func NewSDSFromBytes(init []byte) SDS {
 	case SDS_TYPE_8:
 		hdr := (*sdshdr8)(unsafe.Pointer(&buf[0]))
 		hdr.len = uint8(initlen)
-		hdr.alloc = uint8(initlen)
+		hdr.alloc = uint8(alloc)
 		hdr.flags = byte(sdsType)
-		copy(buf[hdrSize:], init)
-		buf[totalSize-1] = 0 // \0 结尾
-		return (*byte)(unsafe.Pointer(&buf[hdrSize]))
 	case SDS_TYPE_16:
 		hdr := (*sdshdr16)(unsafe.Pointer(&buf[0]))
 		hdr.len = uint16(initlen)
-		hdr.alloc = uint16(initlen)
+		hdr.alloc = uint16(alloc)
 		hdr.flags = byte(sdsType)
-		copy(buf[hdrSize:], init)
-		buf[totalSize-1] = 0
-		return (*byte)(unsafe.Pointer(&buf[hdrSize]))
 	case SDS_TYPE_32:
 		hdr := (*sdshdr32)(unsafe.Pointer(&buf[0]))
 		hdr.len = uint32(initlen)
-		hdr.alloc = uint32(initlen)
+		hdr.alloc = uint32(alloc)
 		hdr.flags = byte(sdsType)
-		copy(buf[hdrSize:], init)
-		buf[totalSize-1] = 0
-		return (*byte)(unsafe.Pointer(&buf[hdrSize]))
 	case SDS_TYPE_64:
 		hdr := (*sdshdr64)(unsafe.Pointer(&buf[0]))
 		hdr.len = initlen
-		hdr.alloc = initlen
+		hdr.alloc = alloc
 		hdr.flags = byte(sdsType)
-		copy(buf[hdrSize:], init)
-		buf[totalSize-1] = 0
-		return (*byte)(unsafe.Pointer(&buf[hdrSize]))
 	}
 
-	return nil
+	copy(buf[hdrSize:], init)
+	buf[hdrSize+uintptr(initlen)] = 0 // \0 结尾
+	return (*byte)(unsafe.Pointer(&buf[hdrSize]))
 }
 
 // NewSDSEmpty 创建空的 SDS
@@ -323,9 +318,9 @@ func SDSCatLen(s SDS, t []byte, addlen uint64) SDS {
 		return nil
 	}
 
-	// 复制数据
-	buf := sdsBuf(s)
-	copy(buf[curlen:], t)
+	// 复制数据（写入已分配但未使用的空间）
+	buf := sdsAllocBuf(s)
+	copy(buf[curlen:curlen+addlen], t)
 
 	// 更新长度
 	sdsSetLen(s, curlen+addlen)
@@ -334,7 +329,36 @@ func SDSCatLen(s SDS, t []byte, addlen uint64) SDS {
 	return s
 }
 
+// SDSSetRange 从 offset 开始用 t 覆盖 SDS，超出原长度的部分先用 \0 补齐（SETRANGE）
+// 与 SDSCatLen 一样，空间足够时原地修改，否则返回扩容后的新 SDS
+func SDSSetRange(s SDS, offset uint64, t []byte) SDS {
+	curlen := sdsLen(s)
+	end := offset + uint64(len(t))
+	if end > curlen {
+		s = sdsMakeRoomFor(s, end-curlen)
+		buf := sdsAllocBuf(s)
+		clear(buf[curlen:end]) // 复用的空间里可能有截断前的旧数据
+		sdsSetLen(s, end)
+		buf[end] = 0
+	}
+
+	copy(sdsAllocBuf(s)[offset:end], t)
+	return s
+}
+
+// SDSLen 获取 SDS 的长度（导出函数）
+func SDSLen(s SDS) int {
+	return int(sdsLen(s))
+}
+
+// SDSCap 获取 SDS 已分配的容量（alloc，不包括 header 和结尾的 \0）
+func SDSCap(s SDS) int {
+	return int(sdsAlloc(s))
+}
+
 // sdsMakeRoomFor 为 SDS 分配更多空间（实现预分配策略）
+// 可用空间足够时直接返回原来的 SDS，复用原来的缓冲区；
+// 否则按预分配策略计算新的 alloc，header 类型随 alloc 变宽，复制已有内容后返回新的 SDS
 func sdsMakeRoomFor(s SDS, addlen uint64) SDS {
 	avail := sdsAvail(s)
 
@@ -345,53 +369,17 @@ func sdsMakeRoomFor(s SDS, addlen uint64) SDS {
 
 	curlen := sdsLen(s)
 	newlen := curlen + addlen
-	newtype := sdsReqType(newlen)
-	oldtype := sdsType(s)
-
-	// 如果类型不变且新长度在 alloc 范围内，只需要扩展 alloc
-	if newtype == oldtype {
-		hdrSize := sdsHdrSize(oldtype)
-		oldHdrPtr := uintptr(unsafe.Pointer(s)) - hdrSize
-		oldTotalSize := hdrSize + uintptr(curlen) + 1
-
-		// 计算新的 alloc（预分配策略）
-		var newalloc uint64
-		if newlen < SDS_MAX_PREALLOC {
-			newalloc = newlen * 2 // 小于 1MB，分配 2 倍
-		} else {
-			newalloc = newlen + SDS_MAX_PREALLOC // 大于 1MB，多分配 1MB
-		}
 
-		// 重新分配内存
-		newTotalSize := hdrSize + uintptr(newalloc) + 1
-		newBuf := make([]byte, newTotalSize)
-
-		// 复制 header 和数据
-		copy(newBuf, (*[1 << 30]byte)(unsafe.Pointer(oldHdrPtr))[:oldTotalSize])
-
-		// 更新 alloc
-		switch oldtype {
-		case SDS_TYPE_8:
-			hdr := (*sdshdr8)(unsafe.Pointer(&newBuf[0]))
-			hdr.alloc = uint8(newalloc)
-		case SDS_TYPE_16:
-			hdr := (*sdshdr16)(unsafe.Pointer(&newBuf[0]))
-			hdr.alloc = uint16(newalloc)
-		case SDS_TYPE_32:
-			hdr := (*sdshdr32)(unsafe.Pointer(&newBuf[0]))
-			hdr.alloc = uint32(newalloc)
-		case SDS_TYPE_64:
-			hdr := (*sdshdr64)(unsafe.Pointer(&newBuf[0]))
-			hdr.alloc = newalloc
-		}
-
-		return (*byte)(unsafe.Pointer(&newBuf[hdrSize]))
+	// 计算新的 alloc（预分配策略）
+	var newalloc uint64
+	if newlen < SDS_MAX_PREALLOC {
+		newalloc = newlen * 2 // 小于 1MB，分配 2 倍
+	} else {
+		newalloc = newlen + SDS_MAX_PREALLOC // 大于 1MB，多分配 1MB
 	}
 
-	// 类型需要改变，重新创建
-	news := NewSDSFromBytes(SdsBytes(s))
-	news = SDSCatLen(news, SdsBytes(s), curlen)
-	return SDSCatLen(news, nil, addlen)
+	// Go 没有 realloc，类型不变时同样需要重新分配；长度仍为 curlen，由调用方更新
+	return sdsNewAlloc(sdsBuf(s), newalloc)
 }
 
 // sdsSetLen 设置 SDS 长度
@@ -425,6 +413,11 @@ func sdsBuf(s SDS) []byte {
 	return (*[1 << 30]byte)(unsafe.Pointer(s))[:len:len]
 }
 
+// sdsAllocBuf 获取包括未使用空间和结尾 \0 在内的整个 buf（长度为 alloc+1）
+func sdsAllocBuf(s SDS) []byte {
+	return unsafe.Slice((*byte)(s), sdsAlloc(s)+1)
+}
+
 // SdsBytes 获取 SDS 的字节数组（复制）（导出函数）
 func SdsBytes(s SDS) []byte {
 	buf := sdsBuf(s)
@@ -457,6 +450,59 @@ func SDSCmp(s1, s2 SDS) int {
 	return 0
 }
 
+// SDSCaseCmp 忽略大小写比较两个 SDS 字符串（只处理 ASCII 字母，与 Redis 一致）
+func SDSCaseCmp(s1, s2 SDS) int {
+	buf1 := sdsBuf(s1)
+	buf2 := sdsBuf(s2)
+	minlen := len(buf1)
+	if len(buf2) < minlen {
+		minlen = len(buf2)
+	}
+
+	for i := 0; i < minlen; i++ {
+		c1, c2 := asciiLower(buf1[i]), asciiLower(buf2[i])
+		if c1 != c2 {
+			if c1 < c2 {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	if len(buf1) < len(buf2) {
+		return -1
+	} else if len(buf1) > len(buf2) {
+		return 1
+	}
+	return 0
+}
+
+// SDSToLower 原地把 SDS 中的 ASCII 大写字母转换为小写，其他字节不变
+func SDSToLower(s SDS) {
+	buf := sdsBuf(s)
+	for i, c := range buf {
+		buf[i] = asciiLower(c)
+	}
+}
+
+// SDSToUpper 原地把 SDS 中的 ASCII 小写字母转换为大写，其他字节不变
+func SDSToUpper(s SDS) {
+	buf := sdsBuf(s)
+	for i, c := range buf {
+		if c >= 'a' && c <= 'z' {
+			buf[i] = c - ('a' - 'A')
+		}
+	}
+}
+
+// asciiLower ASCII 大写字母转换为小写
+func asciiLower(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + ('a' - 'A')
+	}
+	return c
+}
+
 // SDSTrim 去除 SDS 两端指定的字符
 func SDSTrim(s SDS, cutset string) SDS {
 	buf := sdsBuf(s)
@@ -514,6 +560,49 @@ func SDSClear(s SDS) {
 	}
 }
 
+// SDSRange 原地截取 [start, end] 范围（两端都包含），与 Redis 的 sdsrange 一致
+// 负数索引从末尾开始计算（-1 为最后一个字节），越界时截断到有效范围，
+// start > end 或 start 超出长度时清空为长度 0；截取后不释放多余的空间
+func SDSRange(s SDS, start, end int64) {
+	length := int64(sdsLen(s))
+	if length == 0 {
+		return
+	}
+
+	if start < 0 {
+		start = length + start
+		if start < 0 {
+			start = 0
+		}
+	}
+	if end < 0 {
+		end = length + end
+		if end < 0 {
+			end = 0
+		}
+	}
+
+	newlen := int64(0)
+	if start <= end && start < length {
+		if end >= length {
+			end = length - 1
+		}
+		newlen = end - start + 1
+	}
+
+	buf := sdsAllocBuf(s)
+	if start > 0 && newlen > 0 {
+		copy(buf, buf[start:start+newlen])
+	}
+	buf[newlen] = 0
+	sdsSetLen(s, uint64(newlen))
+}
+
+// SDSDup 复制一个新的 SDS
+func SDSDup(s SDS) SDS {
+	return NewSDSFromBytes(sdsBuf(s))
+}
+
 // SDSCpy 复制字符串到 SDS
 func SDSCpy(s SDS, t string) SDS {
 	return SDSCpyLen(s, []byte(t), uint64(len(t)))
@@ -532,8 +621,8 @@ func SDSCpyLen(s SDS, t []byte, len uint64) SDS {
 		return nil
 	}
 
-	buf := sdsBuf(s)
-	copy(buf, t)
+	buf := sdsAllocBuf(s)
+	copy(buf[:len], t)
 	sdsSetLen(s, len)
 	buf[len] = 0
 
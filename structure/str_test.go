@@ -0,0 +1,239 @@
+package structure
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// TestSDSHeaderTypes 测试各种 header 类型的 SDS 都能正确读出长度和内容
+func TestSDSHeaderTypes(t *testing.T) {
+	for _, tc := range []struct {
+		n    int
+		want SDSType
+	}{
+		{1, SDS_TYPE_8},
+		{255, SDS_TYPE_8},
+		{256, SDS_TYPE_16},
+		{65535, SDS_TYPE_16},
+		{65536, SDS_TYPE_32},
+	} {
+		value := bytes.Repeat([]byte("a"), tc.n)
+		s := NewSDSFromBytes(value)
+		if got := sdsType(s); got != tc.want {
+			t.Fatalf("len %d: expected type %d, got %d", tc.n, tc.want, got)
+		}
+		if got := SdsBytes(s); !bytes.Equal(got, value) {
+			t.Fatalf("len %d: got %d bytes back", tc.n, len(got))
+		}
+	}
+}
+
+// TestSDSCatReusesBuffer 测试预分配空间足够时追加不重新分配缓冲区
+func TestSDSCatReusesBuffer(t *testing.T) {
+	s := NewSDS("hello")
+	s = SDSCat(s, " world") // 空间不足，按预分配策略扩容
+	if got := string(SdsBytes(s)); got != "hello world" {
+		t.Fatalf("Expected hello world, got %q", got)
+	}
+	if sdsAvail(s) == 0 {
+		t.Fatal("Expected spare capacity after growing")
+	}
+
+	// 空间足够时每次追加都复用同一个缓冲区
+	want := "hello world"
+	for sdsAvail(s) > 0 {
+		before := s
+		s = SDSCat(s, "!")
+		want += "!"
+		if s != before {
+			t.Fatalf("Append within capacity reallocated (len %d)", sdsLen(s))
+		}
+	}
+	if got := string(SdsBytes(s)); got != want {
+		t.Fatalf("Expected %q, got %q", want, got)
+	}
+	if sdsAllocBuf(s)[sdsLen(s)] != 0 {
+		t.Fatal("Missing terminating \\0")
+	}
+
+	// 用完之后再追加才重新分配
+	before := s
+	s = SDSCat(s, "?")
+	if s == before {
+		t.Fatal("Expected a reallocation once capacity is exhausted")
+	}
+	if got := string(SdsBytes(s)); got != want+"?" {
+		t.Fatalf("Expected %q, got %q", want+"?", got)
+	}
+}
+
+// TestSDSCatAcrossTypes 测试追加使 header 类型变宽时内容和长度正确
+func TestSDSCatAcrossTypes(t *testing.T) {
+	s := NewSDSEmpty()
+	var want strings.Builder
+	for i := 0; i < 4000; i++ {
+		chunk := strings.Repeat(string(rune('a'+i%26)), i%50)
+		s = SDSCat(s, chunk)
+		want.WriteString(chunk)
+	}
+	if got := string(SdsBytes(s)); got != want.String() {
+		t.Fatalf("Expected %d bytes, got %d", want.Len(), len(got))
+	}
+	if sdsType(s) != SDS_TYPE_32 {
+		t.Fatalf("Expected sdshdr32 after growing past 64KB, got %d", sdsType(s))
+	}
+}
+
+// TestSDSSetRange 测试覆盖写入和超出长度时的 \0 填充
+func TestSDSSetRange(t *testing.T) {
+	s := NewSDS("Hello World")
+	s = SDSSetRange(s, 6, []byte("Redis"))
+	if got := string(SdsBytes(s)); got != "Hello Redis" {
+		t.Fatalf("Expected Hello Redis, got %q", got)
+	}
+
+	s = SDSSetRange(s, 14, []byte("!"))
+	if got := string(SdsBytes(s)); got != "Hello Redis\x00\x00\x00!" {
+		t.Fatalf("Expected zero padding, got %q", got)
+	}
+
+	// 截断后再扩展，复用的空间不能残留旧数据
+	s = SDSCpy(s, "ab")
+	s = SDSSetRange(s, 5, []byte("c"))
+	if got := string(SdsBytes(s)); got != "ab\x00\x00\x00c" {
+		t.Fatalf("Expected stale bytes to be cleared, got %q", got)
+	}
+}
+
+// TestSDSRange 测试负数索引、越界截断和空范围
+func TestSDSRange(t *testing.T) {
+	for _, tc := range []struct {
+		start, end int64
+		want       string
+	}{
+		{0, -1, "Hello World"},
+		{1, -1, "ello World"},
+		{-5, -1, "World"},
+		{-100, 4, "Hello"}, // start 截断到 0
+		{6, 100, "World"},  // end 截断到最后一个字节
+		{0, -100, "H"},     // end 截断到 0
+		{5, 2, ""},         // start > end
+		{11, 20, ""},       // start 超出长度
+		{-1, -2, ""},       // 负数索引换算后 start > end
+		{3, 3, "l"},
+	} {
+		s := NewSDS("Hello World")
+		alloc := sdsAlloc(s)
+		SDSRange(s, tc.start, tc.end)
+		if got := string(SdsBytes(s)); got != tc.want {
+			t.Fatalf("SDSRange(%d, %d): expected %q, got %q", tc.start, tc.end, tc.want, got)
+		}
+		if sdsAlloc(s) != alloc {
+			t.Fatalf("SDSRange(%d, %d) changed alloc", tc.start, tc.end)
+		}
+		if sdsAllocBuf(s)[sdsLen(s)] != 0 {
+			t.Fatalf("SDSRange(%d, %d): missing terminating \\0", tc.start, tc.end)
+		}
+	}
+
+	s := NewSDSEmpty()
+	SDSRange(s, 0, -1)
+	if sdsLen(s) != 0 {
+		t.Fatal("Expected an empty SDS to stay empty")
+	}
+}
+
+// TestSDSCase 测试 ASCII 大小写转换和忽略大小写比较
+func TestSDSCase(t *testing.T) {
+	s := NewSDSFromBytes([]byte("MiXeD cAsE 123_@[`{\x00\xc3\x89"))
+	SDSToLower(s)
+	if got := string(SdsBytes(s)); got != "mixed case 123_@[`{\x00\xc3\x89" {
+		t.Fatalf("SDSToLower: got %q", got)
+	}
+	SDSToUpper(s)
+	if got := string(SdsBytes(s)); got != "MIXED CASE 123_@[`{\x00\xc3\x89" {
+		t.Fatalf("SDSToUpper: got %q", got)
+	}
+
+	for _, tc := range []struct {
+		a, b string
+		want int
+	}{
+		{"maxmemory", "MaxMemory", 0},
+		{"", "", 0},
+		{"abc", "ABD", -1},
+		{"ABD", "abc", 1},
+		{"abc", "ABCD", -1},
+		{"abcd", "ABC", 1},
+		{"[", "{", -1}, // 只折叠字母，'[' 与 '{' 不相等
+	} {
+		if got := SDSCaseCmp(NewSDS(tc.a), NewSDS(tc.b)); got != tc.want {
+			t.Fatalf("SDSCaseCmp(%q, %q): expected %d, got %d", tc.a, tc.b, tc.want, got)
+		}
+	}
+}
+
+// TestSDSGrowthPolicy 测试预分配策略：新长度小于 1MB 时容量翻倍，达到 1MB 后多分配 1MB，
+// header 类型随容量从 sdshdr8 变为 sdshdr16、sdshdr32，长度和内容保持正确
+func TestSDSGrowthPolicy(t *testing.T) {
+	check := func(s SDS, wantLen, wantCap int, wantType SDSType) {
+		t.Helper()
+		if SDSLen(s) != wantLen || SDSCap(s) != wantCap || sdsType(s) != wantType {
+			t.Fatalf("Expected len %d cap %d type %d, got len %d cap %d type %d",
+				wantLen, wantCap, wantType, SDSLen(s), SDSCap(s), sdsType(s))
+		}
+	}
+
+	s := NewSDSEmpty()
+	check(s, 0, 0, SDS_TYPE_8)
+
+	s = SDSCatLen(s, bytes.Repeat([]byte("a"), 100), 100)
+	check(s, 100, 200, SDS_TYPE_8)
+
+	// 容量足够，不扩容
+	s = SDSCatLen(s, bytes.Repeat([]byte("b"), 100), 100)
+	check(s, 200, 200, SDS_TYPE_8)
+
+	// 新容量 402 超过 sdshdr8 的上限
+	s = SDSCat(s, "c")
+	check(s, 201, 402, SDS_TYPE_16)
+
+	s = SDSCatLen(s, bytes.Repeat([]byte("d"), 40000), 40000)
+	check(s, 40201, 80402, SDS_TYPE_32)
+
+	want := strings.Repeat("a", 100) + strings.Repeat("b", 100) + "c" + strings.Repeat("d", 40000)
+	if got := string(SdsBytes(s)); got != want {
+		t.Fatal("Content changed while growing across header types")
+	}
+
+	// 1MB 边界：新长度小于 1MB 时仍然翻倍
+	s = NewSDSFromBytes(make([]byte, SDS_MAX_PREALLOC-10))
+	check(s, SDS_MAX_PREALLOC-10, SDS_MAX_PREALLOC-10, SDS_TYPE_32)
+	s = SDSCat(s, "12345")
+	check(s, SDS_MAX_PREALLOC-5, 2*(SDS_MAX_PREALLOC-5), SDS_TYPE_32)
+
+	// 新长度正好为 1MB 时多分配 1MB
+	s = NewSDSFromBytes(make([]byte, SDS_MAX_PREALLOC-1))
+	s = SDSCat(s, "x")
+	check(s, SDS_MAX_PREALLOC, 2*SDS_MAX_PREALLOC, SDS_TYPE_32)
+
+	// 超过 1MB 后多分配 1MB
+	s = NewSDSFromBytes(make([]byte, 3*SDS_MAX_PREALLOC))
+	s = SDSCat(s, "x")
+	check(s, 3*SDS_MAX_PREALLOC+1, 4*SDS_MAX_PREALLOC+1, SDS_TYPE_32)
+	if buf := sdsBuf(s); buf[len(buf)-1] != 'x' || buf[0] != 0 {
+		t.Fatal("Content changed while growing past 1MB")
+	}
+}
+
+// BenchmarkSDSCat 测试逐字节追加（大部分追加复用预分配的空间）
+func BenchmarkSDSCat(b *testing.B) {
+	s := NewSDSEmpty()
+	for i := 0; i < b.N; i++ {
+		if sdsLen(s) >= 1<<20 {
+			s = NewSDSEmpty()
+		}
+		s = SDSCat(s, "x")
+	}
+}
@@ -3,6 +3,7 @@ package structure
 import (
 	"bytes"
 	"errors"
+	"math"
 	"math/rand"
 	"strconv"
 )
@@ -81,6 +82,7 @@ import (
 // ZSetEncoding 编码类型（使用 Encoding 别名）
 // 常量定义在 encoding.go 中
 
+// ZSET_MAX_LISTPACK_* 为默认阈值，运行时的值见 limits.go（zset-max-listpack-entries / zset-max-listpack-value）
 const (
 	ZSET_MAX_LISTPACK_ENTRIES = 128  // 超过此数量转换为 skiplist
 	ZSET_MAX_LISTPACK_VALUE   = 64   // 超过此大小转换为 skiplist
@@ -144,6 +146,11 @@ func NewZSet() *RedisZSet {
 	}
 }
 
+// Encoding 获取当前编码（listpack 或 skiplist）
+func (rz *RedisZSet) Encoding() Encoding {
+	return rz.encoding
+}
+
 // Add 添加元素到 ZSet
 func (rz *RedisZSet) Add(member []byte, score float64) error {
 	if rz.encoding == OBJ_ENCODING_LISTPACK {
@@ -160,8 +167,8 @@ func (rz *RedisZSet) addListpack(member []byte, score float64) error {
 	}
 
 	// 检查是否需要转换（listpack 中 member-score 对算作 2 个元素）
-	if rz.listpack.Length()/2 >= ZSET_MAX_LISTPACK_ENTRIES ||
-		len(member) > ZSET_MAX_LISTPACK_VALUE {
+	if int64(rz.listpack.Length()/2) >= zsetMaxListpackEntries.Load() ||
+		int64(len(member)) > zsetMaxListpackValue.Load() {
 		rz.convertToSkiplist()
 		return rz.addSkiplist(member, score)
 	}
@@ -226,16 +233,16 @@ func (rz *RedisZSet) getMemberAt(idx int) []byte {
 	p := rz.listpack.First()
 	currentIdx := 0
 
-	for p != nil && currentIdx < idx*2 {
+	for p != 0 && currentIdx < idx*2 {
 		var err error
 		p, err = rz.listpack.Next(p)
-		if err != nil || p == nil {
+		if err != nil || p == 0 {
 			return nil
 		}
 		currentIdx++
 	}
 
-	if p == nil {
+	if p == 0 {
 		return nil
 	}
 
@@ -257,16 +264,16 @@ func (rz *RedisZSet) getScoreAt(idx int) float64 {
 	p := rz.listpack.First()
 	currentIdx := 0
 
-	for p != nil && currentIdx < idx*2+1 {
+	for p != 0 && currentIdx < idx*2+1 {
 		var err error
 		p, err = rz.listpack.Next(p)
-		if err != nil || p == nil {
+		if err != nil || p == 0 {
 			return 0
 		}
 		currentIdx++
 	}
 
-	if p == nil {
+	if p == 0 {
 		return 0
 	}
 
@@ -295,7 +302,7 @@ func (rz *RedisZSet) updateScoreAt(idx int, newScore float64) {
 	currentIdx := 0
 	var currentMember []byte
 
-	for p != nil {
+	for p != 0 {
 		sval, _, _, err := rz.listpack.GetValue(p)
 		if err != nil {
 			break
@@ -323,7 +330,7 @@ func (rz *RedisZSet) updateScoreAt(idx int, newScore float64) {
 
 		var nextErr error
 		p, nextErr = rz.listpack.Next(p)
-		if nextErr != nil || p == nil {
+		if nextErr != nil || p == 0 {
 			break
 		}
 		currentIdx++
@@ -346,7 +353,7 @@ func (rz *RedisZSet) insertAtPosition(idx int, member []byte, score float64) {
 	currentIdx := 0
 	var currentMember []byte
 
-	for p != nil {
+	for p != 0 {
 		sval, _, _, err := rz.listpack.GetValue(p)
 		if err != nil {
 			break
@@ -366,7 +373,7 @@ func (rz *RedisZSet) insertAtPosition(idx int, member []byte, score float64) {
 
 		var nextErr error
 		p, nextErr = rz.listpack.Next(p)
-		if nextErr != nil || p == nil {
+		if nextErr != nil || p == 0 {
 			break
 		}
 		currentIdx++
@@ -395,8 +402,14 @@ func (rz *RedisZSet) parseScore(data []byte) float64 {
 	return score
 }
 
-// scoreToBytes 将 score 转换为字节数组
+// scoreToBytes 将 score 转换为字节数组（无穷大编码为 inf/-inf）
 func (rz *RedisZSet) scoreToBytes(score float64) []byte {
+	if math.IsInf(score, 1) {
+		return []byte("inf")
+	}
+	if math.IsInf(score, -1) {
+		return []byte("-inf")
+	}
 	return []byte(strconv.FormatFloat(score, 'f', -1, 64))
 }
 
@@ -447,7 +460,7 @@ func (rz *RedisZSet) removeListpack(member []byte) error {
 	p := rz.listpack.First()
 	idx := 0
 
-	for p != nil {
+	for p != 0 {
 		sval, _, isInt, err := rz.listpack.GetValue(p)
 		if err != nil {
 			break
@@ -461,7 +474,7 @@ func (rz *RedisZSet) removeListpack(member []byte) error {
 
 		var nextErr error
 		p, nextErr = rz.listpack.Next(p)
-		if nextErr != nil || p == nil {
+		if nextErr != nil || p == 0 {
 			break
 		}
 		idx++
@@ -478,7 +491,7 @@ func (rz *RedisZSet) removeListpack(member []byte) error {
 	idx = 0
 	var currentMember []byte
 
-	for p != nil {
+	for p != 0 {
 		sval, _, _, err := rz.listpack.GetValue(p)
 		if err != nil {
 			break
@@ -489,11 +502,13 @@ func (rz *RedisZSet) removeListpack(member []byte) error {
 			if idx/2 == memberIdx {
 				// 跳过这个 member 和它的 score
 				var nextErr error
-				p, nextErr = rz.listpack.Next(p)
-				if nextErr != nil || p == nil {
+				for skip := 0; skip < 2 && p != 0 && nextErr == nil; skip++ {
+					p, nextErr = rz.listpack.Next(p)
+				}
+				if nextErr != nil || p == 0 {
 					break
 				}
-				idx++ // 跳过 score
+				idx += 2
 				continue
 			}
 			currentMember = sval
@@ -508,7 +523,7 @@ func (rz *RedisZSet) removeListpack(member []byte) error {
 
 		var nextErr error
 		p, nextErr = rz.listpack.Next(p)
-		if nextErr != nil || p == nil {
+		if nextErr != nil || p == 0 {
 			break
 		}
 		idx++
@@ -556,7 +571,7 @@ func (rz *RedisZSet) scoreListpack(member []byte) (float64, bool) {
 	idx := 0
 	var currentMember []byte
 
-	for p != nil {
+	for p != 0 {
 		sval, _, _, err := rz.listpack.GetValue(p)
 		if err != nil {
 			break
@@ -574,11 +589,10 @@ func (rz *RedisZSet) scoreListpack(member []byte) (float64, bool) {
 
 		var nextErr error
 		p, nextErr = rz.listpack.Next(p)
-		if nextErr != nil || p == nil {
+		if nextErr != nil || p == 0 {
 			break
 		}
 		idx++
-		idx++
 	}
 
 	return 0, false
@@ -609,7 +623,7 @@ func (rz *RedisZSet) rankListpack(member []byte, reverse bool) (int, bool) {
 	idx := 0
 	rank := 0
 
-	for p != nil {
+	for p != 0 {
 		sval, _, _, err := rz.listpack.GetValue(p)
 		if err != nil {
 			break
@@ -630,7 +644,7 @@ func (rz *RedisZSet) rankListpack(member []byte, reverse bool) (int, bool) {
 
 		var nextErr error
 		p, nextErr = rz.listpack.Next(p)
-		if nextErr != nil || p == nil {
+		if nextErr != nil || p == 0 {
 			break
 		}
 		idx++
@@ -639,30 +653,22 @@ func (rz *RedisZSet) rankListpack(member []byte, reverse bool) (int, bool) {
 	return 0, false
 }
 
-// rankSkiplist 从 skiplist 获取排名
+// rankSkiplist 从 skiplist 获取排名（利用 span 累加，O(log n)）
 func (rz *RedisZSet) rankSkiplist(member []byte, reverse bool) (int, bool) {
 	score, exists := rz.dict[string(member)]
 	if !exists {
 		return 0, false
 	}
 
-	// 简化实现：遍历计算排名
-	rank := 0
-	node := rz.skiplist.header.level[0].forward
-	for node != nil {
-		if bytes.Equal(node.member, member) {
-			if reverse {
-				return int(rz.skiplist.length) - 1 - rank, true
-			}
-			return rank, true
-		}
-		if node.score < score || (node.score == score && bytes.Compare(node.member, member) < 0) {
-			rank++
-		}
-		node = node.level[0].forward
+	rank := rz.skiplist.GetRank(member, score)
+	if rank == 0 {
+		return 0, false
 	}
 
-	return 0, false
+	if reverse {
+		return int(rz.skiplist.length - rank), true
+	}
+	return int(rank) - 1, true
 }
 
 // Range 获取指定范围的元素
@@ -710,7 +716,7 @@ func (rz *RedisZSet) rangeListpack(start, end int, reverse bool) ([]ZSetEntry, e
 		idx := 0
 		var currentMember []byte
 
-		for p != nil {
+		for p != 0 {
 			sval, _, _, err := rz.listpack.GetValue(p)
 			if err != nil {
 				break
@@ -728,7 +734,7 @@ func (rz *RedisZSet) rangeListpack(start, end int, reverse bool) ([]ZSetEntry, e
 
 			var nextErr error
 			p, nextErr = rz.listpack.Next(p)
-			if nextErr != nil || p == nil {
+			if nextErr != nil || p == 0 {
 				break
 			}
 			idx++
@@ -746,7 +752,7 @@ func (rz *RedisZSet) rangeListpack(start, end int, reverse bool) ([]ZSetEntry, e
 		rank := 0
 		var currentMember []byte
 
-		for p != nil && rank <= end {
+		for p != 0 && rank <= end {
 			sval, _, _, err := rz.listpack.GetValue(p)
 			if err != nil {
 				break
@@ -767,7 +773,7 @@ func (rz *RedisZSet) rangeListpack(start, end int, reverse bool) ([]ZSetEntry, e
 
 			var nextErr error
 			p, nextErr = rz.listpack.Next(p)
-			if nextErr != nil || p == nil {
+			if nextErr != nil || p == 0 {
 				break
 			}
 			idx++
@@ -835,6 +841,99 @@ func (rz *RedisZSet) rangeSkiplist(start, end int, reverse bool) ([]ZSetEntry, e
 	return result, nil
 }
 
+// ZRangeSpec 分数区间
+type ZRangeSpec struct {
+	Min, Max       float64
+	MinInc, MaxInc bool // 是否包含端点
+}
+
+// gteMin score 是否满足下界
+func (spec *ZRangeSpec) gteMin(score float64) bool {
+	if spec.MinInc {
+		return score >= spec.Min
+	}
+	return score > spec.Min
+}
+
+// lteMax score 是否满足上界
+func (spec *ZRangeSpec) lteMax(score float64) bool {
+	if spec.MaxInc {
+		return score <= spec.Max
+	}
+	return score < spec.Max
+}
+
+// RangeByScore 获取分数区间内的元素
+// offset/count 对应 LIMIT（count < 0 表示不限制），reverse 为 true 时从高分到低分返回
+// skiplist 编码下先利用多层指针定位到区间端点，再只遍历区间内的节点，O(log n + m)
+func (rz *RedisZSet) RangeByScore(spec *ZRangeSpec, offset, count int, reverse bool) []ZSetEntry {
+	result := make([]ZSetEntry, 0)
+	if offset < 0 || count == 0 {
+		return result
+	}
+
+	if rz.encoding == OBJ_ENCODING_LISTPACK {
+		entries, _ := rz.rangeListpack(0, -1, reverse)
+		for _, entry := range entries {
+			if !spec.gteMin(entry.score) || !spec.lteMax(entry.score) {
+				continue
+			}
+			if offset > 0 {
+				offset--
+				continue
+			}
+			result = append(result, entry)
+			if count > 0 && len(result) >= count {
+				break
+			}
+		}
+		return result
+	}
+
+	var node *SkipListNode
+	if reverse {
+		node, _ = rz.skiplist.lastInRange(spec)
+	} else {
+		node, _ = rz.skiplist.firstInRange(spec)
+	}
+
+	for node != nil {
+		if reverse && !spec.gteMin(node.score) || !reverse && !spec.lteMax(node.score) {
+			break
+		}
+		if offset > 0 {
+			offset--
+		} else {
+			result = append(result, ZSetEntry{member: node.member, score: node.score})
+			if count > 0 && len(result) >= count {
+				break
+			}
+		}
+		if reverse {
+			node = node.backward
+		} else {
+			node = node.level[0].forward
+		}
+	}
+
+	return result
+}
+
+// CountByScore 统计分数区间内的元素数量
+// skiplist 编码下通过区间两端节点的排名相减得到，O(log n)
+func (rz *RedisZSet) CountByScore(spec *ZRangeSpec) int {
+	if rz.encoding == OBJ_ENCODING_LISTPACK {
+		return len(rz.RangeByScore(spec, 0, -1, false))
+	}
+
+	first, firstRank := rz.skiplist.firstInRange(spec)
+	if first == nil || !spec.lteMax(first.score) {
+		return 0
+	}
+	_, lastRank := rz.skiplist.lastInRange(spec)
+	return int(lastRank-firstRank) + 1
+}
+
 // convertToSkiplist 转换为 skiplist
 func (rz *RedisZSet) convertToSkiplist() {
 	if rz.encoding == OBJ_ENCODING_SKIPLIST {
@@ -850,7 +949,7 @@ func (rz *RedisZSet) convertToSkiplist() {
 		idx := 0
 		var currentMember []byte
 
-		for p != nil {
+		for p != 0 {
 			sval, _, _, err := rz.listpack.GetValue(p)
 			if err != nil {
 				break
@@ -868,7 +967,7 @@ func (rz *RedisZSet) convertToSkiplist() {
 
 			var nextErr error
 			p, nextErr = rz.listpack.Next(p)
-			if nextErr != nil || p == nil {
+			if nextErr != nil || p == 0 {
 				break
 			}
 			idx++
@@ -992,6 +1091,59 @@ func (sl *SkipList) Insert(member []byte, score float64) {
 	sl.length++
 }
 
+// GetRank 获取节点排名（从 1 开始，0 表示不存在）
+// 从顶层开始向下查找，沿途累加经过的 span，时间复杂度 O(log n)
+func (sl *SkipList) GetRank(member []byte, score float64) uint32 {
+	rank := uint32(0)
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil &&
+			(x.level[i].forward.score < score ||
+				(x.level[i].forward.score == score &&
+					bytes.Compare(x.level[i].forward.member, member) <= 0)) {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+
+		// x 可能是头节点，需要检查 member
+		if x != sl.header && bytes.Equal(x.member, member) {
+			return rank
+		}
+	}
+	return 0
+}
+
+// firstInRange 查找第一个满足下界的节点及其排名（从 1 开始）
+// 返回的节点不一定满足上界，调用方需要检查
+func (sl *SkipList) firstInRange(spec *ZRangeSpec) (*SkipListNode, uint32) {
+	rank := uint32(0)
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !spec.gteMin(x.level[i].forward.score) {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+	}
+	return x.level[0].forward, rank + 1
+}
+
+// lastInRange 查找最后一个满足上界的节点及其排名（从 1 开始）
+// 返回的节点不一定满足下界，调用方需要检查
+func (sl *SkipList) lastInRange(spec *ZRangeSpec) (*SkipListNode, uint32) {
+	rank := uint32(0)
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && spec.lteMax(x.level[i].forward.score) {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+	}
+	if x == sl.header {
+		return nil, 0
+	}
+	return x, rank
+}
+
 // Delete 从跳表删除节点
 func (sl *SkipList) Delete(member []byte, score float64) bool {
 	update := make([]*SkipListNode, SKIPLIST_MAXLEVEL)
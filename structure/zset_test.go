@@ -0,0 +1,151 @@
+package structure
+
+import (
+	"bytes"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+// newSkiplistZSet 创建指定元素数量的 skiplist 编码 ZSet
+func newSkiplistZSet(n int) *RedisZSet {
+	zs := NewZSet()
+	zs.convertToSkiplist()
+	for i := 0; i < n; i++ {
+		zs.Add([]byte("member:"+strconv.Itoa(i)), float64(rand.Intn(n)))
+	}
+	return zs
+}
+
+// linearRank 沿底层链表线性计算排名（用于交叉验证）
+func linearRank(zs *RedisZSet, member []byte) (int, bool) {
+	rank := 0
+	for node := zs.skiplist.header.level[0].forward; node != nil; node = node.level[0].forward {
+		if bytes.Equal(node.member, member) {
+			return rank, true
+		}
+		rank++
+	}
+	return 0, false
+}
+
+// TestSkiplistRank 测试基于 span 的排名与线性遍历结果一致
+func TestSkiplistRank(t *testing.T) {
+	zs := newSkiplistZSet(2000)
+
+	// 删除部分元素，验证删除后 span 仍然正确
+	for i := 0; i < 2000; i += 7 {
+		zs.Remove([]byte("member:" + strconv.Itoa(i)))
+	}
+
+	for i := 0; i < 500; i++ {
+		member := []byte("member:" + strconv.Itoa(rand.Intn(2000)))
+		want, wantOk := linearRank(zs, member)
+		got, ok := zs.Rank(member, false)
+		if ok != wantOk || got != want {
+			t.Fatalf("Rank(%s) = %d,%v; want %d,%v", member, got, ok, want, wantOk)
+		}
+		if !ok {
+			continue
+		}
+		rev, _ := zs.Rank(member, true)
+		if rev != zs.Card()-1-want {
+			t.Fatalf("Reverse rank(%s) = %d; want %d", member, rev, zs.Card()-1-want)
+		}
+	}
+
+	if _, ok := zs.Rank([]byte("missing"), false); ok {
+		t.Fatal("Missing member should not have a rank")
+	}
+
+	t.Log("Skiplist rank test passed")
+}
+
+// BenchmarkSkiplistRank 测试 100k 元素 ZSet 的 ZRANK 性能
+func BenchmarkSkiplistRank(b *testing.B) {
+	zs := newSkiplistZSet(100000)
+	members := make([][]byte, 1024)
+	for i := range members {
+		members[i] = []byte("member:" + strconv.Itoa(rand.Intn(100000)))
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		zs.Rank(members[i%len(members)], false)
+	}
+}
+
+// filterByScore 线性过滤分数区间（用于交叉验证）
+func filterByScore(zs *RedisZSet, spec *ZRangeSpec, offset, count int, reverse bool) []ZSetEntry {
+	all, _ := zs.Range(0, -1, reverse)
+	result := make([]ZSetEntry, 0)
+	for _, entry := range all {
+		if !spec.gteMin(entry.Score()) || !spec.lteMax(entry.Score()) {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		if count >= 0 && len(result) >= count {
+			break
+		}
+		result = append(result, entry)
+	}
+	return result
+}
+
+// TestRangeByScore 测试 skiplist 定位区间的结果与线性过滤一致
+func TestRangeByScore(t *testing.T) {
+	small := NewZSet()
+	for i := 0; i < 20; i++ {
+		small.Add([]byte("m"+strconv.Itoa(i)), float64(i%10))
+	}
+
+	for _, zs := range []*RedisZSet{small, newSkiplistZSet(2000)} {
+		for i := 0; i < 300; i++ {
+			max := zs.Card()
+			spec := &ZRangeSpec{
+				Min:    float64(rand.Intn(max)),
+				Max:    float64(rand.Intn(max)),
+				MinInc: rand.Intn(2) == 0,
+				MaxInc: rand.Intn(2) == 0,
+			}
+			if spec.Min > spec.Max {
+				spec.Min, spec.Max = spec.Max, spec.Min
+			}
+			offset, count := rand.Intn(5), rand.Intn(20)-1
+			reverse := rand.Intn(2) == 0
+
+			want := filterByScore(zs, spec, offset, count, reverse)
+			got := zs.RangeByScore(spec, offset, count, reverse)
+			if len(got) != len(want) {
+				t.Fatalf("RangeByScore(%+v, %d, %d, %v) returned %d entries; want %d",
+					*spec, offset, count, reverse, len(got), len(want))
+			}
+			for j := range got {
+				if !bytes.Equal(got[j].Member(), want[j].Member()) || got[j].Score() != want[j].Score() {
+					t.Fatalf("RangeByScore entry %d = %s; want %s", j, got[j].Member(), want[j].Member())
+				}
+			}
+
+			wantCount := len(filterByScore(zs, spec, 0, -1, false))
+			if n := zs.CountByScore(spec); n != wantCount {
+				t.Fatalf("CountByScore(%+v) = %d; want %d", *spec, n, wantCount)
+			}
+		}
+	}
+
+	t.Log("RangeByScore test passed")
+}
+
+// BenchmarkRangeByScore 测试 100k 元素 ZSet 上窄区间 ZRANGEBYSCORE 的性能
+func BenchmarkRangeByScore(b *testing.B) {
+	zs := newSkiplistZSet(100000)
+	spec := &ZRangeSpec{Min: 50000, Max: 50010, MinInc: true, MaxInc: true}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		zs.RangeByScore(spec, 0, -1, false)
+	}
+}
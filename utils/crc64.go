@@ -0,0 +1,27 @@
+package utils
+
+import "hash/crc64"
+
+/*
+ * ============================================================================
+ * CRC64（Jones 多项式）
+ * ============================================================================
+ *
+ * 与 Redis 的 crc64 一致（RDB 文件末尾的校验和）：
+ * - 多项式 0xad93d23594c935a9（反射形式 0x95ac9329ac4bc9b5）
+ * - 输入输出均反射，初始值 0，结果不取反
+ *
+ * 标准库 hash/crc64 在计算前后会对 crc 取反，结果与 Redis 不同，
+ * 这里只复用它生成的查找表。
+ * 校验值：CRC64(0, "123456789") = 0xe9c6d914c4b8d9ca
+ */
+
+var crc64JonesTable = crc64.MakeTable(0x95ac9329ac4bc9b5)
+
+// CRC64 在 crc 的基础上继续计算 data 的校验和（首次调用传入 0）
+func CRC64(crc uint64, data []byte) uint64 {
+	for _, b := range data {
+		crc = crc64JonesTable[byte(crc)^b] ^ (crc >> 8)
+	}
+	return crc
+}
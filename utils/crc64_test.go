@@ -0,0 +1,17 @@
+package utils
+
+import "testing"
+
+// TestCRC64 测试与 Redis crc64 的校验值一致，且支持分段计算
+func TestCRC64(t *testing.T) {
+	data := []byte("123456789")
+	if got := CRC64(0, data); got != 0xe9c6d914c4b8d9ca {
+		t.Fatalf("Expected 0xe9c6d914c4b8d9ca, got %#x", got)
+	}
+	if got := CRC64(CRC64(0, data[:4]), data[4:]); got != 0xe9c6d914c4b8d9ca {
+		t.Fatalf("Incremental CRC64 mismatch: %#x", got)
+	}
+	if got := CRC64(0, nil); got != 0 {
+		t.Fatalf("Expected 0 for empty input, got %#x", got)
+	}
+}
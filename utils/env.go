@@ -20,6 +20,7 @@ var (
 	envCache map[string]string
 	envMutex sync.RWMutex
 	loaded   bool
+	envPath  string // 已加载的 .env 文件路径（CONFIG REWRITE 使用）
 )
 
 func init() {
@@ -88,9 +89,57 @@ func LoadEnv(env string) error {
 	}
 
 	loaded = true
+	envPath = envFile
 	return nil
 }
 
+// LoadedEnvFile 返回已加载的 .env 文件路径，未加载文件时返回空字符串
+func LoadedEnvFile() string {
+	envMutex.RLock()
+	defer envMutex.RUnlock()
+	return envPath
+}
+
+// RewriteEnvFile 将 values 写回 .env 文件
+// 已存在的键原地更新（保留注释和其它行），不存在的键追加到文件末尾；
+// 先写临时文件再 rename，保证替换是原子的
+func RewriteEnvFile(path string, values map[string]string, order []string) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	written := make(map[string]bool)
+	lines := make([]string, 0)
+	if len(data) > 0 {
+		for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+			trimmed := strings.TrimSpace(line)
+			if trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+				parts := strings.SplitN(trimmed, "=", 2)
+				key := strings.TrimSpace(parts[0])
+				if value, ok := values[key]; ok && len(parts) == 2 {
+					line = key + "=" + value
+					written[key] = true
+				}
+			}
+			lines = append(lines, line)
+		}
+	}
+
+	for _, key := range order {
+		if value, ok := values[key]; ok && !written[key] {
+			lines = append(lines, key+"="+value)
+			written[key] = true
+		}
+	}
+
+	tmpFile := path + ".tmp"
+	if err := os.WriteFile(tmpFile, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, path)
+}
+
 // GetEnv 获取环境变量值
 // 优先从系统环境变量获取，其次从 .env 文件缓存获取
 func GetEnv(key string) string {
@@ -251,6 +300,7 @@ func Reload(env string) error {
 	envMutex.Lock()
 	envCache = make(map[string]string)
 	loaded = false
+	envPath = ""
 	envMutex.Unlock()
 
 	return LoadEnv(env)
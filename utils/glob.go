@@ -0,0 +1,124 @@
+package utils
+
+/*
+ * ============================================================================
+ * Glob 模式匹配
+ * ============================================================================
+ *
+ * 与 Redis 的 stringmatchlen 行为一致，支持：
+ * - *       匹配任意长度（包括空）的字符串
+ * - ?       匹配任意单个字符
+ * - [abc]   匹配集合中的字符
+ * - [a-z]   匹配范围内的字符
+ * - [^abc]  匹配不在集合中的字符
+ * - \x      转义，按字面匹配 x
+ */
+
+// GlobMatch 判断 str 是否匹配 glob 模式 pattern
+func GlobMatch(pattern, str string) bool {
+	skipLonger := false
+	return globMatch(pattern, str, &skipLonger, 0)
+}
+
+// globMatch 递归匹配（nesting 用于限制 * 回溯深度，避免病态模式耗尽栈）
+// skipLonger：某一层 * 已经尝试到字符串末尾仍不匹配时置为 true，
+// 此时外层的 * 再往后尝试也不可能匹配，直接失败，避免指数级回溯
+func globMatch(pattern, str string, skipLonger *bool, nesting int) bool {
+	if nesting > 1000 {
+		return false
+	}
+
+	for len(pattern) > 0 && len(str) > 0 {
+		switch pattern[0] {
+		case '*':
+			// 合并连续的 *
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for len(str) > 0 {
+				if globMatch(pattern[1:], str, skipLonger, nesting+1) {
+					return true
+				}
+				if *skipLonger {
+					return false
+				}
+				str = str[1:]
+			}
+			*skipLonger = true
+			return false
+
+		case '?':
+			str = str[1:]
+
+		case '[':
+			pattern = pattern[1:]
+			not := len(pattern) > 0 && pattern[0] == '^'
+			if not {
+				pattern = pattern[1:]
+			}
+
+			match := false
+			for len(pattern) > 0 && pattern[0] != ']' {
+				switch {
+				case pattern[0] == '\\' && len(pattern) >= 2:
+					pattern = pattern[1:]
+					if pattern[0] == str[0] {
+						match = true
+					}
+				case len(pattern) >= 3 && pattern[1] == '-':
+					start, end := pattern[0], pattern[2]
+					if start > end {
+						start, end = end, start
+					}
+					pattern = pattern[2:]
+					if str[0] >= start && str[0] <= end {
+						match = true
+					}
+				default:
+					if pattern[0] == str[0] {
+						match = true
+					}
+				}
+				pattern = pattern[1:]
+			}
+			// 缺少 ] 时按 Redis 的行为视为集合到此结束
+			if len(pattern) == 0 {
+				pattern = "]"
+			}
+
+			if not {
+				match = !match
+			}
+			if !match {
+				return false
+			}
+			str = str[1:]
+
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+
+		default:
+			if pattern[0] != str[0] {
+				return false
+			}
+			str = str[1:]
+		}
+
+		pattern = pattern[1:]
+	}
+
+	// 字符串已耗尽，剩余模式只能是 *
+	if len(str) == 0 {
+		for len(pattern) > 0 && pattern[0] == '*' {
+			pattern = pattern[1:]
+		}
+	}
+
+	return len(pattern) == 0 && len(str) == 0
+}
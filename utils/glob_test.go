@@ -0,0 +1,101 @@
+package utils
+
+import "testing"
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		str     string
+		want    bool
+	}{
+		// Redis 文档中 KEYS 的示例
+		{"h?llo", "hello", true},
+		{"h?llo", "hallo", true},
+		{"h?llo", "hxllo", true},
+		{"h?llo", "hllo", false},
+		{"h*llo", "hllo", true},
+		{"h*llo", "heeeello", true},
+		{"h[ae]llo", "hello", true},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hbllo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hallo", true},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[a-b]llo", "hcllo", false},
+
+		// *
+		{"*", "", true},
+		{"*", "anything", true},
+		{"**", "anything", true},
+		{"a*", "a", true},
+		{"a*", "ba", false},
+		{"*a", "bba", true},
+		{"*a", "ab", false},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyy", false},
+		{"user:*:name", "user:42:name", true},
+		{"user:*:name", "user:42:age", false},
+
+		// ?
+		{"?", "", false},
+		{"?", "a", true},
+		{"??", "a", false},
+		{"a?c", "abc", true},
+
+		// 字符集合与范围
+		{"[abc]", "b", true},
+		{"[abc]", "d", false},
+		{"[a-z]", "m", true},
+		{"[a-z]", "M", false},
+		{"[z-a]", "m", true}, // 反向范围
+		{"[0-9]x", "5x", true},
+		{"[a-cx-z]", "y", true},
+		{"[a-cx-z]", "m", false},
+		{"[^a-z]", "5", true},
+		{"[^a-z]", "q", false},
+		{"[^abc]", "a", false},
+		{"[^abc]", "z", true},
+
+		// 转义
+		{`\*`, "*", true},
+		{`\*`, "a", false},
+		{`\?`, "?", true},
+		{`\?`, "x", false},
+		{`a\[b`, "a[b", true},
+		{`[\]]`, "]", true},
+		{`[\-]`, "-", true},
+		{`[a\-z]`, "b", false},
+		{`\\`, `\`, true},
+
+		// 空模式
+		{"", "", true},
+		{"", "a", false},
+		{"a", "", false},
+	}
+
+	for _, tt := range tests {
+		if got := GlobMatch(tt.pattern, tt.str); got != tt.want {
+			t.Errorf("GlobMatch(%q, %q) = %v, want %v", tt.pattern, tt.str, got, tt.want)
+		}
+	}
+}
+
+func TestGlobMatchPathological(t *testing.T) {
+	// 大量 * 的模式不能导致指数级回溯或栈溢出
+	pattern := ""
+	for i := 0; i < 50; i++ {
+		pattern += "a*"
+	}
+	pattern += "b"
+
+	str := ""
+	for i := 0; i < 100; i++ {
+		str += "a"
+	}
+
+	if GlobMatch(pattern, str) {
+		t.Fatal("Pattern should not match")
+	}
+}